@@ -0,0 +1,105 @@
+package main
+
+import (
+	"coffee-and-running/src/cli"
+	"coffee-and-running/src/loadgen"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(command().Execute(ctx, os.Args[1:]))
+}
+
+func command() *cli.Command {
+	var (
+		planFile     string
+		target       string
+		rate         float64
+		concurrency  int
+		duration     time.Duration
+		timeout      time.Duration
+		statsdAddr   string
+		statsdWait   time.Duration
+		progressEach time.Duration
+	)
+	return &cli.Command{
+		Name:    "loadgen",
+		Summary: "Send a request mix to a running instance and report latency percentiles and error rates",
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&planFile, "plan", "", "YAML plan with the request mix; flags override its settings")
+			fs.StringVar(&target, "target", "", "base URL of the instance")
+			fs.Float64Var(&rate, "rate", 0, "requests per second; 0 sends as fast as concurrency allows")
+			fs.IntVar(&concurrency, "concurrency", 0, "requests in flight at most")
+			fs.DurationVar(&duration, "duration", 0, "how long to send for")
+			fs.DurationVar(&timeout, "timeout", 0, "per-request timeout")
+			fs.StringVar(&statsdAddr, "statsd-listen", "", "UDP address to receive the service's statsd metrics on, e.g. :8125, to cross-check them")
+			fs.DurationVar(&statsdWait, "statsd-wait", 2*time.Second, "how long to wait for the service to flush metrics after the run")
+			fs.DurationVar(&progressEach, "progress", 5*time.Second, "how often to print progress; 0 disables")
+		},
+		Run: func(ctx context.Context, fs *flag.FlagSet) error {
+			plan := loadgen.DefaultPlan()
+			if planFile != "" {
+				var err error
+				if plan, err = loadgen.LoadPlan(planFile); err != nil {
+					return err
+				}
+			}
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "target":
+					plan.Target = target
+				case "rate":
+					plan.Rate = rate
+				case "concurrency":
+					plan.Concurrency = concurrency
+				case "duration":
+					plan.Duration = duration
+				case "timeout":
+					plan.Timeout = timeout
+				}
+			})
+			if err := plan.Validate(); err != nil {
+				return cli.Usagef("%v", err)
+			}
+
+			var server *loadgen.ServerMetrics
+			if statsdAddr != "" {
+				var err error
+				if server, err = loadgen.ListenStatsd(statsdAddr); err != nil {
+					return err
+				}
+				defer server.Close()
+			}
+
+			client := &http.Client{Transport: &http.Transport{
+				MaxIdleConns:        plan.Concurrency,
+				MaxIdleConnsPerHost: plan.Concurrency,
+			}}
+			fmt.Fprintf(os.Stderr, "sending %d request types to %s for %s at concurrency %d\n",
+				len(plan.Requests), plan.Target, plan.Duration, plan.Concurrency)
+			if server != nil {
+				// Drop anything the service sent before the run
+				server.Reset()
+			}
+			result := loadgen.Run(ctx, plan, client, progressEach, func(r *loadgen.Result) {
+				fmt.Fprintln(os.Stderr, r.Progress())
+			})
+
+			fmt.Println()
+			result.Write(os.Stdout)
+			if server != nil {
+				time.Sleep(statsdWait)
+				server.Write(os.Stdout, result)
+			}
+			return result.Check()
+		},
+	}
+}
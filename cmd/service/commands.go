@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"coffee-and-running/src/app"
+	"coffee-and-running/src/cli"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/doctor"
+	"coffee-and-running/src/events"
+	"coffee-and-running/src/migrations"
+	"coffee-and-running/src/observability/logger"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"coffee-and-running/src/users"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+const configFileEnv = "CONFIG_FILE"
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = ""
+)
+
+// configPath is set by the -config flag shared by every command
+var configPath string
+
+func commands() *cli.Command {
+	serve := &cli.Command{
+		Name:    "serve",
+		Summary: "Run the HTTP server, background workers and schedulers",
+		Run:     runServe,
+	}
+	return &cli.Command{
+		Name:    "service",
+		Summary: "Runs the service. Without a command it serves.",
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&configPath, "config", "", "path to the config file (default $"+configFileEnv+")")
+		},
+		Run: runServe,
+		Commands: []*cli.Command{
+			serve,
+			{
+				Name:    "worker",
+				Summary: "Run background workers and schedulers without the public HTTP server",
+				Run:     runWorker,
+			},
+			migrateCommand(),
+			{
+				Name:    "routes",
+				Summary: "List the HTTP routes",
+				Run:     runRoutes,
+			},
+			{
+				Name:    "modules",
+				Summary: "List the modules in the order they start",
+				Run:     runModules,
+			},
+			{
+				Name:    "config",
+				Summary: "Inspect the configuration",
+				Commands: []*cli.Command{
+					{
+						Name:    "validate",
+						Summary: "Check the config file and exit non-zero on problems",
+						Run:     runConfigValidate,
+					},
+				},
+			},
+			eventsCommand(),
+			adminCommand(),
+			doctorCommand(),
+			{
+				Name:    "version",
+				Summary: "Print the build version",
+				Run:     runVersion,
+			},
+		},
+	}
+}
+
+// loadConfig reads the file named by -config or $CONFIG_FILE
+func loadConfig() (*config.Config, error) {
+	path := configPath
+	if path == "" {
+		path = os.Getenv(configFileEnv)
+	}
+	if path == "" {
+		return nil, cli.Usagef("config file not specified; use -config or set %s", configFileEnv)
+	}
+	return config.LoadFromFile(path)
+}
+
+func runServe(ctx context.Context, fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := buildApp(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	if c.Crash != nil {
+		defer c.Crash.Recover()
+	}
+	application, err := c.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	application.Run()
+	return nil
+}
+
+func runWorker(ctx context.Context, fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := buildApp(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	if c.Crash != nil {
+		defer c.Crash.Recover()
+	}
+	application, err := c.BuildWorker()
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	application.Run()
+	return nil
+}
+
+func migrateCommand() *cli.Command {
+	var (
+		dir     string
+		timeout time.Duration
+		yes     bool
+	)
+	flags := func(fs *flag.FlagSet) {
+		fs.StringVar(&dir, "migrations-dir", "scripts/migrations", "path to the migrations directory")
+		fs.DurationVar(&timeout, "timeout", 30*time.Second, "migration timeout")
+	}
+	run := func(action func(*migrations.Migrator, context.Context) error) func(context.Context, *flag.FlagSet) error {
+		return func(ctx context.Context, fs *flag.FlagSet) error {
+			return withMigrator(ctx, dir, timeout, action)
+		}
+	}
+
+	return &cli.Command{
+		Name:    "migrate",
+		Summary: "Apply or inspect database migrations",
+		Flags:   flags,
+		Commands: []*cli.Command{
+			{Name: "up", Summary: "Apply all pending migrations", Run: run((*migrations.Migrator).Up)},
+			{Name: "down", Summary: "Roll back the last migration", Run: run((*migrations.Migrator).Down)},
+			{Name: "status", Summary: "Show applied and pending migrations", Run: run((*migrations.Migrator).Status)},
+			{
+				Name:    "reset",
+				Summary: "Roll back every migration, dropping all data",
+				Flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&yes, "yes", false, "skip the confirmation prompt")
+				},
+				Run: func(ctx context.Context, fs *flag.FlagSet) error {
+					if !yes && !confirm("This will reset ALL migrations and drop all data. Are you sure? (y/N): ") {
+						fmt.Println("Migration reset cancelled")
+						return nil
+					}
+					return withMigrator(ctx, dir, timeout, (*migrations.Migrator).Reset)
+				},
+			},
+		},
+	}
+}
+
+// withMigrator connects to the database and runs action within timeout
+func withMigrator(ctx context.Context, dir string, timeout time.Duration, action func(*migrations.Migrator, context.Context) error) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	lgr, err := logger.NewLogger(cfg.Logger)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer lgr.Sync()
+
+	// Metrics can be a no-op for migrations
+	metricsAgent, err := metrics.NewAgent(cfg.Metrics, lgr)
+	if err != nil {
+		return fmt.Errorf("failed to create metrics agent: %w", err)
+	}
+	defer metricsAgent.Close()
+
+	engine, err := storage.NewEngine(cfg.Database, lgr, metricsAgent, storage.WithApp(cfg.App))
+	if err != nil {
+		return fmt.Errorf("failed to create database engine: %w", err)
+	}
+	defer engine.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := action(migrations.NewMigrator(engine, lgr, dir), ctx); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	return nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	return answer == "y" || answer == "Y"
+}
+
+// runModules prints the modules in build and start order; they stop in
+// reverse. Building them also reports dependency cycles and services
+// resolved without being required.
+func runModules(ctx context.Context, fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := buildApp(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	if c.Crash != nil {
+		defer c.Crash.Close()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tENABLED\tREQUIRES")
+	for _, m := range c.Modules() {
+		requires := strings.Join(m.Requires, ", ")
+		if requires == "" {
+			requires = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\n", m.Name, m.Enabled, requires)
+	}
+	return tw.Flush()
+}
+
+func runRoutes(ctx context.Context, fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := buildApp(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	if c.Crash != nil {
+		// Nothing is started, so nothing else would remove the runtime
+		// crash file
+		defer c.Crash.Close()
+	}
+	public, admin, err := c.Routers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tMETHOD\tPATTERN\tMIDDLEWARE")
+	for _, r := range []struct {
+		name   string
+		router chi.Router
+	}{{"http", public}, {"admin", admin}} {
+		if r.router == nil {
+			continue
+		}
+		routes, err := c.Middleware.Routes(r.router)
+		if err != nil {
+			return err
+		}
+		for _, route := range routes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.name, route.Method, route.Pattern, strings.Join(route.Middleware, ", "))
+		}
+	}
+	return tw.Flush()
+}
+
+func runConfigValidate(ctx context.Context, fs *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	// Checked as serve builds it, against the storage package's rules,
+	// which the config package can't import
+	if t := cfg.Tenancy; t != nil && t.Enabled {
+		if _, err := buildTenancy(t, zap.NewNop(), nil); err != nil {
+			return fmt.Errorf("invalid configuration:\n%w", err)
+		}
+	}
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+func eventsCommand() *cli.Command {
+	var (
+		from     string
+		types    string
+		consumer string
+	)
+	return &cli.Command{
+		Name:    "events",
+		Summary: "Inspect and replay the domain event log",
+		Commands: []*cli.Command{
+			{
+				Name:    "replay",
+				Summary: "Print the matching events as JSON lines, or hand them to a consumer",
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&from, "from", "", "first event: a position, or an RFC 3339 time")
+					fs.StringVar(&types, "type", "", "comma-separated event types; empty means all")
+					fs.StringVar(&consumer, "consumer", "", "registered consumer to replay into, leaving its position alone")
+				},
+				Run: func(ctx context.Context, fs *flag.FlagSet) error {
+					return withEventStore(func(store *events.Store) error {
+						filter := events.Filter{From: 1}
+						if types != "" {
+							filter.Types = strings.Split(types, ",")
+						}
+						if from != "" {
+							if position, err := strconv.ParseInt(from, 10, 64); err == nil {
+								filter.From = position
+							} else if t, err := time.Parse(time.RFC3339, from); err == nil {
+								filter.Since = t
+							} else {
+								return cli.Usagef("-from must be an event position or an RFC 3339 time, got %q", from)
+							}
+						}
+
+						if consumer != "" {
+							n, err := store.Replay(ctx, consumer, filter)
+							fmt.Fprintf(os.Stderr, "replayed %d events into %s\n", n, consumer)
+							return err
+						}
+						out := json.NewEncoder(os.Stdout)
+						_, err := store.Each(ctx, filter, func(event events.Event) error {
+							return out.Encode(event)
+						})
+						return err
+					})
+				},
+			},
+			{
+				Name:    "consumers",
+				Summary: "List the consumers, their positions and how far behind they are",
+				Run: func(ctx context.Context, fs *flag.FlagSet) error {
+					return withEventStore(func(store *events.Store) error {
+						statuses, err := store.Consumers(ctx)
+						if err != nil {
+							return err
+						}
+						tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(tw, "CONSUMER\tPOSITION\tLAG\tUPDATED\tLAST ERROR")
+						for _, st := range statuses {
+							fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", st.Name, st.Position, st.Lag, st.UpdatedAt.Format(time.RFC3339), st.LastError)
+						}
+						return tw.Flush()
+					})
+				},
+			},
+		},
+	}
+}
+
+// withEventStore builds the application, without starting it, so the
+// consumers modules register are available to action
+func withEventStore(action func(*events.Store) error) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	c, err := buildApp(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build application: %w", err)
+	}
+	if c.Crash != nil {
+		defer c.Crash.Close()
+	}
+	defer c.Stats.Close()
+	defer c.Engine.Close()
+
+	store, ok := app.Resolve[*events.Store](c)
+	if !ok {
+		return fmt.Errorf("the event log is disabled; set events.enabled")
+	}
+	return action(store)
+}
+
+func adminCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "admin",
+		Summary: "Manage the admin UI",
+		Commands: []*cli.Command{
+			{
+				Name:    "hash-password",
+				Summary: "Read a password from stdin and print its hash for admin_ui.users",
+				Run: func(ctx context.Context, fs *flag.FlagSet) error {
+					line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+					if err != nil && line == "" {
+						return fmt.Errorf("failed to read password: %w", err)
+					}
+					password := strings.TrimRight(line, "\r\n")
+					if password == "" {
+						return cli.Usagef("password must not be empty")
+					}
+					params := config.DefaultConfig().Auth.Users.Argon2
+					hash, err := users.NewHasher(params.Memory, params.Iterations, params.Parallelism).Hash(password)
+					if err != nil {
+						return err
+					}
+					fmt.Println(hash)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+func doctorCommand() *cli.Command {
+	var opts doctor.Options
+	var timeout time.Duration
+	return &cli.Command{
+		Name:    "doctor",
+		Summary: "Check the config and dependencies before a deployment and exit non-zero on failures",
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.MigrationsDir, "migrations-dir", "scripts/migrations", "path to the migrations directory")
+			fs.DurationVar(&opts.CertWarning, "cert-warning", 30*24*time.Hour, "warn about certificates expiring within this long")
+			fs.DurationVar(&timeout, "timeout", 5*time.Second, "timeout for each check")
+		},
+		Run: func(ctx context.Context, fs *flag.FlagSet) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			checks, cleanup := doctor.Checks(cfg, opts)
+			defer cleanup()
+			results, err := doctor.Run(ctx, checks, timeout, os.Stdout)
+			if errors.Is(err, doctor.ErrFailed) {
+				failed := 0
+				for _, r := range results {
+					if r.Status == doctor.Fail {
+						failed++
+					}
+				}
+				return fmt.Errorf("%d of %d checks failed", failed, len(results))
+			}
+			return err
+		},
+	}
+}
+
+func runVersion(ctx context.Context, fs *flag.FlagSet) error {
+	rev := commit
+	if info, ok := debug.ReadBuildInfo(); ok && rev == "" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				rev = s.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	fmt.Printf("version %s, commit %s\n", version, rev)
+	return nil
+}
@@ -2,48 +2,310 @@ package main
 
 import (
 	"coffee-and-running/src/app"
+	"coffee-and-running/src/blob"
+	"coffee-and-running/src/botdetect"
+	"coffee-and-running/src/clients/httpclient"
 	"coffee-and-running/src/config"
-	"coffee-and-running/src/observability/logger"
+	"coffee-and-running/src/crypto"
+	"coffee-and-running/src/featureflags"
+	"coffee-and-running/src/i18n"
+	"coffee-and-running/src/mailer"
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/messaging/kafka"
+	"coffee-and-running/src/messaging/nats"
+	"coffee-and-running/src/notifications"
 	"coffee-and-running/src/observability/metrics"
-	"coffee-and-running/src/server"
+	"coffee-and-running/src/operations"
+	"coffee-and-running/src/render"
+	"coffee-and-running/src/reports"
+	"coffee-and-running/src/search"
 	"coffee-and-running/src/storage"
+	"coffee-and-running/src/tenancy"
+	"context"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
-)
 
-const configFile = "CONFIG_FILE"
+	"go.uber.org/zap"
+)
 
 func main() {
-	fPath, ok := os.LookupEnv(configFile)
-	if !ok {
-		log.Fatalf("please set %s env var", configFile)
+	ctx := context.Background()
+	os.Exit(commands().Execute(ctx, os.Args[1:]))
+}
+
+// buildApp refuses a configuration that fails validation, so the rules
+// `config validate` checks hold for every command that runs the service
+func buildApp(cfg *config.Config) (*app.Container, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
-	cfg, err := config.LoadFromFile(fPath)
+	c, err := app.NewContainer(cfg)
 	if err != nil {
-		log.Fatalf("failed to read config file: %s", err.Error())
+		return nil, err
+	}
+	// Applications add their own modules to the list in modules.go
+	if err := c.Install(modules()...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// buildFlags returns a feature flag client for the configured provider
+func buildFlags(cfg *config.FlagsConfig, engine storage.Engine, lgr *zap.Logger, stats metrics.Agent) (*featureflags.Client, error) {
+	var provider featureflags.Provider
+	switch cfg.Provider {
+	case "", "database":
+		provider = featureflags.NewDatabaseProvider(engine)
+	case "file":
+		provider = featureflags.NewFileProvider(cfg.File)
+	default:
+		return nil, fmt.Errorf("unsupported feature flag provider %q", cfg.Provider)
+	}
+	return featureflags.NewClient(provider, cfg.RefreshInterval, lgr, stats)
+}
+
+// buildMailer returns a mailer for the configured provider
+func buildMailer(cfg *config.EmailConfig, engine storage.Engine, clients *httpclient.Factory, lgr *zap.Logger, stats metrics.Agent) (*mailer.Mailer, error) {
+	var templateFS fs.FS = mailer.DefaultTemplates
+	if cfg.TemplatesDir != "" {
+		templateFS = os.DirFS(cfg.TemplatesDir)
+	}
+	templates, err := mailer.NewTemplates(templateFS)
+	if err != nil {
+		return nil, err
+	}
+
+	var provider mailer.Provider
+	switch cfg.Provider {
+	case "", "smtp":
+		provider, err = mailer.NewSMTPProvider(cfg.SMTP)
+	case "ses":
+		provider, err = mailer.NewSESProvider(context.Background(), cfg.SES)
+	case "sendgrid":
+		provider, err = mailer.NewSendGridProvider(cfg.SendGrid, clients.Client("sendgrid"))
+	default:
+		err = fmt.Errorf("unsupported email provider %q", cfg.Provider)
 	}
-	app, err := buildApp(cfg)
 	if err != nil {
-		log.Fatalf("failed to build application: %s", err.Error())
+		return nil, err
 	}
-	app.Run()
+
+	return mailer.New(cfg, provider, templates, engine, lgr, stats), nil
 }
 
-func buildApp(cfg *config.Config) (app.Application, error) {
-	lgr, err := logger.NewLogger(cfg.Logger)
+// buildNotifier returns a notifier with the enabled channels
+func buildNotifier(cfg *config.NotifyConfig, mail *mailer.Mailer, engine storage.Engine, clients *httpclient.Factory, lgr *zap.Logger, stats metrics.Agent) (*notifications.Notifier, error) {
+	var templateFS fs.FS = notifications.DefaultTemplates
+	if cfg.TemplatesDir != "" {
+		templateFS = os.DirFS(cfg.TemplatesDir)
+	}
+	templates, err := notifications.NewTemplates(templateFS)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build app logger: %w", err)
+		return nil, err
 	}
-	metricsAgent, err := metrics.NewAgent(cfg.Metrics, lgr)
+
+	var channels []notifications.Channel
+	if cfg.Email != nil && cfg.Email.Enabled {
+		if mail == nil {
+			return nil, fmt.Errorf("email notifications require the mailer")
+		}
+		channels = append(channels, notifications.NewEmail(mail))
+	}
+	if cfg.SMS != nil && cfg.SMS.Enabled {
+		sms, err := notifications.NewTwilio(cfg.SMS, clients.Client("twilio"))
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, sms)
+	}
+	if cfg.Slack != nil && cfg.Slack.Enabled {
+		slack, err := notifications.NewSlack(cfg.Slack, clients.Client("slack"))
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, slack)
+	}
+	if cfg.Push != nil && cfg.Push.Enabled {
+		push, err := notifications.NewFCM(context.Background(), cfg.Push, clients.Client("fcm"))
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, push)
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("notifications need at least one enabled channel")
+	}
+	return notifications.New(cfg, channels, templates, engine, lgr, stats), nil
+}
+
+// buildBlob returns an object store for the configured driver
+func buildBlob(cfg *config.BlobConfig, lgr *zap.Logger) (blob.Store, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return blob.NewLocal(cfg.Local.Dir, cfg.Local.BaseURL, cfg.Local.SigningKey, lgr)
+	case "s3":
+		if cfg.Bucket == "" {
+			return nil, fmt.Errorf("blob bucket is required for the s3 driver")
+		}
+		return blob.NewS3(context.Background(), cfg.S3, cfg.Bucket, cfg.Prefix)
+	case "gcs":
+		if cfg.Bucket == "" {
+			return nil, fmt.Errorf("blob bucket is required for the gcs driver")
+		}
+		return blob.NewGCS(context.Background(), cfg.Bucket, cfg.Prefix, cfg.GCS.CredentialsFile)
+	default:
+		return nil, fmt.Errorf("unsupported blob driver %q", cfg.Driver)
+	}
+}
+
+// buildBotGuard returns a bot detection guard with the enabled built-in
+// detectors, cheapest first
+func buildBotGuard(cfg *config.BotsConfig, clients *httpclient.Factory, lgr *zap.Logger, stats metrics.Agent) (*botdetect.Guard, error) {
+	guard := botdetect.NewGuard(cfg, lgr, stats)
+	if cfg.UserAgent != nil && cfg.UserAgent.Enabled {
+		detector, err := botdetect.NewUserAgentDetector(cfg.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		guard.Register("user_agent", detector)
+	}
+	if cfg.Rate != nil && cfg.Rate.Enabled {
+		guard.Register("rate", botdetect.NewRateDetector(cfg.Rate))
+	}
+	if cfg.External != nil && cfg.External.Enabled {
+		guard.Register("external", botdetect.NewExternalDetector(cfg.External, clients.Client("bot_detection")))
+	}
+	return guard, nil
+}
+
+// buildI18n returns a bundle loaded from the configured catalogs
+func buildI18n(cfg *config.I18nConfig) (*i18n.Bundle, error) {
+	bundle, err := i18n.NewBundle(cfg.DefaultLocale)
 	if err != nil {
-		return nil, fmt.Errorf("failed to buuld app metrics agent: %w", err)
+		return nil, err
+	}
+
+	catalogs := i18n.DefaultLocales()
+	if cfg.Dir != "" {
+		catalogs = os.DirFS(cfg.Dir)
+	}
+	if err := bundle.LoadFS(catalogs, "."); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// buildRenderer returns the HTML renderer and, when enabled, the CSRF
+// middleware
+func buildRenderer(cfg *config.RenderConfig, lgr *zap.Logger) (*render.Renderer, *render.CSRF, error) {
+	templates := render.DefaultTemplates()
+	if cfg.Dir != "" {
+		templates = os.DirFS(cfg.Dir)
+	}
+
+	var flashes *render.Flashes
+	if cfg.FlashSecret != "" {
+		flashes = render.NewFlashes("flash", cfg.FlashSecret, cfg.CSRF == nil || cfg.CSRF.Secure)
+	}
+	views := render.NewRenderer(templates, cfg.Layout, cfg.Reload, flashes, lgr)
+
+	if cfg.CSRF == nil || !cfg.CSRF.Enabled {
+		return views, nil, nil
+	}
+	csrf := render.NewCSRF(cfg.CSRF.CookieName, cfg.CSRF.HeaderName, cfg.CSRF.FieldName, cfg.CSRF.Secure, cfg.CSRF.ExemptPaths)
+	return views, csrf, nil
+}
+
+// buildReports returns a report generator over the configured templates
+// and PDF converter
+func buildReports(cfg *config.ReportsConfig, store blob.Store, ops *operations.Manager, lgr *zap.Logger, stats metrics.Agent) (*reports.Generator, error) {
+	templates := reports.DefaultTemplates()
+	if cfg.Dir != "" {
+		templates = os.DirFS(cfg.Dir)
+	}
+	converter, err := reports.NewConverter(cfg.Converter, cfg.Binary, cfg.Args)
+	if err != nil {
+		return nil, err
+	}
+	views := render.NewRenderer(templates, cfg.Layout, cfg.Reload, nil, lgr)
+	return reports.New(cfg, views, converter, store, ops, lgr, stats), nil
+}
+
+// buildEncryptor returns a field encryptor for the configured key source
+func buildEncryptor(cfg *config.EncryptionConfig, stats metrics.Agent) (*crypto.Encryptor, error) {
+	var keys map[string][]byte
+	var err error
+	switch cfg.Source {
+	case "", "env":
+		keys, err = crypto.LoadEnvKeys(cfg.EnvPrefix)
+	case "file":
+		keys, err = crypto.LoadFileKeys(cfg.File)
+	case "kms":
+		if cfg.KMS.KeyID == "" {
+			return nil, fmt.Errorf("encryption kms key_id is required")
+		}
+		wrapper, err := crypto.NewKMS(context.Background(), cfg.KMS.Region, cfg.KMS.KeyID, cfg.KMS.DataKeyTTL, cfg.KMS.CacheSize, stats)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.NewEncryptor(wrapper), nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption key source %q", cfg.Source)
 	}
-	engine, err := storage.NewEngine(cfg.Database, lgr, metricsAgent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build app storage engine: %w", err)
+		return nil, err
 	}
-	srv := server.New(cfg.Server)
 
-	return app.New(cfg, lgr, metricsAgent, engine, srv), nil
+	keyring, err := crypto.NewKeyring(keys, cfg.PrimaryKey)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewEncryptor(keyring), nil
+}
+
+// buildTenancy returns the tenant resolution middleware
+func buildTenancy(cfg *config.TenancyConfig, lgr *zap.Logger, stats metrics.Agent) (*tenancy.Middleware, error) {
+	if _, err := tenancy.NewScoper(cfg); err != nil {
+		return nil, err
+	}
+	resolvers, err := tenancy.Resolvers(cfg.Sources, cfg.Header, cfg.BaseDomain, cfg.Claim)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Metrics {
+		stats = nil
+	}
+	return tenancy.NewMiddleware(resolvers, cfg.Required, cfg.SessionVariable, lgr, stats)
+}
+
+// buildSearch returns a search index for the configured driver
+func buildSearch(cfg *config.SearchConfig, engine storage.Engine, clients *httpclient.Factory) (search.Index, error) {
+	switch cfg.Driver {
+	case "", "postgres":
+		return search.NewPostgres(engine, cfg.Language), nil
+	case "elasticsearch", "opensearch":
+		return search.NewElasticsearch(cfg.Elasticsearch, clients.Client("search"))
+	default:
+		return nil, fmt.Errorf("unsupported search driver %q", cfg.Driver)
+	}
+}
+
+// buildBroker returns the configured message broker, or nil if messaging
+// is disabled
+func buildBroker(cfg *config.MessagingConfig, lgr *zap.Logger, stats metrics.Agent) (messaging.Broker, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	switch cfg.Driver {
+	case "":
+		return nil, nil
+	case "kafka":
+		return kafka.New(cfg.Kafka, lgr, stats)
+	case "nats":
+		return nats.New(cfg.NATS, lgr, stats)
+	default:
+		return nil, fmt.Errorf("unsupported messaging driver %q", cfg.Driver)
+	}
 }
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"coffee-and-running/src/admin"
+	"coffee-and-running/src/app"
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/auth/revocation"
+	"coffee-and-running/src/blob"
+	"coffee-and-running/src/cache"
+	"coffee-and-running/src/cache/redis"
+	"coffee-and-running/src/coalesce"
+	"coffee-and-running/src/concurrency"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/deprecation"
+	"coffee-and-running/src/events"
+	"coffee-and-running/src/featureflags"
+	"coffee-and-running/src/geoip"
+	"coffee-and-running/src/graph"
+	"coffee-and-running/src/graph/generated"
+	"coffee-and-running/src/graphql"
+	"coffee-and-running/src/i18n"
+	"coffee-and-running/src/imports"
+	"coffee-and-running/src/ipfilter"
+	"coffee-and-running/src/loadshed"
+	"coffee-and-running/src/locks"
+	"coffee-and-running/src/mailer"
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/openapi"
+	"coffee-and-running/src/operations"
+	"coffee-and-running/src/outbox"
+	"coffee-and-running/src/querystats"
+	"coffee-and-running/src/ratelimit"
+	"coffee-and-running/src/scheduler"
+	"coffee-and-running/src/search"
+	"coffee-and-running/src/server"
+	"coffee-and-running/src/storage"
+	"coffee-and-running/src/users"
+	"coffee-and-running/src/webhooks"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// modules lists the service's subsystems. Each declares the modules it
+// depends on in Requires, which fixes the order they are built, started
+// and stopped in; `service modules` prints it. Middleware inserted after
+// an entry lands closer to it the later its module is built, so the
+// order below also fixes the order of the auth, csrf, tenancy,
+// feature_flags, i18n and rate_limit middleware. They follow cors, so
+// preflights are answered before them and their refusals carry CORS
+// headers, and timeout bounds their lookups. Middleware that guards
+// the public API, such as auth and rate_limit, is inserted with
+// InsertPublic, so the admin routes don't run it.
+func modules() []app.Module {
+	return []app.Module{
+		{
+			Name:    "routes",
+			Enabled: func(cfg *config.Config) bool { return len(cfg.Routes) > 0 },
+			Build: func(c *app.Container) error {
+				// Mounted first, so a route registered in code for the
+				// same pattern and method takes precedence
+				mount, err := server.DeclaredRoutes(c.Config.Routes, c.Clients, c.Logger)
+				if err != nil {
+					return err
+				}
+				c.Mount(mount)
+				return nil
+			},
+		},
+		{
+			Name:     "admin_ui",
+			Requires: []string{"database"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.AdminUI != nil && cfg.AdminUI.Enabled },
+			Build: func(c *app.Container) error {
+				// Modules requiring this one expose their tables with
+				// admin.Register; columns are read once the database is
+				// reachable
+				tables := admin.New(c.Config.AdminUI, c.Engine, c.Logger, c.Stats)
+				c.MountAdmin(admin.NewHandler(tables, c.Logger).Mount)
+				c.Append(app.Hook{Name: "admin_ui", OnStart: tables.Load})
+				app.Provide(c, tables)
+				return nil
+			},
+		},
+		{
+			Name:    "redis",
+			Enabled: func(cfg *config.Config) bool { return cfg.Redis != nil && cfg.Redis.Enabled },
+			Build: func(c *app.Container) error {
+				client, err := redis.New(c.Config.Redis, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Checks.Register("redis", client.Ping)
+				c.Append(app.ComponentHook("redis", app.Closer(client)).InPhase(app.StopClose))
+				app.Provide(c, client)
+				return nil
+			},
+		},
+		{
+			Name:     "cache",
+			Requires: []string{"redis"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Cache != nil && cfg.Cache.Enabled },
+			Build: func(c *app.Container) error {
+				// Modules create their caches with cache.New[T](caches,
+				// name) and hand them to their handlers
+				client, _ := app.Resolve[*redis.Client](c)
+				caches, err := cache.NewFactory(c.Config.Cache, client, c.Stats)
+				if err != nil {
+					return err
+				}
+				app.Provide(c, caches)
+				return nil
+			},
+		},
+		{
+			Name:    "encryption",
+			Enabled: func(cfg *config.Config) bool { return cfg.Encryption != nil && cfg.Encryption.Enabled },
+			Build: func(c *app.Container) error {
+				// Repositories encrypt columns with encryptor.Value and
+				// encryptor.Scan
+				encryptor, err := buildEncryptor(c.Config.Encryption, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Checks.Register("encryption", encryptor.Check)
+				app.Provide(c, encryptor)
+				return nil
+			},
+		},
+		{
+			Name:    "worker_pool",
+			Enabled: func(cfg *config.Config) bool { return cfg.WorkerPool != nil && cfg.WorkerPool.Enabled },
+			Build: func(c *app.Container) error {
+				// Handlers and modules run background work with
+				// pool.Submit instead of spawning goroutines; it drains
+				// on shutdown before the database closes
+				cfg := c.Config.WorkerPool
+				pool, err := concurrency.NewPool("shared", cfg.Workers, cfg.QueueSize, concurrency.Policy(cfg.Policy), c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Append(app.ComponentHook("worker_pool", pool).InPhase(app.StopFlush))
+				app.Provide(c, pool)
+				return nil
+			},
+		},
+		{
+			Name:    "mail",
+			Enabled: func(cfg *config.Config) bool { return cfg.Email != nil && cfg.Email.Enabled },
+			Build: func(c *app.Container) error {
+				// Handlers send mail with mail.Send or mail.SendTemplate
+				mail, err := buildMailer(c.Config.Email, c.Engine, c.Clients, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Component("mail", mail)
+				app.Provide(c, mail)
+				return nil
+			},
+		},
+		{
+			Name:     "notifications",
+			Requires: []string{"mail"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Notify != nil && cfg.Notify.Enabled },
+			Build: func(c *app.Container) error {
+				// Handlers notify with notifier.Notify or notifier.Send
+				mail, _ := app.Resolve[*mailer.Mailer](c)
+				notifier, err := buildNotifier(c.Config.Notify, mail, c.Engine, c.Clients, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Component("notifications", notifier)
+				app.Provide(c, notifier)
+				return nil
+			},
+		},
+		{
+			Name:    "rate_limit",
+			Enabled: func(cfg *config.Config) bool { return cfg.RateLimit != nil && cfg.RateLimit.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted before the other modules' middleware so it ends
+				// up after auth and tenancy, and can limit by subject and
+				// tenant
+				var provider ratelimit.Provider
+				if c.Config.RateLimit.Policies {
+					provider = ratelimit.NewDatabaseProvider(c.Engine)
+				}
+				limiter, err := ratelimit.NewLimiter(c.Config.RateLimit, provider, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Middleware.InsertPublic("rate_limit", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return limiter.Handler, nil
+				}, server.After("cors"))
+				c.MountAdmin(ratelimit.NewHandler(limiter, c.Logger).Mount)
+				c.Component("rate_limit", limiter)
+				return nil
+			},
+		},
+		{
+			Name:    "i18n",
+			Enabled: func(cfg *config.Config) bool { return cfg.I18n != nil && cfg.I18n.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted first so it ends up after auth and can read the
+				// user's saved locale. Handlers translate with
+				// i18n.T(ctx, key, args).
+				bundle, err := buildI18n(c.Config.I18n)
+				if err != nil {
+					return err
+				}
+				sources := []i18n.LocaleFunc{i18n.QueryLocale(c.Config.I18n.QueryParam), i18n.CookieLocale(c.Config.I18n.Cookie), i18n.UserLocale}
+				c.Middleware.Insert("i18n", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return i18n.Middleware(bundle, sources...), nil
+				}, server.After("cors"))
+				app.Provide(c, bundle)
+				return nil
+			},
+		},
+		{
+			Name:    "feature_flags",
+			Enabled: func(cfg *config.Config) bool { return cfg.Flags != nil && cfg.Flags.Enabled },
+			Build: func(c *app.Container) error {
+				flags, err := buildFlags(c.Config.Flags, c.Engine, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Middleware.Insert("feature_flags", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return featureflags.Middleware(flags, nil, featureflags.ExperimentOptions{
+						Header:  c.Config.Flags.ExperimentHeader,
+						Metrics: c.Config.Flags.ExperimentMetrics,
+					}), nil
+				}, server.After("cors"))
+				c.MountAdmin(featureflags.NewHandler(flags, nil, c.Logger).Mount)
+				c.Component("feature_flags", flags)
+				app.Provide(c, flags)
+				return nil
+			},
+		},
+		{
+			Name:    "blob",
+			Enabled: func(cfg *config.Config) bool { return cfg.Blob != nil && cfg.Blob.Enabled },
+			Build: func(c *app.Container) error {
+				store, err := buildBlob(c.Config.Blob, c.Logger)
+				if err != nil {
+					return err
+				}
+				if local, ok := store.(*blob.Local); ok {
+					c.Mount(local.Mount)
+				}
+				if closer, ok := store.(io.Closer); ok {
+					c.Append(app.ComponentHook("blob", app.Closer(closer)).InPhase(app.StopClose))
+				}
+				// Handlers store files with store.Put and hand out
+				// store.SignedURL
+				app.Provide(c, blob.WithMetrics(store, c.Config.Blob.Driver, c.Stats))
+				return nil
+			},
+		},
+		{
+			Name:    "tenancy",
+			Enabled: func(cfg *config.Config) bool { return cfg.Tenancy != nil && cfg.Tenancy.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted after i18n and feature_flags so it sits closer
+				// to cors, ahead of anything that reads the
+				// tenant. Repositories scope queries with
+				// tenancy.NewScoper(cfg.Tenancy).
+				tenants, err := buildTenancy(c.Config.Tenancy, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Middleware.InsertPublic("tenancy", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return tenants.Handler, nil
+				}, server.After("cors"))
+				return nil
+			},
+		},
+		{
+			Name:    "render",
+			Enabled: func(cfg *config.Config) bool { return cfg.Render != nil && cfg.Render.Enabled },
+			Build: func(c *app.Container) error {
+				views, csrf, err := buildRenderer(c.Config.Render, c.Logger)
+				if err != nil {
+					return err
+				}
+				if csrf != nil {
+					c.Middleware.InsertPublic("csrf", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+						return csrf.Handler, nil
+					}, server.After("cors"))
+				}
+				// Handlers render pages with views.HTML(w, r, status, "page", data)
+				c.Mount(func(r chi.Router) {
+					r.Get("/", views.Page("home"))
+				})
+				app.Provide(c, views)
+				return nil
+			},
+		},
+		{
+			Name:     "auth",
+			Requires: []string{"mail", "redis"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Auth != nil && cfg.Auth.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted after tenancy so it runs first and the claim
+				// tenant source sees the caller's claims. Protect routes
+				// with auth.Require.
+				jwt := c.Config.Auth.JWT
+				tokens, err := auth.NewJWT(jwt.Secret, jwt.Issuer, jwt.Audience, jwt.TTL)
+				if err != nil {
+					return err
+				}
+				// The list is nil unless auth.revocation is enabled;
+				// validation ensures Redis is then available
+				var revocations auth.RevocationList
+				var revoker users.Revoker
+				if r := c.Config.Auth.Revocation; r != nil && r.Enabled {
+					client, _ := app.Resolve[*redis.Client](c)
+					list := revocation.New(r, jwt.TTL, client, c.Logger, c.Stats)
+					c.Component("token_revocation", list)
+					app.Provide(c, list)
+					revocations, revoker = list, list
+				}
+				c.Middleware.InsertPublic("auth", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return auth.Middleware(tokens, revocations), nil
+				}, server.After("cors"))
+				app.Provide(c, tokens)
+
+				if c.Config.Auth.Users == nil || !c.Config.Auth.Users.Enabled {
+					return nil
+				}
+				var sender users.Sender
+				if mail, ok := app.Resolve[*mailer.Mailer](c); ok {
+					sender = mail
+				}
+				accounts, err := users.NewService(c.Config.Auth.Users, users.NewRepository(c.Engine), tokens, revoker, sender, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Mount(users.NewHandler(accounts, c.Logger).Mount)
+				app.Provide(c, accounts)
+				return nil
+			},
+		},
+		{
+			Name:    "messaging",
+			Enabled: func(cfg *config.Config) bool { return cfg.Messaging != nil && cfg.Messaging.Driver != "" },
+			Build: func(c *app.Container) error {
+				// Applications register subscriptions in their own
+				// modules, e.g. broker.Subscribe("orders.created",
+				// handleOrderCreated); the broker starts once every module
+				// is built
+				broker, err := buildBroker(c.Config.Messaging, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Append(app.ComponentHook("messaging", broker).InPhase(app.StopFlush))
+				app.Provide(c, broker)
+				return nil
+			},
+		},
+		{
+			Name:     "search",
+			Requires: []string{"messaging"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Search != nil && cfg.Search.Enabled },
+			Build: func(c *app.Container) error {
+				index, err := buildSearch(c.Config.Search, c.Engine, c.Clients)
+				if err != nil {
+					return err
+				}
+				if es, ok := index.(*search.Elasticsearch); ok {
+					c.Checks.Register("search", es.Ping)
+				}
+				// Handlers query with index.Search
+				index = search.WithMetrics(index, c.Config.Search.Driver, c.Stats)
+				app.Provide(c, index)
+
+				// Applications map outbox events to documents here, e.g.
+				// indexer.Register("post", "posts", postDocument)
+				indexer := search.NewIndexer(index, c.Logger, c.Stats)
+				app.Provide(c, indexer)
+				if broker, ok := app.Resolve[messaging.Broker](c); ok {
+					return indexer.Subscribe(broker)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "graphql",
+			Requires: []string{"auth"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.GraphQL != nil && cfg.GraphQL.Enabled },
+			Build: func(c *app.Container) error {
+				// Resolvers in src/graph reach services through
+				// graph.Resolver; `me` is null unless auth.users is
+				// enabled
+				accounts, _ := app.Resolve[*users.Service](c)
+				schema := generated.NewExecutableSchema(generated.Config{
+					Resolvers: &graph.Resolver{App: c.Config.App, Users: accounts},
+				})
+				gql := graphql.New(c.Config.GraphQL, c.Config.App, schema, c.Engine, nil, c.Logger, c.Stats, c.Tracer)
+				c.Mount(gql.Mount)
+				return nil
+			},
+		},
+		{
+			Name:     "webhooks",
+			Requires: []string{"database", "auth", "tenancy"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Webhooks != nil && cfg.Webhooks.Enabled },
+			Build: func(c *app.Container) error {
+				// The API manages each caller's own tenant's endpoints,
+				// so it needs both an authenticated caller and a tenant
+				if c.Config.Auth == nil || !c.Config.Auth.Enabled || c.Config.Tenancy == nil || !c.Config.Tenancy.Enabled {
+					return fmt.Errorf("webhooks requires auth.enabled and tenancy.enabled")
+				}
+				// Business code enqueues events with service.EnqueueTx
+				service := webhooks.NewService(c.Config.Webhooks, c.Engine, c.Logger)
+				c.Mount(webhooks.NewHandler(service, nil, c.Logger).Mount)
+				app.Provide(c, service)
+
+				dispatcher := webhooks.NewDispatcher(c.Config.Webhooks, c.Engine, c.Clients.PublicClient("webhooks"), c.Logger, c.Stats)
+				c.Append(app.Hook{
+					Name:    "webhooks",
+					OnReady: func(context.Context) error { dispatcher.Start(); return nil },
+					OnStop:  func(context.Context) error { dispatcher.Close(); return nil },
+				})
+				return nil
+			},
+		},
+		{
+			Name:     "outbox",
+			Requires: []string{"database", "messaging"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Outbox != nil && cfg.Outbox.Enabled },
+			Build: func(c *app.Container) error {
+				broker, ok := app.Resolve[messaging.Broker](c)
+				if !ok {
+					return fmt.Errorf("outbox relay requires a messaging driver")
+				}
+				relay := outbox.NewRelay(c.Config.Outbox, c.Engine, broker, c.Logger, c.Stats)
+				c.Append(app.Hook{
+					Name:    "outbox",
+					OnReady: func(context.Context) error { relay.Start(); return nil },
+					OnStop:  func(context.Context) error { relay.Close(); return nil },
+					Phase:   app.StopFlush,
+				})
+				return nil
+			},
+		},
+		{
+			Name:     "events",
+			Requires: []string{"database"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Events != nil && cfg.Events.Enabled },
+			Build: func(c *app.Container) error {
+				// Modules requiring this one append with store.Append
+				// and register consumers with store.Register; consumers
+				// start once the server is listening
+				store := events.NewStore(c.Config.Events, c.Engine, c.Logger, c.Stats)
+				app.Provide(c, store)
+				c.Append(app.Hook{
+					Name:    "event_consumers",
+					OnReady: func(context.Context) error { store.Start(); return nil },
+					OnStop:  func(context.Context) error { store.Close(); return nil },
+				})
+				return nil
+			},
+		},
+		{
+			Name:     "scheduler",
+			Requires: []string{"database", "redis"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Scheduler != nil && cfg.Scheduler.Enabled },
+			Build: func(c *app.Container) error {
+				// Prefer Redlock when Redis is configured; a nil locker
+				// falls back to Postgres advisory locks
+				var locker locks.Locker
+				if client, ok := app.Resolve[*redis.Client](c); ok {
+					redlock, err := locks.NewRedis(c.Logger, client)
+					if err != nil {
+						return err
+					}
+					locker = redlock
+				}
+				sched, err := scheduler.New(c.Config.Scheduler, c.Engine, locker, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.MountAdmin(sched.Mount)
+				// Modules requiring this one register tasks with
+				// sched.Register; they start once the server is listening
+				app.Provide(c, sched)
+				c.Append(app.Hook{
+					Name:    "scheduler",
+					OnReady: func(context.Context) error { sched.Start(); return nil },
+					OnStop:  func(context.Context) error { sched.Close(); return nil },
+				})
+				return nil
+			},
+		},
+		{
+			Name:    "openapi",
+			Enabled: func(cfg *config.Config) bool { return cfg.OpenAPI != nil && cfg.OpenAPI.Enabled },
+			Build: func(c *app.Container) error {
+				cfg := c.Config.OpenAPI
+				doc, err := openapi.Load(cfg.Spec)
+				if err != nil {
+					return err
+				}
+				var opts []openapi.Option
+				if cfg.ValidateRequests {
+					opts = append(opts, openapi.ValidateRequests())
+				}
+				if cfg.ValidateResponses {
+					// Buffering and checking every response is for
+					// development
+					if c.Config.App != nil && c.Config.App.IsProduction() {
+						return fmt.Errorf("openapi.validate_responses is refused in production")
+					}
+					opts = append(opts, openapi.ValidateResponses())
+				}
+				if cfg.MessagePrefix != "" {
+					opts = append(opts, openapi.MessagePrefix(cfg.MessagePrefix))
+				}
+				validator := openapi.NewValidator(doc, c.Logger, c.Stats, opts...)
+				if cfg.Path != "" {
+					c.Mount(func(r chi.Router) {
+						r.Get(cfg.Path, validator.ServeDocument)
+					})
+				}
+				// Last, so requests are authenticated and scoped to their
+				// tenant before their payloads are checked
+				c.Middleware.InsertPublic("openapi", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return validator.Middleware, nil
+				}, server.Last())
+				return nil
+			},
+		},
+		{
+			Name:     "operations",
+			Requires: []string{"database", "worker_pool"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Operations != nil && cfg.Operations.Enabled },
+			Build: func(c *app.Container) error {
+				// Handlers start slow work with ops.Enqueue and respond
+				// with ops.WriteAccepted
+				pool, ok := app.Resolve[*concurrency.Pool](c)
+				if !ok {
+					return fmt.Errorf("operations require the worker pool")
+				}
+				ops := operations.NewManager(c.Config.Operations, c.Engine, pool, c.Logger, c.Stats)
+				c.Mount(ops.Mount)
+				c.Component("operations", ops)
+				app.Provide(c, ops)
+				return nil
+			},
+		},
+		{
+			Name:     "imports",
+			Requires: []string{"operations"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Imports != nil && cfg.Imports.Enabled },
+			Build: func(c *app.Container) error {
+				// Routes accept uploads with importer.Handler(schema)
+				ops, ok := app.Resolve[*operations.Manager](c)
+				if !ok {
+					return fmt.Errorf("imports require operations")
+				}
+				app.Provide(c, imports.New(c.Config.Imports, c.Engine, ops, c.Logger, c.Stats))
+				return nil
+			},
+		},
+		{
+			Name:     "reports",
+			Requires: []string{"operations", "blob"},
+			Enabled:  func(cfg *config.Config) bool { return cfg.Reports != nil && cfg.Reports.Enabled },
+			Build: func(c *app.Container) error {
+				// Handlers start reports with generator.Enqueue and
+				// respond with ops.WriteAccepted
+				ops, ok := app.Resolve[*operations.Manager](c)
+				if !ok {
+					return fmt.Errorf("reports require operations")
+				}
+				store, ok := app.Resolve[blob.Store](c)
+				if !ok {
+					return fmt.Errorf("reports require blob storage")
+				}
+				generator, err := buildReports(c.Config.Reports, store, ops, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				app.Provide(c, generator)
+				return nil
+			},
+		},
+		{
+			Name:    "deprecation",
+			Enabled: func(cfg *config.Config) bool { return cfg.Deprecation != nil && cfg.Deprecation.Enabled },
+			Build: func(c *app.Container) error {
+				// Last, so callers are authenticated and identified by
+				// subject. Modules requiring this one deprecate their own
+				// routes with r.With(tracker.Deprecate(policy)).
+				tracker := deprecation.NewTracker(c.Config.Deprecation, c.Logger, c.Stats)
+				c.Middleware.Insert("deprecation", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return tracker.Middleware, nil
+				}, server.Last())
+				c.MountAdmin(deprecation.NewHandler(tracker).Mount)
+				app.Provide(c, tracker)
+				return nil
+			},
+		},
+		{
+			Name:    "coalesce",
+			Enabled: func(cfg *config.Config) bool { return cfg.Coalesce != nil && cfg.Coalesce.Enabled },
+			Build: func(c *app.Container) error {
+				// Last, so requests are authenticated and rate limited
+				// before they share an execution. Modules requiring this
+				// one opt routes in with r.With(coalescer.Coalesce).
+				coalescer := coalesce.New(c.Config.Coalesce, c.Logger, c.Stats)
+				c.Middleware.Insert("coalesce", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return coalescer.Middleware, nil
+				}, server.Last())
+				app.Provide(c, coalescer)
+				return nil
+			},
+		},
+		{
+			Name:    "bot_detection",
+			Enabled: func(cfg *config.Config) bool { return cfg.Bots != nil && cfg.Bots.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted before geoip and ip_filter so it ends up after
+				// them, scoring only requests they let through. Modules
+				// requiring this one add detectors with guard.Register.
+				guard, err := buildBotGuard(c.Config.Bots, c.Clients, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Middleware.Insert("bot_detection", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return guard.Handler, nil
+				}, server.After("recoverer"))
+				c.Component("bot_detection", guard)
+				app.Provide(c, guard)
+				return nil
+			},
+		},
+		{
+			Name:    "geoip",
+			Enabled: func(cfg *config.Config) bool { return cfg.GeoIP != nil && cfg.GeoIP.Enabled },
+			Build: func(c *app.Container) error {
+				// Right after the recoverer, so blocked locations are
+				// refused before any other work. Handlers read the location
+				// with geoip.FromContext and tag logs with geoip.Fields.
+				db, err := geoip.Open(c.Config.GeoIP.Database)
+				if err != nil {
+					return fmt.Errorf("failed to open the geoip database: %w", err)
+				}
+				geo := geoip.NewMiddleware(c.Config.GeoIP, db, c.Logger, c.Stats)
+				c.Middleware.Insert("geoip", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return geo.Handler, nil
+				}, server.After("recoverer"))
+				app.Provide(c, db)
+				return nil
+			},
+		},
+		{
+			Name:    "ip_filter",
+			Enabled: func(cfg *config.Config) bool { return cfg.IPFilter != nil && cfg.IPFilter.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted after geoip so it ends up ahead of it, refusing
+				// filtered addresses before the location lookup and auth
+				var provider ipfilter.Provider
+				if c.Config.IPFilter.Database {
+					provider = ipfilter.NewDatabaseProvider(c.Engine)
+				}
+				filter, err := ipfilter.NewFilter(c.Config.IPFilter, provider, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Middleware.Insert("ip_filter", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return filter.Handler, nil
+				}, server.After("recoverer"))
+				c.MountAdmin(ipfilter.NewHandler(filter, c.Logger).Mount)
+				c.Component("ip_filter", filter)
+				app.Provide(c, filter)
+				return nil
+			},
+		},
+		{
+			Name:    "load_shedding",
+			Enabled: func(cfg *config.Config) bool { return cfg.Shedding != nil && cfg.Shedding.Enabled },
+			Build: func(c *app.Container) error {
+				// Inserted after ip_filter so it ends up first, rejecting
+				// requests before anything else spends time on them
+				// Background work waiting for its own partition's
+				// connections isn't a reason to shed requests
+				pool := c.Engine.Stats
+				if partitioner, ok := c.Engine.(storage.Partitioner); ok {
+					pool = func() sql.DBStats { return partitioner.PartitionStats()[storage.Interactive] }
+				}
+				shedder := loadshed.New(c.Config.Shedding, pool, c.Logger, c.Stats)
+				c.Middleware.Insert("load_shedding", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+					return shedder.Handler, nil
+				}, server.After("recoverer"))
+				c.MountAdmin(loadshed.NewHandler(shedder).Mount)
+				c.Component("load_shedding", shedder)
+				app.Provide(c, shedder)
+				return nil
+			},
+		},
+		{
+			Name:    "batch",
+			Enabled: func(cfg *config.Config) bool { return cfg.Batch != nil && cfg.Batch.Enabled },
+			Build: func(c *app.Container) error {
+				// Mounted on the public router, which then serves the
+				// sub-requests with its full middleware pipeline
+				cfg := c.Config.Batch
+				var router http.Handler
+				batch, err := server.NewBatch(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					router.ServeHTTP(w, r)
+				}), c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Mount(func(r chi.Router) {
+					router = r
+					r.Post(cfg.Path, batch.ServeHTTP)
+				})
+				return nil
+			},
+		},
+		{
+			Name: "tls_expiry",
+			Enabled: func(cfg *config.Config) bool {
+				tls := cfg.Server.TLS
+				return tls != nil && tls.Enabled && tls.Expiry != nil && tls.Expiry.Enabled
+			},
+			Build: func(c *app.Container) error {
+				monitor, err := server.NewCertMonitor(c.Config.Server.TLS, c.Logger, c.Stats)
+				if err != nil {
+					return err
+				}
+				c.Component("tls_expiry", monitor)
+				return nil
+			},
+		},
+		{
+			Name: "query_stats",
+			Enabled: func(cfg *config.Config) bool {
+				return cfg.Database.QueryStats != nil && cfg.Database.QueryStats.Enabled
+			},
+			Build: func(c *app.Container) error {
+				reporter := querystats.New(c.Config.Database.QueryStats, c.Engine, c.Logger, c.Stats)
+				c.MountAdmin(querystats.NewHandler(reporter).Mount)
+				c.Component("query_stats", reporter)
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"coffee-and-running/src/cli"
+	"coffee-and-running/src/scaffold"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	os.Exit(commands().Execute(context.Background(), os.Args[1:]))
+}
+
+func commands() *cli.Command {
+	var (
+		opts   scaffold.Options
+		genDir string
+		client scaffold.ClientOptions
+	)
+	return &cli.Command{
+		Name:    "starter",
+		Summary: "Tools for services built on the starter kit",
+		Commands: []*cli.Command{
+			{
+				Name:    "new",
+				Summary: "Create a new service from the kit",
+				Args:    "<name>",
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&opts.Module, "module", "", "Go module path (default <name>)")
+					fs.StringVar(&opts.Dir, "dir", "", "output directory (default ./<name>)")
+					fs.StringVar(&opts.Source, "from", ".", "root of the starter kit to copy")
+				},
+				Run: func(ctx context.Context, fs *flag.FlagSet) error {
+					if fs.NArg() != 1 {
+						return cli.Usagef("expected one service name, got %d arguments", fs.NArg())
+					}
+					opts.Name = fs.Arg(0)
+					if err := scaffold.Generate(opts); err != nil {
+						return err
+					}
+
+					dir := opts.Dir
+					if dir == "" {
+						dir = opts.Name
+					}
+					fmt.Printf("Created %s in %s\n\nNext steps:\n", opts.Name, dir)
+					fmt.Printf("  cd %s\n", dir)
+					fmt.Println("  make compose-up db-migrate")
+					fmt.Println("  make run")
+					return nil
+				},
+			},
+			{
+				Name:    "gen",
+				Summary: "Generate code in the service in the current directory",
+				Commands: []*cli.Command{
+					{
+						Name:    "resource",
+						Summary: "Generate a migration, repository, handler, tests and module for a resource",
+						Args:    "<Name> <field:type>... (types: bool, float, int, string, text, time)",
+						Flags: func(fs *flag.FlagSet) {
+							fs.StringVar(&genDir, "dir", ".", "root of the service")
+						},
+						Run: func(ctx context.Context, fs *flag.FlagSet) error {
+							if fs.NArg() < 2 {
+								return cli.Usagef("expected a resource name and at least one field")
+							}
+							r, err := scaffold.ParseResource(fs.Arg(0), fs.Args()[1:])
+							if err != nil {
+								return cli.Usagef("%v", err)
+							}
+							if err := scaffold.GenerateResource(genDir, r); err != nil {
+								return err
+							}
+							fmt.Printf("Generated %s; run \"migrate up\" to create its table\n", r.Type)
+							return nil
+						},
+					},
+					{
+						Name:    "client",
+						Summary: "Generate a typed API client from the OpenAPI document",
+						Flags: func(fs *flag.FlagSet) {
+							fs.StringVar(&client.Spec, "spec", "api/openapi.yaml", "OpenAPI document to generate from")
+							fs.StringVar(&client.Lang, "lang", "go", "language of the client: go or ts")
+							fs.StringVar(&client.Package, "package", "client", "Go package name")
+							fs.StringVar(&client.Out, "out", "", "file to write (default <package>/client.go, or client.ts)")
+						},
+						Run: func(ctx context.Context, fs *flag.FlagSet) error {
+							if fs.NArg() != 0 {
+								return cli.Usagef("unexpected arguments: %v", fs.Args())
+							}
+							out, err := scaffold.GenerateClient(client)
+							if errors.Is(err, scaffold.ErrInvalidClient) {
+								return cli.Usagef("%v", err)
+							}
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Generated %s from %s\n", out, client.Spec)
+							return nil
+						},
+					},
+				},
+			},
+		},
+	}
+}
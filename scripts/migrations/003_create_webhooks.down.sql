@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_endpoint_id;
+DROP INDEX IF EXISTS idx_webhook_deliveries_pending;
+DROP INDEX IF EXISTS idx_webhook_secrets_endpoint_id;
+DROP INDEX IF EXISTS idx_webhook_endpoints_tenant_id;
+DROP TABLE IF EXISTS webhook_dead_letters;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_secrets;
+DROP TABLE IF EXISTS webhook_endpoints;
\ No newline at end of file
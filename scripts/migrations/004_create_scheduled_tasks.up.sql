@@ -0,0 +1,13 @@
+CREATE TABLE scheduled_tasks (
+    name VARCHAR(255) PRIMARY KEY,
+    schedule VARCHAR(255) NOT NULL,
+    locked_by VARCHAR(255),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_scheduled_at TIMESTAMP WITH TIME ZONE,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    last_status VARCHAR(20) CHECK (last_status IN ('success', 'failed')),
+    last_error TEXT,
+    last_duration_ms BIGINT,
+    last_run_by VARCHAR(255),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE feature_flags (
+    key VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    rollout_percent INTEGER NOT NULL DEFAULT 0 CHECK (rollout_percent BETWEEN 0 AND 100),
+    users TEXT[] NOT NULL DEFAULT '{}',
+    tenants TEXT[] NOT NULL DEFAULT '{}',
+    updated_by VARCHAR(255),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
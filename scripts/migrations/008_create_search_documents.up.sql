@@ -0,0 +1,17 @@
+CREATE TABLE search_documents (
+    index_name VARCHAR(255) NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    language REGCONFIG NOT NULL DEFAULT 'english',
+    title TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    attributes JSONB NOT NULL DEFAULT '{}',
+    document TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector(language, title), 'A') ||
+        setweight(to_tsvector(language, body), 'B')
+    ) STORED,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (index_name, id)
+);
+
+CREATE INDEX idx_search_documents_document ON search_documents USING GIN (document);
+CREATE INDEX idx_search_documents_attributes ON search_documents USING GIN (attributes jsonb_path_ops);
@@ -0,0 +1,18 @@
+CREATE TABLE operations (
+    id VARCHAR(32) PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    owner VARCHAR(255) NOT NULL DEFAULT '',
+    tenant_id VARCHAR(63) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    progress SMALLINT NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+    message TEXT NOT NULL DEFAULT '',
+    result JSONB,
+    error TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_operations_unfinished ON operations(created_at) WHERE status IN ('pending', 'running');
+CREATE INDEX idx_operations_finished ON operations(finished_at) WHERE finished_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS event_consumers;
+DROP TRIGGER IF EXISTS domain_events_append_only ON domain_events;
+DROP FUNCTION IF EXISTS domain_events_append_only();
+DROP TABLE IF EXISTS domain_events;
@@ -0,0 +1,32 @@
+CREATE TABLE domain_events (
+    id BIGSERIAL PRIMARY KEY,
+    aggregate_type VARCHAR(255) NOT NULL,
+    aggregate_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_domain_events_type ON domain_events(event_type, id);
+CREATE INDEX idx_domain_events_aggregate ON domain_events(aggregate_type, aggregate_id, id);
+CREATE INDEX idx_domain_events_occurred_at ON domain_events(occurred_at);
+
+-- The log is append-only: read models are rebuilt from it, so rows are
+-- never changed or removed
+CREATE FUNCTION domain_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'domain_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER domain_events_append_only
+    BEFORE UPDATE OR DELETE ON domain_events
+    FOR EACH ROW EXECUTE FUNCTION domain_events_append_only();
+
+CREATE TABLE event_consumers (
+    name VARCHAR(255) PRIMARY KEY,
+    position BIGINT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS rate_limit_policies;
+DROP TABLE IF EXISTS rate_limit_plans;
@@ -0,0 +1,20 @@
+-- Plans are the tiers sold to customers; changing a plan's limits applies
+-- to everyone on it at the next refresh
+CREATE TABLE rate_limit_plans (
+    name VARCHAR(100) PRIMARY KEY,
+    requests_per_second DOUBLE PRECISION NOT NULL CHECK (requests_per_second > 0),
+    burst INTEGER NOT NULL CHECK (burst > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- A policy puts a tenant, or an authenticated subject such as a user or an
+-- API client, on a plan
+CREATE TABLE rate_limit_policies (
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('tenant', 'subject')),
+    subject VARCHAR(255) NOT NULL,
+    plan VARCHAR(100) NOT NULL REFERENCES rate_limit_plans (name) ON UPDATE CASCADE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE INDEX idx_rate_limit_policies_plan ON rate_limit_policies (plan);
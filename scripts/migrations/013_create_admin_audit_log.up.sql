@@ -0,0 +1,12 @@
+-- Edits made through the admin UI, one row per saved form
+CREATE TABLE admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    row_key TEXT NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_admin_audit_log_row ON admin_audit_log (table_name, row_key, id DESC);
@@ -0,0 +1,18 @@
+-- Queued notifications and their delivery status, one row per recipient and channel
+CREATE TABLE notifications (
+    id BIGSERIAL PRIMARY KEY,
+    channel VARCHAR(20) NOT NULL,
+    recipient VARCHAR(512) NOT NULL,
+    template VARCHAR(100),
+    message JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    provider_message_id VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_notifications_pending ON notifications(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_notifications_recipient ON notifications(channel, recipient, created_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE feature_flags ADD COLUMN variants JSONB NOT NULL DEFAULT '[]';
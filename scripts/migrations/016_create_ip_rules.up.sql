@@ -0,0 +1,11 @@
+-- Address ranges allowed or denied at runtime through /admin/ip-rules
+CREATE TABLE ip_rules (
+    id BIGSERIAL PRIMARY KEY,
+    cidr CIDR NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'deny')),
+    reason TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (cidr, action)
+);
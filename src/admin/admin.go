@@ -0,0 +1,195 @@
+// Package admin serves a small HTML admin for browsing, searching and
+// editing database tables, so internal tools don't have to be built for
+// every project. Tables are registered from config or by modules, and
+// their columns are read from the database's catalog at startup. Every
+// edit is recorded in admin_audit_log.
+package admin
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnknownTable is returned for a table nothing registered
+var ErrUnknownTable = errors.New("admin: unknown table")
+
+// Table is a table exposed in the admin. The embedded storage.Table's
+// conventions apply: soft-deleted rows are hidden, and edits bump the
+// version and updated_at columns and fail on a version conflict.
+//
+//	a.Register(admin.Table{
+//		Table:  storage.Table{Name: "posts", Version: "version", DeletedAt: "deleted_at"},
+//		Search: []string{"title"},
+//	})
+type Table struct {
+	storage.Table
+	// Search lists the columns the search box matches
+	Search []string
+	// ReadOnly columns are shown but not editable. The key and the
+	// storage.Table convention columns always are.
+	ReadOnly []string
+	// Hidden columns are never shown, e.g. password hashes
+	Hidden []string
+
+	columns []Column
+}
+
+// Column is a visible column of a registered table
+type Column struct {
+	Name     string
+	Type     string
+	Nullable bool
+	ReadOnly bool
+}
+
+// Columns returns the visible columns, in table order, once loaded
+func (t *Table) Columns() []Column {
+	return t.columns
+}
+
+// Key returns the primary key column
+func (t *Table) Key() string {
+	if t.Table.Key == "" {
+		return "id"
+	}
+	return t.Table.Key
+}
+
+// Admin holds the registered tables
+type Admin struct {
+	config *config.AdminUIConfig
+	engine storage.Engine
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu     sync.RWMutex
+	tables map[string]*Table
+}
+
+// New creates an admin with the tables listed in cfg registered
+func New(cfg *config.AdminUIConfig, engine storage.Engine, logger *zap.Logger, stats metrics.Agent) *Admin {
+	a := &Admin{
+		config: cfg,
+		engine: engine,
+		logger: logger.With(zap.String("component", "admin")),
+		stats:  stats,
+		tables: make(map[string]*Table),
+	}
+	for _, t := range cfg.Tables {
+		a.Register(Table{
+			Table:    storage.Table{Name: t.Name, Key: t.Key},
+			Search:   t.Search,
+			ReadOnly: t.ReadOnly,
+			Hidden:   t.Hidden,
+		})
+	}
+	return a
+}
+
+// Register exposes a table. Modules register their tables while they are
+// built; registering a name again replaces it.
+func (a *Admin) Register(t Table) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tables[t.Name] = &t
+}
+
+// Tables returns the registered tables by name
+func (a *Admin) Tables() []*Table {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	tables := make([]*Table, 0, len(a.tables))
+	for _, t := range a.tables {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables
+}
+
+// Table returns the named table
+func (a *Admin) Table(name string) (*Table, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	t, ok := a.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTable, name)
+	}
+	return t, nil
+}
+
+// Load reads every registered table's columns from the catalog. It fails
+// when a table, its key or a configured column doesn't exist, so a typo
+// stops startup rather than showing an empty page.
+func (a *Admin) Load(ctx context.Context) error {
+	tables := a.Tables()
+	for _, t := range tables {
+		columns, err := a.introspect(ctx, t)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		t.columns = columns
+		a.mu.Unlock()
+	}
+	a.logger.Info("admin tables loaded", zap.Int("tables", len(tables)))
+	return nil
+}
+
+func (a *Admin) introspect(ctx context.Context, t *Table) ([]Column, error) {
+	rows, err := a.engine.Query(ctx,
+		`SELECT column_name, data_type, is_nullable = 'YES'
+		 FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = $1
+		 ORDER BY ordinal_position`, t.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+
+	readOnly := append([]string{t.Key(), t.Version, t.UpdatedAt, t.DeletedAt}, t.ReadOnly...)
+	var columns []Column
+	var all []string
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable); err != nil {
+			return nil, fmt.Errorf("failed to scan column of %s: %w", t.Name, err)
+		}
+		all = append(all, c.Name)
+		if slices.Contains(t.Hidden, c.Name) {
+			continue
+		}
+		c.ReadOnly = slices.Contains(readOnly, c.Name)
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(all) == 0 {
+		return nil, fmt.Errorf("admin table %s does not exist", t.Name)
+	}
+	named := append([]string{t.Key()}, t.Search...)
+	named = append(named, t.ReadOnly...)
+	named = append(named, t.Hidden...)
+	for _, name := range named {
+		if !slices.Contains(all, name) {
+			return nil, fmt.Errorf("admin table %s has no column %s", t.Name, name)
+		}
+	}
+	for _, name := range append([]string{t.Key()}, t.Search...) {
+		if slices.Contains(t.Hidden, name) {
+			return nil, fmt.Errorf("admin table %s cannot hide %s, its key or a search column", t.Name, name)
+		}
+	}
+	return columns, nil
+}
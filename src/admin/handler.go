@@ -0,0 +1,283 @@
+package admin
+
+import (
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/render"
+	"coffee-and-running/src/storage"
+	"coffee-and-running/src/users"
+	"crypto/sha256"
+	"embed"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+//go:embed templates
+var templates embed.FS
+
+// historySize is how many audit log entries the edit page shows
+const historySize = 20
+
+// Handler serves the admin pages under admin_ui.path
+type Handler struct {
+	admin  *Admin
+	views  *render.Renderer
+	hasher *users.Hasher
+	logger *zap.Logger
+
+	// verified caches credentials that passed argon2id, keyed by a hash of
+	// user and password, so each page view doesn't pay for a verification
+	verified sync.Map
+}
+
+// NewHandler creates the admin pages
+func NewHandler(a *Admin, logger *zap.Logger) *Handler {
+	sub, _ := fs.Sub(templates, "templates")
+	views := render.NewRenderer(sub, "admin", false, nil, logger)
+	views.RegisterFunc("pathEscape", url.PathEscape)
+	return &Handler{
+		admin:  a,
+		views:  views,
+		hasher: users.NewHasher(0, 0, 0),
+		logger: logger.With(zap.String("component", "admin.handler")),
+	}
+}
+
+// Mount registers the admin pages, behind HTTP basic auth
+func (h *Handler) Mount(r chi.Router) {
+	r.Route(h.admin.config.Path, func(r chi.Router) {
+		r.Use(h.authenticate, sameOrigin)
+		r.Get("/", h.index)
+		r.Get("/{table}", h.list)
+		r.Get("/{table}/{key}", h.edit)
+		r.Post("/{table}/{key}", h.update)
+	})
+}
+
+type indexData struct {
+	Base   string
+	Tables []*Table
+}
+
+type listData struct {
+	Base   string
+	Table  *Table
+	Search string
+	Page   Page
+	Prev   int
+	Next   int
+}
+
+type editData struct {
+	Base    string
+	Table   *Table
+	Key     string
+	Version int64
+	Fields  []field
+	Saved   bool
+	Error   string
+	History []Entry
+}
+
+type field struct {
+	Column
+	Value
+}
+
+func (h *Handler) index(w http.ResponseWriter, r *http.Request) {
+	h.views.HTML(w, r, http.StatusOK, "index", indexData{Base: h.admin.config.Path, Tables: h.admin.Tables()})
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.table(w, r)
+	if !ok {
+		return
+	}
+	number, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	search := r.URL.Query().Get("q")
+	page, err := h.admin.List(r.Context(), t, search, number)
+	if err != nil {
+		h.fail(w, "failed to list rows", err)
+		return
+	}
+
+	data := listData{Base: h.admin.config.Path, Table: t, Search: search, Page: page}
+	if page.Number > 1 {
+		data.Prev = page.Number - 1
+	}
+	if page.More {
+		data.Next = page.Number + 1
+	}
+	h.views.HTML(w, r, http.StatusOK, "list", data)
+}
+
+func (h *Handler) edit(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.table(w, r)
+	if !ok {
+		return
+	}
+	key := chi.URLParam(r, "key")
+	row, err := h.admin.Get(r.Context(), t, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.fail(w, "failed to read row", err)
+		return
+	}
+	h.render(w, r, http.StatusOK, t, row, r.URL.Query().Get("saved") != "", "")
+}
+
+func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.table(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	key := chi.URLParam(r, "key")
+	version, _ := strconv.ParseInt(r.PostForm.Get("_version"), 10, 64)
+
+	values := map[string]Value{}
+	for _, column := range t.Columns() {
+		if column.ReadOnly {
+			continue
+		}
+		if column.Nullable && r.PostForm.Get("null:"+column.Name) != "" {
+			values[column.Name] = Value{Null: true}
+			continue
+		}
+		if text, ok := r.PostForm[column.Name]; ok && len(text) > 0 {
+			values[column.Name] = Value{Text: text[0]}
+		}
+	}
+
+	user, _, _ := r.BasicAuth()
+	_, err := h.admin.Update(r.Context(), t, key, version, values, user)
+	switch {
+	case err == nil:
+		http.Redirect(w, r, h.admin.config.Path+"/"+url.PathEscape(t.Name)+"/"+url.PathEscape(key)+"?saved=1", http.StatusSeeOther)
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, storage.ErrConflict):
+		h.rerender(w, r, http.StatusConflict, t, key, "Someone else changed this row. Review it and apply your edit again.")
+		return
+	case apperr.KindOf(err) == apperr.Invalid || apperr.KindOf(err) == apperr.Conflict:
+		h.rerender(w, r, http.StatusUnprocessableEntity, t, key, apperr.Message(err))
+		return
+	default:
+		h.fail(w, "failed to update row", err)
+	}
+}
+
+// rerender shows the row as it is now with an error message
+func (h *Handler) rerender(w http.ResponseWriter, r *http.Request, status int, t *Table, key, message string) {
+	row, err := h.admin.Get(r.Context(), t, key)
+	if err != nil {
+		h.fail(w, "failed to read row", err)
+		return
+	}
+	h.render(w, r, status, t, row, false, message)
+}
+
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, status int, t *Table, row Row, saved bool, message string) {
+	history, err := h.admin.History(r.Context(), t, row.Key, historySize)
+	if err != nil {
+		h.fail(w, "failed to read audit log", err)
+		return
+	}
+	data := editData{
+		Base:    h.admin.config.Path,
+		Table:   t,
+		Key:     row.Key,
+		Version: row.Version,
+		Saved:   saved,
+		Error:   message,
+		History: history,
+	}
+	for i, column := range t.Columns() {
+		data.Fields = append(data.Fields, field{Column: column, Value: row.Values[i]})
+	}
+	h.views.HTML(w, r, status, "edit", data)
+}
+
+func (h *Handler) table(w http.ResponseWriter, r *http.Request) (*Table, bool) {
+	t, err := h.admin.Table(chi.URLParam(r, "table"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return t, true
+}
+
+func (h *Handler) fail(w http.ResponseWriter, message string, err error) {
+	h.logger.Error(message, zap.Error(err))
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// authenticate requires HTTP basic auth credentials matching
+// admin_ui.users
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !h.verify(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) verify(user, password string) bool {
+	encoded, ok := h.admin.config.Users[user]
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + encoded))
+	if _, ok := h.verified.Load(sum); ok {
+		return true
+	}
+	match, _, err := h.hasher.Verify(password, encoded)
+	if err != nil {
+		h.logger.Error("admin user has a malformed password hash", zap.String("user", user))
+		return false
+	}
+	if !match {
+		h.logger.Warn("admin sign-in failed", zap.String("user", user))
+		return false
+	}
+	h.verified.Store(sum, struct{}{})
+	return true
+}
+
+// sameOrigin rejects cross-site form posts. Browsers resend basic auth
+// credentials on their own, so a post must come from an admin page.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			origin = r.Header.Get("Referer")
+		}
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
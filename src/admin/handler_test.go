@@ -0,0 +1,75 @@
+package admin_test
+
+import (
+	"coffee-and-running/src/admin"
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/tracing"
+	"coffee-and-running/src/server"
+	"coffee-and-running/src/testkit"
+	"coffee-and-running/src/users"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestSignInWithAuthEnabled signs in to the admin pages with basic auth
+// while the auth middleware, which rejects anything but bearer tokens,
+// guards the public routes
+func TestSignInWithAuthEnabled(t *testing.T) {
+	hash, err := users.NewHasher(1024, 1, 1).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := &config.AdminUIConfig{Enabled: true, Path: "/admin/ui", Users: map[string]string{"ops": hash}}
+	pages := admin.NewHandler(admin.New(ui, nil, zap.NewNop(), testkit.NewStats()), zap.NewNop())
+
+	tokens, err := auth.NewJWT("0123456789abcdef0123456789abcdef", "test", "test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := server.NewRegistry()
+	registry.InsertPublic("auth", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+		return auth.Middleware(tokens, nil), nil
+	}, server.After("recoverer"))
+
+	cfg := config.DefaultConfig()
+	tracer, err := tracing.NewProvider(cfg.Tracing, cfg.App, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := server.Dependencies{App: cfg.App, Logger: zap.NewNop(), Stats: testkit.NewStats(), Tracer: tracer}
+	public, err := server.SetupRouter(cfg.Server, registry, deps, pages.Mount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminRouter, err := server.SetupAdminRouter(cfg.Server, registry, deps, pages.Mount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		router   http.Handler
+		password string
+		want     int
+	}{
+		{"public pipeline", public, "correct horse", http.StatusUnauthorized},
+		{"admin pipeline", adminRouter, "correct horse", http.StatusOK},
+		{"wrong password", adminRouter, "battery staple", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/ui/", nil)
+			req.SetBasicAuth("ops", tt.password)
+			rec := httptest.NewRecorder()
+			tt.router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("GET /admin/ui/ = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
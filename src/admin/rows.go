@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Value is a column value as the database formats it as text
+type Value struct {
+	Text string
+	Null bool
+}
+
+// Row is a row's visible values, in column order
+type Row struct {
+	Key     string
+	Values  []Value
+	Version int64
+}
+
+// Page is one page of a table's rows
+type Page struct {
+	Rows   []Row
+	Number int
+	More   bool
+}
+
+// Change is one column's edit
+type Change struct {
+	From *string `json:"from"`
+	To   *string `json:"to"`
+}
+
+// Entry is an audit log entry
+type Entry struct {
+	Actor     string
+	Action    string
+	Changes   map[string]Change
+	CreatedAt time.Time
+}
+
+// List returns page number (from 1) of the table's rows in key order,
+// limited to those whose search columns contain search when it isn't empty
+func (a *Admin) List(ctx context.Context, t *Table, search string, number int) (Page, error) {
+	number = max(number, 1)
+	where, args := "", []interface{}{}
+	if search != "" && len(t.Search) > 0 {
+		args = append(args, "%"+escapeLike(search)+"%")
+		matches := make([]string, len(t.Search))
+		for i, column := range t.Search {
+			matches[i] = pq.QuoteIdentifier(column) + "::text ILIKE $1"
+		}
+		where = strings.Join(matches, " OR ")
+	}
+	args = append(args, a.config.PageSize+1, (number-1)*a.config.PageSize)
+
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		selectList(t), pq.QuoteIdentifier(t.Name), live(t, where), pq.QuoteIdentifier(t.Key()), len(args)-1, len(args))
+	rows, err := a.engine.Query(ctx, query, args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to list %s: %w", t.Name, err)
+	}
+	defer rows.Close()
+
+	page := Page{Number: number}
+	for rows.Next() {
+		row, err := scanRow(rows, t)
+		if err != nil {
+			return Page{}, fmt.Errorf("failed to scan %s: %w", t.Name, err)
+		}
+		page.Rows = append(page.Rows, row)
+	}
+	if len(page.Rows) > a.config.PageSize {
+		page.Rows, page.More = page.Rows[:a.config.PageSize], true
+	}
+	return page, rows.Err()
+}
+
+// Get returns the row with key, or storage.ErrNotFound
+func (a *Admin) Get(ctx context.Context, t *Table, key string) (Row, error) {
+	return get(ctx, a.engine, t, key, "")
+}
+
+// Update applies the edited values of a row read at version and records
+// the changes in admin_audit_log under actor, in one transaction. Values
+// for read-only or unknown columns are ignored. It returns the changes,
+// none if nothing differed, or a *storage.ConflictError if the row was
+// edited since it was read.
+func (a *Admin) Update(ctx context.Context, t *Table, key string, version int64, values map[string]Value, actor string) (map[string]Change, error) {
+	tx, err := a.engine.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				a.logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	current, err := get(ctx, tx, t, key, " FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	set := map[string]interface{}{}
+	for i, column := range t.Columns() {
+		value, ok := values[column.Name]
+		if column.ReadOnly || !ok || value == current.Values[i] {
+			continue
+		}
+		changes[column.Name] = Change{From: text(current.Values[i]), To: text(value)}
+		if value.Null {
+			set[pq.QuoteIdentifier(column.Name)] = nil
+		} else {
+			set[pq.QuoteIdentifier(column.Name)] = value.Text
+		}
+	}
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	if _, err := t.Table.Update(ctx, tx, key, version, set); err != nil {
+		return nil, err
+	}
+	changeJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode changes: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO admin_audit_log (actor, table_name, row_key, action, changes)
+		 VALUES ($1, $2, $3, 'update', $4)`,
+		actor, t.Name, key, changeJSON); err != nil {
+		return nil, fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit update: %w", err)
+	}
+	committed = true
+
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		columns = append(columns, column)
+	}
+	a.logger.Info("admin updated row",
+		zap.String("actor", actor),
+		zap.String("table", t.Name),
+		zap.String("key", key),
+		zap.Strings("columns", columns))
+	a.stats.Increment("admin." + t.Name + ".updated")
+	return changes, nil
+}
+
+// History returns the latest audit log entries for a row, newest first
+func (a *Admin) History(ctx context.Context, t *Table, key string, limit int) ([]Entry, error) {
+	rows, err := a.engine.Query(ctx,
+		`SELECT actor, action, changes, created_at FROM admin_audit_log
+		 WHERE table_name = $1 AND row_key = $2
+		 ORDER BY id DESC LIMIT $3`, t.Name, key, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var changes []byte
+		if err := rows.Scan(&e.Actor, &e.Action, &changes, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode audit log changes: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func get(ctx context.Context, db storage.Executor, t *Table, key, lock string) (Row, error) {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s%s`,
+		selectList(t), pq.QuoteIdentifier(t.Name), live(t, pq.QuoteIdentifier(t.Key())+"::text = $1"), lock)
+	rows, err := db.Query(ctx, query, key)
+	if err != nil {
+		return Row{}, fmt.Errorf("failed to read %s %s: %w", t.Name, key, err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Row{}, err
+		}
+		return Row{}, storage.ErrNotFound
+	}
+	row, err := scanRow(rows, t)
+	if err != nil {
+		return Row{}, fmt.Errorf("failed to scan %s %s: %w", t.Name, key, err)
+	}
+	return row, rows.Err()
+}
+
+// selectList selects the key, the version (0 without one) and the
+// visible columns, all as text
+func selectList(t *Table) string {
+	version := "0"
+	if t.Version != "" {
+		version = pq.QuoteIdentifier(t.Version)
+	}
+	list := []string{pq.QuoteIdentifier(t.Key()) + "::text", version}
+	for _, column := range t.Columns() {
+		list = append(list, pq.QuoteIdentifier(column.Name)+"::text")
+	}
+	return strings.Join(list, ", ")
+}
+
+func scanRow(rows *sql.Rows, t *Table) (Row, error) {
+	var row Row
+	values := make([]sql.NullString, len(t.Columns()))
+	dest := []interface{}{&row.Key, &row.Version}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return Row{}, err
+	}
+	row.Values = make([]Value, len(values))
+	for i, v := range values {
+		row.Values[i] = Value{Text: v.String, Null: !v.Valid}
+	}
+	return row, nil
+}
+
+// live applies the table's soft-delete filter to where, which may be empty
+func live(t *Table, where string) string {
+	if where = t.Live(where); where == "" {
+		return "TRUE"
+	}
+	return where
+}
+
+func text(v Value) *string {
+	if v.Null {
+		return nil
+	}
+	return &v.Text
+}
+
+// escapeLike escapes LIKE wildcards so the search matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
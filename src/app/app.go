@@ -2,71 +2,397 @@ package app
 
 import (
 	"coffee-and-running/src/config"
-	"coffee-and-running/src/observability/metrics"
-	"coffee-and-running/src/storage"
 	"context"
-	"net/http"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 type Application interface {
 	Run()
+	// OnStart runs fn before the server accepts traffic. A failing start
+	// hook aborts startup.
+	OnStart(name string, timeout time.Duration, fn HookFunc)
+	// OnReady runs fn once the listener is up, e.g. to warm caches or
+	// start queue consumers. Failures are logged.
+	OnReady(name string, timeout time.Duration, fn HookFunc)
+	// OnShutdown runs fn after the server has drained, in the
+	// StopConsumers phase, before hooks registered earlier (and the
+	// database) are stopped
+	OnShutdown(name string, timeout time.Duration, fn HookFunc)
+	// Addr returns the address the named server listens on, or nil until
+	// it does. With port 0 this is how to find the chosen port, e.g. from
+	// an OnReady hook.
+	Addr(name string) net.Addr
+}
+
+// HookFunc is a lifecycle callback. It should return once ctx is done.
+type HookFunc func(ctx context.Context) error
+
+// Component is a background service started before the server accepts
+// traffic and closed, in reverse order, after it has drained
+type Component interface {
+	Start() error
+	Close() error
+}
+
+// closer adapts an already-open resource to Component
+type closer struct {
+	io.Closer
+}
+
+func (closer) Start() error { return nil }
+
+// Closer wraps a resource that is opened at construction, such as a client
+// connection pool, so it is closed with the application
+func Closer(c io.Closer) Component {
+	return closer{c}
+}
+
+// Phase orders OnStop hooks at shutdown. Once the servers have stopped
+// accepting requests, hooks stop phase by phase, so background work is
+// done with the database before it closes. Within a phase they stop in
+// reverse registration order.
+type Phase int
+
+const (
+	// StopConsumers stops taking on background work: queue consumers,
+	// schedulers and dispatchers. It is the default.
+	StopConsumers Phase = iota
+	// StopFlush drains and flushes what was taken on: the worker pool,
+	// the outbox relay, broker producers, metrics and spans
+	StopFlush
+	// StopClose closes connections, such as the database's, once nothing
+	// uses them
+	StopClose
+)
+
+// phases lists the shutdown phases in the order they run
+var phases = []Phase{StopConsumers, StopFlush, StopClose}
+
+func (p Phase) String() string {
+	switch p {
+	case StopConsumers:
+		return "consumers"
+	case StopFlush:
+		return "flush"
+	case StopClose:
+		return "close"
+	}
+	return fmt.Sprintf("phase(%d)", int(p))
+}
+
+// Hook is a named set of lifecycle callbacks. OnStart hooks run in
+// registration order before the server accepts traffic and OnReady hooks
+// once it listens; OnStop hooks run after it has drained, by Phase and in
+// reverse order within one. Any may be nil.
+type Hook struct {
+	Name    string
+	OnStart HookFunc
+	OnReady HookFunc
+	OnStop  HookFunc
+	// Timeout bounds each callback; zero uses server.start_timeout and
+	// server.shutdown_timeout
+	Timeout time.Duration
+	// Phase is when OnStop runs; the zero value is StopConsumers
+	Phase Phase
+}
+
+// InPhase returns h with its OnStop run in phase:
+//
+//	c.Append(app.ComponentHook("redis", app.Closer(client)).InPhase(app.StopClose))
+func (h Hook) InPhase(phase Phase) Hook {
+	h.Phase = phase
+	return h
+}
+
+// ComponentHook adapts a Component to a Hook
+func ComponentHook(name string, c Component) Hook {
+	return Hook{
+		Name:    name,
+		OnStart: func(context.Context) error { return c.Start() },
+		OnStop:  func(context.Context) error { return c.Close() },
+	}
 }
 
 type application struct {
-	config *config.Config
-	logger *zap.Logger
-	engine storage.Engine
-	server *http.Server
-	stats  metrics.Agent
+	config  *config.Config
+	logger  *zap.Logger
+	servers []Server
+	hooks   []Hook
+
+	mu    sync.Mutex
+	addrs map[string]net.Addr
 }
 
-func New(config *config.Config, logger *zap.Logger, stats metrics.Agent, engine storage.Engine, server *http.Server) Application {
+// New creates an application running servers and running hooks around
+// them
+func New(config *config.Config, logger *zap.Logger, servers []Server, hooks ...Hook) Application {
 	return &application{
-		config: config,
-		logger: logger,
-		engine: engine,
-		server: server,
-		stats:  stats,
+		config:  config,
+		logger:  logger,
+		servers: servers,
+		hooks:   hooks,
+		addrs:   make(map[string]net.Addr),
 	}
 }
 
+func (a *application) OnStart(name string, timeout time.Duration, fn HookFunc) {
+	a.hooks = append(a.hooks, Hook{Name: name, OnStart: fn, Timeout: timeout})
+}
+
+func (a *application) OnReady(name string, timeout time.Duration, fn HookFunc) {
+	a.hooks = append(a.hooks, Hook{Name: name, OnReady: fn, Timeout: timeout})
+}
+
+func (a *application) OnShutdown(name string, timeout time.Duration, fn HookFunc) {
+	a.hooks = append(a.hooks, Hook{Name: name, OnStop: fn, Timeout: timeout})
+}
+
+func (a *application) Addr(name string) net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.addrs[name]
+}
+
 func (a *application) Run() {
 	// Create a channel to receive OS signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	// Start server in a goroutine
-	go func() {
-		a.logger.Info("Starting server", zap.String("address", a.server.Addr))
+	started, err := a.start()
+	if err != nil {
+		a.stop(started)
+		a.logger.Fatal("Application failed to start", zap.Error(err))
+	}
+
+	// Listen on everything before serving anything, so ready hooks only
+	// run once every server accepts connections
+	for _, s := range a.servers {
+		if err := a.listen(s); err != nil {
+			a.stop(started)
+			a.logger.Fatal("Server failed to listen", zap.String("server", s.Name()), zap.Error(err))
+		}
+	}
+
+	// A server failing cancels ctx, shutting the others down too
+	group, ctx := errgroup.WithContext(context.Background())
+	for _, s := range a.servers {
+		group.Go(func() error {
+			a.logger.Info("Starting server", zap.String("server", s.Name()))
+			if err := s.Serve(); err != nil {
+				return fmt.Errorf("%s: %w", s.Name(), err)
+			}
+			return nil
+		})
+	}
+	a.ready()
+
+	// Wait for interrupt signal or a server failure
+	select {
+	case <-sigChan:
+		a.logger.Info("Shutting down servers...")
+	case <-ctx.Done():
+		a.logger.Error("Server failed, shutting down servers...")
+	}
+	a.shutdown()
 
-		var err error
-		if a.config.Server.TLS.Enabled {
-			err = a.server.ListenAndServeTLS(a.config.Server.TLS.CertFile, a.config.Server.TLS.KeyFile)
+	err = group.Wait()
+	if err != nil {
+		a.logger.Error("Server failed", zap.Error(err))
+	}
+	a.stop(len(a.hooks))
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// listen binds s, retrying with backoff per server.bind_retry while its
+// address is in use, and records the address it bound
+func (a *application) listen(s Server) error {
+	attempts, backoff, maxBackoff := 1, time.Duration(0), time.Duration(0)
+	if retry := a.config.Server.BindRetry; retry != nil && retry.Attempts > 1 {
+		attempts, backoff, maxBackoff = retry.Attempts, retry.InitialBackoff, retry.MaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.Listen()
+		if err == nil {
+			break
+		}
+		if attempt >= attempts || !errors.Is(err, syscall.EADDRINUSE) {
+			return err
+		}
+		a.logger.Warn("Server address in use, retrying",
+			zap.String("server", s.Name()),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		time.Sleep(backoff)
+		if backoff *= 2; maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	if ad, ok := s.(Addresser); ok && ad.Addr() != nil {
+		a.mu.Lock()
+		a.addrs[s.Name()] = ad.Addr()
+		a.mu.Unlock()
+		a.logger.Info("Server listening", zap.String("server", s.Name()), zap.Stringer("addr", ad.Addr()))
+	}
+	return nil
+}
+
+// shutdown stops servers in reverse order, each within
+// server.shutdown_timeout
+func (a *application) shutdown() {
+	for i := len(a.servers) - 1; i >= 0; i-- {
+		s := a.servers[i]
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.Server.ShutdownTimeout)
+		if err := s.Shutdown(ctx); err != nil {
+			a.logger.Error("Server forced to shutdown", zap.String("server", s.Name()), zap.Error(err))
 		} else {
-			err = a.server.ListenAndServe()
+			a.logger.Info("Server gracefully stopped", zap.String("server", s.Name()))
+		}
+		cancel()
+	}
+}
+
+// start runs OnStart hooks in order and returns how many succeeded, so a
+// failed startup stops only what was started
+func (a *application) start() (int, error) {
+	for i, h := range a.hooks {
+		if h.OnStart == nil {
+			continue
+		}
+		if err := runHook(h.OnStart, a.timeout(h, a.config.Server.StartTimeout)); err != nil {
+			return i, fmt.Errorf("%s: %w", h.Name, err)
 		}
+		a.logger.Debug("Hook started", zap.String("hook", h.Name))
+	}
+	return len(a.hooks), nil
+}
 
-		if err != nil && err != http.ErrServerClosed {
-			a.logger.Fatal("Server failed to start", zap.Error(err))
+// ready runs OnReady hooks in order. They run after the server is up, so
+// a failure is logged rather than taking the service down.
+func (a *application) ready() {
+	for _, h := range a.hooks {
+		if h.OnReady == nil {
+			continue
+		}
+		if err := runHook(h.OnReady, a.timeout(h, a.config.Server.StartTimeout)); err != nil {
+			a.logger.Error("Hook failed when ready", zap.String("hook", h.Name), zap.Error(err))
+			continue
 		}
-	}()
-	// Wait for interrupt signal
-	<-sigChan
-	a.logger.Info("Shutting down server...")
-
-	// Create a context with timeout for graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), a.config.Server.ShutdownTimeout)
-	defer cancel()
-
-	// Attempt graceful shutdown
-	if err := a.server.Shutdown(ctx); err != nil {
-		a.logger.Error("Server forced to shutdown", zap.Error(err))
-	} else {
-		a.logger.Info("Server gracefully stopped")
+		a.logger.Debug("Hook ready", zap.String("hook", h.Name))
+	}
+}
+
+// stop runs the OnStop hooks of the first n hooks, phase by phase
+func (a *application) stop(n int) {
+	for _, phase := range phases {
+		a.stopPhase(phase, a.hooks[:n])
+	}
+}
+
+// stopPhase runs the phase's OnStop hooks in reverse order, within
+// server.shutdown_phases for the phase. A failing or slow hook is logged
+// and does not prevent the rest from stopping; once the phase is out of
+// time, its remaining hooks are skipped.
+func (a *application) stopPhase(phase Phase, hooks []Hook) {
+	var pending []Hook
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].OnStop != nil && hooks[i].Phase == phase {
+			pending = append(pending, hooks[i])
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	budget := a.phaseTimeout(phase)
+	start := time.Now()
+	logger := a.logger.With(zap.Stringer("phase", phase))
+	logger.Info("Shutdown phase started", zap.Int("hooks", len(pending)), zap.Duration("timeout", budget))
+
+	failed := 0
+	for _, h := range pending {
+		timeout := a.timeout(h, a.config.Server.ShutdownTimeout)
+		if budget > 0 {
+			remaining := budget - time.Since(start)
+			if remaining <= 0 {
+				logger.Error("Shutdown phase out of time, hook not stopped", zap.String("hook", h.Name))
+				failed++
+				continue
+			}
+			if timeout <= 0 || remaining < timeout {
+				timeout = remaining
+			}
+		}
+		hookStart := time.Now()
+		if err := runHook(h.OnStop, timeout); err != nil {
+			logger.Error("Hook failed to stop", zap.String("hook", h.Name), zap.Error(err))
+			failed++
+			continue
+		}
+		logger.Debug("Hook stopped", zap.String("hook", h.Name), zap.Duration("duration", time.Since(hookStart)))
+	}
+	logger.Info("Shutdown phase finished",
+		zap.Int("failed", failed),
+		zap.Duration("duration", time.Since(start)))
+}
+
+// phaseTimeout bounds a whole shutdown phase; zero leaves only the
+// per-hook timeouts
+func (a *application) phaseTimeout(phase Phase) time.Duration {
+	p := a.config.Server.ShutdownPhases
+	if p == nil {
+		return 0
+	}
+	switch phase {
+	case StopConsumers:
+		return p.Consumers
+	case StopFlush:
+		return p.Flush
+	case StopClose:
+		return p.Close
+	}
+	return 0
+}
+
+func (a *application) timeout(h Hook, fallback time.Duration) time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return fallback
+}
+
+// runHook calls fn with a deadline and gives up waiting once it passes,
+// so a hook that ignores its context cannot hang startup or shutdown
+func runHook(fn HookFunc, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
 	}
 }
@@ -0,0 +1,568 @@
+package app
+
+import (
+	"coffee-and-running/src/chaos"
+	"coffee-and-running/src/clients/httpclient"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/crash"
+	"coffee-and-running/src/health"
+	"coffee-and-running/src/observability/logger"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/observability/tracing"
+	"coffee-and-running/src/server"
+	"coffee-and-running/src/storage"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Module is an optional subsystem. Modules build their services from the
+// container, publish them with Provide for later modules, and register
+// routes, middleware, health checks and lifecycle hooks.
+type Module struct {
+	Name string
+	// Requires names modules that must be built first, and so start
+	// before it and stop after it. A required module that is disabled is
+	// skipped, so Resolve its services with the ok result when the
+	// dependency is optional. The core services can be named too, as
+	// "database", "metrics" and "tracing"; they always come first.
+	// Resolving a module's service without requiring it fails Install.
+	Requires []string
+	// Enabled reports whether the module applies to cfg; nil means always
+	Enabled func(cfg *config.Config) bool
+	Build   func(c *Container) error
+}
+
+// Container holds the core services every module may use and collects
+// what modules contribute to the application
+type Container struct {
+	Config     *config.Config
+	Logger     *zap.Logger
+	Stats      metrics.Agent
+	Tracer     tracing.Provider
+	Engine     storage.Engine
+	Clients    *httpclient.Factory
+	Checks     *health.Registry
+	Middleware *server.Registry
+	// Events carries in-process domain events between modules
+	Events *Bus
+	// Crash writes crash reports; nil unless crash_reports is enabled
+	Crash *crash.Reporter
+
+	routes   []func(chi.Router)
+	admin    []func(chi.Router)
+	servers  []Server
+	hooks    []Hook
+	warmers  []warmer
+	services map[reflect.Type]interface{}
+
+	// providers names the module that provided each service, empty for
+	// the core; building is the module being built and depends the
+	// modules it transitively requires, so Resolve can catch undeclared
+	// dependencies
+	providers  map[reflect.Type]string
+	building   string
+	depends    map[string]map[string]bool
+	undeclared []string
+	installed  []InstalledModule
+}
+
+// InstalledModule describes a module in the order Install built it, which
+// is also the order its hooks start; they stop in reverse
+type InstalledModule struct {
+	Name     string
+	Requires []string
+	Enabled  bool
+}
+
+// coreModules are the names modules may require for the container's core
+// services, which are built before any module
+var coreModules = map[string]bool{"database": true, "metrics": true, "tracing": true}
+
+// Option replaces a core service NewContainer would otherwise build from
+// the config
+type Option func(*options)
+
+type options struct {
+	logger *zap.Logger
+	stats  metrics.Agent
+}
+
+// WithLogger makes the container use lgr instead of building a logger
+func WithLogger(lgr *zap.Logger) Option {
+	return func(o *options) { o.logger = lgr }
+}
+
+// WithStats makes the container use stats instead of building a metrics
+// agent
+func WithStats(stats metrics.Agent) Option {
+	return func(o *options) { o.stats = stats }
+}
+
+// NewContainer builds the core services. They are closed after every
+// module hook has stopped.
+func NewContainer(cfg *config.Config, opts ...Option) (*Container, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	lgr := o.logger
+	if lgr == nil {
+		var err error
+		if lgr, err = logger.NewLogger(cfg.Logger); err != nil {
+			return nil, fmt.Errorf("failed to build app logger: %w", err)
+		}
+	}
+	// Set up before anything else logs, so reports hold every entry
+	var reporter *crash.Reporter
+	if cfg.Crash != nil && cfg.Crash.Enabled {
+		var err error
+		if reporter, err = crash.New(cfg.Crash, cfg); err != nil {
+			return nil, fmt.Errorf("failed to build app crash reporter: %w", err)
+		}
+		lgr = reporter.Wrap(lgr)
+	}
+	metricsAgent := o.stats
+	if metricsAgent == nil {
+		var err error
+		if metricsAgent, err = metrics.NewAgent(cfg.Metrics, lgr); err != nil {
+			return nil, fmt.Errorf("failed to build app metrics agent: %w", err)
+		}
+	}
+	tracer, err := tracing.NewProvider(cfg.Tracing, cfg.App, lgr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build app tracer provider: %w", err)
+	}
+	// With readiness gating the database may still be starting; the
+	// readiness hook waits for it instead of failing here
+	readiness := cfg.Server.Readiness
+	gated := readiness != nil && readiness.Enabled
+	newEngine := storage.NewEngine
+	if gated {
+		newEngine = storage.OpenEngine
+	}
+	// Fault injection wraps the database connections, so it is set up
+	// before the engine
+	var injector *chaos.Injector
+	engineOpts := []storage.Option{storage.WithApp(cfg.App)}
+	if cfg.Chaos != nil && cfg.Chaos.Enabled {
+		if injector, err = chaos.New(cfg.Chaos, cfg.App, lgr, metricsAgent); err != nil {
+			return nil, fmt.Errorf("failed to build app fault injector: %w", err)
+		}
+		engineOpts = append(engineOpts, storage.WithConnector(injector.WrapConnector))
+	}
+	engine, err := newEngine(cfg.Database, lgr, metricsAgent, engineOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build app storage engine: %w", err)
+	}
+
+	c := &Container{
+		Config:     cfg,
+		Logger:     lgr,
+		Stats:      metricsAgent,
+		Tracer:     tracer,
+		Engine:     engine,
+		Clients:    httpclient.NewFactory(cfg.Clients, lgr, metricsAgent, tracer),
+		Checks:     health.NewRegistry(cfg.Server.HealthTimeout),
+		Middleware: server.NewRegistry(),
+		Events:     NewBus(lgr, metricsAgent),
+		Crash:      reporter,
+		services:   make(map[reflect.Type]interface{}),
+		providers:  make(map[reflect.Type]string),
+	}
+	if h := cfg.Server.Health; h != nil {
+		c.Checks.SetCacheTTL(h.CacheTTL)
+		for name, check := range h.Checks {
+			var opts []health.Option
+			if check.Critical != nil {
+				opts = append(opts, health.Critical(*check.Critical))
+			}
+			c.Checks.Configure(name, append(opts, health.Timeout(check.Timeout))...)
+		}
+	}
+	c.Checks.Register("database", engine.Ping)
+	if w := cfg.Server.Warmup; w != nil && w.Enabled {
+		c.warmDatabase()
+	}
+	if checker, ok := metricsAgent.(metrics.HealthChecker); ok && cfg.Metrics.Enabled && cfg.Metrics.HealthCheck {
+		// Losing metrics shouldn't take the service out of rotation
+		c.Checks.Register("metrics", checker.Check, health.Critical(false))
+	}
+	if handler, ok := metricsAgent.(http.Handler); ok && cfg.Metrics.Path != "" {
+		c.MountAdmin(func(r chi.Router) {
+			r.Method(http.MethodGet, cfg.Metrics.Path, handler)
+		})
+	}
+	c.Mount(c.Checks.Mount)
+	if rotator, ok := engine.(storage.CredentialRotator); ok && cfg.Database.Credentials != nil && cfg.Database.Credentials.Source != "" {
+		c.MountAdmin(func(r chi.Router) {
+			r.Post("/admin/database/rotate-credentials", storage.RotateCredentialsHandler(rotator, lgr))
+		})
+	}
+	if partitioner, ok := engine.(storage.Partitioner); ok {
+		c.MountAdmin(func(r chi.Router) {
+			r.Get("/admin/database/pools", storage.PartitionStatsHandler(partitioner))
+		})
+	}
+	if injector != nil {
+		// Inside the logger and metrics, so injected faults are logged and
+		// counted like real ones
+		c.Middleware.Insert("chaos", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+			return injector.Middleware, nil
+		}, server.Before("recoverer"))
+	}
+	if cfg.Database.AuditContexts {
+		// Marks requests for the engine's context audit
+		c.Middleware.Insert("context_audit", func(config.MiddlewareOptions, server.Dependencies) (server.Middleware, error) {
+			return storage.MarkRequests, nil
+		}, server.First())
+	}
+
+	// Appended first so they stop last in their phases: the crash
+	// reporter covers the whole shutdown, and metrics and spans still
+	// buffered are flushed once the rest of the flush phase is done
+	if reporter != nil {
+		c.Append(ComponentHook("crash_reports", Closer(reporter)).InPhase(StopClose))
+	}
+	if o.stats == nil {
+		c.Append(Hook{Name: "metrics", OnStop: func(context.Context) error { metricsAgent.Close(); return nil }, Phase: StopFlush})
+	}
+	c.Append(Hook{Name: "tracing", OnStop: tracer.Shutdown, Phase: StopFlush})
+	c.Append(Hook{Name: "database", OnStop: func(context.Context) error { return engine.Close() }, Phase: StopClose})
+	// Drains after the modules publishing to it, and before the database
+	// its subscribers may use
+	c.Append(ComponentHook("events", c.Events).InPhase(StopFlush))
+
+	if gated {
+		// Runs before every module's start hook, against every check
+		// registered by then
+		c.Append(Hook{
+			Name: "readiness",
+			OnStart: func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, readiness.MaxWait)
+				defer cancel()
+				return c.Checks.WaitReady(ctx, readiness.InitialBackoff, readiness.MaxBackoff, lgr)
+			},
+			// Leave room for the final round of checks to report
+			Timeout: readiness.MaxWait + cfg.Server.HealthTimeout,
+		})
+	}
+	return c, nil
+}
+
+// Mount adds route registration functions to the server
+func (c *Container) Mount(routes ...func(chi.Router)) {
+	c.routes = append(c.routes, routes...)
+}
+
+// MountAdmin adds internal route registration functions, served on the
+// admin listener when server.admin is enabled. Otherwise they are served
+// on the public server only behind server.admin.token, and not at all
+// without one.
+func (c *Container) MountAdmin(routes ...func(chi.Router)) {
+	c.admin = append(c.admin, routes...)
+}
+
+// Serve runs an additional server, such as a gRPC listener or a job
+// worker, alongside the HTTP servers
+func (c *Container) Serve(s Server) {
+	c.servers = append(c.servers, s)
+}
+
+// Append registers a lifecycle hook
+func (c *Container) Append(h Hook) {
+	c.hooks = append(c.hooks, h)
+}
+
+// Component registers a Component's Start and Close as a hook
+func (c *Container) Component(name string, comp Component) {
+	c.Append(ComponentHook(name, comp))
+}
+
+// Provide publishes v under its static type T for modules built later:
+//
+//	app.Provide[*mailer.Mailer](c, mail)
+func Provide[T any](c *Container, v T) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	c.services[t] = v
+	c.providers[t] = c.building
+}
+
+// Resolve returns the service provided for T, if any. A module may only
+// resolve services of the modules it requires.
+func Resolve[T any](c *Container) (T, bool) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	v, ok := c.services[t]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	if provider := c.providers[t]; c.building != "" && provider != "" && provider != c.building && !c.depends[c.building][provider] {
+		c.undeclared = append(c.undeclared, fmt.Sprintf("%s from %s", t, provider))
+	}
+	return v.(T), true
+}
+
+// Install builds the enabled modules, each after the modules it
+// requires and otherwise in the order given
+func (c *Container) Install(modules ...Module) error {
+	ordered, err := order(modules)
+	if err != nil {
+		return err
+	}
+	c.depends = dependencies(ordered)
+	defer func() { c.building = "" }()
+
+	for _, m := range ordered {
+		enabled := m.Enabled == nil || m.Enabled(c.Config)
+		c.installed = append(c.installed, InstalledModule{Name: m.Name, Requires: m.Requires, Enabled: enabled})
+		if !enabled {
+			continue
+		}
+
+		c.building, c.undeclared = m.Name, nil
+		if err := m.Build(c); err != nil {
+			return fmt.Errorf("failed to build %s module: %w", m.Name, err)
+		}
+		if len(c.undeclared) > 0 {
+			return fmt.Errorf("module %q resolves %s without requiring it", m.Name, strings.Join(c.undeclared, ", "))
+		}
+		c.Logger.Debug("Module installed", zap.String("module", m.Name))
+	}
+	return nil
+}
+
+// Modules returns the modules given to Install in the order they were
+// built, including the disabled ones
+func (c *Container) Modules() []InstalledModule {
+	return c.installed
+}
+
+// Build creates the HTTP servers from the collected middleware and routes
+// and returns the application
+func (c *Container) Build() (Application, error) {
+	return c.build(true)
+}
+
+// BuildWorker returns an application that runs the hooks and the servers
+// registered with Serve, plus the admin listener if enabled, but not the
+// public HTTP server
+func (c *Container) BuildWorker() (Application, error) {
+	return c.build(false)
+}
+
+// Routers returns the public router and, when server.admin is enabled,
+// the admin router, without creating servers. The admin router runs the
+// pipeline without the public middleware, see server.SetupAdminRouter,
+// and also serves GET /debug/routes, listing the routes of both.
+func (c *Container) Routers() (public chi.Router, admin chi.Router, err error) {
+	public, err = c.publicRouter()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.adminEnabled() {
+		mux, err := server.SetupAdminRouter(c.adminConfig(), c.Middleware, c.deps(), c.adminRoutes()...)
+		if err != nil {
+			return nil, nil, err
+		}
+		mux.Get("/debug/routes", c.Middleware.RoutesHandler(map[string]chi.Routes{"http": public, "admin": mux}))
+		admin = mux
+	}
+	return public, admin, nil
+}
+
+// Start runs the hooks' start and ready phases without serving, for
+// tests and one-off commands, and returns a function that runs their stop
+// phase
+func (c *Container) Start() (stop func(), err error) {
+	a := New(c.Config, c.Logger, nil, c.hooks...).(*application)
+	started, err := a.start()
+	if err != nil {
+		a.stop(started)
+		return nil, err
+	}
+	a.ready()
+	return func() { a.stop(len(a.hooks)) }, nil
+}
+
+func (c *Container) build(public bool) (Application, error) {
+	cfg := c.Config.Server
+	var servers []Server
+
+	publicRouter, adminRouter, err := c.Routers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build app routers: %w", err)
+	}
+
+	if adminRouter != nil {
+		srv, err := server.NewWithHandler(c.adminConfig(), adminRouter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build app admin server: %w", err)
+		}
+		servers = append(servers, HTTP("admin", srv, "", ""))
+	}
+
+	// Last, so ready hooks such as consumers and schedulers have started
+	// before warmup, and readiness flips once it is done
+	var warmRouter http.Handler
+	if public {
+		warmRouter = publicRouter
+	}
+	hooks := append(append([]Hook{}, c.hooks...), c.warmupHook(warmRouter))
+
+	if public {
+		srv, err := server.NewWithHandler(cfg, publicRouter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build app server: %w", err)
+		}
+		var certFile, keyFile string
+		if cfg.TLS.Enabled {
+			certFile, keyFile = cfg.TLS.CertFile, cfg.TLS.KeyFile
+		}
+		listen := func() (net.Listener, error) { return server.Listen(cfg, c.Stats) }
+		servers = append(servers, HTTPListener("http", srv, listen, certFile, keyFile))
+	}
+
+	return New(c.Config, c.Logger, append(servers, c.servers...), hooks...), nil
+}
+
+func (c *Container) deps() server.Dependencies {
+	return server.Dependencies{
+		App:    c.Config.App,
+		Logger: c.Logger,
+		Stats:  c.Stats,
+		Tracer: c.Tracer,
+	}
+}
+
+func (c *Container) adminEnabled() bool {
+	return c.Config.Server.Admin != nil && c.Config.Server.Admin.Enabled
+}
+
+// adminConfig is the public server config on the admin address, without
+// TLS since the admin listener is internal
+func (c *Container) adminConfig() *config.ServerConfig {
+	admin := *c.Config.Server
+	admin.Host, admin.Port = c.Config.Server.Admin.Host, c.Config.Server.Admin.Port
+	admin.TLS = &config.TLSConfig{}
+	admin.Listener = nil
+	return &admin
+}
+
+func (c *Container) adminRoutes() []func(chi.Router) {
+	return append([]func(chi.Router){c.Checks.Mount}, c.admin...)
+}
+
+// publicRouter serves the admin routes too, behind server.admin.token,
+// when there is no admin listener
+func (c *Container) publicRouter() (*chi.Mux, error) {
+	if c.adminEnabled() || len(c.admin) == 0 {
+		return server.SetupRouter(c.Config.Server, c.Middleware, c.deps(), c.routes...)
+	}
+	token := ""
+	if c.Config.Server.Admin != nil {
+		token = c.Config.Server.Admin.Token
+	}
+	if token == "" {
+		c.Logger.Warn("Admin routes are not served; enable server.admin or set server.admin.token")
+		return server.SetupRouter(c.Config.Server, c.Middleware, c.deps(), c.routes...)
+	}
+	return server.SetupRouterWithAdmin(c.Config.Server, c.Middleware, c.deps(), requireToken(token), c.admin, c.routes...)
+}
+
+// requireToken rejects requests without "X-Admin-Token: <token>" with 401.
+// The token has a header of its own, leaving Authorization to the admin
+// routes, such as the admin UI's basic auth.
+func requireToken(token string) func(http.Handler) http.Handler {
+	want := []byte(token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), want) != 1 {
+				http.Error(w, "admin token required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// order sorts modules so each follows its requirements, keeping the given
+// order where requirements allow
+func order(modules []Module) ([]Module, error) {
+	byName := make(map[string]Module, len(modules))
+	for _, m := range modules {
+		if _, dup := byName[m.Name]; dup {
+			return nil, fmt.Errorf("module %q registered twice", m.Name)
+		}
+		byName[m.Name] = m
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(modules))
+	ordered := make([]Module, 0, len(modules))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("module dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		m, ok := byName[name]
+		if !ok {
+			if coreModules[name] && len(path) > 0 {
+				return nil
+			}
+			return fmt.Errorf("module %q requires unknown module %q", path[len(path)-1], name)
+		}
+
+		state[name] = visiting
+		for _, req := range m.Requires {
+			if err := visit(req, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, m)
+		return nil
+	}
+
+	for _, m := range modules {
+		if coreModules[m.Name] {
+			return nil, fmt.Errorf("module %q uses the name of a core service", m.Name)
+		}
+		if err := visit(m.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// dependencies returns the modules each module requires, directly or
+// through others. ordered must follow requirements, as order returns.
+func dependencies(ordered []Module) map[string]map[string]bool {
+	deps := make(map[string]map[string]bool, len(ordered))
+	for _, m := range ordered {
+		all := make(map[string]bool)
+		for _, req := range m.Requires {
+			all[req] = true
+			for dep := range deps[req] {
+				all[dep] = true
+			}
+		}
+		deps[m.Name] = all
+	}
+	return deps
+}
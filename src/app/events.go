@@ -0,0 +1,188 @@
+package app
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// defaultEventBuffer is how many events a subscriber may fall behind by
+const defaultEventBuffer = 64
+
+// Bus delivers in-process domain events, so modules such as cache
+// invalidation, websocket broadcast and audit can react to what others do
+// without depending on them. Events are routed by their Go type. Each
+// subscriber has its own goroutine and buffer, so a slow or panicking
+// subscriber doesn't hold up the publisher or the other subscribers.
+//
+// Delivery is at most once and lost on a crash; use the outbox for events
+// other services must see.
+type Bus struct {
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu     sync.RWMutex // guards subs and closed
+	subs   map[reflect.Type][]*subscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type subscription struct {
+	name    string
+	deliver func(ctx context.Context, event interface{})
+	events  chan envelope
+}
+
+type envelope struct {
+	ctx   context.Context
+	event interface{}
+}
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	buffer int
+}
+
+// WithBuffer sets how many events the subscriber may fall behind by
+// before further events to it are dropped; the default is 64
+func WithBuffer(n int) SubscribeOption {
+	return func(o *subscribeOptions) { o.buffer = n }
+}
+
+// NewBus creates a bus with no subscribers
+func NewBus(logger *zap.Logger, stats metrics.Agent) *Bus {
+	return &Bus{
+		logger: logger.With(zap.String("component", "events")),
+		stats:  stats,
+		subs:   make(map[reflect.Type][]*subscription),
+	}
+}
+
+// Subscribe calls fn with every event of type T published from now on,
+// on a goroutine of its own. name appears in logs and in the
+// events.<name>.delivered, .dropped and .panic metrics. The returned func
+// unsubscribes, after which events already buffered are still delivered.
+//
+//	app.Subscribe(c.Events, "posts.cache", func(ctx context.Context, e PostUpdated) {
+//		cache.Delete(ctx, "post:"+e.ID)
+//	})
+func Subscribe[T any](b *Bus, name string, fn func(ctx context.Context, event T), opts ...SubscribeOption) (unsubscribe func()) {
+	o := subscribeOptions{buffer: defaultEventBuffer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sub := &subscription{
+		name:    name,
+		deliver: func(ctx context.Context, event interface{}) { fn(ctx, event.(T)) },
+		events:  make(chan envelope, max(o.buffer, 1)),
+	}
+	key := reflect.TypeOf((*T)(nil)).Elem()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		b.logger.Warn("subscribed after the event bus closed", zap.String("subscriber", name))
+		return func() {}
+	}
+	b.subs[key] = append(b.subs[key], sub)
+	b.wg.Add(1)
+	go b.run(sub)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.unsubscribe(key, sub) })
+	}
+}
+
+// Publish hands event to every subscriber of type T without waiting for
+// them. A subscriber whose buffer is full misses the event, which is
+// logged and counted. Subscribers get ctx's values, such as the request
+// ID, but not its cancellation.
+func Publish[T any](ctx context.Context, b *Bus, event T) {
+	key := reflect.TypeOf((*T)(nil)).Elem()
+	msg := envelope{ctx: context.WithoutCancel(ctx), event: event}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs[key] {
+		select {
+		case sub.events <- msg:
+		default:
+			b.logger.Warn("subscriber is falling behind, dropped event",
+				zap.String("subscriber", sub.name),
+				zap.Stringer("event", key),
+				zap.Int("buffer", cap(sub.events)))
+			b.stats.Increment("events." + sub.name + ".dropped")
+		}
+	}
+}
+
+// Start implements Component; subscribers start as they subscribe
+func (b *Bus) Start() error {
+	return nil
+}
+
+// Close stops accepting subscriptions and events, then waits for the
+// subscribers to handle what is buffered
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for key, subs := range b.subs {
+			for _, sub := range subs {
+				close(sub.events)
+			}
+			delete(b.subs, key)
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+func (b *Bus) unsubscribe(key reflect.Type, sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[key]
+	for i, s := range subs {
+		if s == sub {
+			b.subs[key] = append(subs[:i:i], subs[i+1:]...)
+			close(sub.events)
+			return
+		}
+	}
+}
+
+// run delivers a subscriber's events in order until it is unsubscribed
+func (b *Bus) run(sub *subscription) {
+	defer b.wg.Done()
+	for msg := range sub.events {
+		if b.deliver(sub, msg) {
+			b.stats.Increment("events." + sub.name + ".delivered")
+		}
+	}
+}
+
+// deliver calls the subscriber, recovering a panic so it only loses the
+// event that caused it
+func (b *Bus) deliver(sub *subscription, msg envelope) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			b.logger.Error("event subscriber panicked",
+				zap.String("subscriber", sub.name),
+				zap.String("event", reflect.TypeOf(msg.event).String()),
+				zap.Any("panic", p),
+				zap.ByteString("stack", debug.Stack()))
+			b.stats.Increment("events." + sub.name + ".panic")
+			ok = false
+		}
+	}()
+	sub.deliver(msg.ctx, msg.event)
+	return true
+}
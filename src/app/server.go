@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// Server is a long-running unit the application runs next to others,
+// such as an HTTP or gRPC listener or a job worker. Servers are listened
+// in registration order before any of them serves, and shut down in
+// reverse order.
+type Server interface {
+	Name() string
+	// Listen binds the server's resources, e.g. its port
+	Listen() error
+	// Serve blocks until Shutdown is called, returning nil, or the server
+	// fails
+	Serve() error
+	// Shutdown stops the server gracefully, giving up when ctx is done
+	Shutdown(ctx context.Context) error
+}
+
+// Addresser is implemented by servers that listen on a network address,
+// so the address can be reported once bound. With port 0 it holds the
+// port the system chose.
+type Addresser interface {
+	// Addr returns nil until Listen succeeds
+	Addr() net.Addr
+}
+
+var (
+	_ Addresser = (*httpServer)(nil)
+	_ Addresser = (*grpcServer)(nil)
+)
+
+// httpServer runs an *http.Server
+type httpServer struct {
+	name     string
+	server   *http.Server
+	certFile string
+	keyFile  string
+	listen   func() (net.Listener, error)
+	listener net.Listener
+}
+
+// HTTP adapts srv to Server, listening on TCP at srv.Addr. Set certFile and
+// keyFile to serve TLS.
+func HTTP(name string, srv *http.Server, certFile, keyFile string) Server {
+	return &httpServer{name: name, server: srv, certFile: certFile, keyFile: keyFile}
+}
+
+// HTTPListener is HTTP listening on what listen binds instead, such as
+// server.Listen's unix socket or systemd socket
+func HTTPListener(name string, srv *http.Server, listen func() (net.Listener, error), certFile, keyFile string) Server {
+	return &httpServer{name: name, server: srv, listen: listen, certFile: certFile, keyFile: keyFile}
+}
+
+func (s *httpServer) Name() string { return s.name }
+
+func (s *httpServer) Listen() error {
+	listen := s.listen
+	if listen == nil {
+		listen = func() (net.Listener, error) { return net.Listen("tcp", s.server.Addr) }
+	}
+	l, err := listen()
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	return nil
+}
+
+func (s *httpServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *httpServer) Serve() error {
+	var err error
+	if s.certFile != "" {
+		err = s.server.ServeTLS(s.listener, s.certFile, s.keyFile)
+	} else {
+		err = s.server.Serve(s.listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *httpServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// grpcServer runs a *grpc.Server
+type grpcServer struct {
+	name     string
+	addr     string
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// GRPC adapts srv to Server, listening on addr
+func GRPC(name, addr string, srv *grpc.Server) Server {
+	return &grpcServer{name: name, addr: addr, server: srv}
+}
+
+func (s *grpcServer) Name() string { return s.name }
+
+func (s *grpcServer) Listen() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	return nil
+}
+
+func (s *grpcServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *grpcServer) Serve() error {
+	return s.server.Serve(s.listener)
+}
+
+// Shutdown waits for in-flight RPCs, then cancels them once ctx is done
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// worker runs a function until shutdown
+type worker struct {
+	name   string
+	run    func(ctx context.Context) error
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Worker adapts a blocking function, such as a job consumer loop, to
+// Server. fn must return once ctx is cancelled; returning earlier with an
+// error shuts the application down.
+func Worker(name string, fn func(ctx context.Context) error) Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &worker{name: name, run: fn, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+func (w *worker) Name() string { return w.name }
+
+func (w *worker) Listen() error { return nil }
+
+func (w *worker) Serve() error {
+	defer w.once.Do(func() { close(w.done) })
+	err := w.run(w.ctx)
+	if w.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (w *worker) Shutdown(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,165 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Policy decides whether a goroutine is restarted when it returns
+type Policy int
+
+const (
+	// Backoff restarts after a panic or error, waiting exponentially
+	// longer after each consecutive failure. A nil return ends it.
+	Backoff Policy = iota
+	// Always restarts after any return, waiting the initial delay
+	Always
+	// Never runs the function once
+	Never
+)
+
+// Counter is the part of metrics.Agent the supervisor uses. It is an
+// interface so the metrics agent can supervise its own goroutines.
+type Counter interface {
+	Increment(bucket string)
+}
+
+// Option configures a supervised goroutine
+type Option func(*options)
+
+type options struct {
+	policy  Policy
+	initial time.Duration
+	max     time.Duration
+}
+
+// WithPolicy sets the restart policy; the default is Backoff
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithBackoff sets the restart delays; the defaults are 1s and 1m
+func WithBackoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.initial = initial
+		o.max = max
+	}
+}
+
+// Supervisor runs named background goroutines until its context is
+// cancelled. Panics are recovered, logged with their stack and counted,
+// and the goroutine is restarted according to its policy.
+type Supervisor struct {
+	ctx    context.Context
+	logger *zap.Logger
+	stats  Counter
+	wg     sync.WaitGroup
+}
+
+// New creates a supervisor whose goroutines stop restarting, and are
+// expected to return, once ctx is cancelled. stats may be nil.
+func New(ctx context.Context, logger *zap.Logger, stats Counter) *Supervisor {
+	return &Supervisor{
+		ctx:    ctx,
+		logger: logger.With(zap.String("component", "supervisor")),
+		stats:  stats,
+	}
+}
+
+// Go runs fn in a goroutine. fn should return when its context is done.
+// name appears in logs and in the supervisor.<name>.panic and
+// supervisor.<name>.restart metrics.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context) error, opts ...Option) {
+	o := options{policy: Backoff, initial: time.Second, max: time.Minute}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.max < o.initial {
+		o.max = o.initial
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.supervise(name, fn, o)
+	}()
+}
+
+// Wait blocks until every goroutine has returned for good
+func (s *Supervisor) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Supervisor) supervise(name string, fn func(ctx context.Context) error, o options) {
+	logger := s.logger.With(zap.String("goroutine", name))
+	delay := o.initial
+
+	for {
+		started := time.Now()
+		err := s.run(name, fn, logger)
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case o.policy == Never:
+			if err != nil {
+				logger.Error("goroutine failed", zap.Error(err))
+			}
+			return
+		case o.policy == Backoff && err == nil:
+			return
+		case o.policy == Always:
+			delay = o.initial
+		default:
+			// A run that outlasted the longest delay was healthy, so
+			// start backing off from scratch
+			if time.Since(started) > o.max {
+				delay = o.initial
+			}
+		}
+
+		logger.Warn("restarting goroutine", zap.Error(err), zap.Duration("delay", delay))
+		s.increment(name, "restart")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if o.policy == Backoff {
+			if delay *= 2; delay > o.max {
+				delay = o.max
+			}
+		}
+	}
+}
+
+// run calls fn, converting a panic into an error
+func (s *Supervisor) run(name string, fn func(ctx context.Context) error, logger *zap.Logger) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("goroutine panicked",
+				zap.Any("panic", r),
+				zap.ByteString("stack", debug.Stack()))
+			s.increment(name, "panic")
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(s.ctx)
+}
+
+func (s *Supervisor) increment(name, event string) {
+	if s.stats != nil {
+		s.stats.Increment("supervisor." + name + "." + event)
+	}
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// warmer is a named warmup step
+type warmer struct {
+	name string
+	fn   HookFunc
+}
+
+// Warm registers a warmup step, such as priming a cache, run with the
+// others once the servers listen and before /readyz reports ready. Steps
+// run concurrently, only with server.warmup enabled, and should return
+// once ctx is done. A failing step is logged; the service becomes ready
+// regardless.
+func (c *Container) Warm(name string, fn HookFunc) {
+	c.warmers = append(c.warmers, warmer{name: name, fn: fn})
+}
+
+// warmDatabase registers a step filling the database pool, per
+// server.warmup.connections and statements
+func (c *Container) warmDatabase() {
+	cfg := c.Config.Server.Warmup
+	w, ok := c.Engine.(storage.Warmer)
+	if !ok || cfg.Connections < 0 {
+		return
+	}
+	conns := cfg.Connections
+	if conns == 0 {
+		conns = c.Config.Database.MaxIdleConns
+	}
+	if conns <= 0 {
+		// database/sql keeps two idle connections by default
+		conns = 2
+	}
+	c.Warm("database", func(ctx context.Context) error {
+		return w.Warm(ctx, conns, cfg.Statements)
+	})
+}
+
+// warmupHook returns the last ready hook, which warms up with router, nil
+// without a public server, and then marks the service ready
+func (c *Container) warmupHook(router http.Handler) Hook {
+	cfg := c.Config.Server.Warmup
+	if cfg == nil || !cfg.Enabled {
+		return Hook{Name: "warmup", OnReady: func(context.Context) error {
+			c.Checks.SetReady(true)
+			return nil
+		}}
+	}
+	return Hook{
+		Name: "warmup",
+		OnReady: func(ctx context.Context) error {
+			defer c.Checks.SetReady(true)
+			return c.warmup(ctx, router)
+		},
+		Timeout: cfg.Timeout,
+	}
+}
+
+// warmup runs the warmers concurrently, then the synthetic requests in
+// order
+func (c *Container) warmup(ctx context.Context, router http.Handler) error {
+	start := time.Now()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, w := range c.warmers {
+		wg.Add(1)
+		go func(w warmer) {
+			defer wg.Done()
+			stepStart := time.Now()
+			err := w.fn(ctx)
+			c.Stats.Timing("app.warmup."+w.name+".duration", time.Since(stepStart))
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", w.name, err))
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	requests := c.Config.Server.Warmup.Requests
+	if router != nil {
+		for _, r := range requests {
+			if err := c.warmupRequest(ctx, router, r.Method, r.Path, r.Headers, r.Body, r.Repeat, r.Status); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if len(requests) > 0 {
+		c.Logger.Debug("no public server, skipping warmup requests")
+	}
+
+	c.Stats.Timing("app.warmup.duration", time.Since(start))
+	c.Stats.Count("app.warmup.failed", len(errs))
+	c.Logger.Info("warmup finished",
+		zap.Int("warmers", len(c.warmers)),
+		zap.Int("requests", len(requests)),
+		zap.Int("failed", len(errs)),
+		zap.Duration("duration", time.Since(start)))
+	return errors.Join(errs...)
+}
+
+// warmupRequest serves a synthetic request through router repeat times,
+// at least once, checking the status of each response
+func (c *Container) warmupRequest(ctx context.Context, router http.Handler, method, path string, headers map[string]string, body string, repeat, status int) error {
+	if method == "" {
+		method = http.MethodGet
+	}
+	for i := 0; i < max(repeat, 1); i++ {
+		req, err := http.NewRequestWithContext(ctx, method, path, strings.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("warmup request %s %s: %w", method, path, err)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "warmup")
+		}
+		// As the server would set them, for the access log
+		req.RequestURI, req.Host = path, "localhost"
+		req.RemoteAddr = "127.0.0.1:0"
+
+		w := &discardWriter{header: make(http.Header)}
+		router.ServeHTTP(w, req)
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if (status != 0 && w.status != status) || (status == 0 && w.status >= 500) {
+			return fmt.Errorf("warmup request %s %s: got status %d", method, path, w.status)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// discardWriter records the status of a synthetic response and drops its
+// body
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
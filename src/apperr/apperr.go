@@ -0,0 +1,171 @@
+// Package apperr classifies errors by kind so every layer handles them the
+// same way: storage maps driver errors to kinds, and the HTTP and gRPC
+// mappers turn kinds into statuses without knowing where an error came
+// from. Errors of any kind match it with errors.Is:
+//
+//	if errors.Is(err, apperr.NotFound) { ... }
+package apperr
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Kind is a category of failure. Kinds are errors themselves, so they can
+// be returned bare or used as errors.Is targets.
+type Kind string
+
+// Kinds of failure. An error of no kind is an internal error.
+const (
+	NotFound     Kind = "not_found"
+	Conflict     Kind = "conflict"
+	Invalid      Kind = "invalid"
+	Unauthorized Kind = "unauthorized"
+	Unavailable  Kind = "unavailable"
+)
+
+func (k Kind) Error() string {
+	return strings.ReplaceAll(string(k), "_", " ")
+}
+
+// Error is a failure of a known kind. Message is safe to show clients;
+// Meta carries details for logs, such as the constraint that failed, and
+// is never sent to clients.
+type Error struct {
+	Kind    Kind
+	Op      string // what failed, e.g. "storage"
+	Message string
+	Meta    map[string]string
+	Err     error
+}
+
+// New returns an error of kind, e.g. for a package's sentinel errors:
+//
+//	var ErrNotFound = apperr.New(apperr.NotFound, "users", "user not found")
+func New(kind Kind, op, message string) *Error {
+	return &Error{Kind: kind, Op: op, Message: message}
+}
+
+// Wrap classifies err as kind, keeping it in the chain. It returns nil for
+// a nil err.
+func Wrap(err error, kind Kind, op, message string) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Op: op, Message: message, Err: err}
+}
+
+// With returns a copy of e with key set in its metadata
+func (e *Error) With(key, value string) *Error {
+	c := *e
+	c.Meta = make(map[string]string, len(e.Meta)+1)
+	for k, v := range e.Meta {
+		c.Meta[k] = v
+	}
+	c.Meta[key] = value
+	return &c
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Op != "" {
+		b.WriteString(e.Op)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.message())
+	if len(e.Meta) > 0 {
+		keys := make([]string, 0, len(e.Meta))
+		for k := range e.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString(" (")
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(e.Meta[k])
+		}
+		b.WriteByte(')')
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, kind) true for e's kind
+func (e *Error) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k == e.Kind
+}
+
+func (e *Error) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Kind.Error()
+}
+
+// KindOf returns the kind of the first classified error in err's chain, or
+// "" for an internal error
+func KindOf(err error) Kind {
+	for err != nil {
+		switch e := err.(type) {
+		case *Error:
+			if e.Kind != "" {
+				return e.Kind
+			}
+		case Kind:
+			return e
+		}
+		// Errors that opt into a kind through Is, such as
+		// *storage.ConflictError
+		for _, k := range []Kind{NotFound, Conflict, Invalid, Unauthorized, Unavailable} {
+			if is, ok := err.(interface{ Is(error) bool }); ok && is.Is(k) {
+				return k
+			}
+		}
+		err = errors.Unwrap(err)
+	}
+	return ""
+}
+
+// Message returns the client-safe message of err: that of its first
+// classified error, or "" for an internal error, whose text may reveal
+// details and shouldn't be shown
+func Message(err error) string {
+	var e *Error
+	if errors.As(err, &e) && e.Kind != "" {
+		return e.message()
+	}
+	if k := KindOf(err); k != "" {
+		return k.Error()
+	}
+	return ""
+}
+
+// MetaOf merges the metadata of every classified error in err's chain,
+// outer values winning
+func MetaOf(err error) map[string]string {
+	meta := make(map[string]string)
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			for k, v := range e.Meta {
+				if _, set := meta[k]; !set {
+					meta[k] = v
+				}
+			}
+		}
+		err = errors.Unwrap(err)
+	}
+	return meta
+}
@@ -0,0 +1,83 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCCode returns the status code for err's kind
+func GRPCCode(err error) codes.Code {
+	switch KindOf(err) {
+	case NotFound:
+		return codes.NotFound
+	case Conflict:
+		return codes.Aborted
+	case Invalid:
+		return codes.InvalidArgument
+	case Unauthorized:
+		return codes.Unauthenticated
+	case Unavailable:
+		return codes.Unavailable
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+	return codes.Internal
+}
+
+// GRPCStatus converts err to a status carrying its client-safe message.
+// Errors that already are statuses are returned unchanged.
+func GRPCStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := GRPCCode(err)
+	message := Message(err)
+	if message == "" {
+		message = "internal error"
+		if code != codes.Internal {
+			message = code.String()
+		}
+	}
+	return status.Error(code, message)
+}
+
+// UnaryServerInterceptor converts handler errors with GRPCStatus, logging
+// internal ones with their full text:
+//
+//	grpc.NewServer(grpc.ChainUnaryInterceptor(apperr.UnaryServerInterceptor(logger)))
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, convert(logger, info.FullMethod, err)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return convert(logger, info.FullMethod, handler(srv, ss))
+	}
+}
+
+func convert(logger *zap.Logger, method string, err error) error {
+	converted := GRPCStatus(err)
+	if converted == nil || converted == err {
+		return converted
+	}
+	if status.Code(converted) == codes.Internal {
+		logger.Error("grpc call failed", zap.String("method", method), zap.Error(err))
+	}
+	return converted
+}
@@ -0,0 +1,41 @@
+package apperr
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HTTPStatus returns the status code for err's kind
+func HTTPStatus(err error) int {
+	switch KindOf(err) {
+	case NotFound:
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case Invalid:
+		return http.StatusBadRequest
+	case Unauthorized:
+		return http.StatusUnauthorized
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// WriteHTTP responds with err's status and client-safe message as
+// {"error": ..., "kind": ...}. Internal errors get a generic message; log
+// them before calling this.
+func WriteHTTP(w http.ResponseWriter, err error) {
+	status := HTTPStatus(err)
+	body := map[string]string{"error": "internal error"}
+	if kind := KindOf(err); kind != "" {
+		body["error"] = Message(err)
+		body["kind"] = string(kind)
+	}
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+)
+
+type claimsKey struct{}
+
+// Claims are the verified claims of the authenticated caller, e.g. from a
+// JWT or session
+type Claims map[string]interface{}
+
+// String returns a claim as a string. Numeric claims are formatted, so
+// "tenant_id": 42 reads as "42".
+func (c Claims) String(name string) (string, bool) {
+	switch v := c[name].(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return fmt.Sprintf("%.0f", v), true
+	case int, int64:
+		return fmt.Sprintf("%d", v), true
+	default:
+		return "", false
+	}
+}
+
+// NewContext returns a copy of ctx carrying claims. Authentication
+// middleware calls this once the caller's credentials have been verified.
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored in ctx, or nil for anonymous requests
+func FromContext(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
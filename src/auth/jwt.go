@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed,
+// expired, or issued for another service
+var ErrInvalidToken = errors.New("auth: invalid token")
+
+// leeway tolerates small clock differences between issuing and verifying
+// hosts
+const leeway = 30 * time.Second
+
+// JWT issues and verifies HS256 access tokens
+type JWT struct {
+	secret   []byte
+	issuer   string
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// NewJWT creates a token issuer. The secret should be at least 32 random
+// bytes.
+func NewJWT(secret, issuer, audience string, ttl time.Duration) (*JWT, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("jwt secret must be at least 32 bytes")
+	}
+	return &JWT{
+		secret:   []byte(secret),
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+		now:      time.Now,
+	}, nil
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue signs a token for subject. Extra claims are added as-is but cannot
+// override the registered claims. Every token gets a random "jti" so it
+// can be revoked on its own.
+func (j *JWT) Issue(subject string, extra Claims) (string, time.Time, error) {
+	now := j.now()
+	expires := now.Add(j.ttl)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	claims := Claims{}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	claims["sub"] = subject
+	claims["iat"] = now.Unix()
+	claims["exp"] = expires.Unix()
+	claims["jti"] = base64.RawURLEncoding.EncodeToString(id)
+	if j.issuer != "" {
+		claims["iss"] = j.issuer
+	}
+	if j.audience != "" {
+		claims["aud"] = j.audience
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + j.sign(unsigned), expires, nil
+}
+
+// Verify checks the signature and registered claims and returns the claims
+func (j *JWT) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	// Only HS256 is accepted; the header is compared rather than parsed so
+	// "alg": "none" and algorithm confusion are impossible
+	if parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(j.sign(parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := j.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return nil, ErrInvalidToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, ErrInvalidToken
+	}
+	if j.issuer != "" && claims["iss"] != j.issuer {
+		return nil, ErrInvalidToken
+	}
+	if j.audience != "" && claims["aud"] != j.audience {
+		return nil, ErrInvalidToken
+	}
+	if sub, _ := claims.String("sub"); sub == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (j *JWT) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
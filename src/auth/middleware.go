@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Verifier checks an access token and returns its claims
+type Verifier interface {
+	Verify(token string) (Claims, error)
+}
+
+// RevocationList reports whether a verified token has been revoked before
+// it expired, e.g. by logging out
+type RevocationList interface {
+	Revoked(ctx context.Context, claims Claims) (bool, error)
+}
+
+// Middleware authenticates requests carrying an "Authorization: Bearer"
+// token and stores the claims in the request context. Requests without a
+// token pass through anonymously; use Require to protect routes. Invalid
+// and revoked tokens are rejected with 401 rather than treated as
+// anonymous. revocations may be nil; when the list cannot be checked the
+// request is rejected with 503.
+func Middleware(verifier Verifier, revocations RevocationList) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				unauthorized(w, "unsupported authorization scheme")
+				return
+			}
+			claims, err := verifier.Verify(strings.TrimSpace(token))
+			if err != nil {
+				unauthorized(w, "invalid token")
+				return
+			}
+			if revocations != nil {
+				revoked, err := revocations.Revoked(r.Context(), claims)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					json.NewEncoder(w).Encode(map[string]string{"error": "unable to verify token"})
+					return
+				}
+				if revoked {
+					unauthorized(w, "token has been revoked")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+		})
+	}
+}
+
+// Require rejects anonymous requests with 401
+func Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) == nil {
+			unauthorized(w, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Subject returns the "sub" claim of the authenticated caller
+func Subject(r *http.Request) string {
+	sub, _ := FromContext(r.Context()).String("sub")
+	return sub
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
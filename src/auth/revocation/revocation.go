@@ -0,0 +1,252 @@
+// Package revocation keeps a list of revoked access tokens in Redis, so
+// stateless JWTs can be invalidated before they expire. A single token is
+// revoked by its "jti" claim, and every token of a subject issued up to a
+// point in time by a cut-off, for "log out everywhere".
+//
+// Lookups are cached in each instance for revocation.cache_ttl. Instances
+// announce revocations on a Redis channel and drop their own cached
+// answers when they hear one, so no instance coordinates the others; the
+// cache TTL bounds how stale an instance can be if it misses a message.
+package revocation
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/cache"
+	"coffee-and-running/src/cache/redis"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// leeway matches the clock skew tolerated by auth.JWT, so revocations
+// outlive every token they cover
+const leeway = 30 * time.Second
+
+// ErrNoTokenID is returned when revoking a token without a "jti" claim,
+// such as one issued before token IDs were added
+var ErrNoTokenID = errors.New("revocation: token has no jti claim")
+
+// List is a Redis-backed revocation list. It satisfies auth.RevocationList.
+type List struct {
+	config   *config.RevocationConfig
+	tokenTTL time.Duration
+	client   *redis.Client
+	tokens   *cache.Memory[bool]
+	subjects *cache.Memory[int64]
+	logger   *zap.Logger
+	stats    metrics.Agent
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var _ auth.RevocationList = (*List)(nil)
+
+// New creates a revocation list. tokenTTL is the lifetime of issued
+// tokens, auth.jwt.ttl, which is how long a subject's cut-off must be
+// kept.
+func New(cfg *config.RevocationConfig, tokenTTL time.Duration, client *redis.Client, logger *zap.Logger, stats metrics.Agent) *List {
+	return &List{
+		config:   cfg,
+		tokenTTL: tokenTTL,
+		client:   client,
+		tokens:   cache.NewMemory[bool]("revoked_tokens", cfg.CacheSize, cfg.CacheTTL, stats),
+		subjects: cache.NewMemory[int64]("revoked_subjects", cfg.CacheSize, cfg.CacheTTL, stats),
+		logger:   logger.With(zap.String("component", "token_revocation")),
+		stats:    stats,
+	}
+}
+
+// Revoke revokes the token described by claims until it expires, e.g. on
+// logout
+func (l *List) Revoke(ctx context.Context, claims auth.Claims) error {
+	jti, ok := claims.String("jti")
+	if !ok {
+		return ErrNoTokenID
+	}
+	ttl := leeway
+	if exp, ok := claims["exp"].(float64); ok {
+		ttl += time.Until(time.Unix(int64(exp), 0))
+	}
+	if ttl <= 0 {
+		// Already expired
+		return nil
+	}
+
+	if err := l.client.Set(ctx, l.key("jti", jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	l.tokens.Set(ctx, jti, true, 0)
+	l.announce(ctx, "jti:"+jti)
+	l.stats.Increment("auth.revocation.token")
+	return nil
+}
+
+// RevokeSubject revokes every token issued to subject so far, logging it
+// out everywhere. Tokens carry whole-second issue times, so a token issued
+// in the same second as the revocation is revoked too.
+func (l *List) RevokeSubject(ctx context.Context, subject string) error {
+	cutoff := time.Now().Unix()
+	if err := l.client.Set(ctx, l.key("sub", subject), cutoff, l.tokenTTL+leeway).Err(); err != nil {
+		return fmt.Errorf("failed to revoke tokens of %s: %w", subject, err)
+	}
+	l.subjects.Set(ctx, subject, cutoff, 0)
+	l.announce(ctx, "sub:"+subject)
+	l.stats.Increment("auth.revocation.subject")
+	l.logger.Info("revoked all tokens of subject", zap.String("subject", subject))
+	return nil
+}
+
+// Revoked implements auth.RevocationList. Tokens without an issue time are
+// treated as revoked once their subject has a cut-off. When Redis cannot
+// be reached the error is returned, unless revocation.fail_open is set.
+func (l *List) Revoked(ctx context.Context, claims auth.Claims) (bool, error) {
+	revoked, err := l.lookup(ctx, claims)
+	if err != nil {
+		l.stats.Increment("auth.revocation.error")
+		if l.config.FailOpen {
+			l.logger.Warn("failed to check token revocation, accepting token", zap.Error(err))
+			return false, nil
+		}
+		l.logger.Error("failed to check token revocation", zap.Error(err))
+		return false, err
+	}
+	if revoked {
+		l.stats.Increment("auth.revocation.rejected")
+	}
+	return revoked, nil
+}
+
+func (l *List) lookup(ctx context.Context, claims auth.Claims) (bool, error) {
+	jti, hasJTI := claims.String("jti")
+	sub, _ := claims.String("sub")
+
+	tokenRevoked, tokenCached := false, !hasJTI
+	if hasJTI {
+		tokenRevoked, tokenCached, _ = l.tokens.Get(ctx, jti)
+		if tokenRevoked {
+			return true, nil
+		}
+	}
+	cutoff, subjectCached, _ := l.subjects.Get(ctx, sub)
+
+	if !tokenCached || !subjectCached {
+		// One round trip for both keys; pipelines are split by slot in
+		// cluster mode
+		pipe := l.client.Pipeline()
+		var exists *goredis.IntCmd
+		if !tokenCached {
+			exists = pipe.Exists(ctx, l.key("jti", jti))
+		}
+		var get *goredis.StringCmd
+		if !subjectCached {
+			get = pipe.Get(ctx, l.key("sub", sub))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return false, err
+		}
+
+		if exists != nil {
+			tokenRevoked = exists.Val() > 0
+			l.tokens.Set(ctx, jti, tokenRevoked, 0)
+		}
+		if get != nil {
+			cutoff = 0
+			if v, err := get.Result(); err == nil {
+				if cutoff, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return false, fmt.Errorf("malformed revocation cut-off for %s: %w", sub, err)
+				}
+			}
+			l.subjects.Set(ctx, sub, cutoff, 0)
+		}
+	}
+
+	if tokenRevoked {
+		return true, nil
+	}
+	if cutoff == 0 {
+		return false, nil
+	}
+	iat, ok := claims["iat"].(float64)
+	return !ok || int64(iat) <= cutoff, nil
+}
+
+// Start listens for revocations made by other instances
+func (l *List) Start() error {
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	sub := l.client.Subscribe(l.ctx, l.key("events"))
+	if _, err := sub.Receive(l.ctx); err != nil {
+		sub.Close()
+		l.cancel()
+		return fmt.Errorf("failed to subscribe to revocations: %w", err)
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				l.apply(msg.Payload)
+			}
+		}
+	}()
+
+	l.logger.Info("token revocation list started", zap.String("prefix", l.config.Prefix))
+	return nil
+}
+
+// Close stops listening for revocations
+func (l *List) Close() error {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.wg.Wait()
+	return nil
+}
+
+// announce tells other instances to forget what they cached about a token
+// or subject. Failing to announce is not fatal: their caches expire.
+func (l *List) announce(ctx context.Context, event string) {
+	if err := l.client.Publish(ctx, l.key("events"), event).Err(); err != nil {
+		l.logger.Warn("failed to announce revocation", zap.String("event", event), zap.Error(err))
+	}
+}
+
+// apply drops the cached answer named by an announced event, so the next
+// lookup reads Redis
+func (l *List) apply(event string) {
+	kind, id, ok := strings.Cut(event, ":")
+	if !ok {
+		l.logger.Warn("ignoring malformed revocation event", zap.String("event", event))
+		return
+	}
+	switch kind {
+	case "jti":
+		l.tokens.Delete(l.ctx, id)
+	case "sub":
+		l.subjects.Delete(l.ctx, id)
+	}
+}
+
+func (l *List) key(parts ...string) string {
+	return l.config.Prefix + ":" + strings.Join(parts, ":")
+}
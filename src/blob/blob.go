@@ -0,0 +1,163 @@
+package blob
+
+import (
+	"bufio"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("blob: object not found")
+
+// Object describes a stored object
+type Object struct {
+	Key          string            `json:"key"`
+	ContentType  string            `json:"content_type"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// PutOptions customizes an upload. ContentType is detected from the key's
+// extension, then the content, when empty.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+}
+
+// Store is an object storage backend. Objects are streamed in both
+// directions; callers must close readers returned by Get.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, opts *PutOptions) (*Object, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a time-limited URL that allows method (GET or PUT)
+	// on key without credentials
+	SignedURL(ctx context.Context, key, method string, expires time.Duration) (string, error)
+}
+
+// detectContentType returns the content type for an upload and a reader
+// that still yields the full content
+func detectContentType(key string, r io.Reader, opts *PutOptions) (string, io.Reader) {
+	if opts != nil && opts.ContentType != "" {
+		return opts.ContentType, r
+	}
+	if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+		return byExt, r
+	}
+
+	// Sniff without consuming: http.DetectContentType reads at most 512 bytes
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	return http.DetectContentType(head), br
+}
+
+// validateKey rejects keys that could escape a prefix or directory
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("blob: invalid key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("blob: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// instrumented records latency, errors and bytes for every operation
+type instrumented struct {
+	store  Store
+	prefix string
+	stats  metrics.Agent
+}
+
+// WithMetrics wraps store so every operation emits
+// "blob.<backend>.<operation>.{duration,error}" and transferred bytes
+func WithMetrics(store Store, backend string, stats metrics.Agent) Store {
+	return &instrumented{store: store, prefix: "blob." + backend, stats: stats}
+}
+
+func (s *instrumented) observe(op string, start time.Time, err error) {
+	s.stats.Timing(s.prefix+"."+op+".duration", time.Since(start))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		s.stats.Increment(s.prefix + "." + op + ".error")
+	}
+}
+
+func (s *instrumented) Put(ctx context.Context, key string, r io.Reader, opts *PutOptions) (*Object, error) {
+	start := time.Now()
+	counter := &countingReader{r: r}
+	obj, err := s.store.Put(ctx, key, counter, opts)
+	s.observe("put", start, err)
+	if err == nil {
+		s.stats.Count(s.prefix+".put.bytes", counter.n)
+	}
+	return obj, err
+}
+
+func (s *instrumented) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	start := time.Now()
+	rc, obj, err := s.store.Get(ctx, key)
+	s.observe("get", start, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingReadCloser{ReadCloser: rc, onClose: func(n int64) {
+		s.stats.Count(s.prefix+".get.bytes", n)
+	}}, obj, nil
+}
+
+func (s *instrumented) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, key)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *instrumented) SignedURL(ctx context.Context, key, method string, expires time.Duration) (string, error) {
+	start := time.Now()
+	url, err := s.store.SignedURL(ctx, key, method, expires)
+	s.observe("signed_url", start, err)
+	return url, err
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReadCloser reports bytes read when closed
+type countingReadCloser struct {
+	io.ReadCloser
+	n       int64
+	onClose func(int64)
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	c.onClose(c.n)
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,140 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCS stores objects in a Google Cloud Storage bucket. Uploads are
+// streamed with resumable chunked writes.
+type GCS struct {
+	bucket *storage.BucketHandle
+	client *storage.Client
+	prefix string
+}
+
+var _ Store = (*GCS)(nil)
+
+// NewGCS creates a GCS store. An empty credentialsFile uses Application
+// Default Credentials.
+func NewGCS(ctx context.Context, bucket, prefix, credentialsFile string) (*GCS, error) {
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcs client: %w", err)
+	}
+
+	return &GCS{
+		bucket: client.Bucket(bucket),
+		client: client,
+		prefix: prefix,
+	}, nil
+}
+
+// Put streams r to the bucket
+func (g *GCS) Put(ctx context.Context, key string, r io.Reader, opts *PutOptions) (*Object, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	contentType, r := detectContentType(key, r, opts)
+	w := g.bucket.Object(g.prefix + key).NewWriter(ctx)
+	w.ContentType = contentType
+	if opts != nil {
+		w.Metadata = opts.Metadata
+		w.CacheControl = opts.CacheControl
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	return toObject(key, w.Attrs()), nil
+}
+
+// Get streams the object from the bucket
+func (g *GCS) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := validateKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	handle := g.bucket.Object(g.prefix + key)
+	attrs, err := handle.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+
+	// Pin the generation so content and attributes always match
+	rc, err := handle.Generation(attrs.Generation).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return rc, toObject(key, attrs), nil
+}
+
+// Delete removes the object; deleting a missing object is not an error
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	err := g.bucket.Object(g.prefix + key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+// SignedURL returns a V4 signed URL. Signing uses the client's service
+// account credentials, or the IAM signBlob API when running with ADC.
+func (g *GCS) SignedURL(ctx context.Context, key, method string, expires time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("blob: unsupported signed URL method %s", method)
+	}
+
+	return g.bucket.SignedURL(g.prefix+key, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  method,
+		Expires: time.Now().Add(expires),
+	})
+}
+
+// Close releases the underlying client
+func (g *GCS) Close() error {
+	return g.client.Close()
+}
+
+func toObject(key string, attrs *storage.ObjectAttrs) *Object {
+	return &Object{
+		Key:          key,
+		ContentType:  attrs.ContentType,
+		Size:         attrs.Size,
+		ETag:         attrs.Etag,
+		LastModified: attrs.Updated,
+		Metadata:     attrs.Metadata,
+	}
+}
@@ -0,0 +1,270 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// metaDir holds per-object metadata alongside the data
+const metaDir = ".meta"
+
+// Local stores objects as files under a directory. It is meant for
+// development and single-instance deployments; signed URLs are served by
+// the Local store's own handler (see Mount).
+type Local struct {
+	dir        string
+	baseURL    string
+	signingKey []byte
+	logger     *zap.Logger
+}
+
+var _ Store = (*Local)(nil)
+
+// NewLocal creates a filesystem store rooted at dir. baseURL is the
+// externally visible URL of the service, used for signed URLs.
+func NewLocal(dir, baseURL, signingKey string, logger *zap.Logger) (*Local, error) {
+	if err := os.MkdirAll(filepath.Join(dir, metaDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &Local{
+		dir:        dir,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		signingKey: []byte(signingKey),
+		logger:     logger.With(zap.String("component", "blob.local")),
+	}, nil
+}
+
+// Put streams r to a temporary file and renames it into place, so readers
+// never see a partial object
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, opts *PutOptions) (*Object, error) {
+	if err := l.checkKey(key); err != nil {
+		return nil, err
+	}
+
+	contentType, r := detectContentType(key, r, opts)
+	target := l.dataPath(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, contextReader{ctx: ctx, r: r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	obj := &Object{
+		Key:          key,
+		ContentType:  contentType,
+		Size:         size,
+		LastModified: time.Now().UTC(),
+	}
+	if opts != nil {
+		obj.Metadata = opts.Metadata
+	}
+	if err := l.writeMeta(obj); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return nil, fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return obj, nil
+}
+
+// Get opens the object for reading
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := l.checkKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(l.dataPath(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj, err := l.readMeta(key, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, obj, nil
+}
+
+// Delete removes the object; deleting a missing object is not an error
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := l.checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(l.dataPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(l.metaPath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL returns an HMAC-signed URL served by Mount
+func (l *Local) SignedURL(ctx context.Context, key, method string, expires time.Duration) (string, error) {
+	if err := l.checkKey(key); err != nil {
+		return "", err
+	}
+	if len(l.signingKey) == 0 {
+		return "", errors.New("blob: local signing_key is required for signed URLs")
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("blob: unsupported signed URL method %s", method)
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {l.sign(method, key, expiresAt)},
+	}
+	return l.baseURL + "/blobs/" + key + "?" + query.Encode(), nil
+}
+
+// Mount registers GET and PUT /blobs/* for signed URLs
+func (l *Local) Mount(r chi.Router) {
+	r.Get("/blobs/*", l.serve)
+	r.Put("/blobs/*", l.serve)
+}
+
+func (l *Local) serve(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	expiresAt := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires ||
+		!hmac.Equal([]byte(signature), []byte(l.sign(r.Method, key, expiresAt))) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		opts := &PutOptions{ContentType: r.Header.Get("Content-Type")}
+		if _, err := l.Put(r.Context(), key, r.Body, opts); err != nil {
+			l.logger.Error("signed upload failed", zap.String("key", key), zap.Error(err))
+			http.Error(w, "upload failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rc, obj, err := l.Get(r.Context(), key)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		l.logger.Error("signed download failed", zap.String("key", key), zap.Error(err))
+		http.Error(w, "download failed", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", obj.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	io.Copy(w, rc)
+}
+
+func (l *Local) sign(method, key, expiresAt string) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, key, expiresAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkKey validates key and reserves the metadata directory
+func (l *Local) checkKey(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	if key == metaDir || strings.HasPrefix(key, metaDir+"/") {
+		return fmt.Errorf("blob: key %q is reserved", key)
+	}
+	return nil
+}
+
+func (l *Local) dataPath(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+func (l *Local) metaPath(key string) string {
+	return filepath.Join(l.dir, metaDir, filepath.FromSlash(key)+".json")
+}
+
+func (l *Local) writeMeta(obj *Object) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	p := l.metaPath(obj.Key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+// readMeta loads stored metadata, falling back to the file's own stat for
+// objects written outside the store
+func (l *Local) readMeta(key string, f *os.File) (*Object, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{Key: key}
+	if data, err := os.ReadFile(l.metaPath(key)); err == nil {
+		if err := json.Unmarshal(data, obj); err != nil {
+			return nil, fmt.Errorf("corrupt metadata for %s: %w", key, err)
+		}
+	}
+	obj.Size = info.Size()
+	obj.LastModified = info.ModTime().UTC()
+	if obj.ContentType == "" {
+		obj.ContentType = "application/octet-stream"
+	}
+	return obj, nil
+}
+
+// contextReader stops a copy when ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,161 @@
+package blob
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 stores objects in an S3 bucket or an S3-compatible service such as
+// MinIO. Uploads of unknown size are streamed as multipart uploads.
+type S3 struct {
+	bucket   string
+	prefix   string
+	client   *s3.Client
+	uploader *transfermanager.Client
+	presign  *s3.PresignClient
+}
+
+var _ Store = (*S3)(nil)
+
+// NewS3 creates an S3 store using the default AWS credential chain
+func NewS3(ctx context.Context, cfg *config.S3BlobConfig, bucket, prefix string) (*S3, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.ForcePathStyle
+	})
+
+	return &S3{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   client,
+		uploader: transfermanager.New(client),
+		presign:  s3.NewPresignClient(client),
+	}, nil
+}
+
+// Put streams r to the bucket
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, opts *PutOptions) (*Object, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	contentType, r := detectContentType(key, r, opts)
+	counter := &countingReader{r: r}
+	input := &transfermanager.UploadObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        counter,
+		ContentType: aws.String(contentType),
+	}
+	if opts != nil {
+		input.Metadata = opts.Metadata
+		if opts.CacheControl != "" {
+			input.CacheControl = aws.String(opts.CacheControl)
+		}
+	}
+
+	out, err := s.uploader.UploadObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	return &Object{
+		Key:          key,
+		ContentType:  contentType,
+		Size:         counter.n,
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: time.Now().UTC(),
+		Metadata:     input.Metadata,
+	}, nil
+}
+
+// Get streams the object from the bucket
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := validateKey(key); err != nil {
+		return nil, nil, err
+	}
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+
+	return out.Body, &Object{
+		Key:          key,
+		ContentType:  aws.ToString(out.ContentType),
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+// Delete removes the object; S3 treats missing keys as success
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return err
+}
+
+// SignedURL returns a presigned GET or PUT URL
+func (s *S3) SignedURL(ctx context.Context, key, method string, expires time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	withExpiry := s3.WithPresignExpires(expires)
+	switch method {
+	case http.MethodGet:
+		req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.prefix + key),
+		}, withExpiry)
+		if err != nil {
+			return "", err
+		}
+		return req.URL, nil
+	case http.MethodPut:
+		req, err := s.presign.PresignPutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.prefix + key),
+		}, withExpiry)
+		if err != nil {
+			return "", err
+		}
+		return req.URL, nil
+	default:
+		return "", fmt.Errorf("blob: unsupported signed URL method %s", method)
+	}
+}
@@ -0,0 +1,104 @@
+// Package botdetect scores requests for signs of automation and abuse and
+// challenges or denies the ones that score too high. Detectors are
+// pluggable: the built-in ones look at the user agent, per-address request
+// bursts and, optionally, an external scoring service, and modules add
+// their own with Guard.Register.
+package botdetect
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Actions taken on a request, by increasing severity
+const (
+	ActionAllow     = "allow"
+	ActionChallenge = "challenge"
+	ActionDeny      = "deny"
+)
+
+// Modes the guard runs in
+const (
+	// ModeEnforce challenges and denies requests
+	ModeEnforce = "enforce"
+	// ModeMonitor logs and measures decisions without acting on them, to
+	// tune thresholds before enforcing them
+	ModeMonitor = "monitor"
+)
+
+// Signal is a detector's verdict on a request. Scores add up across
+// detectors; a negative score vouches for the request, e.g. for a client
+// that has already passed a challenge.
+type Signal struct {
+	Detector string `json:"detector"`
+	Score    int    `json:"score"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Detector scores a request. A detector with nothing to say returns a
+// zero Signal. Errors are logged and counted, and the detector is skipped,
+// so an unavailable dependency never blocks traffic.
+type Detector interface {
+	Detect(r *http.Request) (Signal, error)
+}
+
+// DetectorFunc adapts a function to Detector
+type DetectorFunc func(r *http.Request) (Signal, error)
+
+// Detect calls f
+func (f DetectorFunc) Detect(r *http.Request) (Signal, error) {
+	return f(r)
+}
+
+// Decision is the outcome of scoring a request
+type Decision struct {
+	Action string `json:"action"`
+	// Score is the sum of the signals' scores, at least 0
+	Score   int      `json:"score"`
+	Signals []Signal `json:"signals,omitempty"`
+	// Enforced is false when the guard only monitors
+	Enforced bool `json:"enforced"`
+}
+
+// Reasons returns the reasons of the signals that scored, as
+// "detector: reason"
+func (d Decision) Reasons() []string {
+	var reasons []string
+	for _, s := range d.Signals {
+		if s.Score == 0 {
+			continue
+		}
+		reason := s.Detector
+		if s.Reason != "" {
+			reason += ": " + s.Reason
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons
+}
+
+// String formats the decision for logs, e.g. "challenge (user_agent:
+// scripting client)"
+func (d Decision) String() string {
+	reasons := d.Reasons()
+	if len(reasons) == 0 {
+		return d.Action
+	}
+	return d.Action + " (" + strings.Join(reasons, ", ") + ")"
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying d
+func NewContext(ctx context.Context, d Decision) context.Context {
+	return context.WithValue(ctx, contextKey{}, d)
+}
+
+// FromContext returns the decision the middleware made for the request, so
+// handlers can be stricter with suspicious callers without refusing them,
+// e.g. by asking for a second factor on login
+func FromContext(ctx context.Context) (Decision, bool) {
+	d, ok := ctx.Value(contextKey{}).(Decision)
+	return d, ok
+}
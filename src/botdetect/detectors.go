@@ -0,0 +1,207 @@
+package botdetect
+
+import (
+	"bytes"
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// UserAgentDetector flags requests without a user agent, or whose user
+// agent matches one of bot_detection.user_agent.patterns. User agents are
+// trivially spoofed, so it catches careless scripts rather than determined
+// ones and scores below the deny threshold on its own.
+type UserAgentDetector struct {
+	config   *config.BotUserAgentConfig
+	patterns []*regexp.Regexp
+}
+
+// NewUserAgentDetector compiles cfg's patterns, which match case
+// insensitively
+func NewUserAgentDetector(cfg *config.BotUserAgentConfig) (*UserAgentDetector, error) {
+	d := &UserAgentDetector{config: cfg}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot_detection.user_agent pattern %q: %w", pattern, err)
+		}
+		d.patterns = append(d.patterns, re)
+	}
+	return d, nil
+}
+
+// Detect implements Detector
+func (d *UserAgentDetector) Detect(r *http.Request) (Signal, error) {
+	ua := strings.TrimSpace(r.UserAgent())
+	if ua == "" {
+		return Signal{Score: d.config.EmptyScore, Reason: "no user agent"}, nil
+	}
+	for _, re := range d.patterns {
+		if match := re.FindString(ua); match != "" {
+			return Signal{Score: d.config.Score, Reason: "user agent matches " + match}, nil
+		}
+	}
+	return Signal{}, nil
+}
+
+// RateDetector flags addresses sending requests faster than a person
+// could, with a token bucket per client address. Unlike the rate_limit
+// middleware it refuses nothing by itself; its score adds to the others'.
+type RateDetector struct {
+	config *config.BotRateConfig
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type rateBucket struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+// NewRateDetector creates a detector for cfg's rate
+func NewRateDetector(cfg *config.BotRateConfig) *RateDetector {
+	return &RateDetector{config: cfg, buckets: make(map[string]*rateBucket)}
+}
+
+// Detect implements Detector
+func (d *RateDetector) Detect(r *http.Request) (Signal, error) {
+	ip := clientip.FromRequest(r)
+	now := time.Now()
+
+	d.mu.Lock()
+	b, ok := d.buckets[ip]
+	if !ok {
+		b = &rateBucket{limiter: rate.NewLimiter(rate.Limit(d.config.RequestsPerSecond), d.config.Burst)}
+		d.buckets[ip] = b
+	}
+	b.used = now
+	allowed := b.limiter.AllowN(now, 1)
+	d.mu.Unlock()
+
+	if allowed {
+		return Signal{}, nil
+	}
+	return Signal{Score: d.config.Score, Reason: fmt.Sprintf("over %g requests per second", d.config.RequestsPerSecond)}, nil
+}
+
+// Start forgets the buckets of addresses idle for
+// bot_detection.rate.idle_timeout
+func (d *RateDetector) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.config.IdleTimeout)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				d.evict(now.Add(-d.config.IdleTimeout))
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the eviction
+func (d *RateDetector) Close() error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	return nil
+}
+
+func (d *RateDetector) evict(before time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ip, b := range d.buckets {
+		if b.used.Before(before) {
+			delete(d.buckets, ip)
+		}
+	}
+}
+
+// ExternalDetector asks a scoring service about each request. It POSTs
+//
+//	{"ip": "203.0.113.7", "method": "POST", "path": "/login", "user_agent": "...", "headers": {...}}
+//
+// and expects {"score": 70, "reason": "known proxy"} back. It adds a round
+// trip to every request, bounded by bot_detection.external.timeout, and the
+// request goes through unscored when the service is slow or down.
+type ExternalDetector struct {
+	config *config.BotExternalConfig
+	client *http.Client
+}
+
+// NewExternalDetector creates a detector calling cfg's URL with client
+func NewExternalDetector(cfg *config.BotExternalConfig, client *http.Client) *ExternalDetector {
+	return &ExternalDetector{config: cfg, client: client}
+}
+
+// forwardedHeaders are the request headers sent to the scoring service;
+// credentials and cookies are left out
+var forwardedHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Referer", "Origin", "Sec-Ch-Ua", "Sec-Fetch-Site", "Sec-Fetch-Mode"}
+
+// Detect implements Detector
+func (d *ExternalDetector) Detect(r *http.Request) (Signal, error) {
+	headers := make(map[string]string)
+	for _, name := range forwardedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			headers[name] = v
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"ip":         clientip.FromRequest(r),
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"user_agent": r.UserAgent(),
+		"headers":    headers,
+	})
+	if err != nil {
+		return Signal{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return Signal{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Signal{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return Signal{}, fmt.Errorf("scoring service returned %d", resp.StatusCode)
+	}
+
+	var signal Signal
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&signal); err != nil {
+		return Signal{}, fmt.Errorf("invalid scoring service response: %w", err)
+	}
+	return signal, nil
+}
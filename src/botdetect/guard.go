@@ -0,0 +1,209 @@
+package botdetect
+
+import (
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// namedDetector is a registered detector
+type namedDetector struct {
+	name     string
+	detector Detector
+}
+
+// Guard runs the registered detectors over every request and challenges
+// or denies the ones whose total score reaches bot_detection's thresholds
+type Guard struct {
+	config *config.BotsConfig
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu        sync.RWMutex
+	detectors []namedDetector
+	challenge http.Handler
+}
+
+// NewGuard creates a guard with no detectors. Challenged requests get 403
+// with {"error": "challenge required"} until SetChallenge replaces it.
+func NewGuard(cfg *config.BotsConfig, logger *zap.Logger, stats metrics.Agent) *Guard {
+	return &Guard{
+		config: cfg,
+		logger: logger.With(zap.String("component", "botdetect")),
+		stats:  stats,
+		challenge: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "challenge required")
+		}),
+	}
+}
+
+// Register adds a detector. Detectors run in the order registered and stop
+// once the score reaches the deny threshold, so register cheap ones first.
+// Registering a name again replaces it.
+func (g *Guard) Register(name string, d Detector) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.detectors {
+		if g.detectors[i].name == name {
+			g.detectors[i].detector = d
+			return
+		}
+	}
+	g.detectors = append(g.detectors, namedDetector{name: name, detector: d})
+}
+
+// Detectors returns the names of the registered detectors in order
+func (g *Guard) Detectors() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	names := make([]string, len(g.detectors))
+	for i, d := range g.detectors {
+		names[i] = d.name
+	}
+	return names
+}
+
+// SetChallenge replaces the handler serving challenged requests, e.g. with
+// one rendering a CAPTCHA. The request carries the Decision for
+// FromContext.
+func (g *Guard) SetChallenge(h http.Handler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.challenge = h
+}
+
+// Evaluate scores r with every detector and decides what to do with it
+func (g *Guard) Evaluate(r *http.Request) Decision {
+	g.mu.RLock()
+	detectors := g.detectors
+	g.mu.RUnlock()
+
+	d := Decision{Action: ActionAllow, Enforced: g.config.Mode != ModeMonitor}
+	total := 0
+	for _, nd := range detectors {
+		start := time.Now()
+		signal, err := nd.detector.Detect(r)
+		g.stats.Timing("botdetect."+nd.name+".duration", time.Since(start))
+		if err != nil {
+			g.stats.Increment("botdetect." + nd.name + ".error")
+			g.logger.Warn("bot detector failed", zap.String("detector", nd.name), zap.Error(err))
+			continue
+		}
+		if signal.Score == 0 {
+			continue
+		}
+		signal.Detector = nd.name
+		if signal.Score > 0 {
+			g.stats.Increment("botdetect." + nd.name + ".flagged")
+		}
+		d.Signals = append(d.Signals, signal)
+		total += signal.Score
+		if total >= g.config.DenyScore {
+			break
+		}
+	}
+
+	d.Score = max(total, 0)
+	switch {
+	case d.Score >= g.config.DenyScore:
+		d.Action = ActionDeny
+	case d.Score >= g.config.ChallengeScore:
+		d.Action = ActionChallenge
+	}
+	return d
+}
+
+// Handler scores every request outside bot_detection.exempt_paths,
+// answers denied ones with 403 and passes challenged ones to the challenge
+// handler. In monitor mode every request goes through. It reads the
+// client address real_ip resolved, which only trusts forwarding headers
+// from server.real_ip.trusted_proxies.
+func (g *Guard) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range g.config.ExemptPaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		d := g.Evaluate(r)
+		r = r.WithContext(NewContext(r.Context(), d))
+		g.stats.Increment("botdetect." + d.Action)
+		if d.Action == ActionAllow {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		g.logger.Info("suspicious request",
+			zap.String("action", d.Action),
+			zap.Bool("enforced", d.Enforced),
+			zap.Int("score", d.Score),
+			zap.Strings("reasons", d.Reasons()),
+			zap.String("ip", clientip.FromRequest(r)),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("user_agent", r.UserAgent()))
+		if !d.Enforced {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if d.Action == ActionDeny {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		g.mu.RLock()
+		challenge := g.challenge
+		g.mu.RUnlock()
+		challenge.ServeHTTP(w, r)
+	})
+}
+
+// lifecycle is implemented by detectors with background work, such as
+// RateDetector's eviction
+type lifecycle interface {
+	Start() error
+	Close() error
+}
+
+// Start starts the registered detectors that have a Start method
+func (g *Guard) Start() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, nd := range g.detectors {
+		if l, ok := nd.detector.(lifecycle); ok {
+			if err := l.Start(); err != nil {
+				return fmt.Errorf("failed to start bot detector %s: %w", nd.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the registered detectors that have a Close method
+func (g *Guard) Close() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var errs []error
+	for _, nd := range g.detectors {
+		if l, ok := nd.detector.(lifecycle); ok {
+			errs = append(errs, l.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
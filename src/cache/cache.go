@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores values of type T by key. A zero ttl uses the cache's
+// default TTL.
+type Cache[T any] interface {
+	// Get returns the cached value and whether it was found
+	Get(ctx context.Context, key string) (T, bool, error)
+	Set(ctx context.Context, key string, value T, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// GetOrLoad returns the cached value, or calls load and caches its
+	// result. Concurrent misses for the same key share a single load.
+	GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error)
+}
+
+// LoadFunc produces a value on a cache miss
+type LoadFunc[T any] func(ctx context.Context) (T, error)
+
+// loader implements GetOrLoad on top of Get and Set
+type loader[T any] struct {
+	name  string
+	stats metrics.Agent
+	group singleflight.Group
+}
+
+func (l *loader[T]) getOrLoad(ctx context.Context, c Cache[T], key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	// A failing backend degrades to loading rather than failing the request
+	if value, ok, err := c.Get(ctx, key); err == nil && ok {
+		return value, nil
+	}
+
+	loads := l.group.DoChan(key, func() (interface{}, error) {
+		// The load and store outlive any one caller, so a cancelled
+		// request can't fail the others waiting on it or leave the value
+		// uncached. The first caller's deadline still bounds them, so a
+		// stuck backend can't hold the key forever.
+		detached := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			detached, cancel = context.WithDeadline(detached, deadline)
+			defer cancel()
+		}
+		value, err := load(detached)
+		if err != nil {
+			return value, err
+		}
+		if err := c.Set(detached, key, value, ttl); err != nil {
+			l.stats.Increment(l.bucket("set.error"))
+		}
+		return value, nil
+	})
+	// A caller that gives up returns while the shared load carries on
+	var res singleflight.Result
+	select {
+	case res = <-loads:
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("cache %s: failed to load %s: %w", l.name, key, ctx.Err())
+	}
+	result, err := res.Val, res.Err
+	if res.Shared {
+		l.stats.Increment(l.bucket("load.shared"))
+	}
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("cache %s: failed to load %s: %w", l.name, key, err)
+	}
+
+	value, ok := result.(T)
+	if !ok {
+		var zero T
+		return zero, errors.New("cache " + l.name + ": loader returned an unexpected type")
+	}
+	return value, nil
+}
+
+// bucket returns the metric name for this cache, e.g. "cache.users.hit"
+func (l *loader[T]) bucket(name string) string {
+	return "cache." + l.name + "." + name
+}
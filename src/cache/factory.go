@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"coffee-and-running/src/cache/redis"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"errors"
+	"fmt"
+)
+
+// Factory creates caches on the backend cache.driver selects, so moving a
+// service's caches from per-instance to shared is a config change. The
+// cache module provides it; modules resolve it and create their caches
+// with New.
+type Factory struct {
+	config *config.CacheConfig
+	client *redis.Client
+	stats  metrics.Agent
+}
+
+// NewFactory creates a factory for cfg. client may be nil unless the
+// driver is redis.
+func NewFactory(cfg *config.CacheConfig, client *redis.Client, stats metrics.Agent) (*Factory, error) {
+	switch cfg.Driver {
+	case "memory":
+	case "redis":
+		if client == nil {
+			return nil, errors.New("cache.driver redis requires redis.enabled")
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache driver %q", cfg.Driver)
+	}
+	return &Factory{config: cfg, client: client, stats: stats}, nil
+}
+
+// New creates a cache of T values. name labels its metrics and, in Redis,
+// prefixes its keys, so each cache needs its own.
+func New[T any](f *Factory, name string) Cache[T] {
+	if f.config.Driver == "redis" {
+		return NewRedis[T](name, f.client, f.config.DefaultTTL, f.stats)
+	}
+	return NewMemory[T](name, f.config.MaxEntries, f.config.DefaultTTL, f.stats)
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process LRU cache with per-entry TTLs. Values are shared,
+// not copied, so callers must not mutate what they get back.
+type Memory[T any] struct {
+	loader[T]
+	maxEntries int
+	defaultTTL time.Duration
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List // front is most recently used
+}
+
+// entry is a cached value with its expiry
+type entry[T any] struct {
+	key       string
+	value     T
+	expiresAt time.Time
+}
+
+var _ Cache[int] = (*Memory[int])(nil)
+
+// NewMemory creates an in-memory cache holding at most maxEntries values.
+// name labels the cache's metrics.
+func NewMemory[T any](name string, maxEntries int, defaultTTL time.Duration, stats metrics.Agent) *Memory[T] {
+	return &Memory[T]{
+		loader:     loader[T]{name: name, stats: stats},
+		maxEntries: maxEntries,
+		defaultTTL: defaultTTL,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the cached value if present and not expired
+func (m *Memory[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		m.stats.Increment(m.bucket("miss"))
+		var zero T
+		return zero, false, nil
+	}
+
+	e := elem.Value.(*entry[T])
+	if time.Now().After(e.expiresAt) {
+		m.remove(elem)
+		m.stats.Increment(m.bucket("miss"))
+		var zero T
+		return zero, false, nil
+	}
+
+	m.order.MoveToFront(elem)
+	m.stats.Increment(m.bucket("hit"))
+	return e.value, true, nil
+}
+
+// Set stores a value, evicting the least recently used entry when full
+func (m *Memory[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = m.defaultTTL
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if elem, ok := m.entries[key]; ok {
+		e := elem.Value.(*entry[T])
+		e.value = value
+		e.expiresAt = expiresAt
+		m.order.MoveToFront(elem)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&entry[T]{key: key, value: value, expiresAt: expiresAt})
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+		m.stats.Increment(m.bucket("evicted"))
+	}
+	return nil
+}
+
+// Delete removes a value
+func (m *Memory[T]) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+// GetOrLoad returns the cached value or loads it once across concurrent callers
+func (m *Memory[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	return m.getOrLoad(ctx, m, key, ttl, load)
+}
+
+// Len returns the number of entries, including expired ones not yet evicted
+func (m *Memory[T]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// remove deletes an element; the caller must hold mu
+func (m *Memory[T]) remove(elem *list.Element) {
+	m.order.Remove(elem)
+	delete(m.entries, elem.Value.(*entry[T]).key)
+}
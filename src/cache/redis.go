@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"coffee-and-running/src/cache/redis"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Redis is a cache shared across instances, storing values as JSON under
+// "<name>:<key>"
+type Redis[T any] struct {
+	loader[T]
+	client     *redis.Client
+	defaultTTL time.Duration
+}
+
+var _ Cache[int] = (*Redis[int])(nil)
+
+// NewRedis creates a Redis-backed cache. name prefixes keys and labels the
+// cache's metrics.
+func NewRedis[T any](name string, client *redis.Client, defaultTTL time.Duration, stats metrics.Agent) *Redis[T] {
+	return &Redis[T]{
+		loader:     loader[T]{name: name, stats: stats},
+		client:     client,
+		defaultTTL: defaultTTL,
+	}
+}
+
+// Get returns the cached value if present
+func (r *Redis[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	var value T
+
+	data, err := r.client.Get(ctx, r.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		r.stats.Increment(r.bucket("miss"))
+		return value, false, nil
+	}
+	if err != nil {
+		r.stats.Increment(r.bucket("get.error"))
+		return value, false, err
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		// Treat undecodable entries (e.g. after a type change) as misses
+		r.stats.Increment(r.bucket("decode.error"))
+		return value, false, nil
+	}
+
+	r.stats.Increment(r.bucket("hit"))
+	return value, true, nil
+}
+
+// Set stores a value
+func (r *Redis[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = r.defaultTTL
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cache %s: failed to encode %s: %w", r.name, key, err)
+	}
+	return r.client.Set(ctx, r.key(key), data, ttl).Err()
+}
+
+// Delete removes a value
+func (r *Redis[T]) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.key(key)).Err()
+}
+
+// GetOrLoad returns the cached value or loads it once across concurrent
+// callers in this instance
+func (r *Redis[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, load LoadFunc[T]) (T, error) {
+	return r.getOrLoad(ctx, r, key, ttl, load)
+}
+
+func (r *Redis[T]) key(key string) string {
+	return r.name + ":" + key
+}
@@ -0,0 +1,227 @@
+package redis
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/requestid"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// metricsPrefix is the bucket prefix for all Redis metrics
+const metricsPrefix = "redis"
+
+// Nil is returned by commands when the key does not exist
+const Nil = goredis.Nil
+
+// Client is an instrumented Redis client. It embeds the go-redis universal
+// client, so every command is available directly, e.g. c.Incr(ctx, "hits").
+type Client struct {
+	goredis.UniversalClient
+	config *config.RedisConfig
+	logger *zap.Logger
+}
+
+// New creates a Redis client for the configured mode and verifies the
+// connection
+func New(cfg *config.RedisConfig, logger *zap.Logger, stats metrics.Agent) (*Client, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("redis: at least one address is required")
+	}
+
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	opts := &goredis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		TLSConfig:        tlsConfig,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		PoolTimeout:      cfg.PoolTimeout,
+		ConnMaxIdleTime:  cfg.ConnMaxIdleTime,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		MaxRetries:       cfg.MaxRetries,
+	}
+
+	var client goredis.UniversalClient
+	switch cfg.Mode {
+	case "", "standalone":
+		client = goredis.NewClient(opts.Simple())
+	case "sentinel":
+		if cfg.MasterName == "" {
+			return nil, errors.New("redis: master_name is required in sentinel mode")
+		}
+		client = goredis.NewFailoverClient(opts.Failover())
+	case "cluster":
+		client = goredis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, fmt.Errorf("redis: unsupported mode %q", cfg.Mode)
+	}
+
+	logger = logger.With(zap.String("component", "redis"))
+	client.AddHook(&metricsHook{
+		logger:        logger,
+		stats:         stats,
+		slowThreshold: cfg.SlowCommandThreshold,
+	})
+
+	c := &Client{
+		UniversalClient: client,
+		config:          cfg,
+		logger:          logger,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis: failed to connect: %w", err)
+	}
+
+	logger.Info("redis client connected",
+		zap.String("mode", cfg.Mode),
+		zap.Strings("addrs", cfg.Addrs),
+		zap.Int("pool_size", cfg.PoolSize))
+
+	return c, nil
+}
+
+// Ping verifies the connection; it doubles as a health.Checker
+func (c *Client) Ping(ctx context.Context) error {
+	return c.UniversalClient.Ping(ctx).Err()
+}
+
+// GetJSON loads key into dest. It returns false, without error, if the key
+// does not exist.
+func (c *Client) GetJSON(ctx context.Context, key string, dest interface{}) (bool, error) {
+	data, err := c.Get(ctx, key).Bytes()
+	if errors.Is(err, Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		return false, fmt.Errorf("redis: failed to decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// SetJSON stores value under key. A zero ttl means no expiry.
+func (c *Client) SetJSON(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("redis: failed to encode %s: %w", key, err)
+	}
+	return c.Set(ctx, key, data, ttl).Err()
+}
+
+// Delete removes keys, ignoring keys that don't exist
+func (c *Client) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Del(ctx, keys...).Err()
+}
+
+// Close releases all pooled connections
+func (c *Client) Close() error {
+	err := c.UniversalClient.Close()
+	c.logger.Info("redis client closed")
+	return err
+}
+
+// metricsHook records per-command latency and errors and logs slow commands
+type metricsHook struct {
+	logger        *zap.Logger
+	stats         metrics.Agent
+	slowThreshold time.Duration
+}
+
+func (h *metricsHook) DialHook(next goredis.DialHook) goredis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			h.stats.Increment(metricsPrefix + ".dial.error")
+		}
+		return conn, err
+	}
+}
+
+func (h *metricsHook) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.record(ctx, commandLabel(cmd.Name()), 1, time.Since(start), err)
+		return err
+	}
+}
+
+func (h *metricsHook) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []goredis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.record(ctx, "pipeline", len(cmds), time.Since(start), err)
+		return err
+	}
+}
+
+func (h *metricsHook) record(ctx context.Context, name string, count int, duration time.Duration, err error) {
+	bucket := fmt.Sprintf("%s.%s", metricsPrefix, name)
+	h.stats.Timing(bucket+".duration", duration)
+
+	// A missing key is a normal result, not a failure
+	if err != nil && !errors.Is(err, Nil) {
+		h.stats.Increment(bucket + ".error")
+		h.loggerFor(ctx).Error("redis command failed",
+			zap.String("command", name),
+			zap.Int("commands", count),
+			zap.Duration("duration", duration),
+			zap.Error(err))
+		return
+	}
+
+	if h.slowThreshold > 0 && duration > h.slowThreshold {
+		h.stats.Increment(bucket + ".slow")
+		h.loggerFor(ctx).Warn("slow redis command",
+			zap.String("command", name),
+			zap.Int("commands", count),
+			zap.Duration("duration", duration),
+			zap.Duration("threshold", h.slowThreshold))
+	}
+}
+
+// loggerFor annotates the logger with the request ID carried by ctx
+func (h *metricsHook) loggerFor(ctx context.Context) *zap.Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return h.logger.With(zap.String("request_id", id))
+	}
+	return h.logger
+}
+
+// commandLabel converts a command name into a metric-safe bucket segment,
+// e.g. "CLIENT SETINFO" becomes "client_setinfo"
+func commandLabel(name string) string {
+	if name == "" {
+		return "unknown"
+	}
+	return strings.ReplaceAll(strings.ToLower(name), " ", "_")
+}
@@ -0,0 +1,146 @@
+package chaos
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// metricsPrefix is the bucket prefix for counts of injected faults, so
+// alerts can be checked against what was actually injected
+const metricsPrefix = "chaos"
+
+var (
+	// ErrProduction is returned by New when the app runs in production
+	ErrProduction = errors.New("chaos: fault injection is refused in production")
+	// ErrInjected is the error of injected database faults
+	ErrInjected = errors.New("chaos: injected fault")
+)
+
+// Injector adds latency, errors and dropped connections to a random share
+// of HTTP requests and database calls
+type Injector struct {
+	http     *config.ChaosHTTPConfig
+	database *config.ChaosDatabaseConfig
+	logger   *zap.Logger
+	stats    metrics.Agent
+}
+
+// New creates an injector. It refuses to run in production, whatever the
+// config says.
+func New(cfg *config.ChaosConfig, app *config.AppConfig, logger *zap.Logger, stats metrics.Agent) (*Injector, error) {
+	if app != nil && app.IsProduction() {
+		return nil, ErrProduction
+	}
+	logger = logger.With(zap.String("component", "chaos"))
+	logger.Warn("fault injection enabled; requests and database calls will fail on purpose")
+	return &Injector{
+		http:     cfg.HTTP,
+		database: cfg.Database,
+		logger:   logger,
+		stats:    stats,
+	}, nil
+}
+
+// Middleware injects faults before the request reaches the handler:
+// latency, then a dropped connection or an error response
+func (i *Injector) Middleware(next http.Handler) http.Handler {
+	cfg := i.http
+	if cfg == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !matches(r.URL.Path, cfg.Paths, true) || matches(r.URL.Path, cfg.ExcludePaths, false) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if roll(cfg.LatencyProbability) {
+			i.stats.Increment(metricsPrefix + ".http.latency")
+			if err := sleep(r.Context(), between(cfg.LatencyMin, cfg.LatencyMax)); err != nil {
+				return
+			}
+		}
+		if roll(cfg.DropProbability) {
+			i.stats.Increment(metricsPrefix + ".http.drop")
+			i.logger.Debug("dropping connection", zap.String("path", r.URL.Path))
+			// net/http closes the connection without a response and
+			// without logging a stack trace
+			panic(http.ErrAbortHandler)
+		}
+		if roll(cfg.ErrorProbability) {
+			status := cfg.ErrorStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			i.stats.Increment(metricsPrefix + ".http.error")
+			i.logger.Debug("injecting error response", zap.String("path", r.URL.Path), zap.Int("status", status))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status)})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fault delays or fails a database call
+func (i *Injector) fault(ctx context.Context) error {
+	cfg := i.database
+	if roll(cfg.LatencyProbability) {
+		i.stats.Increment(metricsPrefix + ".database.latency")
+		if err := sleep(ctx, between(cfg.LatencyMin, cfg.LatencyMax)); err != nil {
+			return err
+		}
+	}
+	if roll(cfg.ErrorProbability) {
+		i.stats.Increment(metricsPrefix + ".database.error")
+		return ErrInjected
+	}
+	return nil
+}
+
+// matches reports whether path starts with one of prefixes, or def when
+// there are none
+func matches(path string, prefixes []string, def bool) bool {
+	if len(prefixes) == 0 {
+		return def
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func roll(probability float64) bool {
+	return probability > 0 && rand.Float64() < probability
+}
+
+// between returns a uniformly random duration in [lo, hi]
+func between(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(rand.Int63n(int64(hi-lo)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
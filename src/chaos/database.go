@@ -0,0 +1,129 @@
+package chaos
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// WrapConnector injects database faults into connections opened through
+// c. Faults happen below the storage engine, so they are logged and counted
+// like real failures and reach QueryRow, transactions and statements alike:
+//
+//	storage.NewEngine(cfg, logger, stats, storage.WithConnector(injector.WrapConnector))
+func (i *Injector) WrapConnector(c driver.Connector) driver.Connector {
+	if i.database == nil {
+		return c
+	}
+	return &connector{Connector: c, injector: i}
+}
+
+type connector struct {
+	driver.Connector
+	injector *Injector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	inner, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: inner, injector: c.injector}, nil
+}
+
+// conn injects faults before queries, statements and transactions start.
+// Optional driver interfaces the wrapped connection lacks are reported with
+// driver.ErrSkip or their zero behavior, so database/sql falls back as it
+// would without the wrapper.
+type conn struct {
+	driver.Conn
+	injector *Injector
+}
+
+var (
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.SessionResetter    = (*conn)(nil)
+	_ driver.Validator          = (*conn)(nil)
+	_ driver.NamedValueChecker  = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.injector.fault(ctx); err != nil {
+		return nil, err
+	}
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return pc.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.injector.fault(ctx); err != nil {
+		return nil, err
+	}
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	// Deprecated, but the only way in for drivers without BeginTx
+	return c.Conn.Begin()
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		// Falls back to PrepareContext, which injects
+		return nil, driver.ErrSkip
+	}
+	if err := c.injector.fault(ctx); err != nil {
+		return nil, err
+	}
+	return qc.QueryContext(ctx, query, args)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.injector.fault(ctx); err != nil {
+		return nil, err
+	}
+	return ec.ExecContext(ctx, query, args)
+}
+
+// Ping isn't injected into, so health checks and readiness report the
+// database itself
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
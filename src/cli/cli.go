@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ErrUsage makes Execute print the command's usage and exit with status 2
+var ErrUsage = errors.New("invalid usage")
+
+// Command is a node in the command tree. A command either runs or groups
+// subcommands; a group whose Run is set runs it when no subcommand is
+// given.
+type Command struct {
+	Name    string
+	Summary string
+	// Args describes positional arguments in usage, e.g. "<name>"
+	Args string
+	// Flags registers the command's flags. Flags declared on a group are
+	// inherited by its subcommands.
+	Flags func(fs *flag.FlagSet)
+	// Run executes the command with its flag set already parsed; the
+	// positional arguments are fs.Args()
+	Run      func(ctx context.Context, fs *flag.FlagSet) error
+	Commands []*Command
+}
+
+// Execute parses args (without the program name) against the tree rooted
+// at c and runs the selected command. It returns the process exit status:
+// 0 on success, 1 if the command failed and 2 on usage errors.
+func (c *Command) Execute(ctx context.Context, args []string) int {
+	return c.execute(ctx, args, []string{c.Name}, nil, nil, os.Stderr)
+}
+
+func (c *Command) execute(ctx context.Context, args []string, path []string, inherited []func(*flag.FlagSet), parent *flag.FlagSet, stderr io.Writer) int {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	// Registering a flag resets its variable to the default, so carry
+	// over what was set before the subcommand name
+	set := map[string]string{}
+	if parent != nil {
+		parent.Visit(func(f *flag.Flag) { set[f.Name] = f.Value.String() })
+	}
+	own := inherited
+	if c.Flags != nil {
+		own = append(own[:len(own):len(own)], c.Flags)
+	}
+	for _, register := range own {
+		register(fs)
+	}
+	for name, value := range set {
+		fs.Set(name, value)
+	}
+	fs.Usage = func() { c.usage(fs, path, stderr) }
+
+	// Groups stop at the first subcommand name so its flags reach it
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if len(c.Commands) > 0 && fs.NArg() > 0 {
+		name := fs.Arg(0)
+		if name == "help" {
+			// "help migrate up" is "migrate up -h"
+			return c.execute(ctx, append(fs.Args()[1:], "-h"), path, inherited, parent, stderr)
+		}
+		for _, sub := range c.Commands {
+			if sub.Name == name {
+				// Re-parse the group's flags after the subcommand name too,
+				// e.g. "migrate up -config x.yaml"
+				return sub.execute(ctx, fs.Args()[1:], append(path[:len(path):len(path)], name), own, fs, stderr)
+			}
+		}
+		if c.Run == nil {
+			fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+			fs.Usage()
+			return 2
+		}
+	}
+
+	if c.Run == nil {
+		fs.Usage()
+		return 2
+	}
+	if err := c.Run(ctx, fs); err != nil {
+		if errors.Is(err, ErrUsage) {
+			fmt.Fprintf(stderr, "%v\n\n", err)
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "%s: %v\n", strings.Join(path, " "), err)
+		return 1
+	}
+	return 0
+}
+
+func (c *Command) usage(fs *flag.FlagSet, path []string, w io.Writer) {
+	line := strings.Join(path, " ")
+	if len(c.Commands) > 0 {
+		line += " <command>"
+	}
+	fmt.Fprintf(w, "Usage: %s [flags]", line)
+	if c.Args != "" {
+		fmt.Fprintf(w, " %s", c.Args)
+	}
+	fmt.Fprintln(w)
+	if c.Summary != "" {
+		fmt.Fprintf(w, "\n%s\n", c.Summary)
+	}
+
+	if len(c.Commands) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, sub := range c.Commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", sub.Name, sub.Summary)
+		}
+		tw.Flush()
+	}
+
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.PrintDefaults()
+	}
+}
+
+// Usagef returns an error that makes Execute print usage
+func Usagef(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrUsage, fmt.Sprintf(format, args...))
+}
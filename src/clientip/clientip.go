@@ -0,0 +1,105 @@
+// Package clientip resolves the address of the client behind a request.
+// Forwarding headers are only read from trusted proxies, so a client can't
+// choose the address that IP filters, rate limits and geo-blocking see.
+package clientip
+
+import (
+	"coffee-and-running/src/requestid"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// FromRequest returns the client address the middleware resolved for r,
+// or the peer address of r.RemoteAddr without it
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return host(r.RemoteAddr)
+}
+
+// Middleware resolves each request's client address. When the peer is a
+// trusted proxy, X-Forwarded-For is read from the right, skipping the
+// trusted proxies it lists, and the first other address is the client's;
+// X-Real-IP is read when there is no X-Forwarded-For. Headers from any
+// other peer are ignored.
+type Middleware struct {
+	trusted []*net.IPNet
+}
+
+// NewMiddleware creates the middleware. trustedProxies lists the IPs or
+// CIDR blocks whose forwarding headers are read; with none, the peer
+// address is always the client's.
+func NewMiddleware(trustedProxies []string) (*Middleware, error) {
+	trusted, err := requestid.ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	return &Middleware{trusted: trusted}, nil
+}
+
+// Handler implements the chi middleware signature. It stores the client
+// address for FromRequest and sets r.RemoteAddr to it, so request logs
+// show the client.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := m.resolve(r)
+		r.RemoteAddr = ip
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// resolve returns r's client address
+func (m *Middleware) resolve(r *http.Request) string {
+	client := host(r.RemoteAddr)
+	if !m.trusts(net.ParseIP(client)) {
+		return client
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// A malformed hop was added before the nearest trusted
+				// proxy, so the client is past what can be trusted
+				break
+			}
+			client = ip.String()
+			if !m.trusts(ip) {
+				break
+			}
+		}
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return client
+}
+
+// trusts reports whether ip belongs to a trusted proxy
+func (m *Middleware) trusts(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range m.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// host strips the port from addr, if it has one
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
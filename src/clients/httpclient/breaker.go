@@ -0,0 +1,155 @@
+package httpclient
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned when a host's circuit breaker is rejecting calls
+var ErrCircuitOpen = errors.New("httpclient: circuit breaker open")
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker tracks consecutive failures for a single host
+type breaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakerTransport opens a per-host circuit after consecutive failures
+// (transport errors or 5xx responses), then lets a single probe through once
+// the open timeout has elapsed
+type breakerTransport struct {
+	base     http.RoundTripper
+	config   *config.CircuitBreakerConfig
+	name     string
+	logger   *zap.Logger
+	stats    metrics.Agent
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+func newBreakerTransport(base http.RoundTripper, cfg *config.CircuitBreakerConfig, name string, logger *zap.Logger, stats metrics.Agent) *breakerTransport {
+	return &breakerTransport{
+		base:     base,
+		config:   cfg,
+		name:     name,
+		logger:   logger,
+		stats:    stats,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if !t.allow(host) {
+		t.stats.Increment(fmt.Sprintf("http.client.%s.%s.circuit_open", t.name, hostLabel(host)))
+		return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	t.record(host, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	return resp, err
+}
+
+// allow reports whether a request to host may proceed
+func (t *breakerTransport) allow(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.breaker(host)
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < t.config.OpenTimeout {
+			return false
+		}
+		t.transition(host, b, stateHalfOpen)
+		b.probing = true
+		return true
+	case stateHalfOpen:
+		// Only one probe at a time while half-open
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates host's breaker with the outcome of a request
+func (t *breakerTransport) record(host string, success bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.breaker(host)
+	b.probing = false
+
+	if success {
+		b.failures = 0
+		if b.state != stateClosed {
+			t.transition(host, b, stateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= t.config.FailureThreshold {
+		b.openedAt = time.Now()
+		if b.state != stateOpen {
+			t.transition(host, b, stateOpen)
+		}
+	}
+}
+
+// breaker returns the breaker for host, creating it if needed. Callers must
+// hold t.mu.
+func (t *breakerTransport) breaker(host string) *breaker {
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// transition moves b to state, logging and counting the change. Callers
+// must hold t.mu.
+func (t *breakerTransport) transition(host string, b *breaker, state breakerState) {
+	t.logger.Warn("circuit breaker state changed",
+		zap.String("host", host),
+		zap.String("from", b.state.String()),
+		zap.String("to", state.String()),
+		zap.Int("consecutive_failures", b.failures),
+	)
+	t.stats.Increment(fmt.Sprintf("http.client.%s.%s.circuit.%s", t.name, hostLabel(host), state))
+	b.state = state
+}
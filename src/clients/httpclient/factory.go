@@ -0,0 +1,197 @@
+package httpclient
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/deadline"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/observability/tracing"
+	"coffee-and-running/src/requestid"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultClientName is the config key applied to clients that aren't listed
+const defaultClientName = "default"
+
+// Factory builds named outbound HTTP clients from the `clients.http` config
+// section. Clients are cached, so repeated calls share connection pools and
+// circuit breaker state.
+type Factory struct {
+	config  *config.ClientsConfig
+	logger  *zap.Logger
+	stats   metrics.Agent
+	tracer  tracing.Provider
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// NewFactory creates a new outbound HTTP client factory
+func NewFactory(cfg *config.ClientsConfig, logger *zap.Logger, stats metrics.Agent, tracer tracing.Provider) *Factory {
+	return &Factory{
+		config:  cfg,
+		logger:  logger.With(zap.String("component", "httpclient")),
+		stats:   stats,
+		tracer:  tracer,
+		clients: make(map[string]*http.Client),
+	}
+}
+
+// Client returns the *http.Client configured under name, falling back to the
+// "default" entry. The transport chain is, outermost first:
+// metrics -> retry -> circuit breaker -> tracing -> request ID -> deadline ->
+// connection pool. The request's context deadline, which includes the
+// client timeout, is sent to the called service in X-Request-Deadline.
+func (f *Factory) Client(name string) *http.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if client, ok := f.clients[name]; ok {
+		return client
+	}
+
+	cfg := f.clientConfig(name)
+	client := f.newClient(name, cfg, newPooledTransport(cfg))
+	f.clients[name] = client
+	return client
+}
+
+// newClient wraps base in the transport chain for cfg
+func (f *Factory) newClient(name string, cfg *config.HTTPClientConfig, base *http.Transport) *http.Client {
+	logger := f.logger.With(zap.String("client", name))
+
+	var transport http.RoundTripper = base
+	transport = &deadline.Transport{Base: transport}
+	transport = &requestid.Transport{Base: transport}
+	if f.tracer != nil {
+		transport = &tracing.Transport{Base: transport, Provider: f.tracer}
+	}
+	if cfg.CircuitBreaker.Enabled {
+		transport = newBreakerTransport(transport, cfg.CircuitBreaker, name, logger, f.stats)
+	}
+	if cfg.Retry.MaxAttempts > 1 {
+		transport = newRetryTransport(transport, cfg.Retry, name, logger, f.stats)
+	}
+	transport = newMetricsTransport(transport, name, f.stats)
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}
+
+	logger.Info("http client created",
+		zap.Duration("timeout", cfg.Timeout),
+		zap.Int("max_attempts", cfg.Retry.MaxAttempts),
+		zap.Bool("circuit_breaker", cfg.CircuitBreaker.Enabled),
+	)
+
+	return client
+}
+
+// CloseIdleConnections closes idle connections on every client created so far
+func (f *Factory) CloseIdleConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, client := range f.clients {
+		client.CloseIdleConnections()
+	}
+}
+
+// clientConfig resolves the configuration for name with defaults applied
+func (f *Factory) clientConfig(name string) *config.HTTPClientConfig {
+	var cfg *config.HTTPClientConfig
+	if f.config != nil {
+		cfg = f.config.HTTP[name]
+		if cfg == nil {
+			cfg = f.config.HTTP[defaultClientName]
+		}
+	}
+	return withDefaults(cfg)
+}
+
+// withDefaults fills zero values in cfg from DefaultHTTPClientConfig
+func withDefaults(cfg *config.HTTPClientConfig) *config.HTTPClientConfig {
+	def := config.DefaultHTTPClientConfig()
+	if cfg == nil {
+		return def
+	}
+
+	out := *cfg
+	if out.Timeout == 0 {
+		out.Timeout = def.Timeout
+	}
+	if out.DialTimeout == 0 {
+		out.DialTimeout = def.DialTimeout
+	}
+	if out.TLSHandshakeTimeout == 0 {
+		out.TLSHandshakeTimeout = def.TLSHandshakeTimeout
+	}
+	if out.ResponseHeaderTimeout == 0 {
+		out.ResponseHeaderTimeout = def.ResponseHeaderTimeout
+	}
+	if out.IdleConnTimeout == 0 {
+		out.IdleConnTimeout = def.IdleConnTimeout
+	}
+	if out.MaxIdleConns == 0 {
+		out.MaxIdleConns = def.MaxIdleConns
+	}
+	if out.MaxIdleConnsPerHost == 0 {
+		out.MaxIdleConnsPerHost = def.MaxIdleConnsPerHost
+	}
+	if out.Retry == nil {
+		out.Retry = def.Retry
+	} else {
+		retry := *out.Retry
+		if retry.MaxAttempts == 0 {
+			retry.MaxAttempts = def.Retry.MaxAttempts
+		}
+		if retry.InitialBackoff == 0 {
+			retry.InitialBackoff = def.Retry.InitialBackoff
+		}
+		if retry.MaxBackoff == 0 {
+			retry.MaxBackoff = def.Retry.MaxBackoff
+		}
+		if retry.RetryOnStatus == nil {
+			retry.RetryOnStatus = def.Retry.RetryOnStatus
+		}
+		out.Retry = &retry
+	}
+	if out.CircuitBreaker == nil {
+		out.CircuitBreaker = def.CircuitBreaker
+	} else {
+		breaker := *out.CircuitBreaker
+		if breaker.FailureThreshold == 0 {
+			breaker.FailureThreshold = def.CircuitBreaker.FailureThreshold
+		}
+		if breaker.OpenTimeout == 0 {
+			breaker.OpenTimeout = def.CircuitBreaker.OpenTimeout
+		}
+		out.CircuitBreaker = &breaker
+	}
+	return &out
+}
+
+// newPooledTransport creates the connection-pooling base transport
+func newPooledTransport(cfg *config.HTTPClientConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
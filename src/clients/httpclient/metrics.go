@@ -0,0 +1,50 @@
+package httpclient
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// metricsTransport records per-host request counts, durations and errors.
+// It sits outermost so durations include retries and backoff.
+type metricsTransport struct {
+	base  http.RoundTripper
+	name  string
+	stats metrics.Agent
+}
+
+func newMetricsTransport(base http.RoundTripper, name string, stats metrics.Agent) *metricsTransport {
+	return &metricsTransport{
+		base:  base,
+		name:  name,
+		stats: stats,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	bucket := fmt.Sprintf("http.client.%s.%s", t.name, hostLabel(req.URL.Host))
+
+	resp, err := t.base.RoundTrip(req)
+	t.stats.Timing(bucket+".duration", time.Since(start))
+
+	if err != nil {
+		t.stats.Increment(bucket + ".error")
+		return nil, err
+	}
+
+	t.stats.Increment(fmt.Sprintf("%s.%dxx.requests", bucket, resp.StatusCode/100))
+	return resp, nil
+}
+
+// hostLabel converts a host[:port] into a metric-safe bucket segment
+func hostLabel(host string) string {
+	if host == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(".", "_", ":", "_").Replace(host)
+}
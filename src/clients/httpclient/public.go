@@ -0,0 +1,99 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrNonPublicAddress is returned when a public client would connect to an
+// address that isn't on the public internet
+var ErrNonPublicAddress = errors.New("httpclient: address is not public")
+
+// nonPublicPrefixes are special-purpose ranges the netip predicates don't
+// cover
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, which reaches IPv4 hosts
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site-local
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which embeds IPv4 hosts
+	netip.MustParsePrefix("2001::/32"),       // Teredo, likewise
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4-translated
+}
+
+// IsPublic reports whether addr is a public unicast address: not loopback,
+// private, link-local (which includes cloud metadata services such as
+// 169.254.169.254), multicast, unspecified or otherwise reserved
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicClient returns the client configured under name, like Client, for
+// calling URLs supplied by users, such as webhook endpoints. It only
+// connects to public addresses, checked as each connection is dialed so a
+// name can't resolve elsewhere after it was validated. It uses no proxy,
+// which would be dialed instead of the target, and doesn't follow
+// redirects, which could point anywhere; a redirect is returned as the
+// response.
+func (f *Factory) PublicClient(name string) *http.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := "public:" + name
+	if client, ok := f.clients[key]; ok {
+		return client
+	}
+
+	cfg := f.clientConfig(name)
+	base := newPooledTransport(cfg)
+	base.Proxy = nil
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublic,
+	}
+	base.DialContext = dialer.DialContext
+
+	client := f.newClient(name, cfg, base)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	f.clients[key] = client
+	return client
+}
+
+// dialPublic is a net.Dialer Control function refusing addresses IsPublic
+// rejects. It sees the resolved address of every connection attempt.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !IsPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package httpclient
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/deadline"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader marks a non-idempotent request as safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// retryTransport retries failed idempotent requests with exponential backoff
+// and full jitter, honouring Retry-After on retryable responses
+type retryTransport struct {
+	base    http.RoundTripper
+	config  *config.RetryConfig
+	name    string
+	logger  *zap.Logger
+	stats   metrics.Agent
+	retryOn map[int]bool
+}
+
+func newRetryTransport(base http.RoundTripper, cfg *config.RetryConfig, name string, logger *zap.Logger, stats metrics.Agent) *retryTransport {
+	retryOn := make(map[int]bool, len(cfg.RetryOnStatus))
+	for _, status := range cfg.RetryOnStatus {
+		retryOn[status] = true
+	}
+
+	return &retryTransport{
+		base:    base,
+		config:  cfg,
+		name:    name,
+		logger:  logger,
+		stats:   stats,
+		retryOn: retryOn,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isRetryable(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			var err error
+			if attemptReq, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.config.MaxAttempts || !t.shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+		if remaining, ok := deadline.Remaining(ctx); ok && remaining <= wait {
+			// The budget would run out while waiting, so the last
+			// outcome is as good as it gets
+			t.stats.Increment(fmt.Sprintf("http.client.%s.retry_skipped", t.name))
+			return resp, err
+		}
+		if resp != nil {
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		t.logger.Debug("retrying http request",
+			zap.String("method", req.Method),
+			zap.String("host", req.URL.Host),
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+		t.stats.Increment(fmt.Sprintf("http.client.%s.retry", t.name))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry reports whether the outcome of an attempt is worth retrying
+func (t *retryTransport) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// An open breaker fails fast; retrying would only delay the caller
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	return t.retryOn[resp.StatusCode]
+}
+
+// backoff returns the wait before the next attempt, preferring the server's
+// Retry-After (in seconds) when present
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			if wait := time.Duration(secs) * time.Second; wait <= t.config.MaxBackoff {
+				return wait
+			}
+			return t.config.MaxBackoff
+		}
+	}
+
+	ceiling := t.config.InitialBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > t.config.MaxBackoff {
+		ceiling = t.config.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// isRetryable reports whether req may be sent more than once: the method
+// must be idempotent (or carry an Idempotency-Key) and the body replayable
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		if req.Header.Get(IdempotencyKeyHeader) == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind clones req with a fresh copy of its body
+func rewind(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", err)
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
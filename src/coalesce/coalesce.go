@@ -0,0 +1,309 @@
+// Package coalesce collapses concurrent identical GET requests into one
+// execution of the handler and sends its response to every caller, so a
+// hot endpoint whose cache just expired queries the database once rather
+// than once per waiting client.
+//
+// Only requests in flight at the same time are coalesced: nothing is
+// cached once the response is sent. Routes opt in, either in config or
+// with r.With(coalescer.Coalesce), and should be idempotent GETs whose
+// response depends only on the host, the URL, the tenant and the key
+// headers.
+package coalesce
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/tenancy"
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// credentialHeaders carry a caller's identity. Requests with one are only
+// coalesced when it is a key header, so a response is never shared with a
+// different caller.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// Coalescer shares the executions of identical requests
+type Coalescer struct {
+	logger       *zap.Logger
+	stats        metrics.Agent
+	keyHeaders   []string
+	maxBodyBytes int64
+	group        singleflight.Group
+
+	// routes matches request paths against the configured patterns
+	routes *chi.Mux
+}
+
+// New creates a coalescer for cfg's routes
+func New(cfg *config.CoalesceConfig, logger *zap.Logger, stats metrics.Agent) *Coalescer {
+	c := &Coalescer{
+		logger:       logger.With(zap.String("component", "coalesce")),
+		stats:        stats,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		routes:       chi.NewMux(),
+	}
+	for _, h := range cfg.KeyHeaders {
+		c.keyHeaders = append(c.keyHeaders, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+	}
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, pattern := range cfg.Routes {
+		c.routes.Get(pattern, noop)
+	}
+	return c
+}
+
+// Middleware coalesces requests to the routes listed in config
+func (c *Coalescer) Middleware(next http.Handler) http.Handler {
+	if len(c.routes.Routes()) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rctx := chi.NewRouteContext()
+		if !c.routes.Match(rctx, http.MethodGet, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.serve(w, r, next, rctx.RoutePattern())
+	})
+}
+
+// Coalesce coalesces requests to the routes it is used on:
+//
+//	r.With(coalescer.Coalesce).Get("/api/v1/products/{id}", h.get)
+func (c *Coalescer) Coalesce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pattern := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			pattern = rctx.RoutePattern()
+		}
+		c.serve(w, r, next, pattern)
+	})
+}
+
+// serve runs next for the first of a set of identical requests and sends
+// its response to all of them
+func (c *Coalescer) serve(w http.ResponseWriter, r *http.Request, next http.Handler, pattern string) {
+	if !c.shareable(r) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	bucket := "coalesce." + metricSegment(pattern)
+	leader := false
+	ch := c.group.DoChan(c.key(r), func() (interface{}, error) {
+		leader = true
+		c.stats.Increment(bucket + ".executions")
+		return c.execute(r, next), nil
+	})
+
+	var resp *response
+	select {
+	case result := <-ch:
+		resp = result.Val.(*response)
+	case <-r.Context().Done():
+		// Gone, or out of time; the execution carries on for the others
+		c.stats.Increment(bucket + ".abandoned")
+		return
+	}
+
+	switch {
+	case resp.panicked != nil && leader:
+		// Let the recoverer report it as if it happened here
+		panic(resp.panicked)
+	case resp.panicked != nil:
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	case resp.overflowed || (!leader && resp.setsCookies()):
+		// Run it again rather than hold an oversized body for everyone or
+		// hand out another client's cookies
+		c.stats.Increment(bucket + ".unshared")
+		next.ServeHTTP(w, r)
+	case leader:
+		resp.writeTo(w, r)
+	default:
+		c.stats.Increment(bucket + ".coalesced")
+		resp.writeTo(w, r)
+	}
+}
+
+// shareable reports whether r's response may go to other callers: it
+// carries no credentials the key leaves out
+func (c *Coalescer) shareable(r *http.Request) bool {
+	for _, h := range credentialHeaders {
+		if r.Header.Get(h) != "" && !slices.Contains(c.keyHeaders, h) {
+			return false
+		}
+	}
+	return true
+}
+
+// key identifies identical requests: same method, host, path, query,
+// tenant and key headers
+func (c *Coalescer) key(r *http.Request) string {
+	tenant, _ := tenancy.FromContext(r.Context())
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	b.WriteString("\ntenant:")
+	b.WriteString(tenant)
+	for _, h := range c.keyHeaders {
+		b.WriteByte('\n')
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// execute runs next into a buffer. It isn't cancelled when the request
+// that started it is, since others may be waiting on it, but keeps its
+// deadline.
+func (c *Coalescer) execute(r *http.Request, next http.Handler) (resp *response) {
+	ctx := context.WithoutCancel(r.Context())
+	if deadline, ok := r.Context().Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	// chi recycles the request's routing context once it returns, which
+	// may be before the execution ends
+	var rctx *chi.Context
+	if orig := chi.RouteContext(ctx); orig != nil {
+		rctx = chi.NewRouteContext()
+		rctx.Routes = orig.Routes
+		rctx.RoutePath = orig.RoutePath
+		rctx.RouteMethod = orig.RouteMethod
+		rctx.RoutePatterns = append(rctx.RoutePatterns, orig.RoutePatterns...)
+		rctx.URLParams.Keys = append(rctx.URLParams.Keys, orig.URLParams.Keys...)
+		rctx.URLParams.Values = append(rctx.URLParams.Values, orig.URLParams.Values...)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	}
+
+	resp = &response{header: make(http.Header), maxBodyBytes: c.maxBodyBytes}
+	defer func() {
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler {
+				c.logger.Error("panic in coalesced request",
+					zap.String("path", r.URL.Path),
+					zap.Any("panic", p),
+					zap.ByteString("stack", debug.Stack()))
+			}
+			resp.panicked = p
+		}
+	}()
+	next.ServeHTTP(resp, r.WithContext(ctx))
+	if rctx != nil {
+		resp.routePatterns = rctx.RoutePatterns
+	}
+	return resp
+}
+
+// response records a response for the requests sharing it
+type response struct {
+	header       http.Header
+	code         int
+	body         bytes.Buffer
+	maxBodyBytes int64
+	overflowed   bool
+	panicked     interface{}
+
+	// routePatterns are the patterns chi matched, when the execution did
+	// the routing
+	routePatterns []string
+}
+
+func (r *response) Header() http.Header {
+	return r.header
+}
+
+func (r *response) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	if r.overflowed {
+		return len(b), nil
+	}
+	if int64(r.body.Len()+len(b)) > r.maxBodyBytes {
+		r.overflowed = true
+		r.body = bytes.Buffer{}
+		return len(b), nil
+	}
+	return r.body.Write(b)
+}
+
+// setsCookies reports whether the response is for the request that
+// produced it only
+func (r *response) setsCookies() bool {
+	return len(r.header.Values("Set-Cookie")) > 0
+}
+
+// writeTo sends the response to req
+func (r *response) writeTo(w http.ResponseWriter, req *http.Request) {
+	if rctx := chi.RouteContext(req.Context()); rctx != nil && len(rctx.RoutePatterns) == 0 {
+		// Routed by the execution; tell the metrics and logs which route
+		rctx.RoutePatterns = append(rctx.RoutePatterns, r.routePatterns...)
+	}
+	h := w.Header()
+	for k, v := range r.header {
+		h[k] = append([]string(nil), v...)
+	}
+	code := r.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	w.Write(r.body.Bytes())
+}
+
+// metricSegment makes a route pattern safe for a metric bucket, e.g.
+// "/api/v1/products/{id}" becomes "api_v1_products_id"
+func metricSegment(pattern string) string {
+	if pattern == "" {
+		return "unmatched"
+	}
+	var b strings.Builder
+	for _, r := range strings.Trim(pattern, "/") {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r + 'a' - 'A')
+		case r == '/' || r == '_':
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "root"
+	}
+	return b.String()
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
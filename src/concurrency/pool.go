@@ -0,0 +1,203 @@
+package concurrency
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrRejected is returned by Submit when the queue is full under the
+	// Reject policy
+	ErrRejected = errors.New("concurrency: pool queue is full")
+	// ErrClosed is returned by Submit once the pool is draining
+	ErrClosed = errors.New("concurrency: pool is closed")
+)
+
+// Policy decides what Submit does when the queue is full
+type Policy string
+
+const (
+	// Block waits for queue space until the submission context is done
+	Block Policy = "block"
+	// Reject fails fast with ErrRejected so callers can shed load, e.g.
+	// by responding 503
+	Reject Policy = "reject"
+	// CallerRuns runs the task in the submitting goroutine, slowing the
+	// producer down to the pool's pace
+	CallerRuns Policy = "caller_runs"
+)
+
+// Task is a unit of work. It receives the context it was submitted with;
+// submit context.WithoutCancel(r.Context()) for work that should outlive
+// a request.
+type Task func(ctx context.Context)
+
+type job struct {
+	ctx      context.Context
+	task     Task
+	enqueued time.Time
+}
+
+// Pool runs tasks on a fixed number of workers fed by a bounded queue
+type Pool struct {
+	name   string
+	policy Policy
+	queue  chan job
+	logger *zap.Logger
+	stats  metrics.Agent
+	prefix string
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	done   chan struct{}
+}
+
+// NewPool starts workers goroutines reading from a queue of queueSize
+// tasks. name labels the pool's metrics: concurrency.<name>.*
+func NewPool(name string, workers, queueSize int, policy Policy, logger *zap.Logger, stats metrics.Agent) (*Pool, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("pool %s needs at least one worker", name)
+	}
+	if queueSize < 0 {
+		return nil, fmt.Errorf("pool %s queue size cannot be negative", name)
+	}
+	switch policy {
+	case "":
+		policy = Block
+	case Block, Reject, CallerRuns:
+	default:
+		return nil, fmt.Errorf("unsupported pool policy %q", policy)
+	}
+
+	p := &Pool{
+		name:   name,
+		policy: policy,
+		queue:  make(chan job, queueSize),
+		logger: logger.With(zap.String("component", "concurrency.pool"), zap.String("pool", name)),
+		stats:  stats,
+		prefix: "concurrency." + name,
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	go func() {
+		p.wg.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Submit queues task, applying the pool's policy when the queue is full.
+// It returns ctx's error if ctx is done before the task is queued.
+func (p *Pool) Submit(ctx context.Context, task Task) error {
+	// Held for reading while sending so Drain cannot close the queue
+	// under a blocked submitter
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+
+	j := job{ctx: ctx, task: task, enqueued: time.Now()}
+	select {
+	case p.queue <- j:
+		p.stats.Gauge(p.prefix+".queue_depth", len(p.queue))
+		return nil
+	default:
+	}
+
+	switch p.policy {
+	case Reject:
+		p.stats.Increment(p.prefix + ".rejected")
+		return ErrRejected
+	case CallerRuns:
+		p.stats.Increment(p.prefix + ".caller_runs")
+		p.run(j)
+		return nil
+	default:
+		p.stats.Increment(p.prefix + ".blocked")
+		select {
+		case p.queue <- j:
+			p.stats.Gauge(p.prefix+".queue_depth", len(p.queue))
+			return nil
+		case <-ctx.Done():
+			p.stats.Increment(p.prefix + ".rejected")
+			return ctx.Err()
+		}
+	}
+}
+
+// Drain stops accepting tasks and waits for queued and running tasks to
+// finish, or for ctx to be done
+func (p *Pool) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pool %s did not drain: %w", p.name, ctx.Err())
+	}
+}
+
+// Start implements app.Component; workers start with the pool
+func (p *Pool) Start() error { return nil }
+
+// Close drains the pool without a deadline; the application bounds it
+// with server.shutdown_timeout
+func (p *Pool) Close() error {
+	return p.Drain(context.Background())
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for j := range p.queue {
+		p.stats.Gauge(p.prefix+".queue_depth", len(p.queue))
+		p.stats.Timing(p.prefix+".wait", time.Since(j.enqueued))
+
+		// Work whose submitter gave up is skipped rather than run late
+		if j.ctx.Err() != nil {
+			p.stats.Increment(p.prefix + ".expired")
+			continue
+		}
+		// Nobody waits on a queued task, so its queries use the background
+		// partition of the database pool unless it was given another; a
+		// task its caller runs keeps the caller's
+		if storage.PartitionOf(j.ctx) == storage.Interactive {
+			j.ctx = storage.WithPartition(j.ctx, storage.Background)
+		}
+		p.run(j)
+	}
+}
+
+// run executes a task, recovering panics so one bad task cannot take a
+// worker down
+func (p *Pool) run(j job) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("pool task panicked",
+				zap.Any("panic", r),
+				zap.ByteString("stack", debug.Stack()))
+			p.stats.Increment(p.prefix + ".panic")
+		}
+		p.stats.Timing(p.prefix+".duration", time.Since(start))
+	}()
+	j.task(j.ctx)
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -10,23 +12,79 @@ import (
 )
 
 type Config struct {
-	Server   *ServerConfig   `json:"server" yaml:"server"`
-	Database *DatabaseConfig `json:"database" yaml:"database"`
-	Logger   *LoggerConfig   `json:"logger" yaml:"logger"`
-	Metrics  *MetricsConfig  `json:"metrics" yaml:"metrics"`
-	App      *AppConfig      `json:"app" yaml:"app"`
+	Server      *ServerConfig      `json:"server" yaml:"server"`
+	Database    *DatabaseConfig    `json:"database" yaml:"database"`
+	Logger      *LoggerConfig      `json:"logger" yaml:"logger"`
+	Metrics     *MetricsConfig     `json:"metrics" yaml:"metrics"`
+	Tracing     *TracingConfig     `json:"tracing" yaml:"tracing"`
+	Clients     *ClientsConfig     `json:"clients" yaml:"clients"`
+	GraphQL     *GraphQLConfig     `json:"graphql" yaml:"graphql"`
+	Webhooks    *WebhooksConfig    `json:"webhooks" yaml:"webhooks"`
+	Scheduler   *SchedulerConfig   `json:"scheduler" yaml:"scheduler"`
+	Outbox      *OutboxConfig      `json:"outbox" yaml:"outbox"`
+	Events      *EventsConfig      `json:"events" yaml:"events"`
+	Messaging   *MessagingConfig   `json:"messaging" yaml:"messaging"`
+	Redis       *RedisConfig       `json:"redis" yaml:"redis"`
+	Cache       *CacheConfig       `json:"cache" yaml:"cache"`
+	Flags       *FlagsConfig       `json:"feature_flags" yaml:"feature_flags"`
+	RateLimit   *RateLimitConfig   `json:"rate_limit" yaml:"rate_limit"`
+	IPFilter    *IPFilterConfig    `json:"ip_filter" yaml:"ip_filter"`
+	Bots        *BotsConfig        `json:"bot_detection" yaml:"bot_detection"`
+	Shedding    *LoadShedConfig    `json:"load_shedding" yaml:"load_shedding"`
+	Email       *EmailConfig       `json:"email" yaml:"email"`
+	Notify      *NotifyConfig      `json:"notifications" yaml:"notifications"`
+	Blob        *BlobConfig        `json:"blob" yaml:"blob"`
+	Search      *SearchConfig      `json:"search" yaml:"search"`
+	Tenancy     *TenancyConfig     `json:"tenancy" yaml:"tenancy"`
+	Auth        *AuthConfig        `json:"auth" yaml:"auth"`
+	Encryption  *EncryptionConfig  `json:"encryption" yaml:"encryption"`
+	Render      *RenderConfig      `json:"render" yaml:"render"`
+	I18n        *I18nConfig        `json:"i18n" yaml:"i18n"`
+	WorkerPool  *WorkerPoolConfig  `json:"worker_pool" yaml:"worker_pool"`
+	Chaos       *ChaosConfig       `json:"chaos" yaml:"chaos"`
+	OpenAPI     *OpenAPIConfig     `json:"openapi" yaml:"openapi"`
+	Batch       *BatchConfig       `json:"batch" yaml:"batch"`
+	Deprecation *DeprecationConfig `json:"deprecation" yaml:"deprecation"`
+	Coalesce    *CoalesceConfig    `json:"coalesce" yaml:"coalesce"`
+	GeoIP       *GeoIPConfig       `json:"geoip" yaml:"geoip"`
+	Operations  *OperationsConfig  `json:"operations" yaml:"operations"`
+	Imports     *ImportsConfig     `json:"imports" yaml:"imports"`
+	Reports     *ReportsConfig     `json:"reports" yaml:"reports"`
+	AdminUI     *AdminUIConfig     `json:"admin_ui" yaml:"admin_ui"`
+	Routes      []*RouteConfig     `json:"routes" yaml:"routes"`
+	Crash       *CrashConfig       `json:"crash_reports" yaml:"crash_reports"`
+	App         *AppConfig         `json:"app" yaml:"app"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Host            string        `json:"host" yaml:"host"`
-	Port            int           `json:"port" yaml:"port"`
-	ReadTimeout     time.Duration `json:"read_timeout" yaml:"read_timeout"`
-	WriteTimeout    time.Duration `json:"write_timeout" yaml:"write_timeout"`
-	IdleTimeout     time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
-	ShutdownTimeout time.Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
-	TLS             *TLSConfig    `json:"tls" yaml:"tls"`
-	CORS            *CORSConfig   `json:"cors" yaml:"cors"`
+	Host            string                `json:"host" yaml:"host"`
+	Port            int                   `json:"port" yaml:"port"` // 0 picks any free port, e.g. in tests
+	ReadTimeout     time.Duration         `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout    time.Duration         `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout     time.Duration         `json:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration         `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	StartTimeout    time.Duration         `json:"start_timeout" yaml:"start_timeout"` // per-hook timeout for startup
+	TLS             *TLSConfig            `json:"tls" yaml:"tls"`
+	CORS            *CORSConfig           `json:"cors" yaml:"cors"`
+	RequestID       *RequestIDConfig      `json:"request_id" yaml:"request_id"`
+	RealIP          *RealIPConfig         `json:"real_ip" yaml:"real_ip"`
+	Admin           *AdminServerConfig    `json:"admin" yaml:"admin"`
+	Middleware      []MiddlewareConfig    `json:"middleware" yaml:"middleware"`         // ordered; empty means DefaultMiddleware()
+	HealthTimeout   time.Duration         `json:"health_timeout" yaml:"health_timeout"` // per-check timeout for /health
+	Health          *HealthConfig         `json:"health" yaml:"health"`
+	Readiness       *ReadinessConfig      `json:"readiness" yaml:"readiness"`
+	Warmup          *WarmupConfig         `json:"warmup" yaml:"warmup"`
+	BindRetry       *BindRetryConfig      `json:"bind_retry" yaml:"bind_retry"`
+	ShutdownPhases  *ShutdownPhasesConfig `json:"shutdown_phases" yaml:"shutdown_phases"`
+	Listener        *ListenerConfig       `json:"listener" yaml:"listener"` // nil listens on TCP at host:port
+	ProxyProtocol   *ProxyProtocolConfig  `json:"proxy_protocol" yaml:"proxy_protocol"`
+	// ReadHeaderTimeout bounds how long a client may take to send request
+	// headers, the main defense against slowloris clients; zero falls back
+	// to ReadTimeout
+	ReadHeaderTimeout time.Duration      `json:"read_header_timeout" yaml:"read_header_timeout"`
+	MaxHeaderBytes    int                `json:"max_header_bytes" yaml:"max_header_bytes"`
+	Connections       *ConnectionsConfig `json:"connections" yaml:"connections"`
 }
 
 // GetAddress returns the full server address
@@ -36,19 +94,279 @@ func (s ServerConfig) Address() string {
 
 // TLSConfig holds TLS configuration
 type TLSConfig struct {
-	Enabled  bool   `json:"enabled" yaml:"enabled"`
-	CertFile string `json:"cert_file" yaml:"cert_file"`
-	KeyFile  string `json:"key_file" yaml:"key_file"`
+	Enabled  bool              `json:"enabled" yaml:"enabled"`
+	CertFile string            `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string            `json:"key_file" yaml:"key_file"`
+	Expiry   *CertExpiryConfig `json:"expiry" yaml:"expiry"`
 }
 
-// CORSConfig holds CORS configuration
+// CertExpiryConfig watches the served certificate, exporting the days it
+// has left every Interval and warning once fewer than WarnBefore remain
+type CertExpiryConfig struct {
+	Enabled    bool          `json:"enabled" yaml:"enabled"`
+	Interval   time.Duration `json:"interval" yaml:"interval"`
+	WarnBefore time.Duration `json:"warn_before" yaml:"warn_before"`
+}
+
+// CORSConfig holds CORS configuration. Resolve applies the preset and
+// environment defaults.
 type CORSConfig struct {
-	AllowedOrigins   []string `json:"allowed_origins" yaml:"allowed_origins"`
+	Preset           string   `json:"preset" yaml:"preset"`                   // internal, public-readonly
+	AllowedOrigins   []string `json:"allowed_origins" yaml:"allowed_origins"` // empty allows any in development, none elsewhere
 	AllowedMethods   []string `json:"allowed_methods" yaml:"allowed_methods"`
 	AllowedHeaders   []string `json:"allowed_headers" yaml:"allowed_headers"`
 	ExposedHeaders   []string `json:"exposed_headers" yaml:"exposed_headers"`
 	AllowCredentials bool     `json:"allow_credentials" yaml:"allow_credentials"`
 	MaxAge           int      `json:"max_age" yaml:"max_age"`
+	AllowWildcard    bool     `json:"allow_wildcard" yaml:"allow_wildcard"` // permits "*" origins in production
+}
+
+// RequestIDConfig holds request ID propagation configuration
+type RequestIDConfig struct {
+	Header         string   `json:"header" yaml:"header"`                   // X-Request-ID
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"` // IPs/CIDRs whose incoming IDs are accepted
+}
+
+// RealIPConfig holds the proxies the real_ip middleware reads client
+// addresses from
+type RealIPConfig struct {
+	TrustedProxies []string `json:"trusted_proxies" yaml:"trusted_proxies"` // IPs/CIDRs whose X-Forwarded-For and X-Real-IP are read
+}
+
+// HealthConfig tunes the health checks. CacheTTL reuses each check's
+// result across /health requests; Checks overrides individual checks by
+// name, e.g. to make an optional dependency non-critical so its failure
+// degrades the service instead of taking it out of rotation.
+type HealthConfig struct {
+	CacheTTL time.Duration                 `json:"cache_ttl" yaml:"cache_ttl"`
+	Checks   map[string]*HealthCheckConfig `json:"checks" yaml:"checks"`
+}
+
+// HealthCheckConfig overrides one health check. Unset fields keep what the
+// check was registered with.
+type HealthCheckConfig struct {
+	Critical *bool         `json:"critical" yaml:"critical"`
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"` // overrides server.health_timeout
+}
+
+// ReadinessConfig gates startup until the health checks pass, so the
+// service can start before its dependencies instead of crash-looping
+type ReadinessConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	MaxWait        time.Duration `json:"max_wait" yaml:"max_wait"` // give up and exit after this long
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff" yaml:"max_backoff"`
+}
+
+// WarmupConfig runs the registered warmers and then synthetic requests
+// once the servers listen, before /readyz reports ready, so the first real
+// requests after a deploy don't pay for cold caches and connections
+type WarmupConfig struct {
+	Enabled bool          `json:"enabled" yaml:"enabled"`
+	Timeout time.Duration `json:"timeout" yaml:"timeout"` // for the whole warmup; /readyz flips when it runs out
+	// Connections is how many database connections to open; 0 means
+	// database.max_idle_conns, and a negative value skips the database
+	Connections int      `json:"connections" yaml:"connections"`
+	Statements  []string `json:"statements" yaml:"statements"` // prepared on each connection
+	// Requests are served in-process by the public router, through the
+	// whole middleware pipeline, in order
+	Requests []WarmupRequestConfig `json:"requests" yaml:"requests"`
+}
+
+// WarmupRequestConfig is a synthetic request made during warmup
+type WarmupRequestConfig struct {
+	Method  string            `json:"method" yaml:"method"` // GET when empty
+	Path    string            `json:"path" yaml:"path"`
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	Body    string            `json:"body" yaml:"body"`
+	Repeat  int               `json:"repeat" yaml:"repeat"` // times to send it; 0 means once
+	Status  int               `json:"status" yaml:"status"` // expected; 0 accepts anything below 500
+}
+
+// ListenerConfig selects what the public server listens on: TCP at
+// host:port, a unix socket, e.g. for a local proxy, or a socket passed by
+// systemd socket activation
+type ListenerConfig struct {
+	Network string `json:"network" yaml:"network"` // tcp, unix, systemd
+	Path    string `json:"path" yaml:"path"`       // unix socket path
+	Mode    string `json:"mode" yaml:"mode"`       // unix socket permissions in octal, e.g. "0660"; empty leaves the umask default
+	Name    string `json:"name" yaml:"name"`       // systemd FileDescriptorName to use; empty takes the first socket passed
+}
+
+// ConnectionsConfig limits the public listener's open connections, so a
+// flood from one client or many can't exhaust file descriptors
+type ConnectionsConfig struct {
+	Max      int `json:"max" yaml:"max"`               // open connections at most; 0 means unlimited
+	MaxPerIP int `json:"max_per_ip" yaml:"max_per_ip"` // open connections per client IP at most; 0 means unlimited
+}
+
+// ProxyProtocolConfig reads the PROXY protocol (v1 or v2) header that L4
+// load balancers such as HAProxy or an AWS NLB in TCP mode send ahead of
+// each connection, so the server sees the client's address rather than the
+// balancer's
+type ProxyProtocolConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	TrustedProxies []string      `json:"trusted_proxies" yaml:"trusted_proxies"` // IPs/CIDRs whose headers are read; empty trusts every peer
+	HeaderTimeout  time.Duration `json:"header_timeout" yaml:"header_timeout"`
+}
+
+// BindRetryConfig retries binding an address that is still in use, e.g.
+// by the previous instance during a restart
+type BindRetryConfig struct {
+	Attempts       int           `json:"attempts" yaml:"attempts"` // including the first; 1 means no retries
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff" yaml:"max_backoff"`
+}
+
+// ShutdownPhasesConfig bounds each shutdown phase, run after the servers
+// stop accepting requests: stopping consumers, flushing buffered work,
+// then closing connections. Zero leaves a phase bounded only by its
+// hooks' timeouts.
+type ShutdownPhasesConfig struct {
+	Consumers time.Duration `json:"consumers" yaml:"consumers"`
+	Flush     time.Duration `json:"flush" yaml:"flush"`
+	Close     time.Duration `json:"close" yaml:"close"`
+}
+
+// AdminServerConfig holds the optional internal listener for /admin
+// routes. When disabled, admin routes are served by the public server if
+// a token protects them. Port 0 picks any free port, as for server.port.
+type AdminServerConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Host    string `json:"host" yaml:"host"`
+	Port    int    `json:"port" yaml:"port"`
+	// Token is the X-Admin-Token header value admin routes require when
+	// they are served on the public listener; without a listener or a token they aren't
+	// served at all
+	Token string `json:"token" yaml:"token"`
+}
+
+// MiddlewareConfig configures one entry of the HTTP middleware pipeline
+type MiddlewareConfig struct {
+	Name     string            `json:"name" yaml:"name"`
+	Disabled bool              `json:"disabled" yaml:"disabled"`
+	Options  MiddlewareOptions `json:"options" yaml:"options"`
+}
+
+// MiddlewareOptions holds free-form per-middleware options
+type MiddlewareOptions map[string]interface{}
+
+// String returns the string option key, or def when unset
+func (o MiddlewareOptions) String(key, def string) (string, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("option %s must be a string", key)
+	}
+	return s, nil
+}
+
+// Int returns the integer option key, or def when unset
+func (o MiddlewareOptions) Int(key string, def int) (int, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("option %s must be an integer", key)
+	}
+}
+
+// Float returns the numeric option key, or def when unset
+func (o MiddlewareOptions) Float(key string, def float64) (float64, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	default:
+		return 0, fmt.Errorf("option %s must be a number", key)
+	}
+}
+
+// Bool returns the boolean option key, or def when unset
+func (o MiddlewareOptions) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %s must be a boolean", key)
+	}
+	return b, nil
+}
+
+// Duration returns the duration option key (e.g. "30s"), or def when unset
+func (o MiddlewareOptions) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("option %s must be a duration string", key)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// Strings returns the string list option key, or def when unset
+func (o MiddlewareOptions) Strings(key string, def []string) ([]string, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %s must be a list of strings", key)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("option %s must be a list of strings", key)
+	}
+}
+
+// DefaultMiddleware returns the built-in middleware pipeline, in order
+func DefaultMiddleware() []MiddlewareConfig {
+	return []MiddlewareConfig{
+		{Name: "request_id"},
+		{Name: "real_ip"},
+		{Name: "tracing"},
+		{Name: "metrics"},
+		{Name: "logger"},
+		{Name: "fields"},
+		{Name: "recoverer"},
+		{Name: "timeout", Options: MiddlewareOptions{"timeout": "60s"}},
+		{Name: "cors"},
+	}
 }
 
 // DatabaseConfig holds database configuration
@@ -67,22 +385,122 @@ type DatabaseConfig struct {
 	ConnMaxIdleTime    time.Duration `json:"conn_max_idle_time" yaml:"conn_max_idle_time"`
 	LogSlowQueries     bool          `json:"log_slow_queries" yaml:"log_slow_queries"`
 	SlowQueryThreshold time.Duration `json:"slow_query_threshold" yaml:"slow_query_threshold"`
+	// MinQueryBudget fails a call at once, without borrowing a connection,
+	// when its context's deadline is closer than this, since the query
+	// couldn't finish in time; 0 fails only once the deadline has passed
+	MinQueryBudget time.Duration `json:"min_query_budget" yaml:"min_query_budget"`
+	// Explain logs the plans of queries slower than SlowQueryThreshold
+	Explain *ExplainConfig `json:"explain" yaml:"explain"`
+	// QueryStats reports the top statements from pg_stat_statements
+	QueryStats *QueryStatsConfig `json:"query_stats" yaml:"query_stats"`
+	// SearchPath sets the Postgres schema search path, e.g. "tenant_a,public"
+	SearchPath    string               `json:"search_path" yaml:"search_path"`
+	LeakDetection *LeakDetectionConfig `json:"leak_detection" yaml:"leak_detection"`
+	// AuditContexts warns about calls from request paths whose context
+	// ignores the request's cancellation. It's a development aid that walks
+	// the stack, so it can't be enabled in production.
+	AuditContexts bool            `json:"audit_contexts" yaml:"audit_contexts"`
+	Failover      *FailoverConfig `json:"failover" yaml:"failover"`
+	// Credentials reads User and Password from a secret source, so they
+	// can be rotated without a restart
+	Credentials *CredentialsConfig `json:"credentials" yaml:"credentials"`
+	// AuthMethod is "password", the default, or "aws_iam" or "gcp_iam" to
+	// log in as User with short-lived IAM tokens instead of a password
+	AuthMethod string             `json:"auth_method" yaml:"auth_method"`
+	IAM        *DatabaseIAMConfig `json:"iam" yaml:"iam"`
+	// Partitions carves connections out of MaxOpenConns into separate
+	// pools by name, e.g. {"background": 5}; calls pick theirs with
+	// storage.WithPartition and the rest use "interactive", which keeps
+	// what is left, so background work can't starve requests
+	Partitions map[string]int `json:"partitions" yaml:"partitions"`
 }
 
-// GetDSN returns the database connection string
-func (d DatabaseConfig) GetDSN() string {
-	switch d.Driver {
-	case "postgres", "postgresql":
-		return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
-			d.Host, d.Port, d.User, d.Password, d.Name, d.SSLMode, int(d.ConnectTimeout.Seconds()))
-	case "mysql":
-		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=%s",
-			d.User, d.Password, d.Host, d.Port, d.Name, d.ConnectTimeout)
-	case "sqlite", "sqlite3":
-		return d.Name
-	default:
-		return ""
+// DatabaseIAMConfig holds the cloud settings of IAM database authentication
+type DatabaseIAMConfig struct {
+	// Region is the RDS instance's AWS region; empty uses the environment's
+	Region string `json:"region" yaml:"region"`
+	// CredentialsFile is a Google service account key; empty uses
+	// Application Default Credentials
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file"`
+}
+
+// CredentialsConfig names where the database user and password come from.
+// The source is read again when the database rejects them, when its
+// refresh interval elapses, and on POST /admin/database/rotate-credentials;
+// when they have changed, the pool is rebuilt and connections made with
+// the old ones close as they are released.
+type CredentialsConfig struct {
+	// Source is "file", or empty for database.user and database.password
+	Source string `json:"source" yaml:"source"`
+	// File is a YAML or JSON file with user and password keys, such as a
+	// mounted Kubernetes secret or one rendered by Vault Agent
+	File string `json:"file" yaml:"file"`
+	// RefreshInterval is how often the source is checked; 0 checks only on
+	// authentication failures and rotation requests
+	RefreshInterval time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+}
+
+// FailoverConfig follows a Postgres primary across a cluster's hosts, e.g.
+// one managed by Patroni. Connections go to the first writable host in
+// Hosts, tried in order of preference; when a connection reports the
+// server is read-only or shutting down, the pool is reset and the primary
+// is discovered again.
+type FailoverConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Hosts are "host" or "host:port" entries, database.port by default.
+	// database.host is ignored when failover is enabled.
+	Hosts []string `json:"hosts" yaml:"hosts"`
+	// ProbeTimeout bounds connecting to and probing one host
+	ProbeTimeout time.Duration `json:"probe_timeout" yaml:"probe_timeout"`
+}
+
+// SplitHostPort splits a "host" or "host:port" entry, using defaultPort
+// when the entry has none
+func SplitHostPort(entry string, defaultPort int) (string, int, error) {
+	if entry == "" {
+		return "", 0, fmt.Errorf("empty host")
+	}
+	host, portStr, err := net.SplitHostPort(entry)
+	if err != nil {
+		// No port; bracketed IPv6 literals keep their brackets otherwise
+		return strings.Trim(entry, "[]"), defaultPort, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 || host == "" {
+		return "", 0, fmt.Errorf("invalid host %q", entry)
 	}
+	return host, port, nil
+}
+
+// LeakDetectionConfig tracks open transactions and prepared statements,
+// warning about handles held longer than MaxAge or more than MaxOpen at
+// once. StackSampleRate is the fraction of handles that record where they
+// were opened, between 0 and 1.
+type LeakDetectionConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`
+	MaxAge          time.Duration `json:"max_age" yaml:"max_age"`
+	MaxOpen         int           `json:"max_open" yaml:"max_open"`
+	StackSampleRate float64       `json:"stack_sample_rate" yaml:"stack_sample_rate"`
+	Interval        time.Duration `json:"interval" yaml:"interval"`
+}
+
+// ExplainConfig holds the settings of slow query plan capture. Plans are
+// made in the background with EXPLAIN, which doesn't run the query, on a
+// connection of the background pool partition.
+type ExplainConfig struct {
+	Enabled      bool          `json:"enabled" yaml:"enabled"`
+	MaxPerMinute int           `json:"max_per_minute" yaml:"max_per_minute"` // across all statements
+	Cooldown     time.Duration `json:"cooldown" yaml:"cooldown"`             // before a statement is explained again
+	Timeout      time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// QueryStatsConfig holds the settings of the top statement report, read
+// from the pg_stat_statements extension when it is installed
+type QueryStatsConfig struct {
+	Enabled  bool          `json:"enabled" yaml:"enabled"`
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	Limit    int           `json:"limit" yaml:"limit"` // statements per report, and listed by default
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`
 }
 
 // LoggerConfig holds logger configuration
@@ -104,7 +522,7 @@ type LoggerConfig struct {
 // MetricsConfig holds metrics/StatsD configuration
 type MetricsConfig struct {
 	Enabled        bool          `json:"enabled" yaml:"enabled"`
-	Type           string        `json:"type" yaml:"type"`                       // alexcesaro, custom, mock, buffered
+	Type           string        `json:"type" yaml:"type"`                       // alexcesaro, buffered, prometheus, otel, inmemory, mock, noop
 	Address        string        `json:"address" yaml:"address"`                 // localhost:8125
 	Prefix         string        `json:"prefix" yaml:"prefix"`                   // myapp
 	UnderlyingType string        `json:"underlying_type" yaml:"underlying_type"` // for buffered client
@@ -112,6 +530,816 @@ type MetricsConfig struct {
 	FlushInterval  time.Duration `json:"flush_interval" yaml:"flush_interval"`   // for buffered client
 	ReportInterval time.Duration `json:"report_interval" yaml:"report_interval"` // for periodic stats
 	Tags           []string      `json:"tags" yaml:"tags"`                       // global tags
+
+	// ReconnectInterval re-dials the sink, picking up DNS changes and a
+	// restarted agent; 0 keeps the first connection
+	ReconnectInterval time.Duration `json:"reconnect_interval" yaml:"reconnect_interval"`
+	// HealthCheck adds the sink to /health, which then fails while metrics
+	// can't be sent
+	HealthCheck bool `json:"health_check" yaml:"health_check"`
+	// Path serves what the prometheus, inmemory and mock types record, on
+	// the admin listener
+	Path string `json:"path" yaml:"path"`
+}
+
+// TracingConfig holds OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Enabled     bool     `json:"enabled" yaml:"enabled"`
+	Exporter    string   `json:"exporter" yaml:"exporter"`         // otlp-grpc, otlp-http, stdout
+	Endpoint    string   `json:"endpoint" yaml:"endpoint"`         // localhost:4317
+	Insecure    bool     `json:"insecure" yaml:"insecure"`         // disable TLS to the collector
+	ServiceName string   `json:"service_name" yaml:"service_name"` // defaults to app.name
+	SampleRatio float64  `json:"sample_ratio" yaml:"sample_ratio"` // 0.0 - 1.0, parent-based
+	Propagators []string `json:"propagators" yaml:"propagators"`   // tracecontext, baggage, b3, b3multi
+}
+
+// ClientsConfig holds outbound client configuration
+type ClientsConfig struct {
+	// HTTP clients keyed by name; "default" applies to names not listed
+	HTTP map[string]*HTTPClientConfig `json:"http" yaml:"http"`
+}
+
+// HTTPClientConfig holds configuration for an outbound HTTP client.
+// Zero values fall back to DefaultHTTPClientConfig.
+type HTTPClientConfig struct {
+	Timeout               time.Duration         `json:"timeout" yaml:"timeout"` // overall request timeout, including retries
+	DialTimeout           time.Duration         `json:"dial_timeout" yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration         `json:"tls_handshake_timeout" yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration         `json:"response_header_timeout" yaml:"response_header_timeout"`
+	IdleConnTimeout       time.Duration         `json:"idle_conn_timeout" yaml:"idle_conn_timeout"`
+	MaxIdleConns          int                   `json:"max_idle_conns" yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int                   `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int                   `json:"max_conns_per_host" yaml:"max_conns_per_host"`
+	Retry                 *RetryConfig          `json:"retry" yaml:"retry"`
+	CircuitBreaker        *CircuitBreakerConfig `json:"circuit_breaker" yaml:"circuit_breaker"`
+}
+
+// RetryConfig holds retry/backoff configuration for outbound calls
+type RetryConfig struct {
+	MaxAttempts    int           `json:"max_attempts" yaml:"max_attempts"` // including the first attempt
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff" yaml:"max_backoff"`
+	RetryOnStatus  []int         `json:"retry_on_status" yaml:"retry_on_status"`
+}
+
+// CircuitBreakerConfig holds per-host circuit breaker configuration
+type CircuitBreakerConfig struct {
+	Enabled          bool          `json:"enabled" yaml:"enabled"`
+	FailureThreshold int           `json:"failure_threshold" yaml:"failure_threshold"` // consecutive failures before opening
+	OpenTimeout      time.Duration `json:"open_timeout" yaml:"open_timeout"`           // time before a half-open probe
+}
+
+// DefaultHTTPClientConfig returns the outbound HTTP client defaults
+func DefaultHTTPClientConfig() *HTTPClientConfig {
+	return &HTTPClientConfig{
+		Timeout:               30 * time.Second,
+		DialTimeout:           5 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		MaxConnsPerHost:       0, // unlimited
+		Retry: &RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     2 * time.Second,
+			RetryOnStatus:  []int{502, 503, 504},
+		},
+		CircuitBreaker: &CircuitBreakerConfig{
+			Enabled:          true,
+			FailureThreshold: 5,
+			OpenTimeout:      30 * time.Second,
+		},
+	}
+}
+
+// GraphQLConfig holds configuration for the optional GraphQL endpoint
+type GraphQLConfig struct {
+	Enabled         bool   `json:"enabled" yaml:"enabled"`
+	Path            string `json:"path" yaml:"path"`                       // /graphql
+	Playground      bool   `json:"playground" yaml:"playground"`           // never served in production
+	PlaygroundPath  string `json:"playground_path" yaml:"playground_path"` // /graphql/playground
+	Introspection   bool   `json:"introspection" yaml:"introspection"`
+	ComplexityLimit int    `json:"complexity_limit" yaml:"complexity_limit"` // 0 disables the limit
+}
+
+// WebhooksConfig holds outbound webhook delivery configuration
+type WebhooksConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	Workers        int           `json:"workers" yaml:"workers"`             // concurrent deliveries per instance
+	PollInterval   time.Duration `json:"poll_interval" yaml:"poll_interval"` // how often to look for due deliveries
+	BatchSize      int           `json:"batch_size" yaml:"batch_size"`       // deliveries claimed per poll
+	MaxAttempts    int           `json:"max_attempts" yaml:"max_attempts"`   // before dead-lettering
+	InitialBackoff time.Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff" yaml:"max_backoff"`
+	RequestTimeout time.Duration `json:"request_timeout" yaml:"request_timeout"`
+	SecretOverlap  time.Duration `json:"secret_overlap" yaml:"secret_overlap"` // old secrets stay valid after rotation
+}
+
+// SchedulerConfig holds recurring task scheduler configuration
+type SchedulerConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	Location       string        `json:"location" yaml:"location"`               // time zone for cron expressions, default UTC
+	DefaultTimeout time.Duration `json:"default_timeout" yaml:"default_timeout"` // per-run timeout and lease
+}
+
+// OutboxConfig holds transactional outbox relay configuration
+type OutboxConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	PollInterval   time.Duration `json:"poll_interval" yaml:"poll_interval"`
+	BatchSize      int           `json:"batch_size" yaml:"batch_size"`
+	PublishTimeout time.Duration `json:"publish_timeout" yaml:"publish_timeout"`
+	Retention      time.Duration `json:"retention" yaml:"retention"` // published events older than this are deleted; 0 keeps them
+}
+
+// EventsConfig holds the domain event log, kept for replaying into read
+// models, and the consumers reading it
+type EventsConfig struct {
+	Enabled      bool          `json:"enabled" yaml:"enabled"`
+	Publish      bool          `json:"publish" yaml:"publish"`             // also write appended events to the outbox
+	PollInterval time.Duration `json:"poll_interval" yaml:"poll_interval"` // how often consumers look for new events
+	BatchSize    int           `json:"batch_size" yaml:"batch_size"`       // events per consumer transaction
+}
+
+// MessagingConfig selects and configures the message broker
+type MessagingConfig struct {
+	Driver string       `json:"driver" yaml:"driver"` // kafka, nats; empty disables messaging
+	Kafka  *KafkaConfig `json:"kafka" yaml:"kafka"`
+	NATS   *NATSConfig  `json:"nats" yaml:"nats"`
+}
+
+// KafkaConfig holds Kafka producer and consumer configuration
+type KafkaConfig struct {
+	Brokers            []string         `json:"brokers" yaml:"brokers"`
+	ClientID           string           `json:"client_id" yaml:"client_id"`
+	GroupID            string           `json:"group_id" yaml:"group_id"` // consumer group shared by all instances
+	TLS                *ClientTLSConfig `json:"tls" yaml:"tls"`
+	SASL               *SASLConfig      `json:"sasl" yaml:"sasl"`
+	RequiredAcks       string           `json:"required_acks" yaml:"required_acks"` // none, one, all
+	Compression        string           `json:"compression" yaml:"compression"`     // none, gzip, snappy, lz4, zstd
+	BatchSize          int              `json:"batch_size" yaml:"batch_size"`
+	BatchTimeout       time.Duration    `json:"batch_timeout" yaml:"batch_timeout"`
+	WriteTimeout       time.Duration    `json:"write_timeout" yaml:"write_timeout"`
+	StartOffset        string           `json:"start_offset" yaml:"start_offset"` // earliest, latest; for new groups only
+	SessionTimeout     time.Duration    `json:"session_timeout" yaml:"session_timeout"`
+	RebalanceTimeout   time.Duration    `json:"rebalance_timeout" yaml:"rebalance_timeout"`
+	HandlerTimeout     time.Duration    `json:"handler_timeout" yaml:"handler_timeout"`
+	HandlerMaxAttempts int              `json:"handler_max_attempts" yaml:"handler_max_attempts"` // before the message is skipped
+}
+
+// NATSConfig holds NATS and JetStream configuration
+type NATSConfig struct {
+	Servers         []string         `json:"servers" yaml:"servers"`
+	Name            string           `json:"name" yaml:"name"` // connection name shown in server monitoring
+	CredentialsFile string           `json:"credentials_file" yaml:"credentials_file"`
+	Token           string           `json:"token" yaml:"token"`
+	Username        string           `json:"username" yaml:"username"`
+	Password        string           `json:"password" yaml:"password"`
+	TLS             *ClientTLSConfig `json:"tls" yaml:"tls"`
+	ConnectTimeout  time.Duration    `json:"connect_timeout" yaml:"connect_timeout"`
+	ReconnectWait   time.Duration    `json:"reconnect_wait" yaml:"reconnect_wait"`
+	MaxReconnects   int              `json:"max_reconnects" yaml:"max_reconnects"` // -1 retries forever
+	QueueGroup      string           `json:"queue_group" yaml:"queue_group"`       // shared by all instances so each message is handled once
+	HandlerTimeout  time.Duration    `json:"handler_timeout" yaml:"handler_timeout"`
+	JetStream       *JetStreamConfig `json:"jetstream" yaml:"jetstream"`
+}
+
+// JetStreamConfig holds JetStream durable consumer configuration
+type JetStreamConfig struct {
+	Enabled       bool          `json:"enabled" yaml:"enabled"`
+	Stream        string        `json:"stream" yaml:"stream"`   // existing stream that captures the subscribed subjects
+	Durable       string        `json:"durable" yaml:"durable"` // consumer name prefix; the subject is appended
+	AckWait       time.Duration `json:"ack_wait" yaml:"ack_wait"`
+	MaxDeliver    int           `json:"max_deliver" yaml:"max_deliver"`
+	MaxAckPending int           `json:"max_ack_pending" yaml:"max_ack_pending"`
+	NakDelay      time.Duration `json:"nak_delay" yaml:"nak_delay"` // redelivery delay after a handler error
+}
+
+// RedisConfig holds Redis client configuration
+type RedisConfig struct {
+	Enabled              bool             `json:"enabled" yaml:"enabled"`
+	Mode                 string           `json:"mode" yaml:"mode"`   // standalone, sentinel, cluster
+	Addrs                []string         `json:"addrs" yaml:"addrs"` // sentinel addresses in sentinel mode, seed nodes in cluster mode
+	MasterName           string           `json:"master_name" yaml:"master_name"`
+	Username             string           `json:"username" yaml:"username"`
+	Password             string           `json:"password" yaml:"password"`
+	SentinelPassword     string           `json:"sentinel_password" yaml:"sentinel_password"`
+	DB                   int              `json:"db" yaml:"db"` // ignored in cluster mode
+	TLS                  *ClientTLSConfig `json:"tls" yaml:"tls"`
+	PoolSize             int              `json:"pool_size" yaml:"pool_size"`
+	MinIdleConns         int              `json:"min_idle_conns" yaml:"min_idle_conns"`
+	PoolTimeout          time.Duration    `json:"pool_timeout" yaml:"pool_timeout"`
+	ConnMaxIdleTime      time.Duration    `json:"conn_max_idle_time" yaml:"conn_max_idle_time"`
+	DialTimeout          time.Duration    `json:"dial_timeout" yaml:"dial_timeout"`
+	ReadTimeout          time.Duration    `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout         time.Duration    `json:"write_timeout" yaml:"write_timeout"`
+	MaxRetries           int              `json:"max_retries" yaml:"max_retries"`
+	SlowCommandThreshold time.Duration    `json:"slow_command_threshold" yaml:"slow_command_threshold"`
+}
+
+// CacheConfig selects the backend of the caches handlers create with
+// cache.New
+type CacheConfig struct {
+	Enabled    bool          `json:"enabled" yaml:"enabled"`
+	Driver     string        `json:"driver" yaml:"driver"`           // memory, redis
+	MaxEntries int           `json:"max_entries" yaml:"max_entries"` // per memory cache
+	DefaultTTL time.Duration `json:"default_ttl" yaml:"default_ttl"`
+}
+
+// FlagsConfig holds feature flag configuration
+type FlagsConfig struct {
+	Enabled           bool          `json:"enabled" yaml:"enabled"`
+	Provider          string        `json:"provider" yaml:"provider"` // database, file
+	File              string        `json:"file" yaml:"file"`         // YAML flag definitions for the file provider
+	RefreshInterval   time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+	ExperimentHeader  string        `json:"experiment_header" yaml:"experiment_header"`   // response header listing the request's experiment variants; empty to omit
+	ExperimentMetrics bool          `json:"experiment_metrics" yaml:"experiment_metrics"` // request metrics per experiment variant
+}
+
+// RateLimitConfig holds request rate limiting. Each authenticated subject,
+// or client IP for anonymous requests, gets the default limit unless
+// rate_limit_policies puts it or its tenant on a plan.
+type RateLimitConfig struct {
+	Enabled           bool          `json:"enabled" yaml:"enabled"`
+	RequestsPerSecond float64       `json:"requests_per_second" yaml:"requests_per_second"` // default limit
+	Burst             int           `json:"burst" yaml:"burst"`
+	Policies          bool          `json:"policies" yaml:"policies"` // load per-tenant and per-subject plans from the database
+	RefreshInterval   time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+	IdleTimeout       time.Duration `json:"idle_timeout" yaml:"idle_timeout"` // forget buckets unused for this long
+}
+
+// LoadShedConfig rejects low-priority requests with 503 while the service
+// is saturated: its p99 latency, peak in-flight requests or mean database
+// pool wait over the last Interval are over their limits. A zero limit
+// ignores that signal. Each saturated interval sheds one more priority,
+// low then normal, and each healthy one lets one back; critical requests
+// are never shed.
+type LoadShedConfig struct {
+	Enabled         bool               `json:"enabled" yaml:"enabled"`
+	Interval        time.Duration      `json:"interval" yaml:"interval"`               // how often saturation is evaluated
+	MaxP99Latency   time.Duration      `json:"max_p99_latency" yaml:"max_p99_latency"` // of requests served in the interval
+	MaxInFlight     int                `json:"max_in_flight" yaml:"max_in_flight"`
+	MaxPoolWait     time.Duration      `json:"max_pool_wait" yaml:"max_pool_wait"`     // mean wait for a database connection
+	PriorityHeader  string             `json:"priority_header" yaml:"priority_header"` // overrides the route's priority when set; empty ignores it
+	DefaultPriority string             `json:"default_priority" yaml:"default_priority"`
+	Routes          []*ShedRouteConfig `json:"routes" yaml:"routes"`
+	RetryAfter      time.Duration      `json:"retry_after" yaml:"retry_after"`
+	ExemptPaths     []string           `json:"exempt_paths" yaml:"exempt_paths"` // prefixes never shed or measured, e.g. health checks
+}
+
+// ShedRouteConfig gives the routes matching Pattern, e.g.
+// "/api/v1/reports/*", for Methods or every method when empty, a priority:
+// critical, normal or low
+type ShedRouteConfig struct {
+	Pattern  string   `json:"pattern" yaml:"pattern"`
+	Methods  []string `json:"methods" yaml:"methods"`
+	Priority string   `json:"priority" yaml:"priority"`
+}
+
+// IPFilterConfig admits or refuses requests by client address before auth.
+// Entries are addresses or CIDR ranges. Deny entries always refuse; once
+// there is any allow entry, only allowed addresses get through.
+type IPFilterConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`
+	Allow           []string      `json:"allow" yaml:"allow"`
+	Deny            []string      `json:"deny" yaml:"deny"`
+	Database        bool          `json:"database" yaml:"database"` // also load rules from ip_rules, editable through the admin API
+	RefreshInterval time.Duration `json:"refresh_interval" yaml:"refresh_interval"`
+	ExemptPaths     []string      `json:"exempt_paths" yaml:"exempt_paths"` // path prefixes never filtered, e.g. health checks
+}
+
+// BotsConfig scores requests with the built-in detectors and any
+// registered in code, then challenges or denies those scoring at or above
+// the thresholds
+type BotsConfig struct {
+	Enabled        bool                `json:"enabled" yaml:"enabled"`
+	Mode           string              `json:"mode" yaml:"mode"` // enforce, or monitor to only log and measure decisions
+	ChallengeScore int                 `json:"challenge_score" yaml:"challenge_score"`
+	DenyScore      int                 `json:"deny_score" yaml:"deny_score"`
+	ExemptPaths    []string            `json:"exempt_paths" yaml:"exempt_paths"` // path prefixes never scored
+	UserAgent      *BotUserAgentConfig `json:"user_agent" yaml:"user_agent"`
+	Rate           *BotRateConfig      `json:"rate" yaml:"rate"`
+	External       *BotExternalConfig  `json:"external" yaml:"external"`
+}
+
+// BotUserAgentConfig scores requests by user agent
+type BotUserAgentConfig struct {
+	Enabled    bool     `json:"enabled" yaml:"enabled"`
+	Score      int      `json:"score" yaml:"score"`             // when a pattern matches
+	EmptyScore int      `json:"empty_score" yaml:"empty_score"` // when there is no user agent
+	Patterns   []string `json:"patterns" yaml:"patterns"`       // case-insensitive regular expressions
+}
+
+// BotRateConfig scores client addresses sending requests in bursts
+type BotRateConfig struct {
+	Enabled           bool          `json:"enabled" yaml:"enabled"`
+	RequestsPerSecond float64       `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int           `json:"burst" yaml:"burst"`
+	Score             int           `json:"score" yaml:"score"` // for each request over the rate
+	IdleTimeout       time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+}
+
+// BotExternalConfig asks a scoring service about every request, through
+// the "bot_detection" HTTP client
+type BotExternalConfig struct {
+	Enabled bool          `json:"enabled" yaml:"enabled"`
+	URL     string        `json:"url" yaml:"url"`
+	Timeout time.Duration `json:"timeout" yaml:"timeout"` // requests go through unscored past this
+}
+
+// EmailConfig holds outbound email configuration
+type EmailConfig struct {
+	Enabled        bool            `json:"enabled" yaml:"enabled"`
+	Provider       string          `json:"provider" yaml:"provider"`           // smtp, ses, sendgrid
+	From           string          `json:"from" yaml:"from"`                   // default sender, e.g. "MyApp <no-reply@example.com>"
+	TemplatesDir   string          `json:"templates_dir" yaml:"templates_dir"` // overrides the embedded templates
+	SMTP           *SMTPConfig     `json:"smtp" yaml:"smtp"`
+	SES            *SESConfig      `json:"ses" yaml:"ses"`
+	SendGrid       *SendGridConfig `json:"sendgrid" yaml:"sendgrid"`
+	Workers        int             `json:"workers" yaml:"workers"`
+	PollInterval   time.Duration   `json:"poll_interval" yaml:"poll_interval"`
+	BatchSize      int             `json:"batch_size" yaml:"batch_size"`
+	MaxAttempts    int             `json:"max_attempts" yaml:"max_attempts"`
+	InitialBackoff time.Duration   `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration   `json:"max_backoff" yaml:"max_backoff"`
+	SendTimeout    time.Duration   `json:"send_timeout" yaml:"send_timeout"`
+}
+
+// SMTPConfig holds SMTP relay configuration
+type SMTPConfig struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	TLSMode  string `json:"tls_mode" yaml:"tls_mode"` // starttls, tls, none
+}
+
+// SESConfig holds Amazon SES configuration. Credentials come from the
+// standard AWS chain.
+type SESConfig struct {
+	Region           string `json:"region" yaml:"region"`
+	ConfigurationSet string `json:"configuration_set" yaml:"configuration_set"`
+}
+
+// SendGridConfig holds SendGrid API configuration
+type SendGridConfig struct {
+	APIKey   string `json:"api_key" yaml:"api_key"`
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+}
+
+// NotifyConfig holds the notification queue and its channels. Like email,
+// notifications are queued in the database and sent in the background
+// with retries.
+type NotifyConfig struct {
+	Enabled        bool                               `json:"enabled" yaml:"enabled"`
+	TemplatesDir   string                             `json:"templates_dir" yaml:"templates_dir"` // overrides the embedded templates
+	Email          *NotifyEmailConfig                 `json:"email" yaml:"email"`
+	SMS            *TwilioConfig                      `json:"sms" yaml:"sms"`
+	Slack          *SlackConfig                       `json:"slack" yaml:"slack"`
+	Push           *FCMConfig                         `json:"push" yaml:"push"`
+	RateLimits     map[string]*ChannelRateLimitConfig `json:"rate_limits" yaml:"rate_limits"` // by channel name
+	Workers        int                                `json:"workers" yaml:"workers"`
+	PollInterval   time.Duration                      `json:"poll_interval" yaml:"poll_interval"`
+	BatchSize      int                                `json:"batch_size" yaml:"batch_size"`
+	MaxAttempts    int                                `json:"max_attempts" yaml:"max_attempts"`
+	InitialBackoff time.Duration                      `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     time.Duration                      `json:"max_backoff" yaml:"max_backoff"`
+	SendTimeout    time.Duration                      `json:"send_timeout" yaml:"send_timeout"`
+}
+
+// NotifyEmailConfig sends notifications through the mailer
+type NotifyEmailConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+}
+
+// TwilioConfig sends SMS through the Twilio Messages API
+type TwilioConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	AccountSID string `json:"account_sid" yaml:"account_sid"`
+	AuthToken  string `json:"auth_token" yaml:"auth_token"`
+	From       string `json:"from" yaml:"from"` // sending number in E.164, or a messaging service SID
+	Endpoint   string `json:"endpoint" yaml:"endpoint"`
+}
+
+// SlackConfig posts to Slack incoming webhooks. Notifications name a
+// webhook rather than carry its URL, which is a secret.
+type SlackConfig struct {
+	Enabled  bool              `json:"enabled" yaml:"enabled"`
+	Webhooks map[string]string `json:"webhooks" yaml:"webhooks"` // name -> webhook URL
+}
+
+// FCMConfig sends push notifications through the Firebase Cloud Messaging
+// HTTP v1 API. Credentials come from the file or Application Default
+// Credentials.
+type FCMConfig struct {
+	Enabled         bool   `json:"enabled" yaml:"enabled"`
+	ProjectID       string `json:"project_id" yaml:"project_id"`
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file"`
+	Endpoint        string `json:"endpoint" yaml:"endpoint"`
+}
+
+// ChannelRateLimitConfig caps how fast one channel sends, across the
+// instance's workers
+type ChannelRateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int     `json:"burst" yaml:"burst"`
+}
+
+// BlobConfig holds object storage configuration
+type BlobConfig struct {
+	Enabled bool             `json:"enabled" yaml:"enabled"`
+	Driver  string           `json:"driver" yaml:"driver"` // local, s3, gcs
+	Bucket  string           `json:"bucket" yaml:"bucket"`
+	Prefix  string           `json:"prefix" yaml:"prefix"` // prepended to every key, e.g. "myapp/"
+	Local   *LocalBlobConfig `json:"local" yaml:"local"`
+	S3      *S3BlobConfig    `json:"s3" yaml:"s3"`
+	GCS     *GCSBlobConfig   `json:"gcs" yaml:"gcs"`
+}
+
+// LocalBlobConfig holds filesystem storage configuration
+type LocalBlobConfig struct {
+	Dir        string `json:"dir" yaml:"dir"`
+	BaseURL    string `json:"base_url" yaml:"base_url"`       // public URL of this service, for signed URLs
+	SigningKey string `json:"signing_key" yaml:"signing_key"` // HMAC key for signed URLs
+}
+
+// S3BlobConfig holds S3 configuration. Credentials come from the standard
+// AWS chain.
+type S3BlobConfig struct {
+	Region         string `json:"region" yaml:"region"`
+	Endpoint       string `json:"endpoint" yaml:"endpoint"` // for S3-compatible services such as MinIO
+	ForcePathStyle bool   `json:"force_path_style" yaml:"force_path_style"`
+}
+
+// GCSBlobConfig holds Google Cloud Storage configuration
+type GCSBlobConfig struct {
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file"` // empty uses Application Default Credentials
+}
+
+// SearchConfig holds full-text search configuration
+type SearchConfig struct {
+	Enabled       bool                 `json:"enabled" yaml:"enabled"`
+	Driver        string               `json:"driver" yaml:"driver"`     // postgres, elasticsearch, opensearch
+	Language      string               `json:"language" yaml:"language"` // Postgres text search configuration
+	Elasticsearch *ElasticsearchConfig `json:"elasticsearch" yaml:"elasticsearch"`
+}
+
+// ElasticsearchConfig holds Elasticsearch/OpenSearch connection settings.
+// Requests go through the "search" HTTP client.
+type ElasticsearchConfig struct {
+	Addresses   []string `json:"addresses" yaml:"addresses"`
+	Username    string   `json:"username" yaml:"username"`
+	Password    string   `json:"password" yaml:"password"`
+	APIKey      string   `json:"api_key" yaml:"api_key"`
+	IndexPrefix string   `json:"index_prefix" yaml:"index_prefix"`
+	Refresh     string   `json:"refresh" yaml:"refresh"` // "", "true" or "wait_for"
+}
+
+// TenancyConfig holds multi-tenancy configuration
+type TenancyConfig struct {
+	Enabled      bool     `json:"enabled" yaml:"enabled"`
+	Sources      []string `json:"sources" yaml:"sources"` // tried in order: header, subdomain, claim
+	Header       string   `json:"header" yaml:"header"`
+	BaseDomain   string   `json:"base_domain" yaml:"base_domain"` // for the subdomain source
+	Claim        string   `json:"claim" yaml:"claim"`             // for the claim source
+	Required     bool     `json:"required" yaml:"required"`       // reject requests without a tenant
+	Isolation    string   `json:"isolation" yaml:"isolation"`     // column, schema
+	Column       string   `json:"column" yaml:"column"`           // tenant column in column mode
+	SchemaPrefix string   `json:"schema_prefix" yaml:"schema_prefix"`
+	Metrics      bool     `json:"metrics" yaml:"metrics"` // per-tenant request metrics; mind the cardinality
+	// SessionVariable is a Postgres setting, e.g. "app.current_tenant",
+	// set to the request's tenant in every transaction it begins, for row
+	// level security policies; empty sets none
+	SessionVariable string `json:"session_variable" yaml:"session_variable"`
+}
+
+// AuthConfig holds authentication configuration
+type AuthConfig struct {
+	Enabled    bool              `json:"enabled" yaml:"enabled"`
+	JWT        *JWTConfig        `json:"jwt" yaml:"jwt"`
+	Revocation *RevocationConfig `json:"revocation" yaml:"revocation"`
+	Users      *UsersConfig      `json:"users" yaml:"users"`
+}
+
+// JWTConfig holds access token settings
+type JWTConfig struct {
+	Secret   string        `json:"secret" yaml:"secret"` // at least 32 bytes
+	Issuer   string        `json:"issuer" yaml:"issuer"`
+	Audience string        `json:"audience" yaml:"audience"`
+	TTL      time.Duration `json:"ttl" yaml:"ttl"`
+}
+
+// RevocationConfig holds the Redis token revocation list settings
+type RevocationConfig struct {
+	Enabled   bool          `json:"enabled" yaml:"enabled"`
+	Prefix    string        `json:"prefix" yaml:"prefix"`         // Redis key prefix
+	CacheTTL  time.Duration `json:"cache_ttl" yaml:"cache_ttl"`   // how long a lookup is trusted locally
+	CacheSize int           `json:"cache_size" yaml:"cache_size"` // locally cached lookups
+	FailOpen  bool          `json:"fail_open" yaml:"fail_open"`   // accept tokens while Redis is unreachable
+}
+
+// UsersConfig holds the built-in account module configuration
+type UsersConfig struct {
+	Enabled             bool          `json:"enabled" yaml:"enabled"`
+	AppName             string        `json:"app_name" yaml:"app_name"` // used in emails
+	BaseURL             string        `json:"base_url" yaml:"base_url"` // frontend URL for verification and reset links
+	RequireVerification bool          `json:"require_verification" yaml:"require_verification"`
+	MinPasswordLength   int           `json:"min_password_length" yaml:"min_password_length"`
+	VerificationTTL     time.Duration `json:"verification_ttl" yaml:"verification_ttl"`
+	ResetTTL            time.Duration `json:"reset_ttl" yaml:"reset_ttl"`
+	Argon2              *Argon2Config `json:"argon2" yaml:"argon2"`
+}
+
+// Argon2Config holds argon2id cost parameters. Raising them rehashes
+// passwords on the next successful login.
+type Argon2Config struct {
+	Memory      uint32 `json:"memory" yaml:"memory"` // KiB
+	Iterations  uint32 `json:"iterations" yaml:"iterations"`
+	Parallelism uint8  `json:"parallelism" yaml:"parallelism"`
+}
+
+// EncryptionConfig holds field-level encryption configuration
+type EncryptionConfig struct {
+	Enabled    bool       `json:"enabled" yaml:"enabled"`
+	Source     string     `json:"source" yaml:"source"`           // env, file, kms
+	PrimaryKey string     `json:"primary_key" yaml:"primary_key"` // key ID for new values (env and file sources)
+	EnvPrefix  string     `json:"env_prefix" yaml:"env_prefix"`
+	File       string     `json:"file" yaml:"file"`
+	KMS        *KMSConfig `json:"kms" yaml:"kms"`
+}
+
+// KMSConfig holds AWS KMS settings for envelope encryption
+type KMSConfig struct {
+	Region     string        `json:"region" yaml:"region"`
+	KeyID      string        `json:"key_id" yaml:"key_id"`             // key ID, ARN or alias
+	DataKeyTTL time.Duration `json:"data_key_ttl" yaml:"data_key_ttl"` // how long a data key is reused and cached
+	CacheSize  int           `json:"cache_size" yaml:"cache_size"`     // unwrapped data keys kept in memory
+}
+
+// RenderConfig holds server-side HTML rendering configuration
+type RenderConfig struct {
+	Enabled     bool        `json:"enabled" yaml:"enabled"`
+	Dir         string      `json:"dir" yaml:"dir"`       // template directory; empty uses the embedded templates
+	Reload      bool        `json:"reload" yaml:"reload"` // re-read templates on every render (development)
+	Layout      string      `json:"layout" yaml:"layout"`
+	FlashSecret string      `json:"flash_secret" yaml:"flash_secret"` // signs the flash cookie
+	CSRF        *CSRFConfig `json:"csrf" yaml:"csrf"`
+}
+
+// CSRFConfig holds CSRF protection settings for HTML forms
+type CSRFConfig struct {
+	Enabled     bool     `json:"enabled" yaml:"enabled"`
+	CookieName  string   `json:"cookie_name" yaml:"cookie_name"`
+	HeaderName  string   `json:"header_name" yaml:"header_name"`
+	FieldName   string   `json:"field_name" yaml:"field_name"`
+	Secure      bool     `json:"secure" yaml:"secure"`             // Secure cookie flag; disable only for plain-HTTP development
+	ExemptPaths []string `json:"exempt_paths" yaml:"exempt_paths"` // path prefixes skipped, e.g. inbound webhooks
+}
+
+// I18nConfig holds internationalization configuration
+type I18nConfig struct {
+	Enabled       bool   `json:"enabled" yaml:"enabled"`
+	DefaultLocale string `json:"default_locale" yaml:"default_locale"`
+	Dir           string `json:"dir" yaml:"dir"`                 // catalog directory; empty uses the embedded catalogs
+	QueryParam    string `json:"query_param" yaml:"query_param"` // explicit override, e.g. ?lang=fr
+	Cookie        string `json:"cookie" yaml:"cookie"`           // set by a language switcher
+}
+
+// WorkerPoolConfig holds the shared bounded worker pool configuration
+type WorkerPoolConfig struct {
+	Enabled   bool   `json:"enabled" yaml:"enabled"`
+	Workers   int    `json:"workers" yaml:"workers"`
+	QueueSize int    `json:"queue_size" yaml:"queue_size"`
+	Policy    string `json:"policy" yaml:"policy"` // block, reject, caller_runs
+}
+
+// ChaosConfig injects faults into HTTP requests and database calls, to
+// check that clients retry and alerts fire. It is refused in production.
+type ChaosConfig struct {
+	Enabled  bool                 `json:"enabled" yaml:"enabled"`
+	HTTP     *ChaosHTTPConfig     `json:"http" yaml:"http"`
+	Database *ChaosDatabaseConfig `json:"database" yaml:"database"`
+}
+
+// ChaosHTTPConfig holds per-request fault probabilities, each between 0
+// and 1
+type ChaosHTTPConfig struct {
+	LatencyProbability float64       `json:"latency_probability" yaml:"latency_probability"`
+	LatencyMin         time.Duration `json:"latency_min" yaml:"latency_min"`
+	LatencyMax         time.Duration `json:"latency_max" yaml:"latency_max"`
+	ErrorProbability   float64       `json:"error_probability" yaml:"error_probability"`
+	ErrorStatus        int           `json:"error_status" yaml:"error_status"`
+	DropProbability    float64       `json:"drop_probability" yaml:"drop_probability"` // close the connection without responding
+	Paths              []string      `json:"paths" yaml:"paths"`                       // path prefixes to inject into; empty means all
+	ExcludePaths       []string      `json:"exclude_paths" yaml:"exclude_paths"`       // path prefixes never injected into
+}
+
+// ChaosDatabaseConfig holds per-call fault probabilities for queries,
+// statements and transactions, each between 0 and 1
+type ChaosDatabaseConfig struct {
+	LatencyProbability float64       `json:"latency_probability" yaml:"latency_probability"`
+	LatencyMin         time.Duration `json:"latency_min" yaml:"latency_min"`
+	LatencyMax         time.Duration `json:"latency_max" yaml:"latency_max"`
+	ErrorProbability   float64       `json:"error_probability" yaml:"error_probability"`
+}
+
+// OpenAPIConfig serves the API's OpenAPI 3 document and checks traffic
+// against it. Requests that break the contract get a 400; responses are
+// only checked in development, where violations are logged.
+type OpenAPIConfig struct {
+	Enabled           bool   `json:"enabled" yaml:"enabled"`
+	Spec              string `json:"spec" yaml:"spec"` // YAML or JSON document
+	Path              string `json:"path" yaml:"path"` // served at; empty doesn't serve it
+	ValidateRequests  bool   `json:"validate_requests" yaml:"validate_requests"`
+	ValidateResponses bool   `json:"validate_responses" yaml:"validate_responses"`
+	MessagePrefix     string `json:"message_prefix" yaml:"message_prefix"` // i18n key prefix of violation messages, e.g. "validation.required"
+}
+
+// BatchConfig serves an endpoint that runs several API requests sent in
+// one body, each through the full middleware pipeline with the batch
+// request's credentials
+type BatchConfig struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	Path         string `json:"path" yaml:"path"`
+	MaxRequests  int    `json:"max_requests" yaml:"max_requests"` // per batch
+	Concurrency  int    `json:"concurrency" yaml:"concurrency"`   // sub-requests run at once, per batch
+	MaxBodyBytes int64  `json:"max_body_bytes" yaml:"max_body_bytes"`
+}
+
+// DeprecationConfig marks routes deprecated. Their responses carry
+// Deprecation, Sunset and Link headers, and calls are logged and counted
+// by client for GET /admin/deprecations.
+type DeprecationConfig struct {
+	Enabled     bool                     `json:"enabled" yaml:"enabled"`
+	LogInterval time.Duration            `json:"log_interval" yaml:"log_interval"` // log each client of a route at most this often
+	MaxClients  int                      `json:"max_clients" yaml:"max_clients"`   // per route; further clients are counted as "other"
+	Routes      []*DeprecatedRouteConfig `json:"routes" yaml:"routes"`
+}
+
+// DeprecatedRouteConfig deprecates the route registered for Method, or
+// every method when empty, and Pattern, e.g. "/api/v1/users/{id}"
+type DeprecatedRouteConfig struct {
+	Method      string    `json:"method" yaml:"method"`
+	Pattern     string    `json:"pattern" yaml:"pattern"`
+	Since       time.Time `json:"since" yaml:"since"`             // when it was deprecated
+	Sunset      time.Time `json:"sunset" yaml:"sunset"`           // when it will stop working; optional
+	Replacement string    `json:"replacement" yaml:"replacement"` // successor URL or path; optional
+	Link        string    `json:"link" yaml:"link"`               // migration docs; optional
+}
+
+// CoalesceConfig collapses concurrent identical GET and HEAD requests to
+// Routes into one execution of the handler, whose response is sent to
+// every caller. Requests are identical when their method, path, query and
+// KeyHeaders match.
+type CoalesceConfig struct {
+	Enabled      bool     `json:"enabled" yaml:"enabled"`
+	Routes       []string `json:"routes" yaml:"routes"`                 // chi patterns, e.g. "/api/v1/products/{id}"
+	KeyHeaders   []string `json:"key_headers" yaml:"key_headers"`       // request headers responses depend on
+	MaxBodyBytes int64    `json:"max_body_bytes" yaml:"max_body_bytes"` // larger responses aren't shared
+}
+
+// GeoIPConfig resolves the country and region of each request's client
+// address from a MaxMind database, for handlers, logs and metrics, and
+// blocks routes by location
+type GeoIPConfig struct {
+	Enabled  bool             `json:"enabled" yaml:"enabled"`
+	Database string           `json:"database" yaml:"database"` // GeoLite2 or GeoIP2 Country or City .mmdb file
+	Metrics  bool             `json:"metrics" yaml:"metrics"`   // per-country request metrics
+	Rules    []*GeoRuleConfig `json:"rules" yaml:"rules"`
+}
+
+// GeoRuleConfig restricts where the routes matching Pattern, e.g.
+// "/api/v1/payments/*", can be called from, for Methods or every method
+// when empty. Locations are ISO 3166 countries ("US") or regions
+// ("US-CA"). Allow admits only the listed locations, so clients whose
+// location is unknown are blocked too; Deny blocks the listed ones.
+type GeoRuleConfig struct {
+	Pattern string   `json:"pattern" yaml:"pattern"`
+	Methods []string `json:"methods" yaml:"methods"`
+	Allow   []string `json:"allow" yaml:"allow"`
+	Deny    []string `json:"deny" yaml:"deny"`
+}
+
+// OperationsConfig tracks long-running work started by handlers, which
+// respond 202 and let clients poll the operation for its progress and
+// result
+type OperationsConfig struct {
+	Enabled       bool          `json:"enabled" yaml:"enabled"`
+	Path          string        `json:"path" yaml:"path"`                     // operations are served at <path>/{id}
+	Timeout       time.Duration `json:"timeout" yaml:"timeout"`               // longest an operation may run
+	Retention     time.Duration `json:"retention" yaml:"retention"`           // finished operations are kept this long
+	RetryAfter    time.Duration `json:"retry_after" yaml:"retry_after"`       // suggested poll interval
+	SweepInterval time.Duration `json:"sweep_interval" yaml:"sweep_interval"` // how often expired operations are cleaned up
+}
+
+// ImportsConfig bounds CSV and Excel uploads loaded into tables. Imports
+// run as operations.
+type ImportsConfig struct {
+	Enabled     bool   `json:"enabled" yaml:"enabled"`
+	BatchSize   int    `json:"batch_size" yaml:"batch_size"`       // rows per INSERT
+	MaxErrors   int    `json:"max_errors" yaml:"max_errors"`       // failed rows after which an import stops
+	MaxFileSize int64  `json:"max_file_size" yaml:"max_file_size"` // bytes
+	FormField   string `json:"form_field" yaml:"form_field"`       // multipart field holding the file
+}
+
+// ReportsConfig renders templates to HTML or PDF documents in the
+// background and stores them in blob storage behind signed URLs. Reports
+// run as operations.
+type ReportsConfig struct {
+	Enabled   bool          `json:"enabled" yaml:"enabled"`
+	Dir       string        `json:"dir" yaml:"dir"` // template directory; empty uses the embedded templates
+	Layout    string        `json:"layout" yaml:"layout"`
+	Reload    bool          `json:"reload" yaml:"reload"`
+	Converter string        `json:"converter" yaml:"converter"` // "wkhtmltopdf", "chromium", or "" for HTML only
+	Binary    string        `json:"binary" yaml:"binary"`       // converter executable; defaults to its usual name
+	Args      []string      `json:"args" yaml:"args"`           // extra converter flags, e.g. --no-sandbox
+	Timeout   time.Duration `json:"timeout" yaml:"timeout"`     // longest a conversion may run
+	Prefix    string        `json:"prefix" yaml:"prefix"`       // blob key prefix
+	URLExpiry time.Duration `json:"url_expiry" yaml:"url_expiry"`
+}
+
+// AdminUIConfig holds the HTML admin served on the admin listener for
+// browsing and editing database tables. Operators sign in with HTTP basic
+// auth against argon2id hashes from "admin hash-password".
+type AdminUIConfig struct {
+	Enabled  bool                  `json:"enabled" yaml:"enabled"`
+	Path     string                `json:"path" yaml:"path"`
+	PageSize int                   `json:"page_size" yaml:"page_size"`
+	Users    map[string]string     `json:"users" yaml:"users"` // username -> password hash
+	Tables   []*AdminUITableConfig `json:"tables" yaml:"tables"`
+}
+
+// AdminUITableConfig registers a table with the admin. Its columns are
+// read from the database at startup.
+type AdminUITableConfig struct {
+	Name     string   `json:"name" yaml:"name"`
+	Key      string   `json:"key" yaml:"key"`             // primary key column; default "id"
+	Search   []string `json:"search" yaml:"search"`       // columns matched by the search box
+	ReadOnly []string `json:"read_only" yaml:"read_only"` // shown but not editable; the key always is
+	Hidden   []string `json:"hidden" yaml:"hidden"`       // never shown, e.g. password hashes
+}
+
+// RouteConfig declares a route served without code: a redirect, a static
+// response or a proxy pass. Exactly one of Redirect, Static and Proxy is
+// set.
+type RouteConfig struct {
+	Path     string         `json:"path" yaml:"path"`       // chi pattern; end with /* to match a prefix
+	Methods  []string       `json:"methods" yaml:"methods"` // empty matches any method
+	Redirect *RedirectRoute `json:"redirect" yaml:"redirect"`
+	Static   *StaticRoute   `json:"static" yaml:"static"`
+	Proxy    *ProxyRoute    `json:"proxy" yaml:"proxy"`
+}
+
+// RedirectRoute redirects to To. For a /* pattern a To ending in / gets
+// the rest of the path appended; the query string is always kept.
+type RedirectRoute struct {
+	To     string `json:"to" yaml:"to"`
+	Status int    `json:"status" yaml:"status"` // 301, 302, 303, 307 or 308; 0 is 308
+}
+
+// StaticRoute responds with a fixed body, given inline or read from File
+// at startup
+type StaticRoute struct {
+	Status      int               `json:"status" yaml:"status"` // 0 is 200
+	ContentType string            `json:"content_type" yaml:"content_type"`
+	Body        string            `json:"body" yaml:"body"`
+	File        string            `json:"file" yaml:"file"`
+	Headers     map[string]string `json:"headers" yaml:"headers"`
+}
+
+// ProxyRoute passes requests to Upstream through the outbound HTTP client
+// named Client, so clients.http configures its timeouts and breaker
+type ProxyRoute struct {
+	Upstream    string        `json:"upstream" yaml:"upstream"`         // e.g. http://legacy:8080/api
+	StripPrefix string        `json:"strip_prefix" yaml:"strip_prefix"` // removed from the path before it is appended to Upstream's
+	Client      string        `json:"client" yaml:"client"`             // empty is "proxy"
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`           // 0 leaves it to the client
+}
+
+// CrashConfig writes a report to Dir when the process dies of a panic or a
+// fatal log, for postmortems beyond what the orchestrator kept of stdout
+type CrashConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	Dir        string `json:"dir" yaml:"dir"`
+	LogLines   int    `json:"log_lines" yaml:"log_lines"`     // recent log entries kept for the report
+	MaxReports int    `json:"max_reports" yaml:"max_reports"` // older reports are deleted
+}
+
+// ClientTLSConfig holds TLS configuration for outbound connections
+type ClientTLSConfig struct {
+	Enabled            bool   `json:"enabled" yaml:"enabled"`
+	CAFile             string `json:"ca_file" yaml:"ca_file"`
+	CertFile           string `json:"cert_file" yaml:"cert_file"` // client certificate for mTLS
+	KeyFile            string `json:"key_file" yaml:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// SASLConfig holds SASL authentication configuration
+type SASLConfig struct {
+	Mechanism string `json:"mechanism" yaml:"mechanism"` // plain, scram-sha-256, scram-sha-512
+	Username  string `json:"username" yaml:"username"`
+	Password  string `json:"password" yaml:"password"`
 }
 
 // AppConfig holds general application configuration
@@ -161,15 +1389,66 @@ func DefaultConfig() *Config {
 			WriteTimeout:    10 * time.Second,
 			IdleTimeout:     60 * time.Second,
 			ShutdownTimeout: 30 * time.Second,
+			StartTimeout:    30 * time.Second,
+			HealthTimeout:   5 * time.Second,
+			Health: &HealthConfig{
+				CacheTTL: time.Second,
+			},
 			TLS: &TLSConfig{
 				Enabled: false,
+				Expiry: &CertExpiryConfig{
+					Enabled:    true,
+					Interval:   time.Hour,
+					WarnBefore: 30 * 24 * time.Hour,
+				},
 			},
 			CORS: &CORSConfig{
-				AllowedOrigins: []string{"*"},
 				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 				AllowedHeaders: []string{"*"},
 				MaxAge:         86400,
 			},
+			RequestID: &RequestIDConfig{
+				Header:         "X-Request-ID",
+				TrustedProxies: []string{"127.0.0.1/32", "::1/128"},
+			},
+			RealIP: &RealIPConfig{
+				TrustedProxies: []string{"127.0.0.1/32", "::1/128"},
+			},
+			Readiness: &ReadinessConfig{
+				Enabled:        true,
+				MaxWait:        2 * time.Minute,
+				InitialBackoff: 500 * time.Millisecond,
+				MaxBackoff:     10 * time.Second,
+			},
+			Warmup: &WarmupConfig{
+				Enabled: false,
+				Timeout: 30 * time.Second,
+			},
+			Admin: &AdminServerConfig{
+				Enabled: false,
+				Host:    "127.0.0.1",
+				Port:    9090,
+			},
+			ReadHeaderTimeout: 5 * time.Second,
+			MaxHeaderBytes:    1 << 20, // 1 MB
+			Connections: &ConnectionsConfig{
+				Max:      10000,
+				MaxPerIP: 0,
+			},
+			ProxyProtocol: &ProxyProtocolConfig{
+				Enabled:       false,
+				HeaderTimeout: 5 * time.Second,
+			},
+			BindRetry: &BindRetryConfig{
+				Attempts:       5,
+				InitialBackoff: 250 * time.Millisecond,
+				MaxBackoff:     2 * time.Second,
+			},
+			ShutdownPhases: &ShutdownPhasesConfig{
+				Consumers: 20 * time.Second,
+				Flush:     10 * time.Second,
+				Close:     5 * time.Second,
+			},
 		},
 		Database: &DatabaseConfig{
 			Driver:             "postgres",
@@ -186,6 +1465,34 @@ func DefaultConfig() *Config {
 			ConnMaxIdleTime:    5 * time.Minute,
 			LogSlowQueries:     true,
 			SlowQueryThreshold: 500 * time.Millisecond,
+			Explain: &ExplainConfig{
+				Enabled:      false,
+				MaxPerMinute: 6,
+				Cooldown:     10 * time.Minute,
+				Timeout:      5 * time.Second,
+			},
+			QueryStats: &QueryStatsConfig{
+				Enabled:  false,
+				Interval: 5 * time.Minute,
+				Limit:    10,
+				Timeout:  10 * time.Second,
+			},
+			LeakDetection: &LeakDetectionConfig{
+				Enabled:         true,
+				MaxAge:          time.Minute,
+				MaxOpen:         100,
+				StackSampleRate: 0.01,
+				Interval:        10 * time.Second,
+			},
+			Failover: &FailoverConfig{
+				Enabled:      false,
+				ProbeTimeout: 2 * time.Second,
+			},
+			Credentials: &CredentialsConfig{
+				RefreshInterval: time.Minute,
+			},
+			AuthMethod: "password",
+			IAM:        &DatabaseIAMConfig{},
 		},
 		Logger: &LoggerConfig{
 			Level:             "info",
@@ -204,6 +1511,376 @@ func DefaultConfig() *Config {
 			BufferSize:     100,
 			FlushInterval:  5 * time.Second,
 			ReportInterval: 30 * time.Second,
+
+			ReconnectInterval: 30 * time.Second,
+			Path:              "/metrics",
+		},
+		Tracing: &TracingConfig{
+			Enabled:     false,
+			Exporter:    "otlp-grpc",
+			Endpoint:    "localhost:4317",
+			Insecure:    true,
+			SampleRatio: 1.0,
+			Propagators: []string{"tracecontext", "baggage", "b3"},
+		},
+		Clients: &ClientsConfig{
+			HTTP: map[string]*HTTPClientConfig{
+				"default": DefaultHTTPClientConfig(),
+			},
+		},
+		GraphQL: &GraphQLConfig{
+			Enabled:         false,
+			Path:            "/graphql",
+			Playground:      true,
+			PlaygroundPath:  "/graphql/playground",
+			Introspection:   true,
+			ComplexityLimit: 200,
+		},
+		Webhooks: &WebhooksConfig{
+			Enabled:        false,
+			Workers:        4,
+			PollInterval:   time.Second,
+			BatchSize:      50,
+			MaxAttempts:    8,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     time.Hour,
+			RequestTimeout: 10 * time.Second,
+			SecretOverlap:  24 * time.Hour,
+		},
+		Scheduler: &SchedulerConfig{
+			Enabled:        false,
+			Location:       "UTC",
+			DefaultTimeout: 10 * time.Minute,
+		},
+		Events: &EventsConfig{
+			Enabled:      false,
+			Publish:      false,
+			PollInterval: time.Second,
+			BatchSize:    100,
+		},
+		Outbox: &OutboxConfig{
+			Enabled:        false,
+			PollInterval:   500 * time.Millisecond,
+			BatchSize:      100,
+			PublishTimeout: 5 * time.Second,
+			Retention:      7 * 24 * time.Hour,
+		},
+		Messaging: &MessagingConfig{
+			Driver: "",
+			Kafka: &KafkaConfig{
+				Brokers:            []string{"localhost:9092"},
+				ClientID:           "myapp",
+				GroupID:            "myapp",
+				RequiredAcks:       "all",
+				Compression:        "snappy",
+				BatchSize:          100,
+				BatchTimeout:       10 * time.Millisecond,
+				WriteTimeout:       10 * time.Second,
+				StartOffset:        "earliest",
+				SessionTimeout:     30 * time.Second,
+				RebalanceTimeout:   30 * time.Second,
+				HandlerTimeout:     30 * time.Second,
+				HandlerMaxAttempts: 5,
+			},
+			NATS: &NATSConfig{
+				Servers:        []string{"nats://localhost:4222"},
+				Name:           "myapp",
+				ConnectTimeout: 5 * time.Second,
+				ReconnectWait:  2 * time.Second,
+				MaxReconnects:  -1,
+				QueueGroup:     "myapp",
+				HandlerTimeout: 30 * time.Second,
+				JetStream: &JetStreamConfig{
+					Enabled:       false,
+					Durable:       "myapp",
+					AckWait:       30 * time.Second,
+					MaxDeliver:    5,
+					MaxAckPending: 1000,
+					NakDelay:      5 * time.Second,
+				},
+			},
+		},
+		Redis: &RedisConfig{
+			Enabled:              false,
+			Mode:                 "standalone",
+			Addrs:                []string{"localhost:6379"},
+			DB:                   0,
+			PoolSize:             20,
+			MinIdleConns:         2,
+			PoolTimeout:          4 * time.Second,
+			ConnMaxIdleTime:      5 * time.Minute,
+			DialTimeout:          5 * time.Second,
+			ReadTimeout:          3 * time.Second,
+			WriteTimeout:         3 * time.Second,
+			MaxRetries:           3,
+			SlowCommandThreshold: 100 * time.Millisecond,
+		},
+		Cache: &CacheConfig{
+			Enabled:    false,
+			Driver:     "memory",
+			MaxEntries: 10000,
+			DefaultTTL: 5 * time.Minute,
+		},
+		Flags: &FlagsConfig{
+			Enabled:           false,
+			Provider:          "database",
+			File:              "flags.yaml",
+			RefreshInterval:   30 * time.Second,
+			ExperimentHeader:  "X-Experiments",
+			ExperimentMetrics: true,
+		},
+		Shedding: &LoadShedConfig{
+			Enabled:         false,
+			Interval:        time.Second,
+			MaxP99Latency:   time.Second,
+			MaxInFlight:     1000,
+			MaxPoolWait:     100 * time.Millisecond,
+			DefaultPriority: "normal",
+			RetryAfter:      5 * time.Second,
+			ExemptPaths:     []string{"/health", "/readyz"},
+		},
+		IPFilter: &IPFilterConfig{
+			Enabled:         false,
+			RefreshInterval: 30 * time.Second,
+			ExemptPaths:     []string{"/health", "/readyz"},
+		},
+		Bots: &BotsConfig{
+			Enabled:        false,
+			Mode:           "enforce",
+			ChallengeScore: 50,
+			DenyScore:      90,
+			ExemptPaths:    []string{"/health", "/readyz"},
+			UserAgent: &BotUserAgentConfig{
+				Enabled:    true,
+				Score:      40,
+				EmptyScore: 60,
+				Patterns:   []string{`curl/`, `wget/`, `python-requests`, `python-urllib`, `go-http-client`, `scrapy`, `headlesschrome`, `phantomjs`},
+			},
+			Rate: &BotRateConfig{
+				Enabled:           true,
+				RequestsPerSecond: 20,
+				Burst:             40,
+				Score:             50,
+				IdleTimeout:       10 * time.Minute,
+			},
+			External: &BotExternalConfig{
+				Enabled: false,
+				Timeout: 200 * time.Millisecond,
+			},
+		},
+		RateLimit: &RateLimitConfig{
+			Enabled:           false,
+			RequestsPerSecond: 10,
+			Burst:             20,
+			Policies:          false,
+			RefreshInterval:   30 * time.Second,
+			IdleTimeout:       10 * time.Minute,
+		},
+		Email: &EmailConfig{
+			Enabled:  false,
+			Provider: "smtp",
+			From:     "myapp <no-reply@example.com>",
+			SMTP: &SMTPConfig{
+				Host:    "localhost",
+				Port:    1025,
+				TLSMode: "none",
+			},
+			SES: &SESConfig{
+				Region: "us-east-1",
+			},
+			SendGrid: &SendGridConfig{
+				Endpoint: "https://api.sendgrid.com/v3/mail/send",
+			},
+			Workers:        4,
+			PollInterval:   time.Second,
+			BatchSize:      50,
+			MaxAttempts:    6,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     30 * time.Minute,
+			SendTimeout:    30 * time.Second,
+		},
+		Notify: &NotifyConfig{
+			Enabled: false,
+			Email:   &NotifyEmailConfig{},
+			SMS: &TwilioConfig{
+				Endpoint: "https://api.twilio.com",
+			},
+			Slack: &SlackConfig{},
+			Push: &FCMConfig{
+				Endpoint: "https://fcm.googleapis.com",
+			},
+			RateLimits: map[string]*ChannelRateLimitConfig{
+				"sms": {RequestsPerSecond: 1, Burst: 10},
+			},
+			Workers:        4,
+			PollInterval:   time.Second,
+			BatchSize:      50,
+			MaxAttempts:    6,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     30 * time.Minute,
+			SendTimeout:    10 * time.Second,
+		},
+		Blob: &BlobConfig{
+			Enabled: false,
+			Driver:  "local",
+			Local: &LocalBlobConfig{
+				Dir:     "data/blobs",
+				BaseURL: "http://localhost:8080",
+			},
+			S3: &S3BlobConfig{
+				Region: "us-east-1",
+			},
+			GCS: &GCSBlobConfig{},
+		},
+		Search: &SearchConfig{
+			Enabled:  false,
+			Driver:   "postgres",
+			Language: "english",
+			Elasticsearch: &ElasticsearchConfig{
+				Addresses: []string{"http://localhost:9200"},
+			},
+		},
+		Tenancy: &TenancyConfig{
+			Enabled:      false,
+			Sources:      []string{"header"},
+			Header:       "X-Tenant-ID",
+			Claim:        "tenant_id",
+			Isolation:    "column",
+			Column:       "tenant_id",
+			SchemaPrefix: "tenant_",
+		},
+		Auth: &AuthConfig{
+			Enabled: false,
+			JWT: &JWTConfig{
+				TTL: 15 * time.Minute,
+			},
+			Revocation: &RevocationConfig{
+				Enabled:   false,
+				Prefix:    "revoked",
+				CacheTTL:  5 * time.Second,
+				CacheSize: 10000,
+			},
+			Users: &UsersConfig{
+				Enabled:           false,
+				AppName:           "myapp",
+				BaseURL:           "http://localhost:8080",
+				MinPasswordLength: 10,
+				VerificationTTL:   48 * time.Hour,
+				ResetTTL:          time.Hour,
+				Argon2: &Argon2Config{
+					Memory:      64 * 1024,
+					Iterations:  3,
+					Parallelism: 2,
+				},
+			},
+		},
+		Encryption: &EncryptionConfig{
+			Enabled:   false,
+			Source:    "env",
+			EnvPrefix: "APP_ENCRYPTION_KEY_",
+			KMS: &KMSConfig{
+				Region:     "us-east-1",
+				DataKeyTTL: 5 * time.Minute,
+				CacheSize:  1000,
+			},
+		},
+		Render: &RenderConfig{
+			Enabled: false,
+			Layout:  "base",
+			CSRF: &CSRFConfig{
+				Enabled:    true,
+				CookieName: "csrf_token",
+				HeaderName: "X-CSRF-Token",
+				FieldName:  "csrf_token",
+				Secure:     true,
+			},
+		},
+		I18n: &I18nConfig{
+			Enabled:       false,
+			DefaultLocale: "en",
+			QueryParam:    "lang",
+			Cookie:        "lang",
+		},
+		WorkerPool: &WorkerPoolConfig{
+			Enabled:   false,
+			Workers:   8,
+			QueueSize: 256,
+			Policy:    "block",
+		},
+		Chaos: &ChaosConfig{
+			Enabled: false,
+			HTTP: &ChaosHTTPConfig{
+				LatencyMin:   100 * time.Millisecond,
+				LatencyMax:   2 * time.Second,
+				ErrorStatus:  500,
+				ExcludePaths: []string{"/health"},
+			},
+			Database: &ChaosDatabaseConfig{
+				LatencyMin: 50 * time.Millisecond,
+				LatencyMax: 500 * time.Millisecond,
+			},
+		},
+		OpenAPI: &OpenAPIConfig{
+			Enabled:          false,
+			Spec:             "api/openapi.yaml",
+			Path:             "/openapi.yaml",
+			ValidateRequests: true,
+			MessagePrefix:    "validation",
+		},
+		Batch: &BatchConfig{
+			Enabled:      false,
+			Path:         "/batch",
+			MaxRequests:  20,
+			Concurrency:  4,
+			MaxBodyBytes: 1 << 20,
+		},
+		Deprecation: &DeprecationConfig{
+			Enabled:     false,
+			LogInterval: time.Hour,
+			MaxClients:  1000,
+		},
+		Coalesce: &CoalesceConfig{
+			Enabled:      false,
+			KeyHeaders:   []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"},
+			MaxBodyBytes: 1 << 20,
+		},
+		GeoIP: &GeoIPConfig{
+			Enabled:  false,
+			Database: "GeoLite2-Country.mmdb",
+			Metrics:  true,
+		},
+		Crash: &CrashConfig{
+			Enabled:    false,
+			Dir:        "crash",
+			LogLines:   200,
+			MaxReports: 20,
+		},
+		AdminUI: &AdminUIConfig{
+			Enabled:  false,
+			Path:     "/admin/ui",
+			PageSize: 50,
+		},
+		Operations: &OperationsConfig{
+			Enabled:       false,
+			Path:          "/operations",
+			Timeout:       time.Hour,
+			Retention:     24 * time.Hour,
+			RetryAfter:    2 * time.Second,
+			SweepInterval: time.Minute,
+		},
+		Imports: &ImportsConfig{
+			Enabled:     false,
+			BatchSize:   500,
+			MaxErrors:   100,
+			MaxFileSize: 50 << 20,
+			FormField:   "file",
+		},
+		Reports: &ReportsConfig{
+			Enabled:   false,
+			Layout:    "report",
+			Timeout:   time.Minute,
+			Prefix:    "reports",
+			URLExpiry: time.Hour,
 		},
 		App: &AppConfig{
 			Name:        "myapp",
@@ -217,8 +1894,25 @@ func DefaultConfig() *Config {
 
 // String returns a string representation of the config (with sensitive data masked)
 func (c *Config) String() string {
+	// The sections are pointers, so they are copied before masking
 	masked := *c
-	masked.Database.Password = "***"
+	if c.Database != nil {
+		database := *c.Database
+		if database.Password != "" {
+			database.Password = redacted
+		}
+		masked.Database = &database
+	}
+	if c.Redis != nil {
+		redis := *c.Redis
+		if redis.Password != "" {
+			redis.Password = redacted
+		}
+		if redis.SentinelPassword != "" {
+			redis.SentinelPassword = redacted
+		}
+		masked.Redis = &redis
+	}
 
 	data, _ := yaml.Marshal(masked)
 	return string(data)
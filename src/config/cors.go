@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CORS presets for common kinds of API
+const (
+	// CORSPresetInternal serves the organization's own frontends: the
+	// origins listed in allowed_origins, every method, and credentials
+	CORSPresetInternal = "internal"
+	// CORSPresetPublicReadOnly serves anyone reading a public API: any
+	// origin, read methods only, no credentials
+	CORSPresetPublicReadOnly = "public-readonly"
+)
+
+var (
+	// ErrCORSWildcardCredentials is returned for credentials allowed with
+	// "*" origins, which would let every site make authenticated requests
+	ErrCORSWildcardCredentials = errors.New(`config: cors.allow_credentials can't be combined with "*" origins`)
+	// ErrCORSWildcardProduction is returned for "*" origins in production
+	// without cors.allow_wildcard
+	ErrCORSWildcardProduction = errors.New(`config: cors "*" origins are refused in production unless cors.allow_wildcard is set`)
+)
+
+// Resolve returns the CORS settings in effect in environment. A preset
+// replaces the allowed methods, headers and credentials, and
+// public-readonly also allows any origin. Without origins, development
+// allows any and other environments none, so cross-origin access outside
+// development is opted into.
+func (c CORSConfig) Resolve(environment string) (CORSConfig, error) {
+	r := c
+	switch c.Preset {
+	case "":
+	case CORSPresetInternal:
+		if len(c.AllowedOrigins) == 0 || slices.Contains(c.AllowedOrigins, "*") {
+			return r, fmt.Errorf("config: cors preset %s requires allowed_origins to list the origins", c.Preset)
+		}
+		r.AllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+		r.AllowedHeaders = []string{"Accept", "Authorization", "Content-Type"}
+		r.AllowCredentials = true
+	case CORSPresetPublicReadOnly:
+		r.AllowedOrigins = []string{"*"}
+		r.AllowedMethods = []string{"GET", "HEAD", "OPTIONS"}
+		r.AllowedHeaders = []string{"Accept", "Content-Type"}
+		r.AllowCredentials = false
+	default:
+		return r, fmt.Errorf("config: unknown cors preset %q", c.Preset)
+	}
+
+	production := strings.EqualFold(environment, "production")
+	if len(r.AllowedOrigins) == 0 && strings.EqualFold(environment, "development") {
+		r.AllowedOrigins = []string{"*"}
+	}
+	if slices.Contains(r.AllowedOrigins, "*") {
+		if r.AllowCredentials {
+			return r, ErrCORSWildcardCredentials
+		}
+		// Choosing the public preset is as explicit as allow_wildcard
+		if production && !r.AllowWildcard && c.Preset != CORSPresetPublicReadOnly {
+			return r, ErrCORSWildcardProduction
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,516 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the configuration for values that would make the
+// service fail at startup or misbehave, and returns every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	oneOf := func(field, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", field, strings.Join(allowed, ", "), value))
+	}
+	fileExists := func(field, path string) {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+
+	if s := c.Server; s == nil {
+		check(false, "server section is required")
+	} else {
+		check(s.Port >= 0 && s.Port < 65536, "server.port must be between 0 and 65535, got %d", s.Port)
+		check(s.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+		if s.TLS != nil && s.TLS.Enabled {
+			fileExists("server.tls.cert_file", s.TLS.CertFile)
+			fileExists("server.tls.key_file", s.TLS.KeyFile)
+			if e := s.TLS.Expiry; e != nil && e.Enabled {
+				check(e.Interval > 0, "server.tls.expiry.interval must be positive")
+				check(e.WarnBefore >= 0, "server.tls.expiry.warn_before must not be negative")
+			}
+		}
+		if s.Admin != nil && s.Admin.Enabled {
+			check(s.Admin.Port >= 0 && s.Admin.Port < 65536, "server.admin.port must be between 0 and 65535, got %d", s.Admin.Port)
+			check(s.Admin.Port == 0 || s.Admin.Port != s.Port || s.Admin.Host != s.Host, "server.admin must not share the public address")
+		}
+		if s.Admin != nil && !s.Admin.Enabled && s.Admin.Token != "" {
+			check(len(s.Admin.Token) >= 32, "server.admin.token must be at least 32 characters")
+		}
+		if h := s.Health; h != nil {
+			check(h.CacheTTL >= 0, "server.health.cache_ttl must not be negative")
+			for name, c := range h.Checks {
+				check(c != nil, "server.health.checks.%s must not be empty", name)
+				check(c == nil || c.Timeout >= 0, "server.health.checks.%s.timeout must not be negative", name)
+			}
+		}
+		if r := s.Readiness; r != nil && r.Enabled {
+			check(r.MaxWait > 0, "server.readiness.max_wait must be positive")
+		}
+		if w := s.Warmup; w != nil && w.Enabled {
+			check(w.Timeout > 0, "server.warmup.timeout must be positive")
+			for i, r := range w.Requests {
+				check(strings.HasPrefix(r.Path, "/"), "server.warmup.requests[%d].path must start with /, got %q", i, r.Path)
+				check(r.Repeat >= 0, "server.warmup.requests[%d].repeat must not be negative", i)
+				check(r.Status == 0 || (r.Status >= 100 && r.Status < 600), "server.warmup.requests[%d].status must be an HTTP status, got %d", i, r.Status)
+			}
+		}
+		if l := s.Listener; l != nil {
+			oneOf("server.listener.network", l.Network, "", "tcp", "unix", "systemd")
+			if l.Network == "unix" {
+				check(l.Path != "", "server.listener.path is required for unix sockets")
+				_, err := strconv.ParseUint(l.Mode, 8, 32)
+				check(l.Mode == "" || err == nil, "server.listener.mode must be octal permissions, got %q", l.Mode)
+			}
+		}
+		if ri := s.RealIP; ri != nil {
+			for _, proxy := range ri.TrustedProxies {
+				_, _, err := net.ParseCIDR(proxy)
+				check(err == nil || net.ParseIP(proxy) != nil, "server.real_ip.trusted_proxies: invalid IP or CIDR %q", proxy)
+			}
+		}
+		if p := s.ProxyProtocol; p != nil && p.Enabled {
+			for _, proxy := range p.TrustedProxies {
+				_, _, err := net.ParseCIDR(proxy)
+				check(err == nil || net.ParseIP(proxy) != nil, "server.proxy_protocol.trusted_proxies: invalid IP or CIDR %q", proxy)
+			}
+		}
+		if cors := s.CORS; cors != nil {
+			environment := ""
+			if c.App != nil {
+				environment = c.App.Environment
+			}
+			if _, err := cors.Resolve(environment); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if c := s.Connections; c != nil {
+			check(c.Max >= 0 && c.MaxPerIP >= 0, "server.connections limits must not be negative")
+		}
+		if b := s.BindRetry; b != nil {
+			check(b.Attempts >= 0, "server.bind_retry.attempts must not be negative")
+		}
+		if p := s.ShutdownPhases; p != nil {
+			check(p.Consumers >= 0 && p.Flush >= 0 && p.Close >= 0, "server.shutdown_phases timeouts must not be negative")
+		}
+	}
+
+	if d := c.Database; d == nil {
+		check(false, "database section is required")
+	} else {
+		check(d.GetDSN() != "", "database.driver %q is not supported", d.Driver)
+		failover := d.Failover != nil && d.Failover.Enabled
+		check((d.Host != "" || failover) && d.Name != "", "database.host and database.name are required")
+		if l := d.LeakDetection; l != nil && l.Enabled {
+			check(l.StackSampleRate >= 0 && l.StackSampleRate <= 1, "database.leak_detection.stack_sample_rate must be between 0 and 1")
+			check(l.Interval > 0, "database.leak_detection.interval must be positive")
+			check(l.MaxAge >= 0 && l.MaxOpen >= 0, "database.leak_detection thresholds must not be negative")
+		}
+		check(!d.AuditContexts || c.App == nil || !c.App.IsProduction(), "database.audit_contexts must not be enabled in production")
+		check(d.MinQueryBudget >= 0, "database.min_query_budget must not be negative")
+		if x := d.Explain; x != nil && x.Enabled {
+			check(d.Driver == "postgres" || d.Driver == "postgresql", "database.explain requires the postgres driver")
+			check(d.SlowQueryThreshold > 0, "database.explain requires database.slow_query_threshold")
+			check(x.MaxPerMinute > 0, "database.explain.max_per_minute must be positive")
+			check(x.Cooldown >= 0, "database.explain.cooldown must not be negative")
+			check(x.Timeout > 0, "database.explain.timeout must be positive")
+		}
+		if q := d.QueryStats; q != nil && q.Enabled {
+			check(d.Driver == "postgres" || d.Driver == "postgresql", "database.query_stats requires the postgres driver")
+			check(q.Interval > 0, "database.query_stats.interval must be positive")
+			check(q.Limit > 0, "database.query_stats.limit must be positive")
+			check(q.Timeout > 0, "database.query_stats.timeout must be positive")
+		}
+		if f := d.Failover; failover {
+			check(d.Driver == "postgres" || d.Driver == "postgresql", "database.failover requires the postgres driver")
+			check(len(f.Hosts) > 0, "database.failover.hosts is required")
+			for _, h := range f.Hosts {
+				_, _, err := SplitHostPort(h, d.Port)
+				check(err == nil, "database.failover.hosts: %v", err)
+			}
+			check(f.ProbeTimeout > 0, "database.failover.probe_timeout must be positive")
+		}
+		oneOf("database.auth_method", d.AuthMethod, "", "password", "aws_iam", "gcp_iam")
+		if d.AuthMethod == "aws_iam" || d.AuthMethod == "gcp_iam" {
+			check(d.Driver == "postgres" || d.Driver == "postgresql", "database.auth_method %s requires the postgres driver", d.AuthMethod)
+			check(d.User != "", "database.user is required with database.auth_method %s", d.AuthMethod)
+			check(d.SSLMode != "" && d.SSLMode != "disable", "database.auth_method %s requires database.ssl_mode other than disable", d.AuthMethod)
+			check(d.Credentials == nil || d.Credentials.Source == "", "database.credentials.source can't be combined with database.auth_method %s", d.AuthMethod)
+			// RDS tokens are signed for one endpoint
+			check(d.AuthMethod != "aws_iam" || !failover, "database.auth_method aws_iam can't be combined with database.failover")
+		}
+		if cr := d.Credentials; cr != nil && cr.Source != "" {
+			oneOf("database.credentials.source", cr.Source, "file")
+			if cr.Source == "file" {
+				fileExists("database.credentials.file", cr.File)
+			}
+			check(cr.RefreshInterval >= 0, "database.credentials.refresh_interval must not be negative")
+		}
+		if len(d.Partitions) > 0 {
+			reserved := 0
+			for name, conns := range d.Partitions {
+				check(name != "" && name != "interactive", "database.partitions: %q can't be a partition name", name)
+				check(conns > 0, "database.partitions.%s must be positive", name)
+				reserved += conns
+			}
+			check(d.MaxOpenConns > reserved, "database.partitions must leave database.max_open_conns some interactive connections")
+		}
+	}
+
+	if l := c.Logger; l != nil {
+		oneOf("logger.level", strings.ToLower(l.Level), "debug", "info", "warn", "error", "dpanic", "panic", "fatal")
+		if l.Output == "file" {
+			check(l.File != "", "logger.file is required when logger.output is file")
+		}
+	}
+	if m := c.Metrics; m != nil && m.Enabled {
+		check(m.ReconnectInterval >= 0, "metrics.reconnect_interval must not be negative")
+		check(m.Path == "" || strings.HasPrefix(m.Path, "/"), "metrics.path must start with /")
+		if m.Type == "buffered" {
+			check(m.UnderlyingType != "buffered", "metrics.underlying_type must not be buffered")
+			check(m.BufferSize > 0, "metrics.buffer_size must be positive for the buffered type")
+			check(m.FlushInterval > 0, "metrics.flush_interval must be positive for the buffered type")
+		}
+	}
+	if t := c.Tracing; t != nil && t.Enabled {
+		oneOf("tracing.exporter", t.Exporter, "otlp-grpc", "otlp-http", "stdout")
+		check(t.SampleRatio >= 0 && t.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	}
+
+	if w := c.Webhooks; w != nil && w.Enabled {
+		check(c.Auth != nil && c.Auth.Enabled, "webhooks requires auth.enabled")
+		check(c.Tenancy != nil && c.Tenancy.Enabled, "webhooks requires tenancy.enabled")
+	}
+	if m := c.Messaging; m != nil {
+		oneOf("messaging.driver", m.Driver, "", "kafka", "nats")
+	}
+	if o := c.Outbox; o != nil && o.Enabled {
+		check(c.Messaging != nil && c.Messaging.Driver != "", "outbox requires messaging.driver")
+	}
+	if e := c.Events; e != nil && e.Enabled {
+		check(e.PollInterval > 0, "events.poll_interval must be positive")
+		check(e.BatchSize > 0, "events.batch_size must be positive")
+		check(!e.Publish || c.Outbox != nil && c.Outbox.Enabled, "events.publish requires outbox.enabled")
+	}
+	if e := c.Email; e != nil && e.Enabled {
+		oneOf("email.provider", e.Provider, "", "smtp", "ses", "sendgrid")
+	}
+	if n := c.Notify; n != nil && n.Enabled {
+		check(n.Workers > 0, "notifications.workers must be positive")
+		check(n.PollInterval > 0, "notifications.poll_interval must be positive")
+		check(n.BatchSize > 0, "notifications.batch_size must be positive")
+		check(n.MaxAttempts > 0, "notifications.max_attempts must be positive")
+		check(n.SendTimeout > 0, "notifications.send_timeout must be positive")
+		check((n.Email != nil && n.Email.Enabled) || (n.SMS != nil && n.SMS.Enabled) ||
+			(n.Slack != nil && n.Slack.Enabled) || (n.Push != nil && n.Push.Enabled),
+			"notifications need at least one enabled channel")
+		if e := n.Email; e != nil && e.Enabled {
+			check(c.Email != nil && c.Email.Enabled, "notifications.email requires email.enabled")
+		}
+		if s := n.SMS; s != nil && s.Enabled {
+			check(s.AccountSID != "" && s.AuthToken != "", "notifications.sms requires account_sid and auth_token")
+			check(s.From != "", "notifications.sms.from is required")
+		}
+		if s := n.Slack; s != nil && s.Enabled {
+			check(len(s.Webhooks) > 0, "notifications.slack.webhooks must not be empty")
+			for name, url := range s.Webhooks {
+				check(strings.HasPrefix(url, "https://"), "notifications.slack.webhooks.%s must be an https URL", name)
+			}
+		}
+		if p := n.Push; p != nil && p.Enabled {
+			check(p.ProjectID != "", "notifications.push.project_id is required")
+			if p.CredentialsFile != "" {
+				fileExists("notifications.push.credentials_file", p.CredentialsFile)
+			}
+		}
+		for channel, limit := range n.RateLimits {
+			oneOf("notifications.rate_limits", channel, "email", "sms", "slack", "push")
+			check(limit != nil && limit.RequestsPerSecond > 0 && limit.Burst > 0,
+				"notifications.rate_limits.%s needs a positive requests_per_second and burst", channel)
+		}
+	}
+	if b := c.Blob; b != nil && b.Enabled {
+		oneOf("blob.driver", b.Driver, "", "local", "s3", "gcs")
+		check(b.Driver == "" || b.Driver == "local" || b.Bucket != "", "blob.bucket is required for the %s driver", b.Driver)
+	}
+	if cc := c.Cache; cc != nil && cc.Enabled {
+		oneOf("cache.driver", cc.Driver, "memory", "redis")
+		check(cc.Driver != "memory" || cc.MaxEntries > 0, "cache.max_entries must be positive")
+		check(cc.DefaultTTL > 0, "cache.default_ttl must be positive")
+		check(cc.Driver != "redis" || (c.Redis != nil && c.Redis.Enabled), "cache.driver redis requires redis.enabled")
+	}
+	if s := c.Search; s != nil && s.Enabled {
+		oneOf("search.driver", s.Driver, "", "postgres", "elasticsearch", "opensearch")
+	}
+	if f := c.Flags; f != nil && f.Enabled {
+		oneOf("feature_flags.provider", f.Provider, "", "database", "file")
+	}
+	if r := c.RateLimit; r != nil && r.Enabled {
+		check(r.RequestsPerSecond > 0, "rate_limit.requests_per_second must be positive")
+		check(r.Burst > 0, "rate_limit.burst must be positive")
+		check(r.RefreshInterval > 0, "rate_limit.refresh_interval must be positive")
+		check(r.IdleTimeout > 0, "rate_limit.idle_timeout must be positive")
+	}
+	if l := c.Shedding; l != nil && l.Enabled {
+		check(l.Interval > 0, "load_shedding.interval must be positive")
+		check(l.MaxP99Latency >= 0, "load_shedding.max_p99_latency must not be negative")
+		check(l.MaxInFlight >= 0, "load_shedding.max_in_flight must not be negative")
+		check(l.MaxPoolWait >= 0, "load_shedding.max_pool_wait must not be negative")
+		check(l.MaxP99Latency > 0 || l.MaxInFlight > 0 || l.MaxPoolWait > 0,
+			"load_shedding needs one of max_p99_latency, max_in_flight and max_pool_wait")
+		check(l.RetryAfter >= 0, "load_shedding.retry_after must not be negative")
+		oneOf("load_shedding.default_priority", l.DefaultPriority, "critical", "normal", "low")
+		for i, r := range l.Routes {
+			field := fmt.Sprintf("load_shedding.routes[%d]", i)
+			if r == nil {
+				check(false, "%s must not be empty", field)
+				continue
+			}
+			check(strings.HasPrefix(r.Pattern, "/"), "%s.pattern must start with /", field)
+			for _, m := range r.Methods {
+				oneOf(field+".methods", strings.ToUpper(m), "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+			}
+			oneOf(field+".priority", r.Priority, "critical", "normal", "low")
+		}
+	}
+	if f := c.IPFilter; f != nil && f.Enabled {
+		check(!f.Database || f.RefreshInterval > 0, "ip_filter.refresh_interval must be positive")
+		for _, entry := range f.Allow {
+			check(isIPOrCIDR(entry), "ip_filter.allow: %q is not an IP address or CIDR range without host bits", entry)
+		}
+		for _, entry := range f.Deny {
+			check(isIPOrCIDR(entry), "ip_filter.deny: %q is not an IP address or CIDR range without host bits", entry)
+		}
+	}
+	if b := c.Bots; b != nil && b.Enabled {
+		oneOf("bot_detection.mode", b.Mode, "enforce", "monitor")
+		check(b.ChallengeScore > 0, "bot_detection.challenge_score must be positive")
+		check(b.DenyScore >= b.ChallengeScore, "bot_detection.deny_score must be at least challenge_score")
+		if u := b.UserAgent; u != nil && u.Enabled {
+			for _, pattern := range u.Patterns {
+				_, err := regexp.Compile(pattern)
+				check(err == nil, "bot_detection.user_agent.patterns: invalid pattern %q: %v", pattern, err)
+			}
+		}
+		if r := b.Rate; r != nil && r.Enabled {
+			check(r.RequestsPerSecond > 0, "bot_detection.rate.requests_per_second must be positive")
+			check(r.Burst > 0, "bot_detection.rate.burst must be positive")
+			check(r.IdleTimeout > 0, "bot_detection.rate.idle_timeout must be positive")
+		}
+		if e := b.External; e != nil && e.Enabled {
+			u, err := url.Parse(e.URL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "bot_detection.external.url must be an http(s) URL")
+			check(e.Timeout > 0, "bot_detection.external.timeout must be positive")
+		}
+	}
+	if e := c.Encryption; e != nil && e.Enabled {
+		oneOf("encryption.source", e.Source, "", "env", "file", "kms")
+	}
+	if a := c.Auth; a != nil && a.Enabled {
+		check(a.JWT != nil && len(a.JWT.Secret) >= 32, "auth.jwt.secret must be at least 32 bytes")
+		if r := a.Revocation; r != nil && r.Enabled {
+			check(c.Redis != nil && c.Redis.Enabled, "auth.revocation requires redis.enabled")
+			check(r.Prefix != "", "auth.revocation.prefix is required")
+			check(r.CacheTTL >= 0, "auth.revocation.cache_ttl must not be negative")
+			check(r.CacheSize > 0, "auth.revocation.cache_size must be positive")
+		}
+	}
+	if p := c.WorkerPool; p != nil && p.Enabled {
+		check(p.Workers > 0, "worker_pool.workers must be positive")
+		oneOf("worker_pool.policy", p.Policy, "", "block", "reject", "caller_runs")
+	}
+	if o := c.OpenAPI; o != nil && o.Enabled {
+		fileExists("openapi.spec", o.Spec)
+		check(o.Path == "" || strings.HasPrefix(o.Path, "/"), "openapi.path must start with /")
+		check(!o.ValidateResponses || c.App == nil || !c.App.IsProduction(), "openapi.validate_responses must not be enabled in production")
+	}
+	if b := c.Batch; b != nil && b.Enabled {
+		check(strings.HasPrefix(b.Path, "/"), "batch.path must start with /")
+		check(b.MaxRequests > 0, "batch.max_requests must be positive")
+		check(b.Concurrency > 0, "batch.concurrency must be positive")
+		check(b.MaxBodyBytes > 0, "batch.max_body_bytes must be positive")
+	}
+	if d := c.Deprecation; d != nil && d.Enabled {
+		check(d.LogInterval > 0, "deprecation.log_interval must be positive")
+		check(d.MaxClients > 0, "deprecation.max_clients must be positive")
+		for i, r := range d.Routes {
+			if r == nil {
+				check(false, "deprecation.routes[%d] must not be empty", i)
+				continue
+			}
+			check(strings.HasPrefix(r.Pattern, "/"), "deprecation.routes[%d].pattern must start with /", i)
+			check(!r.Since.IsZero(), "deprecation.routes[%d].since is required", i)
+			check(r.Sunset.IsZero() || r.Sunset.After(r.Since), "deprecation.routes[%d].sunset must be after since", i)
+			oneOf(fmt.Sprintf("deprecation.routes[%d].method", i), strings.ToUpper(r.Method), "", "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+		}
+	}
+	if co := c.Coalesce; co != nil && co.Enabled {
+		for i, pattern := range co.Routes {
+			check(strings.HasPrefix(pattern, "/"), "coalesce.routes[%d] must start with /", i)
+		}
+		for i, h := range co.KeyHeaders {
+			check(strings.TrimSpace(h) != "", "coalesce.key_headers[%d] must not be empty", i)
+		}
+		check(co.MaxBodyBytes > 0, "coalesce.max_body_bytes must be positive")
+	}
+	if g := c.GeoIP; g != nil && g.Enabled {
+		fileExists("geoip.database", g.Database)
+		for i, r := range g.Rules {
+			field := fmt.Sprintf("geoip.rules[%d]", i)
+			if r == nil {
+				check(false, "%s must not be empty", field)
+				continue
+			}
+			check(strings.HasPrefix(r.Pattern, "/"), "%s.pattern must start with /", field)
+			for _, m := range r.Methods {
+				oneOf(field+".methods", strings.ToUpper(m), "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+			}
+			check((len(r.Allow) > 0) != (len(r.Deny) > 0), "%s must set exactly one of allow and deny", field)
+			for _, code := range append(append([]string(nil), r.Allow...), r.Deny...) {
+				check(isLocationCode(code), "%s: %q is not an ISO 3166 country or region code", field, code)
+			}
+		}
+	}
+	if a := c.AdminUI; a != nil && a.Enabled {
+		check(c.Server != nil && c.Server.Admin != nil && c.Server.Admin.Enabled, "admin_ui requires server.admin.enabled")
+		check(strings.HasPrefix(a.Path, "/"), "admin_ui.path must start with /")
+		check(a.PageSize > 0, "admin_ui.page_size must be positive")
+		check(len(a.Users) > 0, "admin_ui.users must not be empty")
+		for name, hash := range a.Users {
+			check(strings.HasPrefix(hash, "$argon2id$"), "admin_ui.users.%s must be an argon2id hash", name)
+		}
+		seen := map[string]bool{}
+		for i, t := range a.Tables {
+			check(t != nil && t.Name != "", "admin_ui.tables[%d].name is required", i)
+			if t != nil {
+				check(!seen[t.Name], "admin_ui.tables[%d]: %s listed more than once", i, t.Name)
+				seen[t.Name] = true
+			}
+		}
+	}
+	if o := c.Operations; o != nil && o.Enabled {
+		check(c.WorkerPool != nil && c.WorkerPool.Enabled, "operations require worker_pool.enabled")
+		check(strings.HasPrefix(o.Path, "/"), "operations.path must start with /")
+		check(o.Timeout > 0, "operations.timeout must be positive")
+		check(o.Retention > 0, "operations.retention must be positive")
+		check(o.SweepInterval > 0, "operations.sweep_interval must be positive")
+	}
+	if im := c.Imports; im != nil && im.Enabled {
+		check(c.Operations != nil && c.Operations.Enabled, "imports require operations.enabled")
+		check(im.BatchSize > 0, "imports.batch_size must be positive")
+		check(im.MaxErrors >= 0, "imports.max_errors must not be negative")
+		check(im.MaxFileSize > 0, "imports.max_file_size must be positive")
+		check(im.FormField != "", "imports.form_field is required")
+	}
+	if rp := c.Reports; rp != nil && rp.Enabled {
+		check(c.Operations != nil && c.Operations.Enabled, "reports require operations.enabled")
+		check(c.Blob != nil && c.Blob.Enabled, "reports require blob.enabled")
+		oneOf("reports.converter", rp.Converter, "", "wkhtmltopdf", "chromium")
+		check(rp.Layout != "", "reports.layout is required")
+		check(rp.Timeout > 0, "reports.timeout must be positive")
+		check(rp.Prefix != "" && !strings.HasPrefix(rp.Prefix, "/"), "reports.prefix must be a relative key prefix")
+		check(rp.URLExpiry > 0, "reports.url_expiry must be positive")
+	}
+	if cr := c.Crash; cr != nil && cr.Enabled {
+		check(cr.Dir != "", "crash_reports.dir is required")
+		check(cr.LogLines >= 0, "crash_reports.log_lines must not be negative")
+		check(cr.MaxReports > 0, "crash_reports.max_reports must be positive")
+	}
+	for i, r := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		check(strings.HasPrefix(r.Path, "/"), "%s.path must start with /", field)
+		for _, m := range r.Methods {
+			oneOf(field+".methods", strings.ToUpper(m), "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+		}
+		actions := 0
+		if rd := r.Redirect; rd != nil {
+			actions++
+			check(rd.To != "", "%s.redirect.to is required", field)
+			check(rd.Status == 0 || (rd.Status >= 301 && rd.Status <= 308 && rd.Status != 304 && rd.Status != 305 && rd.Status != 306),
+				"%s.redirect.status must be 301, 302, 303, 307 or 308, got %d", field, rd.Status)
+		}
+		if st := r.Static; st != nil {
+			actions++
+			check(st.Status == 0 || (st.Status >= 200 && st.Status < 600), "%s.static.status must be between 200 and 599, got %d", field, st.Status)
+			check(st.Body == "" || st.File == "", "%s.static sets both body and file", field)
+			if st.File != "" {
+				fileExists(field+".static.file", st.File)
+			}
+		}
+		if p := r.Proxy; p != nil {
+			actions++
+			u, err := url.Parse(p.Upstream)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"%s.proxy.upstream must be an http or https URL, got %q", field, p.Upstream)
+			check(p.Timeout >= 0, "%s.proxy.timeout must not be negative", field)
+		}
+		check(actions == 1, "%s must set exactly one of redirect, static and proxy", field)
+	}
+	if ch := c.Chaos; ch != nil && ch.Enabled {
+		check(c.App == nil || !c.App.IsProduction(), "chaos must not be enabled in production")
+		probability := func(field string, p float64) {
+			check(p >= 0 && p <= 1, "%s must be between 0 and 1, got %g", field, p)
+		}
+		if h := ch.HTTP; h != nil {
+			probability("chaos.http.latency_probability", h.LatencyProbability)
+			probability("chaos.http.error_probability", h.ErrorProbability)
+			probability("chaos.http.drop_probability", h.DropProbability)
+			check(h.LatencyMin <= h.LatencyMax, "chaos.http.latency_min must not exceed latency_max")
+			check(h.ErrorStatus == 0 || (h.ErrorStatus >= 400 && h.ErrorStatus < 600),
+				"chaos.http.error_status must be a 4xx or 5xx status, got %d", h.ErrorStatus)
+		}
+		if d := ch.Database; d != nil {
+			probability("chaos.database.latency_probability", d.LatencyProbability)
+			probability("chaos.database.error_probability", d.ErrorProbability)
+			check(d.LatencyMin <= d.LatencyMax, "chaos.database.latency_min must not exceed latency_max")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// isLocationCode reports whether code looks like an ISO 3166-1 alpha-2
+// country ("US") or ISO 3166-2 region ("US-CA")
+func isLocationCode(code string) bool {
+	country, region, ok := strings.Cut(code, "-")
+	if len(country) != 2 || !isAlnum(country, false) {
+		return false
+	}
+	return !ok || (len(region) >= 1 && len(region) <= 3 && isAlnum(region, true))
+}
+
+func isAlnum(s string, digits bool) bool {
+	for _, c := range s {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || digits && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isIPOrCIDR reports whether entry is an address or a CIDR range with no
+// host bits set
+func isIPOrCIDR(entry string) bool {
+	if net.ParseIP(entry) != nil {
+		return true
+	}
+	ip, network, err := net.ParseCIDR(entry)
+	return err == nil && network.IP.Equal(ip)
+}
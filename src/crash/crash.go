@@ -0,0 +1,248 @@
+// Package crash writes a report when the process dies: its build, a
+// fingerprint of its config, every goroutine's stack and the most recent
+// log entries. Reports go to a directory that outlives the container's
+// stdout, e.g. a mounted volume.
+package crash
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/logger"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// runtimeMarker ends the header of the runtime crash file; a file that
+// ends with it recorded no crash
+const runtimeMarker = "== Runtime output ==\n"
+
+// Reporter writes crash reports. Panics recovered with Recover and fatal
+// log entries get a full report. Crashes it can't intercept, such as a
+// panic in another goroutine or a concurrent map write, are written by
+// the runtime to a file opened at startup, after a header with the build
+// and config fingerprint; that file is deleted on a clean exit.
+type Reporter struct {
+	cfg     *config.CrashConfig
+	app     *config.AppConfig
+	config  string // fingerprint
+	started time.Time
+	logs    *ring
+
+	mu          sync.Mutex // guards runtimeFile
+	runtimeFile *os.File
+	once        sync.Once
+}
+
+// New creates the report directory, prunes old reports and routes the
+// runtime's crash output to a file in it
+func New(cfg *config.CrashConfig, appCfg *config.Config) (*Reporter, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create crash report directory: %w", err)
+	}
+	r := &Reporter{
+		cfg:     cfg,
+		app:     appCfg.App,
+		config:  fingerprint(appCfg),
+		started: time.Now(),
+		logs:    newRing(cfg.LogLines),
+	}
+	r.prune()
+
+	f, err := os.Create(r.path(".runtime.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runtime crash file: %w", err)
+	}
+	var header bytes.Buffer
+	r.writeHeader(&header, "runtime crash, see below", false)
+	header.WriteString("\n" + runtimeMarker)
+	if _, err := f.Write(header.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	r.runtimeFile = f
+	return r, nil
+}
+
+// Wrap returns lgr with its entries kept for reports and a report
+// written before a Fatal entry exits
+func (r *Reporter) Wrap(lgr *zap.Logger) *zap.Logger {
+	return lgr.WithOptions(
+		zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			encoder := zap.NewProductionEncoderConfig()
+			encoder.EncodeTime = zapcore.ISO8601TimeEncoder
+			// Kept at the logger's level, so the report holds what the
+			// logs would have
+			report := zapcore.NewCore(zapcore.NewJSONEncoder(encoder), r.logs, core)
+			return zapcore.NewTee(core, logger.RedactingCore(report))
+		}),
+		zap.WithFatalHook(r),
+	)
+}
+
+// OnWrite implements zapcore.CheckWriteHook for Fatal entries
+func (r *Reporter) OnWrite(entry *zapcore.CheckedEntry, _ []zapcore.Field) {
+	r.Report("fatal: " + entry.Message)
+	os.Exit(1)
+}
+
+// Recover writes a report for a panic and panics again, so the process
+// still dies as it would have. Defer it at the top of main and of
+// goroutines that don't recover themselves:
+//
+//	defer reporter.Recover()
+func (r *Reporter) Recover() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	r.Report(fmt.Sprintf("panic: %v", p))
+	panic(p)
+}
+
+// Report writes a report for reason, once. It stops routing the runtime's
+// crash output to a file, as the report holds every stack already.
+func (r *Reporter) Report(reason string) {
+	r.once.Do(func() {
+		var report bytes.Buffer
+		r.writeHeader(&report, reason, true)
+
+		report.WriteString("\n== Goroutines ==\n")
+		stacks := make([]byte, 1<<20)
+		for {
+			n := runtime.Stack(stacks, true)
+			if n < len(stacks) || len(stacks) >= 64<<20 {
+				report.Write(stacks[:n])
+				break
+			}
+			stacks = make([]byte, 2*len(stacks))
+		}
+
+		lines := r.logs.lines()
+		fmt.Fprintf(&report, "\n== Recent logs (%d) ==\n", len(lines))
+		for _, line := range lines {
+			report.Write(line)
+		}
+
+		path := r.path(".txt")
+		if err := os.WriteFile(path, report.Bytes(), 0o640); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write crash report: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "crash report written to %s\n", path)
+		}
+		r.closeRuntimeFile()
+	})
+}
+
+// Close stops routing crash output to the runtime file and deletes it, as
+// the process is exiting cleanly
+func (r *Reporter) Close() error {
+	r.closeRuntimeFile()
+	return nil
+}
+
+func (r *Reporter) closeRuntimeFile() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.runtimeFile == nil {
+		return
+	}
+	debug.SetCrashOutput(nil, debug.CrashOptions{})
+	r.runtimeFile.Close()
+	os.Remove(r.runtimeFile.Name())
+	r.runtimeFile = nil
+}
+
+// writeHeader describes the process. The runtime file's header is written
+// at startup, so it leaves out what is only known at the crash.
+func (r *Reporter) writeHeader(b *bytes.Buffer, reason string, crashed bool) {
+	line := func(label, format string, args ...interface{}) {
+		fmt.Fprintf(b, "%-14s %s\n", label+":", fmt.Sprintf(format, args...))
+	}
+	host, _ := os.Hostname()
+	b.WriteString("Crash report\n\n")
+	line("Reason", "%s", reason)
+	if crashed {
+		now := time.Now().UTC()
+		line("Time", "%s", now.Format(time.RFC3339Nano))
+		line("Started", "%s (up %s)", r.started.UTC().Format(time.RFC3339), now.Sub(r.started).Round(time.Second))
+	} else {
+		line("Started", "%s", r.started.UTC().Format(time.RFC3339))
+	}
+	if r.app != nil {
+		line("Service", "%s %s (%s)", r.app.Name, r.app.Version, r.app.Environment)
+		if r.app.InstanceID != "" {
+			line("Instance", "%s", r.app.InstanceID)
+		}
+	}
+	line("Host", "%s, pid %d", host, os.Getpid())
+	line("Go", "%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if crashed {
+		line("Goroutines", "%d", runtime.NumGoroutine())
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		line("Module", "%s %s", info.Main.Path, info.Main.Version)
+		for _, s := range info.Settings {
+			if strings.HasPrefix(s.Key, "vcs.") {
+				line(s.Key, "%s", s.Value)
+			}
+		}
+	}
+	line("Config", "sha256:%s", r.config)
+}
+
+// path names a file for this process, sorting reports by start time
+func (r *Reporter) path(suffix string) string {
+	return filepath.Join(r.cfg.Dir, fmt.Sprintf("crash-%s-%d%s", r.started.UTC().Format("20060102T150405Z"), os.Getpid(), suffix))
+}
+
+// prune deletes runtime files left by processes that were killed rather
+// than crashing, then all but the newest cfg.MaxReports reports
+func (r *Reporter) prune() {
+	paths, _ := filepath.Glob(filepath.Join(r.cfg.Dir, "crash-*.txt"))
+	var reports []string
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".runtime.txt") {
+			if data, err := os.ReadFile(path); err == nil && bytes.HasSuffix(data, []byte(runtimeMarker)) {
+				os.Remove(path)
+				continue
+			}
+		}
+		reports = append(reports, path)
+	}
+	sort.Strings(reports)
+	// Leave room for this process's report
+	for len(reports) >= r.cfg.MaxReports && len(reports) > 0 {
+		os.Remove(reports[0])
+		reports = reports[1:]
+	}
+}
+
+// fingerprint identifies the configuration without revealing it, so
+// reports can be matched to a deployment's config
+func fingerprint(cfg *config.Config) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
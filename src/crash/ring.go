@@ -0,0 +1,43 @@
+package crash
+
+import "sync"
+
+// ring keeps the last size log entries, one encoded entry per Write
+type ring struct {
+	mu      sync.Mutex
+	entries [][]byte
+	next    int
+	full    bool
+}
+
+func newRing(size int) *ring {
+	return &ring{entries: make([][]byte, size)}
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	if len(r.entries) == 0 {
+		return len(p), nil
+	}
+	// The encoder reuses its buffer
+	entry := append([]byte(nil), p...)
+	r.mu.Lock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+func (r *ring) Sync() error { return nil }
+
+// lines returns the entries oldest first
+func (r *ring) lines() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([][]byte(nil), r.entries[:r.next]...)
+	}
+	return append(append([][]byte(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
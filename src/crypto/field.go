@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Value returns an argument for Exec/Query that stores plaintext encrypted,
+// bound to column:
+//
+//	engine.Exec(ctx, "UPDATE users SET ssn = $2 WHERE id = $1", id, enc.Value(ctx, "users.ssn", ssn))
+//
+// Encryption errors surface from the Exec call.
+func (e *Encryptor) Value(ctx context.Context, column, plaintext string) driver.Valuer {
+	return &encryptedValue{enc: e, ctx: ctx, column: column, plaintext: &plaintext}
+}
+
+// NullValue is Value for nullable columns; nil stores NULL
+func (e *Encryptor) NullValue(ctx context.Context, column string, plaintext *string) driver.Valuer {
+	return &encryptedValue{enc: e, ctx: ctx, column: column, plaintext: plaintext}
+}
+
+// Scan returns a scan destination that decrypts column into dest:
+//
+//	row.Scan(&u.ID, enc.Scan(ctx, "users.ssn", &u.SSN))
+//
+// NULL scans as the empty string.
+func (e *Encryptor) Scan(ctx context.Context, column string, dest *string) sql.Scanner {
+	return &encryptedScanner{enc: e, ctx: ctx, column: column, dest: dest}
+}
+
+// NullScan is Scan for nullable columns; NULL sets *dest to nil
+func (e *Encryptor) NullScan(ctx context.Context, column string, dest **string) sql.Scanner {
+	return &encryptedScanner{enc: e, ctx: ctx, column: column, nullDest: dest}
+}
+
+type encryptedValue struct {
+	enc       *Encryptor
+	ctx       context.Context
+	column    string
+	plaintext *string
+}
+
+func (v *encryptedValue) Value() (driver.Value, error) {
+	if v.plaintext == nil {
+		return nil, nil
+	}
+	ciphertext, err := v.enc.Encrypt(v.ctx, []byte(*v.plaintext), []byte(v.column))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", v.column, err)
+	}
+	return ciphertext, nil
+}
+
+type encryptedScanner struct {
+	enc      *Encryptor
+	ctx      context.Context
+	column   string
+	dest     *string
+	nullDest **string
+}
+
+func (s *encryptedScanner) Scan(src interface{}) error {
+	var ciphertext string
+	switch v := src.(type) {
+	case nil:
+		if s.nullDest != nil {
+			*s.nullDest = nil
+		} else {
+			*s.dest = ""
+		}
+		return nil
+	case string:
+		ciphertext = v
+	case []byte:
+		ciphertext = string(v)
+	default:
+		return fmt.Errorf("cannot decrypt %s from %T", s.column, src)
+	}
+
+	plaintext, err := s.enc.Decrypt(s.ctx, ciphertext, []byte(s.column))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", s.column, err)
+	}
+
+	value := string(plaintext)
+	if s.nullDest != nil {
+		*s.nullDest = &value
+	} else {
+		*s.dest = value
+	}
+	return nil
+}
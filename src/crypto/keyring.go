@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keySize is the AES-256 key length, for both key encryption keys and
+// data keys
+const keySize = 32
+
+// Keyring holds key encryption keys in process memory, loaded from the
+// environment or a file. Each value gets a fresh random data key.
+type Keyring struct {
+	keys    map[string][]byte
+	primary string
+}
+
+var _ KeyWrapper = (*Keyring)(nil)
+
+// NewKeyring creates a keyring. primary must be one of keys; the others
+// remain available for decrypting values written before a rotation.
+func NewKeyring(keys map[string][]byte, primary string) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary encryption key %q not found", primary)
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid encryption key id %q", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, keySize, len(key))
+		}
+	}
+	return &Keyring{keys: keys, primary: primary}, nil
+}
+
+// PrimaryKeyID returns the key used for new values
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// DataKey generates a random data key wrapped by the primary key
+func (k *Keyring) DataKey(ctx context.Context) (string, []byte, []byte, error) {
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", nil, nil, err
+	}
+
+	// The key ID is bound as associated data so a wrapped key can't be
+	// relabelled
+	wrapped, err := seal(k.keys[k.primary], dek, []byte(k.primary))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return k.primary, dek, wrapped, nil
+}
+
+// Unwrap decrypts a data key wrapped by keyID
+func (k *Keyring) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return open(kek, wrapped, []byte(keyID))
+}
+
+// LoadEnvKeys reads keys from environment variables named prefix + key
+// ID, each holding a base64-encoded 32-byte key, e.g.
+// APP_ENCRYPTION_KEY_2024=... for key ID "2024". Key IDs are lowercased.
+func LoadEnvKeys(prefix string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		id, ok := strings.CutPrefix(name, prefix)
+		if !ok || id == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		keys[strings.ToLower(id)] = key
+	}
+	return keys, nil
+}
+
+// LoadFileKeys reads keys from a YAML file, typically a mounted secret:
+//
+//	keys:
+//	  "2024": <base64 key>
+//	  "2025": <base64 key>
+func LoadFileKeys(path string) (map[string][]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Keys map[string]string `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, value := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q in %s: %w", id, path, err)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"coffee-and-running/src/cache"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// kmsKeyID labels values protected by KMS. The wrapped blob identifies the
+// KMS key itself, and KMS rotates key material transparently.
+const kmsKeyID = "kms"
+
+// KMS wraps data keys with an AWS KMS key. A data key is reused for
+// dataKeyTTL to keep KMS calls off the write path, and unwrapped keys are
+// cached so reads don't call KMS for every row.
+type KMS struct {
+	client     *kms.Client
+	keyID      string
+	dataKeyTTL time.Duration
+	unwrapped  *cache.Memory[[]byte]
+
+	mu        sync.Mutex
+	current   []byte
+	wrapped   []byte
+	expiresAt time.Time
+}
+
+var _ KeyWrapper = (*KMS)(nil)
+
+// NewKMS creates a KMS key wrapper for keyID, which may be a key ID, ARN
+// or alias
+func NewKMS(ctx context.Context, region, keyID string, dataKeyTTL time.Duration, cacheSize int, stats metrics.Agent) (*KMS, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+
+	return &KMS{
+		client:     kms.NewFromConfig(awsCfg),
+		keyID:      keyID,
+		dataKeyTTL: dataKeyTTL,
+		unwrapped:  cache.NewMemory[[]byte]("crypto_kms", cacheSize, dataKeyTTL, stats),
+	}, nil
+}
+
+// PrimaryKeyID returns "kms"
+func (k *KMS) PrimaryKeyID() string {
+	return kmsKeyID
+}
+
+// DataKey returns the current data key, generating a new one with KMS
+// when it has expired
+func (k *KMS) DataKey(ctx context.Context) (string, []byte, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.current == nil || time.Now().After(k.expiresAt) {
+		out, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(k.keyID),
+			KeySpec: types.DataKeySpecAes256,
+		})
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("failed to generate kms data key: %w", err)
+		}
+		k.current, k.wrapped = out.Plaintext, out.CiphertextBlob
+		k.expiresAt = time.Now().Add(k.dataKeyTTL)
+	}
+	return kmsKeyID, k.current, k.wrapped, nil
+}
+
+// Unwrap decrypts a data key with KMS, using the cache when possible
+func (k *KMS) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != kmsKeyID {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	sum := sha256.Sum256(wrapped)
+	return k.unwrapped.GetOrLoad(ctx, hex.EncodeToString(sum[:]), k.dataKeyTTL, func(ctx context.Context) ([]byte, error) {
+		out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+			CiphertextBlob: wrapped,
+			KeyId:          aws.String(k.keyID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt kms data key: %w", err)
+		}
+		return out.Plaintext, nil
+	})
+}
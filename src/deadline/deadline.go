@@ -0,0 +1,136 @@
+// Package deadline propagates a request's time budget between services.
+// The budget is the request context's deadline: the middleware sets it
+// from the route timeout, shortened by an X-Request-Deadline header from
+// the caller, and Transport passes what is left on to the services called
+// in turn. Storage and outbound clients fail fast once it is spent rather
+// than starting work nobody will wait for.
+//
+// Deadlines are absolute, so they assume the services' clocks are
+// synchronized; skew shortens or lengthens budgets by the same amount.
+package deadline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header carries the deadline, in RFC 3339 or as Unix milliseconds
+const Header = "X-Request-Deadline"
+
+// ErrExhausted is returned when the budget is spent before work starts.
+// It wraps context.DeadlineExceeded, so it is treated like any timeout.
+var ErrExhausted = fmt.Errorf("deadline: request budget exhausted: %w", context.DeadlineExceeded)
+
+// Remaining returns the time left before ctx's deadline, if it has one
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// Bound returns ctx bounded by timeout, when positive, on top of its own
+// deadline. It returns ErrExhausted without a new context when ctx's
+// budget has less than min left, so callers skip work that can't finish.
+func Bound(ctx context.Context, timeout, min time.Duration) (context.Context, context.CancelFunc, error) {
+	if remaining, ok := Remaining(ctx); ok && (remaining <= 0 || remaining < min) {
+		return ctx, func() {}, ErrExhausted
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Parse reads a deadline in RFC 3339 or as Unix milliseconds
+func Parse(value string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deadline %q", value)
+	}
+	return t, nil
+}
+
+// Format writes t as Header expects, in UTC to the millisecond
+func Format(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// Middleware gives each request a deadline of timeout from now, or the
+// caller's X-Request-Deadline when that is sooner; a later one is ignored,
+// so callers can only shorten the budget. Requests whose deadline has
+// already passed are answered with 504 without running the handler, and
+// so are those that run out of time before the handler responds.
+func Middleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Now().Add(timeout)
+			if value := r.Header.Get(Header); value != "" {
+				if requested, err := Parse(value); err == nil && requested.Before(deadline) {
+					deadline = requested
+				}
+			}
+			if !time.Now().Before(deadline) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer func() {
+				cancel()
+				if ctx.Err() == context.DeadlineExceeded {
+					w.WriteHeader(http.StatusGatewayTimeout)
+				}
+			}()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Transport is an http.RoundTripper that sends the outgoing request's
+// context deadline in Header, so the called service works within the same
+// budget, and fails without sending once the budget is spent
+type Transport struct {
+	// Base is the underlying transport; http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	d, ok := req.Context().Deadline()
+	if !ok {
+		return base.RoundTrip(req)
+	}
+	if !time.Now().Before(d) {
+		closeBody(req)
+		return nil, ErrExhausted
+	}
+	if req.Header.Get(Header) == "" {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set(Header, Format(d))
+	}
+	return base.RoundTrip(req)
+}
+
+// closeBody closes the body of a request that won't be sent, as
+// RoundTrip must
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,326 @@
+// Package deprecation marks API routes deprecated. Responses from them
+// carry the Deprecation (RFC 9745) and Sunset (RFC 8594) headers and Links
+// to the replacement and migration docs, and every call is recorded by
+// client, so the report at GET /admin/deprecations shows who still has to
+// migrate before a route is removed.
+//
+// Usage is kept in memory by each instance, so the report covers the
+// instance that serves it since it started; the deprecation.* metrics
+// add up across instances.
+package deprecation
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// otherClients counts the calls of clients beyond deprecation.max_clients
+const otherClients = "other"
+
+// Policy describes a deprecated route
+type Policy struct {
+	Since       time.Time `json:"since"`
+	Sunset      time.Time `json:"sunset,omitzero"`
+	Replacement string    `json:"replacement,omitempty"`
+	Link        string    `json:"link,omitempty"`
+}
+
+// setHeaders adds the policy's headers to a response
+func (p Policy) setHeaders(h http.Header) {
+	h.Set("Deprecation", fmt.Sprintf("@%d", p.Since.Unix()))
+	if !p.Sunset.IsZero() {
+		h.Set("Sunset", p.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if p.Replacement != "" {
+		h.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, p.Replacement))
+	}
+	if p.Link != "" {
+		h.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, p.Link))
+	}
+}
+
+// ClientUsage is how one client has used a deprecated route
+type ClientUsage struct {
+	Client    string    `json:"client"`
+	Calls     int64     `json:"calls"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserAgent string    `json:"user_agent,omitempty"`
+
+	logged time.Time
+}
+
+// RouteUsage is a deprecated route and the clients still calling it
+type RouteUsage struct {
+	Method  string        `json:"method"`
+	Pattern string        `json:"pattern"`
+	Policy  Policy        `json:"policy"`
+	Calls   int64         `json:"calls"`
+	Clients []ClientUsage `json:"clients"`
+}
+
+type route struct {
+	method, pattern string
+	policy          Policy
+	calls           int64
+	clients         map[string]*ClientUsage
+}
+
+// Tracker records calls to deprecated routes
+type Tracker struct {
+	config *config.DeprecationConfig
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	// configured holds the routes deprecated in config, by method and
+	// pattern, with "*" for any method
+	configured map[string]Policy
+
+	mu     sync.Mutex
+	routes map[string]*route
+}
+
+// NewTracker creates a tracker for the routes deprecated in cfg. Routes
+// are also deprecated in code with Deprecate.
+func NewTracker(cfg *config.DeprecationConfig, logger *zap.Logger, stats metrics.Agent) *Tracker {
+	t := &Tracker{
+		config:     cfg,
+		logger:     logger.With(zap.String("component", "deprecation")),
+		stats:      stats,
+		configured: make(map[string]Policy),
+		routes:     make(map[string]*route),
+	}
+	for _, r := range cfg.Routes {
+		method := strings.ToUpper(r.Method)
+		if method == "" {
+			method = "*"
+		}
+		t.configured[method+" "+r.Pattern] = Policy{Since: r.Since, Sunset: r.Sunset, Replacement: r.Replacement, Link: r.Link}
+	}
+	return t
+}
+
+// Deprecate returns middleware deprecating the routes it is used on:
+//
+//	r.With(tracker.Deprecate(deprecation.Policy{Since: since, Replacement: "/api/v2/users"})).Get("/api/v1/users", list)
+func (t *Tracker) Deprecate(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy.setHeaders(w.Header())
+			t.record(r, routePattern(r), policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware applies the routes deprecated in config. It goes last in the
+// pipeline, so the caller is authenticated, and waits for the router to
+// match the route before the response is written.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	if len(t.configured) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&routeWriter{ResponseWriter: w, tracker: t, request: r}, r)
+	})
+}
+
+// lookup returns the configured policy for the matched route, if any
+func (t *Tracker) lookup(r *http.Request) (string, Policy, bool) {
+	pattern := routePattern(r)
+	if pattern == "" {
+		return "", Policy{}, false
+	}
+	if policy, ok := t.configured[r.Method+" "+pattern]; ok {
+		return pattern, policy, true
+	}
+	policy, ok := t.configured["* "+pattern]
+	return pattern, policy, ok
+}
+
+// record counts a call to a deprecated route and logs the client, at most
+// once per log interval
+func (t *Tracker) record(r *http.Request, pattern string, policy Policy) {
+	client := clientOf(r)
+	now := time.Now()
+
+	t.mu.Lock()
+	key := r.Method + " " + pattern
+	rt, ok := t.routes[key]
+	if !ok {
+		rt = &route{method: r.Method, pattern: pattern, policy: policy, clients: make(map[string]*ClientUsage)}
+		t.routes[key] = rt
+	}
+	rt.calls++
+
+	usage, ok := rt.clients[client]
+	if !ok {
+		if len(rt.clients) >= t.config.MaxClients {
+			client = otherClients
+			usage = rt.clients[client]
+		}
+		if usage == nil {
+			usage = &ClientUsage{Client: client, FirstSeen: now}
+			rt.clients[client] = usage
+		}
+	}
+	usage.Calls++
+	usage.LastSeen = now
+	usage.UserAgent = r.UserAgent()
+	log := client != otherClients && now.Sub(usage.logged) >= t.config.LogInterval
+	if log {
+		usage.logged = now
+	}
+	t.mu.Unlock()
+
+	t.stats.Increment("deprecation.calls")
+	t.stats.Increment("deprecation." + strings.ToLower(r.Method) + "." + metricSegment(pattern) + ".calls")
+	if log {
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("route", pattern),
+			zap.String("client", client),
+			zap.String("user_agent", r.UserAgent()),
+		}
+		if !policy.Sunset.IsZero() {
+			fields = append(fields, zap.Time("sunset", policy.Sunset))
+		}
+		t.logger.Warn("deprecated route called", fields...)
+	}
+}
+
+// Report returns every deprecated route called so far, plus those
+// configured but not yet called, with their clients, busiest first
+func (t *Tracker) Report() []RouteUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := make([]RouteUsage, 0, len(t.routes)+len(t.configured))
+	seen := make(map[string]bool, len(t.routes))
+	for key, rt := range t.routes {
+		seen[key] = true
+		usage := RouteUsage{Method: rt.method, Pattern: rt.pattern, Policy: rt.policy, Calls: rt.calls}
+		for _, c := range rt.clients {
+			usage.Clients = append(usage.Clients, *c)
+		}
+		sort.Slice(usage.Clients, func(i, j int) bool { return usage.Clients[i].Calls > usage.Clients[j].Calls })
+		report = append(report, usage)
+	}
+	for key, policy := range t.configured {
+		method, pattern, _ := strings.Cut(key, " ")
+		if method != "*" && seen[key] {
+			continue
+		}
+		if method == "*" && t.calledAnyMethod(pattern) {
+			continue
+		}
+		report = append(report, RouteUsage{Method: method, Pattern: pattern, Policy: policy, Clients: []ClientUsage{}})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Pattern != report[j].Pattern {
+			return report[i].Pattern < report[j].Pattern
+		}
+		return report[i].Method < report[j].Method
+	})
+	return report
+}
+
+// calledAnyMethod reports whether pattern was called with any method.
+// t.mu must be held.
+func (t *Tracker) calledAnyMethod(pattern string) bool {
+	for _, rt := range t.routes {
+		if rt.pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// routeWriter applies a configured deprecation once the router has
+// matched the route, just before the response is written
+type routeWriter struct {
+	http.ResponseWriter
+	tracker *Tracker
+	request *http.Request
+	checked bool
+}
+
+func (w *routeWriter) check() {
+	if w.checked {
+		return
+	}
+	w.checked = true
+	if pattern, policy, ok := w.tracker.lookup(w.request); ok {
+		policy.setHeaders(w.ResponseWriter.Header())
+		w.tracker.record(w.request, pattern, policy)
+	}
+}
+
+func (w *routeWriter) WriteHeader(status int) {
+	w.check()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *routeWriter) Write(p []byte) (int, error) {
+	w.check()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *routeWriter) Flush() {
+	w.check()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *routeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// clientOf identifies the caller by authenticated subject, or else by IP
+func clientOf(r *http.Request) string {
+	if sub := auth.Subject(r); sub != "" {
+		return "subject:" + sub
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// routePattern returns the route template the router matched so far
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
+
+// metricSegment turns a route pattern into a metric-safe bucket segment,
+// e.g. "/api/v1/users/{id}" becomes "api_v1_users_id"
+func metricSegment(pattern string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '{' || r == '}' || r == '*':
+			return -1
+		default:
+			return '_'
+		}
+	}, pattern), "_")
+}
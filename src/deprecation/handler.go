@@ -0,0 +1,29 @@
+package deprecation
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// Handler exposes the deprecation report
+type Handler struct {
+	tracker *Tracker
+}
+
+// NewHandler creates the admin handler
+func NewHandler(tracker *Tracker) *Handler {
+	return &Handler{tracker: tracker}
+}
+
+// Mount registers GET /admin/deprecations
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/deprecations", h.report)
+}
+
+func (h *Handler) report(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"routes": h.tracker.Report()})
+}
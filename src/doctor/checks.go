@@ -0,0 +1,193 @@
+package doctor
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/migrations"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultStatsdAddress is where the statsd client sends without an address
+const defaultStatsdAddress = "localhost:8125"
+
+// Options tune the standard checks
+type Options struct {
+	// MigrationsDir holds the migration files the database must be at
+	MigrationsDir string
+	// CertWarning is how close to expiry a certificate may be before its
+	// check warns
+	CertWarning time.Duration
+}
+
+// Checks returns the standard checks for cfg: the config itself, the
+// database and its migrations, statsd, TLS certificates and log paths.
+// Call cleanup when the checks have run to release the database connection.
+func Checks(cfg *config.Config, opts Options) (checks []Check, cleanup func()) {
+	var engine storage.Engine
+	cleanup = func() {
+		if engine != nil {
+			engine.Close()
+		}
+	}
+
+	checks = []Check{
+		{Name: "config", Run: func(context.Context) (string, error) {
+			if err := cfg.Validate(); err != nil {
+				return "", err
+			}
+			return "configuration is valid", nil
+		}},
+		{Name: "database", Run: func(ctx context.Context) (string, error) {
+			if cfg.Database == nil {
+				return "", Skipped("no database section")
+			}
+			stats, _ := metrics.NewAgent(&config.MetricsConfig{}, zap.NewNop())
+			e, err := storage.OpenEngine(cfg.Database, zap.NewNop(), stats)
+			if err != nil {
+				return "", err
+			}
+			if err := e.Ping(ctx); err != nil {
+				e.Close()
+				return "", err
+			}
+			engine = e
+			return fmt.Sprintf("connected to %s on %s", cfg.Database.Name, databaseHost(cfg.Database)), nil
+		}},
+		{Name: "migrations", Run: func(ctx context.Context) (string, error) {
+			if engine == nil {
+				return "", Skipped("database unreachable")
+			}
+			pending, err := migrations.NewMigrator(engine, zap.NewNop(), opts.MigrationsDir).Pending(ctx)
+			if err != nil {
+				return "", err
+			}
+			if len(pending) > 0 {
+				return "", fmt.Errorf("%d pending, from %d_%s", len(pending), pending[0].Version, pending[0].Name)
+			}
+			return "schema is current", nil
+		}},
+		{Name: "metrics", Run: func(ctx context.Context) (string, error) {
+			return checkStatsd(ctx, cfg.Metrics)
+		}},
+		{Name: "tls", Run: func(context.Context) (string, error) {
+			return checkCertificate(cfg.Server, opts.CertWarning)
+		}},
+		{Name: "logs", Run: func(context.Context) (string, error) {
+			if cfg.Logger == nil || cfg.Logger.Output != "file" {
+				return "", Skipped("not logging to a file")
+			}
+			if err := writableFile(cfg.Logger.File); err != nil {
+				return "", err
+			}
+			return cfg.Logger.File + " is writable", nil
+		}},
+	}
+	return checks, cleanup
+}
+
+func databaseHost(cfg *config.DatabaseConfig) string {
+	if cfg.Failover != nil && cfg.Failover.Enabled {
+		return fmt.Sprintf("one of %v", cfg.Failover.Hosts)
+	}
+	return fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+}
+
+// checkStatsd sends a counter to statsd. UDP doesn't acknowledge, but a
+// host with nothing listening answers with an ICMP error that a connected
+// socket reports on the next read.
+func checkStatsd(ctx context.Context, cfg *config.MetricsConfig) (string, error) {
+	if cfg == nil || !cfg.Enabled {
+		return "", Skipped("metrics disabled")
+	}
+	if cfg.Type == "mock" {
+		return "", Skipped("mock metrics client")
+	}
+	addr := cfg.Address
+	if addr == "" {
+		addr = defaultStatsdAddress
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	bucket := "doctor.check"
+	if cfg.Prefix != "" {
+		bucket = cfg.Prefix + "." + bucket
+	}
+	if _, err := conn.Write([]byte(bucket + ":1|c")); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); errors.Is(err, syscall.ECONNREFUSED) {
+		return "", fmt.Errorf("nothing is listening on %s", addr)
+	}
+	return "sent a counter to " + conn.RemoteAddr().String(), nil
+}
+
+// checkCertificate checks the server certificate is valid now and for at
+// least warning longer
+func checkCertificate(cfg *config.ServerConfig, warning time.Duration) (string, error) {
+	if cfg == nil || cfg.TLS == nil || !cfg.TLS.Enabled {
+		return "", Skipped("TLS disabled")
+	}
+	leaf, err := cfg.TLS.Leaf()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		return "", fmt.Errorf("certificate for %s is not valid until %s", leaf.Subject.CommonName, leaf.NotBefore.Format(time.RFC3339))
+	case now.After(leaf.NotAfter):
+		return "", fmt.Errorf("certificate for %s expired on %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+	remaining := leaf.NotAfter.Sub(now)
+	detail := fmt.Sprintf("certificate for %s expires in %d days", leaf.Subject.CommonName, int(remaining.Hours()/24))
+	if remaining < warning {
+		return "", Warning("%s", detail)
+	}
+	return detail, nil
+}
+
+// writableFile checks path can be appended to, or created along with any
+// missing directories, as the log rotator does
+func writableFile(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0); err == nil {
+		return f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,102 @@
+// Package doctor runs preflight checks against a deployment's config and
+// dependencies and prints a pass/fail report, so a bad rollout fails
+// before it takes traffic.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrFailed is returned by Run when any check fails
+var ErrFailed = errors.New("doctor: checks failed")
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass Status = "PASS"
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+	Skip Status = "SKIP"
+)
+
+// Check is one preflight check. Run returns a detail line on success, or
+// an error: a failure, or a Warning or Skipped outcome.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Result is a check's outcome
+type Result struct {
+	Name     string
+	Status   Status
+	Detail   string
+	Duration time.Duration
+}
+
+type outcome struct {
+	status Status
+	detail string
+}
+
+func (o *outcome) Error() string { return o.detail }
+
+// Warning reports a check that passed with a problem worth fixing, such as
+// a certificate close to expiry. It doesn't fail the run.
+func Warning(format string, args ...interface{}) error {
+	return &outcome{status: Warn, detail: fmt.Sprintf(format, args...)}
+}
+
+// Skipped reports a check that couldn't run, e.g. because a check it
+// depends on failed or the feature is disabled
+func Skipped(format string, args ...interface{}) error {
+	return &outcome{status: Skip, detail: fmt.Sprintf(format, args...)}
+}
+
+// Run runs checks in order, each bounded by timeout, and writes a report
+// to w. It returns the results, and ErrFailed when any check failed.
+func Run(ctx context.Context, checks []Check, timeout time.Duration, w io.Writer) ([]Result, error) {
+	results := make([]Result, 0, len(checks))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	counts := map[Status]int{}
+	for _, check := range checks {
+		r := run(ctx, check, timeout)
+		results = append(results, r)
+		counts[r.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Status, r.Name, r.Detail, r.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d checks: %d passed, %d warnings, %d failed, %d skipped\n",
+		len(results), counts[Pass], counts[Warn], counts[Fail], counts[Skip])
+	if counts[Fail] > 0 {
+		return results, ErrFailed
+	}
+	return results, nil
+}
+
+func run(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	r := Result{Name: check.Name, Status: Pass, Detail: detail, Duration: time.Since(start)}
+	var o *outcome
+	switch {
+	case errors.As(err, &o):
+		r.Status, r.Detail = o.status, o.detail
+	case err != nil:
+		r.Status, r.Detail = Fail, err.Error()
+	}
+	// Joined errors, such as config validation's, stay on one row
+	r.Detail = strings.ReplaceAll(r.Detail, "\n", "; ")
+	return r
+}
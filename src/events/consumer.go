@@ -0,0 +1,272 @@
+package events
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnknownConsumer is returned for a consumer name nothing registered
+var ErrUnknownConsumer = errors.New("events: unknown consumer")
+
+// Handler handles one event. An error stops its consumer at that event,
+// which is retried on the next poll. Consumers see an event at least
+// once, so handlers must be idempotent.
+type Handler func(ctx context.Context, event Event) error
+
+type consumer struct {
+	name    string
+	types   []string
+	handler Handler
+}
+
+// ConsumerStatus is a consumer's place in the log
+type ConsumerStatus struct {
+	Name      string
+	Position  int64 // last event handled
+	Lag       int64 // events appended since
+	LastError string
+	UpdatedAt time.Time
+}
+
+// Register adds a consumer reading events of types, or every event when
+// types is empty. Modules register consumers while they are built; each
+// starts after the last event its name has handled, or at the beginning
+// of the log. One instance at a time runs a consumer.
+//
+//	store.Register("post_stats", []string{"post.published"}, stats.Apply)
+func (s *Store) Register(name string, types []string, handler Handler) {
+	s.consumers = append(s.consumers, &consumer{name: name, types: types, handler: handler})
+}
+
+// Start runs the consumers every events.poll_interval
+func (s *Store) Start() {
+	if len(s.consumers) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.config.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, c := range s.consumers {
+					if _, err := s.consume(ctx, c); err != nil && ctx.Err() == nil {
+						s.logger.Error("event consumer failed", zap.String("consumer", c.name), zap.Error(err))
+					}
+				}
+			}
+		}
+	}()
+	s.logger.Info("event consumers started", zap.Int("consumers", len(s.consumers)))
+}
+
+// Close stops the consumers after their in-flight batches
+func (s *Store) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Consume runs the named consumer until it has caught up with the log and
+// returns how many events it handled
+func (s *Store) Consume(ctx context.Context, name string) (int, error) {
+	c, err := s.consumer(name)
+	if err != nil {
+		return 0, err
+	}
+	return s.consume(ctx, c)
+}
+
+// Replay hands the events matching filter, among those the named consumer
+// reads, to its handler in log order. The consumer's position is left
+// alone: replay rebuilds or repairs what the handler maintains, relying on
+// it being idempotent. It stops at the first error.
+func (s *Store) Replay(ctx context.Context, name string, filter Filter) (int, error) {
+	c, err := s.consumer(name)
+	if err != nil {
+		return 0, err
+	}
+	filter.Types = intersect(c.types, filter.Types)
+	if filter.Types != nil && len(filter.Types) == 0 {
+		return 0, nil
+	}
+	return s.Each(ctx, filter, func(event Event) error {
+		if err := c.handler(ctx, event); err != nil {
+			return fmt.Errorf("consumer %s failed at event %d: %w", name, event.Position, err)
+		}
+		s.stats.Increment("events.consumer." + name + ".replayed")
+		return nil
+	})
+}
+
+// PositionAt returns the position of the first event that occurred at or
+// after t, or the position the next event will get if none has
+func (s *Store) PositionAt(ctx context.Context, t time.Time) (int64, error) {
+	var position int64
+	err := s.engine.QueryRow(ctx,
+		`SELECT COALESCE(MIN(id), (SELECT COALESCE(MAX(id), 0) + 1 FROM domain_events))
+		 FROM domain_events WHERE occurred_at >= $1`, t).Scan(&position)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find position: %w", storage.Classify(err))
+	}
+	return position, nil
+}
+
+// Consumers returns the positions of the consumers that have run, by name
+func (s *Store) Consumers(ctx context.Context) ([]ConsumerStatus, error) {
+	rows, err := s.engine.Query(ctx,
+		`SELECT name, position, GREATEST((SELECT COALESCE(MAX(id), 0) FROM domain_events) - position, 0),
+		        COALESCE(last_error, ''), updated_at
+		 FROM event_consumers ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event consumers: %w", err)
+	}
+	defer rows.Close()
+
+	var statuses []ConsumerStatus
+	for rows.Next() {
+		var st ConsumerStatus
+		if err := rows.Scan(&st.Name, &st.Position, &st.Lag, &st.LastError, &st.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event consumer: %w", err)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, rows.Err()
+}
+
+func (s *Store) consumer(name string) (*consumer, error) {
+	for _, c := range s.consumers {
+		if c.name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownConsumer, name)
+}
+
+// consume runs batches until one comes back short
+func (s *Store) consume(ctx context.Context, c *consumer) (int, error) {
+	total := 0
+	for {
+		n, full, err := s.consumeBatch(ctx, c)
+		total += n
+		if err != nil || !full {
+			return total, err
+		}
+	}
+}
+
+// consumeBatch handles up to events.batch_size events after the
+// consumer's position in one transaction, whose row lock keeps other
+// instances from running the same consumer. It reports whether the batch
+// was full, i.e. there may be more.
+func (s *Store) consumeBatch(ctx context.Context, c *consumer) (int, bool, error) {
+	tx, err := s.engine.Begin(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				s.logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO event_consumers (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, c.name); err != nil {
+		return 0, false, fmt.Errorf("failed to register consumer: %w", err)
+	}
+	rows, err := tx.Query(ctx,
+		`SELECT position FROM event_consumers WHERE name = $1 FOR UPDATE SKIP LOCKED`, c.name)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to lock consumer: %w", err)
+	}
+	var position int64
+	locked := rows.Next()
+	if locked {
+		err = rows.Scan(&position)
+	}
+	rows.Close()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to lock consumer: %w", err)
+	}
+	if !locked {
+		// Another instance is running it
+		return 0, false, nil
+	}
+
+	batch, err := read(ctx, tx, Filter{From: position + 1, Types: c.types}, s.config.BatchSize)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(batch) == 0 {
+		return 0, false, nil
+	}
+
+	handled := 0
+	var handlerErr error
+	for _, event := range batch {
+		if handlerErr = c.handler(ctx, event); handlerErr != nil {
+			s.logger.Warn("event consumer failed, will retry",
+				zap.String("consumer", c.name),
+				zap.Int64("position", event.Position),
+				zap.String("event_type", event.EventType),
+				zap.Error(handlerErr))
+			s.stats.Increment("events.consumer." + c.name + ".error")
+			break
+		}
+		position = event.Position
+		handled++
+	}
+
+	var lastError interface{}
+	if handlerErr != nil {
+		lastError = handlerErr.Error()
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE event_consumers SET position = $2, last_error = $3, updated_at = NOW() WHERE name = $1`,
+		c.name, position, lastError); err != nil {
+		return handled, false, fmt.Errorf("failed to store consumer position: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return handled, false, fmt.Errorf("failed to commit consumer position: %w", err)
+	}
+	committed = true
+	s.stats.Count("events.consumer."+c.name+".handled", handled)
+	return handled, handlerErr == nil && len(batch) == s.config.BatchSize, nil
+}
+
+// intersect narrows a consumer's types by a replay's. nil means every
+// type; an empty result means none.
+func intersect(types, only []string) []string {
+	if len(only) == 0 {
+		return types
+	}
+	if len(types) == 0 {
+		return only
+	}
+	out := []string{}
+	for _, t := range only {
+		for _, u := range types {
+			if t == u {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
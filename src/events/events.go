@@ -0,0 +1,174 @@
+// Package events keeps an append-only log of domain events in the
+// domain_events table. Unlike the outbox, which forgets events once they
+// are published, the log is kept, so read models can be rebuilt by
+// replaying it and event-driven flows can be debugged after the fact.
+// Consumers read the log in order and track their position in
+// event_consumers.
+package events
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/outbox"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Event is a domain event read from the log
+type Event struct {
+	// Position orders the log; consumers resume after the last one they
+	// handled
+	Position      int64             `json:"position"`
+	AggregateType string            `json:"aggregate_type"`
+	AggregateID   string            `json:"aggregate_id"`
+	EventType     string            `json:"event_type"`
+	Payload       json.RawMessage   `json:"payload"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	OccurredAt    time.Time         `json:"occurred_at"`
+}
+
+// Filter selects events from the log. The zero Filter selects every event.
+type Filter struct {
+	// From is the first position to read
+	From int64
+	// Since skips events that occurred before it
+	Since time.Time
+	// Types limits the events to these event types
+	Types []string
+}
+
+// Store appends to the log and runs its consumers
+type Store struct {
+	config *config.EventsConfig
+	engine storage.Engine
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	consumers []*consumer
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewStore creates a store over the domain_events table
+func NewStore(cfg *config.EventsConfig, engine storage.Engine, logger *zap.Logger, stats metrics.Agent) *Store {
+	return &Store{
+		config: cfg,
+		engine: engine,
+		logger: logger.With(zap.String("component", "events")),
+		stats:  stats,
+	}
+}
+
+// Append records events in the log within the caller's transaction, so
+// they are kept only if the business change they describe is. With
+// events.publish they are also written to the outbox for the relay to
+// publish.
+func (s *Store) Append(ctx context.Context, tx outbox.Execer, events ...outbox.Event) error {
+	for _, event := range events {
+		if event.AggregateType == "" || event.AggregateID == "" || event.EventType == "" {
+			return fmt.Errorf("domain event requires aggregate type, aggregate id and event type")
+		}
+		payload, err := json.Marshal(event.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload for %s: %w", event.EventType, err)
+		}
+		headers := event.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headerJSON, err := json.Marshal(headers)
+		if err != nil {
+			return fmt.Errorf("failed to marshal headers for %s: %w", event.EventType, err)
+		}
+
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO domain_events (aggregate_type, aggregate_id, event_type, payload, headers)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			event.AggregateType, event.AggregateID, event.EventType, payload, headerJSON); err != nil {
+			return fmt.Errorf("failed to append domain event %s: %w", event.EventType, err)
+		}
+		s.stats.Increment("events.log.appended")
+	}
+	if s.config.Publish {
+		return outbox.Write(ctx, tx, events...)
+	}
+	return nil
+}
+
+// Querier is satisfied by storage.Engine and *storage.InstrumentedTx
+type Querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Read returns up to limit events matching filter, in log order
+func (s *Store) Read(ctx context.Context, filter Filter, limit int) ([]Event, error) {
+	return read(ctx, s.engine, filter, limit)
+}
+
+func read(ctx context.Context, q Querier, filter Filter, limit int) ([]Event, error) {
+	var types interface{}
+	if len(filter.Types) > 0 {
+		types = pq.Array(filter.Types)
+	}
+	var since interface{}
+	if !filter.Since.IsZero() {
+		since = filter.Since
+	}
+	rows, err := q.Query(ctx,
+		`SELECT id, aggregate_type, aggregate_id, event_type, payload, headers, occurred_at
+		 FROM domain_events
+		 WHERE id >= $1
+		   AND ($2::text[] IS NULL OR event_type = ANY($2))
+		   AND ($3::timestamptz IS NULL OR occurred_at >= $3)
+		 ORDER BY id
+		 LIMIT $4`,
+		filter.From, types, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domain events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var headers []byte
+		if err := rows.Scan(&e.Position, &e.AggregateType, &e.AggregateID, &e.EventType, &e.Payload, &headers, &e.OccurredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan domain event: %w", err)
+		}
+		if err := json.Unmarshal(headers, &e.Headers); err != nil {
+			return nil, fmt.Errorf("failed to decode headers of domain event %d: %w", e.Position, err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Each calls fn with every event matching filter, in log order, reading
+// events.batch_size at a time. It stops at the first error.
+func (s *Store) Each(ctx context.Context, filter Filter, fn func(Event) error) (int, error) {
+	n := 0
+	for {
+		batch, err := s.Read(ctx, filter, s.config.BatchSize)
+		if err != nil {
+			return n, err
+		}
+		for _, event := range batch {
+			if err := fn(event); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(batch) < s.config.BatchSize {
+			return n, nil
+		}
+		filter.From = batch[len(batch)-1].Position + 1
+	}
+}
@@ -0,0 +1,161 @@
+package featureflags
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Client evaluates flags from an in-memory snapshot that is refreshed from
+// the provider in the background, so evaluation never touches the network
+type Client struct {
+	provider Provider
+	interval time.Duration
+	logger   *zap.Logger
+	stats    metrics.Agent
+	mu       sync.RWMutex
+	flags    map[string]Flag
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewClient creates a flag client and loads the initial snapshot
+func NewClient(provider Provider, interval time.Duration, logger *zap.Logger, stats metrics.Agent) (*Client, error) {
+	c := &Client{
+		provider: provider,
+		interval: interval,
+		logger:   logger.With(zap.String("component", "featureflags")),
+		stats:    stats,
+		flags:    make(map[string]Flag),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Enabled evaluates key for subject. Unknown flags are off.
+func (c *Client) Enabled(key string, subject Subject) bool {
+	c.mu.RLock()
+	flag, ok := c.flags[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		c.stats.Increment("featureflags.unknown")
+		return false
+	}
+
+	on := flag.Evaluate(subject)
+	if on {
+		c.stats.Increment(fmt.Sprintf("featureflags.%s.on", key))
+	} else {
+		c.stats.Increment(fmt.Sprintf("featureflags.%s.off", key))
+	}
+	return on
+}
+
+// Flags returns the current snapshot
+func (c *Client) Flags() []Flag {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	flags := make([]Flag, 0, len(c.flags))
+	for _, flag := range c.flags {
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// Refresh reloads the snapshot from the provider
+func (c *Client) Refresh(ctx context.Context) error {
+	flags, err := c.provider.Flags(ctx)
+	if err != nil {
+		c.stats.Increment("featureflags.refresh.error")
+		return fmt.Errorf("failed to load feature flags: %w", err)
+	}
+
+	snapshot := make(map[string]Flag, len(flags))
+	for _, flag := range flags {
+		snapshot[flag.Key] = flag
+	}
+
+	c.mu.Lock()
+	c.flags = snapshot
+	c.mu.Unlock()
+	return nil
+}
+
+// Set saves a flag through the provider and applies it to this instance
+// immediately; other instances pick it up on their next refresh
+func (c *Client) Set(ctx context.Context, flag Flag) (Flag, error) {
+	store, ok := c.provider.(Store)
+	if !ok {
+		return flag, ErrReadOnly
+	}
+	if err := validate(flag); err != nil {
+		return flag, err
+	}
+
+	flag.UpdatedAt = time.Now()
+	if err := store.Save(ctx, flag); err != nil {
+		return flag, fmt.Errorf("failed to save feature flag %s: %w", flag.Key, err)
+	}
+
+	c.mu.Lock()
+	c.flags[flag.Key] = flag
+	c.mu.Unlock()
+
+	c.logger.Info("feature flag updated",
+		zap.String("flag", flag.Key),
+		zap.Bool("enabled", flag.Enabled),
+		zap.Int("rollout_percent", flag.RolloutPercent),
+		zap.String("updated_by", flag.UpdatedBy))
+	return flag, nil
+}
+
+// ErrReadOnly is returned when changing flags backed by a read-only provider
+var ErrReadOnly = errors.New("featureflags: provider is read-only")
+
+// Start refreshes the snapshot periodically
+func (c *Client) Start() error {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.Refresh(c.ctx); err != nil && c.ctx.Err() == nil {
+					// Keep serving the last good snapshot
+					c.logger.Error("feature flag refresh failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the background refresh
+func (c *Client) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+	return nil
+}
@@ -0,0 +1,223 @@
+package featureflags
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Variant is one arm of an experiment. Subjects are assigned to variants
+// in proportion to their weights.
+type Variant struct {
+	Name   string `json:"name" yaml:"name"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// Assignment is the variant a subject got in an experiment
+type Assignment struct {
+	Flag    string `json:"flag"`
+	Variant string `json:"variant"`
+}
+
+// Variant returns the variant of the experiment subject is assigned to, or
+// "" when the flag is off for subject or has no variants. Assignment is a
+// hash of the flag key and user (or tenant, for anonymous subjects), so it
+// is stable across requests and instances, and independent of the hash
+// that decides the rollout.
+func (f *Flag) Variant(subject Subject) string {
+	if len(f.Variants) == 0 || !f.Evaluate(subject) {
+		return ""
+	}
+
+	id := subject.UserID
+	if id == "" {
+		id = subject.TenantID
+	}
+	if id == "" {
+		// Explicit targeting and full rollouts turn flags on for anonymous
+		// subjects, but they can't be assigned consistently
+		return ""
+	}
+
+	total := 0
+	for _, v := range f.Variants {
+		total += v.Weight
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.Key))
+	h.Write([]byte(":variant:"))
+	h.Write([]byte(id))
+	n := int(h.Sum32() % uint32(total))
+	for _, v := range f.Variants {
+		if n < v.Weight {
+			return v.Name
+		}
+		n -= v.Weight
+	}
+	return ""
+}
+
+// Variant returns subject's variant of the experiment key, or "" when it
+// is unknown, off for subject or not an experiment
+func (c *Client) Variant(key string, subject Subject) string {
+	c.mu.RLock()
+	flag, ok := c.flags[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		c.stats.Increment("featureflags.unknown")
+		return ""
+	}
+	return flag.Variant(subject)
+}
+
+// Assignments returns subject's variant of every experiment it is in,
+// sorted by flag key
+func (c *Client) Assignments(subject Subject) []Assignment {
+	c.mu.RLock()
+	var assignments []Assignment
+	for key, flag := range c.flags {
+		if variant := flag.Variant(subject); variant != "" {
+			assignments = append(assignments, Assignment{Flag: key, Variant: variant})
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].Flag < assignments[j].Flag
+	})
+	return assignments
+}
+
+// VariantOf returns the subject in ctx's variant of the experiment key. It
+// returns "" if ctx carries no flag client, or the subject isn't in the
+// experiment.
+func VariantOf(ctx context.Context, key string) string {
+	e, ok := ctx.Value(contextKey{}).(evaluation)
+	if !ok {
+		return ""
+	}
+	if e.assigned {
+		for _, a := range e.assignments {
+			if a.Flag == key {
+				return a.Variant
+			}
+		}
+		return ""
+	}
+	return e.client.Variant(key, e.subject)
+}
+
+// Assignments returns the experiments the subject in ctx is in. The
+// middleware assigns them once per request, so a flag changing mid-request
+// doesn't move the subject.
+func Assignments(ctx context.Context) []Assignment {
+	e, ok := ctx.Value(contextKey{}).(evaluation)
+	if !ok {
+		return nil
+	}
+	if e.assigned {
+		return e.assignments
+	}
+	return e.client.Assignments(e.subject)
+}
+
+// Bucket returns the metric prefix for an assignment, e.g.
+// "experiment.new-checkout.treatment". Experiment keys and variant names
+// are validated to be metric-safe.
+func (a Assignment) Bucket() string {
+	return "experiment." + a.Flag + "." + a.Variant
+}
+
+// FormatAssignments renders assignments for a response header:
+// "new-checkout=treatment, pricing=control"
+func FormatAssignments(assignments []Assignment) string {
+	parts := make([]string, len(assignments))
+	for i, a := range assignments {
+		parts[i] = a.Flag + "=" + a.Variant
+	}
+	return strings.Join(parts, ", ")
+}
+
+// experimentAgent sends every metric once per assignment
+type experimentAgent struct {
+	metrics.Agent
+	prefixes []string
+}
+
+// Stats returns an agent that tags every bucket with the experiments the
+// subject in ctx is in, sending it once per experiment: "orders.created"
+// becomes "experiment.new-checkout.treatment.orders.created". Outside any
+// experiment it returns stats unchanged.
+func Stats(ctx context.Context, stats metrics.Agent) metrics.Agent {
+	assignments := Assignments(ctx)
+	if len(assignments) == 0 {
+		return stats
+	}
+	prefixes := make([]string, len(assignments))
+	for i, a := range assignments {
+		prefixes[i] = a.Bucket() + "."
+	}
+	return &experimentAgent{Agent: stats, prefixes: prefixes}
+}
+
+func (a *experimentAgent) Increment(bucket string) {
+	for _, prefix := range a.prefixes {
+		a.Agent.Increment(prefix + bucket)
+	}
+}
+
+func (a *experimentAgent) Count(bucket string, n interface{}) {
+	for _, prefix := range a.prefixes {
+		a.Agent.Count(prefix+bucket, n)
+	}
+}
+
+func (a *experimentAgent) Timing(bucket string, value interface{}) {
+	for _, prefix := range a.prefixes {
+		a.Agent.Timing(prefix+bucket, value)
+	}
+}
+
+func (a *experimentAgent) Gauge(bucket string, value interface{}) {
+	for _, prefix := range a.prefixes {
+		a.Agent.Gauge(prefix+bucket, value)
+	}
+}
+
+// experimentName keeps experiment keys and variant names safe for metric
+// buckets and headers
+var experimentName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateVariants checks an experiment's key and variants
+func validateVariants(flag Flag) error {
+	if len(flag.Variants) == 0 {
+		return nil
+	}
+	if !experimentName.MatchString(flag.Key) {
+		return errInvalid(fmt.Sprintf("experiment key %q must be lowercase letters, digits, '-' and '_'", flag.Key))
+	}
+	total := 0
+	seen := make(map[string]bool, len(flag.Variants))
+	for _, v := range flag.Variants {
+		if !experimentName.MatchString(v.Name) {
+			return errInvalid(fmt.Sprintf("variant name %q must be lowercase letters, digits, '-' and '_'", v.Name))
+		}
+		if seen[v.Name] {
+			return errInvalid(fmt.Sprintf("variant %q is listed twice", v.Name))
+		}
+		seen[v.Name] = true
+		if v.Weight < 0 {
+			return errInvalid(fmt.Sprintf("variant %q has a negative weight", v.Name))
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return errInvalid("variants need a positive total weight")
+	}
+	return nil
+}
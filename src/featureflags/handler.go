@@ -0,0 +1,168 @@
+package featureflags
+
+import (
+	"coffee-and-running/src/tenancy"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// Headers read by DefaultSubjectFunc
+const (
+	UserHeader   = "X-User-ID"
+	TenantHeader = "X-Tenant-ID"
+)
+
+// SubjectFunc resolves the flag subject from a request
+type SubjectFunc func(r *http.Request) Subject
+
+// DefaultSubjectFunc reads the subject from the X-User-ID and X-Tenant-ID
+// headers, preferring the tenant resolved by the tenancy middleware.
+// Replace it with one backed by your auth middleware.
+func DefaultSubjectFunc(r *http.Request) Subject {
+	tenant, ok := tenancy.FromContext(r.Context())
+	if !ok {
+		tenant = r.Header.Get(TenantHeader)
+	}
+	return Subject{
+		UserID:   r.Header.Get(UserHeader),
+		TenantID: tenant,
+	}
+}
+
+// ExperimentOptions controls how the middleware reports experiment
+// assignments
+type ExperimentOptions struct {
+	// Header, when set, lists the request's assignments on the response,
+	// e.g. "X-Experiments: new-checkout=treatment"
+	Header string
+	// Metrics emits experiment.<key>.<variant>.http.<status>.requests and
+	// .duration for every assignment
+	Metrics bool
+}
+
+// Middleware stores the client, request subject and its experiment
+// assignments in the request context so handlers can call
+// featureflags.Enabled(ctx, key) and featureflags.VariantOf(ctx, key).
+// subject may be nil to use DefaultSubjectFunc.
+func Middleware(client *Client, subject SubjectFunc, experiments ExperimentOptions) func(http.Handler) http.Handler {
+	if subject == nil {
+		subject = DefaultSubjectFunc
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subj := subject(r)
+			assignments := client.Assignments(subj)
+			ctx := context.WithValue(r.Context(), contextKey{}, evaluation{
+				client:      client,
+				subject:     subj,
+				assignments: assignments,
+				assigned:    true,
+			})
+			r = r.WithContext(ctx)
+
+			if len(assignments) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if experiments.Header != "" {
+				w.Header().Set(experiments.Header, FormatAssignments(assignments))
+			}
+			if !experiments.Metrics {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			defer func() {
+				for _, a := range assignments {
+					bucket := fmt.Sprintf("%s.http.%dxx", a.Bucket(), ww.Status()/100)
+					client.stats.Increment(bucket + ".requests")
+					client.stats.Timing(bucket+".duration", time.Since(start))
+				}
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// Handler exposes the flag admin API
+type Handler struct {
+	client  *Client
+	subject SubjectFunc
+	logger  *zap.Logger
+}
+
+// NewHandler creates the admin handler. subject identifies who made a
+// change and may be nil to use DefaultSubjectFunc.
+func NewHandler(client *Client, subject SubjectFunc, logger *zap.Logger) *Handler {
+	if subject == nil {
+		subject = DefaultSubjectFunc
+	}
+	return &Handler{
+		client:  client,
+		subject: subject,
+		logger:  logger.With(zap.String("component", "featureflags.handler")),
+	}
+}
+
+// Mount registers GET /admin/flags and PUT /admin/flags/{key}
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/flags", h.listFlags)
+	r.Put("/admin/flags/{key}", h.putFlag)
+}
+
+func (h *Handler) listFlags(w http.ResponseWriter, r *http.Request) {
+	flags := h.client.Flags()
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Key < flags[j].Key
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flags": flags})
+}
+
+func (h *Handler) putFlag(w http.ResponseWriter, r *http.Request) {
+	var flag Flag
+	if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON flag")
+		return
+	}
+	flag.Key = chi.URLParam(r, "key")
+	flag.UpdatedBy = h.subject(r).UserID
+
+	flag, err := h.client.Set(r.Context(), flag)
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrReadOnly):
+		writeError(w, http.StatusConflict, "flags are read-only with the configured provider")
+		return
+	case err != nil:
+		h.logger.Error("failed to update feature flag", zap.String("flag", flag.Key), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to update flag")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flag": flag})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
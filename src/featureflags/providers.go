@@ -0,0 +1,137 @@
+package featureflags
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+// FileProvider reads flags from a YAML file:
+//
+//	flags:
+//	  - key: new-checkout
+//	    enabled: true
+//	    rollout_percent: 10
+//	    tenants: ["acme"]
+//	  - key: checkout-button
+//	    enabled: true
+//	    rollout_percent: 100
+//	    variants:
+//	      - {name: control, weight: 50}
+//	      - {name: green, weight: 50}
+//
+// The file is re-read on every refresh, so edits apply without a restart.
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a read-only YAML provider
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+// Flags reads and validates the file
+func (p *FileProvider) Flags(ctx context.Context) ([]Flag, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Flags []Flag `yaml:"flags"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p.path, err)
+	}
+	for _, flag := range file.Flags {
+		if err := validate(flag); err != nil {
+			return nil, fmt.Errorf("invalid flag in %s: %w", p.path, err)
+		}
+	}
+	return file.Flags, nil
+}
+
+// DatabaseProvider stores flags in the feature_flags table and supports
+// runtime changes
+type DatabaseProvider struct {
+	engine storage.Engine
+}
+
+var _ Store = (*DatabaseProvider)(nil)
+
+// NewDatabaseProvider creates a provider backed by the feature_flags table
+func NewDatabaseProvider(engine storage.Engine) *DatabaseProvider {
+	return &DatabaseProvider{engine: engine}
+}
+
+// Flags loads every flag
+func (p *DatabaseProvider) Flags(ctx context.Context) ([]Flag, error) {
+	rows, err := p.engine.Query(ctx,
+		`SELECT key, description, enabled, rollout_percent, users, tenants, variants, updated_by, updated_at
+		 FROM feature_flags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []Flag
+	for rows.Next() {
+		var flag Flag
+		var variants []byte
+		var updatedBy sql.NullString
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&flag.Key, &flag.Description, &flag.Enabled, &flag.RolloutPercent,
+			pq.Array(&flag.Users), pq.Array(&flag.Tenants), &variants, &updatedBy, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(variants, &flag.Variants); err != nil {
+			return nil, fmt.Errorf("invalid variants for flag %s: %w", flag.Key, err)
+		}
+		flag.UpdatedBy = updatedBy.String
+		flag.UpdatedAt = updatedAt.Time
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// Save creates or replaces a flag
+func (p *DatabaseProvider) Save(ctx context.Context, flag Flag) error {
+	variants := flag.Variants
+	if variants == nil {
+		variants = []Variant{}
+	}
+	encoded, err := json.Marshal(variants)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.engine.Exec(ctx,
+		`INSERT INTO feature_flags (key, description, enabled, rollout_percent, users, tenants, variants, updated_by, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9)
+		 ON CONFLICT (key) DO UPDATE SET
+			description = EXCLUDED.description,
+			enabled = EXCLUDED.enabled,
+			rollout_percent = EXCLUDED.rollout_percent,
+			users = EXCLUDED.users,
+			tenants = EXCLUDED.tenants,
+			variants = EXCLUDED.variants,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at`,
+		flag.Key, flag.Description, flag.Enabled, flag.RolloutPercent,
+		pq.Array(nonNil(flag.Users)), pq.Array(nonNil(flag.Tenants)), encoded, flag.UpdatedBy, flag.UpdatedAt)
+	return err
+}
+
+// nonNil avoids writing NULL into NOT NULL array columns
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
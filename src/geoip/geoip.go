@@ -0,0 +1,117 @@
+// Package geoip resolves the location of a request's client address from a
+// MaxMind database (GeoLite2 or GeoIP2 Country or City) and blocks routes
+// by country or region where compliance requires it.
+package geoip
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Location is where an address is registered. Codes are ISO 3166: Country
+// is alpha-2, e.g. "US", and Region is the subdivision without the country
+// prefix, e.g. "CA". Either may be empty: Country databases have no
+// regions, and anycast or private addresses have no country.
+type Location struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+}
+
+// Code returns "US-CA", or "US" without a region, or "" when the location
+// is unknown
+func (l Location) Code() string {
+	if l.Country == "" || l.Region == "" {
+		return l.Country
+	}
+	return l.Country + "-" + l.Region
+}
+
+// Matches reports whether l is inside code, a country ("US") or region
+// ("US-CA")
+func (l Location) Matches(code string) bool {
+	if l.Country == "" {
+		return false
+	}
+	country, region, ok := strings.Cut(strings.ToUpper(code), "-")
+	if country != l.Country {
+		return false
+	}
+	return !ok || region == l.Region
+}
+
+// Database looks up locations in a MaxMind DB file
+type Database struct {
+	db *mmdb
+}
+
+// Open loads the database at path into memory
+func Open(path string) (*Database, error) {
+	db, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{db: db}, nil
+}
+
+// Metadata describes the loaded database
+func (d *Database) Metadata() Metadata {
+	return d.db.meta
+}
+
+// Lookup returns the location of addr. ok is false when the database has
+// no country for it, e.g. for private addresses.
+func (d *Database) Lookup(addr netip.Addr) (loc Location, ok bool, err error) {
+	record, err := d.db.lookup(addr)
+	if err != nil || record == nil {
+		return Location{}, false, err
+	}
+	fields, _ := record.(map[string]interface{})
+
+	// Prefer where the address is used over where it is registered
+	for _, key := range []string{"country", "registered_country"} {
+		if country, _ := fields[key].(map[string]interface{}); country != nil {
+			if loc.Country = asString(country["iso_code"]); loc.Country != "" {
+				break
+			}
+		}
+	}
+	if subdivisions, _ := fields["subdivisions"].([]interface{}); len(subdivisions) > 0 {
+		if first, _ := subdivisions[0].(map[string]interface{}); first != nil {
+			loc.Region = asString(first["iso_code"])
+		}
+	}
+	return loc, loc.Country != "", nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying loc
+func NewContext(ctx context.Context, loc Location) context.Context {
+	return context.WithValue(ctx, contextKey{}, loc)
+}
+
+// FromContext returns the location the middleware resolved for the
+// request. ok is false when it is unknown.
+func FromContext(ctx context.Context) (Location, bool) {
+	loc, ok := ctx.Value(contextKey{}).(Location)
+	return loc, ok && loc.Country != ""
+}
+
+// Fields returns the location in ctx as log fields, or none when it is
+// unknown:
+//
+//	logger.Info("order placed", geoip.Fields(ctx)...)
+func Fields(ctx context.Context) []zap.Field {
+	loc, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	fields := []zap.Field{zap.String("geo_country", loc.Country)}
+	if loc.Region != "" {
+		fields = append(fields, zap.String("geo_region", loc.Region))
+	}
+	return fields
+}
@@ -0,0 +1,48 @@
+package geoip
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+)
+
+// Bucket returns the metric prefix for loc's country, e.g. "geo.US", or
+// "geo.unknown". Regions are left out to bound the number of series.
+func Bucket(loc Location) string {
+	if loc.Country == "" {
+		return "geo.unknown"
+	}
+	return "geo." + loc.Country
+}
+
+// geoAgent prefixes every bucket with the country
+type geoAgent struct {
+	metrics.Agent
+	prefix string
+}
+
+// Stats returns an agent that tags every bucket with the country in ctx,
+// e.g. "orders.created" becomes "geo.US.orders.created". Without the geoip
+// middleware it returns stats unchanged.
+func Stats(ctx context.Context, stats metrics.Agent) metrics.Agent {
+	loc, ok := ctx.Value(contextKey{}).(Location)
+	if !ok {
+		return stats
+	}
+	return &geoAgent{Agent: stats, prefix: Bucket(loc) + "."}
+}
+
+func (a *geoAgent) Increment(bucket string) {
+	a.Agent.Increment(a.prefix + bucket)
+}
+
+func (a *geoAgent) Count(bucket string, n interface{}) {
+	a.Agent.Count(a.prefix+bucket, n)
+}
+
+func (a *geoAgent) Timing(bucket string, value interface{}) {
+	a.Agent.Timing(a.prefix+bucket, value)
+}
+
+func (a *geoAgent) Gauge(bucket string, value interface{}) {
+	a.Agent.Gauge(a.prefix+bucket, value)
+}
@@ -0,0 +1,159 @@
+package geoip
+
+import (
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// rule is a compiled GeoRuleConfig
+type rule struct {
+	pattern string
+	allow   []string
+	deny    []string
+}
+
+// blocks reports whether the rule refuses loc
+func (r *rule) blocks(loc Location) bool {
+	matches := func(code string) bool { return loc.Matches(code) }
+	if len(r.allow) > 0 {
+		return !slices.ContainsFunc(r.allow, matches)
+	}
+	return slices.ContainsFunc(r.deny, matches)
+}
+
+// Middleware resolves the location of every request's client address and
+// stores it in the request context, then applies the geo-blocking rules.
+// It reads the client address real_ip resolved, which only trusts
+// forwarding headers from server.real_ip.trusted_proxies.
+type Middleware struct {
+	db      *Database
+	logger  *zap.Logger
+	stats   metrics.Agent
+	metrics bool
+
+	// routes matches request paths against the rules' patterns, which
+	// index rules by method and pattern, with "*" for any method
+	routes *chi.Mux
+	rules  map[string]*rule
+}
+
+// NewMiddleware creates the middleware for cfg's rules
+func NewMiddleware(cfg *config.GeoIPConfig, db *Database, logger *zap.Logger, stats metrics.Agent) *Middleware {
+	m := &Middleware{
+		db:      db,
+		logger:  logger.With(zap.String("component", "geoip")),
+		stats:   stats,
+		metrics: cfg.Metrics,
+		routes:  chi.NewMux(),
+		rules:   make(map[string]*rule),
+	}
+	meta := db.Metadata()
+	m.logger.Info("loaded geoip database",
+		zap.String("type", meta.DatabaseType),
+		zap.Time("built", time.Unix(int64(meta.BuildEpoch), 0)))
+
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, rc := range cfg.Rules {
+		r := &rule{pattern: rc.Pattern, allow: upper(rc.Allow), deny: upper(rc.Deny)}
+		if len(rc.Methods) == 0 {
+			m.routes.Handle(rc.Pattern, noop)
+			m.rules["* "+rc.Pattern] = r
+			continue
+		}
+		for _, method := range rc.Methods {
+			method = strings.ToUpper(method)
+			m.routes.Method(method, rc.Pattern, noop)
+			m.rules[method+" "+rc.Pattern] = r
+		}
+	}
+	return m
+}
+
+// Handler implements the chi middleware signature
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loc := m.locate(r)
+		r = r.WithContext(NewContext(r.Context(), loc))
+
+		if rl := m.match(r); rl != nil && rl.blocks(loc) {
+			m.stats.Increment(Bucket(loc) + ".blocked")
+			m.logger.Info("blocked request by location",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("rule", rl.pattern),
+				zap.String("location", loc.Code()))
+			writeError(w, http.StatusUnavailableForLegalReasons, "this service is not available in your location")
+			return
+		}
+		if !m.metrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			bucket := fmt.Sprintf("%s.http.%dxx", Bucket(loc), ww.Status()/100)
+			m.stats.Increment(bucket + ".requests")
+			m.stats.Timing(bucket+".duration", time.Since(start))
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// locate looks up the request's client address
+func (m *Middleware) locate(r *http.Request) Location {
+	host := clientip.FromRequest(r)
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return Location{}
+	}
+	loc, _, err := m.db.Lookup(addr)
+	if err != nil {
+		m.stats.Increment("geo.lookup.error")
+		m.logger.Warn("geoip lookup failed", zap.String("ip", host), zap.Error(err))
+	}
+	return loc
+}
+
+// match returns the rule for the request's route, if any
+func (m *Middleware) match(r *http.Request) *rule {
+	if len(m.rules) == 0 {
+		return nil
+	}
+	rctx := chi.NewRouteContext()
+	if !m.routes.Match(rctx, r.Method, r.URL.Path) {
+		return nil
+	}
+	pattern := rctx.RoutePattern()
+	if rl, ok := m.rules[r.Method+" "+pattern]; ok {
+		return rl
+	}
+	return m.rules["* "+pattern]
+}
+
+func upper(codes []string) []string {
+	out := make([]string, len(codes))
+	for i, code := range codes {
+		out[i] = strings.ToUpper(code)
+	}
+	return out
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,374 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// ErrInvalidDatabase is returned for files that aren't MaxMind DBs or are
+// corrupt
+var ErrInvalidDatabase = errors.New("geoip: invalid MaxMind database")
+
+// Metadata describes a database
+type Metadata struct {
+	DatabaseType string
+	BuildEpoch   uint64
+	IPVersion    uint64
+	NodeCount    uint64
+	RecordSize   uint64
+}
+
+// mmdb reads the MaxMind DB format
+// (https://maxmind.github.io/MaxMind-DB/): a binary search tree over the
+// address bits whose leaves point into a data section of typed values
+type mmdb struct {
+	buf      []byte
+	meta     Metadata
+	tree     []byte
+	data     []byte
+	ipv4Root uint64
+}
+
+// openMMDB reads a whole database into memory
+func openMMDB(path string) (*mmdb, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMMDB(buf)
+}
+
+func parseMMDB(buf []byte) (*mmdb, error) {
+	at := bytes.LastIndex(buf, metadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("%w: no metadata", ErrInvalidDatabase)
+	}
+	metaSection := buf[at+len(metadataMarker):]
+	raw, _, err := (&decoder{buf: metaSection}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+	meta := Metadata{
+		DatabaseType: asString(fields["database_type"]),
+		BuildEpoch:   asUint(fields["build_epoch"]),
+		IPVersion:    asUint(fields["ip_version"]),
+		NodeCount:    asUint(fields["node_count"]),
+		RecordSize:   asUint(fields["record_size"]),
+	}
+	if meta.RecordSize != 24 && meta.RecordSize != 28 && meta.RecordSize != 32 {
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, meta.RecordSize)
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, meta.IPVersion)
+	}
+
+	// The tree is followed by 16 zero bytes and then the data section
+	treeSize := meta.NodeCount * meta.RecordSize / 4
+	if treeSize+16 > uint64(at) {
+		return nil, fmt.Errorf("%w: search tree is larger than the file", ErrInvalidDatabase)
+	}
+	db := &mmdb{
+		buf:  buf,
+		meta: meta,
+		tree: buf[:treeSize],
+		data: buf[treeSize+16 : at],
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 trees; find that node once
+	if meta.IPVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < meta.NodeCount; i++ {
+			if node, err = db.record(node, 0); err != nil {
+				return nil, err
+			}
+		}
+		db.ipv4Root = node
+	}
+	return db, nil
+}
+
+// lookup returns the data recorded for addr, or nil when the database has
+// none
+func (db *mmdb) lookup(addr netip.Addr) (interface{}, error) {
+	addr = addr.Unmap()
+	node, bits := uint64(0), 128
+	ip := addr.As16()
+	if addr.Is4() {
+		if db.meta.IPVersion == 6 {
+			node = db.ipv4Root
+		}
+		bits = 32
+		a4 := addr.As4()
+		copy(ip[:], a4[:])
+	} else if db.meta.IPVersion == 4 {
+		// An IPv4 database has nothing on IPv6 addresses
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < db.meta.NodeCount; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		next, err := db.record(node, bit)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	switch {
+	case node == db.meta.NodeCount:
+		return nil, nil
+	case node < db.meta.NodeCount:
+		return nil, fmt.Errorf("%w: search tree is deeper than the address", ErrInvalidDatabase)
+	}
+
+	offset := node - db.meta.NodeCount - 16
+	if offset >= uint64(len(db.data)) {
+		return nil, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+	value, _, err := (&decoder{buf: db.data}).decode(uint(offset), 0)
+	return value, err
+}
+
+// record reads the left (bit 0) or right (bit 1) record of node
+func (db *mmdb) record(node uint64, bit byte) (uint64, error) {
+	size := db.meta.RecordSize / 4
+	start := node * size
+	if start+size > uint64(len(db.tree)) {
+		return 0, fmt.Errorf("%w: node %d out of range", ErrInvalidDatabase, node)
+	}
+	b := db.tree[start : start+size]
+	switch db.meta.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5]), nil
+	case 28:
+		if bit == 0 {
+			return uint64(b[3]&0xF0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3]&0x0F)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6]), nil
+	default:
+		if bit == 0 {
+			return uint64(binary.BigEndian.Uint32(b[0:4])), nil
+		}
+		return uint64(binary.BigEndian.Uint32(b[4:8])), nil
+	}
+}
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds nesting so a corrupt file can't recurse forever
+const maxDepth = 64
+
+// decoder decodes values from a data section. Pointers are offsets into
+// the same section.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset after it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("%w: values nested too deeply", ErrInvalidDatabase)
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is %T", ErrInvalidDatabase, key)
+			}
+			value, after, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = after
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) || end < offset {
+		return nil, 0, fmt.Errorf("%w: value runs past the end of the section", ErrInvalidDatabase)
+	}
+	b := d.buf[offset:end]
+	switch typ {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of %d bytes", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of %d bytes", ErrInvalidDatabase, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w: unsigned integer of %d bytes", ErrInvalidDatabase, size)
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: int32 of %d bytes", ErrInvalidDatabase, size)
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), end, nil
+	case typeUint128:
+		return new(big.Int).SetBytes(b), end, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", ErrInvalidDatabase, typ)
+}
+
+// control reads a value's control byte and extended type and size
+func (d *decoder) control(offset uint) (typ, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, fmt.Errorf("%w: offset %d out of range", ErrInvalidDatabase, offset)
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ = uint(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, fmt.Errorf("%w: truncated extended type", ErrInvalidDatabase)
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+	if typ == typePointer {
+		// The size bits encode the pointer itself
+		return typ, uint(ctrl & 0x1F), offset, nil
+	}
+
+	size = uint(ctrl & 0x1F)
+	if size < 29 {
+		return typ, size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > uint(len(d.buf)) {
+		return 0, 0, 0, fmt.Errorf("%w: truncated size", ErrInvalidDatabase)
+	}
+	var n uint
+	for _, c := range d.buf[offset : offset+extra] {
+		n = n<<8 | uint(c)
+	}
+	switch extra {
+	case 1:
+		size = 29 + n
+	case 2:
+		size = 285 + n
+	default:
+		size = 65821 + n
+	}
+	return typ, size, offset + extra, nil
+}
+
+// pointer decodes a pointer from its size bits and following bytes
+func (d *decoder) pointer(bits, offset uint) (target, next uint, err error) {
+	n := (bits>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, fmt.Errorf("%w: truncated pointer", ErrInvalidDatabase)
+	}
+	b := d.buf[offset : offset+n]
+	var p uint
+	if n < 4 {
+		p = bits & 0x7
+	}
+	for _, c := range b {
+		p = p<<8 | uint(c)
+	}
+	switch n {
+	case 2:
+		p += 2048
+	case 3:
+		p += 526336
+	}
+	return p, offset + n, nil
+}
+
+func asString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func asUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
@@ -0,0 +1,16 @@
+// Package graph holds the resolvers for the schema in graph/*.graphqls.
+// `make gqlgen` regenerates the generated and model packages and adds
+// stubs here for new fields, keeping the implementations already written.
+package graph
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/users"
+)
+
+// Resolver is the root resolver; its fields are the services resolvers use
+type Resolver struct {
+	App *config.AppConfig
+	// Users is nil unless auth.users is enabled
+	Users *users.Service
+}
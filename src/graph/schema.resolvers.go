@@ -0,0 +1,43 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.76
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/graph/generated"
+	"coffee-and-running/src/users"
+	"context"
+	"errors"
+	"strconv"
+)
+
+// Service is the resolver for the service field.
+func (r *queryResolver) Service(ctx context.Context) (*config.AppConfig, error) {
+	return r.App, nil
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*users.User, error) {
+	sub, ok := auth.FromContext(ctx).String("sub")
+	if !ok || r.Users == nil {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(sub)
+	if err != nil {
+		return nil, nil
+	}
+
+	u, err := r.Users.Find(ctx, id)
+	if errors.Is(err, users.ErrNotFound) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// Query returns generated.QueryResolver implementation.
+func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
+
+type queryResolver struct{ *Resolver }
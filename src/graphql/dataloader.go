@@ -0,0 +1,166 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Loader.Load when the batch function produced no
+// value for the requested key
+var ErrNotFound = errors.New("graphql: key not found")
+
+// BatchFunc fetches values for many keys in one round trip, typically a
+// single `WHERE id = ANY($1)` query against the storage engine
+type BatchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader batches and caches lookups made while resolving a single request,
+// turning N resolver calls into one query. Create one per request.
+type Loader[K comparable, V any] struct {
+	fetch    BatchFunc[K, V]
+	wait     time.Duration
+	maxBatch int
+
+	mu    sync.Mutex
+	cache map[K]*result[V]
+	batch *batch[K, V]
+}
+
+type result[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+type batch[K comparable, V any] struct {
+	keys    []K
+	results map[K]*result[V]
+	full    chan struct{}
+}
+
+// NewLoader creates a loader that waits up to wait for more keys (or until
+// maxBatch keys are queued) before calling fetch
+func NewLoader[K comparable, V any](fetch BatchFunc[K, V], wait time.Duration, maxBatch int) *Loader[K, V] {
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return &Loader[K, V]{
+		fetch:    fetch,
+		wait:     wait,
+		maxBatch: maxBatch,
+		cache:    make(map[K]*result[V]),
+	}
+}
+
+// Load returns the value for key, batching it with concurrent loads
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	res, ok := l.cache[key]
+	if !ok {
+		res = l.enqueue(ctx, key)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-res.done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// LoadMany loads several keys, returning values in key order
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, []error) {
+	values := make([]V, len(keys))
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key K) {
+			defer wg.Done()
+			values[i], errs[i] = l.Load(ctx, key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
+// Prime seeds the cache, e.g. with rows already fetched by a list resolver
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.cache[key]; ok {
+		return
+	}
+	res := &result[V]{done: make(chan struct{}), value: value}
+	close(res.done)
+	l.cache[key] = res
+}
+
+// enqueue adds key to the pending batch, starting a new one if needed.
+// Callers must hold l.mu.
+func (l *Loader[K, V]) enqueue(ctx context.Context, key K) *result[V] {
+	if l.batch == nil {
+		l.batch = &batch[K, V]{
+			results: make(map[K]*result[V]),
+			full:    make(chan struct{}),
+		}
+		go l.dispatch(context.WithoutCancel(ctx), l.batch)
+	}
+
+	res := &result[V]{done: make(chan struct{})}
+	b := l.batch
+	b.keys = append(b.keys, key)
+	b.results[key] = res
+	l.cache[key] = res
+
+	if l.maxBatch > 0 && len(b.keys) >= l.maxBatch {
+		l.batch = nil
+		close(b.full)
+	}
+	return res
+}
+
+// dispatch waits for the batch window to close, then fetches all its keys
+func (l *Loader[K, V]) dispatch(ctx context.Context, b *batch[K, V]) {
+	timer := time.NewTimer(l.wait)
+	select {
+	case <-timer.C:
+		l.mu.Lock()
+		if l.batch == b {
+			l.batch = nil
+		}
+		l.mu.Unlock()
+	case <-b.full:
+		timer.Stop()
+	}
+
+	values, err := l.fetch(ctx, b.keys)
+	for key, res := range b.results {
+		switch value, ok := values[key]; {
+		case err != nil:
+			res.err = err
+		case !ok:
+			res.err = ErrNotFound
+		default:
+			res.value = value
+		}
+		close(res.done)
+	}
+
+	// Don't cache failures; a later Load in the same request may retry
+	if err != nil {
+		l.mu.Lock()
+		for key, res := range b.results {
+			if l.cache[key] == res {
+				delete(l.cache, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
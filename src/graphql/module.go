@@ -0,0 +1,167 @@
+package graphql
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/observability/tracing"
+	"coffee-and-running/src/storage"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	gqlgen "github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/go-chi/chi"
+	"github.com/vektah/gqlparser/v2/ast"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// LoaderFactory builds the per-request set of dataloaders. The returned value
+// is stored in the request context and retrieved by resolvers with
+// LoadersFromContext.
+type LoaderFactory func(ctx context.Context, engine storage.Engine) interface{}
+
+// Module serves a gqlgen executable schema with per-resolver metrics and
+// tracing, request-scoped dataloaders and an optional playground
+type Module struct {
+	config  *config.GraphQLConfig
+	appCfg  *config.AppConfig
+	logger  *zap.Logger
+	stats   metrics.Agent
+	tracer  tracing.Provider
+	engine  storage.Engine
+	loaders LoaderFactory
+	server  *handler.Server
+}
+
+// New creates the GraphQL module around the schema generated by gqlgen
+// (generated.NewExecutableSchema). loaders may be nil.
+func New(cfg *config.GraphQLConfig, appCfg *config.AppConfig, schema gqlgen.ExecutableSchema,
+	engine storage.Engine, loaders LoaderFactory, logger *zap.Logger, stats metrics.Agent, tracer tracing.Provider) *Module {
+	m := &Module{
+		config:  cfg,
+		appCfg:  appCfg,
+		logger:  logger.With(zap.String("component", "graphql")),
+		stats:   stats,
+		tracer:  tracer,
+		engine:  engine,
+		loaders: loaders,
+	}
+
+	srv := handler.New(schema)
+	srv.AddTransport(transport.Options{})
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+	srv.SetQueryCache(lru.New[*ast.QueryDocument](1000))
+
+	if cfg.Introspection {
+		srv.Use(extension.Introspection{})
+	}
+	if cfg.ComplexityLimit > 0 {
+		srv.Use(extension.FixedComplexityLimit(cfg.ComplexityLimit))
+	}
+
+	srv.AroundOperations(m.aroundOperation)
+	srv.AroundFields(m.aroundField)
+	m.server = srv
+
+	return m
+}
+
+// Mount registers the GraphQL endpoint (and the playground outside
+// production) on the router
+func (m *Module) Mount(r chi.Router) {
+	r.Handle(m.config.Path, m.withLoaders(m.server))
+
+	if m.config.Playground && !m.appCfg.IsProduction() {
+		r.Handle(m.config.PlaygroundPath, playground.Handler("GraphQL playground", m.config.Path))
+		m.logger.Info("graphql playground enabled", zap.String("path", m.config.PlaygroundPath))
+	}
+
+	m.logger.Info("graphql endpoint mounted",
+		zap.String("path", m.config.Path),
+		zap.Bool("introspection", m.config.Introspection),
+		zap.Int("complexity_limit", m.config.ComplexityLimit),
+	)
+}
+
+// withLoaders attaches a fresh set of dataloaders to every request, so
+// batching and caching never leak between requests
+func (m *Module) withLoaders(next http.Handler) http.Handler {
+	if m.loaders == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, loadersKey{}, m.loaders(ctx, m.engine))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// aroundOperation counts operations by type and records their duration
+func (m *Module) aroundOperation(ctx context.Context, next gqlgen.OperationHandler) gqlgen.ResponseHandler {
+	opCtx := gqlgen.GetOperationContext(ctx)
+	opType := "unknown"
+	if opCtx.Operation != nil {
+		opType = string(opCtx.Operation.Operation)
+	}
+
+	start := time.Now()
+	handle := next(ctx)
+
+	return func(ctx context.Context) *gqlgen.Response {
+		resp := handle(ctx)
+		m.stats.Increment(fmt.Sprintf("graphql.operation.%s.count", opType))
+		m.stats.Timing(fmt.Sprintf("graphql.operation.%s.duration", opType), time.Since(start))
+		if resp != nil && len(resp.Errors) > 0 {
+			m.stats.Increment(fmt.Sprintf("graphql.operation.%s.error", opType))
+		}
+		return resp
+	}
+}
+
+// aroundField records metrics and a span for every user-defined resolver.
+// Trivial field accessors are skipped to keep overhead and cardinality low.
+func (m *Module) aroundField(ctx context.Context, next gqlgen.Resolver) (interface{}, error) {
+	fc := gqlgen.GetFieldContext(ctx)
+	if fc == nil || !fc.IsResolver {
+		return next(ctx)
+	}
+
+	name := fmt.Sprintf("%s.%s", fc.Object, fc.Field.Name)
+	ctx, span := m.tracer.Tracer().Start(ctx, "graphql.resolve "+name)
+	span.SetAttributes(
+		attribute.String("graphql.object", fc.Object),
+		attribute.String("graphql.field", fc.Field.Name),
+	)
+	defer span.End()
+
+	start := time.Now()
+	res, err := next(ctx)
+	m.stats.Timing(fmt.Sprintf("graphql.resolver.%s.duration", name), time.Since(start))
+
+	if err != nil {
+		m.stats.Increment(fmt.Sprintf("graphql.resolver.%s.error", name))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		m.logger.Debug("resolver failed", zap.String("resolver", name), zap.Error(err))
+	}
+	return res, err
+}
+
+type loadersKey struct{}
+
+// LoadersFromContext returns the per-request loaders built by the module's
+// LoaderFactory. T must match the type the factory returns.
+func LoadersFromContext[T any](ctx context.Context) (T, bool) {
+	loaders, ok := ctx.Value(loadersKey{}).(T)
+	return loaders, ok
+}
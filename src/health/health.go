@@ -0,0 +1,362 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Overall and per-check statuses
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded" // only non-critical checks are failing
+	StatusError    = "error"
+	// StatusStarting is reported by /readyz until the service is ready
+	StatusStarting = "starting"
+)
+
+// Checker reports whether a dependency is healthy
+type Checker func(ctx context.Context) error
+
+// Result is the outcome of a single check
+type Result struct {
+	Status     string    `json:"status"` // ok, error
+	Critical   bool      `json:"critical"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Cached     bool      `json:"cached,omitempty"`
+}
+
+// Report is the outcome of every check. Status is error when a critical
+// check fails and degraded when only non-critical ones do.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Healthy reports whether every critical check passed
+func (r Report) Healthy() bool {
+	return r.Status != StatusError
+}
+
+// Failing returns the names of the failing checks, critical or not, in
+// sorted order
+func (r Report) Failing(critical bool) []string {
+	var names []string
+	for name, result := range r.Checks {
+		if result.Status != StatusOK && result.Critical == critical {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Option changes how a check is run
+type Option func(*check)
+
+// Critical sets whether a failing check fails the service as a whole.
+// Checks are critical by default; a failing non-critical check only
+// degrades it.
+func Critical(critical bool) Option {
+	return func(c *check) { c.critical = critical }
+}
+
+// Timeout bounds the check, overriding the registry's timeout
+func Timeout(timeout time.Duration) Option {
+	return func(c *check) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// check is a registered checker with its last result. mu is held while it
+// runs, so concurrent requests share one run instead of piling onto a
+// slow dependency.
+type check struct {
+	fn       Checker
+	critical bool
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	last   Result
+	hasRun bool
+}
+
+// Registry holds named dependency checks. Components register their own
+// checks so /health reflects everything the service depends on.
+type Registry struct {
+	timeout time.Duration
+	ready   atomic.Bool
+
+	mu        sync.RWMutex
+	cacheTTL  time.Duration
+	checks    map[string]*check
+	overrides map[string][]Option
+}
+
+// NewRegistry creates an empty registry. timeout bounds each check unless
+// it has its own.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout:   timeout,
+		checks:    make(map[string]*check),
+		overrides: make(map[string][]Option),
+	}
+}
+
+// SetCacheTTL makes /health reuse each check's result for ttl, so frequent
+// probes from several sources don't each hit the dependencies. Zero runs
+// the checks on every request.
+func (r *Registry) SetCacheTTL(ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cacheTTL = ttl
+}
+
+// Configure applies options to the named check, whether it is registered
+// already or later, taking precedence over those it is registered with.
+// It lets configuration decide which dependencies are critical.
+func (r *Registry) Configure(name string, opts ...Option) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides[name] = append(r.overrides[name], opts...)
+	if c, ok := r.checks[name]; ok {
+		c.mu.Lock()
+		for _, opt := range opts {
+			opt(c)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Register adds or replaces a named check
+func (r *Registry) Register(name string, fn Checker, opts ...Option) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := &check{fn: fn, critical: true, timeout: r.timeout}
+	for _, opt := range append(opts, r.overrides[name]...) {
+		opt(c)
+	}
+	r.checks[name] = c
+}
+
+// SetReady marks whether the service has finished starting, including any
+// warmup; /readyz fails until it has
+func (r *Registry) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+// Names returns the registered check names in sorted order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs every check concurrently, ignoring cached results
+func (r *Registry) Check(ctx context.Context) Report {
+	return r.run(ctx, 0)
+}
+
+// Cached runs every check concurrently, reusing results younger than the
+// cache TTL
+func (r *Registry) Cached(ctx context.Context) Report {
+	r.mu.RLock()
+	ttl := r.cacheTTL
+	r.mu.RUnlock()
+	return r.run(ctx, ttl)
+}
+
+func (r *Registry) run(ctx context.Context, ttl time.Duration) Report {
+	r.mu.RLock()
+	checks := make(map[string]*check, len(r.checks))
+	for name, c := range r.checks {
+		checks[name] = c
+	}
+	r.mu.RUnlock()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		report = Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	)
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c *check) {
+			defer wg.Done()
+			result := c.run(ctx, ttl)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status == StatusOK {
+				return
+			}
+			if result.Critical {
+				report.Status = StatusError
+			} else if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		}(name, c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// run returns the last result when it is younger than ttl, or runs the
+// check. A check that ignores its context is abandoned at the timeout
+// rather than holding up the report.
+func (c *check) run(ctx context.Context, ttl time.Duration) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl > 0 && c.hasRun && time.Since(c.last.CheckedAt) < ttl {
+		result := c.last
+		result.Cached = true
+		result.Critical = c.critical
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- c.fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", c.timeout)
+	}
+
+	result := Result{
+		Status:     StatusOK,
+		Critical:   c.critical,
+		DurationMS: time.Since(start).Milliseconds(),
+		CheckedAt:  start,
+	}
+	if err != nil {
+		result.Status = StatusError
+		result.Error = err.Error()
+	}
+	c.last, c.hasRun = result, true
+	return result
+}
+
+// Mount registers GET /health, a JSON report of every check, and GET
+// /healthz, a plain-text status for probes. Both return 503 only when a
+// critical check fails; a degraded service stays in rotation. GET /readyz
+// is /healthz for readiness probes, and also fails with "starting" until
+// SetReady.
+func (r *Registry) Mount(router chi.Router) {
+	router.Get("/health", r.handle)
+	router.Get("/healthz", r.handleProbe)
+	router.Get("/readyz", r.handleReady)
+}
+
+func (r *Registry) handle(w http.ResponseWriter, req *http.Request) {
+	report := r.Cached(req.Context())
+
+	code := http.StatusOK
+	if !report.Healthy() {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+func (r *Registry) handleProbe(w http.ResponseWriter, req *http.Request) {
+	report := r.Cached(req.Context())
+
+	code := http.StatusOK
+	if !report.Healthy() {
+		code = http.StatusServiceUnavailable
+	}
+	writeProbe(w, code, report.Status)
+}
+
+func (r *Registry) handleReady(w http.ResponseWriter, req *http.Request) {
+	if !r.ready.Load() {
+		writeProbe(w, http.StatusServiceUnavailable, StatusStarting)
+		return
+	}
+	r.handleProbe(w, req)
+}
+
+func writeProbe(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, status)
+}
+
+// WaitReady runs the checks until every critical one passes, backing off
+// exponentially between attempts from initial up to max, and logging
+// which checks are still failing. Failing non-critical checks are logged
+// but don't hold up startup. It returns the last failures once ctx is
+// done.
+func (r *Registry) WaitReady(ctx context.Context, initial, max time.Duration, logger *zap.Logger) error {
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	if max < initial {
+		max = initial
+	}
+
+	start := time.Now()
+	backoff := initial
+	for attempt := 1; ; attempt++ {
+		report := r.Check(ctx)
+		if report.Healthy() {
+			fields := []zap.Field{zap.Int("attempts", attempt), zap.Duration("waited", time.Since(start))}
+			if degraded := report.Failing(false); len(degraded) > 0 {
+				fields = append(fields, zap.Strings("degraded", degraded))
+			}
+			logger.Info("dependencies ready", fields...)
+			return nil
+		}
+
+		failing := report.Failing(true)
+		fields := []zap.Field{zap.Int("attempt", attempt), zap.Duration("retry_in", backoff)}
+		for name, result := range report.Checks {
+			if result.Status != StatusOK {
+				fields = append(fields, zap.String("check."+name, result.Error))
+			}
+		}
+		logger.Warn("waiting for dependencies", append(fields, zap.Strings("failing", failing))...)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("dependencies not ready after %s: %s", time.Since(start).Round(time.Second), strings.Join(failing, ", "))
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > max {
+			backoff = max
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package httpx
+
+import (
+	"coffee-and-running/src/storage"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned by IfMatchVersion for an If-Match
+// header that can't match any version
+var ErrPreconditionFailed = errors.New("httpx: If-Match names no current version")
+
+// VersionETag returns the strong entity tag for a row version, e.g. "v3".
+// Reads send a row's optimistic-lock version (see storage.Table.Version)
+// as its ETag and writes send it back in If-Match, so a client holding a
+// stale copy gets 412 instead of overwriting someone else's change.
+func VersionETag(version int64) string {
+	return `"v` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseVersionETag returns the version in a tag made by VersionETag. Weak
+// tags are refused: If-Match compares strongly.
+func ParseVersionETag(tag string) (int64, bool) {
+	tag = strings.TrimSpace(tag)
+	if !strings.HasPrefix(tag, `"v`) || !strings.HasSuffix(tag, `"`) || len(tag) < 4 {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[2:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+// SetVersion sets the response's ETag to version's
+func SetVersion(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", VersionETag(version))
+}
+
+// IfMatchVersion returns the version the request's If-Match header names.
+// ok is false when there is no header or it is "*", which matches any
+// version, so the write is unconditional. An If-Match naming anything
+// other than one version, such as a weak or foreign tag, returns
+// ErrPreconditionFailed.
+func IfMatchVersion(r *http.Request) (version int64, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+	found := false
+	for _, tag := range strings.Split(header, ",") {
+		v, parsed := ParseVersionETag(tag)
+		if !parsed {
+			continue
+		}
+		if found && v != version {
+			// One write can only be conditional on one version
+			return 0, false, ErrPreconditionFailed
+		}
+		version, found = v, true
+	}
+	if !found {
+		return 0, false, ErrPreconditionFailed
+	}
+	return version, true, nil
+}
+
+// NotModified sets the response's ETag to version's and, when the
+// request's If-None-Match already has it, answers 304 and returns true
+func NotModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	SetVersion(w, version)
+	tag := VersionETag(version)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// If-None-Match compares weakly
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// RequireIfMatch answers PUT and PATCH requests without an If-Match
+// header with 428, so clients of protected resources can't skip the
+// version check by leaving it out
+func RequireIfMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodPut || r.Method == http.MethodPatch) && r.Header.Get("If-Match") == "" {
+			writeConditionalError(w, http.StatusPreconditionRequired, "If-Match header is required; send the ETag from a GET")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WritePreconditionFailed answers 412 with the current version's ETag
+func WritePreconditionFailed(w http.ResponseWriter, current int64) {
+	SetVersion(w, current)
+	writeConditionalError(w, http.StatusPreconditionFailed, "resource was modified; fetch it again and retry")
+}
+
+// WriteVersionConflict answers a *storage.ConflictError with the current
+// version's ETag: 412 when the request was conditional, 409 otherwise. It
+// returns false, writing nothing, for other errors.
+func WriteVersionConflict(w http.ResponseWriter, r *http.Request, err error) bool {
+	var conflict *storage.ConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	if r.Header.Get("If-Match") != "" {
+		WritePreconditionFailed(w, conflict.Current)
+		return true
+	}
+	SetVersion(w, conflict.Current)
+	writeConditionalError(w, http.StatusConflict, "resource was modified; fetch it again and retry")
+	return true
+}
+
+func writeConditionalError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,169 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Fields is a parsed sparse fieldset: the members to keep at each level of
+// a JSON document. A nil value keeps the member whole.
+type Fields map[string]Fields
+
+// ParseFields parses a ?fields= value. Members are comma-separated and
+// named from the top of the response body. Nested members are selected
+// with dots or parentheses, so these are the same:
+//
+//	posts.id,posts.title,meta
+//	posts(id,title),meta
+//
+// Selections apply to every element of an array.
+func ParseFields(s string) (Fields, error) {
+	p := &fieldsParser{s: s}
+	fields := make(Fields)
+	err := p.list(fields)
+	if err == nil && p.pos < len(p.s) {
+		err = fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos)
+	}
+	if err != nil {
+		return nil, errParam("fields", "%v", err)
+	}
+	return fields, nil
+}
+
+// fieldsParser is a recursive descent parser for
+//
+//	list = item *("," item)
+//	item = name ["." item / "(" list ")"]
+type fieldsParser struct {
+	s   string
+	pos int
+}
+
+func (p *fieldsParser) list(into Fields) error {
+	for {
+		if err := p.item(into); err != nil {
+			return err
+		}
+		if p.pos >= len(p.s) || p.s[p.pos] != ',' {
+			return nil
+		}
+		p.pos++
+	}
+}
+
+func (p *fieldsParser) item(into Fields) error {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(",.()", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	name := strings.TrimSpace(p.s[start:p.pos])
+	if name == "" {
+		return fmt.Errorf("empty field name at %d", start)
+	}
+	if p.pos >= len(p.s) || (p.s[p.pos] != '.' && p.s[p.pos] != '(') {
+		// Selecting a member whole wins over selecting part of it
+		into[name] = nil
+		return nil
+	}
+
+	sub, seen := into[name]
+	switch {
+	case !seen:
+		sub = make(Fields)
+		into[name] = sub
+	case sub == nil:
+		// Already selected whole; parse the selection and drop it
+		sub = make(Fields)
+	}
+	if p.s[p.pos] == '.' {
+		p.pos++
+		return p.item(sub)
+	}
+	p.pos++
+	if err := p.list(sub); err != nil {
+		return err
+	}
+	if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+		return fmt.Errorf("missing ) after %s(", name)
+	}
+	p.pos++
+	return nil
+}
+
+// Prune returns the JSON document data keeping only the selected members.
+// Member order, number formatting and values outside objects are left as
+// they are; selected members the document lacks are skipped.
+func (f Fields) Prune(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := f.prune(bytes.TrimSpace(data), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f Fields) prune(data []byte, buf *bytes.Buffer) error {
+	if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+		buf.Write(data)
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if data[0] == '[' {
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			var elem json.RawMessage
+			if err := dec.Decode(&elem); err != nil {
+				return err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := f.prune(elem, buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	buf.WriteByte('{')
+	first := true
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		sub, ok := f[key]
+		if !ok {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if sub == nil {
+			buf.Write(value)
+			continue
+		}
+		if err := sub.prune(value, buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
@@ -0,0 +1,164 @@
+package httpx
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter operators, used as ?field[op]=value. A bare ?field=value is eq.
+const (
+	OpEq   = "eq"
+	OpNe   = "ne"
+	OpLt   = "lt"
+	OpLte  = "lte"
+	OpGt   = "gt"
+	OpGte  = "gte"
+	OpIn   = "in"   // comma-separated values
+	OpLike = "like" // case-insensitive substring match
+)
+
+// Filter value types
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeTime   = "time" // RFC 3339 or YYYY-MM-DD
+)
+
+// maxInValues bounds the size of IN lists
+const maxInValues = 100
+
+// Filter declares a filterable column
+type Filter struct {
+	Column string
+	// Type parses and validates values; defaults to TypeString
+	Type string
+	// Ops lists the allowed operators; defaults to eq only
+	Ops []string
+}
+
+// Condition is a parsed filter
+type Condition struct {
+	Column string
+	Op     string
+	Value  interface{} // []interface{} for OpIn
+}
+
+var sqlOps = map[string]string{
+	OpEq: "=", OpNe: "<>", OpLt: "<", OpLte: "<=", OpGt: ">", OpGte: ">=",
+}
+
+func (s *ListSpec) parseFilters(query url.Values) ([]Condition, error) {
+	// Sorted so the same request always produces the same SQL
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var conditions []Condition
+	for _, key := range keys {
+		values := query[key]
+		name, op := key, OpEq
+		if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:i], key[i+1:len(key)-1]
+		}
+
+		filter, ok := s.Filterable[name]
+		if !ok {
+			continue
+		}
+		if !filter.allows(op) {
+			return nil, errParam(name, "operator %q is not supported", op)
+		}
+
+		for _, raw := range values {
+			value, err := filter.parse(op, raw)
+			if err != nil {
+				return nil, errParam(name, "%v", err)
+			}
+			conditions = append(conditions, Condition{Column: filter.Column, Op: op, Value: value})
+		}
+	}
+	return conditions, nil
+}
+
+func (f Filter) allows(op string) bool {
+	if len(f.Ops) == 0 {
+		return op == OpEq
+	}
+	for _, allowed := range f.Ops {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) parse(op, raw string) (interface{}, error) {
+	if op == OpIn {
+		parts := strings.Split(raw, ",")
+		if len(parts) > maxInValues {
+			return nil, fmt.Errorf("at most %d values are allowed", maxInValues)
+		}
+		values := make([]interface{}, len(parts))
+		for i, part := range parts {
+			v, err := f.parseOne(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+	if op == OpLike {
+		return "%" + escapeLike(raw) + "%", nil
+	}
+	return f.parseOne(raw)
+}
+
+func (f Filter) parseOne(raw string) (interface{}, error) {
+	switch f.Type {
+	case "", TypeString:
+		return raw, nil
+	case TypeInt:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return n, nil
+	case TypeFloat:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return n, nil
+	case TypeBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return b, nil
+	case TypeTime:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an RFC 3339 time or date", raw)
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("unsupported filter type %q", f.Type)
+	}
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
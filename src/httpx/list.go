@@ -0,0 +1,205 @@
+package httpx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ParamError is returned for invalid list query parameters; handlers
+// should respond 400 with its message
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Message)
+}
+
+func errParam(param, format string, args ...interface{}) error {
+	return &ParamError{Param: param, Message: fmt.Sprintf(format, args...)}
+}
+
+// ListSpec declares what a list endpoint accepts. Column names come from
+// code, never from the request: clients can only pick among the keys.
+type ListSpec struct {
+	// Sortable maps sort parameter names to columns, e.g. "created" to
+	// "p.created_at"
+	Sortable map[string]string
+	// Filterable maps filter parameter names to their columns and operators
+	Filterable map[string]Filter
+	// DefaultSort applies when ?sort is absent, e.g. "-created"
+	DefaultSort string
+	// Tiebreaker is a unique column appended to every sort, typically
+	// "id", so ordering and cursors are stable
+	Tiebreaker string
+	// DefaultLimit and MaxLimit bound page sizes
+	DefaultLimit int
+	MaxLimit     int
+	// Cursors signs cursor tokens. Without it cursors are plain base64
+	// and only their length is checked against the sort.
+	Cursors *Cursors
+}
+
+// SortField is one ORDER BY term
+type SortField struct {
+	Column     string
+	Descending bool
+}
+
+// List is a parsed, validated list request
+type List struct {
+	Filters []Condition
+	Sort    []SortField
+	Limit   int
+	Offset  int
+	// Cursor holds the keyset values of the last row of the previous page
+	Cursor []interface{}
+
+	order   string
+	cursors *Cursors
+}
+
+// Parse reads ?limit, ?offset or ?cursor, ?sort and filters from r:
+//
+//	GET /posts?status=published&created[gte]=2024-01-01&sort=-created&limit=20
+func (s *ListSpec) Parse(r *http.Request) (*List, error) {
+	query := r.URL.Query()
+	list := &List{cursors: s.Cursors}
+
+	var err error
+	if list.Limit, err = s.parseLimit(query.Get("limit")); err != nil {
+		return nil, err
+	}
+	if list.Sort, list.order, err = s.parseSort(query.Get("sort")); err != nil {
+		return nil, err
+	}
+	if list.Filters, err = s.parseFilters(query); err != nil {
+		return nil, err
+	}
+
+	cursor, offset := query.Get("cursor"), query.Get("offset")
+	switch {
+	case cursor != "" && offset != "":
+		return nil, errParam("cursor", "cannot be combined with offset")
+	case cursor != "" && s.Cursors != nil:
+		order, values, err := s.Cursors.Decode(cursor)
+		if err != nil {
+			return nil, errParam("cursor", "invalid or expired")
+		}
+		// The cursor carries its sort order, so later pages may omit ?sort
+		if query.Get("sort") == "" {
+			if list.Sort, list.order, err = s.parseSort(order); err != nil {
+				return nil, errParam("cursor", "invalid or expired")
+			}
+		} else if order != list.order {
+			return nil, errParam("cursor", "does not match the sort order")
+		}
+		list.Cursor = values
+		if len(list.Cursor) != len(list.Sort) {
+			return nil, errParam("cursor", "does not match the sort order")
+		}
+	case cursor != "":
+		if list.Cursor, err = DecodeCursor(cursor); err != nil {
+			return nil, errParam("cursor", "malformed")
+		}
+		if len(list.Cursor) != len(list.Sort) {
+			return nil, errParam("cursor", "does not match the sort order")
+		}
+	case offset != "":
+		if list.Offset, err = strconv.Atoi(offset); err != nil || list.Offset < 0 {
+			return nil, errParam("offset", "must be a non-negative integer")
+		}
+	}
+	return list, nil
+}
+
+func (s *ListSpec) parseLimit(value string) (int, error) {
+	limit := s.DefaultLimit
+	if limit <= 0 {
+		limit = 20
+	}
+	if value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, errParam("limit", "must be a positive integer")
+		}
+		limit = n
+	}
+	if s.MaxLimit > 0 && limit > s.MaxLimit {
+		limit = s.MaxLimit
+	}
+	return limit, nil
+}
+
+// parseSort returns the ORDER BY terms and the canonical form of the
+// requested order, e.g. "-created,title", which cursors are bound to
+func (s *ListSpec) parseSort(value string) ([]SortField, string, error) {
+	if value == "" {
+		value = s.DefaultSort
+	}
+
+	var fields []SortField
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		column, ok := s.Sortable[name]
+		if !ok {
+			return nil, "", errParam("sort", "cannot sort by %q", name)
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		fields = append(fields, SortField{Column: column, Descending: desc})
+		if desc {
+			name = "-" + name
+		}
+		names = append(names, name)
+	}
+
+	if s.Tiebreaker != "" && !seen[s.Tiebreaker] {
+		// Follow the direction of the last field so the index can be
+		// scanned in one direction
+		desc := len(fields) > 0 && fields[len(fields)-1].Descending
+		fields = append(fields, SortField{Column: s.Tiebreaker, Descending: desc})
+	}
+	return fields, strings.Join(names, ","), nil
+}
+
+// EncodeCursor encodes the keyset values of a row as an opaque token
+func EncodeCursor(values ...interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor reverses EncodeCursor. Numbers decode as json.Number,
+// which database/sql sends as text so the column's type applies.
+func DecodeCursor(token string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var values []interface{}
+	if err := dec.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
@@ -0,0 +1,182 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MessagePack encodes values as MessagePack. It transcodes the value's
+// JSON encoding, so json tags, omitempty and MarshalJSON apply exactly as
+// they do for JSON clients. Integers get the smallest integer format that
+// holds them and other numbers are float64; []byte fields stay base64
+// strings, as in JSON.
+var MessagePack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := transcodeMsgpack(data, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// transcodeMsgpack writes the JSON value data as MessagePack
+func transcodeMsgpack(data []byte, buf *bytes.Buffer) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return fmt.Errorf("msgpack: empty JSON value")
+	}
+
+	switch data[0] {
+	case '{':
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.Token()
+		var keys []string
+		var values []json.RawMessage
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		writeMsgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf)
+		for i, key := range keys {
+			writeMsgpackString(buf, key)
+			if err := transcodeMsgpack(values[i], buf); err != nil {
+				return err
+			}
+		}
+	case '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return err
+		}
+		writeMsgpackHeader(buf, len(elems), 0x90, 0xdc, 0xdd)
+		for _, elem := range elems {
+			if err := transcodeMsgpack(elem, buf); err != nil {
+				return err
+			}
+		}
+	case '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		writeMsgpackString(buf, s)
+	case 'n':
+		buf.WriteByte(0xc0)
+	case 't':
+		buf.WriteByte(0xc3)
+	case 'f':
+		buf.WriteByte(0xc2)
+	default:
+		return writeMsgpackNumber(buf, string(data))
+	}
+	return nil
+}
+
+// writeMsgpackHeader writes an array or map header: the fix format for
+// fewer than 16 entries, then the 16 and 32 bit formats
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(b32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(0xdb)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	buf.WriteString(s)
+}
+
+func writeMsgpackNumber(buf *bytes.Buffer, s string) error {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		writeMsgpackInt(buf, i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		buf.WriteByte(0xcf)
+		buf.Write(binary.BigEndian.AppendUint64(nil, u))
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("msgpack: invalid JSON number %q", s)
+	}
+	buf.WriteByte(0xcb)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		buf.WriteByte(byte(int8(i)))
+	case i > 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i > 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(i)))
+	case i > 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	case i > 0:
+		buf.WriteByte(0xcf)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(int16(i))))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(int32(i))))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
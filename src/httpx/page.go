@@ -0,0 +1,94 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PageMeta describes a page of results for the response body
+type PageMeta struct {
+	Limit      int    `json:"limit"`
+	Offset     *int   `json:"offset,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// Paginate trims the extra row fetched by LimitOffset and builds the page
+// metadata. cursor returns a row's values for the list's sort columns, in
+// order; it may be nil for offset-only endpoints.
+func Paginate[T any](l *List, rows []T, cursor func(T) []interface{}) ([]T, PageMeta, error) {
+	meta := PageMeta{Limit: l.Limit}
+	if len(l.Cursor) == 0 {
+		offset := l.Offset
+		meta.Offset = &offset
+	}
+
+	if len(rows) > l.Limit {
+		rows = rows[:l.Limit]
+		meta.HasMore = true
+	}
+	if meta.HasMore && cursor != nil && len(rows) > 0 {
+		values := cursor(rows[len(rows)-1])
+		var token string
+		var err error
+		if l.cursors != nil {
+			token, err = l.cursors.Encode(l.order, values)
+		} else {
+			token, err = EncodeCursor(values...)
+		}
+		if err != nil {
+			return nil, meta, err
+		}
+		meta.NextCursor = token
+	}
+	return rows, meta, nil
+}
+
+// WriteLinks sets an RFC 8288 Link header with first, prev and next pages
+// for r, keeping its other query parameters
+func WriteLinks(w http.ResponseWriter, r *http.Request, meta PageMeta) {
+	var links []string
+	link := func(rel string, set map[string]string) {
+		u := *r.URL
+		q := u.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, requestURL(r, &u), rel))
+	}
+
+	link("first", nil)
+	if meta.Offset != nil && *meta.Offset > 0 {
+		prev := *meta.Offset - meta.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		link("prev", map[string]string{"offset": strconv.Itoa(prev)})
+	}
+	if meta.HasMore {
+		if meta.NextCursor != "" {
+			link("next", map[string]string{"cursor": meta.NextCursor})
+		} else if meta.Offset != nil {
+			link("next", map[string]string{"offset": strconv.Itoa(*meta.Offset + meta.Limit)})
+		}
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
+// requestURL makes u absolute using the request's host and scheme
+func requestURL(r *http.Request, u *url.URL) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u.Scheme = scheme
+	u.Host = r.Host
+	return u.String()
+}
@@ -0,0 +1,490 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch media types. A PATCH sent as plain application/json is treated as
+// a merge patch.
+const (
+	JSONPatchType  = "application/json-patch+json"
+	MergePatchType = "application/merge-patch+json"
+)
+
+// AcceptPatch lists the patch formats ApplyPatch understands, for the
+// Accept-Patch header (RFC 5789)
+const AcceptPatch = JSONPatchType + ", " + MergePatchType
+
+// maxPatchBytes bounds a patch body
+const maxPatchBytes = 1 << 20
+
+// PatchError is returned when a patch can't be applied. Status is the
+// response it calls for: 415 for a body that isn't a patch, 400 for a
+// malformed one, 409 when a JSON Patch test operation fails and 422 when
+// the patched resource is invalid.
+type PatchError struct {
+	Status  int
+	Message string
+}
+
+func (e *PatchError) Error() string {
+	return "invalid patch: " + e.Message
+}
+
+func errPatch(status int, format string, args ...interface{}) error {
+	return &PatchError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Patch is a decoded PATCH body: a JSON Patch (RFC 6902) or a JSON Merge
+// Patch (RFC 7396)
+type Patch struct {
+	ops   []patchOp
+	merge interface{}
+	isOps bool
+}
+
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// DecodePatch reads the request body as the patch format its Content-Type
+// names
+func DecodePatch(r *http.Request) (*Patch, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != JSONPatchType && mediaType != MergePatchType && mediaType != "application/json" {
+		return nil, errPatch(http.StatusUnsupportedMediaType, "Content-Type must be %s or %s", JSONPatchType, MergePatchType)
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPatchBytes+1))
+	if err != nil {
+		return nil, errPatch(http.StatusBadRequest, "reading body: %v", err)
+	}
+	if len(body) > maxPatchBytes {
+		return nil, errPatch(http.StatusBadRequest, "body exceeds %d bytes", maxPatchBytes)
+	}
+
+	if mediaType != JSONPatchType {
+		merge, err := decodeJSON(body)
+		if err != nil {
+			return nil, errPatch(http.StatusBadRequest, "body must be JSON")
+		}
+		return &Patch{merge: merge}, nil
+	}
+
+	var ops []patchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errPatch(http.StatusBadRequest, "body must be a JSON array of operations")
+	}
+	for i, op := range ops {
+		if err := op.check(); err != nil {
+			return nil, errPatch(http.StatusBadRequest, "operation %d: %v", i, err)
+		}
+	}
+	return &Patch{ops: ops, isOps: true}, nil
+}
+
+// check validates an operation's members before any is applied
+func (op patchOp) check() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s needs a value", op.Op)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return fmt.Errorf("%s needs from", op.Op)
+		}
+		if _, err := parsePointer(*op.From); err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	if op.Path == nil {
+		return fmt.Errorf("%s needs a path", op.Op)
+	}
+	_, err := parsePointer(*op.Path)
+	return err
+}
+
+// Apply patches the JSON document doc and returns the result
+func (p *Patch) Apply(doc []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p.isOps {
+		for i, op := range p.ops {
+			if target, err = op.apply(target); err != nil {
+				var pe *PatchError
+				if errors.As(err, &pe) {
+					return nil, errPatch(pe.Status, "operation %d: %s", i, pe.Message)
+				}
+				return nil, errPatch(http.StatusUnprocessableEntity, "operation %d: %v", i, err)
+			}
+		}
+	} else {
+		target = mergePatch(target, p.merge)
+	}
+	return json.Marshal(target)
+}
+
+// ApplyPatch decodes the request's patch and applies it to dst, a pointer
+// to a resource fetched from storage. The patched resource is decoded into
+// a fresh value, so removed members are zeroed and unknown members are
+// refused, then validated with its Validate method if it has one. dst is
+// only changed when all of that succeeds. Errors are *PatchError, see
+// WritePatchError, unless dst itself can't be encoded.
+func ApplyPatch(r *http.Request, dst interface{}) error {
+	p, err := DecodePatch(r)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	if doc, err = p.Apply(doc); err != nil {
+		return err
+	}
+
+	patched := reflect.New(reflect.TypeOf(dst).Elem())
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patched.Interface()); err != nil {
+		return errPatch(http.StatusUnprocessableEntity, "%v", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if v, ok := patched.Interface().(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return errPatch(http.StatusUnprocessableEntity, "%v", err)
+		}
+	}
+	reflect.ValueOf(dst).Elem().Set(patched.Elem())
+	return nil
+}
+
+// WritePatchError answers a *PatchError with its status and, for 415,
+// the Accept-Patch header. It returns false, writing nothing, for other
+// errors.
+func WritePatchError(w http.ResponseWriter, err error) bool {
+	var pe *PatchError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	if pe.Status == http.StatusUnsupportedMediaType {
+		w.Header().Set("Accept-Patch", AcceptPatch)
+	}
+	writeConditionalError(w, pe.Status, pe.Error())
+	return true
+}
+
+// decodeJSON decodes one JSON value keeping numbers exact
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return v, nil
+}
+
+// mergePatch applies an RFC 7396 merge patch: objects merge member by
+// member, null removes a member and anything else replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+func (op patchOp) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(*op.Path)
+	switch op.Op {
+	case "add", "replace":
+		value, err := decodeJSON(*op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "replace" {
+			if _, err := pointerGet(doc, path); err != nil {
+				return nil, err
+			}
+			if doc, _, err = pointerRemove(doc, path); err != nil {
+				return nil, err
+			}
+		}
+		return pointerAdd(doc, path, value)
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+	case "move":
+		from, _ := parsePointer(*op.From)
+		if isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("can't move %s into itself", *op.From)
+		}
+		doc, value, err := pointerRemove(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "copy":
+		from, _ := parsePointer(*op.From)
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, deepCopy(value))
+	default: // test
+		want, err := decodeJSON(*op.Value)
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, path)
+		if err != nil || !jsonEqual(got, want) {
+			return nil, errPatch(http.StatusConflict, "test failed at %s", *op.Path)
+		}
+		return doc, nil
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex parses an array index token. "-" is one past the end and
+// only valid when appending.
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := n - 1
+	if appending {
+		limit = n
+	}
+	if i > limit {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("can't index a scalar with %q", token)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd sets the value at path, inserting into arrays, and returns
+// the new document
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return pointerSet(doc, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("can't add to a scalar")
+	}
+}
+
+// pointerRemove deletes the value at path and returns the new document
+// and the removed value
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		v, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("no member %q", last)
+		}
+		delete(node, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = pointerSet(doc, path[:len(path)-1], node)
+		return doc, v, err
+	default:
+		return nil, nil, fmt.Errorf("can't remove from a scalar")
+	}
+}
+
+// pointerSet replaces the value at an existing path, for arrays that
+// changed length
+func pointerSet(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(node))
+		for k, e := range node {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(node))
+		for i, e := range node {
+			c[i] = deepCopy(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// jsonEqual compares decoded JSON values, numbers by value so 1 equals 1.0
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		rx, okx := new(big.Rat).SetString(string(x))
+		ry, oky := new(big.Rat).SetString(string(y))
+		return okx && oky && rx.Cmp(ry) == 0
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
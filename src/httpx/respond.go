@@ -0,0 +1,190 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes response bodies for Respond
+type Codec interface {
+	// MediaTypes lists the media types the codec answers to in Accept; the
+	// first is sent as Content-Type
+	MediaTypes() []string
+	// Marshal encodes v, or returns ErrUnsupportedValue when the codec
+	// can't represent it so the next acceptable codec is tried
+	Marshal(v interface{}) ([]byte, error)
+}
+
+// ErrUnsupportedValue is returned by codecs for values they can't encode,
+// such as non-protobuf values for the protobuf codec
+var ErrUnsupportedValue = errors.New("httpx: codec does not support the value")
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = []Codec{JSON, MessagePack, Protobuf}
+)
+
+// RegisterCodec adds a codec for Respond, replacing the codec whose first
+// media type is the same. JSON stays the default for clients that accept
+// anything.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for i, existing := range codecs {
+		if existing.MediaTypes()[0] == c.MediaTypes()[0] {
+			codecs[i] = c
+			return
+		}
+	}
+	codecs = append(codecs, c)
+}
+
+// Respond writes v with status in the encoding the request's Accept header
+// prefers among the registered codecs: JSON, MessagePack and, for protobuf
+// messages, Protobuf. Requests without a usable preference get JSON.
+func Respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	contentType, body, err := Negotiate(r, v)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to encode response"})
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Negotiate encodes v for r, returning the Content-Type and body
+func Negotiate(r *http.Request, v interface{}) (contentType string, body []byte, err error) {
+	codecsMu.RLock()
+	candidates := append([]Codec(nil), codecs...)
+	codecsMu.RUnlock()
+
+	accept := parseAccept(r.Header.Get("Accept"))
+	type ranked struct {
+		codec Codec
+		q     float64
+		index int
+	}
+	var order []ranked
+	for i, c := range candidates {
+		if q := accept.quality(c.MediaTypes()); q > 0 {
+			order = append(order, ranked{c, q, i})
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].q > order[j].q })
+	// JSON is the fallback for requests accepting nothing we encode
+	order = append(order, ranked{codec: JSON})
+
+	for _, o := range order {
+		body, err := o.codec.Marshal(v)
+		if errors.Is(err, ErrUnsupportedValue) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return o.codec.MediaTypes()[0], body, nil
+	}
+	return "", nil, ErrUnsupportedValue
+}
+
+// acceptRange is one media range of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+type acceptHeader []acceptRange
+
+// parseAccept parses an Accept header. An empty header accepts anything.
+func parseAccept(header string) acceptHeader {
+	if strings.TrimSpace(header) == "" {
+		return acceptHeader{{mediaType: "*/*", q: 1}}
+	}
+	var ranges acceptHeader
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// quality returns the quality the header gives the best of mediaTypes,
+// using the most specific matching range for each
+func (a acceptHeader) quality(mediaTypes []string) float64 {
+	best := 0.0
+	for _, mediaType := range mediaTypes {
+		major, _, _ := strings.Cut(mediaType, "/")
+		q, specificity := 0.0, -1
+		for _, ar := range a {
+			s := -1
+			switch {
+			case ar.mediaType == mediaType:
+				s = 2
+			case ar.mediaType == major+"/*":
+				s = 1
+			case ar.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+		best = max(best, q)
+	}
+	return best
+}
+
+// JSON encodes with encoding/json
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaTypes() []string { return []string{"application/json"} }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// Protobuf encodes protobuf messages in the binary wire format. Other
+// values are left to the next acceptable codec.
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) MediaTypes() []string {
+	return []string{"application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf"}
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrUnsupportedValue
+	}
+	return proto.Marshal(m)
+}
@@ -0,0 +1,106 @@
+package httpx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Where returns the filter and cursor conditions joined with AND, using
+// placeholders numbered after args, and the extended args:
+//
+//	where, args := list.Where([]interface{}{tenantID})
+//	query := "SELECT ... FROM posts WHERE tenant_id = $1 AND " + where + list.OrderBy() + limit
+//
+// It returns "TRUE" when there is nothing to filter.
+func (l *List) Where(args []interface{}) (string, []interface{}) {
+	var clauses []string
+	for _, c := range l.Filters {
+		var clause string
+		clause, args = c.sql(args)
+		clauses = append(clauses, clause)
+	}
+	if len(l.Cursor) > 0 {
+		var clause string
+		clause, args = l.keyset(args)
+		clauses = append(clauses, clause)
+	}
+
+	if len(clauses) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// OrderBy returns the ORDER BY clause, with a leading space
+func (l *List) OrderBy() string {
+	if len(l.Sort) == 0 {
+		return ""
+	}
+	terms := make([]string, len(l.Sort))
+	for i, f := range l.Sort {
+		terms[i] = f.Column
+		if f.Descending {
+			terms[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// LimitOffset returns the LIMIT/OFFSET clause, with a leading space. It
+// fetches one row more than the page size so Paginate can tell whether
+// another page exists.
+func (l *List) LimitOffset(args []interface{}) (string, []interface{}) {
+	args = append(args, l.Limit+1)
+	clause := fmt.Sprintf(" LIMIT $%d", len(args))
+	if l.Offset > 0 {
+		args = append(args, l.Offset)
+		clause += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return clause, args
+}
+
+func (c Condition) sql(args []interface{}) (string, []interface{}) {
+	switch c.Op {
+	case OpIn:
+		values := c.Value.([]interface{})
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		return fmt.Sprintf("%s IN (%s)", c.Column, strings.Join(placeholders, ", ")), args
+	case OpLike:
+		args = append(args, c.Value)
+		return fmt.Sprintf("%s ILIKE $%d", c.Column, len(args)), args
+	default:
+		args = append(args, c.Value)
+		return fmt.Sprintf("%s %s $%d", c.Column, sqlOps[c.Op], len(args)), args
+	}
+}
+
+// keyset builds the condition selecting rows after the cursor in sort
+// order. Mixed directions rule out a row comparison, so it expands to
+//
+//	(a > $1) OR (a = $1 AND b < $2) OR (a = $1 AND b = $2 AND c > $3)
+func (l *List) keyset(args []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(l.Cursor))
+	for i, v := range l.Cursor {
+		args = append(args, v)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+
+	branches := make([]string, len(l.Sort))
+	for i, f := range l.Sort {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", l.Sort[j].Column, placeholders[j]))
+		}
+		op := ">"
+		if f.Descending {
+			op = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", f.Column, op, placeholders[i]))
+		branches[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(branches, " OR ") + ")", args
+}
@@ -0,0 +1,286 @@
+package httpx
+
+import (
+	"bufio"
+	"coffee-and-running/src/observability/metrics"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trailers set by the stream helpers. The status line is sent with the
+// first rows, so a failure part way through can only be reported after
+// them: clients should treat a response without X-Stream-Rows, or with
+// X-Stream-Error, as incomplete.
+const (
+	TrailerRows  = "X-Stream-Rows"
+	TrailerError = "X-Stream-Error"
+)
+
+// Stream defaults
+const (
+	defaultFlushRows     = 500
+	defaultFlushInterval = time.Second
+	defaultWriteIdle     = 30 * time.Second
+)
+
+// StreamOption configures StreamNDJSON and StreamCSV
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	flushRows     int
+	flushInterval time.Duration
+	writeIdle     time.Duration
+	filename      string
+	stats         metrics.Agent
+	name          string
+}
+
+// FlushEvery flushes to the client after rows rows or interval, whichever
+// comes first; 500 rows or a second by default
+func FlushEvery(rows int, interval time.Duration) StreamOption {
+	return func(o *streamOptions) { o.flushRows, o.flushInterval = rows, interval }
+}
+
+// WriteIdle extends the connection's write deadline by d at every flush,
+// so a long export isn't cut off by server.write_timeout while a stalled
+// client still is; 30s by default
+func WriteIdle(d time.Duration) StreamOption {
+	return func(o *streamOptions) { o.writeIdle = d }
+}
+
+// Attachment sets Content-Disposition so browsers save the response as
+// filename
+func Attachment(filename string) StreamOption {
+	return func(o *streamOptions) { o.filename = filename }
+}
+
+// StreamMetrics counts the rows written under stream.<name>.rows, times
+// the stream under stream.<name>.duration and counts streams cut short
+// under stream.<name>.canceled or stream.<name>.error
+func StreamMetrics(stats metrics.Agent, name string) StreamOption {
+	return func(o *streamOptions) { o.stats, o.name = stats, name }
+}
+
+// StreamNDJSON writes rows to w as newline-delimited JSON objects keyed by
+// column name, flushing as it goes rather than buffering the result. JSON
+// and JSONB columns are embedded as JSON, bytea as base64 and other bytes
+// as strings. Run the query with r.Context() so a client that disconnects
+// stops it. It closes rows and returns the number written.
+//
+//	rows, err := engine.Query(r.Context(), `SELECT id, email, created_at FROM users`)
+//	...
+//	n, err := httpx.StreamNDJSON(w, r, rows, httpx.StreamMetrics(stats, "users_export"))
+func StreamNDJSON(w http.ResponseWriter, r *http.Request, rows *sql.Rows, opts ...StreamOption) (int64, error) {
+	return stream(w, r, rows, "application/x-ndjson", ndjsonEncoder, opts)
+}
+
+// StreamCSV writes rows to w as CSV with a header row of column names,
+// as StreamNDJSON does. NULLs are written as empty fields and times in
+// RFC 3339.
+func StreamCSV(w http.ResponseWriter, r *http.Request, rows *sql.Rows, opts ...StreamOption) (int64, error) {
+	return stream(w, r, rows, "text/csv; charset=utf-8", csvEncoder, opts)
+}
+
+// rowEncoder writes one row of values; newEncoder may write a preamble
+type rowEncoder interface {
+	encode(values []interface{}) error
+	flush() error
+}
+
+type newEncoder func(w *bufio.Writer, columns []*sql.ColumnType) (rowEncoder, error)
+
+func stream(w http.ResponseWriter, r *http.Request, rows *sql.Rows, contentType string, newEnc newEncoder, opts []StreamOption) (n int64, err error) {
+	defer rows.Close()
+	o := streamOptions{flushRows: defaultFlushRows, flushInterval: defaultFlushInterval, writeIdle: defaultWriteIdle}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	start := time.Now()
+	started := false
+	defer func() {
+		canceled := r.Context().Err() != nil
+		if started {
+			// Only the outcome: the error itself may describe the schema
+			w.Header().Set(TrailerRows, strconv.FormatInt(n, 10))
+			if canceled {
+				w.Header().Set(TrailerError, "canceled")
+			} else if err != nil {
+				w.Header().Set(TrailerError, "failed")
+			}
+		}
+		if o.stats == nil {
+			return
+		}
+		prefix := "stream." + o.name
+		o.stats.Count(prefix+".rows", n)
+		o.stats.Timing(prefix+".duration", time.Since(start))
+		switch {
+		case canceled:
+			o.stats.Increment(prefix + ".canceled")
+		case err != nil:
+			o.stats.Increment(prefix + ".error")
+		}
+	}()
+
+	// Fails before anything is written, so the caller can still respond
+	// with an error status
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Trailer", TrailerRows+", "+TrailerError)
+	if o.filename != "" {
+		header.Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(o.filename, `"`, "")+`"`)
+	}
+	w.WriteHeader(http.StatusOK)
+	started = true
+
+	rc := http.NewResponseController(w)
+	buf := bufio.NewWriterSize(w, 32<<10)
+	flush := func() error {
+		if err := buf.Flush(); err != nil {
+			return err
+		}
+		if o.writeIdle > 0 {
+			// Unsupported by some wrappers; the server's timeout applies
+			rc.SetWriteDeadline(time.Now().Add(o.writeIdle))
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	enc, err := newEnc(buf, columns)
+	if err != nil {
+		return 0, err
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	lastFlush := time.Now()
+	for rows.Next() {
+		if err := r.Context().Err(); err != nil {
+			return n, err
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return n, err
+		}
+		if err := enc.encode(values); err != nil {
+			return n, err
+		}
+		n++
+		if (o.flushRows > 0 && n%int64(o.flushRows) == 0) || time.Since(lastFlush) >= o.flushInterval {
+			if err := enc.flush(); err != nil {
+				return n, err
+			}
+			if err := flush(); err != nil {
+				return n, err
+			}
+			lastFlush = time.Now()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := enc.flush(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+type ndjson struct {
+	enc     *json.Encoder
+	names   []string
+	kinds   []string
+	current map[string]interface{}
+}
+
+func ndjsonEncoder(w *bufio.Writer, columns []*sql.ColumnType) (rowEncoder, error) {
+	e := &ndjson{enc: json.NewEncoder(w), current: make(map[string]interface{}, len(columns))}
+	for _, c := range columns {
+		e.names = append(e.names, c.Name())
+		e.kinds = append(e.kinds, strings.ToUpper(c.DatabaseTypeName()))
+	}
+	return e, nil
+}
+
+func (e *ndjson) encode(values []interface{}) error {
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			switch e.kinds[i] {
+			case "JSON", "JSONB":
+				v = json.RawMessage(b)
+			case "BYTEA":
+				v = base64.StdEncoding.EncodeToString(b)
+			default:
+				v = string(b)
+			}
+		}
+		e.current[e.names[i]] = v
+	}
+	// Encode ends each value with a newline
+	return e.enc.Encode(e.current)
+}
+
+func (e *ndjson) flush() error { return nil }
+
+type csvRows struct {
+	w      *csv.Writer
+	record []string
+}
+
+func csvEncoder(w *bufio.Writer, columns []*sql.ColumnType) (rowEncoder, error) {
+	e := &csvRows{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	for i, c := range columns {
+		e.record[i] = c.Name()
+	}
+	return e, e.w.Write(e.record)
+}
+
+func (e *csvRows) encode(values []interface{}) error {
+	for i, v := range values {
+		e.record[i] = csvField(v)
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvRows) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func csvField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
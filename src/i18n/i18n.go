@@ -0,0 +1,352 @@
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/text/language"
+)
+
+// Args are the values substituted for {name} placeholders in a message
+type Args map[string]interface{}
+
+// message is a single entry of a catalog: either a plain string or a set
+// of plural forms keyed by category
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+// Bundle holds the message catalogs for every supported locale
+type Bundle struct {
+	defaultLocale language.Tag
+
+	mu       sync.RWMutex
+	catalogs map[string]map[string]message
+	tags     []language.Tag
+	matcher  language.Matcher
+}
+
+// NewBundle creates an empty bundle. defaultLocale is used when nothing
+// better matches and as the last fallback for missing messages.
+func NewBundle(defaultLocale string) (*Bundle, error) {
+	tag, err := language.Parse(defaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default locale %q: %w", defaultLocale, err)
+	}
+	b := &Bundle{
+		defaultLocale: tag,
+		catalogs:      make(map[string]map[string]message),
+	}
+	b.tags = []language.Tag{tag}
+	b.matcher = language.NewMatcher(b.tags)
+	return b, nil
+}
+
+// LoadFS loads every <locale>.json and <locale>.toml file in dir, e.g.
+// "en.json" or "pt-BR.toml". Values are strings, or tables of plural
+// forms:
+//
+//	{
+//	  "greeting": "Hello, {name}!",
+//	  "items": {"one": "{count} item", "other": "{count} items"}
+//	}
+//
+// Keys may be nested objects; they are flattened with dots, so
+// {"errors": {"required": "..."}} defines "errors.required".
+func (b *Bundle) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := path.Ext(entry.Name())
+		if ext != ".json" && ext != ".toml" {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		var raw map[string]interface{}
+		if ext == ".json" {
+			err = json.Unmarshal(data, &raw)
+		} else {
+			err = toml.Unmarshal(data, &raw)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+
+		if err := b.Add(strings.TrimSuffix(entry.Name(), ext), raw); err != nil {
+			return fmt.Errorf("invalid catalog %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Add merges messages into the catalog for locale
+func (b *Bundle) Add(locale string, messages map[string]interface{}) error {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+
+	flat := make(map[string]message)
+	if err := flatten("", messages, flat); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := tag.String()
+	catalog, ok := b.catalogs[key]
+	if !ok {
+		catalog = make(map[string]message)
+		b.catalogs[key] = catalog
+		if tag != b.defaultLocale {
+			b.tags = append(b.tags, tag)
+			b.matcher = language.NewMatcher(b.tags)
+		}
+	}
+	for k, m := range flat {
+		catalog[k] = m
+	}
+	return nil
+}
+
+// flatten walks nested tables. A table whose keys are all plural
+// categories, including "other", is a plural message.
+func flatten(prefix string, raw map[string]interface{}, out map[string]message) error {
+	for k, v := range raw {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch v := v.(type) {
+		case string:
+			out[key] = message{text: v}
+		case map[string]interface{}:
+			if forms, ok := pluralForms(v); ok {
+				out[key] = message{text: forms[Other], forms: forms}
+				continue
+			}
+			if err := flatten(key, v, out); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("message %s must be a string or table, got %T", key, v)
+		}
+	}
+	return nil
+}
+
+func pluralForms(v map[string]interface{}) (map[string]string, bool) {
+	if _, ok := v[Other]; !ok {
+		return nil, false
+	}
+	forms := make(map[string]string, len(v))
+	for k, form := range v {
+		s, ok := form.(string)
+		if !ok {
+			return nil, false
+		}
+		switch k {
+		case Zero, One, Two, Few, Many, Other:
+			forms[k] = s
+		default:
+			return nil, false
+		}
+	}
+	return forms, true
+}
+
+// Locales returns the supported locales, default first
+func (b *Bundle) Locales() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	locales := make([]string, len(b.tags))
+	for i, tag := range b.tags {
+		locales[i] = tag.String()
+	}
+	return locales
+}
+
+// Match picks the best supported locale for the preferences, tried in
+// order. Each preference may be a single locale ("de-AT") or an
+// Accept-Language header value.
+func (b *Bundle) Match(preferences ...string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, pref := range preferences {
+		if pref == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		// The matched tag may carry extensions; report the catalog's own
+		_, index, confidence := b.matcher.Match(tags...)
+		if confidence == language.No {
+			continue
+		}
+		return b.tags[index].String()
+	}
+	return b.defaultLocale.String()
+}
+
+// Translator returns a translator for locale
+func (b *Bundle) Translator(locale string) *Translator {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = b.defaultLocale
+	}
+
+	// Fall back from the locale to its parents, then to the default
+	var chain []string
+	for t := tag; ; t = t.Parent() {
+		chain = append(chain, t.String())
+		if t.IsRoot() {
+			break
+		}
+	}
+	chain = append(chain, b.defaultLocale.String())
+
+	return &Translator{bundle: b, locale: tag.String(), chain: chain, plural: pluralRule(tag.String())}
+}
+
+func (b *Bundle) lookup(chain []string, key string) (message, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, locale := range chain {
+		if m, ok := b.catalogs[locale][key]; ok {
+			return m, true
+		}
+	}
+	return message{}, false
+}
+
+// Translator translates messages for a single locale
+type Translator struct {
+	bundle *Bundle
+	locale string
+	chain  []string
+	plural PluralRule
+}
+
+// Locale returns the translator's locale, e.g. "pt-BR"
+func (t *Translator) Locale() string {
+	return t.locale
+}
+
+// T returns the message for key with placeholders replaced. Missing keys
+// return the key itself, so gaps are visible but not fatal.
+func (t *Translator) T(key string, args Args) string {
+	m, ok := t.bundle.lookup(t.chain, key)
+	if !ok {
+		return key
+	}
+	return Interpolate(m.text, args)
+}
+
+// Lookup is T for callers with a fallback of their own: ok is false when
+// no locale in the chain defines key
+func (t *Translator) Lookup(key string, args Args) (text string, ok bool) {
+	m, ok := t.bundle.lookup(t.chain, key)
+	if !ok {
+		return "", false
+	}
+	return Interpolate(m.text, args), true
+}
+
+// N returns the plural form of key for count. {count} is available as a
+// placeholder alongside args.
+func (t *Translator) N(key string, count int, args Args) string {
+	m, ok := t.bundle.lookup(t.chain, key)
+	if !ok {
+		return key
+	}
+
+	text := m.text
+	if form, ok := m.forms[t.plural(count)]; ok {
+		text = form
+	}
+
+	withCount := make(Args, len(args)+1)
+	for k, v := range args {
+		withCount[k] = v
+	}
+	withCount["count"] = count
+	return Interpolate(text, withCount)
+}
+
+// Interpolate replaces the {name} placeholders in text with args
+func Interpolate(text string, args Args) string {
+	if len(args) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+
+	pairs := make([]string, 0, 2*len(args))
+	for k, v := range args {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case int:
+			s = strconv.Itoa(v)
+		default:
+			s = fmt.Sprint(v)
+		}
+		pairs = append(pairs, "{"+k+"}", s)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+type translatorKey struct{}
+
+// NewContext returns a copy of ctx carrying t
+func NewContext(ctx context.Context, t *Translator) context.Context {
+	return context.WithValue(ctx, translatorKey{}, t)
+}
+
+// FromContext returns the translator stored in ctx, or nil
+func FromContext(ctx context.Context) *Translator {
+	t, _ := ctx.Value(translatorKey{}).(*Translator)
+	return t
+}
+
+// T translates key with the translator in ctx. Without one, the key is
+// returned.
+func T(ctx context.Context, key string, args Args) string {
+	if t := FromContext(ctx); t != nil {
+		return t.T(key, args)
+	}
+	return key
+}
+
+// N is the plural form of T
+func N(ctx context.Context, key string, count int, args Args) string {
+	if t := FromContext(ctx); t != nil {
+		return t.N(key, count, args)
+	}
+	return key
+}
@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"coffee-and-running/src/auth"
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed locales
+var defaultLocales embed.FS
+
+// DefaultLocales returns the built-in catalogs
+func DefaultLocales() fs.FS {
+	sub, _ := fs.Sub(defaultLocales, "locales")
+	return sub
+}
+
+// LocaleFunc returns a request's explicitly chosen locale, or ""
+type LocaleFunc func(r *http.Request) string
+
+// UserLocale reads the "locale" claim of the authenticated caller, i.e.
+// the locale saved in their settings
+func UserLocale(r *http.Request) string {
+	locale, _ := auth.FromContext(r.Context()).String("locale")
+	return locale
+}
+
+// QueryLocale reads the locale from a query parameter, e.g. ?lang=fr
+func QueryLocale(param string) LocaleFunc {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// CookieLocale reads the locale from a cookie set by a language switcher
+func CookieLocale(name string) LocaleFunc {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// Middleware negotiates the locale of each request and stores a
+// translator in the request context. Explicit choices from sources are
+// tried in order before the Accept-Language header; the bundle's default
+// locale is the final fallback.
+func Middleware(bundle *Bundle, sources ...LocaleFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			preferences := make([]string, 0, len(sources)+1)
+			for _, source := range sources {
+				preferences = append(preferences, source(r))
+			}
+			preferences = append(preferences, r.Header.Get("Accept-Language"))
+
+			t := bundle.Translator(bundle.Match(preferences...))
+			w.Header().Set("Content-Language", t.Locale())
+			w.Header().Add("Vary", "Accept-Language")
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package i18n
+
+import "strings"
+
+// Plural categories, as defined by CLDR
+const (
+	Zero  = "zero"
+	One   = "one"
+	Two   = "two"
+	Few   = "few"
+	Many  = "many"
+	Other = "other"
+)
+
+// PluralRule maps a count to a plural category
+type PluralRule func(n int) string
+
+// pluralRules covers the CLDR cardinal rules for integers in common
+// languages. Languages not listed use the English rule.
+var pluralRules = map[string]PluralRule{
+	"en": oneOther, "de": oneOther, "nl": oneOther, "sv": oneOther, "da": oneOther,
+	"no": oneOther, "nb": oneOther, "fi": oneOther, "it": oneOther, "es": oneOther,
+	"el": oneOther, "hu": oneOther, "tr": oneOther, "bg": oneOther, "et": oneOther,
+
+	"fr": zeroOneOther, "pt": zeroOneOther,
+
+	"ja": otherOnly, "zh": otherOnly, "ko": otherOnly, "vi": otherOnly,
+	"th": otherOnly, "id": otherOnly, "ms": otherOnly,
+
+	"ru": slavic, "uk": slavic, "be": slavic,
+	"pl": polish,
+	"cs": czech, "sk": czech,
+	"ar": arabic,
+}
+
+// RegisterPluralRule sets the rule for a base language such as "cy".
+// Call it at startup, before translating.
+func RegisterPluralRule(lang string, rule PluralRule) {
+	pluralRules[strings.ToLower(lang)] = rule
+}
+
+func pluralRule(locale string) PluralRule {
+	base, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	if rule, ok := pluralRules[base]; ok {
+		return rule
+	}
+	return oneOther
+}
+
+func oneOther(n int) string {
+	if n == 1 {
+		return One
+	}
+	return Other
+}
+
+func zeroOneOther(n int) string {
+	if n == 0 || n == 1 {
+		return One
+	}
+	return Other
+}
+
+func otherOnly(int) string {
+	return Other
+}
+
+func slavic(n int) string {
+	mod10, mod100 := n%10, n%100
+	switch {
+	case mod10 == 1 && mod100 != 11:
+		return One
+	case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+		return Few
+	default:
+		return Many
+	}
+}
+
+func polish(n int) string {
+	mod10, mod100 := n%10, n%100
+	switch {
+	case n == 1:
+		return One
+	case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+		return Few
+	default:
+		return Many
+	}
+}
+
+func czech(n int) string {
+	switch {
+	case n == 1:
+		return One
+	case n >= 2 && n <= 4:
+		return Few
+	default:
+		return Other
+	}
+}
+
+func arabic(n int) string {
+	mod100 := n % 100
+	switch {
+	case n == 0:
+		return Zero
+	case n == 1:
+		return One
+	case n == 2:
+		return Two
+	case mod100 >= 3 && mod100 <= 10:
+		return Few
+	case mod100 >= 11:
+		return Many
+	default:
+		return Other
+	}
+}
@@ -0,0 +1,173 @@
+package imports
+
+import (
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/operations"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// xlsxType is the media type of .xlsx files
+const xlsxType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Handler accepts a multipart upload of a CSV or .xlsx file in the
+// imports.form_field field and imports it into schema's table as an
+// operation of kind "<table>_import". It responds 202 with the operation,
+// whose result is the Report once the import finishes:
+//
+//	r.With(auth.Require("products:write")).Post("/products/import", importer.Handler(schema))
+func (im *Importer) Handler(schema Schema) http.HandlerFunc {
+	kind := schema.Table + "_import"
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, im.config.MaxFileSize)
+		upload, err := im.spool(r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("file exceeds %d bytes", im.config.MaxFileSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if apperr.KindOf(err) == "" {
+				im.logger.Error("failed to receive upload", zap.Error(err))
+			}
+			apperr.WriteHTTP(w, err)
+			return
+		}
+
+		op, err := im.ops.Enqueue(r.Context(), kind, func(ctx context.Context, progress *operations.Progress) (interface{}, error) {
+			defer upload.remove()
+			return im.runUpload(ctx, schema, upload, progress)
+		})
+		if err != nil {
+			upload.remove()
+			if apperr.KindOf(err) == "" {
+				im.logger.Error("failed to start import", zap.String("table", schema.Table), zap.Error(err))
+			}
+			apperr.WriteHTTP(w, err)
+			return
+		}
+		im.ops.WriteAccepted(w, op)
+	}
+}
+
+// upload is a received file, kept on disk until its import finishes
+type upload struct {
+	path string
+	size int64
+	xlsx bool
+}
+
+func (u *upload) remove() {
+	os.Remove(u.path)
+}
+
+// spool copies the file part of the request to a temporary file, since the
+// import outlives the request
+func (im *Importer) spool(r *http.Request) (*upload, error) {
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return nil, apperr.Wrap(err, apperr.Invalid, "imports", "request must be multipart/form-data")
+	}
+	for {
+		part, err := parts.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, apperr.New(apperr.Invalid, "imports", "missing file field "+im.config.FormField)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != im.config.FormField {
+			part.Close()
+			continue
+		}
+
+		f, err := os.CreateTemp("", "import-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		u := &upload{path: f.Name(), xlsx: isXLSX(part.FileName(), part.Header.Get("Content-Type"))}
+		u.size, err = io.Copy(f, part)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			u.remove()
+			return nil, err
+		}
+		return u, nil
+	}
+}
+
+func isXLSX(filename, contentType string) bool {
+	if strings.EqualFold(filepath.Ext(filename), ".xlsx") {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == xlsxType
+}
+
+// runUpload imports the spooled file, reporting progress as the share of
+// the file read so far
+func (im *Importer) runUpload(ctx context.Context, schema Schema, u *upload, progress *operations.Progress) (*Report, error) {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer f.Close()
+
+	pos := &position{file: f}
+	var src Source
+	if u.xlsx {
+		if src, err = XLSX(pos, u.size); err != nil {
+			return nil, apperr.Wrap(err, apperr.Invalid, "imports", "file is not a valid .xlsx workbook")
+		}
+		// Opening the workbook read its directory at the end of the file
+		pos.max = 0
+	} else {
+		src = CSV(pos)
+	}
+
+	last := -1
+	return im.Run(ctx, schema, src, func(rows int) {
+		percent := 0
+		if u.size > 0 {
+			percent = int(pos.max * 100 / u.size)
+		}
+		if percent == last {
+			return
+		}
+		last = percent
+		if err := progress.Update(ctx, percent, fmt.Sprintf("%d rows read", rows)); err != nil {
+			im.logger.Warn("failed to record import progress", zap.Error(err))
+		}
+	})
+}
+
+// position tracks how far into the file reads have got. Reads of an
+// .xlsx file jump around the archive, but the sheet, which is most of it,
+// is read front to back.
+type position struct {
+	file *os.File
+	max  int64
+}
+
+func (p *position) Read(b []byte) (int, error) {
+	n, err := p.file.Read(b)
+	p.max += int64(n)
+	return n, err
+}
+
+func (p *position) ReadAt(b []byte, off int64) (int, error) {
+	n, err := p.file.ReadAt(b, off)
+	p.max = max(p.max, off+int64(n))
+	return n, err
+}
@@ -0,0 +1,361 @@
+// Package imports loads CSV and Excel files into database tables. Rows are
+// streamed from the file, mapped to columns by a Schema and validated one
+// by one; valid rows are inserted in batches and invalid ones collected
+// into a Report, so one bad row doesn't sink a 100k-row upload. Uploads
+// run as operations, which clients poll for progress and the report.
+package imports
+
+import (
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/operations"
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// maxParams is the most parameters PostgreSQL accepts in one statement
+const maxParams = 65535
+
+// Field maps a column of the file to a table column
+type Field struct {
+	// Column is the table column
+	Column string
+	// Header is the file's column header, matched case-insensitively;
+	// it defaults to Column
+	Header string
+	// Required rejects rows where the field is empty. Empty optional
+	// fields are inserted as NULL.
+	Required bool
+	// Parse converts the trimmed text; nil inserts the text as is
+	Parse func(string) (interface{}, error)
+}
+
+func (f Field) header() string {
+	if f.Header == "" {
+		return f.Column
+	}
+	return f.Header
+}
+
+// Schema describes how a file maps onto a table:
+//
+//	schema := imports.Schema{
+//		Table: "products",
+//		Fields: []imports.Field{
+//			{Column: "sku", Required: true},
+//			{Column: "name", Required: true},
+//			{Column: "price_cents", Header: "price", Parse: imports.Int},
+//		},
+//		Conflict: "ON CONFLICT (sku) DO NOTHING",
+//	}
+type Schema struct {
+	Table  string
+	Fields []Field
+	// Validate checks a row across fields after each field parsed, keyed
+	// by column
+	Validate func(row map[string]interface{}) error
+	// Conflict is appended to every INSERT, e.g. an ON CONFLICT clause
+	Conflict string
+}
+
+// RowError is a row that was not imported. Row counts the file's rows
+// from 1, the header included.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the outcome of an import
+type Report struct {
+	Rows     int        `json:"rows"` // data rows read
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors,omitempty"`
+	// Aborted is set when more than imports.max_errors rows failed; rows
+	// after that were not read
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+func (r *Report) fail(e RowError, maxErrors int) {
+	r.Failed++
+	if len(r.Errors) < maxErrors {
+		r.Errors = append(r.Errors, e)
+	}
+	r.Aborted = r.Failed > maxErrors
+}
+
+// Source yields a file's rows, the header first, and io.EOF after the last
+type Source interface {
+	Read() ([]string, error)
+}
+
+// Importer runs imports through the storage engine, as operations when
+// started by an upload
+type Importer struct {
+	config *config.ImportsConfig
+	engine storage.Engine
+	ops    *operations.Manager
+	logger *zap.Logger
+	stats  metrics.Agent
+}
+
+// New creates an importer
+func New(cfg *config.ImportsConfig, engine storage.Engine, ops *operations.Manager, logger *zap.Logger, stats metrics.Agent) *Importer {
+	return &Importer{
+		config: cfg,
+		engine: engine,
+		ops:    ops,
+		logger: logger.With(zap.String("component", "imports")),
+		stats:  stats,
+	}
+}
+
+// Run imports every row of src into schema's table. progress, which may be
+// nil, is called after each batch with the rows read so far. Rows that
+// fail to parse, validate or insert are reported rather than returned as
+// errors; an error means the file couldn't be read at all, e.g. a
+// required header is missing.
+func (im *Importer) Run(ctx context.Context, schema Schema, src Source, progress func(rows int)) (*Report, error) {
+	header, err := src.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, apperr.New(apperr.Invalid, "imports", "file is empty")
+	}
+	if err != nil {
+		return nil, apperr.Wrap(err, apperr.Invalid, "imports", "file could not be read")
+	}
+	if len(schema.Fields) == 0 {
+		return nil, fmt.Errorf("import into %s has no fields", schema.Table)
+	}
+	index, err := mapHeader(schema, header)
+	if err != nil {
+		return nil, err
+	}
+	size := min(im.config.BatchSize, maxParams/len(schema.Fields))
+
+	report := &Report{}
+	batch := newBatch(schema)
+	flush := func() error {
+		if len(batch.rows) == 0 {
+			return nil
+		}
+		if err := im.insert(ctx, batch, report); err != nil {
+			return err
+		}
+		batch.rows, batch.lines = batch.rows[:0], batch.lines[:0]
+		if progress != nil {
+			progress(report.Rows)
+		}
+		return nil
+	}
+
+	line := 1
+	for !report.Aborted {
+		record, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			report.fail(RowError{Row: line, Message: "row could not be read: " + err.Error()}, im.config.MaxErrors)
+			continue
+		}
+		report.Rows++
+		values, rowErr := parseRow(schema, index, record)
+		if rowErr != nil {
+			rowErr.Row = line
+			report.fail(*rowErr, im.config.MaxErrors)
+			continue
+		}
+		batch.add(values, line)
+		if len(batch.rows) >= size {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+
+	im.stats.Count("imports."+schema.Table+".imported", report.Imported)
+	im.stats.Count("imports."+schema.Table+".failed", report.Failed)
+	im.logger.Info("import finished",
+		zap.String("table", schema.Table),
+		zap.Int("rows", report.Rows),
+		zap.Int("imported", report.Imported),
+		zap.Int("failed", report.Failed),
+		zap.Bool("aborted", report.Aborted))
+	return report, nil
+}
+
+// mapHeader finds each field's position in the header row
+func mapHeader(schema Schema, header []string) ([]int, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+
+	index := make([]int, len(schema.Fields))
+	var missing []string
+	for i, f := range schema.Fields {
+		pos, ok := positions[strings.ToLower(f.header())]
+		switch {
+		case ok:
+			index[i] = pos
+		case f.Required:
+			missing = append(missing, f.header())
+		default:
+			index[i] = -1
+		}
+	}
+	if len(missing) > 0 {
+		return nil, apperr.New(apperr.Invalid, "imports", "missing required columns: "+strings.Join(missing, ", "))
+	}
+	return index, nil
+}
+
+// parseRow converts a record into column values in field order
+func parseRow(schema Schema, index []int, record []string) ([]interface{}, *RowError) {
+	values := make([]interface{}, len(schema.Fields))
+	for i, f := range schema.Fields {
+		text := ""
+		if index[i] >= 0 && index[i] < len(record) {
+			text = strings.TrimSpace(record[index[i]])
+		}
+		if text == "" {
+			if f.Required {
+				return nil, &RowError{Field: f.header(), Message: "is required"}
+			}
+			continue
+		}
+		if f.Parse == nil {
+			values[i] = text
+			continue
+		}
+		v, err := f.Parse(text)
+		if err != nil {
+			return nil, &RowError{Field: f.header(), Message: err.Error()}
+		}
+		values[i] = v
+	}
+
+	if schema.Validate != nil {
+		row := make(map[string]interface{}, len(values))
+		for i, f := range schema.Fields {
+			row[f.Column] = values[i]
+		}
+		if err := schema.Validate(row); err != nil {
+			return nil, &RowError{Message: err.Error()}
+		}
+	}
+	return values, nil
+}
+
+type batch struct {
+	schema  Schema
+	columns string
+	rows    [][]interface{}
+	lines   []int
+}
+
+func newBatch(schema Schema) *batch {
+	columns := make([]string, len(schema.Fields))
+	for i, f := range schema.Fields {
+		columns[i] = pq.QuoteIdentifier(f.Column)
+	}
+	return &batch{schema: schema, columns: strings.Join(columns, ", ")}
+}
+
+func (b *batch) add(values []interface{}, line int) {
+	b.rows = append(b.rows, values)
+	b.lines = append(b.lines, line)
+}
+
+// statement builds a multi-row INSERT for rows
+func (b *batch) statement(rows [][]interface{}) (string, []interface{}) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "INSERT INTO %s (%s) VALUES ", pq.QuoteIdentifier(b.schema.Table), b.columns)
+	args := make([]interface{}, 0, len(rows)*len(b.schema.Fields))
+	for i, row := range rows {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteByte('(')
+		for j, v := range row {
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			args = append(args, v)
+			sb.WriteString("$" + strconv.Itoa(len(args)))
+		}
+		sb.WriteByte(')')
+	}
+	if b.schema.Conflict != "" {
+		sb.WriteString(" " + b.schema.Conflict)
+	}
+	return sb.String(), args
+}
+
+// insert writes the batch in one statement. If the database rejects it,
+// the rows are retried one by one so the report names the offending rows
+// and the rest still land.
+func (im *Importer) insert(ctx context.Context, b *batch, report *Report) error {
+	query, args := b.statement(b.rows)
+	start := time.Now()
+	_, err := im.engine.Exec(ctx, query, args...)
+	im.stats.Timing("imports."+b.schema.Table+".batch", time.Since(start))
+	if err == nil {
+		report.Imported += len(b.rows)
+		return nil
+	}
+	if ctx.Err() != nil || apperr.KindOf(err) == apperr.Unavailable {
+		return fmt.Errorf("failed to insert into %s: %w", b.schema.Table, err)
+	}
+
+	for i, row := range b.rows {
+		query, args := b.statement([][]interface{}{row})
+		if _, err := im.engine.Exec(ctx, query, args...); err != nil {
+			if ctx.Err() != nil || apperr.KindOf(err) == apperr.Unavailable {
+				return fmt.Errorf("failed to insert into %s: %w", b.schema.Table, err)
+			}
+			if apperr.KindOf(err) == "" {
+				im.logger.Warn("import row rejected", zap.String("table", b.schema.Table), zap.Int("row", b.lines[i]), zap.Error(err))
+			}
+			report.fail(RowError{Row: b.lines[i], Message: rowMessage(err)}, im.config.MaxErrors)
+			continue
+		}
+		report.Imported++
+	}
+	return nil
+}
+
+// rowMessage describes a rejected row without leaking driver details
+// beyond the violated constraint or column
+func rowMessage(err error) string {
+	msg := apperr.Message(err)
+	if msg == "" {
+		return "could not be inserted"
+	}
+	meta := apperr.MetaOf(err)
+	switch {
+	case meta["constraint"] != "":
+		return msg + " (" + meta["constraint"] + ")"
+	case meta["column"] != "":
+		return msg + " (" + meta["column"] + ")"
+	}
+	return msg
+}
@@ -0,0 +1,72 @@
+package imports
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Int parses a whole number
+func Int(s string) (interface{}, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, errors.New("must be a whole number")
+	}
+	return n, nil
+}
+
+// Float parses a decimal number
+func Float(s string) (interface{}, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, errors.New("must be a number")
+	}
+	return f, nil
+}
+
+// Bool parses true/false, yes/no and 1/0, in any case
+func Bool(s string) (interface{}, error) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "y", "1":
+		return true, nil
+	case "false", "no", "n", "0":
+		return false, nil
+	}
+	return nil, errors.New("must be true or false")
+}
+
+// excelEpoch is day zero of Excel's date serial numbers
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Time returns a parser for times in any of layouts, tried in order. It
+// also accepts the serial day numbers Excel stores dates as, which is
+// what date cells read from an .xlsx file hold.
+//
+//	{Column: "born_on", Parse: imports.Time("2006-01-02", "02/01/2006")}
+func Time(layouts ...string) func(string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		if days, err := strconv.ParseFloat(s, 64); err == nil && days > 0 {
+			return excelEpoch.Add(time.Duration(days * float64(24*time.Hour))).Round(time.Second), nil
+		}
+		return nil, errors.New("must be a date like " + layouts[0])
+	}
+}
+
+// OneOf returns a parser accepting only values, matched case-insensitively
+// and stored as listed
+func OneOf(values ...string) func(string) (interface{}, error) {
+	return func(s string) (interface{}, error) {
+		i := slices.IndexFunc(values, func(v string) bool { return strings.EqualFold(v, s) })
+		if i < 0 {
+			return nil, errors.New("must be one of " + strings.Join(values, ", "))
+		}
+		return values[i], nil
+	}
+}
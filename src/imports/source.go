@@ -0,0 +1,250 @@
+package imports
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CSV reads comma-separated rows from r. Rows may have fewer or more
+// fields than the header; missing fields are empty.
+func CSV(r io.Reader) Source {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return reader
+}
+
+// ErrNoSheet is returned for .xlsx files without a worksheet
+var ErrNoSheet = errors.New("imports: workbook has no worksheet")
+
+// XLSX reads the rows of the first worksheet of an Excel workbook. Cells
+// are read as the text or number they hold: formulas give their cached
+// result and dates their serial number, which Time parses. Rows are
+// streamed, but the shared string table is held in memory.
+func XLSX(r io.ReaderAt, size int64) (Source, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an .xlsx file: %w", err)
+	}
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	sheet, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNoSheet
+	}
+	var strs []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if strs, err = sharedStrings(f); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := sheet.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxSource{decoder: xml.NewDecoder(rc), closer: rc, strings: strs}, nil
+}
+
+// firstSheet resolves the first sheet listed in the workbook to its part
+func firstSheet(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(files, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", ErrNoSheet
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", ErrNoSheet
+}
+
+func decodePart(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("not an .xlsx file: %s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// sharedStrings reads the workbook's string table; rich text runs are
+// joined
+func sharedStrings(f *zip.File) ([]string, error) {
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(&table); err != nil {
+		return nil, fmt.Errorf("failed to read shared strings: %w", err)
+	}
+
+	strs := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		if len(item.Runs) == 0 {
+			strs[i] = item.Text
+			continue
+		}
+		var b strings.Builder
+		for _, run := range item.Runs {
+			b.WriteString(run.Text)
+		}
+		strs[i] = b.String()
+	}
+	return strs, nil
+}
+
+type xlsxSource struct {
+	decoder *xml.Decoder
+	closer  io.Closer
+	strings []string
+	// next is the number of the row Read returns next; rows missing from
+	// the sheet are empty
+	next    int
+	pending *xlsxRow
+	err     error
+}
+
+type xlsxRow struct {
+	Number int `xml:"r,attr"`
+	Cells  []struct {
+		Ref    string `xml:"r,attr"`
+		Type   string `xml:"t,attr"`
+		Value  string `xml:"v"`
+		Inline string `xml:"is>t"`
+	} `xml:"c"`
+}
+
+func (s *xlsxSource) Read() ([]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.pending == nil {
+		row, err := s.nextRow()
+		if err != nil {
+			// Report a broken file once, then stop
+			s.err = io.EOF
+			s.closer.Close()
+			return nil, err
+		}
+		s.pending = row
+	}
+	s.next++
+	if s.pending.Number > s.next {
+		return []string{}, nil
+	}
+	row := s.pending
+	s.pending = nil
+	return s.cells(row)
+}
+
+func (s *xlsxSource) nextRow() (*xlsxRow, error) {
+	for {
+		tok, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row xlsxRow
+		if err := s.decoder.DecodeElement(&row, &start); err != nil {
+			return nil, err
+		}
+		if row.Number == 0 {
+			row.Number = s.next + 1
+		}
+		return &row, nil
+	}
+}
+
+func (s *xlsxSource) cells(row *xlsxRow) ([]string, error) {
+	var record []string
+	for i, c := range row.Cells {
+		col := i
+		if c.Ref != "" {
+			col = column(c.Ref)
+		}
+		for len(record) <= col {
+			record = append(record, "")
+		}
+		switch c.Type {
+		case "s":
+			n, err := strconv.Atoi(c.Value)
+			if err != nil || n < 0 || n >= len(s.strings) {
+				return nil, fmt.Errorf("cell %s refers to a missing shared string", c.Ref)
+			}
+			record[col] = s.strings[n]
+		case "inlineStr":
+			record[col] = c.Inline
+		default:
+			record[col] = c.Value
+		}
+	}
+	return record, nil
+}
+
+// column converts a cell reference such as "AB12" to a zero-based column
+func column(ref string) int {
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
@@ -0,0 +1,274 @@
+package ipfilter
+
+import (
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Decision is the outcome of checking an address
+type Decision struct {
+	Allowed bool
+	// Rule is the rule that refused the address, or nil when no allow rule
+	// covered it
+	Rule *Rule
+}
+
+// Filter checks addresses against the rules in config and those loaded
+// from its provider, which are refreshed in the background so checking a
+// request never touches the database
+type Filter struct {
+	config   *config.IPFilterConfig
+	static   []Rule
+	provider Provider
+	logger   *zap.Logger
+	stats    metrics.Agent
+
+	mu      sync.RWMutex
+	dynamic []Rule
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewFilter creates a filter for cfg's lists and loads the rules from
+// provider, which may be nil to use only the config
+func NewFilter(cfg *config.IPFilterConfig, provider Provider, logger *zap.Logger, stats metrics.Agent) (*Filter, error) {
+	f := &Filter{
+		config:   cfg,
+		provider: provider,
+		logger:   logger.With(zap.String("component", "ipfilter")),
+		stats:    stats,
+	}
+	for _, list := range []struct {
+		action  string
+		entries []string
+	}{{ActionAllow, cfg.Allow}, {ActionDeny, cfg.Deny}} {
+		for _, entry := range list.entries {
+			prefix, err := ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip_filter.%s entry: %w", list.action, err)
+			}
+			f.static = append(f.static, Rule{Prefix: prefix, Action: list.action, Source: SourceConfig})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := f.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Refresh reloads the rules from the provider
+func (f *Filter) Refresh(ctx context.Context) error {
+	if f.provider == nil {
+		return nil
+	}
+	rules, err := f.provider.Rules(ctx)
+	if err != nil {
+		f.stats.Increment("ipfilter.refresh.error")
+		return fmt.Errorf("failed to load ip rules: %w", err)
+	}
+
+	f.mu.Lock()
+	f.dynamic = rules
+	f.mu.Unlock()
+	f.stats.Gauge("ipfilter.rules", len(f.static)+len(rules))
+	return nil
+}
+
+// Rules returns the rules in effect: config first, then the provider's
+func (f *Filter) Rules() []Rule {
+	now := time.Now()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(f.static)+len(f.dynamic))
+	rules = append(rules, f.static...)
+	for _, rule := range f.dynamic {
+		if rule.active(now) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// Check decides whether addr may reach the service. A deny rule covering
+// it wins; otherwise it is allowed unless allow rules exist and none
+// covers it. Rules are scanned in order, which stays cheap for the few
+// hundred ranges an incident list tends to hold.
+func (f *Filter) Check(addr netip.Addr) Decision {
+	addr = addr.Unmap()
+	now := time.Now()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	hasAllow, allowed := false, false
+	for _, rules := range [][]Rule{f.static, f.dynamic} {
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.active(now) {
+				continue
+			}
+			if rule.Action == ActionAllow {
+				hasAllow = true
+				allowed = allowed || rule.Prefix.Contains(addr)
+				continue
+			}
+			if rule.Prefix.Contains(addr) {
+				denied := *rule
+				return Decision{Allowed: false, Rule: &denied}
+			}
+		}
+	}
+	return Decision{Allowed: !hasAllow || allowed}
+}
+
+// Add saves a rule through the store and applies it to this instance
+// immediately; other instances pick it up on their next refresh
+func (f *Filter) Add(ctx context.Context, rule Rule) (Rule, error) {
+	store, ok := f.provider.(Store)
+	if !ok {
+		return rule, ErrReadOnly
+	}
+	rule, err := store.Add(ctx, rule)
+	if err != nil {
+		return rule, fmt.Errorf("failed to save ip rule for %s: %w", rule.Prefix, err)
+	}
+
+	f.mu.Lock()
+	dynamic := make([]Rule, 0, len(f.dynamic)+1)
+	for _, existing := range f.dynamic {
+		if existing.ID != rule.ID {
+			dynamic = append(dynamic, existing)
+		}
+	}
+	f.dynamic = append(dynamic, rule)
+	f.mu.Unlock()
+
+	f.logger.Info("ip rule added",
+		zap.Int64("id", rule.ID),
+		zap.Stringer("cidr", rule.Prefix),
+		zap.String("action", rule.Action),
+		zap.String("reason", rule.Reason),
+		zap.Time("expires_at", rule.ExpiresAt),
+		zap.String("created_by", rule.CreatedBy))
+	return rule, nil
+}
+
+// Delete removes a rule through the store and from this instance
+func (f *Filter) Delete(ctx context.Context, id int64, deletedBy string) error {
+	store, ok := f.provider.(Store)
+	if !ok {
+		return ErrReadOnly
+	}
+	if err := store.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	dynamic := make([]Rule, 0, len(f.dynamic))
+	for _, existing := range f.dynamic {
+		if existing.ID != id {
+			dynamic = append(dynamic, existing)
+		}
+	}
+	f.dynamic = dynamic
+	f.mu.Unlock()
+
+	f.logger.Info("ip rule deleted", zap.Int64("id", id), zap.String("deleted_by", deletedBy))
+	return nil
+}
+
+// Handler refuses requests from filtered addresses with 403. Paths under
+// ip_filter.exempt_paths, such as health checks, are never filtered. It
+// reads the client address real_ip resolved, which only trusts forwarding
+// headers from server.real_ip.trusted_proxies, or else the peer address.
+func (f *Filter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range f.config.ExemptPaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		host := clientip.FromRequest(r)
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			// Unix sockets and the like have no address to filter
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d := f.Check(addr)
+		if d.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reason := "not_allowed"
+		fields := []zap.Field{zap.String("ip", host), zap.String("path", r.URL.Path)}
+		if d.Rule != nil {
+			reason = "denied"
+			fields = append(fields, zap.Stringer("rule", d.Rule.Prefix), zap.String("source", d.Rule.Source))
+		}
+		f.stats.Increment("ipfilter.blocked." + reason)
+		f.logger.Debug("blocked request by ip", fields...)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "forbidden"})
+	})
+}
+
+// Start refreshes the rules every ip_filter.refresh_interval
+func (f *Filter) Start() error {
+	if f.provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		ticker := time.NewTicker(f.config.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.Refresh(ctx); err != nil && ctx.Err() == nil {
+					// Keep enforcing the last good rules
+					f.logger.Error("ip rule refresh failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the background refresh
+func (f *Filter) Close() error {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.wg.Wait()
+	return nil
+}
@@ -0,0 +1,129 @@
+package ipfilter
+
+import (
+	"coffee-and-running/src/auth"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Handler exposes the IP rule admin API
+type Handler struct {
+	filter *Filter
+	logger *zap.Logger
+}
+
+// NewHandler creates the admin handler
+func NewHandler(filter *Filter, logger *zap.Logger) *Handler {
+	return &Handler{
+		filter: filter,
+		logger: logger.With(zap.String("component", "ipfilter.handler")),
+	}
+}
+
+// Mount registers GET and POST /admin/ip-rules, DELETE
+// /admin/ip-rules/{id} and POST /admin/ip-rules/reload
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/ip-rules", h.listRules)
+	r.Post("/admin/ip-rules", h.addRule)
+	r.Delete("/admin/ip-rules/{id}", h.deleteRule)
+	r.Post("/admin/ip-rules/reload", h.reload)
+}
+
+func (h *Handler) listRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": h.filter.Rules()})
+}
+
+// addRule takes {"cidr": "203.0.113.0/24", "action": "deny", "reason":
+// "...", "ttl": "1h"}; without a ttl the rule stays until deleted
+func (h *Handler) addRule(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CIDR   string `json:"cidr"`
+		Action string `json:"action"`
+		Reason string `json:"reason"`
+		TTL    string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be a JSON rule")
+		return
+	}
+	prefix, err := ParsePrefix(req.CIDR)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Action != ActionAllow && req.Action != ActionDeny {
+		writeError(w, http.StatusBadRequest, `action must be "allow" or "deny"`)
+		return
+	}
+	rule := Rule{Prefix: prefix, Action: req.Action, Reason: req.Reason, CreatedBy: auth.Subject(r)}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "ttl must be a positive duration, e.g. 1h")
+			return
+		}
+		rule.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	rule, err = h.filter.Add(r.Context(), rule)
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		writeError(w, http.StatusConflict, "ip rules are read-only without ip_filter.database")
+		return
+	case err != nil:
+		h.logger.Error("failed to add ip rule", zap.Stringer("cidr", prefix), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to add rule")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"rule": rule})
+}
+
+func (h *Handler) deleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "id must be a number")
+		return
+	}
+
+	err = h.filter.Delete(r.Context(), id, auth.Subject(r))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	case errors.Is(err, ErrReadOnly):
+		writeError(w, http.StatusConflict, "ip rules are read-only without ip_filter.database")
+		return
+	case err != nil:
+		h.logger.Error("failed to delete ip rule", zap.Int64("id", id), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to delete rule")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reload applies rule changes made on other instances or directly in the
+// database without waiting for the next refresh
+func (h *Handler) reload(w http.ResponseWriter, r *http.Request) {
+	if err := h.filter.Refresh(r.Context()); err != nil {
+		h.logger.Error("failed to reload ip rules", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to reload rules")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"rules": len(h.filter.Rules())})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
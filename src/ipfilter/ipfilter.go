@@ -0,0 +1,165 @@
+// Package ipfilter admits or refuses requests by client address before
+// anything else looks at them. Allow and deny lists of addresses and CIDR
+// ranges come from config and, optionally, the ip_rules table, which the
+// admin API edits at runtime so an abusive source can be cut off during an
+// incident without a deploy.
+package ipfilter
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Actions a rule takes
+const (
+	// ActionAllow admits the range. Once any allow rule exists, addresses
+	// outside every allowed range are refused.
+	ActionAllow = "allow"
+	// ActionDeny refuses the range, even where an allow rule covers it
+	ActionDeny = "deny"
+)
+
+// Sources a rule comes from
+const (
+	SourceConfig   = "config"
+	SourceDatabase = "database"
+)
+
+// Rule allows or denies an address range
+type Rule struct {
+	ID        int64        `json:"id,omitempty"`
+	Prefix    netip.Prefix `json:"cidr"`
+	Action    string       `json:"action"`
+	Reason    string       `json:"reason,omitempty"`
+	CreatedBy string       `json:"created_by,omitempty"`
+	CreatedAt time.Time    `json:"created_at,omitzero"`
+	ExpiresAt time.Time    `json:"expires_at,omitzero"` // zero never expires
+	Source    string       `json:"source"`
+}
+
+// active reports whether the rule applies at now
+func (r Rule) active(now time.Time) bool {
+	return r.ExpiresAt.IsZero() || now.Before(r.ExpiresAt)
+}
+
+// ParsePrefix parses a CIDR range ("10.0.0.0/8") or a single address
+// ("203.0.113.7"). Ranges with host bits set are refused rather than
+// silently widened.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not an IP address or CIDR range", s)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IP address or CIDR range", s)
+	}
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, fmt.Errorf("%q has host bits set; did you mean %s?", s, prefix.Masked())
+	}
+	return prefix, nil
+}
+
+// Provider loads rules
+type Provider interface {
+	Rules(ctx context.Context) ([]Rule, error)
+}
+
+// Store is a Provider whose rules can be changed at runtime
+type Store interface {
+	Provider
+	Add(ctx context.Context, rule Rule) (Rule, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// ErrReadOnly is returned when changing rules without a store
+var ErrReadOnly = errors.New("ipfilter: rules are read-only without ip_filter.database")
+
+// ErrNotFound is returned when deleting a rule that doesn't exist
+var ErrNotFound = errors.New("ipfilter: rule not found")
+
+// DatabaseProvider stores rules in the ip_rules table
+type DatabaseProvider struct {
+	engine storage.Engine
+}
+
+var _ Store = (*DatabaseProvider)(nil)
+
+// NewDatabaseProvider creates a provider backed by the ip_rules table
+func NewDatabaseProvider(engine storage.Engine) *DatabaseProvider {
+	return &DatabaseProvider{engine: engine}
+}
+
+// Rules loads the rules that haven't expired
+func (p *DatabaseProvider) Rules(ctx context.Context) ([]Rule, error) {
+	rows, err := p.engine.Query(ctx,
+		`SELECT id, cidr, action, reason, created_by, created_at, expires_at
+		 FROM ip_rules
+		 WHERE expires_at IS NULL OR expires_at > NOW()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		var cidr string
+		var createdBy sql.NullString
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&rule.ID, &cidr, &rule.Action, &rule.Reason, &createdBy, &rule.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if rule.Prefix, err = ParsePrefix(cidr); err != nil {
+			return nil, fmt.Errorf("invalid ip rule %d: %w", rule.ID, err)
+		}
+		rule.CreatedBy = createdBy.String
+		rule.ExpiresAt = expiresAt.Time
+		rule.Source = SourceDatabase
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// Add creates a rule, or replaces the reason and expiry of the rule with
+// the same range and action
+func (p *DatabaseProvider) Add(ctx context.Context, rule Rule) (Rule, error) {
+	var expiresAt sql.NullTime
+	if !rule.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: rule.ExpiresAt, Valid: true}
+	}
+	err := p.engine.QueryRow(ctx,
+		`INSERT INTO ip_rules (cidr, action, reason, created_by, expires_at)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		 ON CONFLICT (cidr, action) DO UPDATE SET
+			reason = EXCLUDED.reason,
+			created_by = EXCLUDED.created_by,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		 RETURNING id, created_at`,
+		rule.Prefix.String(), rule.Action, rule.Reason, rule.CreatedBy, expiresAt).Scan(&rule.ID, &rule.CreatedAt)
+	rule.Source = SourceDatabase
+	return rule, err
+}
+
+// Delete removes a rule
+func (p *DatabaseProvider) Delete(ctx context.Context, id int64) error {
+	result, err := p.engine.Exec(ctx, `DELETE FROM ip_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
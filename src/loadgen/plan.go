@@ -0,0 +1,127 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPlan is returned for plans that can't be run
+var ErrInvalidPlan = errors.New("loadgen: invalid plan")
+
+// Plan is a request mix and how hard to send it
+type Plan struct {
+	// Target is the base URL of the instance, e.g. http://localhost:3000
+	Target string `yaml:"target"`
+	// Rate is the requests per second across all workers; 0 sends as fast
+	// as Concurrency allows
+	Rate float64 `yaml:"rate"`
+	// Concurrency is the number of requests in flight at most
+	Concurrency int           `yaml:"concurrency"`
+	Duration    time.Duration `yaml:"duration"`
+	// Timeout bounds each request
+	Timeout time.Duration `yaml:"timeout"`
+	// Headers are sent with every request
+	Headers    map[string]string `yaml:"headers"`
+	Requests   []Request         `yaml:"requests"`
+	Thresholds Thresholds        `yaml:"thresholds"`
+}
+
+// Request is one entry of the mix
+type Request struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+	// Weight is the request's share of the mix relative to the others
+	Weight int `yaml:"weight"`
+	// Expect lists the statuses that count as success; empty means any
+	// 2xx or 3xx
+	Expect []int `yaml:"expect"`
+}
+
+// Thresholds fail the run when exceeded, so loadgen can gate a deploy
+type Thresholds struct {
+	ErrorRate float64       `yaml:"error_rate"`
+	P99       time.Duration `yaml:"p99"`
+}
+
+// DefaultPlan sends GET / at 10 requests per second for 30 seconds
+func DefaultPlan() *Plan {
+	return &Plan{
+		Target:      "http://localhost:3000",
+		Rate:        10,
+		Concurrency: 10,
+		Duration:    30 * time.Second,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// LoadPlan reads a YAML plan over the defaults
+func LoadPlan(filename string) (*Plan, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	plan := DefaultPlan()
+	if err := yaml.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	return plan, nil
+}
+
+// Validate fills in request defaults and checks the plan can run
+func (p *Plan) Validate() error {
+	if !strings.HasPrefix(p.Target, "http://") && !strings.HasPrefix(p.Target, "https://") {
+		return fmt.Errorf("%w: target must be an http(s) URL", ErrInvalidPlan)
+	}
+	p.Target = strings.TrimSuffix(p.Target, "/")
+	if p.Concurrency < 1 {
+		return fmt.Errorf("%w: concurrency must be at least 1", ErrInvalidPlan)
+	}
+	if p.Rate < 0 || p.Duration <= 0 {
+		return fmt.Errorf("%w: rate must not be negative and duration must be positive", ErrInvalidPlan)
+	}
+	if len(p.Requests) == 0 {
+		p.Requests = []Request{{Path: "/"}}
+	}
+	for i := range p.Requests {
+		r := &p.Requests[i]
+		if r.Method == "" {
+			r.Method = http.MethodGet
+		}
+		r.Method = strings.ToUpper(r.Method)
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("%w: request %d path must start with /", ErrInvalidPlan, i)
+		}
+		if r.Name == "" {
+			r.Name = r.Method + " " + r.Path
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		if r.Weight < 0 {
+			return fmt.Errorf("%w: request %s has a negative weight", ErrInvalidPlan, r.Name)
+		}
+	}
+	return nil
+}
+
+// ok reports whether status counts as success for r
+func (r *Request) ok(status int) bool {
+	if len(r.Expect) == 0 {
+		return status >= 200 && status < 400
+	}
+	for _, s := range r.Expect {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrThresholds is returned by Result.Check when the run exceeded the
+// plan's thresholds
+var ErrThresholds = errors.New("loadgen: thresholds exceeded")
+
+// Result aggregates the samples of a run
+type Result struct {
+	Elapsed time.Duration
+
+	plan    *Plan
+	started time.Time
+	mu      sync.Mutex
+	stats   map[string]*requestStats
+	total   *requestStats
+}
+
+type requestStats struct {
+	count     int
+	errors    int
+	statuses  map[int]int
+	failures  map[string]int
+	latencies []time.Duration
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{statuses: map[int]int{}, failures: map[string]int{}}
+}
+
+func newResult(plan *Plan) *Result {
+	r := &Result{plan: plan, started: time.Now(), stats: map[string]*requestStats{}, total: newRequestStats()}
+	for _, req := range plan.Requests {
+		r.stats[req.Name] = newRequestStats()
+	}
+	return r
+}
+
+func (r *Result) add(s Sample) {
+	if errors.Is(s.Err, context.Canceled) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, st := range []*requestStats{r.stats[s.Request], r.total} {
+		st.count++
+		st.latencies = append(st.latencies, s.Latency)
+		if s.Status != 0 {
+			st.statuses[s.Status]++
+		}
+		if !s.OK {
+			st.errors++
+			if s.Err != nil {
+				st.failures[s.Err.Error()]++
+			}
+		}
+	}
+}
+
+// Requests is the number of completed requests
+func (r *Result) Requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total.count
+}
+
+// StatusClasses counts completed requests by status class, e.g. "2xx"
+func (r *Result) StatusClasses() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	classes := map[string]int{}
+	for status, n := range r.total.statuses {
+		classes[fmt.Sprintf("%dxx", status/100)] += n
+	}
+	return classes
+}
+
+// Progress is a one-line summary of the run so far
+func (r *Result) Progress() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elapsed := time.Since(r.started)
+	return fmt.Sprintf("%s: %d requests (%.1f/s), %.2f%% errors, p99 %s",
+		elapsed.Round(time.Second), r.total.count, float64(r.total.count)/elapsed.Seconds(),
+		errorRate(r.total)*100, percentile(r.total.latencies, 99))
+}
+
+// Write prints latency percentiles and error rates per request and overall
+func (r *Result) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fmt.Fprintf(w, "%d requests in %s (%.1f/s) against %s\n\n", r.total.count, r.Elapsed.Round(time.Millisecond),
+		float64(r.total.count)/r.Elapsed.Seconds(), r.plan.Target)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "REQUEST\tCOUNT\tERRORS\tP50\tP90\tP99\tMAX\t")
+	for _, req := range r.plan.Requests {
+		writeRow(tw, req.Name, r.stats[req.Name])
+	}
+	writeRow(tw, "total", r.total)
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nstatuses: %s\n", formatStatuses(r.total.statuses))
+	if len(r.total.failures) > 0 {
+		fmt.Fprintln(w, "failures:")
+		for _, msg := range sortedKeys(r.total.failures) {
+			fmt.Fprintf(w, "  %6d  %s\n", r.total.failures[msg], msg)
+		}
+	}
+}
+
+// Check returns ErrThresholds if the error rate or p99 latency exceeded
+// the plan's thresholds
+func (r *Result) Check() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.plan.Thresholds
+	var failed []string
+	if rate := errorRate(r.total); t.ErrorRate > 0 && rate > t.ErrorRate {
+		failed = append(failed, fmt.Sprintf("error rate %.2f%% > %.2f%%", rate*100, t.ErrorRate*100))
+	}
+	if p99 := percentile(r.total.latencies, 99); t.P99 > 0 && p99 > t.P99 {
+		failed = append(failed, fmt.Sprintf("p99 %s > %s", p99, t.P99))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrThresholds, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func writeRow(w io.Writer, name string, st *requestStats) {
+	fmt.Fprintf(w, "%s\t%d\t%d (%.2f%%)\t%s\t%s\t%s\t%s\t\n", name, st.count, st.errors, errorRate(st)*100,
+		percentile(st.latencies, 50), percentile(st.latencies, 90), percentile(st.latencies, 99), percentile(st.latencies, 100))
+}
+
+func errorRate(st *requestStats) float64 {
+	if st.count == 0 {
+		return 0
+	}
+	return float64(st.errors) / float64(st.count)
+}
+
+// percentile returns the nearest-rank p-th percentile of latencies
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(float64(len(sorted))*p/100+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank].Round(time.Microsecond)
+}
+
+func formatStatuses(statuses map[int]int) string {
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d=%d", code, statuses[code]))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,140 @@
+package loadgen
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is the outcome of one request
+type Sample struct {
+	Request string
+	Status  int
+	Latency time.Duration
+	Err     error
+	OK      bool
+}
+
+// Run sends plan's mix until its duration passes or ctx is done and returns
+// every sample. progress, if set, is called with the samples so far every
+// interval.
+func Run(ctx context.Context, plan *Plan, client *http.Client, interval time.Duration, progress func(*Result)) *Result {
+	ctx, cancel := context.WithTimeout(ctx, plan.Duration)
+	defer cancel()
+
+	result := newResult(plan)
+	// Sampled by weight: each request appears Weight times
+	var mix []*Request
+	for i := range plan.Requests {
+		for n := 0; n < plan.Requests[i].Weight; n++ {
+			mix = append(mix, &plan.Requests[i])
+		}
+	}
+
+	jobs := make(chan *Request)
+	var wg sync.WaitGroup
+	for i := 0; i < plan.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				result.add(send(ctx, plan, client, r))
+			}
+		}()
+	}
+
+	if progress != nil && interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					progress(result)
+				}
+			}
+		}()
+	}
+
+	dispatch(ctx, plan.Rate, func() bool {
+		select {
+		case jobs <- mix[rand.Intn(len(mix))]:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	result.Elapsed = time.Since(result.started)
+	return result
+}
+
+// dispatch calls next at rate per second until it returns false. Sends are
+// scheduled from the start time rather than the previous send, so a slow
+// send is caught up on instead of lowering the rate.
+func dispatch(ctx context.Context, rate float64, next func() bool) {
+	if rate == 0 {
+		for next() {
+		}
+		return
+	}
+	start := time.Now()
+	interval := time.Duration(float64(time.Second) / rate)
+	for i := 0; ; i++ {
+		if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !next() {
+			return
+		}
+	}
+}
+
+func send(ctx context.Context, plan *Plan, client *http.Client, r *Request) Sample {
+	reqCtx, cancel := context.WithTimeout(ctx, plan.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if r.Body != "" {
+		body = strings.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, r.Method, plan.Target+r.Path, body)
+	if err != nil {
+		return Sample{Request: r.Name, Err: err}
+	}
+	if r.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range plan.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		// Requests cut off by the end of the run aren't failures
+		if ctx.Err() != nil {
+			return Sample{Request: r.Name, Err: context.Canceled}
+		}
+		return Sample{Request: r.Name, Latency: time.Since(start), Err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	return Sample{Request: r.Name, Status: resp.StatusCode, Latency: latency, OK: r.ok(resp.StatusCode)}
+}
@@ -0,0 +1,157 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverMetricsPrefix matches the buckets of server.MetricsMiddleware
+const serverMetricsPrefix = "http.server."
+
+// ServerMetrics receives the service's statsd packets, so a run can be
+// cross-checked against what the server itself counted. Point the
+// service's metrics.address at the listener.
+type ServerMetrics struct {
+	conn net.PacketConn
+	done chan struct{}
+
+	mu        sync.Mutex
+	requests  map[string]float64 // by status class
+	durations []float64          // milliseconds
+}
+
+// ListenStatsd receives statsd packets on the UDP address addr
+func ListenStatsd(addr string) (*ServerMetrics, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for statsd: %w", err)
+	}
+	m := &ServerMetrics{conn: conn, done: make(chan struct{}), requests: map[string]float64{}}
+	go m.read()
+	return m, nil
+}
+
+// Close stops listening
+func (m *ServerMetrics) Close() error {
+	err := m.conn.Close()
+	<-m.done
+	return err
+}
+
+// Reset forgets what was received so far, e.g. traffic before the run
+func (m *ServerMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = map[string]float64{}
+	m.durations = nil
+}
+
+func (m *ServerMetrics) read() {
+	defer close(m.done)
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		// Clients batch several metrics per packet, one per line
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			m.parse(line)
+		}
+	}
+}
+
+// parse records a line such as
+// "myapp.http.server.get.api_v1_items.2xx.requests:1|c|@0.5"
+func (m *ServerMetrics) parse(line string) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return
+	}
+	i := strings.Index(name, serverMetricsPrefix)
+	if i < 0 {
+		return
+	}
+	// InfluxDB-style tags follow the bucket after a comma
+	name, _, _ = strings.Cut(name[i+len(serverMetricsPrefix):], ",")
+	parts := strings.Split(name, ".")
+	if len(parts) != 4 {
+		return
+	}
+	class, metric := parts[2], parts[3]
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return
+	}
+	// Sampled counters are scaled back up
+	if len(fields) > 2 && strings.HasPrefix(fields[2], "@") {
+		if rate, err := strconv.ParseFloat(fields[2][1:], 64); err == nil && rate > 0 {
+			value /= rate
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case metric == "requests" && fields[1] == "c":
+		m.requests[class] += value
+	case metric == "duration" && fields[1] == "ms":
+		m.durations = append(m.durations, value)
+	}
+}
+
+// Write compares the server's request counts and latencies with the
+// client's. The server counts every request it served, so other traffic to
+// the instance shows up as a difference.
+func (m *ServerMetrics) Write(w io.Writer, client *Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "\nserver-side metrics (statsd):")
+	if len(m.requests) == 0 {
+		fmt.Fprintln(w, "  none received; is the service's metrics.address pointed at this listener?")
+		return
+	}
+
+	seen := client.StatusClasses()
+	classes := map[string]bool{}
+	for class := range m.requests {
+		classes[class] = true
+	}
+	for class := range seen {
+		classes[class] = true
+	}
+	names := make([]string, 0, len(classes))
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+	for _, class := range names {
+		server := int(m.requests[class] + 0.5)
+		fmt.Fprintf(w, "  %s  server %d  client %d  diff %+d\n", class, server, seen[class], server-seen[class])
+	}
+
+	if len(m.durations) > 0 {
+		latencies := make([]time.Duration, len(m.durations))
+		for i, ms := range m.durations {
+			latencies[i] = time.Duration(ms * float64(time.Millisecond))
+		}
+		fmt.Fprintf(w, "  server duration p50 %s  p90 %s  p99 %s  max %s\n",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+	}
+}
@@ -0,0 +1,37 @@
+package loadshed
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// Handler exposes the shedder's state
+type Handler struct {
+	shedder *Shedder
+}
+
+// NewHandler creates the admin handler
+func NewHandler(shedder *Shedder) *Handler {
+	return &Handler{shedder: shedder}
+}
+
+// Mount registers GET /admin/load-shedding
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/load-shedding", h.status)
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	cfg := h.shedder.config
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": h.shedder.Status(),
+		"limits": map[string]interface{}{
+			"max_p99_latency_ms": cfg.MaxP99Latency.Milliseconds(),
+			"max_in_flight":      cfg.MaxInFlight,
+			"max_pool_wait_ms":   cfg.MaxPoolWait.Milliseconds(),
+		},
+	})
+}
@@ -0,0 +1,363 @@
+// Package loadshed rejects low-priority requests with 503 while the
+// service is saturated, so the requests that matter keep their latency
+// instead of everything timing out together.
+//
+// Saturation is judged every interval from the p99 latency of the requests
+// served, the peak number in flight and the mean wait for a database
+// connection. Each saturated interval raises the shedding level by one and
+// each healthy one lowers it: level 1 sheds low-priority requests and
+// level 2 normal ones too. Critical requests are never shed.
+package loadshed
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Priority is how important a request is to keep serving
+type Priority int
+
+// Priorities, lowest first
+const (
+	Low Priority = iota
+	Normal
+	Critical
+)
+
+// maxLevel sheds everything but critical requests
+const maxLevel = int32(Critical)
+
+// maxSamples bounds the latencies kept per interval; beyond it they are
+// sampled
+const maxSamples = 4096
+
+// ParsePriority parses "critical", "normal" or "low"
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "critical":
+		return Critical, true
+	case "normal":
+		return Normal, true
+	case "low":
+		return Low, true
+	}
+	return 0, false
+}
+
+func (p Priority) String() string {
+	switch p {
+	case Critical:
+		return "critical"
+	case Normal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+// Status is the shedder's latest evaluation
+type Status struct {
+	Level      int       `json:"level"`
+	Shedding   []string  `json:"shedding"` // the priorities being rejected
+	Saturated  []string  `json:"saturated,omitempty"`
+	P99Ms      float64   `json:"p99_ms"`
+	InFlight   int64     `json:"in_flight"`
+	PoolWaitMs float64   `json:"pool_wait_ms"`
+	Evaluated  time.Time `json:"evaluated,omitzero"`
+}
+
+// Shedder measures load and sheds requests by priority
+type Shedder struct {
+	config   *config.LoadShedConfig
+	pool     func() sql.DBStats
+	logger   *zap.Logger
+	stats    metrics.Agent
+	fallback Priority
+
+	// routes matches request paths against the configured patterns, which
+	// index priorities by method and pattern, with "*" for any method
+	routes     *chi.Mux
+	priorities map[string]Priority
+
+	level    atomic.Int32
+	inFlight atomic.Int64
+	peak     atomic.Int64
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	observed int
+	status   Status
+	lastPool sql.DBStats
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a shedder for cfg. pool reports the database pool's
+// statistics for the wait signal; it may be nil.
+func New(cfg *config.LoadShedConfig, pool func() sql.DBStats, logger *zap.Logger, stats metrics.Agent) *Shedder {
+	fallback, ok := ParsePriority(cfg.DefaultPriority)
+	if !ok {
+		fallback = Normal
+	}
+	s := &Shedder{
+		config:     cfg,
+		pool:       pool,
+		logger:     logger.With(zap.String("component", "loadshed")),
+		stats:      stats,
+		fallback:   fallback,
+		routes:     chi.NewMux(),
+		priorities: make(map[string]Priority),
+		samples:    make([]time.Duration, 0, maxSamples),
+	}
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, rc := range cfg.Routes {
+		priority, _ := ParsePriority(rc.Priority)
+		if len(rc.Methods) == 0 {
+			s.routes.Handle(rc.Pattern, noop)
+			s.priorities["* "+rc.Pattern] = priority
+			continue
+		}
+		for _, method := range rc.Methods {
+			method = strings.ToUpper(method)
+			s.routes.Method(method, rc.Pattern, noop)
+			s.priorities[method+" "+rc.Pattern] = priority
+		}
+	}
+	s.status.Shedding = []string{}
+	return s
+}
+
+// Priority returns the request's priority: the header's when it names
+// one, else its route's, else the default
+func (s *Shedder) Priority(r *http.Request) Priority {
+	if s.config.PriorityHeader != "" {
+		if p, ok := ParsePriority(r.Header.Get(s.config.PriorityHeader)); ok {
+			return p
+		}
+	}
+	if len(s.priorities) == 0 {
+		return s.fallback
+	}
+	rctx := chi.NewRouteContext()
+	if !s.routes.Match(rctx, r.Method, r.URL.Path) {
+		return s.fallback
+	}
+	pattern := rctx.RoutePattern()
+	if p, ok := s.priorities[r.Method+" "+pattern]; ok {
+		return p
+	}
+	if p, ok := s.priorities["* "+pattern]; ok {
+		return p
+	}
+	return s.fallback
+}
+
+// Level returns the shedding level: requests with a lower priority are
+// rejected
+func (s *Shedder) Level() int {
+	return int(s.level.Load())
+}
+
+// Status returns the latest evaluation
+func (s *Shedder) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Handler sheds requests below the current level and measures the rest
+func (s *Shedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range s.config.ExemptPaths {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		priority := s.Priority(r)
+		if int32(priority) < s.level.Load() {
+			s.stats.Increment("loadshed.shed." + priority.String())
+			s.logger.Debug("shed request",
+				zap.String("path", r.URL.Path),
+				zap.Stringer("priority", priority))
+			if s.config.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.config.RetryAfter.Seconds()))))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "service is overloaded, retry later"})
+			return
+		}
+
+		n := s.inFlight.Add(1)
+		for {
+			peak := s.peak.Load()
+			if n <= peak || s.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		start := time.Now()
+		defer func() {
+			s.inFlight.Add(-1)
+			s.observe(time.Since(start))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observe records a latency, reservoir sampling past maxSamples
+func (s *Shedder) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed++
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, d)
+		return
+	}
+	if i := rand.IntN(s.observed); i < maxSamples {
+		s.samples[i] = d
+	}
+}
+
+// Evaluate judges the interval since the last evaluation and moves the
+// level one step towards shedding or serving
+func (s *Shedder) Evaluate() Status {
+	s.mu.Lock()
+	samples := s.samples
+	s.samples = make([]time.Duration, 0, maxSamples)
+	s.observed = 0
+	s.mu.Unlock()
+
+	var p99 time.Duration
+	if len(samples) > 0 {
+		slices.Sort(samples)
+		p99 = samples[int(math.Ceil(float64(len(samples))*0.99))-1]
+	}
+	peak := s.peak.Swap(s.inFlight.Load())
+	poolWait := s.poolWait()
+
+	var saturated []string
+	if s.config.MaxP99Latency > 0 && p99 > s.config.MaxP99Latency {
+		saturated = append(saturated, "p99_latency")
+	}
+	if s.config.MaxInFlight > 0 && peak > int64(s.config.MaxInFlight) {
+		saturated = append(saturated, "in_flight")
+	}
+	if s.config.MaxPoolWait > 0 && poolWait > s.config.MaxPoolWait {
+		saturated = append(saturated, "pool_wait")
+	}
+
+	previous := s.level.Load()
+	level := previous
+	switch {
+	case len(saturated) > 0 && level < maxLevel:
+		level++
+	case len(saturated) == 0 && level > 0:
+		level--
+	}
+	s.level.Store(level)
+
+	status := Status{
+		Level:      int(level),
+		Shedding:   []string{},
+		Saturated:  saturated,
+		P99Ms:      float64(p99) / float64(time.Millisecond),
+		InFlight:   peak,
+		PoolWaitMs: float64(poolWait) / float64(time.Millisecond),
+		Evaluated:  time.Now(),
+	}
+	for p := Low; int32(p) < level; p++ {
+		status.Shedding = append(status.Shedding, p.String())
+	}
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+
+	s.stats.Gauge("loadshed.level", level)
+	s.stats.Gauge("loadshed.in_flight", peak)
+	s.stats.Timing("loadshed.p99", p99)
+	s.stats.Timing("loadshed.pool_wait", poolWait)
+	switch {
+	case level > previous:
+		s.logger.Warn("shedding load",
+			zap.Strings("priorities", status.Shedding),
+			zap.Strings("saturated", saturated),
+			zap.Duration("p99", p99),
+			zap.Int64("in_flight", peak),
+			zap.Duration("pool_wait", poolWait))
+	case level < previous:
+		s.logger.Info("shedding less load", zap.Strings("priorities", status.Shedding))
+	}
+	return status
+}
+
+// poolWait returns the mean wait for a database connection since the last
+// call
+func (s *Shedder) poolWait() time.Duration {
+	if s.pool == nil {
+		return 0
+	}
+	current := s.pool()
+	s.mu.Lock()
+	last := s.lastPool
+	s.lastPool = current
+	s.mu.Unlock()
+
+	waits := current.WaitCount - last.WaitCount
+	if waits <= 0 {
+		return 0
+	}
+	return (current.WaitDuration - last.WaitDuration) / time.Duration(waits)
+}
+
+// Start evaluates load every load_shedding.interval
+func (s *Shedder) Start() error {
+	if s.pool != nil {
+		s.lastPool = s.pool()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Evaluate()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the evaluations
+func (s *Shedder) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,50 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotAcquired is returned when the lock is held by another owner
+	ErrNotAcquired = errors.New("locks: lock is held by another owner")
+	// ErrLockLost is returned when extending a lock that has expired or been
+	// taken over
+	ErrLockLost = errors.New("locks: lock was lost")
+)
+
+// Locker hands out named locks shared by every replica
+type Locker interface {
+	// Lock tries once to acquire name for ttl and returns ErrNotAcquired if
+	// another owner holds it. Implementations without expiry may ignore ttl.
+	Lock(ctx context.Context, name string, ttl time.Duration) (Lock, error)
+}
+
+// Lock is a held lock
+type Lock interface {
+	// Extend pushes the expiry out to ttl from now
+	Extend(ctx context.Context, ttl time.Duration) error
+	// Release frees the lock. Releasing an expired lock is not an error.
+	Release(ctx context.Context) error
+}
+
+// WithLock runs fn while holding name, returning ErrNotAcquired without
+// calling fn if the lock is held elsewhere. fn should finish within ttl.
+func WithLock(ctx context.Context, locker Locker, name string, ttl time.Duration, fn func(ctx context.Context) error) error {
+	lock, err := locker.Lock(ctx, name, ttl)
+	if err != nil {
+		return err
+	}
+
+	fnErr := fn(ctx)
+
+	// Release even if ctx was cancelled while fn ran
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := lock.Release(releaseCtx); err != nil && fnErr == nil {
+		return fmt.Errorf("failed to release lock %s: %w", name, err)
+	}
+	return fnErr
+}
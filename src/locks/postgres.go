@@ -0,0 +1,93 @@
+package locks
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Postgres implements Locker with transaction-scoped advisory locks. The
+// lock lives as long as its transaction, so it is released automatically if
+// the holder dies and needs no TTL. Each held lock occupies one pooled
+// connection.
+type Postgres struct {
+	engine storage.Engine
+	logger *zap.Logger
+}
+
+var _ Locker = (*Postgres)(nil)
+
+// NewPostgres creates an advisory lock locker
+func NewPostgres(engine storage.Engine, logger *zap.Logger) *Postgres {
+	return &Postgres{
+		engine: engine,
+		logger: logger.With(zap.String("component", "locks.postgres")),
+	}
+}
+
+// Lock tries to take the advisory lock for name. ttl is ignored. ctx
+// bounds taking the lock but not holding it: the transaction is begun
+// detached from ctx, since database/sql rolls a transaction back when its
+// context is done, which would free the lock while its holder still
+// believes it has it. The lock is held until Release.
+func (p *Postgres) Lock(ctx context.Context, name string, ttl time.Duration) (Lock, error) {
+	tx, err := p.engine.Begin(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin lock transaction: %w", err)
+	}
+
+	acquired, err := tryLock(ctx, tx, name)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	if !acquired {
+		tx.Rollback()
+		return nil, ErrNotAcquired
+	}
+
+	p.logger.Debug("lock acquired", zap.String("lock", name))
+	return &postgresLock{name: name, tx: tx, logger: p.logger}, nil
+}
+
+// tryLock takes the advisory lock for name in tx if it is free
+func tryLock(ctx context.Context, tx *storage.InstrumentedTx, name string) (bool, error) {
+	rows, err := tx.Query(ctx, "SELECT pg_try_advisory_xact_lock(hashtextextended($1, 0))", name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var acquired bool
+	if rows.Next() {
+		if err := rows.Scan(&acquired); err != nil {
+			return false, err
+		}
+	}
+	return acquired, rows.Err()
+}
+
+// postgresLock is a held advisory lock
+type postgresLock struct {
+	name   string
+	tx     *storage.InstrumentedTx
+	logger *zap.Logger
+}
+
+// Extend is a no-op; the lock is held until Release
+func (l *postgresLock) Extend(ctx context.Context, ttl time.Duration) error {
+	return nil
+}
+
+// Release rolls the transaction back, which frees the lock. Nothing else
+// ends it, so every lock must be released.
+func (l *postgresLock) Release(ctx context.Context) error {
+	if err := l.tx.Rollback(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.name, err)
+	}
+	l.logger.Debug("lock released", zap.String("lock", l.name))
+	return nil
+}
@@ -0,0 +1,147 @@
+package locks
+
+import (
+	"coffee-and-running/src/cache/redis"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// keyPrefix namespaces lock keys
+const keyPrefix = "lock:"
+
+var (
+	// releaseScript deletes the key only if we still own it
+	releaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	// extendScript resets the expiry only if we still own the key
+	extendScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Redis implements Locker with the Redlock algorithm: a lock is held when a
+// majority of independent Redis nodes accept it within its validity time.
+// With a single client it degrades to a plain SET NX lock.
+type Redis struct {
+	clients []*redis.Client
+	quorum  int
+	logger  *zap.Logger
+}
+
+var _ Locker = (*Redis)(nil)
+
+// NewRedis creates a Redlock locker over independent Redis nodes. Use an
+// odd number of nodes; replicas of the same primary don't count.
+func NewRedis(logger *zap.Logger, clients ...*redis.Client) (*Redis, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("locks: at least one redis client is required")
+	}
+
+	return &Redis{
+		clients: clients,
+		quorum:  len(clients)/2 + 1,
+		logger:  logger.With(zap.String("component", "locks.redis")),
+	}, nil
+}
+
+// Lock tries to acquire name on a quorum of nodes
+func (r *Redis) Lock(ctx context.Context, name string, ttl time.Duration) (Lock, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	key := keyPrefix + name
+
+	start := time.Now()
+	acquired := 0
+	for _, client := range r.clients {
+		ok, err := client.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			r.logger.Warn("failed to set lock on node", zap.String("lock", name), zap.Error(err))
+			continue
+		}
+		if ok {
+			acquired++
+		}
+	}
+
+	// Allow for clock drift between nodes, per the Redlock paper
+	drift := ttl/100 + 2*time.Millisecond
+	validity := ttl - time.Since(start) - drift
+
+	lock := &redisLock{locker: r, name: name, key: key, token: token}
+	if acquired >= r.quorum && validity > 0 {
+		r.logger.Debug("lock acquired", zap.String("lock", name), zap.Duration("validity", validity))
+		return lock, nil
+	}
+
+	// Undo partial acquisition so the lock frees up immediately
+	lock.Release(context.WithoutCancel(ctx))
+	return nil, ErrNotAcquired
+}
+
+// redisLock is a lock held on a quorum of nodes
+type redisLock struct {
+	locker *Redis
+	name   string
+	key    string
+	token  string
+}
+
+// Extend resets the expiry on every node we still own
+func (l *redisLock) Extend(ctx context.Context, ttl time.Duration) error {
+	extended := 0
+	for _, client := range l.locker.clients {
+		n, err := extendScript.Run(ctx, client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+		if err != nil {
+			l.locker.logger.Warn("failed to extend lock on node", zap.String("lock", l.name), zap.Error(err))
+			continue
+		}
+		if n == 1 {
+			extended++
+		}
+	}
+
+	if extended < l.locker.quorum {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release deletes the key on every node where we still own it
+func (l *redisLock) Release(ctx context.Context) error {
+	var errs []error
+	for _, client := range l.locker.clients {
+		if err := releaseScript.Run(ctx, client, []string{l.key}, l.token).Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to release lock %s: %w", l.name, errors.Join(errs...))
+	}
+	l.locker.logger.Debug("lock released", zap.String("lock", l.name))
+	return nil
+}
+
+// newToken returns a random value identifying this lock holder
+func newToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("locks: failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,393 @@
+package mailer
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// maxErrorLength bounds the provider error stored with a failed attempt
+const maxErrorLength = 1024
+
+// Message is an email ready to send
+type Message struct {
+	From    string            `json:"from"`
+	ReplyTo string            `json:"reply_to,omitempty"`
+	To      []string          `json:"to"`
+	Cc      []string          `json:"cc,omitempty"`
+	Bcc     []string          `json:"bcc,omitempty"`
+	Subject string            `json:"subject"`
+	Text    string            `json:"text,omitempty"`
+	HTML    string            `json:"html,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Tags    []string          `json:"tags,omitempty"` // provider categories, for analytics
+}
+
+// Recipients returns every To, Cc and Bcc address
+func (m *Message) Recipients() []string {
+	all := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	all = append(all, m.To...)
+	all = append(all, m.Cc...)
+	return append(all, m.Bcc...)
+}
+
+// Provider sends a message through an email service and returns the
+// provider's message ID, if any
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) (string, error)
+}
+
+// PermanentError marks a failure that retrying won't fix, such as a
+// rejected recipient or invalid credentials
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// ErrSuppressed is returned when every recipient is on the suppression list
+var ErrSuppressed = errors.New("mailer: all recipients are suppressed")
+
+// Mailer queues emails in the email_queue table and sends them in the
+// background, retrying transient provider failures with backoff. Messages
+// to addresses on the suppression list are dropped.
+type Mailer struct {
+	config    *config.EmailConfig
+	provider  Provider
+	templates *Templates
+	engine    storage.Engine
+	logger    *zap.Logger
+	stats     metrics.Agent
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New creates a mailer
+func New(cfg *config.EmailConfig, provider Provider, templates *Templates, engine storage.Engine, logger *zap.Logger, stats metrics.Agent) *Mailer {
+	return &Mailer{
+		config:    cfg,
+		provider:  provider,
+		templates: templates,
+		engine:    engine,
+		logger:    logger.With(zap.String("component", "mailer"), zap.String("provider", provider.Name())),
+		stats:     stats,
+	}
+}
+
+// Send queues a message. From defaults to the configured sender.
+// Suppressed recipients are removed; ErrSuppressed is returned if none remain.
+func (m *Mailer) Send(ctx context.Context, msg *Message) error {
+	if msg.From == "" {
+		msg.From = m.config.From
+	}
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	if err := m.filterSuppressed(ctx, msg); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode email: %w", err)
+	}
+
+	if _, err := m.engine.Exec(ctx, "INSERT INTO email_queue (message) VALUES ($1)", payload); err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
+	}
+	m.stats.Increment("mailer.queued")
+	return nil
+}
+
+// SendTemplate renders the named template and queues it to the given
+// recipients
+func (m *Mailer) SendTemplate(ctx context.Context, name string, to []string, data interface{}) error {
+	rendered, err := m.templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, &Message{
+		To:      to,
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+		Tags:    []string{name},
+	})
+}
+
+// Suppress stops all future email to address, e.g. after a hard bounce,
+// complaint or unsubscribe
+func (m *Mailer) Suppress(ctx context.Context, address, reason string) error {
+	_, err := m.engine.Exec(ctx,
+		`INSERT INTO email_suppressions (email, reason) VALUES ($1, $2)
+		 ON CONFLICT (email) DO UPDATE SET reason = EXCLUDED.reason`,
+		normalize(address), reason)
+	if err == nil {
+		m.logger.Info("email address suppressed", zap.String("reason", reason))
+	}
+	return err
+}
+
+// Unsuppress allows email to address again
+func (m *Mailer) Unsuppress(ctx context.Context, address string) error {
+	_, err := m.engine.Exec(ctx, "DELETE FROM email_suppressions WHERE email = $1", normalize(address))
+	return err
+}
+
+// filterSuppressed removes suppressed recipients from msg
+func (m *Mailer) filterSuppressed(ctx context.Context, msg *Message) error {
+	recipients := msg.Recipients()
+	addresses := make([]string, len(recipients))
+	for i, r := range recipients {
+		addresses[i] = normalize(r)
+	}
+
+	rows, err := m.engine.Query(ctx, "SELECT email FROM email_suppressions WHERE email = ANY($1)", pq.Array(addresses))
+	if err != nil {
+		return fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	defer rows.Close()
+
+	suppressed := make(map[string]bool)
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return err
+		}
+		suppressed[email] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(suppressed) == 0 {
+		return nil
+	}
+
+	keep := func(list []string) []string {
+		var out []string
+		for _, r := range list {
+			if !suppressed[normalize(r)] {
+				out = append(out, r)
+			}
+		}
+		return out
+	}
+	msg.To, msg.Cc, msg.Bcc = keep(msg.To), keep(msg.Cc), keep(msg.Bcc)
+	m.stats.Count("mailer.suppressed", len(suppressed))
+
+	if len(msg.Recipients()) == 0 {
+		return ErrSuppressed
+	}
+	return nil
+}
+
+// Start begins sending queued email
+func (m *Mailer) Start() error {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.poll()
+			}
+		}
+	}()
+
+	m.logger.Info("mailer started", zap.Int("workers", m.config.Workers))
+	return nil
+}
+
+// Close stops sending and waits for in-flight sends
+func (m *Mailer) Close() error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+	m.logger.Info("mailer stopped")
+	return nil
+}
+
+// queued is a claimed email_queue row
+type queued struct {
+	id       int64
+	message  Message
+	attempts int
+}
+
+// poll claims due emails and sends them with bounded concurrency
+func (m *Mailer) poll() {
+	batch, err := m.claim(m.ctx)
+	if err != nil {
+		if m.ctx.Err() == nil {
+			m.logger.Error("failed to claim queued email", zap.Error(err))
+			m.stats.Increment("mailer.claim.error")
+		}
+		return
+	}
+
+	sem := make(chan struct{}, m.config.Workers)
+	var wg sync.WaitGroup
+	for _, q := range batch {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(q queued) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			m.deliver(q)
+		}(q)
+	}
+	wg.Wait()
+}
+
+// claim leases due emails by pushing next_attempt_at past the send timeout
+func (m *Mailer) claim(ctx context.Context) ([]queued, error) {
+	lease := 2 * m.config.SendTimeout
+	rows, err := m.engine.Query(ctx,
+		`UPDATE email_queue SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		 WHERE id IN (
+			SELECT id FROM email_queue
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING id, message, attempts`,
+		m.config.BatchSize, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []queued
+	for rows.Next() {
+		var q queued
+		var payload []byte
+		if err := rows.Scan(&q.id, &payload, &q.attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan queued email: %w", err)
+		}
+		if err := json.Unmarshal(payload, &q.message); err != nil {
+			return nil, fmt.Errorf("failed to decode queued email %d: %w", q.id, err)
+		}
+		batch = append(batch, q)
+	}
+	return batch, rows.Err()
+}
+
+// deliver sends one email and records the outcome
+func (m *Mailer) deliver(q queued) {
+	logger := m.logger.With(zap.Int64("email_id", q.id), zap.Int("attempt", q.attempts+1))
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.config.SendTimeout)
+	start := time.Now()
+	messageID, err := m.provider.Send(ctx, &q.message)
+	cancel()
+	m.stats.Timing("mailer.send.duration", time.Since(start))
+
+	if err == nil {
+		if _, dbErr := m.engine.Exec(m.ctx,
+			`UPDATE email_queue SET status = 'sent', attempts = attempts + 1, last_error = NULL,
+			     provider_message_id = NULLIF($2, ''), sent_at = NOW()
+			 WHERE id = $1`,
+			q.id, messageID); dbErr != nil {
+			logger.Error("failed to mark email sent", zap.Error(dbErr))
+		}
+		m.stats.Increment("mailer.sent")
+		logger.Debug("email sent", zap.String("provider_message_id", messageID))
+		return
+	}
+
+	attempts := q.attempts + 1
+	reason := err.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+	m.stats.Increment("mailer.send.error")
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) || attempts >= m.config.MaxAttempts {
+		if _, dbErr := m.engine.Exec(m.ctx,
+			"UPDATE email_queue SET status = 'failed', attempts = $2, last_error = $3 WHERE id = $1",
+			q.id, attempts, reason); dbErr != nil {
+			logger.Error("failed to mark email failed", zap.Error(dbErr))
+		}
+		m.stats.Increment("mailer.failed")
+		logger.Error("email failed permanently", zap.Bool("permanent", permanent != nil), zap.Error(err))
+		return
+	}
+
+	wait := m.backoff(attempts)
+	if _, dbErr := m.engine.Exec(m.ctx,
+		`UPDATE email_queue SET attempts = $2, last_error = $3,
+		     next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond'
+		 WHERE id = $1`,
+		q.id, attempts, reason, wait.Milliseconds()); dbErr != nil {
+		logger.Error("failed to reschedule email", zap.Error(dbErr))
+		return
+	}
+	logger.Warn("email send failed, retry scheduled", zap.Duration("backoff", wait), zap.Error(err))
+}
+
+// backoff returns the exponential delay (with jitter) before attempt+1
+func (m *Mailer) backoff(attempts int) time.Duration {
+	wait := m.config.InitialBackoff << (attempts - 1)
+	if wait <= 0 || wait > m.config.MaxBackoff {
+		wait = m.config.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(wait)/5+1)) - wait/10
+	return wait + jitter
+}
+
+// validate checks a message has a sender, recipients and a body
+func validate(msg *Message) error {
+	if msg.From == "" {
+		return errors.New("mailer: from address is required")
+	}
+	if len(msg.Recipients()) == 0 {
+		return errors.New("mailer: at least one recipient is required")
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return errors.New("mailer: text or html body is required")
+	}
+	for _, address := range append([]string{msg.From}, msg.Recipients()...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("mailer: invalid address %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// normalize reduces an address to its lower-cased mailbox for suppression
+// lookups, so "Jane <Jane@Example.com>" matches "jane@example.com"
+func normalize(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+)
+
+// SendGridProvider sends email through the SendGrid v3 API
+type SendGridProvider struct {
+	config *config.SendGridConfig
+	client *http.Client
+}
+
+// NewSendGridProvider creates a SendGrid provider. client should come from
+// the httpclient factory so calls are traced and measured.
+func NewSendGridProvider(cfg *config.SendGridConfig, client *http.Client) (*SendGridProvider, error) {
+	if cfg.APIKey == "" {
+		return nil, errors.New("sendgrid api_key is required")
+	}
+	return &SendGridProvider{config: cfg, client: client}, nil
+}
+
+// Name returns "sendgrid"
+func (p *SendGridProvider) Name() string { return "sendgrid" }
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+	Categories       []string                  `json:"categories,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Send posts msg to the SendGrid API
+func (p *SendGridProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	req, err := p.buildRequest(msg)
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.Header.Get("X-Message-Id"), nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	err = fmt.Errorf("sendgrid returned %d: %s", resp.StatusCode, detail)
+	// Rate limiting and server errors are worth retrying; other 4xx are not
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return "", &PermanentError{Err: err}
+	}
+	return "", err
+}
+
+func (p *SendGridProvider) buildRequest(msg *Message) (*sendGridRequest, error) {
+	convert := func(list []string) ([]sendGridAddress, error) {
+		var out []sendGridAddress
+		for _, a := range list {
+			parsed, err := mail.ParseAddress(a)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sendGridAddress{Email: parsed.Address, Name: parsed.Name})
+		}
+		return out, nil
+	}
+
+	req := &sendGridRequest{
+		Subject:    msg.Subject,
+		Headers:    msg.Headers,
+		Categories: msg.Tags,
+	}
+	req.Personalizations = make([]sendGridPersonalization, 1)
+
+	var err error
+	personalization := &req.Personalizations[0]
+	if personalization.To, err = convert(msg.To); err != nil {
+		return nil, err
+	}
+	if personalization.Cc, err = convert(msg.Cc); err != nil {
+		return nil, err
+	}
+	if personalization.Bcc, err = convert(msg.Bcc); err != nil {
+		return nil, err
+	}
+
+	from, err := convert([]string{msg.From})
+	if err != nil {
+		return nil, err
+	}
+	req.From = from[0]
+
+	if msg.ReplyTo != "" {
+		replyTo, err := convert([]string{msg.ReplyTo})
+		if err != nil {
+			return nil, err
+		}
+		req.ReplyTo = &replyTo[0]
+	}
+
+	// SendGrid requires text/plain before text/html
+	if msg.Text != "" {
+		req.Content = append(req.Content, sendGridContent{Type: "text/plain", Value: msg.Text})
+	}
+	if msg.HTML != "" {
+		req.Content = append(req.Content, sendGridContent{Type: "text/html", Value: msg.HTML})
+	}
+	return req, nil
+}
@@ -0,0 +1,75 @@
+package mailer
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/smithy-go"
+)
+
+// SESProvider sends email through the Amazon SES v2 API
+type SESProvider struct {
+	config *config.SESConfig
+	client *sesv2.Client
+}
+
+// NewSESProvider creates an SES provider using the default AWS credential
+// chain (environment, shared config, instance role)
+func NewSESProvider(ctx context.Context, cfg *config.SESConfig) (*SESProvider, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	return &SESProvider{config: cfg, client: sesv2.NewFromConfig(awsCfg)}, nil
+}
+
+// Name returns "ses"
+func (p *SESProvider) Name() string { return "ses" }
+
+// Send submits msg as a raw MIME message, which preserves custom headers
+func (p *SESProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	_, raw, err := buildMIME(msg)
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(msg.From),
+		Destination: &types.Destination{
+			ToAddresses:  msg.To,
+			CcAddresses:  msg.Cc,
+			BccAddresses: msg.Bcc,
+		},
+		Content: &types.EmailContent{
+			Raw: &types.RawMessage{Data: raw},
+		},
+	}
+	if p.config.ConfigurationSet != "" {
+		input.ConfigurationSetName = aws.String(p.config.ConfigurationSet)
+	}
+	// SES tags are name/value pairs with unique names, so each tag becomes
+	// a name with a constant value
+	for _, tag := range msg.Tags {
+		input.EmailTags = append(input.EmailTags, types.MessageTag{Name: aws.String(tag), Value: aws.String("true")})
+	}
+
+	out, err := p.client.SendEmail(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultClient {
+			switch apiErr.ErrorCode() {
+			case "TooManyRequestsException", "LimitExceededException":
+			default:
+				return "", &PermanentError{Err: err}
+			}
+		}
+		return "", err
+	}
+	return aws.ToString(out.MessageId), nil
+}
@@ -0,0 +1,216 @@
+package mailer
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPProvider sends email through an SMTP relay
+type SMTPProvider struct {
+	config *config.SMTPConfig
+}
+
+// NewSMTPProvider creates an SMTP provider
+func NewSMTPProvider(cfg *config.SMTPConfig) (*SMTPProvider, error) {
+	switch cfg.TLSMode {
+	case "", "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unsupported smtp tls_mode %q", cfg.TLSMode)
+	}
+	return &SMTPProvider{config: cfg}, nil
+}
+
+// Name returns "smtp"
+func (p *SMTPProvider) Name() string { return "smtp" }
+
+// Send delivers msg over a new SMTP connection
+func (p *SMTPProvider) Send(ctx context.Context, msg *Message) (string, error) {
+	messageID, body, err := buildMIME(msg)
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	client, err := p.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from.Address); err != nil {
+		return "", classify(err)
+	}
+	for _, recipient := range msg.Recipients() {
+		to, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return "", &PermanentError{Err: err}
+		}
+		if err := client.Rcpt(to.Address); err != nil {
+			return "", classify(err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return "", classify(err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", classify(err)
+	}
+
+	return messageID, client.Quit()
+}
+
+// dial connects, negotiates TLS and authenticates
+func (p *SMTPProvider) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(p.config.Host, strconv.Itoa(p.config.Port))
+	tlsConfig := &tls.Config{ServerName: p.config.Host, MinVersion: tls.VersionTLS12}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	// net/smtp isn't context-aware, so bound the whole session instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if p.config.TLSMode == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, p.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if p.config.TLSMode == "" || p.config.TLSMode == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("starttls failed: %w", err)
+		}
+	}
+
+	if p.config.Username != "" {
+		auth := smtp.PlainAuth("", p.config.Username, p.config.Password, p.config.Host)
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, classify(err)
+		}
+	}
+
+	return client, nil
+}
+
+// classify marks 5xx SMTP replies as permanent
+func classify(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &PermanentError{Err: err}
+	}
+	return err
+}
+
+// buildMIME renders msg as an RFC 5322 message with text and HTML
+// alternatives and returns its Message-ID
+func buildMIME(msg *Message) (string, []byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	messageID := fmt.Sprintf("<%s@%s>", hex.EncodeToString(id[:]), domain)
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+
+	header("From", from.String())
+	header("To", strings.Join(msg.To, ", "))
+	if len(msg.Cc) > 0 {
+		header("Cc", strings.Join(msg.Cc, ", "))
+	}
+	if msg.ReplyTo != "" {
+		header("Reply-To", msg.ReplyTo)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+
+	keys := make([]string, 0, len(msg.Headers))
+	for k := range msg.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header(k, msg.Headers[k])
+	}
+
+	writePart := func(w *multipart.Writer, contentType, body string) error {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(body)); err != nil {
+			return err
+		}
+		return qp.Close()
+	}
+
+	w := multipart.NewWriter(&buf)
+	header("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+	buf.WriteString("\r\n")
+
+	// Plain text first: clients show the last alternative they support
+	if msg.Text != "" {
+		if err := writePart(w, "text/plain", msg.Text); err != nil {
+			return "", nil, err
+		}
+	}
+	if msg.HTML != "" {
+		if err := writePart(w, "text/html", msg.HTML); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return messageID, buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultTemplates are the templates shipped with the service. Each email
+// is a set of files named <name>.subject.tmpl, <name>.txt.tmpl and
+// <name>.html.tmpl; the text and HTML parts are each optional.
+//
+//go:embed templates/*.tmpl
+var DefaultTemplates embed.FS
+
+// Templates renders named emails
+type Templates struct {
+	subjects map[string]*texttemplate.Template
+	texts    map[string]*texttemplate.Template
+	htmls    map[string]*htmltemplate.Template
+}
+
+// Rendered is the output of a template
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// NewTemplates parses every *.tmpl file in fsys, searching subdirectories
+func NewTemplates(fsys fs.FS) (*Templates, error) {
+	t := &Templates{
+		subjects: make(map[string]*texttemplate.Template),
+		texts:    make(map[string]*texttemplate.Template),
+		htmls:    make(map[string]*htmltemplate.Template),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tmpl") {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		base := strings.TrimSuffix(path.Base(p), ".tmpl")
+		dot := strings.LastIndex(base, ".")
+		if dot < 0 {
+			return fmt.Errorf("template %s must be named <name>.<subject|txt|html>.tmpl", p)
+		}
+		name, part := base[:dot], base[dot+1:]
+
+		switch part {
+		case "subject":
+			tmpl, err := texttemplate.New(base).Option("missingkey=error").Parse(strings.TrimSpace(string(data)))
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", p, err)
+			}
+			t.subjects[name] = tmpl
+		case "txt":
+			tmpl, err := texttemplate.New(base).Option("missingkey=error").Parse(string(data))
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", p, err)
+			}
+			t.texts[name] = tmpl
+		case "html":
+			tmpl, err := htmltemplate.New(base).Option("missingkey=error").Parse(string(data))
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", p, err)
+			}
+			t.htmls[name] = tmpl
+		default:
+			return fmt.Errorf("template %s has unknown part %q", p, part)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range t.subjects {
+		if t.texts[name] == nil && t.htmls[name] == nil {
+			return nil, fmt.Errorf("template %s has a subject but no body", name)
+		}
+	}
+	return t, nil
+}
+
+// Render executes the named template with data
+func (t *Templates) Render(name string, data interface{}) (*Rendered, error) {
+	subject, ok := t.subjects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var out Rendered
+	var buf bytes.Buffer
+	if err := subject.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	out.Subject = buf.String()
+
+	if tmpl := t.texts[name]; tmpl != nil {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s text: %w", name, err)
+		}
+		out.Text = buf.String()
+	}
+
+	if tmpl := t.htmls[name]; tmpl != nil {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s html: %w", name, err)
+		}
+		out.HTML = buf.String()
+	}
+
+	return &out, nil
+}
@@ -0,0 +1,409 @@
+package kafka
+
+import (
+	"coffee-and-running/src/app/supervisor"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go.uber.org/zap"
+)
+
+// metricsPrefix is the bucket prefix for all Kafka metrics
+const metricsPrefix = "messaging.kafka"
+
+// Broker is a Kafka implementation of messaging.Broker. A single writer is
+// shared by all publishers; each subscription runs its own consumer group
+// reader.
+type Broker struct {
+	config        *config.KafkaConfig
+	logger        *zap.Logger
+	stats         metrics.Agent
+	writer        *kafkago.Writer
+	dialer        *kafkago.Dialer
+	mu            sync.Mutex
+	subscriptions []*subscription
+	started       bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	workers       *supervisor.Supervisor
+}
+
+// subscription is a topic consumed by a handler
+type subscription struct {
+	topic   string
+	handler messaging.Handler
+	reader  *kafkago.Reader
+}
+
+var _ messaging.Broker = (*Broker)(nil)
+
+// New creates a Kafka broker connection. No network calls are made until
+// the first publish or Start.
+func New(cfg *config.KafkaConfig, logger *zap.Logger, stats metrics.Agent) (*Broker, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka: at least one broker is required")
+	}
+
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, fmt.Errorf("kafka: %w", err)
+	}
+	mechanism, err := saslMechanism(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+	acks, err := requiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := compressionCodec(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	logger = logger.With(zap.String("component", "messaging.kafka"))
+	errorLogger := kafkago.LoggerFunc(logger.Sugar().Warnf)
+
+	b := &Broker{
+		config: cfg,
+		logger: logger,
+		stats:  stats,
+		writer: &kafkago.Writer{
+			Addr:         kafkago.TCP(cfg.Brokers...),
+			Balancer:     &kafkago.Hash{}, // same key, same partition
+			RequiredAcks: acks,
+			Compression:  compression,
+			BatchSize:    cfg.BatchSize,
+			BatchTimeout: cfg.BatchTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			ErrorLogger:  errorLogger,
+			Transport: &kafkago.Transport{
+				ClientID: cfg.ClientID,
+				TLS:      tlsConfig,
+				SASL:     mechanism,
+			},
+		},
+		dialer: &kafkago.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+	}
+
+	return b, nil
+}
+
+// Publish writes messages synchronously, returning once they are
+// acknowledged according to RequiredAcks
+func (b *Broker) Publish(ctx context.Context, msgs ...messaging.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	records := make([]kafkago.Message, len(msgs))
+	for i, msg := range msgs {
+		records[i] = kafkago.Message{
+			Topic:   msg.Topic,
+			Key:     []byte(msg.Key),
+			Value:   msg.Value,
+			Headers: toHeaders(msg.Headers),
+		}
+	}
+
+	start := time.Now()
+	err := b.writer.WriteMessages(ctx, records...)
+	elapsed := time.Since(start)
+
+	for _, msg := range msgs {
+		bucket := fmt.Sprintf("%s.%s", metricsPrefix, messaging.MetricLabel(msg.Topic))
+		if err != nil {
+			b.stats.Increment(bucket + ".publish.error")
+		} else {
+			b.stats.Increment(bucket + ".published")
+		}
+		b.stats.Timing(bucket+".publish.duration", elapsed)
+	}
+
+	if err != nil {
+		b.logger.Error("failed to publish messages", zap.Int("count", len(msgs)), zap.Error(err))
+		return fmt.Errorf("kafka: failed to publish: %w", err)
+	}
+	return nil
+}
+
+// Subscribe registers a handler for a topic. All instances share the
+// configured consumer group, so each message is handled by one instance.
+func (b *Broker) Subscribe(topic string, handler messaging.Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return errors.New("kafka: subscriptions must be registered before Start")
+	}
+	if b.config.GroupID == "" {
+		return errors.New("kafka: group_id is required to subscribe")
+	}
+
+	b.subscriptions = append(b.subscriptions, &subscription{topic: topic, handler: handler})
+	return nil
+}
+
+// Start joins the consumer group for every subscription
+func (b *Broker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return nil
+	}
+	b.started = true
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.workers = supervisor.New(b.ctx, b.logger, b.stats)
+
+	startOffset := kafkago.FirstOffset
+	if b.config.StartOffset == "latest" {
+		startOffset = kafkago.LastOffset
+	}
+
+	for _, sub := range b.subscriptions {
+		sub.reader = kafkago.NewReader(kafkago.ReaderConfig{
+			Brokers:          b.config.Brokers,
+			GroupID:          b.config.GroupID,
+			Topic:            sub.topic,
+			Dialer:           b.dialer,
+			StartOffset:      startOffset,
+			SessionTimeout:   b.config.SessionTimeout,
+			RebalanceTimeout: b.config.RebalanceTimeout,
+			CommitInterval:   0, // commit synchronously after each handled message
+			ErrorLogger:      kafkago.LoggerFunc(b.logger.Sugar().Warnf),
+		})
+
+		// A panicking consumer is restarted with backoff rather than
+		// silently stopping its partitions
+		b.workers.Go("kafka."+messaging.MetricLabel(sub.topic), func(context.Context) error {
+			b.consume(sub)
+			return nil
+		})
+
+		b.logger.Info("kafka consumer started",
+			zap.String("topic", sub.topic),
+			zap.String("group_id", b.config.GroupID))
+	}
+
+	return nil
+}
+
+// Close stops consuming, waits for in-flight handlers, leaves the consumer
+// group so partitions rebalance promptly, and flushes pending writes
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	cancel := b.cancel
+	workers := b.workers
+	b.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if workers != nil {
+		workers.Wait()
+	}
+
+	// Start sets the readers under the lock; the consumers have stopped,
+	// so nothing else waits on it
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var errs []error
+	for _, sub := range b.subscriptions {
+		if sub.reader == nil {
+			continue
+		}
+		if err := sub.reader.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s reader: %w", sub.topic, err))
+		}
+	}
+	if err := b.writer.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close writer: %w", err))
+	}
+
+	b.logger.Info("kafka broker closed")
+	return errors.Join(errs...)
+}
+
+// consume fetches and handles messages until the broker is closed. Offsets
+// are committed only after the handler finishes, giving at-least-once
+// delivery.
+func (b *Broker) consume(sub *subscription) {
+	bucket := fmt.Sprintf("%s.%s", metricsPrefix, messaging.MetricLabel(sub.topic))
+	for {
+		record, err := sub.reader.FetchMessage(b.ctx)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			b.logger.Error("failed to fetch message", zap.String("topic", sub.topic), zap.Error(err))
+			b.stats.Increment(bucket + ".fetch.error")
+			if !b.sleep(time.Second) {
+				return
+			}
+			continue
+		}
+
+		b.stats.Increment(bucket + ".consumed")
+		if !b.handle(sub, record, bucket) {
+			// Closing mid-retry: leave the offset uncommitted for redelivery
+			return
+		}
+
+		// Commit even if the broker is closing; the message was handled
+		if err := sub.reader.CommitMessages(context.Background(), record); err != nil {
+			b.logger.Error("failed to commit offset",
+				zap.String("topic", sub.topic),
+				zap.Int("partition", record.Partition),
+				zap.Int64("offset", record.Offset),
+				zap.Error(err))
+			b.stats.Increment(bucket + ".commit.error")
+		}
+		b.stats.Gauge(bucket+".lag", sub.reader.Stats().Lag)
+	}
+}
+
+// handle runs the handler with retries. It returns false if the broker was
+// closed before the message was handled or given up on.
+func (b *Broker) handle(sub *subscription, record kafkago.Message, bucket string) bool {
+	msg := messaging.Message{
+		Topic:   record.Topic,
+		Key:     string(record.Key),
+		Value:   record.Value,
+		Headers: fromHeaders(record.Headers),
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		// Handlers are not cancelled by Close so in-flight work can finish
+		ctx, cancel := context.WithTimeout(context.Background(), b.config.HandlerTimeout)
+		start := time.Now()
+		err := sub.handler(ctx, msg)
+		cancel()
+		b.stats.Timing(bucket+".handler.duration", time.Since(start))
+
+		if err == nil {
+			return true
+		}
+
+		b.stats.Increment(bucket + ".handler.error")
+		fields := []zap.Field{
+			zap.String("topic", record.Topic),
+			zap.Int("partition", record.Partition),
+			zap.Int64("offset", record.Offset),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		}
+
+		if attempt >= b.config.HandlerMaxAttempts {
+			// Skip the poison message rather than block the partition
+			b.logger.Error("message handler failed, skipping message", fields...)
+			b.stats.Increment(bucket + ".skipped")
+			return true
+		}
+
+		b.logger.Warn("message handler failed, retrying", fields...)
+		if !b.sleep(backoff) {
+			return false
+		}
+		backoff *= 2
+	}
+}
+
+// sleep waits for d, returning false if the broker is closed first
+func (b *Broker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-b.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func toHeaders(headers map[string]string) []kafkago.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	result := make([]kafkago.Header, 0, len(headers))
+	for k, v := range headers {
+		result = append(result, kafkago.Header{Key: k, Value: []byte(v)})
+	}
+	return result
+}
+
+func fromHeaders(headers []kafkago.Header) map[string]string {
+	result := make(map[string]string, len(headers))
+	for _, h := range headers {
+		result[h.Key] = string(h.Value)
+	}
+	return result
+}
+
+// saslMechanism builds the configured SASL mechanism, or nil if none
+func saslMechanism(cfg *config.SASLConfig) (sasl.Mechanism, error) {
+	if cfg == nil || cfg.Mechanism == "" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(cfg.Mechanism) {
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("kafka: unsupported SASL mechanism %q", cfg.Mechanism)
+	}
+}
+
+func requiredAcks(value string) (kafkago.RequiredAcks, error) {
+	switch strings.ToLower(value) {
+	case "", "all":
+		return kafkago.RequireAll, nil
+	case "one":
+		return kafkago.RequireOne, nil
+	case "none":
+		return kafkago.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("kafka: unsupported required_acks %q", value)
+	}
+}
+
+func compressionCodec(value string) (kafkago.Compression, error) {
+	switch strings.ToLower(value) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafkago.Gzip, nil
+	case "snappy":
+		return kafkago.Snappy, nil
+	case "lz4":
+		return kafkago.Lz4, nil
+	case "zstd":
+		return kafkago.Zstd, nil
+	default:
+		return 0, fmt.Errorf("kafka: unsupported compression %q", value)
+	}
+}
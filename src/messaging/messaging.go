@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+)
+
+// Message is a broker-agnostic message. Key determines partitioning (and
+// therefore ordering) on brokers that support it.
+type Message struct {
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+// Publisher sends messages to a broker
+type Publisher interface {
+	Publish(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// Handler processes a received message. Returning an error retries the
+// message a bounded number of times: Kafka calls the handler up to
+// messaging.kafka.handler_max_attempts times and JetStream delivers it up
+// to messaging.nats.jetstream.max_deliver times, after which the message
+// is logged, counted as skipped and not delivered again. Core NATS never
+// redelivers, so a message whose handler fails is dropped. Handlers must
+// be idempotent, and one that can't afford to lose a message must save it
+// somewhere durable before giving up on it.
+type Handler func(ctx context.Context, msg Message) error
+
+// Subscriber delivers messages from a broker to handlers. Subscriptions are
+// registered before Start and consumed until Close.
+type Subscriber interface {
+	Subscribe(topic string, handler Handler) error
+	Start() error
+	Close() error
+}
+
+// Broker is a connection that can both publish and subscribe
+type Broker interface {
+	Publisher
+	Subscriber
+}
+
+// MetricLabel converts a topic or subject into a metric-safe bucket
+// segment, e.g. "orders.created" becomes "orders_created"
+func MetricLabel(topic string) string {
+	if topic == "" {
+		return "unknown"
+	}
+
+	var b strings.Builder
+	for _, c := range topic {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
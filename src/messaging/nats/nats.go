@@ -0,0 +1,354 @@
+package nats
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// metricsPrefix is the bucket prefix for all NATS metrics
+const metricsPrefix = "messaging.nats"
+
+// KeyHeader carries Message.Key, since NATS has no native message key
+const KeyHeader = "Message-Key"
+
+// Broker is a NATS implementation of messaging.Broker. Topics map to
+// subjects. With JetStream disabled, delivery is at-most-once and handler
+// errors are only logged; with JetStream enabled, each subscription is a
+// durable consumer shared by all instances and failed messages are
+// redelivered up to MaxDeliver times.
+type Broker struct {
+	config        *config.NATSConfig
+	logger        *zap.Logger
+	stats         metrics.Agent
+	conn          *natsgo.Conn
+	js            jetstream.JetStream
+	mu            sync.Mutex
+	subscriptions []*subscription
+	started       bool
+	closed        chan struct{}
+}
+
+// subscription is a subject consumed by a handler
+type subscription struct {
+	subject  string
+	handler  messaging.Handler
+	sub      *natsgo.Subscription
+	consumer jetstream.ConsumeContext
+}
+
+var _ messaging.Broker = (*Broker)(nil)
+
+// New connects to NATS
+func New(cfg *config.NATSConfig, logger *zap.Logger, stats metrics.Agent) (*Broker, error) {
+	if len(cfg.Servers) == 0 {
+		return nil, errors.New("nats: at least one server is required")
+	}
+	if cfg.JetStream != nil && cfg.JetStream.Enabled && cfg.JetStream.Stream == "" {
+		return nil, errors.New("nats: jetstream.stream is required when JetStream is enabled")
+	}
+
+	b := &Broker{
+		config: cfg,
+		logger: logger.With(zap.String("component", "messaging.nats")),
+		stats:  stats,
+		closed: make(chan struct{}),
+	}
+
+	options, err := b.options()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := natsgo.Connect(strings.Join(cfg.Servers, ","), options...)
+	if err != nil {
+		return nil, fmt.Errorf("nats: failed to connect: %w", err)
+	}
+	b.conn = conn
+
+	if cfg.JetStream != nil && cfg.JetStream.Enabled {
+		js, err := jetstream.New(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("nats: failed to create JetStream context: %w", err)
+		}
+		b.js = js
+	}
+
+	b.logger.Info("connected to nats",
+		zap.String("server", conn.ConnectedUrlRedacted()),
+		zap.Bool("jetstream", b.js != nil))
+
+	return b, nil
+}
+
+// options builds the connection options from config
+func (b *Broker) options() ([]natsgo.Option, error) {
+	cfg := b.config
+	options := []natsgo.Option{
+		natsgo.Name(cfg.Name),
+		natsgo.Timeout(cfg.ConnectTimeout),
+		natsgo.ReconnectWait(cfg.ReconnectWait),
+		natsgo.MaxReconnects(cfg.MaxReconnects),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			b.logger.Warn("disconnected from nats", zap.Error(err))
+			b.stats.Increment(metricsPrefix + ".disconnected")
+		}),
+		natsgo.ReconnectHandler(func(conn *natsgo.Conn) {
+			b.logger.Info("reconnected to nats", zap.String("server", conn.ConnectedUrlRedacted()))
+			b.stats.Increment(metricsPrefix + ".reconnected")
+		}),
+		natsgo.ErrorHandler(func(_ *natsgo.Conn, sub *natsgo.Subscription, err error) {
+			fields := []zap.Field{zap.Error(err)}
+			if sub != nil {
+				fields = append(fields, zap.String("subject", sub.Subject))
+			}
+			b.logger.Error("nats async error", fields...)
+		}),
+		natsgo.ClosedHandler(func(*natsgo.Conn) {
+			close(b.closed)
+		}),
+	}
+
+	tlsConfig, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	if tlsConfig != nil {
+		options = append(options, natsgo.Secure(tlsConfig))
+	}
+
+	switch {
+	case cfg.CredentialsFile != "":
+		options = append(options, natsgo.UserCredentials(cfg.CredentialsFile))
+	case cfg.Token != "":
+		options = append(options, natsgo.Token(cfg.Token))
+	case cfg.Username != "":
+		options = append(options, natsgo.UserInfo(cfg.Username, cfg.Password))
+	}
+
+	return options, nil
+}
+
+// Publish sends messages in order. With JetStream enabled each publish
+// waits for the stream's acknowledgement.
+func (b *Broker) Publish(ctx context.Context, msgs ...messaging.Message) error {
+	for _, msg := range msgs {
+		bucket := fmt.Sprintf("%s.%s", metricsPrefix, messaging.MetricLabel(msg.Topic))
+		start := time.Now()
+
+		err := b.publish(ctx, msg)
+
+		b.stats.Timing(bucket+".publish.duration", time.Since(start))
+		if err != nil {
+			b.stats.Increment(bucket + ".publish.error")
+			b.logger.Error("failed to publish message", zap.String("subject", msg.Topic), zap.Error(err))
+			return fmt.Errorf("nats: failed to publish to %s: %w", msg.Topic, err)
+		}
+		b.stats.Increment(bucket + ".published")
+	}
+	return nil
+}
+
+func (b *Broker) publish(ctx context.Context, msg messaging.Message) error {
+	record := &natsgo.Msg{
+		Subject: msg.Topic,
+		Data:    msg.Value,
+		Header:  natsgo.Header{},
+	}
+	for k, v := range msg.Headers {
+		record.Header.Set(k, v)
+	}
+	if msg.Key != "" {
+		record.Header.Set(KeyHeader, msg.Key)
+	}
+
+	if b.js != nil {
+		_, err := b.js.PublishMsg(ctx, record)
+		return err
+	}
+	return b.conn.PublishMsg(record)
+}
+
+// Subscribe registers a handler for a subject. Wildcard subjects are
+// supported.
+func (b *Broker) Subscribe(subject string, handler messaging.Handler) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return errors.New("nats: subscriptions must be registered before Start")
+	}
+
+	b.subscriptions = append(b.subscriptions, &subscription{subject: subject, handler: handler})
+	return nil
+}
+
+// Start begins consuming every subscription
+func (b *Broker) Start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return nil
+	}
+	b.started = true
+
+	for _, sub := range b.subscriptions {
+		var err error
+		if b.js != nil {
+			err = b.startConsumer(sub)
+		} else {
+			sub.sub, err = b.conn.QueueSubscribe(sub.subject, b.config.QueueGroup, func(msg *natsgo.Msg) {
+				b.handleCore(sub, msg)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("nats: failed to subscribe to %s: %w", sub.subject, err)
+		}
+
+		b.logger.Info("nats subscription started",
+			zap.String("subject", sub.subject),
+			zap.String("queue_group", b.config.QueueGroup))
+	}
+
+	return nil
+}
+
+// startConsumer creates or updates the durable consumer for a subscription
+func (b *Broker) startConsumer(sub *subscription) error {
+	cfg := b.config.JetStream
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.ConnectTimeout)
+	defer cancel()
+
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, cfg.Stream, jetstream.ConsumerConfig{
+		Durable:       durableName(cfg.Durable, sub.subject),
+		FilterSubject: sub.subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       cfg.AckWait,
+		MaxDeliver:    cfg.MaxDeliver,
+		MaxAckPending: cfg.MaxAckPending,
+	})
+	if err != nil {
+		return err
+	}
+
+	sub.consumer, err = consumer.Consume(func(msg jetstream.Msg) {
+		b.handleJetStream(sub, msg)
+	})
+	return err
+}
+
+// Close drains subscriptions so in-flight handlers finish, then closes
+// the connection
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subscriptions {
+		if sub.consumer != nil {
+			sub.consumer.Drain()
+			<-sub.consumer.Closed()
+		}
+	}
+
+	if err := b.conn.Drain(); err != nil {
+		b.conn.Close()
+		return fmt.Errorf("nats: failed to drain connection: %w", err)
+	}
+	<-b.closed
+
+	b.logger.Info("nats broker closed")
+	return nil
+}
+
+// handleCore processes a core NATS message. There is no redelivery, so a
+// failed message is dropped.
+func (b *Broker) handleCore(sub *subscription, msg *natsgo.Msg) {
+	bucket := fmt.Sprintf("%s.%s", metricsPrefix, messaging.MetricLabel(sub.subject))
+	b.stats.Increment(bucket + ".consumed")
+
+	if err := b.invoke(sub, toMessage(msg.Subject, msg.Data, msg.Header), bucket); err != nil {
+		b.logger.Error("message handler failed, message dropped",
+			zap.String("subject", msg.Subject),
+			zap.Error(err))
+	}
+}
+
+// handleJetStream processes a JetStream message, acknowledging on success
+// and requesting delayed redelivery on failure
+func (b *Broker) handleJetStream(sub *subscription, msg jetstream.Msg) {
+	bucket := fmt.Sprintf("%s.%s", metricsPrefix, messaging.MetricLabel(sub.subject))
+	b.stats.Increment(bucket + ".consumed")
+
+	err := b.invoke(sub, toMessage(msg.Subject(), msg.Data(), msg.Headers()), bucket)
+	if err == nil {
+		if ackErr := msg.Ack(); ackErr != nil {
+			b.logger.Error("failed to ack message", zap.String("subject", msg.Subject()), zap.Error(ackErr))
+			b.stats.Increment(bucket + ".ack.error")
+		}
+		return
+	}
+
+	fields := []zap.Field{zap.String("subject", msg.Subject()), zap.Error(err)}
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		fields = append(fields, zap.Uint64("delivery", meta.NumDelivered))
+		if int(meta.NumDelivered) >= b.config.JetStream.MaxDeliver {
+			b.logger.Error("message handler failed, max deliveries reached", fields...)
+			b.stats.Increment(bucket + ".skipped")
+			return
+		}
+	}
+
+	b.logger.Warn("message handler failed, requesting redelivery", fields...)
+	if nakErr := msg.NakWithDelay(b.config.JetStream.NakDelay); nakErr != nil {
+		b.logger.Error("failed to nak message", zap.String("subject", msg.Subject()), zap.Error(nakErr))
+	}
+}
+
+// invoke runs the handler with the configured timeout and records metrics
+func (b *Broker) invoke(sub *subscription, msg messaging.Message, bucket string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.HandlerTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := sub.handler(ctx, msg)
+	b.stats.Timing(bucket+".handler.duration", time.Since(start))
+	if err != nil {
+		b.stats.Increment(bucket + ".handler.error")
+	}
+	return err
+}
+
+func toMessage(subject string, data []byte, header natsgo.Header) messaging.Message {
+	msg := messaging.Message{
+		Topic:   subject,
+		Key:     header.Get(KeyHeader),
+		Value:   data,
+		Headers: make(map[string]string, len(header)),
+	}
+	for k := range header {
+		if k != KeyHeader {
+			msg.Headers[k] = header.Get(k)
+		}
+	}
+	return msg
+}
+
+// durableName derives a valid consumer name from the prefix and subject,
+// e.g. "myapp" and "orders.*" become "myapp-orders-all"
+func durableName(prefix, subject string) string {
+	replacer := strings.NewReplacer(".", "-", "*", "all", ">", "rest", " ", "-")
+	return prefix + "-" + replacer.Replace(subject)
+}
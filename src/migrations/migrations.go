@@ -371,6 +371,35 @@ func (m *Migrator) applyMigration(ctx context.Context, migration Migration, dire
 	return nil
 }
 
+// Pending returns the migrations not yet applied, oldest first, without
+// creating the tracking table, so it is safe against a database the
+// service only reads
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	migrations, err := m.loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := m.engine.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check for the migrations table: %w", err)
+	}
+	applied := map[int]bool{}
+	if exists {
+		if applied, err = m.getAppliedMigrations(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
 // Status shows the current migration status
 func (m *Migrator) Status(ctx context.Context) error {
 	if err := m.ensureMigrationsTable(ctx); err != nil {
@@ -0,0 +1,40 @@
+package notifications
+
+import (
+	"coffee-and-running/src/mailer"
+	"context"
+	"errors"
+)
+
+// Email sends notifications as plain-text email through the mailer, which
+// queues them again and applies its own retries and suppression list. A
+// notification counts as sent once the mailer has accepted it.
+type Email struct {
+	mail *mailer.Mailer
+}
+
+// NewEmail creates the email channel
+func NewEmail(mail *mailer.Mailer) *Email {
+	return &Email{mail: mail}
+}
+
+// Name returns "email"
+func (e *Email) Name() string { return "email" }
+
+// Send hands msg to the mailer, with the title as the subject
+func (e *Email) Send(ctx context.Context, msg *Message) (string, error) {
+	text := msg.Body
+	if msg.Link != "" {
+		text += "\n\n" + msg.Link
+	}
+	err := e.mail.Send(ctx, &mailer.Message{
+		To:      []string{msg.To},
+		Subject: msg.Title,
+		Text:    text,
+		Tags:    []string{"notification"},
+	})
+	if errors.Is(err, mailer.ErrSuppressed) {
+		return "", &PermanentError{Err: err}
+	}
+	return "", err
+}
@@ -0,0 +1,372 @@
+// Package notifications sends short messages to people over email, SMS,
+// Slack and mobile push. Notifications are queued in the notifications
+// table and delivered in the background by the channel they name, with
+// retries, per-channel rate limits and a status callers can look up.
+package notifications
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// maxErrorLength bounds the channel error stored with a failed attempt
+const maxErrorLength = 1024
+
+// Delivery statuses
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// ErrNotFound is returned for notifications that don't exist
+var ErrNotFound = errors.New("notifications: not found")
+
+// Message is a notification ready to send. To is the channel's address:
+// an email address, an E.164 phone number, a Slack webhook name or a
+// device registration token.
+type Message struct {
+	To    string `json:"to"`
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+	// Link is opened when the notification is tapped or clicked, where
+	// the channel supports it
+	Link string `json:"link,omitempty"`
+	// Data is passed to push notifications as their data payload
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Channel delivers messages over one medium and returns the provider's
+// message ID, if any
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg *Message) (string, error)
+}
+
+// PermanentError marks a failure that retrying won't fix, such as an
+// invalid phone number or an unregistered device
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Delivery is a queued notification and its outcome
+type Delivery struct {
+	ID                int64      `json:"id"`
+	Channel           string     `json:"channel"`
+	To                string     `json:"to"`
+	Template          string     `json:"template,omitempty"`
+	Status            string     `json:"status"`
+	Attempts          int        `json:"attempts"`
+	LastError         *string    `json:"last_error,omitempty"`
+	ProviderMessageID *string    `json:"provider_message_id,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	SentAt            *time.Time `json:"sent_at,omitempty"`
+}
+
+// Notifier queues notifications and delivers them through its channels
+type Notifier struct {
+	config    *config.NotifyConfig
+	channels  map[string]Channel
+	limits    map[string]*rate.Limiter
+	templates *Templates
+	engine    storage.Engine
+	logger    *zap.Logger
+	stats     metrics.Agent
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New creates a notifier delivering through channels, keyed by their
+// names
+func New(cfg *config.NotifyConfig, channels []Channel, templates *Templates, engine storage.Engine, logger *zap.Logger, stats metrics.Agent) *Notifier {
+	n := &Notifier{
+		config:    cfg,
+		channels:  make(map[string]Channel, len(channels)),
+		limits:    make(map[string]*rate.Limiter),
+		templates: templates,
+		engine:    engine,
+		logger:    logger.With(zap.String("component", "notifications")),
+		stats:     stats,
+	}
+	for _, ch := range channels {
+		n.channels[ch.Name()] = ch
+	}
+	for name, limit := range cfg.RateLimits {
+		n.limits[name] = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), limit.Burst)
+	}
+	return n
+}
+
+// Channels returns the names of the configured channels
+func (n *Notifier) Channels() []string {
+	names := make([]string, 0, len(n.channels))
+	for name := range n.channels {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Notify renders the named template for channel and queues it to to,
+// returning the notification's ID:
+//
+//	id, err := notifier.Notify(ctx, "sms", "+15551234567", "login_code", map[string]string{"Code": code})
+func (n *Notifier) Notify(ctx context.Context, channel, to, template string, data interface{}) (int64, error) {
+	msg, err := n.templates.Render(template, channel, data)
+	if err != nil {
+		return 0, err
+	}
+	msg.To = to
+	return n.enqueue(ctx, channel, template, msg)
+}
+
+// Send queues a message built by the caller on channel
+func (n *Notifier) Send(ctx context.Context, channel string, msg *Message) (int64, error) {
+	return n.enqueue(ctx, channel, "", msg)
+}
+
+func (n *Notifier) enqueue(ctx context.Context, channel, template string, msg *Message) (int64, error) {
+	if _, ok := n.channels[channel]; !ok {
+		return 0, fmt.Errorf("notifications: channel %q is not configured", channel)
+	}
+	if msg.To == "" {
+		return 0, errors.New("notifications: recipient is required")
+	}
+	if msg.Body == "" {
+		return 0, errors.New("notifications: body is required")
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode notification: %w", err)
+	}
+	var id int64
+	if err := n.engine.QueryRow(ctx,
+		`INSERT INTO notifications (channel, recipient, template, message) VALUES ($1, $2, NULLIF($3, ''), $4)
+		 RETURNING id`,
+		channel, msg.To, template, payload).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to queue notification: %w", err)
+	}
+	n.stats.Increment("notifications." + channel + ".queued")
+	return id, nil
+}
+
+// Get returns a notification's delivery status
+func (n *Notifier) Get(ctx context.Context, id int64) (*Delivery, error) {
+	var d Delivery
+	var template sql.NullString
+	err := n.engine.QueryRow(ctx,
+		`SELECT id, channel, recipient, template, status, attempts, last_error, provider_message_id, created_at, sent_at
+		 FROM notifications WHERE id = $1`, id).
+		Scan(&d.ID, &d.Channel, &d.To, &template, &d.Status, &d.Attempts, &d.LastError, &d.ProviderMessageID, &d.CreatedAt, &d.SentAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification %d: %w", id, err)
+	}
+	d.Template = template.String
+	return &d, nil
+}
+
+// Start begins delivering queued notifications
+func (n *Notifier) Start() error {
+	n.ctx, n.cancel = context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		ticker := time.NewTicker(n.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+				n.poll()
+			}
+		}
+	}()
+
+	n.logger.Info("notifier started", zap.Strings("channels", n.Channels()), zap.Int("workers", n.config.Workers))
+	return nil
+}
+
+// Close stops delivering and waits for in-flight sends
+func (n *Notifier) Close() error {
+	if n.cancel != nil {
+		n.cancel()
+	}
+	n.wg.Wait()
+	n.logger.Info("notifier stopped")
+	return nil
+}
+
+// queued is a claimed notifications row
+type queued struct {
+	id       int64
+	channel  string
+	message  Message
+	attempts int
+}
+
+// poll claims due notifications and sends them with bounded concurrency
+func (n *Notifier) poll() {
+	batch, err := n.claim(n.ctx)
+	if err != nil {
+		if n.ctx.Err() == nil {
+			n.logger.Error("failed to claim queued notifications", zap.Error(err))
+			n.stats.Increment("notifications.claim.error")
+		}
+		return
+	}
+
+	sem := make(chan struct{}, n.config.Workers)
+	var wg sync.WaitGroup
+	for _, q := range batch {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(q queued) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			n.deliver(q)
+		}(q)
+	}
+	wg.Wait()
+}
+
+// claim leases due notifications by pushing next_attempt_at past the send
+// timeout. Rows for channels this instance doesn't have are left alone.
+func (n *Notifier) claim(ctx context.Context) ([]queued, error) {
+	lease := 2 * n.config.SendTimeout
+	rows, err := n.engine.Query(ctx,
+		`UPDATE notifications SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		 WHERE id IN (
+			SELECT id FROM notifications
+			WHERE status = 'pending' AND next_attempt_at <= NOW() AND channel = ANY($3)
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING id, channel, message, attempts`,
+		n.config.BatchSize, lease.Milliseconds(), pq.Array(n.Channels()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []queued
+	for rows.Next() {
+		var q queued
+		var payload []byte
+		if err := rows.Scan(&q.id, &q.channel, &payload, &q.attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan queued notification: %w", err)
+		}
+		if err := json.Unmarshal(payload, &q.message); err != nil {
+			return nil, fmt.Errorf("failed to decode queued notification %d: %w", q.id, err)
+		}
+		batch = append(batch, q)
+	}
+	return batch, rows.Err()
+}
+
+// deliver sends one notification and records the outcome
+func (n *Notifier) deliver(q queued) {
+	logger := n.logger.With(zap.Int64("notification_id", q.id), zap.String("channel", q.channel), zap.Int("attempt", q.attempts+1))
+	bucket := "notifications." + q.channel
+
+	// Over the channel's rate, put the notification back without counting
+	// an attempt
+	if limiter := n.limits[q.channel]; limiter != nil {
+		reservation := limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			if _, dbErr := n.engine.Exec(n.ctx,
+				"UPDATE notifications SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond' WHERE id = $1",
+				q.id, max(delay, n.config.PollInterval).Milliseconds()); dbErr != nil {
+				logger.Error("failed to defer throttled notification", zap.Error(dbErr))
+			}
+			n.stats.Increment(bucket + ".throttled")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.config.SendTimeout)
+	start := time.Now()
+	messageID, err := n.channels[q.channel].Send(ctx, &q.message)
+	cancel()
+	n.stats.Timing(bucket+".send.duration", time.Since(start))
+
+	if err == nil {
+		if _, dbErr := n.engine.Exec(n.ctx,
+			`UPDATE notifications SET status = 'sent', attempts = attempts + 1, last_error = NULL,
+			     provider_message_id = NULLIF($2, ''), sent_at = NOW()
+			 WHERE id = $1`,
+			q.id, messageID); dbErr != nil {
+			logger.Error("failed to mark notification sent", zap.Error(dbErr))
+		}
+		n.stats.Increment(bucket + ".sent")
+		logger.Debug("notification sent", zap.String("provider_message_id", messageID))
+		return
+	}
+
+	attempts := q.attempts + 1
+	reason := err.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+	n.stats.Increment(bucket + ".send.error")
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) || attempts >= n.config.MaxAttempts {
+		if _, dbErr := n.engine.Exec(n.ctx,
+			"UPDATE notifications SET status = 'failed', attempts = $2, last_error = $3 WHERE id = $1",
+			q.id, attempts, reason); dbErr != nil {
+			logger.Error("failed to mark notification failed", zap.Error(dbErr))
+		}
+		n.stats.Increment(bucket + ".failed")
+		logger.Error("notification failed permanently", zap.Bool("permanent", permanent != nil), zap.Error(err))
+		return
+	}
+
+	wait := n.backoff(attempts)
+	if _, dbErr := n.engine.Exec(n.ctx,
+		`UPDATE notifications SET attempts = $2, last_error = $3,
+		     next_attempt_at = NOW() + $4 * INTERVAL '1 millisecond'
+		 WHERE id = $1`,
+		q.id, attempts, reason, wait.Milliseconds()); dbErr != nil {
+		logger.Error("failed to reschedule notification", zap.Error(dbErr))
+		return
+	}
+	logger.Warn("notification send failed, retry scheduled", zap.Duration("backoff", wait), zap.Error(err))
+}
+
+// backoff returns the exponential delay (with jitter) before attempt+1
+func (n *Notifier) backoff(attempts int) time.Duration {
+	wait := n.config.InitialBackoff << (attempts - 1)
+	if wait <= 0 || wait > n.config.MaxBackoff {
+		wait = n.config.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(wait)/5+1)) - wait/10
+	return wait + jitter
+}
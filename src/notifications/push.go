@@ -0,0 +1,121 @@
+package notifications
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// fcmScope is the OAuth scope for sending with FCM
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCM sends push notifications through the Firebase Cloud Messaging HTTP
+// v1 API. Messages are addressed to a device registration token.
+type FCM struct {
+	config *config.FCMConfig
+	client *http.Client
+}
+
+// NewFCM creates the push channel. Requests go through client's transport
+// with Google credentials added, from the credentials file or Application
+// Default Credentials.
+func NewFCM(ctx context.Context, cfg *config.FCMConfig, client *http.Client) (*FCM, error) {
+	if cfg.ProjectID == "" {
+		return nil, errors.New("fcm project_id is required")
+	}
+	opts := []option.ClientOption{option.WithScopes(fcmScope)}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, err := htransport.NewTransport(ctx, base, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load fcm credentials: %w", err)
+	}
+	authed := *client
+	authed.Transport = transport
+	return &FCM{config: cfg, client: &authed}, nil
+}
+
+// Name returns "push"
+func (f *FCM) Name() string { return "push" }
+
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Webpush      *fcmWebpush       `json:"webpush,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+}
+
+type fcmWebpush struct {
+	FCMOptions struct {
+		Link string `json:"link"`
+	} `json:"fcm_options"`
+}
+
+// Send posts msg to the device token in msg.To. The link is set for web
+// push and passed as the "link" data key for apps to open.
+func (f *FCM) Send(ctx context.Context, msg *Message) (string, error) {
+	m := fcmMessage{
+		Token:        msg.To,
+		Notification: fcmNotification{Title: msg.Title, Body: msg.Body},
+		Data:         msg.Data,
+	}
+	if msg.Link != "" {
+		m.Webpush = &fcmWebpush{}
+		m.Webpush.FCMOptions.Link = msg.Link
+		m.Data = make(map[string]string, len(msg.Data)+1)
+		for k, v := range msg.Data {
+			m.Data[k] = v
+		}
+		m.Data["link"] = msg.Link
+	}
+	body, err := json.Marshal(fcmRequest{Message: m})
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	endpoint := strings.TrimSuffix(f.config.Endpoint, "/") + "/v1/projects/" + url.PathEscape(f.config.ProjectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var sent struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&sent)
+		return sent.Name, nil
+	}
+	return "", providerError("fcm", resp)
+}
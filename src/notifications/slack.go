@@ -0,0 +1,67 @@
+package notifications
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Slack posts to Slack incoming webhooks. Messages are addressed to a
+// webhook by its name in notifications.slack.webhooks.
+type Slack struct {
+	webhooks map[string]string
+	client   *http.Client
+}
+
+// NewSlack creates the Slack channel. client should come from the
+// httpclient factory so calls are traced and measured.
+func NewSlack(cfg *config.SlackConfig, client *http.Client) (*Slack, error) {
+	if len(cfg.Webhooks) == 0 {
+		return nil, errors.New("slack webhooks are required")
+	}
+	return &Slack{webhooks: cfg.Webhooks, client: client}, nil
+}
+
+// Name returns "slack"
+func (s *Slack) Name() string { return "slack" }
+
+// Send posts msg with its title in bold above the body
+func (s *Slack) Send(ctx context.Context, msg *Message) (string, error) {
+	webhook, ok := s.webhooks[msg.To]
+	if !ok {
+		return "", &PermanentError{Err: fmt.Errorf("unknown slack webhook %q", msg.To)}
+	}
+
+	text := msg.Body
+	if msg.Title != "" {
+		text = "*" + msg.Title + "*\n" + text
+	}
+	if msg.Link != "" {
+		text += "\n<" + msg.Link + ">"
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return "", &PermanentError{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return "", nil
+	}
+	return "", providerError("slack", resp)
+}
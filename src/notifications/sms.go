@@ -0,0 +1,80 @@
+package notifications
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Twilio sends SMS through the Twilio Messages API
+type Twilio struct {
+	config *config.TwilioConfig
+	client *http.Client
+}
+
+// NewTwilio creates the SMS channel. client should come from the
+// httpclient factory so calls are traced and measured.
+func NewTwilio(cfg *config.TwilioConfig, client *http.Client) (*Twilio, error) {
+	if cfg.AccountSID == "" || cfg.AuthToken == "" {
+		return nil, errors.New("twilio account_sid and auth_token are required")
+	}
+	return &Twilio{config: cfg, client: client}, nil
+}
+
+// Name returns "sms"
+func (t *Twilio) Name() string { return "sms" }
+
+// Send posts msg's body, and link if any, to msg.To. A From starting with
+// "MG" is used as a messaging service SID.
+func (t *Twilio) Send(ctx context.Context, msg *Message) (string, error) {
+	body := msg.Body
+	if msg.Link != "" {
+		body += " " + msg.Link
+	}
+	form := url.Values{"To": {msg.To}, "Body": {body}}
+	if strings.HasPrefix(t.config.From, "MG") {
+		form.Set("MessagingServiceSid", t.config.From)
+	} else {
+		form.Set("From", t.config.From)
+	}
+
+	endpoint := strings.TrimSuffix(t.config.Endpoint, "/") + "/2010-04-01/Accounts/" + url.PathEscape(t.config.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(t.config.AccountSID, t.config.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var created struct {
+			SID string `json:"sid"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&created)
+		return created.SID, nil
+	}
+	return "", providerError("twilio", resp)
+}
+
+// providerError describes a failed provider response. Rate limiting and
+// server errors are worth retrying; other 4xx are not.
+func providerError(provider string, resp *http.Response) error {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	err := fmt.Errorf("%s returned %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(detail)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &PermanentError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package notifications
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// DefaultTemplates are the templates shipped with the service. Each
+// notification is a pair of files named <name>.title.tmpl and
+// <name>.body.tmpl, the title being optional. A channel can have its own
+// wording in <name>.<channel>.title.tmpl and <name>.<channel>.body.tmpl,
+// e.g. a shorter SMS.
+//
+//go:embed templates/*.tmpl
+var DefaultTemplates embed.FS
+
+// Templates renders named notifications
+type Templates struct {
+	titles map[string]*template.Template
+	bodies map[string]*template.Template
+}
+
+// NewTemplates parses every *.tmpl file in fsys, searching subdirectories
+func NewTemplates(fsys fs.FS) (*Templates, error) {
+	t := &Templates{
+		titles: make(map[string]*template.Template),
+		bodies: make(map[string]*template.Template),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tmpl") {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		base := strings.TrimSuffix(path.Base(p), ".tmpl")
+		dot := strings.LastIndex(base, ".")
+		if dot < 0 {
+			return fmt.Errorf("template %s must be named <name>.<title|body>.tmpl", p)
+		}
+		name, part := base[:dot], base[dot+1:]
+
+		tmpl, err := template.New(base).Option("missingkey=error").Parse(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", p, err)
+		}
+		switch part {
+		case "title":
+			t.titles[name] = tmpl
+		case "body":
+			t.bodies[name] = tmpl
+		default:
+			return fmt.Errorf("template %s has unknown part %q", p, part)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range t.titles {
+		if t.bodies[name] == nil {
+			return nil, fmt.Errorf("template %s has a title but no body", name)
+		}
+	}
+	return t, nil
+}
+
+// Render executes the named template for channel, preferring the
+// channel's own title and body
+func (t *Templates) Render(name, channel string, data interface{}) (*Message, error) {
+	key := name + "." + channel
+	if t.bodies[key] == nil {
+		key = name
+	}
+	body, ok := t.bodies[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification template %q", name)
+	}
+
+	title := t.titles[key]
+	if title == nil {
+		title = t.titles[name]
+	}
+
+	var msg Message
+	var buf bytes.Buffer
+	if title != nil {
+		if err := title.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s title: %w", key, err)
+		}
+		msg.Title = buf.String()
+		buf.Reset()
+	}
+	if err := body.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s body: %w", key, err)
+	}
+	msg.Body = buf.String()
+	return &msg, nil
+}
@@ -39,8 +39,8 @@ func NewLogger(cfg *config.LoggerConfig) (*zap.Logger, error) {
 		return nil, fmt.Errorf("failed to create write syncer: %w", err)
 	}
 
-	// Create core
-	core := zapcore.NewCore(encoder, writeSyncer, level)
+	// Create core, masking any connection string credentials
+	core := RedactingCore(zapcore.NewCore(encoder, writeSyncer, level))
 
 	// Create logger options
 	options := getLoggerOptions(cfg)
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"coffee-and-running/src/config"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RedactingCore masks the passwords of connection strings in messages and
+// in string and error fields before core writes them, in case a
+// driver error or a misplaced DSN reaches a log entry
+func RedactingCore(core zapcore.Core) zapcore.Core {
+	return redactingCore{Core: core}
+}
+
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = config.RedactDSN(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns fields with connection string passwords masked,
+// copying only when one is found
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		var value string
+		switch f.Type {
+		case zapcore.StringType:
+			value = f.String
+		case zapcore.ErrorType:
+			err, ok := f.Interface.(error)
+			if !ok {
+				continue
+			}
+			if value, ok = errorMessage(err); !ok {
+				continue
+			}
+		default:
+			continue
+		}
+
+		masked := config.RedactDSN(value)
+		if masked == value {
+			continue
+		}
+		if out == nil {
+			out = append([]zapcore.Field(nil), fields...)
+		}
+		out[i] = zap.String(f.Key, masked)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// errorMessage returns err.Error(), reporting false when it panics, as it
+// can for a nil pointer error; zap's own encoder reports those
+func errorMessage(err error) (message string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return err.Error(), true
+}
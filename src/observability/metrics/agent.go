@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"coffee-and-running/src/app/supervisor"
 	"coffee-and-running/src/config"
 	"context"
 	"fmt"
@@ -20,13 +21,26 @@ type Agent interface {
 	IsEnabled() bool
 }
 
+// HealthChecker is implemented by agents that can tell whether their sink
+// is receiving metrics
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
 type agent struct {
-	config *config.MetricsConfig
+	config  *config.MetricsConfig
+	logger  *zap.Logger
+	cancel  context.CancelFunc
+	workers *supervisor.Supervisor
+
+	mu     sync.RWMutex // guards client, which reconnect replaces
 	client *statsd.Client
-	logger *zap.Logger
-	ctx    context.Context
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
+
+	errMu       sync.Mutex // guards the fields below
+	sendErrors  int64      // since they were last logged
+	lastError   error
+	lastErrorAt time.Time
+	dialError   error // from the last reconnect
 }
 
 // Close implements Agent.
@@ -34,14 +48,21 @@ func (a *agent) Close() {
 	if a.cancel != nil {
 		a.cancel()
 	}
-	a.wg.Wait()
+	if a.workers != nil {
+		a.workers.Wait()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	if a.client != nil {
 		a.client.Close()
 	}
+	a.logSendErrors()
 }
 
 // Count implements Agent.
 func (a *agent) Count(bucket string, n interface{}) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if a.client != nil {
 		a.client.Count(bucket, n)
 	}
@@ -49,6 +70,8 @@ func (a *agent) Count(bucket string, n interface{}) {
 
 // Gauge implements Agent.
 func (a *agent) Gauge(bucket string, value interface{}) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if a.client != nil {
 		a.client.Gauge(bucket, value)
 	}
@@ -56,6 +79,8 @@ func (a *agent) Gauge(bucket string, value interface{}) {
 
 // Increment implements Agent.
 func (a *agent) Increment(bucket string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if a.client != nil {
 		a.client.Increment(bucket)
 	}
@@ -66,29 +91,29 @@ func (a *agent) IsEnabled() bool {
 	return a.config.Enabled
 }
 
-// Timing implements Agent.
+// Timing implements Agent. time.Duration values are sent in milliseconds,
+// since the statsd client only understands plain numeric types.
 func (a *agent) Timing(bucket string, value interface{}) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if a.client != nil {
+		if d, ok := value.(time.Duration); ok {
+			value = float64(d) / float64(time.Millisecond)
+		}
 		a.client.Timing(bucket, value)
 	}
 }
 
-func NewAgent(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
-	if !cfg.Enabled {
-		logger.Info("metrics disabled, using no-op client")
-		return &agent{
-			config: cfg,
-			logger: logger,
-			// client will be nil, but methods will check for nil
-		}, nil
-	}
+// newStatsd creates the alexcesaro agent, sending to a StatsD server
+func newStatsd(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	agent := &agent{
 		config: cfg,
 		logger: logger,
-		ctx:    ctx,
 		cancel: cancel,
 	}
+	// The agent counts its own goroutines' panics and restarts
+	agent.workers = supervisor.New(ctx, logger, agent)
 	client, err := agent.createClient()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create metrics client: %w", err)
@@ -98,6 +123,9 @@ func NewAgent(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
 	if cfg.ReportInterval > 0 {
 		agent.startPeriodicReporting()
 	}
+	if cfg.ReconnectInterval > 0 {
+		agent.startReconnecting()
+	}
 	logger.Info("metrics agent initialized",
 		zap.String("type", cfg.Type),
 		zap.String("address", cfg.Address),
@@ -123,31 +151,126 @@ func (a *agent) createClient() (*statsd.Client, error) {
 		opts = append(opts, statsd.TagsFormat(statsd.InfluxDB))
 	}
 
+	opts = append(opts, statsd.ErrorHandler(a.sendFailed))
+
 	return statsd.New(opts...)
 }
 
-// startPeriodicReporting starts a goroutine for periodic metric reporting
-func (a *agent) startPeriodicReporting() {
-	a.wg.Add(1)
-	go func() {
-		defer a.wg.Done()
+// sendFailed counts a failed write to the sink. The client calls it while
+// sending, so it only records the error; the reconnect loop logs them.
+func (a *agent) sendFailed(err error) {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	a.sendErrors++
+	a.lastError = err
+	a.lastErrorAt = time.Now()
+}
+
+// startReconnecting starts a supervised goroutine that logs send errors
+// and re-dials the sink every ReconnectInterval. The statsd client
+// resolves and connects once, so without it a restarted agent or a
+// changed DNS record would stop metrics until the service restarts.
+func (a *agent) startReconnecting() {
+	a.workers.Go("metrics.reconnect", func(ctx context.Context) error {
+		ticker := time.NewTicker(a.config.ReconnectInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				a.logSendErrors()
+				a.reconnect()
+			}
+		}
+	})
+}
 
+// reconnect replaces the client with a freshly dialled one, keeping the
+// current client if the sink can't be reached
+func (a *agent) reconnect() {
+	client, err := a.createClient()
+
+	a.errMu.Lock()
+	failing := a.dialError != nil
+	a.dialError = err
+	a.errMu.Unlock()
+
+	if err != nil {
+		if !failing {
+			a.logger.Warn("metrics sink unreachable, keeping the current connection",
+				zap.String("address", a.config.Address), zap.Error(err))
+		}
+		return
+	}
+	if failing {
+		a.logger.Info("metrics sink reachable again", zap.String("address", a.config.Address))
+	}
+
+	a.mu.Lock()
+	old := a.client
+	a.client = client
+	a.mu.Unlock()
+	// Flushes what the old client buffered
+	old.Close()
+}
+
+// logSendErrors logs the send errors counted since the last call
+func (a *agent) logSendErrors() {
+	a.errMu.Lock()
+	n, err := a.sendErrors, a.lastError
+	a.sendErrors = 0
+	a.errMu.Unlock()
+
+	if n > 0 {
+		a.logger.Warn("failed to send metrics",
+			zap.String("address", a.config.Address),
+			zap.Int64("errors", n),
+			zap.NamedError("last_error", err))
+	}
+}
+
+// Check implements HealthChecker. It fails while the sink can't be dialled
+// or a send has failed within the last reconnect interval.
+func (a *agent) Check(ctx context.Context) error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	if a.dialError != nil {
+		return fmt.Errorf("metrics sink unreachable: %w", a.dialError)
+	}
+	window := a.config.ReconnectInterval
+	if window <= 0 {
+		window = a.config.ReportInterval
+	}
+	if a.lastError != nil && time.Since(a.lastErrorAt) < window {
+		return fmt.Errorf("failed to send metrics: %w", a.lastError)
+	}
+	return nil
+}
+
+// startPeriodicReporting starts a supervised goroutine for periodic
+// metric reporting
+func (a *agent) startPeriodicReporting() {
+	a.workers.Go("metrics.reporter", func(ctx context.Context) error {
 		ticker := time.NewTicker(a.config.ReportInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-a.ctx.Done():
-				return
+			case <-ctx.Done():
+				return nil
 			case <-ticker.C:
 				a.reportSystemMetrics()
 			}
 		}
-	}()
+	})
 }
 
 // reportSystemMetrics reports system-level metrics
 func (a *agent) reportSystemMetrics() {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	if a.client != nil {
 		a.client.Gauge("system.uptime", time.Now().Unix())
 		a.client.Increment("system.heartbeat")
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"coffee-and-running/src/app/supervisor"
+	"coffee-and-running/src/config"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// buffered aggregates metrics in memory and hands them to the underlying
+// agent every FlushInterval, so a hot path incrementing the same counter
+// thousands of times a second sends one sum per interval. Counters are
+// summed and gauges keep their last value per bucket; timings are kept as
+// samples. The buffer holds at most BufferSize entries, one per counter or
+// gauge bucket and one per timing sample; past that, metrics are dropped
+// and counted rather than growing memory.
+type buffered struct {
+	config     *config.MetricsConfig
+	underlying Agent
+	logger     *zap.Logger
+	cancel     context.CancelFunc
+	workers    *supervisor.Supervisor
+	full       chan struct{} // asks for an early flush
+
+	mu      sync.Mutex // guards the buffer
+	counts  map[string]float64
+	gauges  map[string]interface{}
+	timings []timing
+	dropped int64
+}
+
+type timing struct {
+	bucket string
+	value  interface{}
+}
+
+func newBuffered(cfg *config.MetricsConfig, underlying Agent, logger *zap.Logger) *buffered {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &buffered{
+		config:     cfg,
+		underlying: underlying,
+		logger:     logger,
+		cancel:     cancel,
+		full:       make(chan struct{}, 1),
+		counts:     make(map[string]float64),
+		gauges:     make(map[string]interface{}),
+	}
+	b.workers = supervisor.New(ctx, logger, underlying)
+	b.workers.Go("metrics.flusher", func(ctx context.Context) error {
+		ticker := time.NewTicker(cfg.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			case <-b.full:
+			}
+			b.flush()
+		}
+	})
+	return b
+}
+
+// newBufferedAgent puts a buffer in front of an agent of UnderlyingType
+func newBufferedAgent(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	if cfg.UnderlyingType == "buffered" {
+		return nil, fmt.Errorf("metrics underlying_type must not be buffered")
+	}
+	if cfg.BufferSize <= 0 || cfg.FlushInterval <= 0 {
+		return nil, fmt.Errorf("buffered metrics need a positive buffer_size and flush_interval")
+	}
+	underlyingCfg := *cfg
+	underlyingCfg.Type = cfg.UnderlyingType
+	underlying, err := NewAgent(&underlyingCfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("metrics buffered",
+		zap.Int("buffer_size", cfg.BufferSize),
+		zap.Duration("flush_interval", cfg.FlushInterval),
+	)
+	return newBuffered(cfg, underlying, logger), nil
+}
+
+// Close implements Agent. What is still buffered is flushed first.
+func (b *buffered) Close() {
+	b.cancel()
+	b.workers.Wait()
+	b.flush()
+	b.underlying.Close()
+}
+
+// Count implements Agent. Values of other than numeric types bypass the
+// buffer.
+func (b *buffered) Count(bucket string, n interface{}) {
+	v, ok := toFloat(n)
+	if !ok {
+		b.underlying.Count(bucket, n)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.counts[bucket]; !exists && !b.reserve() {
+		return
+	}
+	b.counts[bucket] += v
+}
+
+// Gauge implements Agent.
+func (b *buffered) Gauge(bucket string, value interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.gauges[bucket]; !exists && !b.reserve() {
+		return
+	}
+	b.gauges[bucket] = value
+}
+
+// Increment implements Agent.
+func (b *buffered) Increment(bucket string) {
+	b.Count(bucket, 1)
+}
+
+// Timing implements Agent.
+func (b *buffered) Timing(bucket string, value interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.reserve() {
+		return
+	}
+	b.timings = append(b.timings, timing{bucket: bucket, value: value})
+}
+
+// IsEnabled implements Agent.
+func (b *buffered) IsEnabled() bool {
+	return b.underlying.IsEnabled()
+}
+
+// Check implements HealthChecker for the underlying agent's sink
+func (b *buffered) Check(ctx context.Context) error {
+	if checker, ok := b.underlying.(HealthChecker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
+
+// reserve reports whether the buffer has room for another entry, asking
+// for an early flush and counting the drop when it doesn't. b.mu is held.
+func (b *buffered) reserve() bool {
+	if len(b.counts)+len(b.gauges)+len(b.timings) < b.config.BufferSize {
+		return true
+	}
+	b.dropped++
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+	return false
+}
+
+// flush hands the buffer to the underlying agent. The buffer is swapped
+// out first, so callers don't wait on the underlying agent.
+func (b *buffered) flush() {
+	b.mu.Lock()
+	counts, gauges, timings, dropped := b.counts, b.gauges, b.timings, b.dropped
+	b.counts = make(map[string]float64, len(counts))
+	b.gauges = make(map[string]interface{}, len(gauges))
+	b.timings = make([]timing, 0, len(timings))
+	b.dropped = 0
+	b.mu.Unlock()
+
+	for bucket, n := range counts {
+		b.underlying.Count(bucket, n)
+	}
+	for bucket, value := range gauges {
+		b.underlying.Gauge(bucket, value)
+	}
+	for _, t := range timings {
+		b.underlying.Timing(t.bucket, t.value)
+	}
+	if dropped > 0 {
+		b.underlying.Count("metrics.buffer.dropped", dropped)
+		b.logger.Warn("metrics buffer full, dropped metrics",
+			zap.Int64("dropped", dropped),
+			zap.Int("buffer_size", b.config.BufferSize))
+	}
+}
+
+// toFloat converts the numeric types a count may be given as
+func toFloat(n interface{}) (float64, bool) {
+	switch v := n.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"coffee-and-running/src/config"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// InMemory keeps totals in the process instead of sending them anywhere.
+// The inmemory and mock types use it, and it serves a JSON snapshot on
+// metrics.path, so local runs can see what the service records.
+type InMemory struct {
+	prefix string
+
+	mu      sync.Mutex
+	counts  map[string]float64
+	gauges  map[string]float64
+	timings map[string]*TimingSummary
+}
+
+// TimingSummary aggregates the timings sent to one bucket, in
+// milliseconds
+type TimingSummary struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+// Snapshot is a copy of what an InMemory agent has recorded
+type Snapshot struct {
+	Counters map[string]float64       `json:"counters"`
+	Gauges   map[string]float64       `json:"gauges"`
+	Timings  map[string]TimingSummary `json:"timings"`
+}
+
+// NewInMemory creates an empty agent. prefix is prepended to buckets, as
+// the StatsD client would.
+func NewInMemory(prefix string) *InMemory {
+	return &InMemory{
+		prefix:  prefix,
+		counts:  make(map[string]float64),
+		gauges:  make(map[string]float64),
+		timings: make(map[string]*TimingSummary),
+	}
+}
+
+func newInMemory(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	return NewInMemory(cfg.Prefix), nil
+}
+
+// Increment implements Agent.
+func (m *InMemory) Increment(bucket string) {
+	m.Count(bucket, 1)
+}
+
+// Count implements Agent. Values of other than numeric types are ignored.
+func (m *InMemory) Count(bucket string, n interface{}) {
+	v, ok := toFloat(n)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[m.name(bucket)] += v
+}
+
+// Gauge implements Agent.
+func (m *InMemory) Gauge(bucket string, value interface{}) {
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[m.name(bucket)] = v
+}
+
+// Timing implements Agent. time.Duration values are kept in milliseconds.
+func (m *InMemory) Timing(bucket string, value interface{}) {
+	if d, ok := value.(time.Duration); ok {
+		value = float64(d) / float64(time.Millisecond)
+	}
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := m.name(bucket)
+	s, ok := m.timings[name]
+	if !ok {
+		s = &TimingSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+		m.timings[name] = s
+	}
+	s.Count++
+	s.Sum += v
+	s.Min = math.Min(s.Min, v)
+	s.Max = math.Max(s.Max, v)
+}
+
+// Close implements Agent.
+func (m *InMemory) Close() {}
+
+// IsEnabled implements Agent.
+func (m *InMemory) IsEnabled() bool { return true }
+
+// Snapshot returns a copy of everything recorded so far
+func (m *InMemory) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Snapshot{
+		Counters: make(map[string]float64, len(m.counts)),
+		Gauges:   make(map[string]float64, len(m.gauges)),
+		Timings:  make(map[string]TimingSummary, len(m.timings)),
+	}
+	for name, v := range m.counts {
+		s.Counters[name] = v
+	}
+	for name, v := range m.gauges {
+		s.Gauges[name] = v
+	}
+	for name, t := range m.timings {
+		s.Timings[name] = *t
+	}
+	return s
+}
+
+// ServeHTTP writes the snapshot as JSON
+func (m *InMemory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Snapshot())
+}
+
+func (m *InMemory) name(bucket string) string {
+	if m.prefix == "" {
+		return bucket
+	}
+	return m.prefix + "." + bucket
+}
+
+// mock records in memory like inmemory and logs every metric at debug
+// level, for watching metrics go by during development
+type mock struct {
+	*InMemory
+	logger *zap.Logger
+}
+
+func newMock(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	return &mock{
+		InMemory: NewInMemory(cfg.Prefix),
+		logger:   logger.With(zap.String("component", "metrics")),
+	}, nil
+}
+
+// Increment implements Agent.
+func (m *mock) Increment(bucket string) {
+	m.Count(bucket, 1)
+}
+
+// Count implements Agent.
+func (m *mock) Count(bucket string, n interface{}) {
+	m.logger.Debug("count", zap.String("bucket", bucket), zap.Any("value", n))
+	m.InMemory.Count(bucket, n)
+}
+
+// Gauge implements Agent.
+func (m *mock) Gauge(bucket string, value interface{}) {
+	m.logger.Debug("gauge", zap.String("bucket", bucket), zap.Any("value", value))
+	m.InMemory.Gauge(bucket, value)
+}
+
+// Timing implements Agent.
+func (m *mock) Timing(bucket string, value interface{}) {
+	m.logger.Debug("timing", zap.String("bucket", bucket), zap.Any("value", value))
+	m.InMemory.Timing(bucket, value)
+}
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// instrumentationName identifies the meter the otel type records with
+const instrumentationName = "coffee-and-running"
+
+// otelAgent records through the global OpenTelemetry MeterProvider.
+// Counters, gauges and timings become Float64 counters, gauges and
+// histograms named after the bucket. Install a provider with an exporter
+// using otel.SetMeterProvider before the container is built; until then
+// the global provider discards everything.
+type otelAgent struct {
+	prefix string
+	meter  metric.Meter
+	logger *zap.Logger
+
+	mu         sync.Mutex // guards the instrument caches
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64Gauge
+	histograms map[string]metric.Float64Histogram
+}
+
+func newOtel(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	return &otelAgent{
+		prefix:     cfg.Prefix,
+		meter:      otel.GetMeterProvider().Meter(instrumentationName),
+		logger:     logger.With(zap.String("component", "metrics")),
+		counters:   make(map[string]metric.Float64Counter),
+		gauges:     make(map[string]metric.Float64Gauge),
+		histograms: make(map[string]metric.Float64Histogram),
+	}, nil
+}
+
+// Increment implements Agent.
+func (a *otelAgent) Increment(bucket string) {
+	a.Count(bucket, 1)
+}
+
+// Count implements Agent.
+func (a *otelAgent) Count(bucket string, n interface{}) {
+	v, ok := toFloat(n)
+	if !ok {
+		return
+	}
+	if c := instrument(a, a.counters, bucket, a.meter.Float64Counter); c != nil {
+		c.Add(context.Background(), v)
+	}
+}
+
+// Gauge implements Agent.
+func (a *otelAgent) Gauge(bucket string, value interface{}) {
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	if g := instrument(a, a.gauges, bucket, a.meter.Float64Gauge); g != nil {
+		g.Record(context.Background(), v)
+	}
+}
+
+// Timing implements Agent. time.Duration values are recorded in
+// milliseconds.
+func (a *otelAgent) Timing(bucket string, value interface{}) {
+	if d, ok := value.(time.Duration); ok {
+		value = float64(d) / float64(time.Millisecond)
+	}
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	create := func(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+		return a.meter.Float64Histogram(name, metric.WithUnit("ms"))
+	}
+	if h := instrument(a, a.histograms, bucket, create); h != nil {
+		h.Record(context.Background(), v)
+	}
+}
+
+// Close implements Agent. The MeterProvider's owner shuts it down.
+func (a *otelAgent) Close() {}
+
+// IsEnabled implements Agent.
+func (a *otelAgent) IsEnabled() bool { return true }
+
+// instrument returns the cached instrument for bucket, creating it on
+// first use. An instrument that can't be created, e.g. because the name
+// is invalid, is logged and left nil.
+func instrument[I any, O any](a *otelAgent, cache map[string]I, bucket string, create func(string, ...O) (I, error)) I {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if inst, ok := cache[bucket]; ok {
+		return inst
+	}
+	name := bucket
+	if a.prefix != "" {
+		name = a.prefix + "." + bucket
+	}
+	inst, err := create(name)
+	if err != nil {
+		a.logger.Warn("failed to create metric instrument", zap.String("bucket", bucket), zap.Error(err))
+		var zero I
+		inst = zero
+	}
+	cache[bucket] = inst
+	return inst
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bufio"
+	"coffee-and-running/src/config"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// prometheus records in memory and serves the totals in the Prometheus
+// text format on metrics.path, for scraping rather than pushing. Buckets
+// become metric names with anything but letters, digits and underscores
+// replaced: counters gain a _total suffix and timings are summaries of
+// milliseconds, without quantiles.
+type prometheus struct {
+	*InMemory
+}
+
+func newPrometheus(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	return &prometheus{InMemory: NewInMemory(cfg.Prefix)}, nil
+}
+
+// ServeHTTP writes the exposition
+func (p *prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := p.Snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	for _, bucket := range sortedKeys(s.Counters) {
+		name := promName(bucket) + "_total"
+		out.WriteString("# TYPE " + name + " counter\n")
+		out.WriteString(name + " " + promValue(s.Counters[bucket]) + "\n")
+	}
+	for _, bucket := range sortedKeys(s.Gauges) {
+		name := promName(bucket)
+		out.WriteString("# TYPE " + name + " gauge\n")
+		out.WriteString(name + " " + promValue(s.Gauges[bucket]) + "\n")
+	}
+	for _, bucket := range sortedKeys(s.Timings) {
+		name := promName(bucket) + "_milliseconds"
+		t := s.Timings[bucket]
+		out.WriteString("# TYPE " + name + " summary\n")
+		out.WriteString(name + "_sum " + promValue(t.Sum) + "\n")
+		out.WriteString(name + "_count " + strconv.FormatInt(t.Count, 10) + "\n")
+	}
+}
+
+// promName turns a bucket such as "http.server.requests" into a valid
+// metric name
+func promName(bucket string) string {
+	name := []byte(bucket)
+	for i, c := range name {
+		valid := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' && i > 0
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func promValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
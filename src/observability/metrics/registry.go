@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"coffee-and-running/src/config"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnknownType is returned for a metrics.type no constructor is
+// registered for
+var ErrUnknownType = errors.New("metrics: unknown agent type")
+
+// defaultType is used when metrics.type is empty
+const defaultType = "alexcesaro"
+
+// Constructor builds the agent for one metrics.type
+type Constructor func(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error)
+
+// constructors holds the agent types metrics.type selects from
+var constructors = map[string]Constructor{
+	"alexcesaro": newStatsd,
+	"prometheus": newPrometheus,
+	"otel":       newOtel,
+	"inmemory":   newInMemory,
+	"mock":       newMock,
+	"noop":       newNoop,
+}
+
+func init() {
+	// Added here as it builds its underlying agent through the map
+	constructors["buffered"] = newBufferedAgent
+}
+
+// Register adds or replaces the constructor for a metrics.type, such as
+// "datadog". Call it at startup, before the container is built.
+func Register(typ string, construct Constructor) {
+	constructors[typ] = construct
+}
+
+// Types returns the registered metrics types in sorted order
+func Types() []string {
+	types := make([]string, 0, len(constructors))
+	for typ := range constructors {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewAgent builds the agent cfg.Type selects, alexcesaro by default. With
+// metrics disabled it returns one that discards everything.
+func NewAgent(cfg *config.MetricsConfig, logger *zap.Logger) (Agent, error) {
+	if !cfg.Enabled {
+		logger.Info("metrics disabled, using no-op client")
+		return noop{}, nil
+	}
+	typ := cfg.Type
+	if typ == "" {
+		typ = defaultType
+	}
+	construct, ok := constructors[typ]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownType, typ, strings.Join(Types(), ", "))
+	}
+	return construct(cfg, logger)
+}
+
+// noop discards every metric
+type noop struct{}
+
+func newNoop(*config.MetricsConfig, *zap.Logger) (Agent, error) {
+	return noop{}, nil
+}
+
+func (noop) Increment(string)           {}
+func (noop) Count(string, interface{})  {}
+func (noop) Timing(string, interface{}) {}
+func (noop) Gauge(string, interface{})  {}
+func (noop) Close()                     {}
+func (noop) IsEnabled() bool            { return false }
@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// instrumentationName identifies spans created by the kit itself
+const instrumentationName = "coffee-and-running"
+
+type Provider interface {
+	Tracer() trace.Tracer
+	Propagator() propagation.TextMapPropagator
+	Shutdown(ctx context.Context) error
+	IsEnabled() bool
+}
+
+type provider struct {
+	config     *config.TracingConfig
+	logger     *zap.Logger
+	sdk        *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// Tracer implements Provider.
+func (p *provider) Tracer() trace.Tracer {
+	return p.tracer
+}
+
+// Propagator implements Provider.
+func (p *provider) Propagator() propagation.TextMapPropagator {
+	return p.propagator
+}
+
+// IsEnabled implements Provider.
+func (p *provider) IsEnabled() bool {
+	return p.config.Enabled
+}
+
+// Shutdown implements Provider. It flushes any buffered spans.
+func (p *provider) Shutdown(ctx context.Context) error {
+	if p.sdk == nil {
+		return nil
+	}
+
+	p.logger.Info("shutting down tracer provider")
+	if err := p.sdk.Shutdown(ctx); err != nil {
+		p.logger.Error("failed to shut down tracer provider", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// NewProvider creates the tracer provider and registers it, along with the
+// configured propagators, as the OpenTelemetry globals
+func NewProvider(cfg *config.TracingConfig, appCfg *config.AppConfig, logger *zap.Logger) (Provider, error) {
+	propagator, err := newPropagator(cfg.Propagators)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTextMapPropagator(propagator)
+
+	if !cfg.Enabled {
+		logger.Info("tracing disabled, using no-op tracer")
+		return &provider{
+			config:     cfg,
+			logger:     logger,
+			tracer:     noop.NewTracerProvider().Tracer(instrumentationName),
+			propagator: propagator,
+		}, nil
+	}
+
+	exporter, err := newExporter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = appCfg.Name
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(appCfg.Version),
+		semconv.DeploymentEnvironment(appCfg.Environment),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	sdk := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(sdk)
+
+	logger.Info("tracer provider initialized",
+		zap.String("exporter", cfg.Exporter),
+		zap.String("endpoint", cfg.Endpoint),
+		zap.String("service_name", serviceName),
+		zap.Float64("sample_ratio", cfg.SampleRatio),
+		zap.Strings("propagators", cfg.Propagators),
+	)
+
+	return &provider{
+		config:     cfg,
+		logger:     logger,
+		sdk:        sdk,
+		tracer:     sdk.Tracer(instrumentationName),
+		propagator: propagator,
+	}, nil
+}
+
+// newExporter creates the span exporter selected by configuration
+func newExporter(cfg *config.TracingConfig) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+
+	switch strings.ToLower(cfg.Exporter) {
+	case "otlp", "otlp-grpc", "":
+		opts := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "otlp-http":
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter: %s", cfg.Exporter)
+	}
+}
+
+// newPropagator builds a composite propagator from the configured names
+func newPropagator(names []string) (propagation.TextMapPropagator, error) {
+	if len(names) == 0 {
+		names = []string{"tracecontext", "baggage"}
+	}
+
+	propagators := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("unsupported trace propagator: %s", name)
+		}
+	}
+
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
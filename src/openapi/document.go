@@ -0,0 +1,323 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnresolvedRef is returned by Load for a $ref that names nothing in
+// the document's components
+var ErrUnresolvedRef = errors.New("openapi: unresolved $ref")
+
+// Document is an OpenAPI 3 document, reduced to what validation needs
+type Document struct {
+	OpenAPI    string               `yaml:"openapi"`
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Components struct {
+		Schemas       map[string]*Schema      `yaml:"schemas"`
+		Parameters    map[string]*Parameter   `yaml:"parameters"`
+		RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+		Responses     map[string]*Response    `yaml:"responses"`
+	} `yaml:"components"`
+
+	raw    []byte
+	routes []*route
+}
+
+// PathItem holds the operations on one path template
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Options    *Operation   `yaml:"options"`
+	Head       *Operation   `yaml:"head"`
+	Patch      *Operation   `yaml:"patch"`
+}
+
+// Operation is one method on a path
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody describes an operation's accepted bodies by media type
+type RequestBody struct {
+	Ref      string                `yaml:"$ref"`
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// Response describes a response's bodies by media type
+type Response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType holds the schema of one media type
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Load reads a YAML or JSON document from path, resolving its references
+func Load(path string) (*Document, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI document: %w", err)
+	}
+	return Parse(raw)
+}
+
+// Parse parses a YAML or JSON document, resolving its references
+func Parse(raw []byte) (*Document, error) {
+	doc := &Document{raw: raw}
+	if err := yaml.Unmarshal(raw, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q, want 3.x", doc.OpenAPI)
+	}
+	if err := doc.resolve(); err != nil {
+		return nil, err
+	}
+	doc.compileRoutes()
+	return doc, nil
+}
+
+// Raw returns the document as it was read
+func (d *Document) Raw() []byte {
+	return d.raw
+}
+
+// operations returns a path item's operations by method
+func (p *PathItem) operations() map[string]*Operation {
+	ops := map[string]*Operation{
+		http.MethodGet:     p.Get,
+		http.MethodPut:     p.Put,
+		http.MethodPost:    p.Post,
+		http.MethodDelete:  p.Delete,
+		http.MethodOptions: p.Options,
+		http.MethodHead:    p.Head,
+		http.MethodPatch:   p.Patch,
+	}
+	for method, op := range ops {
+		if op == nil {
+			delete(ops, method)
+		}
+	}
+	return ops
+}
+
+// parameters returns an operation's parameters followed by those of its
+// path item it doesn't override with the same name and location
+func parameters(item *PathItem, op *Operation) []*Parameter {
+	params := append([]*Parameter(nil), op.Parameters...)
+	for _, p := range item.Parameters {
+		overridden := false
+		for _, o := range op.Parameters {
+			overridden = overridden || (o.In == p.In && o.Name == p.Name)
+		}
+		if !overridden {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// resolve replaces parameter, body and response references with their
+// targets and links schema references, which may be recursive
+func (d *Document) resolve() error {
+	var errs []error
+	visited := make(map[*Schema]bool)
+	schema := func(s *Schema) {
+		if err := d.resolveSchema(s, visited); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	param := func(p *Parameter) *Parameter {
+		if p.Ref != "" {
+			target := d.Components.Parameters[refName(p.Ref, "parameters")]
+			if target == nil {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrUnresolvedRef, p.Ref))
+				return p
+			}
+			p = target
+		}
+		schema(p.Schema)
+		return p
+	}
+	content := func(c map[string]*MediaType) {
+		for _, m := range c {
+			if m != nil {
+				schema(m.Schema)
+			}
+		}
+	}
+
+	for _, s := range d.Components.Schemas {
+		schema(s)
+	}
+	for _, item := range d.Paths {
+		if item == nil {
+			continue
+		}
+		for i, p := range item.Parameters {
+			item.Parameters[i] = param(p)
+		}
+		for _, op := range item.operations() {
+			for i, p := range op.Parameters {
+				op.Parameters[i] = param(p)
+			}
+			if b := op.RequestBody; b != nil && b.Ref != "" {
+				if op.RequestBody = d.Components.RequestBodies[refName(b.Ref, "requestBodies")]; op.RequestBody == nil {
+					errs = append(errs, fmt.Errorf("%w: %s", ErrUnresolvedRef, b.Ref))
+				}
+			}
+			if b := op.RequestBody; b != nil {
+				content(b.Content)
+			}
+			for status, r := range op.Responses {
+				if r != nil && r.Ref != "" {
+					if r = d.Components.Responses[refName(r.Ref, "responses")]; r == nil {
+						errs = append(errs, fmt.Errorf("%w: %s", ErrUnresolvedRef, op.Responses[status].Ref))
+						continue
+					}
+					op.Responses[status] = r
+				}
+				if r != nil {
+					content(r.Content)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Document) resolveSchema(s *Schema, visited map[*Schema]bool) error {
+	if s == nil || visited[s] {
+		return nil
+	}
+	visited[s] = true
+
+	if s.Ref != "" {
+		s.target = d.Components.Schemas[refName(s.Ref, "schemas")]
+		if s.target == nil {
+			return fmt.Errorf("%w: %s", ErrUnresolvedRef, s.Ref)
+		}
+		return d.resolveSchema(s.target, visited)
+	}
+	children := []*Schema{s.Items}
+	if s.AdditionalProperties != nil {
+		children = append(children, s.AdditionalProperties.Schema)
+	}
+	for _, p := range s.Properties {
+		children = append(children, p)
+	}
+	children = append(children, s.AllOf...)
+	children = append(children, s.AnyOf...)
+	children = append(children, s.OneOf...)
+	var errs []error
+	for _, c := range children {
+		if err := d.resolveSchema(c, visited); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refName returns the component name a local reference such as
+// "#/components/schemas/User" points to, or "" for other references
+func refName(ref, kind string) string {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ref, prefix)
+}
+
+// route is a path template split into segments, "{name}" ones capturing
+type route struct {
+	template string
+	segments []string
+	literals int
+	item     *PathItem
+}
+
+// compileRoutes orders templates so concrete paths match before templated
+// ones, as the specification requires: /users/me before /users/{id}
+func (d *Document) compileRoutes() {
+	for template, item := range d.Paths {
+		if item == nil {
+			continue
+		}
+		r := &route{template: template, segments: strings.Split(strings.Trim(template, "/"), "/"), item: item}
+		for _, seg := range r.segments {
+			if !isParam(seg) {
+				r.literals++
+			}
+		}
+		d.routes = append(d.routes, r)
+	}
+	sort.Slice(d.routes, func(i, j int) bool {
+		if d.routes[i].literals != d.routes[j].literals {
+			return d.routes[i].literals > d.routes[j].literals
+		}
+		return d.routes[i].template < d.routes[j].template
+	})
+}
+
+// match returns the route for path and its path parameters
+func (d *Document) match(path string) (*route, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, r := range d.routes {
+		if len(r.segments) != len(segments) {
+			continue
+		}
+		var params map[string]string
+		matched := true
+		for i, seg := range r.segments {
+			switch {
+			case isParam(seg):
+				if segments[i] == "" {
+					matched = false
+					break
+				}
+				if params == nil {
+					params = make(map[string]string)
+				}
+				params[seg[1:len(seg)-1]] = segments[i]
+			case seg != segments[i]:
+				matched = false
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+func isParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
@@ -0,0 +1,168 @@
+package openapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Endpoint is one documented operation with its path item's parameters
+// merged in
+type Endpoint struct {
+	Method     string
+	Path       string
+	Operation  *Operation
+	Parameters []*Parameter
+}
+
+// Route names the endpoint as "METHOD /path/{param}"
+func (e *Endpoint) Route() string {
+	return e.Method + " " + e.Path
+}
+
+// Endpoints lists the document's operations sorted by path and method
+func (d *Document) Endpoints() []*Endpoint {
+	var endpoints []*Endpoint
+	for path, item := range d.Paths {
+		if item == nil {
+			continue
+		}
+		for method, op := range item.operations() {
+			endpoints = append(endpoints, &Endpoint{Method: method, Path: path, Operation: op, Parameters: parameters(item, op)})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return methodOrder(endpoints[i].Method) < methodOrder(endpoints[j].Method)
+	})
+	return endpoints
+}
+
+func methodOrder(method string) int {
+	for i, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions} {
+		if m == method {
+			return i
+		}
+	}
+	return len(method)
+}
+
+// Example returns a value for s that a request may send: the schema's
+// example or default, its first enum member, or a value built to satisfy
+// its type, format and bounds. Objects get their required properties
+// other than read-only ones.
+func Example(s *Schema) interface{} {
+	return example(s, map[*Schema]bool{})
+}
+
+func example(s *Schema, visiting map[*Schema]bool) interface{} {
+	for s != nil && s.target != nil {
+		s = s.target
+	}
+	if s == nil || visiting[s] {
+		return nil
+	}
+	visiting[s] = true
+	defer delete(visiting, s)
+
+	switch {
+	case s.Example != nil:
+		return s.Example
+	case s.Default != nil:
+		return s.Default
+	case len(s.Enum) > 0:
+		return s.Enum[0]
+	case len(s.AllOf) > 0:
+		merged := map[string]interface{}{}
+		for _, sub := range s.AllOf {
+			if m, ok := example(sub, visiting).(map[string]interface{}); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	case len(s.OneOf) > 0:
+		return example(s.OneOf[0], visiting)
+	case len(s.AnyOf) > 0:
+		return example(s.AnyOf[0], visiting)
+	}
+
+	typ := ""
+	for _, t := range s.Type {
+		if t != "null" {
+			typ = t
+			break
+		}
+	}
+	if typ == "" && len(s.Properties) > 0 {
+		typ = "object"
+	}
+	switch typ {
+	case "string":
+		return exampleString(s)
+	case "integer", "number":
+		n := 1.0
+		if s.Minimum != nil && *s.Minimum > n {
+			n = *s.Minimum
+		}
+		if s.Maximum != nil && *s.Maximum < n {
+			n = *s.Maximum
+		}
+		if typ == "integer" {
+			return int64(n)
+		}
+		return n
+	case "boolean":
+		return true
+	case "array":
+		n := 1
+		if s.MinItems != nil {
+			n = max(n, *s.MinItems)
+		}
+		if s.MaxItems != nil {
+			n = min(n, *s.MaxItems)
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = example(s.Items, visiting)
+		}
+		return items
+	case "object":
+		obj := map[string]interface{}{}
+		for _, name := range s.Required {
+			if p := s.property(name); p != nil && !p.ReadOnly {
+				obj[name] = example(p, visiting)
+			}
+		}
+		return obj
+	}
+	return nil
+}
+
+func exampleString(s *Schema) string {
+	var value string
+	switch s.Format {
+	case "date-time":
+		value = "2024-01-01T00:00:00Z"
+	case "date":
+		value = "2024-01-01"
+	case "email":
+		value = "user@example.com"
+	case "uuid":
+		value = "00000000-0000-4000-8000-000000000000"
+	case "uri", "url":
+		value = "https://example.com"
+	default:
+		value = "example"
+	}
+	if s.MaxLength != nil && len(value) > *s.MaxLength {
+		value = value[:*s.MaxLength]
+	}
+	if s.MinLength != nil && len(value) < *s.MinLength {
+		value += strings.Repeat("x", *s.MinLength-len(value))
+	}
+	return value
+}
@@ -0,0 +1,84 @@
+package openapi
+
+import (
+	"coffee-and-running/src/i18n"
+	"net/http"
+)
+
+// DefaultMessagePrefix is where the validator looks up violation messages
+// in the request's i18n catalog, e.g. "validation.required"
+const DefaultMessagePrefix = "validation"
+
+// messages are the English texts of each violation code, used when the
+// request has no translator or its catalog lacks the code. Placeholders
+// are filled from the violation's Args; {field} is always available.
+var messages = map[string]string{
+	"failed":              "request does not match the API contract",
+	"required":            "is required",
+	"not_null":            "must not be null",
+	"type":                "must be of type {type}",
+	"enum":                "must be one of {values}",
+	"min_items":           "must have at least {min} items",
+	"max_items":           "must have at most {max} items",
+	"any_of":              "must match at least one of the allowed schemas",
+	"one_of":              "must match exactly one of the allowed schemas, matched {matched}",
+	"min_length":          "must be at least {min} characters",
+	"max_length":          "must be at most {max} characters",
+	"pattern":             "must match {pattern}",
+	"format":              "must be a valid {format}",
+	"number":              "must be a number",
+	"minimum":             "must be at least {min}",
+	"maximum":             "must be at most {max}",
+	"not_allowed":         "is not allowed",
+	"content_type":        "content type {content_type} is not accepted",
+	"invalid_json":        "is not valid JSON",
+	"undocumented_status": "{status} is not a documented response",
+	"undocumented_type":   "content type {content_type} is not documented",
+}
+
+// newViolation builds a violation with its English message
+func newViolation(in, field, code string, args i18n.Args) Violation {
+	return Violation{In: in, Field: field, Code: code, Args: args, Message: i18n.Interpolate(messages[code], withField(args, field))}
+}
+
+// withField adds {field} to args
+func withField(args i18n.Args, field string) i18n.Args {
+	out := make(i18n.Args, len(args)+1)
+	for k, v := range args {
+		out[k] = v
+	}
+	out["field"] = field
+	return out
+}
+
+// MessagePrefix changes where violation messages are looked up in the i18n
+// catalog, so an application can keep them under its own keys, e.g.
+// "errors.api" for "errors.api.required"
+func MessagePrefix(prefix string) Option {
+	return func(v *Validator) { v.messagePrefix = prefix }
+}
+
+// localize translates the 400's error and violation messages into the
+// request's locale. Codes the catalog doesn't define keep their English
+// text.
+func (v *Validator) localize(r *http.Request, violations []Violation) (string, []Violation) {
+	t := i18n.FromContext(r.Context())
+	if t == nil {
+		return messages["failed"], violations
+	}
+
+	summary, ok := t.Lookup(v.messagePrefix+".failed", nil)
+	if !ok {
+		summary = messages["failed"]
+	}
+	localized := make([]Violation, len(violations))
+	for i, violation := range violations {
+		if violation.Code != "" {
+			if msg, ok := t.Lookup(v.messagePrefix+"."+violation.Code, withField(violation.Args, violation.Field)); ok {
+				violation.Message = msg
+			}
+		}
+		localized[i] = violation
+	}
+	return summary, localized
+}
@@ -0,0 +1,388 @@
+package openapi
+
+import (
+	"bytes"
+	"coffee-and-running/src/i18n"
+	"coffee-and-running/src/observability/metrics"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxValidatedBody bounds the request and response bodies read for
+// validation. Larger bodies pass through unchecked rather than being held
+// in memory.
+const maxValidatedBody = 1 << 20
+
+// Validator checks requests, and optionally responses, against a document.
+// Paths the document doesn't describe pass through untouched.
+type Validator struct {
+	doc       *Document
+	logger    *zap.Logger
+	stats     metrics.Agent
+	requests  bool
+	responses bool
+	observe   func(r *http.Request, side string, violations []Violation)
+	// messagePrefix is the i18n key prefix of violation messages
+	messagePrefix string
+}
+
+// Option configures a Validator
+type Option func(*Validator)
+
+// ValidateRequests rejects requests that break the contract with a 400
+// listing the violations
+func ValidateRequests() Option {
+	return func(v *Validator) { v.requests = true }
+}
+
+// ValidateResponses logs responses that break the contract. Responses are
+// sent as the handler wrote them; this is meant for development, where
+// the logs show drift between the implementation and the document.
+func ValidateResponses() Option {
+	return func(v *Validator) { v.responses = true }
+}
+
+// OnViolation calls fn with each request or response that breaks the
+// contract, after logging it; side is "request" or "response". Contract
+// tests use it to fail on drift the service would only log.
+func OnViolation(fn func(r *http.Request, side string, violations []Violation)) Option {
+	return func(v *Validator) { v.observe = fn }
+}
+
+// NewValidator creates a validator for doc
+func NewValidator(doc *Document, logger *zap.Logger, stats metrics.Agent, opts ...Option) *Validator {
+	v := &Validator{
+		doc:           doc,
+		logger:        logger.With(zap.String("component", "openapi")),
+		stats:         stats,
+		messagePrefix: DefaultMessagePrefix,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// ServeDocument serves the document the validator checks against
+func (v *Validator) ServeDocument(w http.ResponseWriter, r *http.Request) {
+	contentType := "application/yaml"
+	if trimmed := bytes.TrimSpace(v.doc.raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(v.doc.raw)
+}
+
+// Middleware implements the chi middleware signature
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt, params := v.doc.match(r.URL.Path)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op := rt.item.operations()[r.Method]
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if v.requests {
+			violations := v.checkRequest(r, rt.item, op, params)
+			if len(violations) > 0 {
+				v.stats.Increment("openapi.request.invalid")
+				v.logger.Info("request violates the API contract",
+					zap.String("method", r.Method),
+					zap.String("route", rt.template),
+					zap.Stringers("violations", violations),
+				)
+				if v.observe != nil {
+					v.observe(r, "request", violations)
+				}
+				summary, localized := v.localize(r, violations)
+				writeViolations(w, summary, localized)
+				return
+			}
+		}
+		if !v.responses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tee := &teeWriter{ResponseWriter: w}
+		next.ServeHTTP(tee, r)
+		if violations := v.checkResponse(tee, op); len(violations) > 0 {
+			v.stats.Increment("openapi.response.invalid")
+			v.logger.Warn("response violates the API contract",
+				zap.String("method", r.Method),
+				zap.String("route", rt.template),
+				zap.Int("status", tee.status()),
+				zap.Stringers("violations", violations),
+			)
+			if v.observe != nil {
+				v.observe(r, "response", violations)
+			}
+		}
+	})
+}
+
+func (v *Validator) checkRequest(r *http.Request, item *PathItem, op *Operation, pathParams map[string]string) []Violation {
+	var violations []Violation
+
+	query := r.URL.Query()
+	for _, p := range parameters(item, op) {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := pathParams[p.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		default:
+			continue
+		}
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				violations = append(violations, newViolation(p.In, p.Name, "required", nil))
+			}
+			continue
+		}
+		val := &validator{in: p.In, direction: inRequest}
+		val.validate(p.Schema, coerce(p.Schema, values), "")
+		for _, violation := range val.violations {
+			violation.Field = p.Name + violation.Field
+			violations = append(violations, violation)
+		}
+	}
+
+	body := op.RequestBody
+	if body == nil {
+		return violations
+	}
+	raw, complete := readBody(r)
+	if !complete {
+		return violations
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if body.Required {
+			violations = append(violations, newViolation("body", "", "required", nil))
+		}
+		return violations
+	}
+	media, ok := mediaType(body.Content, r.Header.Get("Content-Type"))
+	if !ok {
+		return append(violations, newViolation("body", "", "content_type", i18n.Args{"content_type": r.Header.Get("Content-Type")}))
+	}
+	return append(violations, validateJSON(media, raw, inRequest)...)
+}
+
+func (v *Validator) checkResponse(tee *teeWriter, op *Operation) []Violation {
+	status := tee.status()
+	response := op.Responses[strconv.Itoa(status)]
+	if response == nil {
+		response = op.Responses[strconv.Itoa(status/100)+"XX"]
+	}
+	if response == nil {
+		response = op.Responses["default"]
+	}
+	if response == nil {
+		return []Violation{newViolation("status", "", "undocumented_status", i18n.Args{"status": status})}
+	}
+	if len(response.Content) == 0 || tee.overflow || tee.body.Len() == 0 {
+		return nil
+	}
+	media, ok := mediaType(response.Content, tee.Header().Get("Content-Type"))
+	if !ok && negotiated(tee.Header()) && response.Content["application/json"] != nil {
+		// Another encoding of the documented JSON, such as MessagePack
+		// picked by httpx.Respond from the Accept header; not inspected
+		return nil
+	}
+	if !ok {
+		return []Violation{newViolation("body", "", "undocumented_type", i18n.Args{"content_type": tee.Header().Get("Content-Type")})}
+	}
+	return validateJSON(media, tee.body.Bytes(), inResponse)
+}
+
+// validateJSON checks a JSON body against media's schema. Other media
+// types aren't inspected.
+func validateJSON(media *MediaType, raw []byte, dir direction) []Violation {
+	if media == nil || media.Schema == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []Violation{newViolation("body", "", "invalid_json", nil)}
+	}
+	val := &validator{in: "body", direction: dir}
+	val.validate(media.Schema, value, "")
+	return val.violations
+}
+
+// mediaType finds the document's entry for a Content-Type header. It
+// returns a nil entry for a declared type that isn't JSON. A missing header
+// is taken as JSON, as the handlers decode bodies without checking it.
+func mediaType(content map[string]*MediaType, header string) (*MediaType, bool) {
+	mt := "application/json"
+	if header != "" {
+		var err error
+		if mt, _, err = mime.ParseMediaType(header); err != nil {
+			return nil, false
+		}
+	}
+	if media, ok := content[mt]; ok {
+		return nilUnlessJSON(media, mt), true
+	}
+	// Wildcards such as "application/*" or "*/*"
+	if slash := strings.IndexByte(mt, '/'); slash > 0 {
+		if media, ok := content[mt[:slash]+"/*"]; ok {
+			return nilUnlessJSON(media, mt), true
+		}
+	}
+	if media, ok := content["*/*"]; ok {
+		return nilUnlessJSON(media, mt), true
+	}
+	return nil, false
+}
+
+// negotiated reports whether the response was picked from the Accept header
+func negotiated(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func nilUnlessJSON(media *MediaType, mt string) *MediaType {
+	if isJSON(mt) {
+		return media
+	}
+	return nil
+}
+
+func isJSON(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// coerce converts parameter strings to the JSON types their schema
+// expects, leaving values that don't parse as strings for validation to
+// report
+func coerce(s *Schema, values []string) interface{} {
+	for s != nil && s.target != nil {
+		s = s.target
+	}
+	if s != nil && s.allows("array") {
+		items := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				items = append(items, coerce(s.Items, []string{part}))
+			}
+		}
+		return items
+	}
+	value := values[0]
+	if s == nil {
+		return value
+	}
+	switch {
+	case s.allows("integer") || s.allows("number"):
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case s.allows("boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// readBody reads the request body for validation and puts it back for the
+// handler. It reports false when the body is too large to validate.
+func readBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBody+1))
+	rest := r.Body
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), rest), rest}
+	if err != nil || len(raw) > maxValidatedBody {
+		return nil, false
+	}
+	return raw, true
+}
+
+// writeViolations responds with a 400 listing what broke the contract
+func writeViolations(w http.ResponseWriter, summary string, violations []Violation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      summary,
+		"violations": violations,
+	})
+}
+
+// teeWriter passes a response through while keeping a copy of its body
+type teeWriter struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (t *teeWriter) WriteHeader(code int) {
+	if t.code == 0 {
+		t.code = code
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	if t.code == 0 {
+		t.code = http.StatusOK
+	}
+	if !t.overflow {
+		if t.body.Len()+len(b) > maxValidatedBody {
+			t.overflow = true
+			t.body.Reset()
+		} else {
+			t.body.Write(b)
+		}
+	}
+	return t.ResponseWriter.Write(b)
+}
+
+func (t *teeWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (t *teeWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+func (t *teeWriter) status() int {
+	if t.code == 0 {
+		return http.StatusOK
+	}
+	return t.code
+}
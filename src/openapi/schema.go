@@ -0,0 +1,346 @@
+package openapi
+
+import (
+	"coffee-and-running/src/i18n"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Schema is the subset of JSON Schema that OpenAPI 3.0 and 3.1 documents
+// commonly use. Unknown keywords are ignored.
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 Types              `yaml:"type"`
+	Format               string             `yaml:"format"`
+	Nullable             bool               `yaml:"nullable"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	Pattern              string             `yaml:"pattern"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	AnyOf                []*Schema          `yaml:"anyOf"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+	ReadOnly             bool               `yaml:"readOnly"`
+	WriteOnly            bool               `yaml:"writeOnly"`
+	Example              interface{}        `yaml:"example"`
+	Default              interface{}        `yaml:"default"`
+
+	target  *Schema // set for references
+	once    sync.Once
+	pattern *regexp.Regexp
+}
+
+// Types is a schema's type: one name in OpenAPI 3.0, or a list in 3.1
+type Types []string
+
+func (t *Types) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = Types{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// Additional is additionalProperties: false, true or a schema
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return node.Decode(&a.Schema)
+}
+
+// direction is which side of an exchange a value is, which decides whether
+// readOnly or writeOnly properties may be required
+type direction int
+
+const (
+	inRequest direction = iota
+	inResponse
+)
+
+// Violation is one way a value breaks the contract
+type Violation struct {
+	In      string    `json:"in"`              // body, path, query or header
+	Field   string    `json:"field,omitempty"` // JSON pointer or parameter name
+	Code    string    `json:"code,omitempty"`  // message key, e.g. "min_length"
+	Args    i18n.Args `json:"args,omitempty"`  // placeholders of the message, e.g. {"min": 3}
+	Message string    `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.In + ": " + v.Message
+	}
+	return v.In + " " + v.Field + ": " + v.Message
+}
+
+// validator collects the violations of one value
+type validator struct {
+	in         string
+	direction  direction
+	violations []Violation
+}
+
+func (v *validator) fail(pointer, code string, args i18n.Args) {
+	v.violations = append(v.violations, newViolation(v.in, pointer, code, args))
+}
+
+// validate checks value, as decoded by encoding/json with UseNumber,
+// against s
+func (v *validator) validate(s *Schema, value interface{}, pointer string) {
+	for s != nil && s.target != nil {
+		s = s.target
+	}
+	if s == nil {
+		return
+	}
+
+	if value == nil {
+		if !s.Nullable && len(s.Type) > 0 && !s.allows("null") {
+			v.fail(pointer, "not_null", nil)
+		}
+		return
+	}
+	if len(s.Type) > 0 && !s.allows(typeOf(value)) {
+		v.fail(pointer, "type", i18n.Args{"type": strings.Join(s.Type, " or ")})
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.fail(pointer, "enum", i18n.Args{"values": s.Enum})
+	}
+
+	switch value := value.(type) {
+	case string:
+		v.validateString(s, value, pointer)
+	case json.Number:
+		v.validateNumber(s, value, pointer)
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			v.fail(pointer, "min_items", i18n.Args{"min": *s.MinItems})
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			v.fail(pointer, "max_items", i18n.Args{"max": *s.MaxItems})
+		}
+		for i, item := range value {
+			v.validate(s.Items, item, fmt.Sprintf("%s/%d", pointer, i))
+		}
+	case map[string]interface{}:
+		v.validateObject(s, value, pointer)
+	}
+
+	for _, sub := range s.AllOf {
+		v.validate(sub, value, pointer)
+	}
+	if len(s.AnyOf) > 0 && v.matching(s.AnyOf, value, pointer) == 0 {
+		v.fail(pointer, "any_of", nil)
+	}
+	if len(s.OneOf) > 0 {
+		if n := v.matching(s.OneOf, value, pointer); n != 1 {
+			v.fail(pointer, "one_of", i18n.Args{"matched": n})
+		}
+	}
+}
+
+func (v *validator) validateString(s *Schema, value, pointer string) {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		v.fail(pointer, "min_length", i18n.Args{"min": *s.MinLength})
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.fail(pointer, "max_length", i18n.Args{"max": *s.MaxLength})
+	}
+	if s.Pattern != "" {
+		if re := s.compiled(); re != nil && !re.MatchString(value) {
+			v.fail(pointer, "pattern", i18n.Args{"pattern": s.Pattern})
+		}
+	}
+
+	var ok bool
+	switch s.Format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		ok = err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, value)
+		ok = err == nil
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		ok = err == nil && addr.Address == value
+	case "uuid":
+		ok = uuidPattern.MatchString(value)
+	default:
+		return
+	}
+	if !ok {
+		v.fail(pointer, "format", i18n.Args{"format": s.Format})
+	}
+}
+
+func (v *validator) validateNumber(s *Schema, value json.Number, pointer string) {
+	n, err := value.Float64()
+	if err != nil {
+		v.fail(pointer, "number", nil)
+		return
+	}
+	if s.Minimum != nil && n < *s.Minimum {
+		v.fail(pointer, "minimum", i18n.Args{"min": *s.Minimum})
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		v.fail(pointer, "maximum", i18n.Args{"max": *s.Maximum})
+	}
+}
+
+func (v *validator) validateObject(s *Schema, value map[string]interface{}, pointer string) {
+	for _, name := range s.Required {
+		if _, ok := value[name]; ok {
+			continue
+		}
+		// The other side supplies these
+		if p := s.property(name); p != nil && ((v.direction == inRequest && p.ReadOnly) || (v.direction == inResponse && p.WriteOnly)) {
+			continue
+		}
+		v.fail(pointer+"/"+escapePointer(name), "required", nil)
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := pointer + "/" + escapePointer(name)
+		if p, ok := s.Properties[name]; ok {
+			v.validate(p, value[name], field)
+			continue
+		}
+		if a := s.AdditionalProperties; a != nil {
+			if !a.Allowed {
+				v.fail(field, "not_allowed", nil)
+			} else if a.Schema != nil {
+				v.validate(a.Schema, value[name], field)
+			}
+		}
+	}
+}
+
+// matching counts the schemas value matches
+func (v *validator) matching(schemas []*Schema, value interface{}, pointer string) int {
+	n := 0
+	for _, sub := range schemas {
+		trial := &validator{in: v.in, direction: v.direction}
+		trial.validate(sub, value, pointer)
+		if len(trial.violations) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Schema) allows(name string) bool {
+	for _, t := range s.Type {
+		if t == name || (t == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) property(name string) *Schema {
+	p := s.Properties[name]
+	for p != nil && p.target != nil {
+		p = p.target
+	}
+	return p
+}
+
+// compiled returns the schema's pattern, or nil when it isn't a valid
+// regular expression
+func (s *Schema) compiled() *regexp.Regexp {
+	s.once.Do(func() {
+		s.pattern, _ = regexp.Compile(s.Pattern)
+	})
+	return s.pattern
+}
+
+// typeOf names value's JSON Schema type
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) && !strings.ContainsAny(value.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+// inEnum compares value with the enum's YAML-decoded members
+func inEnum(enum []interface{}, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, _ := n.Float64()
+		for _, e := range enum {
+			switch e := e.(type) {
+			case int:
+				if float64(e) == f {
+					return true
+				}
+			case float64:
+				if e == f {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, e := range enum {
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapePointer escapes a property name for a JSON pointer
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,84 @@
+package operations
+
+import (
+	"coffee-and-running/src/apperr"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Mount registers GET <operations.path>/{id}
+func (m *Manager) Mount(r chi.Router) {
+	r.Get(m.cfg.Path+"/{id}", m.get)
+}
+
+// WriteAccepted responds 202 with op, pointing the client at the URL to
+// poll:
+//
+//	op, err := ops.Enqueue(r.Context(), "posts_export", exportPosts)
+//	if err != nil {
+//		apperr.WriteHTTP(w, err)
+//		return
+//	}
+//	ops.WriteAccepted(w, op)
+func (m *Manager) WriteAccepted(w http.ResponseWriter, op *Operation) {
+	w.Header().Set("Location", m.cfg.Path+"/"+op.ID)
+	m.write(w, http.StatusAccepted, op)
+}
+
+func (m *Manager) get(w http.ResponseWriter, r *http.Request) {
+	op, err := m.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if apperr.KindOf(err) == "" {
+			m.logger.Error("Failed to load operation", zap.Error(err))
+		}
+		apperr.WriteHTTP(w, err)
+		return
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag(op)) {
+		m.headers(w, op)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	m.write(w, http.StatusOK, op)
+}
+
+func (m *Manager) write(w http.ResponseWriter, status int, op *Operation) {
+	m.headers(w, op)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(op); err != nil {
+		m.logger.Debug("Failed to write operation", zap.String("operation_id", op.ID), zap.Error(err))
+	}
+}
+
+// headers sets the validators and, while the operation runs, how long
+// to wait before polling again
+func (m *Manager) headers(w http.ResponseWriter, op *Operation) {
+	w.Header().Set("ETag", etag(op))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !op.Done() && m.cfg.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(max(m.cfg.RetryAfter.Seconds(), 1))))
+	}
+}
+
+// etag changes with every update, so a poll with If-None-Match costs a
+// 304 until there is news
+func etag(op *Operation) string {
+	return fmt.Sprintf(`"%s-%d"`, op.ID, op.Version)
+}
+
+func etagMatches(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == tag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+// Package operations tracks long-running work started by a request. The
+// handler responds 202 Accepted with the operation, the work runs on the
+// shared worker pool and records its progress in the operations table,
+// and the client polls GET /operations/{id} for the status and result.
+package operations
+
+import (
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/concurrency"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/requestid"
+	"coffee-and-running/src/storage"
+	"coffee-and-running/src/tenancy"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotFound is returned for operations that don't exist, have expired or
+// belong to another caller
+var ErrNotFound error = apperr.New(apperr.NotFound, "operations", "operation not found")
+
+// Operation statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Operation is a unit of long-running work and its outcome
+type Operation struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Status     string          `json:"status"`
+	Progress   int             `json:"progress"` // percent
+	Message    string          `json:"message,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+
+	// Version increases with every change and makes the ETag
+	Version int `json:"-"`
+}
+
+// Done reports whether the operation has finished, either way
+func (o *Operation) Done() bool {
+	return o.Status == StatusSucceeded || o.Status == StatusFailed
+}
+
+// Func is the work of an operation. Its result is stored as JSON. A
+// classified error's message is shown to the client; other errors are
+// logged and reported as "internal error". ctx carries the starting
+// request's values but not its cancellation, and ends after
+// operations.timeout.
+type Func func(ctx context.Context, progress *Progress) (interface{}, error)
+
+// Progress records how far an operation has got
+type Progress struct {
+	manager *Manager
+	id      string
+}
+
+// Update stores percent, from 0 to 100, and a short message for pollers.
+// Each call writes to the database, so report at coarse steps.
+func (p *Progress) Update(ctx context.Context, percent int, message string) error {
+	percent = min(max(percent, 0), 100)
+	_, err := p.manager.engine.Exec(ctx,
+		`UPDATE operations SET progress = $2, message = $3, version = version + 1, updated_at = NOW()
+		 WHERE id = $1 AND status = 'running'`,
+		p.id, percent, message)
+	return err
+}
+
+// Manager starts operations and serves their status
+type Manager struct {
+	cfg    *config.OperationsConfig
+	engine storage.Engine
+	pool   *concurrency.Pool
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewManager creates a manager running operations on pool
+func NewManager(cfg *config.OperationsConfig, engine storage.Engine, pool *concurrency.Pool, logger *zap.Logger, stats metrics.Agent) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		engine: engine,
+		pool:   pool,
+		logger: logger.With(zap.String("component", "operations")),
+		stats:  stats,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Enqueue records an operation of kind, owned by the caller and tenant in
+// ctx, and queues fn to run it on the worker pool. kind labels metrics, so use a fixed name
+// such as "posts_export".
+func (m *Manager) Enqueue(ctx context.Context, kind string, fn Func) (*Operation, error) {
+	owner, _ := auth.FromContext(ctx).String("sub")
+	tenant, _ := tenancy.FromContext(ctx)
+	op, err := m.scan(m.engine.QueryRow(ctx,
+		`INSERT INTO operations (id, kind, owner, tenant_id) VALUES ($1, $2, $3, $4)
+		 RETURNING `+columns,
+		requestid.Generate(), kind, owner, tenant))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create operation: %w", err)
+	}
+
+	// The work outlives the request that started it
+	err = m.pool.Submit(context.WithoutCancel(ctx), func(ctx context.Context) {
+		m.run(ctx, op, fn)
+	})
+	if err != nil {
+		m.finish(ctx, op, StatusFailed, nil, "operation could not be started")
+		return nil, apperr.Wrap(err, apperr.Unavailable, "operations", "server is busy")
+	}
+	m.stats.Increment("operations." + kind + ".started")
+	return op, nil
+}
+
+// Get returns the operation id if the caller and tenant in ctx started it
+func (m *Manager) Get(ctx context.Context, id string) (*Operation, error) {
+	owner, _ := auth.FromContext(ctx).String("sub")
+	tenant, _ := tenancy.FromContext(ctx)
+	op, err := m.scan(m.engine.QueryRow(ctx,
+		`SELECT `+columns+` FROM operations WHERE id = $1 AND owner = $2 AND tenant_id = $3`,
+		id, owner, tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return op, err
+}
+
+const columns = `id, kind, status, progress, message, result, COALESCE(error, ''), version, created_at, updated_at, finished_at`
+
+func (m *Manager) scan(row *sql.Row) (*Operation, error) {
+	var op Operation
+	var result []byte
+	var finished sql.NullTime
+	err := row.Scan(&op.ID, &op.Kind, &op.Status, &op.Progress, &op.Message, &result, &op.Error,
+		&op.Version, &op.CreatedAt, &op.UpdatedAt, &finished)
+	if err != nil {
+		return nil, storage.Classify(err)
+	}
+	if result != nil {
+		op.Result = result
+	}
+	if finished.Valid {
+		op.FinishedAt = &finished.Time
+	}
+	return &op, nil
+}
+
+// run executes fn on a pool worker and stores its outcome
+func (m *Manager) run(ctx context.Context, op *Operation, fn Func) {
+	start := time.Now()
+	logger := m.logger.With(zap.String("operation_id", op.ID), zap.String("kind", op.Kind))
+	bucket := "operations." + op.Kind
+
+	if _, err := m.engine.Exec(ctx,
+		`UPDATE operations SET status = 'running', version = version + 1, updated_at = NOW() WHERE id = $1`,
+		op.ID); err != nil {
+		logger.Error("Failed to mark operation running", zap.Error(err))
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	result, err := m.call(runCtx, op, fn, logger)
+	m.stats.Timing(bucket+".duration", time.Since(start))
+
+	var payload []byte
+	if err == nil && result != nil {
+		if payload, err = json.Marshal(result); err != nil {
+			err = fmt.Errorf("failed to encode result: %w", err)
+		}
+	}
+	if err != nil {
+		message := apperr.Message(err)
+		if message == "" {
+			logger.Error("Operation failed", zap.Error(err))
+			message = "internal error"
+		}
+		if runCtx.Err() == context.DeadlineExceeded {
+			message = "operation timed out"
+		}
+		m.stats.Increment(bucket + ".failed")
+		m.finish(ctx, op, StatusFailed, nil, message)
+		return
+	}
+	m.stats.Increment(bucket + ".succeeded")
+	m.finish(ctx, op, StatusSucceeded, payload, "")
+}
+
+// call runs fn, turning a panic into a failure so the operation doesn't
+// stay running until the sweeper times it out
+func (m *Manager) call(ctx context.Context, op *Operation, fn Func, logger *zap.Logger) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Error("Operation panicked", zap.Any("panic", p), zap.ByteString("stack", debug.Stack()))
+			err = fmt.Errorf("operation panicked: %v", p)
+		}
+	}()
+	return fn(ctx, &Progress{manager: m, id: op.ID})
+}
+
+func (m *Manager) finish(ctx context.Context, op *Operation, status string, result []byte, message string) {
+	var resultArg, errText interface{}
+	if result != nil {
+		resultArg = result
+	}
+	if message != "" {
+		errText = message
+	}
+	if _, err := m.engine.Exec(ctx,
+		`UPDATE operations SET status = $2, result = $3, error = $4,
+		 progress = CASE WHEN $2 = 'succeeded' THEN 100 ELSE progress END,
+		 version = version + 1, updated_at = NOW(), finished_at = NOW()
+		 WHERE id = $1`,
+		op.ID, status, resultArg, errText); err != nil {
+		m.logger.Error("Failed to store operation outcome",
+			zap.String("operation_id", op.ID), zap.String("status", status), zap.Error(err))
+	}
+}
+
+// Start runs the sweeper, which fails operations that outlived
+// operations.timeout, e.g. because the instance running them died, and
+// deletes finished operations after operations.retention
+func (m *Manager) Start() error {
+	go m.sweepLoop()
+	return nil
+}
+
+// Close stops the sweeper. Running operations finish on the worker pool,
+// which drains after this.
+func (m *Manager) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
+}
+
+func (m *Manager) sweepLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.sweep()
+		}
+	}
+}
+
+func (m *Manager) sweep() {
+	ctx, cancel := context.WithTimeout(storage.WithPartition(context.Background(), storage.Background), m.cfg.SweepInterval)
+	defer cancel()
+
+	// A sweep interval of grace lets the owner record a late outcome itself
+	res, err := m.engine.Exec(ctx,
+		`UPDATE operations SET status = 'failed', error = 'operation timed out',
+		 version = version + 1, updated_at = NOW(), finished_at = NOW()
+		 WHERE status IN ('pending', 'running') AND created_at < NOW() - $1 * INTERVAL '1 millisecond'`,
+		(m.cfg.Timeout + m.cfg.SweepInterval).Milliseconds())
+	if err != nil {
+		m.logger.Error("Failed to time out stale operations", zap.Error(err))
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		m.logger.Warn("Timed out stale operations", zap.Int64("count", n))
+		m.stats.Count("operations.timed_out", n)
+	}
+
+	if _, err := m.engine.Exec(ctx,
+		`DELETE FROM operations WHERE finished_at < NOW() - $1 * INTERVAL '1 millisecond'`,
+		m.cfg.Retention.Milliseconds()); err != nil {
+		m.logger.Error("Failed to delete expired operations", zap.Error(err))
+	}
+}
@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Event is a domain event recorded in the outbox. Events with the same
+// AggregateType and AggregateID are published in the order they were written.
+type Event struct {
+	AggregateType string
+	AggregateID   string
+	EventType     string
+	// Topic defaults to AggregateType when empty
+	Topic   string
+	Payload interface{}
+	Headers map[string]string
+}
+
+// Execer is satisfied by *storage.InstrumentedTx. Events must be written in
+// the same transaction as the business change they describe.
+type Execer interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Write records events in the outbox within the caller's transaction
+func Write(ctx context.Context, tx Execer, events ...Event) error {
+	for _, event := range events {
+		if event.AggregateType == "" || event.AggregateID == "" || event.EventType == "" {
+			return fmt.Errorf("outbox event requires aggregate type, aggregate id and event type")
+		}
+
+		payload, err := json.Marshal(event.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal outbox payload for %s: %w", event.EventType, err)
+		}
+
+		headers := event.Headers
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headerJSON, err := json.Marshal(headers)
+		if err != nil {
+			return fmt.Errorf("failed to marshal outbox headers for %s: %w", event.EventType, err)
+		}
+
+		topic := event.Topic
+		if topic == "" {
+			topic = event.AggregateType
+		}
+
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, topic, payload, headers)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			event.AggregateType, event.AggregateID, event.EventType, topic, payload, headerJSON); err != nil {
+			return fmt.Errorf("failed to write outbox event %s: %w", event.EventType, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package outbox
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// relayLockKey is the advisory lock that elects a single active relay, which
+// is what guarantees per-aggregate ordering across replicas
+const relayLockKey = 0x6f7574626f78 // "outbox"
+
+// Header names added to every published message
+const (
+	HeaderEventID       = "event-id"
+	HeaderEventType     = "event-type"
+	HeaderAggregateType = "aggregate-type"
+	HeaderAggregateID   = "aggregate-id"
+)
+
+// Relay publishes outbox events to a broker with at-least-once semantics.
+// Consumers must therefore be idempotent (the event-id header helps).
+type Relay struct {
+	config    *config.OutboxConfig
+	engine    storage.Engine
+	publisher messaging.Publisher
+	logger    *zap.Logger
+	stats     metrics.Agent
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// pending is an unpublished outbox row
+type pending struct {
+	id            int64
+	aggregateType string
+	aggregateID   string
+	eventType     string
+	topic         string
+	payload       []byte
+	headers       []byte
+	createdAt     time.Time
+}
+
+// NewRelay creates an outbox relay
+func NewRelay(cfg *config.OutboxConfig, engine storage.Engine, publisher messaging.Publisher, logger *zap.Logger, stats metrics.Agent) *Relay {
+	return &Relay{
+		config:    cfg,
+		engine:    engine,
+		publisher: publisher,
+		logger:    logger.With(zap.String("component", "outbox.relay")),
+		stats:     stats,
+	}
+}
+
+// Start begins relaying events in the background
+func (r *Relay) Start() {
+	r.ctx, r.cancel = context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.config.PollInterval)
+		defer ticker.Stop()
+
+		lastCleanup := time.Now()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				// Keep draining while full batches come back
+				for {
+					n, err := r.RelayBatch(r.ctx)
+					if err != nil {
+						if r.ctx.Err() == nil {
+							r.logger.Error("outbox relay batch failed", zap.Error(err))
+							r.stats.Increment("outbox.relay.error")
+						}
+						break
+					}
+					if n < r.config.BatchSize {
+						break
+					}
+				}
+
+				if r.config.Retention > 0 && time.Since(lastCleanup) > time.Hour {
+					r.cleanup(r.ctx)
+					lastCleanup = time.Now()
+				}
+			}
+		}
+	}()
+
+	r.logger.Info("outbox relay started",
+		zap.Duration("poll_interval", r.config.PollInterval),
+		zap.Int("batch_size", r.config.BatchSize))
+}
+
+// Close stops the relay after the in-flight batch completes
+func (r *Relay) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	r.logger.Info("outbox relay stopped")
+}
+
+// RelayBatch publishes up to BatchSize events in id order and returns how
+// many were published. Only the instance holding the advisory lock relays;
+// others return immediately.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.engine.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Use a variable to track if we should rollback
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				r.logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	rows, err := tx.Query(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLockKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire relay lock: %w", err)
+	}
+	var leader bool
+	if rows.Next() {
+		err = rows.Scan(&leader)
+	}
+	rows.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire relay lock: %w", err)
+	}
+	if !leader {
+		return 0, nil
+	}
+
+	batch, err := r.loadBatch(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	blocked := make(map[string]bool)
+	for _, event := range batch {
+		aggregate := event.aggregateType + "/" + event.aggregateID
+		// A failed event blocks later events of the same aggregate to keep order
+		if blocked[aggregate] {
+			continue
+		}
+
+		if err := r.publish(ctx, event); err != nil {
+			blocked[aggregate] = true
+			r.logger.Warn("failed to publish outbox event",
+				zap.Int64("event_id", event.id),
+				zap.String("event_type", event.eventType),
+				zap.String("aggregate", aggregate),
+				zap.Error(err))
+			r.stats.Increment("outbox.publish.error")
+			if _, err := tx.Exec(ctx,
+				"UPDATE outbox_events SET attempts = attempts + 1, last_error = $2 WHERE id = $1",
+				event.id, err.Error()); err != nil {
+				return published, fmt.Errorf("failed to record outbox failure: %w", err)
+			}
+			continue
+		}
+
+		if _, err := tx.Exec(ctx,
+			"UPDATE outbox_events SET published_at = NOW(), attempts = attempts + 1, last_error = NULL WHERE id = $1",
+			event.id); err != nil {
+			return published, fmt.Errorf("failed to mark outbox event published: %w", err)
+		}
+		published++
+		r.stats.Increment("outbox.published")
+		r.stats.Timing("outbox.lag", time.Since(event.createdAt))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox batch: %w", err)
+	}
+	committed = true
+
+	if published > 0 {
+		r.logger.Debug("outbox batch relayed", zap.Int("published", published), zap.Int("batch", len(batch)))
+	}
+	return published, nil
+}
+
+// loadBatch reads the oldest unpublished events
+func (r *Relay) loadBatch(ctx context.Context, tx *storage.InstrumentedTx) ([]pending, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT id, aggregate_type, aggregate_id, event_type, topic, payload, headers, created_at
+		 FROM outbox_events WHERE published_at IS NULL
+		 ORDER BY id LIMIT $1`,
+		r.config.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.aggregateType, &p.aggregateID, &p.eventType, &p.topic,
+			&p.payload, &p.headers, &p.createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		batch = append(batch, p)
+	}
+	return batch, rows.Err()
+}
+
+// publish sends one event, keyed by aggregate ID so brokers that partition
+// by key preserve per-aggregate order
+func (r *Relay) publish(ctx context.Context, event pending) error {
+	headers := map[string]string{}
+	if len(event.headers) > 0 {
+		if err := json.Unmarshal(event.headers, &headers); err != nil {
+			return fmt.Errorf("invalid stored headers: %w", err)
+		}
+	}
+	headers[HeaderEventID] = strconv.FormatInt(event.id, 10)
+	headers[HeaderEventType] = event.eventType
+	headers[HeaderAggregateType] = event.aggregateType
+	headers[HeaderAggregateID] = event.aggregateID
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.PublishTimeout)
+	defer cancel()
+
+	return r.publisher.Publish(ctx, messaging.Message{
+		Topic:   event.topic,
+		Key:     event.aggregateID,
+		Value:   event.payload,
+		Headers: headers,
+	})
+}
+
+// cleanup deletes published events older than the retention period
+func (r *Relay) cleanup(ctx context.Context) {
+	result, err := r.engine.Exec(ctx,
+		"DELETE FROM outbox_events WHERE published_at < NOW() - $1 * INTERVAL '1 second'",
+		int64(r.config.Retention.Seconds()))
+	if err != nil {
+		r.logger.Error("failed to clean up outbox events", zap.Error(err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		r.logger.Info("published outbox events cleaned up", zap.Int64("deleted", n))
+	}
+}
+
+// PendingCount returns the number of unpublished events, useful for health
+// checks and lag alerts
+func (r *Relay) PendingCount(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.engine.QueryRow(ctx, "SELECT COUNT(*) FROM outbox_events WHERE published_at IS NULL").Scan(&count)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	r.stats.Gauge("outbox.pending", count)
+	return count, nil
+}
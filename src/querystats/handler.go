@@ -0,0 +1,76 @@
+package querystats
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// maxLimit bounds the statements one request may ask for
+const maxLimit = 500
+
+// Handler exposes the top statements
+type Handler struct {
+	reporter *Reporter
+}
+
+// NewHandler creates the admin handler
+func NewHandler(reporter *Reporter) *Handler {
+	return &Handler{reporter: reporter}
+}
+
+// Mount registers GET /admin/database/statements
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/database/statements", h.top)
+}
+
+// top lists the top statements, ranked by ?order= (total_time, calls or
+// mean_time) and up to ?limit=
+func (h *Handler) top(w http.ResponseWriter, r *http.Request) {
+	order := r.URL.Query().Get("order")
+	switch order {
+	case "":
+		order = OrderTotalTime
+	case OrderTotalTime, OrderCalls, OrderMeanTime:
+	default:
+		writeError(w, http.StatusBadRequest, "order must be total_time, calls or mean_time")
+		return
+	}
+	limit := h.reporter.config.Limit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+		limit = n
+	}
+
+	statements, err := h.reporter.Top(r.Context(), order, limit)
+	if errors.Is(err, ErrUnavailable) {
+		writeError(w, http.StatusServiceUnavailable, "pg_stat_statements is not installed; add it to shared_preload_libraries and CREATE EXTENSION pg_stat_statements")
+		return
+	}
+	if err != nil {
+		h.reporter.logger.Error("failed to read top statements", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to read statement statistics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order":      order,
+		"statements": statements,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
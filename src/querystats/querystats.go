@@ -0,0 +1,254 @@
+// Package querystats reads the pg_stat_statements extension to find the
+// statements the database spends its time on. Each is reported with the
+// same normalized text and fingerprint the engine's logs use, so a top
+// query can be traced to the code and requests that run it.
+//
+// Postgres replaces a statement's literals with placeholders itself, so
+// statements written with placeholders get the fingerprint of their log
+// entries; ones with inline literals are numbered differently by Postgres
+// and get one of their own.
+package querystats
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrUnavailable is returned when the pg_stat_statements extension isn't
+// installed in the database
+var ErrUnavailable = errors.New("querystats: pg_stat_statements is not installed")
+
+// Orders the top statements can be ranked by
+const (
+	OrderTotalTime = "total_time"
+	OrderCalls     = "calls"
+	OrderMeanTime  = "mean_time"
+)
+
+// Statement is one statement's statistics since they were last reset
+type Statement struct {
+	QueryID     int64   `json:"query_id"`
+	Fingerprint string  `json:"fingerprint"`
+	Query       string  `json:"query"`
+	Calls       int64   `json:"calls"`
+	TotalTimeMs float64 `json:"total_time_ms"`
+	MeanTimeMs  float64 `json:"mean_time_ms"`
+	Rows        int64   `json:"rows"`
+	// CacheHitRatio is the share of blocks read from shared buffers
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// Reporter reads pg_stat_statements on demand and reports the top
+// statements every interval
+type Reporter struct {
+	config *config.QueryStatsConfig
+	engine storage.Engine
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu   sync.Mutex
+	last map[int64]Statement // the previous report's statements by query ID
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a reporter reading through engine
+func New(cfg *config.QueryStatsConfig, engine storage.Engine, logger *zap.Logger, stats metrics.Agent) *Reporter {
+	return &Reporter{
+		config: cfg,
+		engine: engine,
+		logger: logger.With(zap.String("component", "querystats")),
+		stats:  stats,
+	}
+}
+
+// Top returns up to limit statements of the current database, or all of
+// them for 0, ranked by order. It returns ErrUnavailable without the
+// extension.
+func (r *Reporter) Top(ctx context.Context, order string, limit int) ([]Statement, error) {
+	var installed bool
+	err := r.engine.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')`).Scan(&installed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for pg_stat_statements: %w", storage.Classify(err))
+	}
+	if !installed {
+		return nil, ErrUnavailable
+	}
+
+	var version string
+	if err := r.engine.QueryRow(ctx, `SHOW server_version_num`).Scan(&version); err != nil {
+		return nil, fmt.Errorf("failed to read the server version: %w", storage.Classify(err))
+	}
+	// Postgres 13 renamed the timing columns when it added planning time
+	total, mean := "total_exec_time", "mean_exec_time"
+	if n, _ := strconv.Atoi(version); n < 130000 {
+		total, mean = "total_time", "mean_time"
+	}
+	var orderBy string
+	switch order {
+	case OrderTotalTime, "":
+		orderBy = total
+	case OrderCalls:
+		orderBy = "calls"
+	case OrderMeanTime:
+		orderBy = mean
+	default:
+		return nil, fmt.Errorf("unsupported order %q", order)
+	}
+
+	var n interface{} // LIMIT NULL is no limit
+	if limit > 0 {
+		n = limit
+	}
+	rows, err := r.engine.Query(ctx, fmt.Sprintf(
+		`SELECT queryid, query, calls, %s, %s, rows, shared_blks_hit, shared_blks_read
+		 FROM pg_stat_statements
+		 WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		   AND queryid IS NOT NULL
+		 ORDER BY %s DESC
+		 LIMIT $1`, total, mean, orderBy), n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	defer rows.Close()
+
+	statements := []Statement{}
+	for rows.Next() {
+		var s Statement
+		var hit, read int64
+		if err := rows.Scan(&s.QueryID, &s.Query, &s.Calls, &s.TotalTimeMs, &s.MeanTimeMs, &s.Rows, &hit, &read); err != nil {
+			return nil, fmt.Errorf("failed to read pg_stat_statements: %w", storage.Classify(err))
+		}
+		// Normalized again so literals Postgres kept, such as those in
+		// DDL, stay out of responses and logs
+		s.Fingerprint = storage.QueryFingerprint(s.Query)
+		s.Query = storage.NormalizeQuery(s.Query)
+		if hit+read > 0 {
+			s.CacheHitRatio = float64(hit) / float64(hit+read)
+		}
+		statements = append(statements, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", storage.Classify(err))
+	}
+	return statements, nil
+}
+
+// Report logs and sends metrics for the statements that took the most
+// time since the previous report, as db.statements.<fingerprint>.calls and
+// .time, up to database.query_stats.limit of them
+func (r *Reporter) Report(ctx context.Context) error {
+	statements, err := r.Top(ctx, OrderTotalTime, 0)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	last := r.last
+	r.last = make(map[int64]Statement, len(statements))
+	for _, s := range statements {
+		r.last[s.QueryID] = s
+	}
+	r.mu.Unlock()
+	if last == nil {
+		// The first report only has totals since the statistics were reset
+		return nil
+	}
+
+	type activity struct {
+		Statement
+		calls int64
+		time  float64
+	}
+	var active []activity
+	for _, s := range statements {
+		a := activity{Statement: s, calls: s.Calls, time: s.TotalTimeMs}
+		if prev, ok := last[s.QueryID]; ok && prev.Calls <= s.Calls {
+			// Otherwise the statistics were reset since; statements
+			// without a previous entry are new
+			a.calls -= prev.Calls
+			a.time -= prev.TotalTimeMs
+		}
+		if a.calls > 0 {
+			active = append(active, a)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].time > active[j].time
+	})
+	if len(active) > r.config.Limit {
+		active = active[:r.config.Limit]
+	}
+
+	for i, a := range active {
+		bucket := "db.statements." + a.Fingerprint
+		r.stats.Count(bucket+".calls", a.calls)
+		r.stats.Timing(bucket+".time", time.Duration(a.time*float64(time.Millisecond)))
+		r.logger.Info("top query",
+			zap.Int("rank", i+1),
+			zap.String("query", a.Query),
+			zap.String("fingerprint", a.Fingerprint),
+			zap.Int64("calls", a.calls),
+			zap.Float64("time_ms", a.time),
+			zap.Float64("mean_time_ms", a.time/float64(a.calls)),
+			zap.Duration("interval", r.config.Interval))
+	}
+	return nil
+}
+
+// Start reports the top statements every database.query_stats.interval
+func (r *Reporter) Start() error {
+	ctx, cancel := context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+		for {
+			r.report(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *Reporter) report(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, r.config.Timeout)
+	defer cancel()
+	err := r.Report(ctx)
+	switch {
+	case errors.Is(err, ErrUnavailable):
+		r.logger.Debug("pg_stat_statements is not installed, skipping the report")
+	case err != nil && parent.Err() == nil:
+		r.logger.Warn("failed to report top queries", zap.Error(err))
+		r.stats.Increment("db.statements.report.error")
+	}
+}
+
+// Close stops reporting
+func (r *Reporter) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Handler exposes the rate limit admin API
+type Handler struct {
+	limiter *Limiter
+	logger  *zap.Logger
+}
+
+// NewHandler creates the admin handler
+func NewHandler(limiter *Limiter, logger *zap.Logger) *Handler {
+	return &Handler{
+		limiter: limiter,
+		logger:  logger.With(zap.String("component", "ratelimit.handler")),
+	}
+}
+
+// Mount registers GET /admin/rate-limits and POST /admin/rate-limits/reload
+func (h *Handler) Mount(r chi.Router) {
+	r.Get("/admin/rate-limits", h.listPolicies)
+	r.Post("/admin/rate-limits/reload", h.reload)
+}
+
+func (h *Handler) listPolicies(w http.ResponseWriter, r *http.Request) {
+	policies := h.limiter.Policies()
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].key() < policies[j].key()
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"default":  h.limiter.Default(),
+		"policies": policies,
+	})
+}
+
+// reload applies policy changes on this instance without waiting for the
+// next refresh
+func (h *Handler) reload(w http.ResponseWriter, r *http.Request) {
+	if err := h.limiter.Refresh(r.Context()); err != nil {
+		h.logger.Error("failed to reload rate limit policies", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to reload policies")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"policies": len(h.limiter.Policies())})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/tenancy"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Limiter enforces the default limit and the policies loaded from its
+// provider, refreshed in the background so checking a request never
+// touches the database
+type Limiter struct {
+	config   *config.RateLimitConfig
+	provider Provider
+	logger   *zap.Logger
+	stats    metrics.Agent
+
+	policyMu sync.RWMutex
+	policies map[string]Policy
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	limit   Limit
+	used    time.Time
+}
+
+// Decision is the outcome of checking a request
+type Decision struct {
+	Allowed bool
+	// Scope is the policy scope that applied, or "default"
+	Scope      string
+	Limit      Limit
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// NewLimiter creates a limiter and loads the initial policies. provider may
+// be nil to apply only the default limit.
+func NewLimiter(cfg *config.RateLimitConfig, provider Provider, logger *zap.Logger, stats metrics.Agent) (*Limiter, error) {
+	l := &Limiter{
+		config:   cfg,
+		provider: provider,
+		logger:   logger.With(zap.String("component", "ratelimit")),
+		stats:    stats,
+		policies: make(map[string]Policy),
+		buckets:  make(map[string]*bucket),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Refresh reloads the policies from the provider. Buckets pick up a changed
+// limit on their next request.
+func (l *Limiter) Refresh(ctx context.Context) error {
+	if l.provider == nil {
+		return nil
+	}
+	policies, err := l.provider.Policies(ctx)
+	if err != nil {
+		l.stats.Increment("ratelimit.refresh.error")
+		return fmt.Errorf("failed to load rate limit policies: %w", err)
+	}
+
+	snapshot := make(map[string]Policy, len(policies))
+	for _, policy := range policies {
+		snapshot[policy.key()] = policy
+	}
+
+	l.policyMu.Lock()
+	l.policies = snapshot
+	l.policyMu.Unlock()
+	l.stats.Gauge("ratelimit.policies", len(snapshot))
+	return nil
+}
+
+// Policies returns the current policies
+func (l *Limiter) Policies() []Policy {
+	l.policyMu.RLock()
+	defer l.policyMu.RUnlock()
+
+	policies := make([]Policy, 0, len(l.policies))
+	for _, policy := range l.policies {
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// Default returns the limit for callers without a policy
+func (l *Limiter) Default() Limit {
+	return Limit{RequestsPerSecond: l.config.RequestsPerSecond, Burst: l.config.Burst}
+}
+
+// Allow takes a token for the request. A subject's policy wins over its
+// tenant's; without either, the default limit applies per subject, or per
+// client IP for anonymous requests.
+func (l *Limiter) Allow(r *http.Request) Decision {
+	key, scope, limit := l.resolve(r)
+	now := time.Now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), limit.Burst), limit: limit}
+		l.buckets[key] = b
+	} else if b.limit != limit {
+		b.limiter.SetLimitAt(now, rate.Limit(limit.RequestsPerSecond))
+		b.limiter.SetBurstAt(now, limit.Burst)
+		b.limit = limit
+	}
+	b.used = now
+	reservation := b.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	remaining := int(math.Max(0, math.Floor(b.limiter.TokensAt(now))))
+	l.mu.Unlock()
+
+	return Decision{
+		Allowed:    delay == 0,
+		Scope:      scope,
+		Limit:      limit,
+		Remaining:  remaining,
+		RetryAfter: delay,
+	}
+}
+
+func (l *Limiter) resolve(r *http.Request) (key, scope string, limit Limit) {
+	subject := auth.Subject(r)
+	tenant, _ := tenancy.FromContext(r.Context())
+
+	l.policyMu.RLock()
+	defer l.policyMu.RUnlock()
+	if subject != "" {
+		if policy, ok := l.policies[ScopeSubject+":"+subject]; ok {
+			return policy.key(), ScopeSubject, policy.Limit
+		}
+	}
+	if tenant != "" {
+		if policy, ok := l.policies[ScopeTenant+":"+tenant]; ok {
+			return policy.key(), ScopeTenant, policy.Limit
+		}
+	}
+	if subject != "" {
+		return "default:subject:" + subject, "default", l.Default()
+	}
+	return "default:ip:" + clientip.FromRequest(r), "default", l.Default()
+}
+
+// Handler rejects requests over their limit with 429 and a Retry-After
+// header. Every response carries RateLimit-Limit and RateLimit-Remaining.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := l.Allow(r)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		if d.Allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		l.stats.Increment("ratelimit." + d.Scope + ".limited")
+		l.logger.Debug("Rate limited request",
+			zap.String("scope", d.Scope),
+			zap.String("path", r.URL.Path),
+			zap.Duration("retry_after", d.RetryAfter))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded"})
+	})
+}
+
+// Start refreshes the policies every rate_limit.refresh_interval and
+// forgets buckets idle for rate_limit.idle_timeout
+func (l *Limiter) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(l.config.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Refresh(ctx); err != nil && ctx.Err() == nil {
+					// Keep enforcing the last good policies
+					l.logger.Error("rate limit policy refresh failed", zap.Error(err))
+				}
+				l.evict(time.Now().Add(-l.config.IdleTimeout))
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the background refresh
+func (l *Limiter) Close() error {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.wg.Wait()
+	return nil
+}
+
+// evict forgets buckets unused since before; they would have refilled by
+// now anyway
+func (l *Limiter) evict(before time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.used.Before(before) {
+			delete(l.buckets, key)
+		}
+	}
+	l.stats.Gauge("ratelimit.buckets", len(l.buckets))
+}
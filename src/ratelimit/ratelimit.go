@@ -0,0 +1,98 @@
+// Package ratelimit limits the request rate of each caller with a token
+// bucket. Callers get the configured default limit unless a policy puts
+// them, or their tenant, on a plan, so tiers can be sold and changed by
+// editing rate_limit_plans and rate_limit_policies without a deploy.
+//
+// Buckets live in memory, so each instance enforces its limits
+// independently.
+package ratelimit
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Scopes a policy applies to
+const (
+	// ScopeTenant shares one bucket between everyone in the tenant
+	ScopeTenant = "tenant"
+	// ScopeSubject gives an authenticated subject, such as a user or an API
+	// client, its own bucket
+	ScopeSubject = "subject"
+)
+
+// Limit is a sustained rate and the burst allowed above it
+type Limit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// Policy puts a tenant or subject on a plan
+type Policy struct {
+	Scope     string    `json:"scope"`
+	Subject   string    `json:"subject"`
+	Plan      string    `json:"plan"`
+	Limit     Limit     `json:"limit"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// key identifies the policy's bucket
+func (p Policy) key() string {
+	return p.Scope + ":" + p.Subject
+}
+
+// Provider loads policies
+type Provider interface {
+	Policies(ctx context.Context) ([]Policy, error)
+}
+
+// DatabaseProvider reads policies and their plans from the
+// rate_limit_policies and rate_limit_plans tables
+type DatabaseProvider struct {
+	engine storage.Engine
+}
+
+// NewDatabaseProvider creates a provider backed by the rate limit tables
+func NewDatabaseProvider(engine storage.Engine) *DatabaseProvider {
+	return &DatabaseProvider{engine: engine}
+}
+
+// Policies loads every policy with its plan's limit
+func (p *DatabaseProvider) Policies(ctx context.Context) ([]Policy, error) {
+	rows, err := p.engine.Query(ctx,
+		`SELECT p.scope, p.subject, p.plan, l.requests_per_second, l.burst,
+		        GREATEST(p.updated_at, l.updated_at)
+		 FROM rate_limit_policies p
+		 JOIN rate_limit_plans l ON l.name = p.plan`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []Policy
+	for rows.Next() {
+		var policy Policy
+		if err := rows.Scan(&policy.Scope, &policy.Subject, &policy.Plan,
+			&policy.Limit.RequestsPerSecond, &policy.Limit.Burst, &policy.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := validate(policy); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// validate checks a policy loaded from a provider
+func validate(policy Policy) error {
+	if policy.Scope != ScopeTenant && policy.Scope != ScopeSubject {
+		return fmt.Errorf("rate limit policy for %q has unknown scope %q", policy.Subject, policy.Scope)
+	}
+	if policy.Limit.RequestsPerSecond <= 0 || policy.Limit.Burst <= 0 {
+		return fmt.Errorf("rate limit plan %q must have a positive rate and burst", policy.Plan)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package render
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Flash kinds used by the default templates
+const (
+	FlashSuccess = "success"
+	FlashInfo    = "info"
+	FlashError   = "error"
+)
+
+// Flash is a one-time message shown on the next rendered page, typically
+// after a redirect
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// Flashes stores flash messages in a signed cookie, so they survive a
+// redirect without server-side sessions
+type Flashes struct {
+	cookieName string
+	key        []byte
+	secure     bool
+}
+
+// NewFlashes creates a flash store signing cookies with key
+func NewFlashes(cookieName, key string, secure bool) *Flashes {
+	return &Flashes{cookieName: cookieName, key: []byte(key), secure: secure}
+}
+
+// Add queues a message for the next page rendered for this client
+func (f *Flashes) Add(w http.ResponseWriter, r *http.Request, kind, message string) {
+	flashes := append(f.read(r), Flash{Kind: kind, Message: message})
+	data, _ := json.Marshal(flashes)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     f.cookieName,
+		Value:    payload + "." + f.sign(payload),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   f.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Pop returns the queued messages and clears them
+func (f *Flashes) Pop(w http.ResponseWriter, r *http.Request) []Flash {
+	flashes := f.read(r)
+	if len(flashes) > 0 {
+		http.SetCookie(w, &http.Cookie{
+			Name:     f.cookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   f.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return flashes
+}
+
+func (f *Flashes) read(r *http.Request) []Flash {
+	cookie, err := r.Cookie(f.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	payload, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(f.sign(payload))) {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+
+	var flashes []Flash
+	if err := json.Unmarshal(data, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+func (f *Flashes) sign(payload string) string {
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
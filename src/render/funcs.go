@@ -0,0 +1,47 @@
+package render
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// defaultFuncs are available in every template
+func defaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"join":  strings.Join,
+		// formatTime formats t with a Go layout, e.g. {{formatTime .CreatedAt "2006-01-02"}}
+		"formatTime": func(t time.Time, layout string) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(layout)
+		},
+		// default returns fallback when value is empty, e.g. {{default .Name "Anonymous"}}
+		"default": func(value, fallback interface{}) interface{} {
+			if value == nil || value == "" {
+				return fallback
+			}
+			return value
+		},
+		// dict builds a map for passing several values to a partial, e.g.
+		// {{template "field" dict "Label" "Email" "Name" "email"}}
+		"dict": func(pairs ...interface{}) (map[string]interface{}, error) {
+			if len(pairs)%2 != 0 {
+				return nil, errors.New("dict requires key/value pairs")
+			}
+			m := make(map[string]interface{}, len(pairs)/2)
+			for i := 0; i < len(pairs); i += 2 {
+				key, ok := pairs[i].(string)
+				if !ok {
+					return nil, errors.New("dict keys must be strings")
+				}
+				m[key] = pairs[i+1]
+			}
+			return m, nil
+		},
+	}
+}
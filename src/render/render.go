@@ -0,0 +1,201 @@
+package render
+
+import (
+	"bytes"
+	"coffee-and-running/src/auth"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// DefaultTemplates returns the built-in layout, partials and pages
+func DefaultTemplates() fs.FS {
+	sub, _ := fs.Sub(defaultTemplates, "templates")
+	return sub
+}
+
+// View is the data every template receives. Handler data is under .Data.
+type View struct {
+	Data      interface{}
+	Flashes   []Flash
+	CSRFToken string
+	// CSRFField is a ready-made hidden input for forms
+	CSRFField template.HTML
+	User      auth.Claims
+	Path      string
+}
+
+// Renderer renders pages from a template tree laid out as
+//
+//	layouts/*.html   wrapping templates, one per layout
+//	partials/*.html  shared {{define}} blocks
+//	pages/**/*.html  one per page, defining "content" (and optionally "title")
+//
+// Each page is parsed together with every layout and partial, so pages
+// may override blocks. With reload set, templates are re-read on every
+// render so edits show up without a restart.
+type Renderer struct {
+	fsys   fs.FS
+	layout string
+	reload bool
+	flash  *Flashes
+	logger *zap.Logger
+
+	mu    sync.RWMutex
+	funcs template.FuncMap
+	cache map[string]*template.Template
+}
+
+// NewRenderer creates a renderer over fsys. layout is the default layout
+// name, e.g. "base" for layouts/base.html. flash may be nil when flash
+// messages aren't used.
+func NewRenderer(fsys fs.FS, layout string, reload bool, flash *Flashes, logger *zap.Logger) *Renderer {
+	return &Renderer{
+		fsys:   fsys,
+		layout: layout,
+		reload: reload,
+		flash:  flash,
+		logger: logger.With(zap.String("component", "render")),
+		funcs:  defaultFuncs(),
+		cache:  make(map[string]*template.Template),
+	}
+}
+
+// RegisterFunc adds a template function. Register functions at startup;
+// registering clears parsed templates.
+func (rn *Renderer) RegisterFunc(name string, fn interface{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	rn.funcs[name] = fn
+	rn.cache = make(map[string]*template.Template)
+}
+
+// HTML renders page inside the default layout. The page is rendered to a
+// buffer first, so template errors produce a clean 500 rather than half
+// a page.
+func (rn *Renderer) HTML(w http.ResponseWriter, r *http.Request, status int, page string, data interface{}) {
+	rn.execute(w, r, status, page, rn.layout+".html", data)
+}
+
+// Render renders page inside the default layout to w, outside any
+// request: pages get .Data but no user, CSRF token or flashes. Use it for
+// emails, reports and other documents.
+func (rn *Renderer) Render(w io.Writer, page string, data interface{}) error {
+	tmpl, err := rn.template(page)
+	if err != nil {
+		return fmt.Errorf("failed to load template %s: %w", page, err)
+	}
+	if err := tmpl.ExecuteTemplate(w, rn.layout+".html", View{Data: data}); err != nil {
+		return fmt.Errorf("failed to render template %s: %w", page, err)
+	}
+	return nil
+}
+
+// Fragment renders only the page's "content" block, for partial page
+// updates
+func (rn *Renderer) Fragment(w http.ResponseWriter, r *http.Request, status int, page string, data interface{}) {
+	rn.execute(w, r, status, page, "content", data)
+}
+
+// Flash queues a message for the next rendered page, e.g. before a
+// redirect. It is a no-op when no flash store is configured.
+func (rn *Renderer) Flash(w http.ResponseWriter, r *http.Request, kind, message string) {
+	if rn.flash != nil {
+		rn.flash.Add(w, r, kind, message)
+	}
+}
+
+// Page returns a handler rendering a page without handler data
+func (rn *Renderer) Page(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rn.HTML(w, r, http.StatusOK, page, nil)
+	}
+}
+
+func (rn *Renderer) execute(w http.ResponseWriter, r *http.Request, status int, page, name string, data interface{}) {
+	tmpl, err := rn.template(page)
+	if err != nil {
+		rn.logger.Error("Failed to load template", zap.String("page", page), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	view := View{
+		Data:      data,
+		CSRFToken: CSRFToken(r.Context()),
+		User:      auth.FromContext(r.Context()),
+		Path:      r.URL.Path,
+	}
+	if view.CSRFToken != "" {
+		view.CSRFField = template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+			template.HTMLEscapeString(csrfFieldName(r.Context())), template.HTMLEscapeString(view.CSRFToken)))
+	}
+	if rn.flash != nil {
+		view.Flashes = rn.flash.Pop(w, r)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, view); err != nil {
+		rn.logger.Error("Failed to render template", zap.String("page", page), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// template returns the parsed template set for page
+func (rn *Renderer) template(page string) (*template.Template, error) {
+	if !rn.reload {
+		rn.mu.RLock()
+		tmpl, ok := rn.cache[page]
+		rn.mu.RUnlock()
+		if ok {
+			return tmpl, nil
+		}
+	}
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	tmpl, err := rn.parse(page)
+	if err != nil {
+		return nil, err
+	}
+	if !rn.reload {
+		rn.cache[page] = tmpl
+	}
+	return tmpl, nil
+}
+
+func (rn *Renderer) parse(page string) (*template.Template, error) {
+	page = strings.TrimSuffix(path.Clean("/"+page), ".html")
+	tmpl := template.New(page).Funcs(rn.funcs)
+
+	for _, pattern := range []string{"layouts/*.html", "partials/*.html"} {
+		matches, err := fs.Glob(rn.fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			continue
+		}
+		if tmpl, err = tmpl.ParseFS(rn.fsys, matches...); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl.ParseFS(rn.fsys, "pages"+page+".html")
+}
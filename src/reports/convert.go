@@ -0,0 +1,116 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// maxStderr bounds the converter output quoted in errors
+const maxStderr = 512
+
+// Wkhtmltopdf converts with wkhtmltopdf, piping the document through
+// stdin and stdout. Local file access is disabled, so stylesheets and
+// images must be inlined or served over HTTP.
+type Wkhtmltopdf struct {
+	Binary string // default "wkhtmltopdf"
+	Args   []string
+}
+
+// Convert implements Converter
+func (c *Wkhtmltopdf) Convert(ctx context.Context, html []byte) ([]byte, error) {
+	binary := c.Binary
+	if binary == "" {
+		binary = "wkhtmltopdf"
+	}
+	args := append([]string{"--quiet", "--disable-local-file-access"}, c.Args...)
+	cmd := exec.CommandContext(ctx, binary, append(args, "-", "-")...)
+	cmd.Stdin = bytes.NewReader(html)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(ctx, binary, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// Chromium converts with headless Chromium or Chrome. The document is
+// written to a temporary directory, which is also the browser profile, and
+// printed from there.
+type Chromium struct {
+	Binary string // default "chromium"
+	Args   []string
+}
+
+// Convert implements Converter
+func (c *Chromium) Convert(ctx context.Context, html []byte) ([]byte, error) {
+	binary := c.Binary
+	if binary == "" {
+		binary = "chromium"
+	}
+	dir, err := os.MkdirTemp("", "report-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input, output := filepath.Join(dir, "report.html"), filepath.Join(dir, "report.pdf")
+	if err := os.WriteFile(input, html, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write report: %w", err)
+	}
+	args := append([]string{
+		"--headless",
+		"--disable-gpu",
+		"--no-pdf-header-footer",
+		"--user-data-dir=" + filepath.Join(dir, "profile"),
+		"--print-to-pdf=" + output,
+	}, c.Args...)
+	cmd := exec.CommandContext(ctx, binary, append(args, "file://"+input)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, commandError(ctx, binary, err, stderr.String())
+	}
+	pdf, err := os.ReadFile(output)
+	if err != nil {
+		return nil, fmt.Errorf("%s wrote no PDF: %s", binary, truncate(stderr.String()))
+	}
+	return pdf, nil
+}
+
+// NewConverter returns the converter named by reports.converter, or nil
+// when reports are HTML only
+func NewConverter(name, binary string, args []string) (Converter, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "wkhtmltopdf":
+		return &Wkhtmltopdf{Binary: binary, Args: args}, nil
+	case "chromium":
+		return &Chromium{Binary: binary, Args: args}, nil
+	}
+	return nil, fmt.Errorf("unknown report converter %q", name)
+}
+
+func commandError(ctx context.Context, binary string, err error, stderr string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out", binary)
+	}
+	if stderr = truncate(stderr); stderr != "" {
+		return fmt.Errorf("%s failed: %w: %s", binary, err, stderr)
+	}
+	return fmt.Errorf("%s failed: %w", binary, err)
+}
+
+func truncate(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxStderr {
+		return s[:maxStderr] + "..."
+	}
+	return s
+}
@@ -0,0 +1,252 @@
+// Package reports renders templates to HTML or PDF documents in the
+// background. A report runs as an operation: the template is rendered with
+// the caller's data, converted to PDF by an external tool when asked,
+// stored in blob storage, and the operation's result carries a signed
+// download URL.
+package reports
+
+import (
+	"bytes"
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/blob"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/operations"
+	"coffee-and-running/src/render"
+	"coffee-and-running/src/requestid"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// DefaultTemplates returns the built-in "report" layout, with print
+// styles, and the "table" page
+func DefaultTemplates() fs.FS {
+	sub, _ := fs.Sub(defaultTemplates, "templates")
+	return sub
+}
+
+// Table is the data of the built-in "table" page
+type Table struct {
+	Title    string
+	Subtitle string
+	Columns  []string
+	Rows     [][]interface{}
+}
+
+// Formats
+const (
+	HTML = "html"
+	PDF  = "pdf"
+)
+
+// ErrNoConverter is returned for PDF reports when reports.converter is
+// not set
+var ErrNoConverter error = apperr.New(apperr.Invalid, "reports", "PDF reports are not available")
+
+// Request describes a report to generate
+type Request struct {
+	// Template is the page under pages/, e.g. "invoices/monthly"
+	Template string
+	// Data is the template's .Data
+	Data interface{}
+	// Format is HTML or PDF; it defaults to PDF
+	Format string
+	// Filename is the download's name without extension; it defaults to
+	// the template's base name
+	Filename string
+}
+
+func (r Request) format() string {
+	if r.Format == "" {
+		return PDF
+	}
+	return r.Format
+}
+
+// Output is a stored report, the result of a report operation
+type Output struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	URL         string    `json:"url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Converter turns a rendered HTML document into a PDF
+type Converter interface {
+	Convert(ctx context.Context, html []byte) ([]byte, error)
+}
+
+// Generator renders reports and stores them
+type Generator struct {
+	config    *config.ReportsConfig
+	views     *render.Renderer
+	converter Converter
+	store     blob.Store
+	ops       *operations.Manager
+	logger    *zap.Logger
+	stats     metrics.Agent
+}
+
+// New creates a generator rendering with views. converter may be nil when
+// only HTML reports are generated.
+func New(cfg *config.ReportsConfig, views *render.Renderer, converter Converter, store blob.Store, ops *operations.Manager, logger *zap.Logger, stats metrics.Agent) *Generator {
+	return &Generator{
+		config:    cfg,
+		views:     views,
+		converter: converter,
+		store:     store,
+		ops:       ops,
+		logger:    logger.With(zap.String("component", "reports")),
+		stats:     stats,
+	}
+}
+
+// Enqueue starts generating req as an operation of kind, owned by the
+// caller in ctx, whose result is the Output:
+//
+//	op, err := generator.Enqueue(r.Context(), "invoice_report", reports.Request{Template: "invoice", Data: invoice})
+//	if err != nil {
+//		apperr.WriteHTTP(w, err)
+//		return
+//	}
+//	ops.WriteAccepted(w, op)
+//
+// Data is rendered when the operation runs, so don't change it after
+// enqueueing.
+func (g *Generator) Enqueue(ctx context.Context, kind string, req Request) (*operations.Operation, error) {
+	if err := g.check(req); err != nil {
+		return nil, err
+	}
+	return g.ops.Enqueue(ctx, kind, func(ctx context.Context, progress *operations.Progress) (interface{}, error) {
+		return g.generate(ctx, req, progress)
+	})
+}
+
+// Generate renders and stores req in the calling goroutine, for commands
+// and scheduled tasks
+func (g *Generator) Generate(ctx context.Context, req Request) (*Output, error) {
+	if err := g.check(req); err != nil {
+		return nil, err
+	}
+	return g.generate(ctx, req, nil)
+}
+
+// SignedURL returns a fresh download URL for a stored report, for when
+// the one in the operation result has expired
+func (g *Generator) SignedURL(ctx context.Context, key string) (string, time.Time, error) {
+	expires := time.Now().Add(g.config.URLExpiry)
+	url, err := g.store.SignedURL(ctx, key, "GET", g.config.URLExpiry)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign report URL: %w", err)
+	}
+	return url, expires, nil
+}
+
+func (g *Generator) check(req Request) error {
+	switch req.format() {
+	case HTML:
+	case PDF:
+		if g.converter == nil {
+			return ErrNoConverter
+		}
+	default:
+		return apperr.New(apperr.Invalid, "reports", "unsupported report format "+req.Format)
+	}
+	if req.Template == "" {
+		return fmt.Errorf("report has no template")
+	}
+	return nil
+}
+
+func (g *Generator) generate(ctx context.Context, req Request, progress *operations.Progress) (*Output, error) {
+	start := time.Now()
+	bucket := "reports." + strings.ReplaceAll(req.Template, "/", "_")
+	step := func(percent int, message string) {
+		if progress == nil {
+			return
+		}
+		if err := progress.Update(ctx, percent, message); err != nil {
+			g.logger.Warn("failed to record report progress", zap.Error(err))
+		}
+	}
+
+	out, err := g.build(ctx, req, step)
+	g.stats.Timing(bucket+".duration", time.Since(start))
+	if err != nil {
+		g.stats.Increment(bucket + ".failed")
+		return nil, err
+	}
+	g.logger.Info("report generated",
+		zap.String("template", req.Template),
+		zap.String("key", out.Key),
+		zap.Int64("size", out.Size),
+		zap.Duration("duration", time.Since(start)))
+	return out, nil
+}
+
+func (g *Generator) build(ctx context.Context, req Request, step func(int, string)) (*Output, error) {
+	step(10, "rendering")
+	var doc bytes.Buffer
+	if err := g.views.Render(&doc, req.Template, req.Data); err != nil {
+		return nil, err
+	}
+
+	body, contentType := doc.Bytes(), "text/html; charset=utf-8"
+	if req.format() == PDF {
+		step(40, "converting to PDF")
+		convertCtx, cancel := context.WithTimeout(ctx, g.config.Timeout)
+		defer cancel()
+		convertStart := time.Now()
+		pdf, err := g.converter.Convert(convertCtx, body)
+		g.stats.Timing("reports.convert.duration", time.Since(convertStart))
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s to PDF: %w", req.Template, err)
+		}
+		body, contentType = pdf, "application/pdf"
+	}
+
+	step(80, "storing")
+	key := path.Join(g.config.Prefix, requestid.Generate(), filename(req)+"."+req.format())
+	obj, err := g.store.Put(ctx, key, bytes.NewReader(body), &blob.PutOptions{ContentType: contentType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store report: %w", err)
+	}
+	url, expires, err := g.SignedURL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Output{Key: key, ContentType: contentType, Size: obj.Size, URL: url, ExpiresAt: expires}, nil
+}
+
+// filename is the download name, reduced to characters safe in a key and
+// a URL
+func filename(req Request) string {
+	name := req.Filename
+	if name == "" {
+		name = path.Base(req.Template)
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		case r == ' ':
+			return '-'
+		}
+		return -1
+	}, name)
+	if name = strings.Trim(name, "."); name == "" {
+		return "report"
+	}
+	return name
+}
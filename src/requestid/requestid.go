@@ -0,0 +1,188 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// DefaultHeader is the HTTP header used to carry the request ID between services
+const DefaultHeader = "X-Request-ID"
+
+// maxLength bounds incoming request IDs so a client can't bloat logs
+const maxLength = 128
+
+// FromContext returns the request ID stored in ctx, or an empty string.
+// The ID is stored under chi's RequestIDKey so chi's own Logger picks it up.
+func FromContext(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// NewContext returns a copy of ctx carrying the given request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, middleware.RequestIDKey, id)
+}
+
+// Generate returns a new random request ID
+func Generate() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(fmt.Sprintf("requestid: failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Middleware assigns a request ID to every request, stores it in the request
+// context and echoes it back in the response. IDs supplied by the client are
+// only honoured when the direct peer is one of the trusted proxies.
+type Middleware struct {
+	header  string
+	trusted []*net.IPNet
+}
+
+// NewMiddleware creates the request ID middleware. trustedProxies is a list of
+// IPs or CIDR blocks whose incoming request IDs are accepted as-is.
+func NewMiddleware(header string, trustedProxies []string) (*Middleware, error) {
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	trusted, err := ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	return &Middleware{
+		header:  http.CanonicalHeaderKey(header),
+		trusted: trusted,
+	}, nil
+}
+
+// Handler implements the chi middleware signature
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		if m.isTrusted(r.RemoteAddr) {
+			id = sanitize(r.Header.Get(m.header))
+		}
+		if id == "" {
+			id = Generate()
+		}
+
+		r.Header.Set(m.header, id)
+		w.Header().Set(m.header, id)
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// isTrusted reports whether remoteAddr belongs to a trusted proxy
+func (m *Middleware) isTrusted(remoteAddr string) bool {
+	if len(m.trusted) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range m.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize rejects request IDs that are too long or contain characters
+// unsafe for logs and headers
+func sanitize(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > maxLength {
+		return ""
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return ""
+		}
+	}
+	return id
+}
+
+// ParseCIDRs parses a list of IPs or CIDR blocks. Bare IPs are treated as
+// single-host networks.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Transport is an http.RoundTripper that injects the request ID from the
+// outgoing request's context into its headers
+type Transport struct {
+	// Base is the underlying transport; http.DefaultTransport when nil
+	Base http.RoundTripper
+	// Header overrides DefaultHeader when set
+	Header string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	header := t.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+
+	if id := FromContext(req.Context()); id != "" && req.Header.Get(header) == "" {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set(header, id)
+	}
+
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,603 @@
+package scaffold
+
+import (
+	"bytes"
+	"coffee-and-running/src/openapi"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidClient is returned for client options that can't be generated
+var ErrInvalidClient = errors.New("scaffold: invalid client")
+
+// generatedHeader starts every generated client. Files that start with it
+// are regenerated in place; any other file at the output path is kept.
+const generatedHeader = "// Code generated by \"starter gen client\""
+
+// Query parameters that page through a list. Operations taking one get a
+// Pages variant that follows the Link header httpx.WriteLinks sets.
+var pageParams = map[string]bool{"limit": true, "offset": true, "cursor": true}
+
+// ClientOptions describes the client to generate
+type ClientOptions struct {
+	// Spec is the OpenAPI document; defaults to api/openapi.yaml
+	Spec string
+	// Lang is "go" or "ts"; defaults to "go"
+	Lang string
+	// Package is the Go package name; defaults to "client"
+	Package string
+	// Out is the file to write; defaults to <package>/client.go for Go and
+	// client.ts for TypeScript
+	Out string
+}
+
+// GenerateClient writes a typed client for every operation in the
+// OpenAPI document: request and response types, path, query and header
+// parameters, an APIError decoded from the service's error envelope and
+// Pages iterators for paginated lists. It returns the path it wrote.
+func GenerateClient(opts ClientOptions) (string, error) {
+	if opts.Spec == "" {
+		opts.Spec = "api/openapi.yaml"
+	}
+	if opts.Lang == "" {
+		opts.Lang = "go"
+	}
+	if opts.Package == "" {
+		opts.Package = "client"
+	}
+	if !token.IsIdentifier(opts.Package) || token.IsKeyword(opts.Package) {
+		return "", fmt.Errorf("%w: package %q is not a Go identifier", ErrInvalidClient, opts.Package)
+	}
+	var tmpl string
+	switch opts.Lang {
+	case "go":
+		tmpl = "client.go.tmpl"
+		if opts.Out == "" {
+			opts.Out = filepath.Join(opts.Package, "client.go")
+		}
+	case "ts":
+		tmpl = "client.ts.tmpl"
+		if opts.Out == "" {
+			opts.Out = "client.ts"
+		}
+	default:
+		return "", fmt.Errorf("%w: unknown language %q (want go or ts)", ErrInvalidClient, opts.Lang)
+	}
+
+	doc, err := openapi.Load(opts.Spec)
+	if err != nil {
+		return "", err
+	}
+	data, err := newClientBuilder(doc).build(opts)
+	if err != nil {
+		return "", err
+	}
+	out, err := render(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+	if opts.Lang == "go" {
+		if out, err = format.Source(out); err != nil {
+			return "", fmt.Errorf("failed to format %s: %w", opts.Out, err)
+		}
+	}
+
+	if existing, err := os.ReadFile(opts.Out); err == nil && !bytes.HasPrefix(existing, []byte(generatedHeader)) {
+		return "", fmt.Errorf("%w: %s was not generated", ErrExists, opts.Out)
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Out), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(opts.Out, out, 0o644); err != nil {
+		return "", err
+	}
+	return opts.Out, nil
+}
+
+// clientData is what the client templates see
+type clientData struct {
+	Source     string
+	Package    string
+	Types      []*clientType
+	Operations []*clientOperation
+	// Imports needed by the types and operations, beyond the client's own
+	Time  bool
+	Pages bool
+}
+
+// clientType is a struct declared for an object schema or parameters
+type clientType struct {
+	Name   string
+	Doc    string
+	Fields []*clientField
+}
+
+type clientField struct {
+	Name     string // Go field, or TypeScript parameter name
+	Key      string // JSON property, or parameter name on the wire
+	In       string // for parameters: Query or Header
+	Go, TS   string
+	Optional bool
+	Required bool // for parameters
+	Doc      string
+	TSKey    string // Key, quoted when it isn't an identifier
+}
+
+type clientOperation struct {
+	Name, TSName   string
+	Request        string // unexported request builder
+	Method, Path   string
+	GoPath         string // Go expression building the path
+	TSPath         string // TypeScript template literal
+	PathParams     []*clientParam
+	Params         *clientType
+	ParamsRequired bool
+	Body, BodyTS   string
+	BodyElem       string // Body without the pointer
+	Result         string // Go result type without the pointer
+	ResultTS       string
+	Pointer        bool
+	Pages          bool
+}
+
+type clientParam struct {
+	Arg, Key, Go, TS string
+}
+
+// clientBuilder names and converts the schemas the operations reach, so
+// types nothing uses, such as the error envelope, are left out
+type clientBuilder struct {
+	doc     *openapi.Document
+	names   map[string]bool
+	named   map[*openapi.Schema]string
+	docs    map[*openapi.Schema]string
+	structs map[string]bool
+	types   []*clientType
+	time    bool
+}
+
+func newClientBuilder(doc *openapi.Document) *clientBuilder {
+	return &clientBuilder{
+		doc:     doc,
+		names:   map[string]bool{"Client": true, "APIError": true, "Violation": true},
+		named:   map[*openapi.Schema]string{},
+		docs:    map[*openapi.Schema]string{},
+		structs: map[string]bool{},
+	}
+}
+
+func (b *clientBuilder) build(opts ClientOptions) (*clientData, error) {
+	// Shared response and body components are named after the component
+	// rather than the first operation that uses them
+	for _, name := range sortedKeys(b.doc.Components.Responses) {
+		if s := jsonSchema(b.doc.Components.Responses[name].Content); s != nil && s.Ref == "" {
+			b.named[s] = b.reserve(exportedName(name))
+			b.docs[s] = "the " + name + " response"
+		}
+	}
+	for _, name := range sortedKeys(b.doc.Components.RequestBodies) {
+		if s := jsonSchema(b.doc.Components.RequestBodies[name].Content); s != nil && s.Ref == "" {
+			b.named[s] = b.reserve(exportedName(name) + "Request")
+			b.docs[s] = "the " + name + " request body"
+		}
+	}
+
+	data := &clientData{Source: filepath.ToSlash(opts.Spec), Package: opts.Package}
+	seen := map[string]string{}
+	for _, template := range sortedKeys(b.doc.Paths) {
+		item := b.doc.Paths[template]
+		if item == nil {
+			continue
+		}
+		for _, m := range methods(item) {
+			op, err := b.operation(template, m.method, item, m.op)
+			if err != nil {
+				return nil, err
+			}
+			if prev, ok := seen[op.Name]; ok {
+				return nil, fmt.Errorf("%w: %s %s and %s are both named %s; set distinct operationIds", ErrInvalidClient, m.method, template, prev, op.Name)
+			}
+			seen[op.Name] = m.method + " " + template
+			data.Operations = append(data.Operations, op)
+			data.Pages = data.Pages || op.Pages
+		}
+	}
+	data.Types = b.types
+	data.Time = b.time
+	return data, nil
+}
+
+func (b *clientBuilder) operation(template, method string, item *openapi.PathItem, op *openapi.Operation) (*clientOperation, error) {
+	name := exportedName(op.OperationID)
+	if name == "" {
+		name = exportedName(strings.ToLower(method) + " " + strings.NewReplacer("{", "", "}", "").Replace(template))
+	}
+	o := &clientOperation{
+		Name:    name,
+		TSName:  lowerName(name),
+		Request: lowerName(name) + "Request",
+		Method:  method,
+		Path:    template,
+	}
+
+	// Operation parameters override path item ones with the same name
+	params := map[string]*openapi.Parameter{}
+	var order []string
+	for _, p := range append(append([]*openapi.Parameter{}, item.Parameters...), op.Parameters...) {
+		if p == nil {
+			continue
+		}
+		key := p.In + " " + p.Name
+		if _, ok := params[key]; !ok {
+			order = append(order, key)
+		}
+		params[key] = p
+	}
+	args := map[string]*openapi.Parameter{}
+	var query []*clientField
+	for _, key := range order {
+		p := params[key]
+		goType, tsType := b.typeOf(p.Schema, name+exportedName(p.Name), "used by "+name+"'s "+p.Name+" parameter")
+		switch p.In {
+		case "path":
+			args[p.Name] = p
+		case "query", "header":
+			query = append(query, &clientField{
+				Name:     exportedName(p.Name),
+				Key:      p.Name,
+				In:       exportedName(p.In),
+				Go:       goType,
+				TS:       tsType,
+				Required: p.Required,
+				Doc:      paramDoc(p),
+			})
+			o.Pages = o.Pages || (p.In == "query" && pageParams[p.Name])
+		}
+	}
+	if len(query) > 0 {
+		o.Params = &clientType{Name: b.reserve(name + "Params"), Fields: query}
+		for _, f := range o.Params.Fields {
+			f.TSKey = lowerName(f.Name)
+			o.ParamsRequired = o.ParamsRequired || f.Required
+		}
+	}
+
+	var goPath, tsPath []string
+	for i, seg := range strings.Split(strings.TrimPrefix(template, "/"), "/") {
+		if i > 0 || strings.HasPrefix(template, "/") {
+			goPath = append(goPath, `"/"`)
+			tsPath = append(tsPath, "/")
+		}
+		if len(seg) < 3 || seg[0] != '{' || seg[len(seg)-1] != '}' {
+			goPath = append(goPath, strconv.Quote(seg))
+			tsPath = append(tsPath, strings.ReplaceAll(seg, "`", "\\`"))
+			continue
+		}
+		p := args[seg[1:len(seg)-1]]
+		if p == nil {
+			return nil, fmt.Errorf("%w: %s %s has no parameter for %s", ErrInvalidClient, method, template, seg)
+		}
+		arg := argName(p.Name)
+		goType, tsType := b.typeOf(p.Schema, name+exportedName(p.Name), "used by "+name+"'s "+p.Name+" parameter")
+		o.PathParams = append(o.PathParams, &clientParam{Arg: arg, Key: p.Name, Go: goType, TS: tsType})
+		goPath = append(goPath, "pathParam("+arg+")")
+		tsPath = append(tsPath, "${encodeURIComponent(String("+arg+"))}")
+	}
+	o.GoPath = strings.ReplaceAll(strings.Join(goPath, " + "), `" + "`, "")
+	o.TSPath = "`" + strings.Join(tsPath, "") + "`"
+
+	if op.RequestBody != nil {
+		s := jsonSchema(op.RequestBody.Content)
+		if s == nil {
+			return nil, fmt.Errorf("%w: %s %s takes no JSON request body", ErrInvalidClient, method, template)
+		}
+		o.Body, o.BodyTS = b.typeOf(s, name+"Request", name+"'s request body")
+		o.BodyElem = o.Body
+		if b.structs[o.Body] {
+			o.Body = "*" + o.Body
+		}
+	}
+
+	for _, status := range sortedKeys(op.Responses) {
+		r := op.Responses[status]
+		if len(status) != 3 || status[0] != '2' || r == nil {
+			continue
+		}
+		if s := jsonSchema(r.Content); s != nil {
+			o.Result, o.ResultTS = b.typeOf(s, name+"Response", name+"'s response")
+			o.Pointer = b.structs[o.Result]
+		}
+		break
+	}
+	o.Pages = o.Pages && method == http.MethodGet && o.Pointer
+	return o, nil
+}
+
+// typeOf returns the Go and TypeScript types for s, declaring object
+// schemas that aren't components as hint, documented as doc
+func (b *clientBuilder) typeOf(s *openapi.Schema, hint, doc string) (string, string) {
+	if s == nil {
+		return "json.RawMessage", "unknown"
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		target := b.doc.Components.Schemas[name]
+		if target == nil {
+			return "json.RawMessage", "unknown"
+		}
+		return b.typeOf(target, exportedName(name), "the "+name+" schema")
+	}
+	if name, ok := b.named[s]; ok && b.declared(name) {
+		return name, name
+	}
+	if len(s.AllOf) == 1 && len(s.AnyOf)+len(s.OneOf) == 0 {
+		return b.typeOf(s.AllOf[0], hint, doc)
+	}
+	if len(s.AllOf)+len(s.AnyOf)+len(s.OneOf) > 0 {
+		return "json.RawMessage", "unknown"
+	}
+
+	typ := ""
+	for _, t := range s.Type {
+		if t != "null" {
+			typ = t
+			break
+		}
+	}
+	if typ == "" && len(s.Properties) > 0 {
+		typ = "object"
+	}
+	switch typ {
+	case "string":
+		ts := "string"
+		if len(s.Enum) > 0 {
+			var values []string
+			for _, v := range s.Enum {
+				raw, _ := json.Marshal(v)
+				values = append(values, string(raw))
+			}
+			ts = strings.Join(values, " | ")
+		}
+		if s.Format == "date-time" {
+			b.time = true
+			return "time.Time", ts
+		}
+		return "string", ts
+	case "integer":
+		if s.Format == "int32" {
+			return "int32", "number"
+		}
+		return "int64", "number"
+	case "number":
+		if s.Format == "float" {
+			return "float32", "number"
+		}
+		return "float64", "number"
+	case "boolean":
+		return "bool", "boolean"
+	case "array":
+		goType, tsType := b.typeOf(s.Items, hint+"Item", doc)
+		if strings.Contains(tsType, " ") {
+			tsType = "(" + tsType + ")"
+		}
+		return "[]" + goType, tsType + "[]"
+	case "object":
+		if len(s.Properties) > 0 {
+			return b.object(s, hint, doc), b.named[s]
+		}
+		if a := s.AdditionalProperties; a != nil && a.Schema != nil {
+			goType, tsType := b.typeOf(a.Schema, hint+"Value", doc)
+			return "map[string]" + goType, "Record<string, " + tsType + ">"
+		}
+		return "map[string]any", "Record<string, unknown>"
+	}
+	return "json.RawMessage", "unknown"
+}
+
+// object declares a struct for s, once
+func (b *clientBuilder) object(s *openapi.Schema, hint, doc string) string {
+	name, ok := b.named[s]
+	if !ok {
+		name = b.reserve(hint)
+		b.named[s] = name
+	}
+	if b.declared(name) {
+		return name
+	}
+	if d, ok := b.docs[s]; ok {
+		doc = d
+	}
+	t := &clientType{Name: name, Doc: doc}
+	b.types = append(b.types, t)
+	b.structs[name] = true
+
+	required := map[string]bool{}
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	for _, key := range sortedKeys(s.Properties) {
+		prop := s.Properties[key]
+		goType, tsType := b.typeOf(prop, name+exportedName(key), "used by "+name+"."+exportedName(key))
+		f := &clientField{
+			Name:     exportedName(key),
+			Key:      key,
+			Go:       goType,
+			TS:       tsType,
+			Optional: !required[key],
+			TSKey:    tsKey(key),
+		}
+		nullable := prop != nil && (prop.Nullable || contains(prop.Type, "null"))
+		if nullable {
+			f.TS += " | null"
+		}
+		// Absent and null stay distinguishable from zero values
+		if (f.Optional || nullable) && !strings.HasPrefix(goType, "[]") && !strings.HasPrefix(goType, "map[") && goType != "json.RawMessage" {
+			f.Go = "*" + goType
+		}
+		if prop != nil && len(prop.Enum) > 0 {
+			var values []string
+			for _, v := range prop.Enum {
+				values = append(values, fmt.Sprint(v))
+			}
+			f.Doc = "One of " + strings.Join(values, ", ")
+		}
+		t.Fields = append(t.Fields, f)
+	}
+	return name
+}
+
+func (b *clientBuilder) declared(name string) bool {
+	for _, t := range b.types {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// paramDoc describes where a parameter is sent
+func paramDoc(p *openapi.Parameter) string {
+	doc := p.Name + " " + p.In
+	if p.In == "query" {
+		doc += " parameter"
+	}
+	if p.Required {
+		doc += ", required"
+	}
+	return doc
+}
+
+// reserve returns name, or name with a number when it's taken
+func (b *clientBuilder) reserve(name string) string {
+	candidate := name
+	for i := 2; b.names[candidate]; i++ {
+		candidate = name + strconv.Itoa(i)
+	}
+	b.names[candidate] = true
+	return candidate
+}
+
+type pathMethod struct {
+	method string
+	op     *openapi.Operation
+}
+
+// methods returns a path item's operations in a stable order
+func methods(item *openapi.PathItem) []pathMethod {
+	var ms []pathMethod
+	for _, m := range []pathMethod{
+		{http.MethodGet, item.Get},
+		{http.MethodPost, item.Post},
+		{http.MethodPut, item.Put},
+		{http.MethodPatch, item.Patch},
+		{http.MethodDelete, item.Delete},
+		{http.MethodHead, item.Head},
+		{http.MethodOptions, item.Options},
+	} {
+		if m.op != nil {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// jsonSchema returns the schema of the JSON media type in content
+func jsonSchema(content map[string]*openapi.MediaType) *openapi.Schema {
+	for _, mediaType := range sortedKeys(content) {
+		if m := content[mediaType]; m != nil && strings.Contains(mediaType, "json") {
+			if m.Schema == nil {
+				return &openapi.Schema{}
+			}
+			return m.Schema
+		}
+	}
+	return nil
+}
+
+// exportedName turns an operationId, property or header name such as
+// "listWebhookDeliveries", "first_name" or "X-Tenant-ID" into a Go name
+func exportedName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		for _, word := range splitWords(part) {
+			if upper, ok := initialisms[word]; ok {
+				b.WriteString(upper)
+				continue
+			}
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	name := b.String()
+	if name != "" && !unicode.IsLetter(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+// lowerName unexports a name made by exportedName: "ListUsers" is
+// "listUsers" and "IDToken" is "idToken"
+func lowerName(name string) string {
+	runes := []rune(name)
+	i := 0
+	for i < len(runes) && unicode.IsUpper(runes[i]) {
+		i++
+	}
+	switch {
+	case i == 0:
+	case i == len(runes) || i == 1:
+		runes = append([]rune(strings.ToLower(string(runes[:i]))), runes[i:]...)
+	default:
+		// The last capital starts the next word
+		i--
+		runes = append([]rune(strings.ToLower(string(runes[:i]))), runes[i:]...)
+	}
+	return string(runes)
+}
+
+// argName is a Go and TypeScript argument name for a path parameter
+func argName(param string) string {
+	arg := lowerName(exportedName(param))
+	switch {
+	case token.IsKeyword(arg), arg == "ctx", arg == "body", arg == "params", arg == "c", arg == "route", arg == "req":
+		return arg + "Param"
+	}
+	return arg
+}
+
+// tsKey quotes property names that aren't TypeScript identifiers
+func tsKey(key string) string {
+	if token.IsIdentifier(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
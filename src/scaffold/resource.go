@@ -0,0 +1,344 @@
+package scaffold
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// ErrInvalidResource is returned for resource definitions that can't be
+// generated
+var ErrInvalidResource = errors.New("scaffold: invalid resource")
+
+// fieldTypes maps the types accepted in field:type to Go and SQL. Strings
+// are required; every other type may be left at its zero value.
+var fieldTypes = map[string]struct {
+	goType, sqlType string
+	sample          interface{}
+}{
+	"string": {"string", "VARCHAR(255) NOT NULL", "example"},
+	"text":   {"string", "TEXT NOT NULL DEFAULT ''", "example text"},
+	"int":    {"int", "INTEGER NOT NULL DEFAULT 0", 1},
+	"float":  {"float64", "DOUBLE PRECISION NOT NULL DEFAULT 0", 1.5},
+	"bool":   {"bool", "BOOLEAN NOT NULL DEFAULT false", true},
+	"time":   {"time.Time", "TIMESTAMP WITH TIME ZONE NOT NULL", "2024-01-01T00:00:00Z"},
+}
+
+var (
+	typeName   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+	columnName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// Columns every resource has, and names that clash with its methods
+var reservedColumns = map[string]bool{"id": true, "created_at": true, "updated_at": true, "validate": true}
+
+// Words written in capitals in Go names
+var initialisms = map[string]string{"id": "ID", "url": "URL", "uri": "URI", "api": "API", "http": "HTTP", "ip": "IP", "uuid": "UUID", "json": "JSON", "sql": "SQL"}
+
+// Resource is a table with a repository, handler and module generated from
+// a definition such as "Order customer_id:int note:text"
+type Resource struct {
+	// Type is the Go type name, e.g. "Order"
+	Type   string
+	Fields []Field
+}
+
+// Field is a column of a resource
+type Field struct {
+	Column string
+	Type   string
+}
+
+// ParseResource parses a resource name and field:type definitions
+func ParseResource(name string, fields []string) (*Resource, error) {
+	if !typeName.MatchString(name) {
+		return nil, fmt.Errorf("%w: name %q must be letters and digits, starting with a letter", ErrInvalidResource, name)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: %s needs at least one field", ErrInvalidResource, name)
+	}
+	r := &Resource{Type: strings.ToUpper(name[:1]) + name[1:]}
+
+	seen := map[string]bool{}
+	for _, def := range fields {
+		column, typ, ok := strings.Cut(def, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: field %q must be name:type", ErrInvalidResource, def)
+		}
+		if !columnName.MatchString(column) {
+			return nil, fmt.Errorf("%w: field name %q must be lowercase snake_case", ErrInvalidResource, column)
+		}
+		if reservedColumns[column] || seen[column] {
+			return nil, fmt.Errorf("%w: field %q is reserved or repeated", ErrInvalidResource, column)
+		}
+		if _, ok := fieldTypes[typ]; !ok {
+			return nil, fmt.Errorf("%w: field %q has unknown type %q (want one of %s)", ErrInvalidResource, column, typ, strings.Join(typeNames(), ", "))
+		}
+		seen[column] = true
+		r.Fields = append(r.Fields, Field{Column: column, Type: typ})
+	}
+	return r, nil
+}
+
+// GenerateResource writes the resource's migration pair, repository,
+// handler and handler tests into the service at dir and registers its
+// module in cmd/service/modules.go. It refuses to overwrite files.
+func GenerateResource(dir string, r *Resource) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	module, err := modulePath(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return err
+	}
+	next, err := nextMigration(filepath.Join(root, "scripts", "migrations"))
+	if err != nil {
+		return err
+	}
+
+	data := r.templateData(module)
+	migration := fmt.Sprintf("scripts/migrations/%03d_create_%s", next, data.Table)
+	files := []struct{ template, path string }{
+		{"resource.go.tmpl", "src/" + data.Package + "/" + data.Package + ".go"},
+		{"handler.go.tmpl", "src/" + data.Package + "/handler.go"},
+		{"handler_test.go.tmpl", "src/" + data.Package + "/handler_test.go"},
+		{"module.go.tmpl", "cmd/service/" + data.Package + ".go"},
+		{"create.up.sql.tmpl", migration + ".up.sql"},
+		{"create.down.sql.tmpl", migration + ".down.sql"},
+	}
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(f.path))); err == nil {
+			return fmt.Errorf("%w: %s", ErrExists, f.path)
+		}
+	}
+
+	for _, f := range files {
+		out, err := render(f.template, data)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(f.path, ".go") {
+			if out, err = format.Source(out); err != nil {
+				return fmt.Errorf("failed to format %s: %w", f.path, err)
+			}
+		}
+		if err := writeFile(root, f.path, out, 0o644); err != nil {
+			return err
+		}
+	}
+	return register(root, data.ModuleVar)
+}
+
+// resourceData is what the resource templates see
+type resourceData struct {
+	Module    string
+	Type      string
+	Package   string // orders
+	Table     string // orders, order_items
+	Key       string // order, order_item
+	Noun      string // order, order item
+	Plural    string // orders, order items
+	Route     string // /api/v1/orders, /api/v1/order-items
+	ModuleVar string // ordersModule
+
+	Fields         []fieldData
+	HasRequired    bool
+	RequiredColumn string // the first required field's, for tests
+	SampleJSON     string
+
+	Columns            string
+	InsertColumns      string
+	InsertPlaceholders string
+	UpdateSet          string
+	VersionParam       string // the placeholder of the expected version in UPDATE
+	FieldArgs          string
+	ScanArgs           string
+}
+
+type fieldData struct {
+	Name     string
+	Column   string
+	GoType   string
+	SQLType  string
+	Required bool
+}
+
+func (r *Resource) templateData(module string) resourceData {
+	words := splitWords(r.Type)
+	plural := append(append([]string{}, words[:len(words)-1]...), pluralize(words[len(words)-1]))
+
+	d := resourceData{
+		Module:    module,
+		Type:      r.Type,
+		Package:   strings.Join(plural, ""),
+		Table:     strings.Join(plural, "_"),
+		Key:       strings.Join(words, "_"),
+		Noun:      strings.Join(words, " "),
+		Plural:    strings.Join(plural, " "),
+		Route:     "/api/v1/" + strings.Join(plural, "-"),
+		ModuleVar: strings.Join(plural, "") + "Module",
+	}
+
+	columns := []string{"id"}
+	var inserts, placeholders, sets, args []string
+	scans := []string{"&rec.ID"}
+	sample := map[string]interface{}{}
+	for i, f := range r.Fields {
+		t := fieldTypes[f.Type]
+		fd := fieldData{
+			Name:     goName(f.Column),
+			Column:   f.Column,
+			GoType:   t.goType,
+			SQLType:  t.sqlType,
+			Required: f.Type == "string",
+		}
+		d.Fields = append(d.Fields, fd)
+		if fd.Required && !d.HasRequired {
+			d.HasRequired = true
+			d.RequiredColumn = f.Column
+		}
+		sample[f.Column] = t.sample
+
+		columns = append(columns, f.Column)
+		inserts = append(inserts, f.Column)
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+		// $1 is the ID in UPDATE
+		sets = append(sets, f.Column+" = $"+strconv.Itoa(i+2))
+		args = append(args, "rec."+fd.Name)
+		scans = append(scans, "&rec."+fd.Name)
+	}
+	columns = append(columns, "version", "created_at", "updated_at")
+	scans = append(scans, "&rec.Version", "&rec.CreatedAt", "&rec.UpdatedAt")
+
+	d.InsertColumns = strings.Join(inserts, ", ")
+	d.InsertPlaceholders = strings.Join(placeholders, ", ")
+	d.UpdateSet = strings.Join(sets, ", ")
+	d.VersionParam = "$" + strconv.Itoa(len(r.Fields)+2)
+	d.FieldArgs = strings.Join(args, ", ")
+	d.Columns = strings.Join(columns, ", ")
+	d.ScanArgs = strings.Join(scans, ", ")
+
+	b, _ := json.Marshal(sample)
+	d.SampleJSON = string(b)
+	return d
+}
+
+func render(name string, data interface{}) ([]byte, error) {
+	tmpl, err := template.ParseFS(templates, path.Join("templates", name))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// register appends module to the list returned by modules() in
+// cmd/service/modules.go
+func register(root, module string) error {
+	p := filepath.Join(root, "cmd", "service", "modules.go")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	src := string(data)
+	// The list is the last composite literal in the file
+	end := strings.LastIndex(src, "\n\t}\n}")
+	if end < 0 {
+		return fmt.Errorf("scaffold: can't find the module list in %s; add %s to modules() by hand", p, module)
+	}
+	out, err := format.Source([]byte(src[:end] + "\n\t\t" + module + "," + src[end:]))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, out, 0o644)
+}
+
+// nextMigration returns the number after the highest migration in dir
+func nextMigration(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	highest := 0
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(prefix); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1, nil
+}
+
+// splitWords splits a CamelCase name into lowercase words, keeping runs of
+// capitals together: "OrderItem" is order, item and "APIKey" is api, key
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		upper := unicode.IsUpper(runes[i])
+		boundary := upper && (!unicode.IsUpper(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1])))
+		if boundary {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(string(runes[start:])))
+}
+
+func pluralize(word string) string {
+	switch {
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !strings.ContainsRune("aeiou", rune(word[len(word)-2])):
+		return word[:len(word)-1] + "ies"
+	case strings.HasSuffix(word, "s"), strings.HasSuffix(word, "x"), strings.HasSuffix(word, "z"),
+		strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		return word + "es"
+	default:
+		return word + "s"
+	}
+}
+
+// goName turns a snake_case column into an exported Go name
+func goName(column string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(column, "_") {
+		if part == "" {
+			continue
+		}
+		if upper, ok := initialisms[part]; ok {
+			b.WriteString(upper)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func typeNames() []string {
+	names := make([]string, 0, len(fieldTypes))
+	for name := range fieldTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
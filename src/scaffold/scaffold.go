@@ -0,0 +1,243 @@
+package scaffold
+
+import (
+	"bufio"
+	"bytes"
+	"coffee-and-running/src/config"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrInvalidName is returned for service names that can't be used as a
+	// binary, directory and database name
+	ErrInvalidName = errors.New("scaffold: name must start with a letter and contain only lowercase letters, digits and dashes")
+	// ErrExists is returned rather than overwriting existing files
+	ErrExists = errors.New("scaffold: already exists")
+)
+
+//go:embed templates
+var templates embed.FS
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// Paths that are never copied into a new service. The Dockerfile is
+// rendered from a template instead.
+var (
+	skipDirs  = map[string]bool{".git": true, "bin": true, "tmp": true, "vendor": true, "node_modules": true}
+	skipPaths = map[string]bool{"Dockerfile": true}
+)
+
+// Files whose example names ("myapp", "myservice") are replaced with the
+// service name
+var renamed = map[string]bool{"Makefile": true, "docker-compose.yml": true, "config-development.yaml": true}
+
+// example is the resource every new service starts with
+var example = &Resource{
+	Type:   "Item",
+	Fields: []Field{{Column: "name", Type: "string"}, {Column: "description", Type: "text"}},
+}
+
+// Options describes the service to generate
+type Options struct {
+	// Name of the service, used for the binary, Docker image and database
+	Name string
+	// Module is the Go module path; defaults to Name
+	Module string
+	// Source is the root of the kit to copy; defaults to "."
+	Source string
+	// Dir is the output directory; defaults to Name. It must not exist or
+	// be empty.
+	Dir string
+}
+
+// Generate stamps out a new service from the kit at opts.Source: it copies
+// the tree, rewrites the module path and example names, and adds an
+// example items resource (handler, repository, migration and module) and
+// a Dockerfile for the service binary
+func Generate(opts Options) error {
+	if !validName.MatchString(opts.Name) {
+		return ErrInvalidName
+	}
+	if opts.Module == "" {
+		opts.Module = opts.Name
+	}
+	if opts.Source == "" {
+		opts.Source = "."
+	}
+	if opts.Dir == "" {
+		opts.Dir = opts.Name
+	}
+
+	src, err := filepath.Abs(opts.Source)
+	if err != nil {
+		return err
+	}
+	dst, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(dst); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%w: %s", ErrExists, opts.Dir)
+	}
+
+	kitModule, err := modulePath(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return err
+	}
+	ignored, err := rootIgnores(filepath.Join(src, ".gitignore"))
+	if err != nil {
+		return err
+	}
+
+	g := &generator{
+		opts:     opts,
+		src:      src,
+		dst:      dst,
+		database: strings.ReplaceAll(opts.Name, "-", "_"),
+		replacer: strings.NewReplacer(
+			`"`+kitModule+`/`, `"`+opts.Module+`/`,
+			`"`+kitModule+`"`, `"`+opts.Module+`"`,
+		),
+		ignored: ignored,
+	}
+	if err := g.copyTree(); err != nil {
+		return err
+	}
+	return g.addExample()
+}
+
+type generator struct {
+	opts     Options
+	src, dst string
+	database string
+	replacer *strings.Replacer
+	ignored  map[string]bool
+}
+
+// copyTree copies every file of the kit into dst, rewriting as it goes
+func (g *generator) copyTree() error {
+	return filepath.WalkDir(g.src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(g.src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		skip := skipPaths[rel] || g.ignored[rel] || p == g.dst
+		if d.IsDir() {
+			if skip || skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if skip || !d.Type().IsRegular() {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return writeFile(g.dst, rel, g.rewrite(rel, data), info.Mode().Perm())
+	})
+}
+
+// rewrite points imports at the new module and renames the example
+// service. Binary files are copied as is.
+func (g *generator) rewrite(rel string, data []byte) []byte {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return data
+	}
+	s := string(data)
+	switch {
+	case rel == "go.mod":
+		s = moduleLine.ReplaceAllString(s, "module "+g.opts.Module)
+	case strings.HasSuffix(rel, ".go"):
+		s = g.replacer.Replace(s)
+	case renamed[rel]:
+		s = strings.NewReplacer(
+			"myapp_dev", g.database+"_dev",
+			"myservice", g.opts.Name,
+			"myapp", g.opts.Name,
+		).Replace(s)
+	}
+	return []byte(s)
+}
+
+// addExample generates the example resource and the Dockerfile
+func (g *generator) addExample() error {
+	if err := GenerateResource(g.dst, example); err != nil {
+		return err
+	}
+	out, err := render("Dockerfile.tmpl", map[string]interface{}{
+		"Name": g.opts.Name,
+		"Port": config.DefaultConfig().Server.Port,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFile(g.dst, "Dockerfile", out, 0o644)
+}
+
+func writeFile(root, rel string, data []byte, perm fs.FileMode) error {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, perm)
+}
+
+var moduleLine = regexp.MustCompile(`(?m)^module\s+\S+`)
+
+func modulePath(gomod string) (string, error) {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return "", fmt.Errorf("scaffold: source is not the kit: %w", err)
+	}
+	m := moduleLine.Find(data)
+	if m == nil {
+		return "", fmt.Errorf("scaffold: no module line in %s", gomod)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(m), "module")), nil
+}
+
+// rootIgnores returns the plain root-anchored entries of a .gitignore,
+// e.g. "/requests.jsonl", which are local files that don't belong in a new
+// service. Patterns are not expanded.
+func rootIgnores(gitignore string) (map[string]bool, error) {
+	ignored := map[string]bool{}
+	f, err := os.Open(gitignore)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ignored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "/") && !strings.ContainsAny(line, "*?[") {
+			ignored[strings.Trim(line, "/")] = true
+		}
+	}
+	return ignored, scanner.Err()
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// TaskStatus is a registered task with its most recent cluster-wide run
+type TaskStatus struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	Timeout        string     `json:"timeout"`
+	NextRunAt      time.Time  `json:"next_run_at"`
+	Running        bool       `json:"running"`
+	LockedBy       *string    `json:"locked_by,omitempty"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastStatus     *string    `json:"last_status,omitempty"`
+	LastError      *string    `json:"last_error,omitempty"`
+	LastDurationMS *int64     `json:"last_duration_ms,omitempty"`
+	LastRunBy      *string    `json:"last_run_by,omitempty"`
+}
+
+// Mount registers GET /admin/schedules, listing schedules and last-run results
+func (s *Scheduler) Mount(r chi.Router) {
+	r.Get("/admin/schedules", s.listSchedules)
+}
+
+func (s *Scheduler) listSchedules(w http.ResponseWriter, r *http.Request) {
+	statuses, err := s.Statuses(r.Context())
+	if err != nil {
+		s.logger.Error("failed to load schedule statuses", zap.Error(err))
+		http.Error(w, "failed to load schedules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": statuses})
+}
+
+// Statuses returns every registered task joined with its stored run state
+func (s *Scheduler) Statuses(ctx context.Context) ([]TaskStatus, error) {
+	rows, err := s.engine.Query(ctx,
+		`SELECT name, locked_by, locked_until, last_run_at, last_status, last_error, last_duration_ms, last_run_by
+		 FROM scheduled_tasks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type state struct {
+		lockedBy       sql.NullString
+		lockedUntil    sql.NullTime
+		lastRunAt      sql.NullTime
+		lastStatus     sql.NullString
+		lastError      sql.NullString
+		lastDurationMS sql.NullInt64
+		lastRunBy      sql.NullString
+	}
+	states := make(map[string]state)
+	for rows.Next() {
+		var name string
+		var st state
+		if err := rows.Scan(&name, &st.lockedBy, &st.lockedUntil, &st.lastRunAt, &st.lastStatus,
+			&st.lastError, &st.lastDurationMS, &st.lastRunBy); err != nil {
+			return nil, err
+		}
+		states[name] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(s.location)
+	tasks := s.Tasks()
+	statuses := make([]TaskStatus, 0, len(tasks))
+	for _, task := range tasks {
+		status := TaskStatus{
+			Name:      task.Name,
+			Schedule:  task.Spec,
+			Timeout:   task.Timeout.String(),
+			NextRunAt: task.schedule.Next(now),
+		}
+		if st, ok := states[task.Name]; ok {
+			status.Running = st.lockedUntil.Valid && st.lockedUntil.Time.After(now)
+			if st.lockedBy.Valid {
+				status.LockedBy = &st.lockedBy.String
+			}
+			if st.lastRunAt.Valid {
+				status.LastRunAt = &st.lastRunAt.Time
+			}
+			if st.lastStatus.Valid {
+				status.LastStatus = &st.lastStatus.String
+			}
+			if st.lastError.Valid {
+				status.LastError = &st.lastError.String
+			}
+			if st.lastDurationMS.Valid {
+				status.LastDurationMS = &st.lastDurationMS.Int64
+			}
+			if st.lastRunBy.Valid {
+				status.LastRunBy = &st.lastRunBy.String
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,310 @@
+package scheduler
+
+import (
+	"coffee-and-running/src/app/supervisor"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/locks"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// TaskFunc is the work performed on each scheduled run
+type TaskFunc func(ctx context.Context) error
+
+// Task is a named recurring task
+type Task struct {
+	Name     string
+	Spec     string
+	Timeout  time.Duration
+	schedule cron.Schedule
+	fn       TaskFunc
+}
+
+// TaskOption customizes a registered task
+type TaskOption func(*Task)
+
+// WithTimeout bounds a single run of the task. It is also the TTL of the
+// task's lock, so other replicas can take over if this one dies.
+func WithTimeout(timeout time.Duration) TaskOption {
+	return func(t *Task) {
+		t.Timeout = timeout
+	}
+}
+
+// Scheduler runs registered tasks on cron schedules. Each run holds the
+// task's distributed lock and claims the tick in the scheduled_tasks table,
+// so only one replica executes a given tick.
+type Scheduler struct {
+	config     *config.SchedulerConfig
+	engine     storage.Engine
+	locker     locks.Locker
+	logger     *zap.Logger
+	stats      metrics.Agent
+	parser     cron.Parser
+	location   *time.Location
+	instanceID string
+	mu         sync.Mutex
+	tasks      map[string]*Task
+	ctx        context.Context
+	cancel     context.CancelFunc
+	workers    *supervisor.Supervisor
+}
+
+// New creates a scheduler. Tasks must be registered before Start. A nil
+// locker uses Postgres advisory locks.
+func New(cfg *config.SchedulerConfig, engine storage.Engine, locker locks.Locker, logger *zap.Logger, stats metrics.Agent) (*Scheduler, error) {
+	location := time.UTC
+	if cfg.Location != "" {
+		loc, err := time.LoadLocation(cfg.Location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scheduler location %s: %w", cfg.Location, err)
+		}
+		location = loc
+	}
+
+	if locker == nil {
+		locker = locks.NewPostgres(engine, logger)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &Scheduler{
+		config:     cfg,
+		engine:     engine,
+		locker:     locker,
+		logger:     logger.With(zap.String("component", "scheduler")),
+		stats:      stats,
+		parser:     cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor),
+		location:   location,
+		instanceID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		tasks:      make(map[string]*Task),
+	}, nil
+}
+
+// Register adds a task with a standard 5-field cron expression or a
+// descriptor such as "@hourly" or "@every 5m"
+func (s *Scheduler) Register(name, spec string, fn TaskFunc, opts ...TaskOption) error {
+	schedule, err := s.parser.Parse(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for task %s: %w", spec, name, err)
+	}
+
+	task := &Task{
+		Name:     name,
+		Spec:     spec,
+		Timeout:  s.config.DefaultTimeout,
+		schedule: schedule,
+		fn:       fn,
+	}
+	for _, opt := range opts {
+		opt(task)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tasks[name]; exists {
+		return fmt.Errorf("task %s already registered", name)
+	}
+	s.tasks[name] = task
+
+	s.logger.Info("scheduled task registered",
+		zap.String("task", name),
+		zap.String("schedule", spec),
+		zap.Duration("timeout", task.Timeout))
+	return nil
+}
+
+// Tasks returns the registered tasks sorted by name
+func (s *Scheduler) Tasks() []*Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name < tasks[j].Name
+	})
+	return tasks
+}
+
+// Start launches one loop per registered task
+func (s *Scheduler) Start() {
+	// Tasks borrow database connections from the background partition, so
+	// they can't starve requests
+	s.ctx, s.cancel = context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+
+	// Task panics are already recovered by invoke; supervising the loops
+	// restarts them if the scheduling code itself panics
+	s.workers = supervisor.New(s.ctx, s.logger, s.stats)
+	for _, task := range s.Tasks() {
+		s.workers.Go("scheduler."+task.Name, func(context.Context) error {
+			s.loop(task)
+			return nil
+		})
+	}
+
+	s.logger.Info("scheduler started",
+		zap.Int("tasks", len(s.tasks)),
+		zap.String("instance_id", s.instanceID))
+}
+
+// Close stops scheduling new runs and waits for running tasks to finish
+func (s *Scheduler) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.workers != nil {
+		s.workers.Wait()
+	}
+	s.logger.Info("scheduler stopped")
+}
+
+// loop sleeps until each tick of the task's schedule and tries to run it
+func (s *Scheduler) loop(task *Task) {
+	for {
+		tick := task.schedule.Next(time.Now().In(s.location))
+		timer := time.NewTimer(time.Until(tick))
+
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.runTick(task, tick)
+		}
+	}
+}
+
+// runTick takes the task's lock, claims tick and, if successful, runs it
+func (s *Scheduler) runTick(task *Task, tick time.Time) {
+	logger := s.logger.With(zap.String("task", task.Name), zap.Time("tick", tick))
+
+	lockCtx, lockCancel := context.WithTimeout(s.ctx, 5*time.Second)
+	lock, err := s.locker.Lock(lockCtx, "scheduler:"+task.Name, task.Timeout)
+	lockCancel()
+	if errors.Is(err, locks.ErrNotAcquired) {
+		logger.Debug("task is running on another instance")
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.skipped", task.Name))
+		return
+	}
+	if err != nil {
+		logger.Error("failed to acquire task lock", zap.Error(err))
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.lock_error", task.Name))
+		return
+	}
+	defer func() {
+		releaseCtx, releaseCancel := context.WithTimeout(context.WithoutCancel(s.ctx), 5*time.Second)
+		defer releaseCancel()
+		if err := lock.Release(releaseCtx); err != nil {
+			logger.Error("failed to release task lock", zap.Error(err))
+		}
+	}()
+
+	claimed, err := s.claim(task, tick)
+	if err != nil {
+		logger.Error("failed to claim task tick", zap.Error(err))
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.lock_error", task.Name))
+		return
+	}
+	if !claimed {
+		logger.Debug("task tick claimed by another instance")
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.skipped", task.Name))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, task.Timeout)
+	defer cancel()
+
+	logger.Info("running scheduled task")
+	start := time.Now()
+	err = s.invoke(ctx, task)
+	duration := time.Since(start)
+
+	s.stats.Timing(fmt.Sprintf("scheduler.%s.duration", task.Name), duration)
+	if err != nil {
+		logger.Error("scheduled task failed", zap.Duration("duration", duration), zap.Error(err))
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.failure", task.Name))
+	} else {
+		logger.Info("scheduled task completed", zap.Duration("duration", duration))
+		s.stats.Increment(fmt.Sprintf("scheduler.%s.success", task.Name))
+	}
+
+	// Record the result even if we're shutting down
+	recordCtx, recordCancel := context.WithTimeout(context.WithoutCancel(s.ctx), 5*time.Second)
+	defer recordCancel()
+	if err := s.release(recordCtx, task, start, duration, err); err != nil {
+		logger.Error("failed to record task result", zap.Error(err))
+	}
+}
+
+// invoke runs the task, converting a panic into an error
+func (s *Scheduler) invoke(ctx context.Context, task *Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panicked: %v", r)
+		}
+	}()
+	return task.fn(ctx)
+}
+
+// claim records that this instance is running tick, unless another
+// instance has already run it. The caller must hold the task's lock; the
+// locked_by/locked_until columns are informational.
+func (s *Scheduler) claim(task *Task, tick time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	var name string
+	err := s.engine.QueryRow(ctx,
+		`INSERT INTO scheduled_tasks (name, schedule, locked_by, locked_until, last_scheduled_at)
+		 VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 millisecond', $5)
+		 ON CONFLICT (name) DO UPDATE SET
+			schedule = EXCLUDED.schedule,
+			locked_by = EXCLUDED.locked_by,
+			locked_until = EXCLUDED.locked_until,
+			last_scheduled_at = EXCLUDED.last_scheduled_at,
+			updated_at = NOW()
+		 WHERE scheduled_tasks.last_scheduled_at IS NULL OR scheduled_tasks.last_scheduled_at < EXCLUDED.last_scheduled_at
+		 RETURNING name`,
+		task.Name, task.Spec, s.instanceID, task.Timeout.Milliseconds(), tick).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// release stores the run result and clears the informational lease
+func (s *Scheduler) release(ctx context.Context, task *Task, start time.Time, duration time.Duration, runErr error) error {
+	status := "success"
+	var lastError sql.NullString
+	if runErr != nil {
+		status = "failed"
+		lastError = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+
+	_, err := s.engine.Exec(ctx,
+		`UPDATE scheduled_tasks SET
+			locked_by = NULL, locked_until = NULL,
+			last_run_at = $3, last_status = $4, last_error = $5,
+			last_duration_ms = $6, last_run_by = $2, updated_at = NOW()
+		 WHERE name = $1 AND locked_by = $2`,
+		task.Name, s.instanceID, start, status, lastError, duration.Milliseconds())
+	return err
+}
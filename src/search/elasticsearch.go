@@ -0,0 +1,264 @@
+package search
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// maxErrorLength bounds how much of an error response is kept
+const maxErrorLength = 1024
+
+// Elasticsearch talks to Elasticsearch or OpenSearch over their shared
+// REST API. Index names are prefixed with IndexPrefix so several services
+// can share a cluster.
+type Elasticsearch struct {
+	config    *config.ElasticsearchConfig
+	client    *http.Client
+	addresses []string
+	next      atomic.Uint64
+}
+
+var _ Index = (*Elasticsearch)(nil)
+
+// NewElasticsearch creates an Elasticsearch/OpenSearch index. client should
+// come from the httpclient factory so calls are traced, retried and measured.
+func NewElasticsearch(cfg *config.ElasticsearchConfig, client *http.Client) (*Elasticsearch, error) {
+	if len(cfg.Addresses) == 0 {
+		return nil, errors.New("search addresses are required")
+	}
+
+	addresses := make([]string, len(cfg.Addresses))
+	for i, addr := range cfg.Addresses {
+		addresses[i] = strings.TrimSuffix(addr, "/")
+	}
+	return &Elasticsearch{config: cfg, client: client, addresses: addresses}, nil
+}
+
+// Index upserts docs with the bulk API. The refresh parameter is
+// configurable because waiting for visibility slows writes.
+func (e *Elasticsearch) Index(ctx context.Context, index string, docs ...Document) error {
+	if err := validateDocs(docs); err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, doc := range docs {
+		action := map[string]map[string]string{"index": {"_index": e.indexName(index), "_id": doc.ID}}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", doc.ID, err)
+		}
+	}
+	return e.bulk(ctx, &body)
+}
+
+// Delete removes documents with the bulk API; missing IDs are ignored
+func (e *Elasticsearch) Delete(ctx context.Context, index string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, id := range ids {
+		action := map[string]map[string]string{"delete": {"_index": e.indexName(index), "_id": id}}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+	}
+	return e.bulk(ctx, &body)
+}
+
+type esSearchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			ID        string              `json:"_id"`
+			Score     float64             `json:"_score"`
+			Source    Document            `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search runs a simple_query_string query boosted towards titles, with
+// filters applied as term clauses on attributes
+func (e *Elasticsearch) Search(ctx context.Context, index string, query Query) (*Results, error) {
+	query = normalize(query)
+
+	filters := make([]map[string]interface{}, 0, len(query.Filters))
+	for field, value := range query.Filters {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"attributes." + field: value},
+		})
+	}
+
+	request := map[string]interface{}{
+		"from":             query.Offset,
+		"size":             query.Limit,
+		"track_total_hits": true,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"simple_query_string": map[string]interface{}{
+						"query":            query.Text,
+						"fields":           []string{"title^2", "body"},
+						"default_operator": "and",
+					},
+				},
+				"filter": filters,
+			},
+		},
+		"highlight": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"body": map[string]interface{}{"number_of_fragments": 2, "fragment_size": 150},
+			},
+		},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, "/"+url.PathEscape(e.indexName(index))+"/_search", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// A missing index just means nothing has been indexed yet
+	if resp.StatusCode == http.StatusNotFound {
+		return &Results{Hits: []Hit{}}, nil
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, readError(resp))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search returned %d: %s", resp.StatusCode, readError(resp))
+	}
+
+	var parsed esSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode search response: %w", err)
+	}
+
+	results := &Results{Total: parsed.Hits.Total.Value, Hits: make([]Hit, 0, len(parsed.Hits.Hits))}
+	for _, h := range parsed.Hits.Hits {
+		results.Hits = append(results.Hits, Hit{
+			ID:         h.ID,
+			Score:      h.Score,
+			Title:      h.Source.Title,
+			Snippet:    strings.Join(h.Highlight["body"], " ... "),
+			Attributes: h.Source.Attributes,
+		})
+	}
+	return results, nil
+}
+
+// Ping checks that the cluster is reachable, for health checks
+func (e *Elasticsearch) Ping(ctx context.Context) error {
+	resp, err := e.do(ctx, http.MethodGet, "/", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("search cluster returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends an NDJSON body and reports the first failed item. Deleting a
+// missing document is a 404 item, which is not an error.
+func (e *Elasticsearch) bulk(ctx context.Context, body io.Reader) error {
+	path := "/_bulk"
+	if e.config.Refresh != "" {
+		path += "?refresh=" + url.QueryEscape(e.config.Refresh)
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, path, "application/x-ndjson", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bulk request returned %d: %s", resp.StatusCode, readError(resp))
+	}
+
+	var parsed esBulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !parsed.Errors {
+		return nil
+	}
+	for _, item := range parsed.Items {
+		for action, result := range item {
+			if result.Error == nil || (action == "delete" && result.Status == http.StatusNotFound) {
+				continue
+			}
+			return fmt.Errorf("bulk %s of %s failed: %s: %s", action, result.ID, result.Error.Type, result.Error.Reason)
+		}
+	}
+	return nil
+}
+
+// do sends a request, rotating through the configured addresses
+func (e *Elasticsearch) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	addr := e.addresses[e.next.Add(1)%uint64(len(e.addresses))]
+	req, err := http.NewRequestWithContext(ctx, method, addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case e.config.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.config.APIKey)
+	case e.config.Username != "":
+		req.SetBasicAuth(e.config.Username, e.config.Password)
+	}
+	return e.client.Do(req)
+}
+
+func (e *Elasticsearch) indexName(index string) string {
+	return e.config.IndexPrefix + index
+}
+
+func readError(resp *http.Response) string {
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	return string(detail)
+}
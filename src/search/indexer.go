@@ -0,0 +1,125 @@
+package search
+
+import (
+	"coffee-and-running/src/messaging"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/outbox"
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Event is an outbox event delivered to a Mapper
+type Event struct {
+	ID            string
+	Type          string
+	AggregateType string
+	AggregateID   string
+	Payload       []byte
+}
+
+// Mapper turns an event into the current document for its aggregate.
+// Returning a nil document removes the aggregate from the index, e.g. on
+// a "deleted" event.
+type Mapper func(ctx context.Context, event Event) (*Document, error)
+
+type mapping struct {
+	index  string
+	mapper Mapper
+}
+
+// Indexer keeps indexes in sync with outbox events. Events for an
+// aggregate arrive in order and every write is an upsert or delete, so
+// redelivered events are harmless.
+type Indexer struct {
+	index  Index
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu       sync.RWMutex
+	mappings map[string]mapping
+}
+
+// NewIndexer creates an indexer writing to index
+func NewIndexer(index Index, logger *zap.Logger, stats metrics.Agent) *Indexer {
+	return &Indexer{
+		index:    index,
+		logger:   logger.With(zap.String("component", "search_indexer")),
+		stats:    stats,
+		mappings: make(map[string]mapping),
+	}
+}
+
+// Register maps events of aggregateType into the named index
+func (ix *Indexer) Register(aggregateType, index string, mapper Mapper) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.mappings[aggregateType] = mapping{index: index, mapper: mapper}
+}
+
+// Subscribe registers a handler on sub for each topic, after Register and
+// before sub.Start. Without topics it subscribes to the registered
+// aggregate types, which are the outbox's default topics.
+func (ix *Indexer) Subscribe(sub messaging.Subscriber, topics ...string) error {
+	if len(topics) == 0 {
+		ix.mu.RLock()
+		for aggregateType := range ix.mappings {
+			topics = append(topics, aggregateType)
+		}
+		ix.mu.RUnlock()
+	}
+
+	for _, topic := range topics {
+		if err := sub.Subscribe(topic, ix.Handle); err != nil {
+			return fmt.Errorf("failed to subscribe indexer to %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Handle applies one outbox message. Messages for unregistered aggregate
+// types are acknowledged and ignored.
+func (ix *Indexer) Handle(ctx context.Context, msg messaging.Message) error {
+	event := Event{
+		ID:            msg.Headers[outbox.HeaderEventID],
+		Type:          msg.Headers[outbox.HeaderEventType],
+		AggregateType: msg.Headers[outbox.HeaderAggregateType],
+		AggregateID:   msg.Headers[outbox.HeaderAggregateID],
+		Payload:       msg.Value,
+	}
+
+	ix.mu.RLock()
+	m, ok := ix.mappings[event.AggregateType]
+	ix.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	bucket := "search.indexer." + messaging.MetricLabel(event.AggregateType)
+	doc, err := m.mapper(ctx, event)
+	if err == nil {
+		if doc == nil {
+			err = ix.index.Delete(ctx, m.index, event.AggregateID)
+		} else {
+			err = ix.index.Index(ctx, m.index, *doc)
+		}
+	}
+	if err != nil {
+		ix.stats.Increment(bucket + ".error")
+		ix.logger.Error("Failed to index event",
+			zap.String("event_id", event.ID),
+			zap.String("event_type", event.Type),
+			zap.String("aggregate_id", event.AggregateID),
+			zap.Error(err))
+		return err
+	}
+
+	if doc == nil {
+		ix.stats.Increment(bucket + ".deleted")
+	} else {
+		ix.stats.Increment(bucket + ".indexed")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package search
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Postgres stores documents in the search_documents table and queries them
+// with tsvector matching. It needs no extra infrastructure and suits
+// modest data sets.
+type Postgres struct {
+	engine   storage.Engine
+	language string
+}
+
+var _ Index = (*Postgres)(nil)
+
+// NewPostgres creates a Postgres index. language is a text search
+// configuration such as "english" or "simple".
+func NewPostgres(engine storage.Engine, language string) *Postgres {
+	if language == "" {
+		language = "english"
+	}
+	return &Postgres{engine: engine, language: language}
+}
+
+// Index upserts docs in one transaction
+func (p *Postgres) Index(ctx context.Context, index string, docs ...Document) error {
+	if err := validateDocs(docs); err != nil {
+		return err
+	}
+
+	tx, err := p.engine.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	for _, doc := range docs {
+		attributes, err := json.Marshal(doc.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal attributes for %s: %w", doc.ID, err)
+		}
+		if doc.Attributes == nil {
+			attributes = []byte("{}")
+		}
+
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO search_documents (index_name, id, language, title, body, attributes, updated_at)
+			 VALUES ($1, $2, $3::regconfig, $4, $5, $6, NOW())
+			 ON CONFLICT (index_name, id) DO UPDATE
+			 SET language = EXCLUDED.language, title = EXCLUDED.title, body = EXCLUDED.body,
+			     attributes = EXCLUDED.attributes, updated_at = NOW()`,
+			index, doc.ID, p.language, doc.Title, doc.Body, attributes); err != nil {
+			return fmt.Errorf("failed to index %s: %w", doc.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// Delete removes documents by ID; missing IDs are ignored
+func (p *Postgres) Delete(ctx context.Context, index string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := p.engine.Exec(ctx,
+		`DELETE FROM search_documents WHERE index_name = $1 AND id = ANY($2)`,
+		index, pq.Array(ids))
+	return err
+}
+
+// Search ranks matches with ts_rank_cd and highlights the body
+func (p *Postgres) Search(ctx context.Context, index string, query Query) (*Results, error) {
+	query = normalize(query)
+
+	filters := []byte("{}")
+	if len(query.Filters) > 0 {
+		var err error
+		if filters, err = json.Marshal(query.Filters); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+	}
+
+	rows, err := p.engine.Query(ctx,
+		`SELECT id, title, attributes,
+		        ts_rank_cd(document, q) AS score,
+		        ts_headline(language, body, q, 'MaxFragments=2, MaxWords=20, MinWords=5') AS snippet,
+		        COUNT(*) OVER () AS total
+		 FROM search_documents, websearch_to_tsquery($2::regconfig, $3) AS q
+		 WHERE index_name = $1 AND document @@ q AND attributes @> $4
+		 ORDER BY score DESC, id
+		 LIMIT $5 OFFSET $6`,
+		index, p.language, query.Text, filters, query.Limit, query.Offset)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Class() == "42" {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &Results{Hits: []Hit{}}
+	for rows.Next() {
+		var hit Hit
+		var attributes []byte
+		if err := rows.Scan(&hit.ID, &hit.Title, &attributes, &hit.Score, &hit.Snippet, &results.Total); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(attributes, &hit.Attributes); err != nil {
+			return nil, err
+		}
+		results.Hits = append(results.Hits, hit)
+	}
+	return results, rows.Err()
+}
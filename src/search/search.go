@@ -0,0 +1,124 @@
+package search
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidQuery is returned when the backend rejects the query syntax
+var ErrInvalidQuery = errors.New("search: invalid query")
+
+// Document is a searchable record. Title matches rank above Body matches;
+// Attributes are stored as-is and can be used as exact-match filters.
+type Document struct {
+	ID         string                 `json:"id"`
+	Title      string                 `json:"title"`
+	Body       string                 `json:"body"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Query is a full-text query. Text accepts web-search syntax: quoted
+// phrases, OR, and -excluded terms.
+type Query struct {
+	Text    string
+	Filters map[string]interface{}
+	Limit   int
+	Offset  int
+}
+
+// Hit is a single search result
+type Hit struct {
+	ID         string                 `json:"id"`
+	Score      float64                `json:"score"`
+	Title      string                 `json:"title"`
+	Snippet    string                 `json:"snippet,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Results holds one page of hits and the total number of matches
+type Results struct {
+	Total int64 `json:"total"`
+	Hits  []Hit `json:"hits"`
+}
+
+// Index maintains and queries named indexes. Index creates or replaces
+// documents by ID, so updates are re-indexing the whole document.
+type Index interface {
+	Index(ctx context.Context, index string, docs ...Document) error
+	Delete(ctx context.Context, index string, ids ...string) error
+	Search(ctx context.Context, index string, query Query) (*Results, error)
+}
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+func normalize(q Query) Query {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	return q
+}
+
+func validateDocs(docs []Document) error {
+	for _, doc := range docs {
+		if doc.ID == "" {
+			return fmt.Errorf("search: document id is required")
+		}
+	}
+	return nil
+}
+
+// instrumented records duration and errors for every operation
+type instrumented struct {
+	index  Index
+	prefix string
+	stats  metrics.Agent
+}
+
+// WithMetrics wraps index so every operation emits
+// "search.<backend>.<operation>.{duration,error}"
+func WithMetrics(index Index, backend string, stats metrics.Agent) Index {
+	return &instrumented{index: index, prefix: "search." + backend, stats: stats}
+}
+
+func (s *instrumented) observe(op string, start time.Time, err error) {
+	s.stats.Timing(s.prefix+"."+op+".duration", time.Since(start))
+	if err != nil {
+		s.stats.Increment(s.prefix + "." + op + ".error")
+	}
+}
+
+func (s *instrumented) Index(ctx context.Context, index string, docs ...Document) error {
+	start := time.Now()
+	err := s.index.Index(ctx, index, docs...)
+	s.observe("index", start, err)
+	if err == nil {
+		s.stats.Count(s.prefix+".index.documents", len(docs))
+	}
+	return err
+}
+
+func (s *instrumented) Delete(ctx context.Context, index string, ids ...string) error {
+	start := time.Now()
+	err := s.index.Delete(ctx, index, ids...)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *instrumented) Search(ctx context.Context, index string, query Query) (*Results, error) {
+	start := time.Now()
+	res, err := s.index.Search(ctx, index, query)
+	s.observe("search", start, err)
+	return res, err
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// batchMetricsPrefix is the bucket prefix for batch endpoint metrics
+const batchMetricsPrefix = httpMetricsPrefix + ".batch"
+
+// Batch serves several API requests sent in one body, cutting round trips
+// for clients on slow networks. Each sub-request runs through handler, the
+// public router, so it passes the full middleware pipeline and is logged
+// and counted like any other request. Sub-requests carry the batch
+// request's headers, cookies and client address, so they authenticate as
+// the batch's caller.
+//
+// Up to cfg.Concurrency sub-requests of a batch run at once, in no
+// particular order; send dependent writes in separate batches.
+type Batch struct {
+	cfg     *config.BatchConfig
+	handler http.Handler
+	logger  *zap.Logger
+	stats   metrics.Agent
+}
+
+// NewBatch creates a batch endpoint dispatching to handler. It refuses
+// limits that aren't positive: no batch could run under them, and with no
+// concurrency every batch would wait forever.
+func NewBatch(cfg *config.BatchConfig, handler http.Handler, logger *zap.Logger, stats metrics.Agent) (*Batch, error) {
+	if cfg.MaxRequests <= 0 || cfg.Concurrency <= 0 || cfg.MaxBodyBytes <= 0 {
+		return nil, errors.New("batch needs a positive max_requests, concurrency and max_body_bytes")
+	}
+	return &Batch{
+		cfg:     cfg,
+		handler: handler,
+		logger:  logger.With(zap.String("component", "batch")),
+		stats:   stats,
+	}, nil
+}
+
+type batchRequest struct {
+	Requests []batchItem `json:"requests"`
+}
+
+type batchItem struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"` // including any query string
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+type batchResult struct {
+	ID      string            `json:"id"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"` // JSON as is, anything else as a string
+	Error   string            `json:"error,omitempty"`
+}
+
+// batchHeaders are not passed from the batch request to its sub-requests:
+// they describe the batch body, not the sub-request's
+var batchHeaders = []string{"Content-Length", "Content-Type", "Content-Encoding", "Accept-Encoding", "Expect"}
+
+// ServeHTTP runs the batch and responds with 200 and a result per
+// sub-request, in request order, whatever their statuses
+func (b *Batch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, b.cfg.MaxBodyBytes)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			b.reject(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch body exceeds %d bytes", b.cfg.MaxBodyBytes))
+			return
+		}
+		b.reject(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+	if err := b.validate(req.Requests); err != nil {
+		b.reject(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := make([]batchResult, len(req.Requests))
+	sem := make(chan struct{}, b.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i, item := range req.Requests {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = b.run(r, item)
+		}()
+	}
+	wg.Wait()
+
+	b.stats.Count(batchMetricsPrefix+".requests", len(results))
+	b.stats.Timing(batchMetricsPrefix+".duration", time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"responses": results})
+}
+
+func (b *Batch) validate(items []batchItem) error {
+	if len(items) == 0 {
+		return errors.New("batch has no requests")
+	}
+	if len(items) > b.cfg.MaxRequests {
+		return fmt.Errorf("batch has %d requests, at most %d are allowed", len(items), b.cfg.MaxRequests)
+	}
+	for i, item := range items {
+		path, _, _ := strings.Cut(item.Path, "?")
+		switch {
+		case !strings.HasPrefix(item.Path, "/"):
+			return fmt.Errorf("requests[%d].path must start with /", i)
+		case path == b.cfg.Path:
+			return fmt.Errorf("requests[%d] may not be a batch", i)
+		case item.Method != "" && methodLabel(strings.ToUpper(item.Method)) == "other":
+			return fmt.Errorf("requests[%d].method %q is not supported", i, item.Method)
+		}
+	}
+	return nil
+}
+
+// run serves one sub-request and captures its response
+func (b *Batch) run(parent *http.Request, item batchItem) (result batchResult) {
+	result.ID = item.ID
+	if parent.Context().Err() != nil {
+		// The client has gone; nobody reads the rest
+		return batchResult{ID: item.ID, Status: http.StatusServiceUnavailable, Error: "batch canceled"}
+	}
+
+	method := strings.ToUpper(item.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	// A fresh routing context, so the router routes the sub-request
+	// rather than resuming the batch's own route
+	ctx := context.WithValue(parent.Context(), chi.RouteCtxKey, (*chi.Context)(nil))
+	sub, err := http.NewRequestWithContext(ctx, method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return batchResult{ID: item.ID, Status: http.StatusBadRequest, Error: "invalid path"}
+	}
+	sub.RequestURI = item.Path
+	sub.Host = parent.Host
+	sub.RemoteAddr = parent.RemoteAddr
+	sub.TLS = parent.TLS
+	sub.Proto, sub.ProtoMajor, sub.ProtoMinor = parent.Proto, parent.ProtoMajor, parent.ProtoMinor
+
+	sub.Header = parent.Header.Clone()
+	for _, name := range batchHeaders {
+		sub.Header.Del(name)
+	}
+	if len(item.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range item.Headers {
+		sub.Header.Set(name, value)
+	}
+
+	rec := &batchRecorder{header: make(http.Header), limit: b.cfg.MaxBodyBytes}
+	defer func() {
+		// The recoverer middleware handles panics in handlers; this covers
+		// the pipeline itself, which would otherwise take the process down
+		// from this goroutine
+		if p := recover(); p != nil {
+			b.logger.Error("Batch sub-request panicked",
+				zap.String("method", method), zap.String("path", item.Path), zap.Any("panic", p))
+			result = batchResult{ID: item.ID, Status: http.StatusInternalServerError, Error: "internal error"}
+		}
+	}()
+	b.handler.ServeHTTP(rec, sub)
+
+	result.Status = rec.status
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if rec.truncated {
+		result.Error = fmt.Sprintf("response body exceeds %d bytes", b.cfg.MaxBodyBytes)
+	} else if rec.body.Len() > 0 {
+		result.Body = responseBody(rec.header.Get("Content-Type"), rec.body.Bytes())
+	}
+	if len(rec.header) > 0 {
+		result.Headers = make(map[string]string, len(rec.header))
+		for name := range rec.header {
+			result.Headers[name] = rec.header.Get(name)
+		}
+	}
+	return result
+}
+
+// reject fails the whole batch
+func (b *Batch) reject(w http.ResponseWriter, status int, message string) {
+	b.stats.Increment(batchMetricsPrefix + ".rejected")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// responseBody embeds a JSON body as is and encodes anything else as a
+// JSON string
+func responseBody(contentType string, body []byte) json.RawMessage {
+	if strings.Contains(contentType, "json") && json.Valid(body) {
+		return append(json.RawMessage(nil), body...)
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+// batchRecorder captures a sub-request's response, up to limit bytes of
+// body
+type batchRecorder struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (r *batchRecorder) Header() http.Header { return r.header }
+
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if int64(r.body.Len()+len(p)) > r.limit {
+		r.truncated = true
+		return 0, errors.New("batch: response body too large")
+	}
+	return r.body.Write(p)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"crypto/x509"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CertMonitor watches the expiry of the certificate the server serves. The
+// server reads its certificate once, when it starts, so that is the one
+// watched: a renewed certificate on disk takes effect on restart.
+//
+// The days left are exported as the tls.certificate.days_remaining gauge,
+// floored at 0, so alerts can fire well before expiry.
+type CertMonitor struct {
+	cfg    *config.CertExpiryConfig
+	leaf   *x509.Certificate
+	file   string
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCertMonitor loads the certificate tlsCfg serves
+func NewCertMonitor(tlsCfg *config.TLSConfig, logger *zap.Logger, stats metrics.Agent) (*CertMonitor, error) {
+	leaf, err := tlsCfg.Leaf()
+	if err != nil {
+		return nil, err
+	}
+	return &CertMonitor{
+		cfg:    tlsCfg.Expiry,
+		leaf:   leaf,
+		file:   tlsCfg.CertFile,
+		logger: logger.With(zap.String("component", "tls_expiry")),
+		stats:  stats,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start checks the certificate now and then every cfg.Interval
+func (m *CertMonitor) Start() error {
+	m.check(time.Now())
+	go m.run()
+	return nil
+}
+
+// Close stops the checks
+func (m *CertMonitor) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
+}
+
+func (m *CertMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.check(now)
+		}
+	}
+}
+
+func (m *CertMonitor) check(now time.Time) {
+	remaining := m.leaf.NotAfter.Sub(now)
+	days := int(remaining.Hours() / 24)
+	if remaining < 0 {
+		// statsd reads a negative gauge as a decrement
+		days = 0
+	}
+	m.stats.Gauge("tls.certificate.days_remaining", days)
+
+	fields := []zap.Field{
+		zap.String("cert_file", m.file),
+		zap.String("subject", m.leaf.Subject.CommonName),
+		zap.Time("not_after", m.leaf.NotAfter),
+		zap.Int("days_remaining", days),
+	}
+	switch {
+	case remaining <= 0:
+		m.logger.Error("TLS certificate has expired", fields...)
+	case remaining < m.cfg.WarnBefore:
+		m.logger.Warn("TLS certificate expires soon", fields...)
+	default:
+		m.logger.Debug("TLS certificate checked", fields...)
+	}
+}
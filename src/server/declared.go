@@ -0,0 +1,156 @@
+package server
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/deadline"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// defaultProxyClient names the outbound client proxy routes use unless
+// they choose one
+const defaultProxyClient = "proxy"
+
+// ClientFactory hands out the named outbound HTTP clients proxy routes
+// send through; *httpclient.Factory implements it
+type ClientFactory interface {
+	Client(name string) *http.Client
+}
+
+// DeclaredRoutes builds the routes declared in config, for infrastructure
+// behaviour such as retired URLs, verification files and legacy backends
+// that shouldn't need a code change. Static files are read now, so a
+// missing file fails startup rather than a request.
+func DeclaredRoutes(routes []*config.RouteConfig, clients ClientFactory, logger *zap.Logger) (func(chi.Router), error) {
+	logger = logger.With(zap.String("component", "routes"))
+	handlers := make([]http.Handler, len(routes))
+	for i, route := range routes {
+		var err error
+		switch {
+		case route.Redirect != nil:
+			handlers[i] = redirectHandler(route.Redirect)
+		case route.Static != nil:
+			handlers[i], err = staticHandler(route.Static)
+		case route.Proxy != nil:
+			handlers[i], err = proxyHandler(route.Proxy, clients, logger)
+		default:
+			err = fmt.Errorf("declares no redirect, static response or proxy")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Path, err)
+		}
+	}
+
+	return func(r chi.Router) {
+		for i, route := range routes {
+			if len(route.Methods) == 0 {
+				r.Handle(route.Path, handlers[i])
+				continue
+			}
+			for _, method := range route.Methods {
+				r.Method(strings.ToUpper(method), route.Path, handlers[i])
+			}
+		}
+	}, nil
+}
+
+func redirectHandler(cfg *config.RedirectRoute) http.Handler {
+	status := cfg.Status
+	if status == 0 {
+		status = http.StatusPermanentRedirect
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := cfg.To
+		if strings.HasSuffix(target, "/") {
+			target += strings.TrimPrefix(chi.URLParam(r, "*"), "/")
+		}
+		if r.URL.RawQuery != "" {
+			separator := "?"
+			if strings.Contains(target, "?") {
+				separator = "&"
+			}
+			target += separator + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
+
+func staticHandler(cfg *config.StaticRoute) (http.Handler, error) {
+	body := []byte(cfg.Body)
+	if cfg.File != "" {
+		var err error
+		if body, err = os.ReadFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+	status := cfg.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := cfg.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range cfg.Headers {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			w.Write(body)
+		}
+	}), nil
+}
+
+func proxyHandler(cfg *config.ProxyRoute, clients ClientFactory, logger *zap.Logger) (http.Handler, error) {
+	upstream, err := url.Parse(cfg.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %w", err)
+	}
+	name := cfg.Client
+	if name == "" {
+		name = defaultProxyClient
+	}
+	logger = logger.With(zap.String("upstream", upstream.Redacted()))
+
+	proxy := &httputil.ReverseProxy{
+		Transport: clients.Client(name).Transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, cfg.StripPrefix)
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status := http.StatusBadGateway
+			if r.Context().Err() == context.DeadlineExceeded {
+				status = http.StatusGatewayTimeout
+			}
+			logger.Warn("Proxy request failed", zap.String("path", r.URL.Path), zap.Int("status", status), zap.Error(err))
+			w.WriteHeader(status)
+		},
+	}
+	if cfg.Timeout <= 0 {
+		return proxy, nil
+	}
+	// Shortens the budget only, and the proxy's client passes what is
+	// left on to the upstream in X-Request-Deadline
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel, err := deadline.Bound(r.Context(), cfg.Timeout, 0)
+		defer cancel()
+		if err != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	}), nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/httpx"
+	"coffee-and-running/src/observability/metrics"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fieldsMiddleware prunes JSON responses to the members named in the
+// request's ?fields=, see httpx.ParseFields. Options: param, the query
+// parameter (default "fields"), and max_bytes, past which a response is
+// sent whole (default 8 MiB).
+func fieldsMiddleware(opts config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	param, err := opts.String("param", "fields")
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := opts.Int("max_bytes", 8<<20)
+	if err != nil {
+		return nil, err
+	}
+	return SparseFields(param, maxBytes, deps.Stats), nil
+}
+
+// SparseFields returns middleware pruning successful JSON responses to the
+// members the client selects with the param query parameter, so mobile
+// clients can trim payloads without per-endpoint code. An invalid
+// selection is answered with 400 before the handler runs. Responses that
+// aren't 2xx JSON, are already encoded, are flushed while streaming or
+// exceed maxBytes go out whole.
+func SparseFields(param string, maxBytes int, stats metrics.Agent) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			selection := r.URL.Query().Get(param)
+			if selection == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fields, err := httpx.ParseFields(selection)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+
+			fw := &fieldsWriter{ResponseWriter: w, maxBytes: maxBytes}
+			next.ServeHTTP(fw, r)
+			if !fw.buffering() {
+				return
+			}
+
+			body := fw.body.Bytes()
+			pruned, err := fields.Prune(body)
+			if err != nil {
+				// Not the JSON it claimed to be; send it as it is
+				stats.Increment("http.fields.invalid")
+				pruned = body
+			} else {
+				pruned = append(pruned, '\n')
+				stats.Count("http.fields.saved_bytes", len(body)-len(pruned))
+				// The handler's validator is for the whole document. Caches
+				// key on the URL, selection included, so each selection is
+				// stored apart; it just can't be revalidated.
+				w.Header().Del("ETag")
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(pruned)))
+			w.WriteHeader(fw.code)
+			w.Write(pruned)
+		})
+	}
+}
+
+// fieldsWriter holds back a response the middleware may prune. It decides
+// when the status is written: JSON successes are buffered, anything else
+// passes straight through.
+type fieldsWriter struct {
+	http.ResponseWriter
+	maxBytes int
+
+	code        int
+	passthrough bool
+	body        bytes.Buffer
+}
+
+// buffering reports whether the response is held back for pruning
+func (f *fieldsWriter) buffering() bool {
+	return f.code != 0 && !f.passthrough
+}
+
+func (f *fieldsWriter) WriteHeader(code int) {
+	if f.code != 0 {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// Informational, e.g. 103 Early Hints; the final status follows
+		f.ResponseWriter.WriteHeader(code)
+		return
+	}
+	f.code = code
+	h := f.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if code < 200 || code >= 300 || code == http.StatusNoContent || !isJSON || h.Get("Content-Encoding") != "" {
+		f.passthrough = true
+		f.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (f *fieldsWriter) Write(b []byte) (int, error) {
+	if f.code == 0 {
+		f.WriteHeader(http.StatusOK)
+	}
+	if f.passthrough {
+		return f.ResponseWriter.Write(b)
+	}
+	if f.body.Len()+len(b) > f.maxBytes {
+		f.release()
+		return f.ResponseWriter.Write(b)
+	}
+	return f.body.Write(b)
+}
+
+// Flush gives up on pruning: the handler is streaming
+func (f *fieldsWriter) Flush() {
+	if f.code == 0 {
+		f.WriteHeader(http.StatusOK)
+	}
+	if !f.passthrough {
+		f.release()
+	}
+	if fl, ok := f.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// release sends the status and what is buffered, and passes the rest
+// through
+func (f *fieldsWriter) release() {
+	f.passthrough = true
+	f.ResponseWriter.WriteHeader(f.code)
+	f.ResponseWriter.Write(f.body.Bytes())
+	f.body = bytes.Buffer{}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (f *fieldsWriter) Unwrap() http.ResponseWriter {
+	return f.ResponseWriter
+}
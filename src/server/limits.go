@@ -0,0 +1,144 @@
+package server
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// connMetricsPrefix is the bucket prefix for listener connection metrics
+const connMetricsPrefix = httpMetricsPrefix + ".connections"
+
+// limitListener closes connections beyond the configured total and
+// per-IP limits as soon as they are accepted, counting each rejection
+type limitListener struct {
+	net.Listener
+	max      int64
+	maxPerIP int
+	stats    metrics.Agent
+
+	open  atomic.Int64
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+// LimitConnections wraps ln to enforce cfg's limits. The per-IP limit is
+// checked on the connection's first use rather than in Accept, so it sees
+// the client address from a PROXY protocol header without a slow client
+// holding up Accept. Only TCP peers have an IP to limit.
+func LimitConnections(ln net.Listener, cfg *config.ConnectionsConfig, stats metrics.Agent) net.Listener {
+	return &limitListener{
+		Listener: ln,
+		max:      int64(cfg.Max),
+		maxPerIP: cfg.MaxPerIP,
+		stats:    stats,
+		perIP:    make(map[string]int),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		open := l.open.Add(1)
+		if l.max > 0 && open > l.max {
+			l.open.Add(-1)
+			conn.Close()
+			l.stats.Increment(connMetricsPrefix + ".rejected.max")
+			continue
+		}
+		l.stats.Gauge(connMetricsPrefix+".open", open)
+		return &limitConn{Conn: conn, listener: l}, nil
+	}
+}
+
+// acquire counts a connection against ip, reporting false when ip is at
+// its limit
+func (l *limitListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+func (l *limitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+// limitConn holds its place in the limits until closed
+type limitConn struct {
+	net.Conn
+	listener *limitListener
+	once     sync.Once
+
+	mu       sync.Mutex
+	rejected bool
+	closed   bool
+	ip       string // counted against its IP when set
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	c.once.Do(c.check)
+	c.mu.Lock()
+	rejected := c.rejected
+	c.mu.Unlock()
+	if rejected {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Read(b)
+}
+
+// check applies the per-IP limit, closing the connection when it's over
+func (c *limitConn) check() {
+	l := c.listener
+	if l.maxPerIP <= 0 {
+		return
+	}
+	tcp, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	ip := tcp.IP.String()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if l.acquire(ip) {
+		c.ip = ip
+		c.mu.Unlock()
+		return
+	}
+	c.rejected = true
+	c.mu.Unlock()
+	l.stats.Increment(connMetricsPrefix + ".rejected.per_ip")
+	c.Close()
+}
+
+func (c *limitConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		l := c.listener
+		if c.ip != "" {
+			l.release(c.ip)
+		}
+		l.stats.Gauge(connMetricsPrefix+".open", l.open.Add(-1))
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
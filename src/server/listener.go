@@ -0,0 +1,124 @@
+package server
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes
+const listenFDsStart = 3
+
+// ErrNoActivation is returned by Listen for the systemd network when the
+// process was not started by socket activation
+var ErrNoActivation = errors.New("server: no sockets passed by systemd")
+
+// Listen binds the server's configured listener: TCP at host:port by
+// default, a unix socket, or a socket passed by systemd. With
+// proxy_protocol enabled, it reads client addresses from PROXY headers,
+// and it enforces the connection limits.
+func Listen(cfg *config.ServerConfig, stats metrics.Agent) (net.Listener, error) {
+	ln, err := listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if p := cfg.ProxyProtocol; p != nil && p.Enabled {
+		proxied, err := ProxyProtocol(ln, p)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = proxied
+	}
+	if c := cfg.Connections; c != nil && (c.Max > 0 || c.MaxPerIP > 0) {
+		ln = LimitConnections(ln, c, stats)
+	}
+	return ln, nil
+}
+
+func listen(cfg *config.ServerConfig) (net.Listener, error) {
+	l := cfg.Listener
+	if l == nil {
+		return net.Listen("tcp", cfg.Address())
+	}
+	switch l.Network {
+	case "", "tcp":
+		return net.Listen("tcp", cfg.Address())
+	case "unix":
+		return listenUnix(l.Path, l.Mode)
+	case "systemd":
+		return listenSystemd(l.Name)
+	default:
+		return nil, fmt.Errorf("unsupported listener network: %s", l.Network)
+	}
+}
+
+// listenUnix listens on a unix socket at path, replacing a stale socket
+// left by a process that didn't shut down cleanly. A socket something still
+// accepts on is reported as in use, so server.bind_retry applies.
+func listenUnix(path, mode string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("listen unix %s: not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen unix %s: %w", path, syscall.EADDRINUSE)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid socket mode %q: %w", mode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// listenSystemd returns the socket systemd passed under name, or the first
+// one when name is empty, following sd_listen_fds(3)
+func listenSystemd(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, ErrNoActivation
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, ErrNoActivation
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+		if name != "" && (i >= len(names) || names[i] != name) {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), fmt.Sprintf("systemd-socket-%d", i))
+		// FileListener duplicates the descriptor
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use systemd socket %d: %w", i, err)
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("%w: none named %s", ErrNoActivation, name)
+}
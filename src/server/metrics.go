@@ -0,0 +1,101 @@
+package server
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// httpMetricsPrefix is the bucket prefix for all HTTP server metrics
+const httpMetricsPrefix = "http.server"
+
+// inFlight counts the requests in flight across every MetricsMiddleware,
+// so the public and admin routers report one http.server.in_flight gauge
+// rather than overwriting each other's
+var inFlight atomic.Int64
+
+// MetricsMiddleware records request count, duration, response size and
+// in-flight requests. Buckets are labelled by method, chi route pattern
+// (never the raw path, to keep cardinality bounded) and status class, e.g.
+// "http.server.get.api_v1_users_id.2xx.requests".
+func MetricsMiddleware(stats metrics.Agent) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			stats.Gauge(httpMetricsPrefix+".in_flight", inFlight.Add(1))
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			// Deferred so requests that panic further down are still counted
+			defer func() {
+				stats.Gauge(httpMetricsPrefix+".in_flight", inFlight.Add(-1))
+
+				bucket := fmt.Sprintf("%s.%s.%s.%s",
+					httpMetricsPrefix, methodLabel(r.Method), routeLabel(r), statusClass(ww.Status()))
+
+				stats.Increment(bucket + ".requests")
+				stats.Timing(bucket+".duration", time.Since(start))
+				stats.Count(bucket+".response_size", ww.BytesWritten())
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// methodLabel normalizes the HTTP method, folding unknown methods together
+// so arbitrary client input can't create new buckets
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return strings.ToLower(method)
+	default:
+		return "other"
+	}
+}
+
+// routeLabel converts the matched chi route pattern into a metric-safe
+// bucket segment, e.g. "/api/v1/users/{id}" becomes "api_v1_users_id"
+func routeLabel(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return "unmatched"
+	}
+
+	pattern := rctx.RoutePattern()
+	if pattern == "" {
+		return "unmatched"
+	}
+	if pattern == "/" {
+		return "root"
+	}
+
+	var b strings.Builder
+	for _, c := range strings.Trim(pattern, "/") {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			b.WriteRune(c)
+		case c == '*':
+			b.WriteString("wildcard")
+		case c == '/', c == '.', c == '_':
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// statusClass returns the status class label (2xx, 4xx, ...). Handlers
+// that never call WriteHeader implicitly respond with 200.
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
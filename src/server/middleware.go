@@ -0,0 +1,299 @@
+package server
+
+import (
+	"coffee-and-running/src/clientip"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/deadline"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/observability/tracing"
+	"coffee-and-running/src/requestid"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/cors"
+	"go.uber.org/zap"
+)
+
+// Middleware is the standard chi/net/http middleware signature
+type Middleware = func(http.Handler) http.Handler
+
+// MiddlewareFactory builds a middleware from its configured options
+type MiddlewareFactory func(opts config.MiddlewareOptions, deps Dependencies) (Middleware, error)
+
+// Dependencies are the shared components middleware factories may use
+type Dependencies struct {
+	Config *config.ServerConfig
+	App    *config.AppConfig
+	Logger *zap.Logger
+	Stats  metrics.Agent
+	Tracer tracing.Provider
+}
+
+// Position describes where a custom middleware is inserted relative to an
+// existing entry in the pipeline
+type Position struct {
+	anchor string
+	after  bool
+}
+
+// Before inserts a middleware immediately before the named middleware
+func Before(name string) Position {
+	return Position{anchor: name}
+}
+
+// After inserts a middleware immediately after the named middleware
+func After(name string) Position {
+	return Position{anchor: name, after: true}
+}
+
+// First inserts a middleware at the start of the pipeline
+func First() Position {
+	return Position{}
+}
+
+// Last inserts a middleware at the end of the pipeline
+func Last() Position {
+	return Position{after: true}
+}
+
+// insertion records a custom middleware placed by code rather than config
+type insertion struct {
+	name     string
+	position Position
+	// public middleware is left out of the admin pipeline
+	public bool
+}
+
+// Registry holds the named middleware factories and the insertions requested
+// by the application. Built-in middleware is registered by NewRegistry.
+type Registry struct {
+	factories  map[string]MiddlewareFactory
+	insertions []insertion
+
+	// built maps middleware built by Build to its name, for Routes
+	mu    sync.Mutex
+	built map[uintptr]string
+}
+
+// NewRegistry creates a registry with the built-in middleware registered
+func NewRegistry() *Registry {
+	r := &Registry{
+		factories: make(map[string]MiddlewareFactory),
+		built:     make(map[uintptr]string),
+	}
+
+	r.factories["request_id"] = requestIDMiddleware
+	r.factories["real_ip"] = realIPMiddleware
+	r.factories["tracing"] = tracingMiddleware
+	r.factories["metrics"] = metricsMiddleware
+	r.factories["logger"] = simpleMiddleware(middleware.Logger)
+	r.factories["recoverer"] = simpleMiddleware(middleware.Recoverer)
+	r.factories["timeout"] = timeoutMiddleware
+	r.factories["cors"] = corsMiddleware
+	r.factories["fields"] = fieldsMiddleware
+
+	return r
+}
+
+// Register adds a named middleware factory that can be referenced from the
+// server.middleware config list. It overrides any built-in of the same name.
+func (r *Registry) Register(name string, factory MiddlewareFactory) {
+	r.factories[name] = factory
+}
+
+// Insert registers a factory and places it in the pipeline at the given
+// position, unless the configured pipeline already lists it explicitly
+func (r *Registry) Insert(name string, factory MiddlewareFactory, position Position) {
+	r.Register(name, factory)
+	r.insertions = append(r.insertions, insertion{name: name, position: position})
+}
+
+// InsertPublic is Insert for middleware that guards the public routes
+// only, such as auth and tenancy. BuildAdmin leaves it out, since the
+// admin routes authenticate on their own.
+func (r *Registry) InsertPublic(name string, factory MiddlewareFactory, position Position) {
+	r.Register(name, factory)
+	r.insertions = append(r.insertions, insertion{name: name, position: position, public: true})
+}
+
+// Names returns the registered middleware names in sorted order
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build resolves the configured pipeline (plus any code insertions) into an
+// ordered list of middleware
+func (r *Registry) Build(pipeline []config.MiddlewareConfig, deps Dependencies) ([]Middleware, error) {
+	return r.build(pipeline, deps, false)
+}
+
+// BuildAdmin is Build without the middleware inserted with InsertPublic,
+// even where the pipeline lists it
+func (r *Registry) BuildAdmin(pipeline []config.MiddlewareConfig, deps Dependencies) ([]Middleware, error) {
+	return r.build(pipeline, deps, true)
+}
+
+func (r *Registry) build(pipeline []config.MiddlewareConfig, deps Dependencies, admin bool) ([]Middleware, error) {
+	if len(pipeline) == 0 {
+		pipeline = config.DefaultMiddleware()
+	}
+
+	// Apply code insertions for middleware the config doesn't mention
+	entries := append([]config.MiddlewareConfig(nil), pipeline...)
+	for _, ins := range r.insertions {
+		if indexOf(entries, ins.name) >= 0 {
+			continue
+		}
+
+		entry := config.MiddlewareConfig{Name: ins.name}
+		switch {
+		case ins.position.anchor == "" && !ins.position.after:
+			entries = append([]config.MiddlewareConfig{entry}, entries...)
+		case ins.position.anchor == "":
+			entries = append(entries, entry)
+		default:
+			idx := indexOf(entries, ins.position.anchor)
+			if idx < 0 {
+				return nil, fmt.Errorf("middleware %s positioned relative to unknown middleware %s",
+					ins.name, ins.position.anchor)
+			}
+			if ins.position.after {
+				idx++
+			}
+			entries = append(entries[:idx], append([]config.MiddlewareConfig{entry}, entries[idx:]...)...)
+		}
+	}
+
+	public := make(map[string]bool)
+	if admin {
+		for _, ins := range r.insertions {
+			public[ins.name] = ins.public
+		}
+	}
+
+	chain := make([]Middleware, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.Name] {
+			return nil, fmt.Errorf("middleware %s listed more than once", entry.Name)
+		}
+		seen[entry.Name] = true
+
+		if entry.Disabled || public[entry.Name] {
+			continue
+		}
+
+		factory, ok := r.factories[entry.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware: %s", entry.Name)
+		}
+
+		mw, err := factory(entry.Options, deps)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build middleware %s: %w", entry.Name, err)
+		}
+		r.remember(entry.Name, mw)
+		chain = append(chain, mw)
+	}
+
+	return chain, nil
+}
+
+// indexOf returns the index of the named entry, or -1
+func indexOf(entries []config.MiddlewareConfig, name string) int {
+	for i, entry := range entries {
+		if entry.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// simpleMiddleware adapts a middleware that takes no options
+func simpleMiddleware(mw Middleware) MiddlewareFactory {
+	return func(config.MiddlewareOptions, Dependencies) (Middleware, error) {
+		return mw, nil
+	}
+}
+
+// requestIDMiddleware propagates X-Request-ID, echoed back to clients and
+// trusted from proxies
+func requestIDMiddleware(_ config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	m, err := requestid.NewMiddleware(deps.Config.RequestID.Header, deps.Config.RequestID.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return m.Handler, nil
+}
+
+// realIPMiddleware resolves the client address, reading forwarding
+// headers only from trusted proxies
+func realIPMiddleware(_ config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	var trusted []string
+	if deps.Config.RealIP != nil {
+		trusted = deps.Config.RealIP.TrustedProxies
+	}
+	m, err := clientip.NewMiddleware(trusted)
+	if err != nil {
+		return nil, err
+	}
+	return m.Handler, nil
+}
+
+// tracingMiddleware creates server spans named after the route template
+func tracingMiddleware(_ config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	return tracing.Middleware(deps.Tracer), nil
+}
+
+// metricsMiddleware records per-route HTTP metrics
+func metricsMiddleware(_ config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	return MetricsMiddleware(deps.Stats), nil
+}
+
+// timeoutMiddleware sets a deadline on the request context (ctx), that
+// will signal through ctx.Done() that the request has timed out and further
+// processing should be stopped. A caller's sooner X-Request-Deadline
+// shortens it, and the database and outbound HTTP clients work within it.
+func timeoutMiddleware(opts config.MiddlewareOptions, _ Dependencies) (Middleware, error) {
+	timeout, err := opts.Duration("timeout", 60*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return deadline.Middleware(timeout), nil
+}
+
+// corsMiddleware applies the server CORS configuration, resolved for the
+// app's environment
+func corsMiddleware(_ config.MiddlewareOptions, deps Dependencies) (Middleware, error) {
+	cfg := deps.Config
+	var environment string
+	if deps.App != nil {
+		environment = deps.App.Environment
+	}
+	resolved, err := cfg.CORS.Resolve(environment)
+	if err != nil {
+		return nil, err
+	}
+	corsOptions := cors.Options{
+		AllowedOrigins:   resolved.AllowedOrigins,
+		AllowedMethods:   resolved.AllowedMethods,
+		AllowedHeaders:   resolved.AllowedHeaders,
+		ExposedHeaders:   append([]string{cfg.RequestID.Header}, resolved.ExposedHeaders...),
+		AllowCredentials: resolved.AllowCredentials,
+		MaxAge:           resolved.MaxAge,
+	}
+	if len(resolved.AllowedOrigins) == 0 {
+		// The handler treats no origins as any, so refuse them explicitly
+		corsOptions.AllowOriginFunc = func(*http.Request, string) bool { return false }
+	}
+	return cors.Handler(corsOptions), nil
+}
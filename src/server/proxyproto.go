@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/requestid"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProxyHeader is returned when reading a connection whose PROXY
+// protocol header is malformed
+var ErrProxyHeader = errors.New("server: invalid PROXY protocol header")
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// proxyV1MaxLength is the longest v1 header, CRLF included
+	proxyV1MaxLength = 107
+	// proxyV2HeaderLength is the fixed part of a v2 header
+	proxyV2HeaderLength = 16
+)
+
+// proxyListener reads the PROXY protocol header of connections from
+// trusted peers
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// ProxyProtocol wraps ln so connections from trusted peers report the
+// client address their PROXY protocol header carries. The header is read on
+// the connection's first use rather than in Accept, so a slow peer can't
+// hold up others. Connections without a header are served as they are.
+func ProxyProtocol(ln net.Listener, cfg *config.ProxyProtocolConfig) (net.Listener, error) {
+	trusted, err := requestid.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_protocol.trusted_proxies: %w", err)
+	}
+	return &proxyListener{Listener: ln, trusted: trusted, timeout: cfg.HeaderTimeout}, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// isTrusted reports whether addr may send a header. Unix socket peers are
+// local proxies and always trusted.
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	if len(l.trusted) == 0 {
+		return true
+	}
+	for _, network := range l.trusted {
+		if network.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the header before the first Read or address lookup
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	prefix, err := c.reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		// Too short for a header; leave the error to the next Read
+		return
+	}
+	switch {
+	case bytes.Equal(prefix, proxyV1Prefix):
+		c.remote, c.local, c.err = readProxyV1(c.reader)
+	case bytes.Equal(prefix, proxyV2Sig[:len(prefix)]):
+		c.remote, c.local, c.err = readProxyV2(c.reader)
+	}
+	if c.err != nil {
+		c.Conn.Close()
+	}
+}
+
+// readProxyV1 parses a text header such as
+// "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n". UNKNOWN connections keep
+// the peer's address.
+func readProxyV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header is not terminated", ErrProxyHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: malformed v1 header", ErrProxyHeader)
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return nil, nil, fmt.Errorf("%w: malformed v1 addresses", ErrProxyHeader)
+	}
+	return &net.TCPAddr{IP: src, Port: int(srcPort)}, &net.TCPAddr{IP: dst, Port: int(dstPort)}, nil
+}
+
+// readProxyV2 parses a binary header. LOCAL connections, such as the
+// balancer's own health checks, and address families other than TCP over
+// IPv4 or IPv6 keep the peer's address.
+func readProxyV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+	}
+	if !bytes.Equal(header[:len(proxyV2Sig)], proxyV2Sig) {
+		return nil, nil, fmt.Errorf("%w: bad v2 signature", ErrProxyHeader)
+	}
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrProxyHeader, verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrProxyHeader, err)
+	}
+
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported command %d", ErrProxyHeader, verCmd&0x0f)
+	}
+
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	// Addresses are followed by optional TLVs, which are skipped
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: v2 addresses are truncated", ErrProxyHeader)
+	}
+	src := net.IP(append([]byte(nil), body[:size]...))
+	dst := net.IP(append([]byte(nil), body[size:2*size]...))
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	return &net.TCPAddr{IP: src, Port: int(srcPort)}, &net.TCPAddr{IP: dst, Port: int(dstPort)}, nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// Route is a method and pattern served by a router, with the middleware
+// that wraps its handler, outermost first
+type Route struct {
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Middleware []string `json:"middleware"`
+}
+
+// Routes lists the routes of router sorted by pattern and method.
+// Middleware from the configured pipeline is named as in the config; other
+// middleware, such as auth.Require added with r.With, by its function.
+func (r *Registry) Routes(router chi.Routes) ([]Route, error) {
+	var routes []Route
+	err := chi.Walk(router, func(method, pattern string, _ http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		names := make([]string, 0, len(middlewares))
+		for _, mw := range middlewares {
+			names = append(names, r.middlewareName(mw))
+		}
+		routes = append(routes, Route{Method: method, Pattern: pattern, Middleware: names})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes, nil
+}
+
+// RoutesHandler serves the routes of each named router as JSON, e.g.
+// {"http": [...], "admin": [...]}
+func (r *Registry) RoutesHandler(routers map[string]chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		out := make(map[string][]Route, len(routers))
+		for name, router := range routers {
+			routes, err := r.Routes(router)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out[name] = routes
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// remember records the pipeline name of a built middleware
+func (r *Registry) remember(name string, mw Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.built[funcPointer(mw)] = name
+}
+
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$|-fm$`)
+
+// middlewareName names mw by its pipeline entry if it was built by the
+// registry, or else by its package and function, e.g. "auth.Require"
+func (r *Registry) middlewareName(mw func(http.Handler) http.Handler) string {
+	r.mu.Lock()
+	name, ok := r.built[funcPointer(mw)]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	fn := runtime.FuncForPC(funcPointer(mw))
+	if fn == nil {
+		return "unknown"
+	}
+	name = fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return closureSuffix.ReplaceAllString(name, "")
+}
+
+// funcPointer identifies a function by its code, so closures returned by
+// the same factory share a pointer
+func funcPointer(fn Middleware) uintptr {
+	return reflect.ValueOf(fn).Pointer()
+}
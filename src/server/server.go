@@ -4,65 +4,98 @@ import (
 	"coffee-and-running/src/config"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"net/http"
-	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-	"github.com/go-chi/cors"
 )
 
-// SetupRouter creates and configures the Chi router with CORS
-func SetupRouter(cfg *config.ServerConfig) *chi.Mux {
-	r := chi.NewRouter()
+// SetupRouter creates the Chi router with the configured middleware pipeline
+// and mounts the given route registration functions
+func SetupRouter(cfg *config.ServerConfig, registry *Registry, deps Dependencies, routes ...func(chi.Router)) (*chi.Mux, error) {
+	return setupRouter(cfg, registry.Build, deps, routes)
+}
 
-	// Basic middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+// SetupAdminRouter is SetupRouter for the admin listener: its pipeline
+// leaves out the middleware inserted with InsertPublic, such as auth, so
+// admin routes can take credentials of their own
+func SetupAdminRouter(cfg *config.ServerConfig, registry *Registry, deps Dependencies, routes ...func(chi.Router)) (*chi.Mux, error) {
+	return setupRouter(cfg, registry.BuildAdmin, deps, routes)
+}
 
-	// Set a timeout value on the request context (ctx), that will signal
-	// through ctx.Done() that the request has timed out and further
-	// processing should be stopped.
-	r.Use(middleware.Timeout(60 * time.Second))
+// SetupRouterWithAdmin is SetupRouter for a public listener that also
+// serves the admin routes. Requests for them are diverted, ahead of the
+// public pipeline, to a router built like SetupAdminRouter's and guarded
+// by guard.
+func SetupRouterWithAdmin(cfg *config.ServerConfig, registry *Registry, deps Dependencies, guard Middleware, admin []func(chi.Router), routes ...func(chi.Router)) (*chi.Mux, error) {
+	guarded := append([]func(chi.Router){func(r chi.Router) { r.Use(guard) }}, admin...)
+	adminRouter, err := setupRouter(cfg, registry.BuildAdmin, deps, guarded)
+	if err != nil {
+		return nil, err
+	}
+	divert := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminRouter.Match(chi.NewRouteContext(), r.Method, r.URL.Path) {
+				adminRouter.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	// Named in route listings like the pipeline entries
+	registry.remember("admin_routes", divert)
+	return setupRouter(cfg, registry.Build, deps, routes, divert)
+}
 
-	// CORS configuration
-	corsOptions := cors.Options{
-		AllowedOrigins:   cfg.CORS.AllowedOrigins,
-		AllowedMethods:   cfg.CORS.AllowedMethods,
-		AllowedHeaders:   cfg.CORS.AllowedHeaders,
-		AllowCredentials: cfg.CORS.AllowCredentials,
-		MaxAge:           cfg.CORS.MaxAge,
+func setupRouter(cfg *config.ServerConfig, build func([]config.MiddlewareConfig, Dependencies) ([]Middleware, error), deps Dependencies, routes []func(chi.Router), first ...Middleware) (*chi.Mux, error) {
+	r := chi.NewRouter()
+	r.Use(first...)
+
+	if deps.Config == nil {
+		deps.Config = cfg
+	}
+	chain, err := build(cfg.Middleware, deps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build middleware pipeline: %w", err)
+	}
+	r.Use(chain...)
+
+	for _, mount := range routes {
+		mount(r)
 	}
-	r.Use(cors.Handler(corsOptions))
 
-	return r
+	return r, nil
 }
 
 // CreateProductionServer creates a production-ready HTTP server with Chi router
-func New(config *config.ServerConfig) *http.Server {
+func New(config *config.ServerConfig, registry *Registry, deps Dependencies, routes ...func(chi.Router)) (*http.Server, error) {
 	// Setup Chi router
-	router := SetupRouter(config)
+	router, err := SetupRouter(config, registry, deps, routes...)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithHandler(config, router)
+}
 
+// NewWithHandler creates the HTTP server for a router built with
+// SetupRouter
+func NewWithHandler(config *config.ServerConfig, handler http.Handler) (*http.Server, error) {
 	// Create the HTTP server
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", config.Host, config.Port),
-		Handler:      router,
+		Handler:      handler,
 		ReadTimeout:  config.ReadTimeout,
 		WriteTimeout: config.WriteTimeout,
 		IdleTimeout:  config.IdleTimeout,
 
-		// Additional production settings
-		ReadHeaderTimeout: 10 * time.Second,
-		MaxHeaderBytes:    1 << 20, // 1 MB
+		// Zero falls back to ReadTimeout and net/http's 1 MB default
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
 	}
 
 	// Configure TLS if enabled
 	if config.TLS.Enabled {
 		if config.TLS.CertFile == "" || config.TLS.KeyFile == "" {
-			log.Fatal("TLS enabled but cert_file or key_file not specified")
+			return nil, fmt.Errorf("TLS enabled but cert_file or key_file not specified")
 		}
 
 		tlsConfig := &tls.Config{
@@ -85,5 +118,5 @@ func New(config *config.ServerConfig) *http.Server {
 		server.TLSConfig = tlsConfig
 	}
 
-	return server
+	return server, nil
 }
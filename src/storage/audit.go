@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// serveFunc is the frame at the bottom of every net/http request goroutine
+const serveFunc = "net/http.(*conn).serve"
+
+// ErrAuditInProduction is returned by NewEngine and OpenEngine, given
+// WithApp, when database.audit_contexts is enabled in production
+var ErrAuditInProduction = errors.New("storage: the context audit is refused in production")
+
+// requestPathKey marks a context as belonging to a request
+type requestPathKey struct{}
+
+// MarkRequests tags each request's context so the engine's context audit
+// can tell calls made on behalf of a request, and which one
+func MarkRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestPathKey{}, r.Method+" "+r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextAuditor warns about engine calls from request paths whose context
+// won't stop them when the request is abandoned: a context never cancelled,
+// such as context.Background(), or a request's context without a deadline.
+// Each call site is reported once. A nil auditor checks nothing.
+type contextAuditor struct {
+	logger   *zap.Logger
+	stats    metrics.Agent
+	reported sync.Map // call site PC -> struct{}
+}
+
+func newContextAuditor(enabled bool, logger *zap.Logger, stats metrics.Agent) *contextAuditor {
+	if !enabled {
+		return nil
+	}
+	return &contextAuditor{logger: logger, stats: stats}
+}
+
+// check audits ctx as passed to the engine method calling it
+func (a *contextAuditor) check(ctx context.Context, op string) {
+	if a == nil {
+		return
+	}
+
+	var problem, msg string
+	path, marked := ctx.Value(requestPathKey{}).(string)
+	_, hasDeadline := ctx.Deadline()
+	switch {
+	case marked && ctx.Done() == nil:
+		problem, msg = "detached", "database call detached from its request's cancellation"
+	case marked && !hasDeadline:
+		problem, msg = "no_deadline", "database call in request path has no deadline"
+	case !marked && ctx.Done() == nil && inRequestGoroutine():
+		problem, msg = "background", "database call in request path uses a context that is never cancelled"
+	default:
+		return
+	}
+
+	caller, ok := applicationCaller()
+	if !ok {
+		return
+	}
+	if _, seen := a.reported.LoadOrStore(caller.PC, struct{}{}); seen {
+		return
+	}
+
+	a.stats.Increment("db.context_audit." + problem)
+	fields := []zap.Field{
+		zap.String("operation", op),
+		zap.String("caller", caller.File+":"+strconv.Itoa(caller.Line)),
+	}
+	if path != "" {
+		fields = append(fields, zap.String("request", path))
+	}
+	a.logger.Warn(msg, fields...)
+}
+
+// storagePackage prefixes the names of this package's functions
+var storagePackage = reflect.TypeOf(contextAuditor{}).PkgPath() + "."
+
+// applicationCaller returns the frame that called into the engine: the
+// first outside this package, however many storage frames, such as a
+// transaction's savepoints or session variables, came between
+func applicationCaller() (runtime.Frame, bool) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !strings.HasPrefix(frame.Function, storagePackage) {
+			return frame, true
+		}
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
+
+// inRequestGoroutine reports whether the calling goroutine is serving an
+// HTTP request, for contexts that carry no mark because they weren't
+// derived from the request's
+func inRequestGoroutine() bool {
+	pcs := make([]uintptr, 256)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, serveFunc) {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
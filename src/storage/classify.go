@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"coffee-and-running/src/apperr"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Classify maps a driver error to an apperr kind, keeping the original in
+// the chain so errors.As(err, &pqErr) still works. Errors that are already
+// classified, and ones no kind fits, are returned unchanged. The engine
+// classifies everything it returns; call it yourself on errors from
+// sql.Row.Scan.
+func Classify(err error) error {
+	if err == nil || apperr.KindOf(err) != "" {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		kind, message := classifyCode(pqErr.Code)
+		if kind == "" {
+			return err
+		}
+		e := &apperr.Error{Kind: kind, Op: "storage", Message: message, Err: err}
+		e = e.With("code", string(pqErr.Code))
+		for key, value := range map[string]string{
+			"constraint": pqErr.Constraint,
+			"table":      pqErr.Table,
+			"column":     pqErr.Column,
+		} {
+			if value != "" {
+				e = e.With(key, value)
+			}
+		}
+		return e
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return apperr.Wrap(err, apperr.NotFound, "storage", "row not found")
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, ErrNoPrimary):
+		return apperr.Wrap(err, apperr.Unavailable, "storage", "database connection lost")
+	case errors.Is(err, context.DeadlineExceeded):
+		return apperr.Wrap(err, apperr.Unavailable, "storage", "database call timed out")
+	}
+	return err
+}
+
+// classifyCode maps a SQLSTATE to a kind and client-safe message
+func classifyCode(code pq.ErrorCode) (apperr.Kind, string) {
+	switch code {
+	case "23505": // unique_violation
+		return apperr.Conflict, "already exists"
+	case "23503": // foreign_key_violation
+		return apperr.Conflict, "conflicts with related data"
+	case "23P01": // exclusion_violation
+		return apperr.Conflict, "conflicts with existing data"
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return apperr.Conflict, "conflicted with a concurrent change, retry"
+	case "23502", "23514": // not_null_violation, check_violation
+		return apperr.Invalid, "violates a data constraint"
+	case "57014", "55P03": // query_canceled, lock_not_available
+		return apperr.Unavailable, "database is busy"
+	case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+		return apperr.Unavailable, "database is unavailable"
+	case "25006": // read_only_sql_transaction, from a demoted primary
+		return apperr.Unavailable, "database is unavailable"
+	}
+	switch code.Class() {
+	case "22": // data_exception
+		return apperr.Invalid, "invalid value"
+	case "08", "53": // connection_exception, insufficient_resources
+		return apperr.Unavailable, "database is unavailable"
+	}
+	return "", ""
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// authRetryInterval is how soon after reading the credentials source an
+// authentication failure may read it again, so a pool of failing
+// connections doesn't hammer it
+const authRetryInterval = 5 * time.Second
+
+// ErrStaticCredentials is returned when rotating the credentials of an
+// engine that takes them from database.user and database.password
+var ErrStaticCredentials = errors.New("storage: database.credentials.source is not set")
+
+// Credentials are a database user and password
+type Credentials struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// CredentialSource supplies the current database credentials
+type CredentialSource interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// FileCredentials reads credentials from a YAML or JSON file on every call,
+// so a rotated file is picked up. "username" is accepted for "user", as
+// written by Vault's database secrets engine.
+type FileCredentials struct {
+	Path string
+}
+
+// Credentials implements CredentialSource
+func (f FileCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var file struct {
+		Credentials `yaml:",inline"`
+		Username    string `yaml:"username"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse %s: %w", f.Path, err)
+	}
+	if file.User == "" {
+		file.User = file.Username
+	}
+	if file.User == "" || file.Password == "" {
+		return Credentials{}, fmt.Errorf("%s must set user and password", f.Path)
+	}
+	return file.Credentials, nil
+}
+
+// NewCredentialSource returns the source of credentials for
+// database.auth_method and database.credentials, or nil when they are
+// database.user and database.password
+func NewCredentialSource(ctx context.Context, cfg *config.DatabaseConfig) (CredentialSource, error) {
+	switch cfg.AuthMethod {
+	case "", "password":
+	case "aws_iam":
+		region := ""
+		if cfg.IAM != nil {
+			region = cfg.IAM.Region
+		}
+		return NewRDSIAMTokens(ctx, cfg.Host, cfg.Port, cfg.User, region)
+	case "gcp_iam":
+		credentialsFile := ""
+		if cfg.IAM != nil {
+			credentialsFile = cfg.IAM.CredentialsFile
+		}
+		return NewCloudSQLIAMTokens(ctx, cfg.User, credentialsFile)
+	default:
+		return nil, fmt.Errorf("unknown database auth method %q", cfg.AuthMethod)
+	}
+
+	if cfg.Credentials == nil {
+		return nil, nil
+	}
+	switch cfg.Credentials.Source {
+	case "":
+		return nil, nil
+	case "file":
+		return FileCredentials{Path: cfg.Credentials.File}, nil
+	}
+	return nil, fmt.Errorf("unknown database credentials source %q", cfg.Credentials.Source)
+}
+
+// RotateCredentialsHandler serves POST /admin/database/rotate-credentials,
+// for a secrets manager to call after rotating the credentials. It
+// responds with {"rotated": bool}, false when they were unchanged.
+func RotateCredentialsHandler(rotator CredentialRotator, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rotated, err := rotator.RotateCredentials(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			logger.Error("failed to rotate database credentials", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "failed to rotate credentials"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"rotated": rotated})
+	}
+}
+
+// credentialsConnector connects with credentials from a source. When they
+// change, it builds a new connector for them and starts a new generation,
+// so connections made with the old credentials finish what they are doing
+// and then close instead of returning to the pool. Short-lived tokens are
+// read for every connection instead, and don't start a generation.
+type credentialsConnector struct {
+	config     *config.DatabaseConfig
+	source     CredentialSource
+	shortLived bool
+	build      func(cfg *config.DatabaseConfig) (driver.Connector, error)
+	driver     driver.Driver
+	logger     *zap.Logger
+	stats      metrics.Agent
+
+	generation atomic.Uint64
+
+	mu        sync.Mutex
+	connector driver.Connector
+	creds     Credentials
+	fetched   time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newCredentialsConnector reads the initial credentials and, with a refresh
+// interval, keeps checking the source until close
+func newCredentialsConnector(d driver.Driver, cfg *config.DatabaseConfig, source CredentialSource, build func(*config.DatabaseConfig) (driver.Connector, error), logger *zap.Logger, stats metrics.Agent) (*credentialsConnector, error) {
+	_, tokens := source.(shortLived)
+	c := &credentialsConnector{
+		config:     cfg,
+		source:     source,
+		shortLived: tokens,
+		build:      build,
+		driver:     d,
+		logger:     logger.With(zap.String("component", "db_credentials")),
+		stats:      stats,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+	if _, err := c.rotate(ctx); err != nil {
+		return nil, err
+	}
+
+	if cfg.Credentials != nil && cfg.Credentials.RefreshInterval > 0 && !tokens {
+		interval := cfg.Credentials.RefreshInterval
+		ctx, c.cancel = context.WithCancel(context.Background())
+		c.wg.Add(1)
+		go c.refresh(ctx, interval)
+	}
+	return c, nil
+}
+
+func (c *credentialsConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Connect connects with the current credentials. When the database
+// rejects them, the source is read again in case they were rotated, and
+// the connection retried once with the new ones.
+func (c *credentialsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if c.shortLived {
+		if _, err := c.rotate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	connector, generation := c.connector, c.generation.Load()
+	c.mu.Unlock()
+
+	conn, err := connector.Connect(ctx)
+	if err != nil && isAuthError(err) {
+		rotated, rerr := c.retry(ctx, generation)
+		if rerr != nil {
+			c.logger.Error("failed to read database credentials", zap.Error(rerr))
+		}
+		if !rotated {
+			return nil, err
+		}
+		c.mu.Lock()
+		connector, generation = c.connector, c.generation.Load()
+		c.mu.Unlock()
+		conn, err = connector.Connect(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &generationConn{Conn: conn, owner: c, generation: generation}, nil
+}
+
+// retry reads the source after connections of generation were rejected,
+// unless another connection already rotated or read it moments ago
+func (c *credentialsConnector) retry(ctx context.Context, generation uint64) (bool, error) {
+	c.mu.Lock()
+	rotated := c.generation.Load() != generation
+	recent := time.Since(c.fetched) < authRetryInterval
+	c.mu.Unlock()
+	if rotated {
+		return true, nil
+	}
+	if recent {
+		return false, nil
+	}
+	c.stats.Increment("db.credentials.rejected")
+	return c.rotate(ctx)
+}
+
+// rotate reads the source and, when the credentials have changed, switches
+// to them
+func (c *credentialsConnector) rotate(ctx context.Context) (bool, error) {
+	creds, err := c.source.Credentials(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = time.Now()
+	if err != nil {
+		c.stats.Increment("db.credentials.error")
+		return false, fmt.Errorf("failed to read database credentials: %w", err)
+	}
+	if c.connector != nil && creds == c.creds {
+		return false, nil
+	}
+
+	cfg := *c.config
+	cfg.User, cfg.Password = creds.User, creds.Password
+	connector, err := c.build(&cfg)
+	if err != nil {
+		return false, err
+	}
+
+	first := c.connector == nil
+	c.connector, c.creds = connector, creds
+	if first || c.shortLived {
+		return true, nil
+	}
+	c.generation.Add(1)
+	c.stats.Increment("db.credentials.rotated")
+	c.logger.Info("database credentials rotated, rebuilding the connection pool", zap.String("user", creds.User))
+	return true, nil
+}
+
+// refresh checks the source every interval until ctx is done
+func (c *credentialsConnector) refresh(ctx context.Context, interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rotateCtx, cancel := context.WithTimeout(ctx, c.config.ConnectTimeout)
+			if _, err := c.rotate(rotateCtx); err != nil && ctx.Err() == nil {
+				c.logger.Error("failed to refresh database credentials", zap.Error(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// close stops refreshing
+func (c *credentialsConnector) close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+func (c *credentialsConnector) current() uint64 {
+	return c.generation.Load()
+}
+
+func (c *credentialsConnector) observe(uint64, error) {}
+
+// isAuthError reports whether err means the database rejected the
+// credentials
+func isAuthError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "28000", // invalid_authorization_specification
+		"28P01": // invalid_password
+		return true
+	}
+	return false
+}
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"coffee-and-running/src/config"
+	"coffee-and-running/src/deadline"
 	"coffee-and-running/src/observability/metrics"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"time"
 
@@ -25,46 +28,56 @@ type Engine interface {
 
 // Engine is the app's storage engine wrapped with a logger and metrics
 type engine struct {
-	logger *zap.Logger
-	db     *sql.DB
-	stats  metrics.Agent
+	logger  *zap.Logger
+	db      *sql.DB
+	stats   metrics.Agent
+	handles *handleTracker
+	audit   *contextAuditor
+	slow    *slowQueryLog
+	// partitions are the pools database.partitions reserves; db is then
+	// the interactive partition's
+	partitions map[string]*sql.DB
+	// credentials is set when database.credentials.source is
+	credentials *credentialsConnector
+	minBudget   time.Duration
 }
 
-// NewEngineWithComponent creates a new instrumented database engine with custom component name
-func NewEngine(cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Agent) (Engine, error) {
+// Option customizes how an engine opens its connections
+type Option func(*options)
 
-	// Get the DSN from the config
-	dsn := cfg.GetDSN()
-	if dsn == "" {
-		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
-	}
-	db, err := sql.Open(cfg.Driver, dsn)
+type options struct {
+	connector func(driver.Connector) driver.Connector
+	app       *config.AppConfig
+}
+
+// WithConnector makes the engine open connections through wrap's result,
+// e.g. to inject faults below the engine's logging and metrics
+func WithConnector(wrap func(driver.Connector) driver.Connector) Option {
+	return func(o *options) { o.connector = wrap }
+}
+
+// WithApp tells the engine which application it serves, so it refuses
+// development aids such as database.audit_contexts in production
+func WithApp(app *config.AppConfig) Option {
+	return func(o *options) { o.app = app }
+}
+
+// NewEngine creates a new instrumented database engine and verifies the
+// database is reachable
+func NewEngine(cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Agent, opts ...Option) (Engine, error) {
+	e, err := open(cfg, logger, stats, opts)
 	if err != nil {
-		logger.Error("failed to open database connection",
-			zap.Error(err),
-			zap.String("driver", cfg.Driver))
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-	// Configure connection pool settings
-	if cfg.MaxOpenConns > 0 {
-		db.SetMaxOpenConns(cfg.MaxOpenConns)
-	}
-	if cfg.MaxIdleConns > 0 {
-		db.SetMaxIdleConns(cfg.MaxIdleConns)
-	}
-	if cfg.ConnMaxLifetime > 0 {
-		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
-	}
-	if cfg.ConnMaxIdleTime > 0 {
-		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+		return nil, err
 	}
 
 	// Test the connection
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
 	defer cancel()
 
-	if err := db.PingContext(ctx); err != nil {
-		db.Close()
+	if err := e.db.PingContext(ctx); err != nil {
+		for _, db := range e.pools() {
+			db.Close()
+		}
 		logger.Error("failed to ping database",
 			zap.Error(err),
 			zap.String("driver", cfg.Driver))
@@ -77,59 +90,214 @@ func NewEngine(cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Age
 		zap.Int("port", cfg.Port),
 		zap.String("database", cfg.Name))
 
+	return e, nil
+}
+
+// OpenEngine creates the engine without connecting. Connections are made
+// on first use, so the caller should wait for Ping to succeed before
+// serving traffic.
+func OpenEngine(cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Agent, opts ...Option) (Engine, error) {
+	return open(cfg, logger, stats, opts)
+}
+
+// NewEngineFromDB instruments an already open database, such as one using
+// a test driver
+func NewEngineFromDB(db *sql.DB, logger *zap.Logger, stats metrics.Agent) Engine {
 	return &engine{
 		logger: logger,
 		db:     db,
 		stats:  stats,
-	}, nil
+	}
+}
+
+func open(cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Agent, opts []Option) (*engine, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if cfg.AuditContexts && o.app != nil && o.app.IsProduction() {
+		return nil, ErrAuditInProduction
+	}
+
+	// Get the DSN from the config
+	dsn := cfg.GetDSN()
+	if dsn == "" {
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+	}
+	db, err := sql.Open(cfg.Driver, dsn)
+	if err != nil {
+		err = redact(err)
+		logger.Error("failed to open database connection",
+			zap.Error(err),
+			zap.String("driver", cfg.Driver))
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// sql.Open doesn't connect, so the driver it found is reused for a
+	// connector that redacts the DSN from connection errors
+	d := db.Driver()
+	db.Close()
+
+	failover := cfg.Failover != nil && cfg.Failover.Enabled
+	sourceCtx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	source, err := NewCredentialSource(sourceCtx, cfg)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	build := func(cfg *config.DatabaseConfig) (driver.Connector, error) {
+		if failover {
+			return newFailoverConnector(d, cfg, logger, stats)
+		}
+		return newConnector(d, cfg.GetDSN())
+	}
+	var credentials *credentialsConnector
+	var connector driver.Connector
+	if source != nil {
+		credentials, err = newCredentialsConnector(d, cfg, source, build, logger, stats)
+		connector = credentials
+	} else {
+		connector, err = build(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if o.connector != nil {
+		connector = o.connector(connector)
+	}
+	db, partitions, err := openPartitions(connector, cfg)
+	if err != nil {
+		if credentials != nil {
+			credentials.close()
+		}
+		return nil, err
+	}
+
+	e := &engine{
+		logger:      logger,
+		db:          db,
+		partitions:  partitions,
+		stats:       stats,
+		handles:     newHandleTracker(cfg.LeakDetection, logger, stats),
+		audit:       newContextAuditor(cfg.AuditContexts, logger, stats),
+		credentials: credentials,
+		minBudget:   cfg.MinQueryBudget,
+	}
+	e.slow = newSlowQueryLog(cfg, newExplainer(cfg.Explain, e.pool, stats), stats)
+	return e, nil
+}
+
+// newConnector returns a connector for dsn, for drivers that predate
+// driver.DriverContext too. Its errors have the DSN's password redacted.
+func newConnector(d driver.Driver, dsn string) (driver.Connector, error) {
+	if dc, ok := d.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, redact(err)
+		}
+		return redactingConnector{Connector: connector}, nil
+	}
+	return dsnConnector{driver: d, dsn: dsn}, nil
+}
+
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	return conn, redact(err)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type redactingConnector struct {
+	driver.Connector
+}
+
+func (c redactingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	return conn, redact(err)
+}
+
+// redactedError masks connection string passwords in an error's message,
+// e.g. a driver quoting a DSN it can't parse, and keeps the original in
+// the chain for errors.As
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+func redact(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := config.RedactDSN(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, message: message}
 }
 
 // Query executes a query with logging and metrics
 func (e *engine) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	e.audit.check(ctx, "query")
+	ctx, cancel := e.budget(ctx, "query")
+	defer cancel()
+	logger := newCallLogger(ctx, e.logger).withQuery(query)
 	start := time.Now()
 
-	e.logger.Debug("executing query",
-		zap.String("query", query),
-		zap.Any("args", args),
-	)
+	logger.query("executing query", args)
 
-	rows, err := e.db.QueryContext(ctx, query, args...)
+	rows, err := e.pool(ctx).QueryContext(ctx, query, args...)
 	duration := time.Since(start)
+	e.slow.observe(logger, args, duration)
 
 	// Log the result
 	if err != nil {
-		e.logger.Error("query failed",
-			zap.String("query", query),
+		class := callErrorClass(ctx, err)
+		logger.failure("query failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		e.stats.Increment("db.query.error")
+		e.stats.Increment("db.query.error." + class)
 	} else {
-		e.logger.Debug("query completed",
-			zap.String("query", query),
+		logger.debug("query completed",
 			zap.Duration("duration", duration),
 		)
 		e.stats.Increment("db.query.success")
 	}
 
 	e.stats.Timing("db.query.duration", duration)
-	return rows, err
+	return rows, Classify(err)
 }
 
 // QueryRow executes a single row query with logging and metrics
 func (e *engine) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	e.audit.check(ctx, "query_row")
+	ctx, cancel := e.budget(ctx, "query_row")
+	defer cancel()
+	logger := newCallLogger(ctx, e.logger).withQuery(query)
 	start := time.Now()
 
-	e.logger.Debug("executing query row",
-		zap.String("query", query),
-		zap.Any("args", args),
-	)
+	logger.query("executing query row", args)
 
-	row := e.db.QueryRowContext(ctx, query, args...)
+	row := e.pool(ctx).QueryRowContext(ctx, query, args...)
 	duration := time.Since(start)
+	e.slow.observe(logger, args, duration)
 
-	e.logger.Debug("query row completed",
-		zap.String("query", query),
+	logger.debug("query row completed",
 		zap.Duration("duration", duration),
 	)
 
@@ -141,27 +309,29 @@ func (e *engine) QueryRow(ctx context.Context, query string, args ...interface{}
 
 // Exec executes a statement with logging and metrics
 func (e *engine) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.audit.check(ctx, "exec")
+	ctx, cancel := e.budget(ctx, "exec")
+	defer cancel()
+	logger := newCallLogger(ctx, e.logger).withQuery(query)
 	start := time.Now()
 
-	e.logger.Debug("executing statement",
-		zap.String("query", query),
-		zap.Any("args", args),
-	)
+	logger.query("executing statement", args)
 
-	result, err := e.db.ExecContext(ctx, query, args...)
+	result, err := e.pool(ctx).ExecContext(ctx, query, args...)
 	duration := time.Since(start)
+	e.slow.observe(logger, args, duration)
 
 	if err != nil {
-		e.logger.Error("statement execution failed",
-			zap.String("query", query),
+		class := callErrorClass(ctx, err)
+		logger.failure("statement execution failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		e.stats.Increment("db.exec.error")
+		e.stats.Increment("db.exec.error." + class)
 	} else {
 		rowsAffected, _ := result.RowsAffected()
-		e.logger.Debug("statement completed",
-			zap.String("query", query),
+		logger.debug("statement completed",
 			zap.Duration("duration", duration),
 			zap.Int64("rows_affected", rowsAffected),
 		)
@@ -170,108 +340,158 @@ func (e *engine) Exec(ctx context.Context, query string, args ...interface{}) (s
 	}
 
 	e.stats.Timing("db.exec.duration", duration)
-	return result, err
+	return result, Classify(err)
 }
 
-// Begin starts a transaction with logging and metrics
+// Begin starts a transaction with logging and metrics, setting the
+// session variables of ctx (see WithSessionVariable) in it
 func (e *engine) Begin(ctx context.Context) (*InstrumentedTx, error) {
+	e.audit.check(ctx, "begin")
+	ctx, cancel := e.budget(ctx, "begin")
+	defer cancel()
+	logger := newCallLogger(ctx, e.logger)
 	start := time.Now()
 
-	e.logger.Debug("beginning transaction")
+	logger.debug("beginning transaction")
 
-	tx, err := e.db.BeginTx(ctx, nil)
+	tx, err := e.pool(ctx).BeginTx(ctx, nil)
 	duration := time.Since(start)
 
 	if err != nil {
-		e.logger.Error("failed to begin transaction",
+		class := callErrorClass(ctx, err)
+		logger.failure("failed to begin transaction", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		e.stats.Increment("db.transaction.begin.error")
-		return nil, err
+		e.stats.Increment("db.transaction.begin.error." + class)
+		return nil, Classify(err)
 	}
 
-	e.logger.Debug("transaction began",
+	logger.debug("transaction began",
 		zap.Duration("duration", duration),
 	)
 	e.stats.Increment("db.transaction.begin.success")
 	e.stats.Timing("db.transaction.begin.duration", duration)
 
-	return &InstrumentedTx{
-		tx:     tx,
-		logger: e.logger,
-		stats:  e.stats,
-		start:  start,
-	}, nil
+	itx := &InstrumentedTx{
+		tx:      tx,
+		logger:  logger,
+		stats:   e.stats,
+		start:   start,
+		handles: e.handles,
+		audit:   e.audit,
+		slow:    e.slow,
+		handle:  e.handles.open(handleTransaction, logger),
+	}
+	if err := setSessionVariables(ctx, itx); err != nil {
+		itx.Rollback()
+		e.stats.Increment("db.transaction.session_variables.error")
+		return nil, fmt.Errorf("failed to set session variables: %w", err)
+	}
+	return itx, nil
 }
 
 // Prepare creates a prepared statement with logging and metrics
 func (e *engine) Prepare(ctx context.Context, query string) (*InstrumentedStmt, error) {
+	e.audit.check(ctx, "prepare")
+	ctx, cancel := e.budget(ctx, "prepare")
+	defer cancel()
+	logger := newCallLogger(ctx, e.logger).withQuery(query)
 	start := time.Now()
 
-	e.logger.Debug("preparing statement",
-		zap.String("query", query),
-	)
+	logger.debug("preparing statement")
 
-	stmt, err := e.db.PrepareContext(ctx, query)
+	stmt, err := e.pool(ctx).PrepareContext(ctx, query)
 	duration := time.Since(start)
 
 	if err != nil {
-		e.logger.Error("failed to prepare statement",
-			zap.String("query", query),
+		class := callErrorClass(ctx, err)
+		logger.failure("failed to prepare statement", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		e.stats.Increment("db.prepare.error")
-		return nil, err
+		e.stats.Increment("db.prepare.error." + class)
+		return nil, Classify(err)
 	}
 
-	e.logger.Debug("statement prepared",
-		zap.String("query", query),
+	logger.debug("statement prepared",
 		zap.Duration("duration", duration),
 	)
 	e.stats.Increment("db.prepare.success")
 	e.stats.Timing("db.prepare.duration", duration)
 
 	return &InstrumentedStmt{
-		stmt:   stmt,
-		query:  query,
-		logger: e.logger,
-		stats:  e.stats,
+		stmt:    stmt,
+		query:   query,
+		logger:  callLogger{logger: e.logger}.withQuery(query),
+		stats:   e.stats,
+		handles: e.handles,
+		audit:   e.audit,
+		slow:    e.slow,
+		handle:  e.handles.open(handleStatement, logger),
 	}, nil
 }
 
+// budget returns ctx for a call, or, when its deadline leaves less than
+// database.min_query_budget, a context already past it, so the call fails
+// at once with context.DeadlineExceeded instead of borrowing a connection
+// for a query that can't finish. Cancelling the result never cancels ctx,
+// so rows read after the call returns are unaffected.
+func (e *engine) budget(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	remaining, ok := deadline.Remaining(ctx)
+	if !ok || remaining >= e.minBudget && remaining > 0 {
+		return ctx, func() {}
+	}
+	e.stats.Increment("db." + op + ".budget_exhausted")
+	return context.WithDeadline(ctx, time.Now())
+}
+
 // Ping tests the database connection with logging and metrics
 func (e *engine) Ping(ctx context.Context) error {
+	e.audit.check(ctx, "ping")
+	logger := newCallLogger(ctx, e.logger)
 	start := time.Now()
 
-	e.logger.Debug("pinging database")
+	logger.debug("pinging database")
 
-	err := e.db.PingContext(ctx)
+	err := e.pool(ctx).PingContext(ctx)
 	duration := time.Since(start)
 
 	if err != nil {
-		e.logger.Error("database ping failed",
+		class := callErrorClass(ctx, err)
+		logger.failure("database ping failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		e.stats.Increment("db.ping.error")
+		e.stats.Increment("db.ping.error." + class)
 	} else {
-		e.logger.Debug("database ping successful",
+		logger.debug("database ping successful",
 			zap.Duration("duration", duration),
 		)
 		e.stats.Increment("db.ping.success")
 	}
 
 	e.stats.Timing("db.ping.duration", duration)
-	return err
+	return Classify(err)
 }
 
 // Close closes the database connection with logging
 func (e *engine) Close() error {
 	e.logger.Info("closing database connection")
+	e.handles.close()
+	e.slow.close()
+	if e.credentials != nil {
+		e.credentials.close()
+	}
 
-	err := e.db.Close()
+	var errs []error
+	for _, db := range e.pools() {
+		errs = append(errs, db.Close())
+	}
+	err := errors.Join(errs...)
 	if err != nil {
 		e.logger.Error("failed to close database connection", zap.Error(err))
 		e.stats.Increment("db.close.error")
@@ -283,9 +503,40 @@ func (e *engine) Close() error {
 	return err
 }
 
-// Stats returns database statistics with logging
+// CredentialRotator is implemented by engines, for rotating credentials
+// from database.credentials on demand
+type CredentialRotator interface {
+	// RotateCredentials reads the credentials source and, when they have
+	// changed, rebuilds the pool with them. It reports whether they had.
+	RotateCredentials(ctx context.Context) (bool, error)
+}
+
+// RotateCredentials implements CredentialRotator
+func (e *engine) RotateCredentials(ctx context.Context) (bool, error) {
+	if e.credentials == nil {
+		return false, ErrStaticCredentials
+	}
+	return e.credentials.rotate(ctx)
+}
+
+// Stats returns database statistics with logging, summed over the
+// partitions when there are several
 func (e *engine) Stats() sql.DBStats {
 	stats := e.db.Stats()
+	if len(e.partitions) > 0 {
+		stats = sql.DBStats{}
+		for _, s := range e.PartitionStats() {
+			stats.MaxOpenConnections += s.MaxOpenConnections
+			stats.OpenConnections += s.OpenConnections
+			stats.InUse += s.InUse
+			stats.Idle += s.Idle
+			stats.WaitCount += s.WaitCount
+			stats.WaitDuration += s.WaitDuration
+			stats.MaxIdleClosed += s.MaxIdleClosed
+			stats.MaxIdleTimeClosed += s.MaxIdleTimeClosed
+			stats.MaxLifetimeClosed += s.MaxLifetimeClosed
+		}
+	}
 
 	e.logger.Debug("database stats",
 		zap.Int("open_connections", stats.OpenConnections),
@@ -310,114 +561,126 @@ func (e *engine) Stats() sql.DBStats {
 
 // InstrumentedTx wraps sql.Tx with logging and metrics
 type InstrumentedTx struct {
-	tx     *sql.Tx
-	logger *zap.Logger
-	stats  metrics.Agent
-	start  time.Time
+	tx      *sql.Tx
+	logger  callLogger
+	stats   metrics.Agent
+	start   time.Time
+	handles *handleTracker
+	handle  *handle
+	audit   *contextAuditor
+	slow    *slowQueryLog
+	// savepoints numbers the savepoints of Nested calls
+	savepoints int
 }
 
 // Commit commits the transaction with logging and metrics
 func (tx *InstrumentedTx) Commit() error {
 	duration := time.Since(tx.start)
 
-	tx.logger.Debug("committing transaction")
+	tx.logger.debug("committing transaction")
 
 	err := tx.tx.Commit()
+	tx.handles.release(tx.handle)
 	if err != nil {
-		tx.logger.Error("transaction commit failed",
+		class := errorClass(err)
+		tx.logger.failure("transaction commit failed", class,
 			zap.Duration("total_duration", duration),
 			zap.Error(err),
 		)
 		tx.stats.Increment("db.transaction.commit.error")
+		tx.stats.Increment("db.transaction.commit.error." + class)
 	} else {
-		tx.logger.Debug("transaction committed successfully",
+		tx.logger.debug("transaction committed successfully",
 			zap.Duration("total_duration", duration),
 		)
 		tx.stats.Increment("db.transaction.commit.success")
 	}
 
 	tx.stats.Timing("db.transaction.total_duration", duration)
-	return err
+	return Classify(err)
 }
 
 // Rollback rolls back the transaction with logging and metrics
 func (tx *InstrumentedTx) Rollback() error {
 	duration := time.Since(tx.start)
 
-	tx.logger.Debug("rolling back transaction")
+	tx.logger.debug("rolling back transaction")
 
 	err := tx.tx.Rollback()
+	tx.handles.release(tx.handle)
 	if err != nil {
-		tx.logger.Error("transaction rollback failed",
+		class := errorClass(err)
+		tx.logger.failure("transaction rollback failed", class,
 			zap.Duration("total_duration", duration),
 			zap.Error(err),
 		)
 		tx.stats.Increment("db.transaction.rollback.error")
+		tx.stats.Increment("db.transaction.rollback.error." + class)
 	} else {
-		tx.logger.Debug("transaction rolled back successfully",
+		tx.logger.debug("transaction rolled back successfully",
 			zap.Duration("total_duration", duration),
 		)
 		tx.stats.Increment("db.transaction.rollback.success")
 	}
 
 	tx.stats.Timing("db.transaction.total_duration", duration)
-	return err
+	return Classify(err)
 }
 
 // Query executes a query within the transaction
 func (tx *InstrumentedTx) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	tx.audit.check(ctx, "transaction.query")
+	logger := tx.logger.withContext(ctx).withQuery(query)
 	start := time.Now()
 
-	tx.logger.Debug("executing query in transaction",
-		zap.String("query", query),
-		zap.Any("args", args),
-	)
+	logger.query("executing query in transaction", args)
 
 	rows, err := tx.tx.QueryContext(ctx, query, args...)
 	duration := time.Since(start)
+	tx.slow.observe(logger, args, duration)
 
 	if err != nil {
-		tx.logger.Error("transaction query failed",
-			zap.String("query", query),
+		class := callErrorClass(ctx, err)
+		logger.failure("transaction query failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		tx.stats.Increment("db.transaction.query.error")
+		tx.stats.Increment("db.transaction.query.error." + class)
 	} else {
-		tx.logger.Debug("transaction query completed",
-			zap.String("query", query),
+		logger.debug("transaction query completed",
 			zap.Duration("duration", duration),
 		)
 		tx.stats.Increment("db.transaction.query.success")
 	}
 
 	tx.stats.Timing("db.transaction.query.duration", duration)
-	return rows, err
+	return rows, Classify(err)
 }
 
 // Exec executes a statement within the transaction
 func (tx *InstrumentedTx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	tx.audit.check(ctx, "transaction.exec")
+	logger := tx.logger.withContext(ctx).withQuery(query)
 	start := time.Now()
 
-	tx.logger.Debug("executing statement in transaction",
-		zap.String("query", query),
-		zap.Any("args", args),
-	)
+	logger.query("executing statement in transaction", args)
 
 	result, err := tx.tx.ExecContext(ctx, query, args...)
 	duration := time.Since(start)
+	tx.slow.observe(logger, args, duration)
 
 	if err != nil {
-		tx.logger.Error("transaction statement execution failed",
-			zap.String("query", query),
+		class := callErrorClass(ctx, err)
+		logger.failure("transaction statement execution failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		tx.stats.Increment("db.transaction.exec.error")
+		tx.stats.Increment("db.transaction.exec.error." + class)
 	} else {
 		rowsAffected, _ := result.RowsAffected()
-		tx.logger.Debug("transaction statement completed",
-			zap.String("query", query),
+		logger.debug("transaction statement completed",
 			zap.Duration("duration", duration),
 			zap.Int64("rows_affected", rowsAffected),
 		)
@@ -426,71 +689,75 @@ func (tx *InstrumentedTx) Exec(ctx context.Context, query string, args ...interf
 	}
 
 	tx.stats.Timing("db.transaction.exec.duration", duration)
-	return result, err
+	return result, Classify(err)
 }
 
 // InstrumentedStmt wraps sql.Stmt with logging and metrics
 type InstrumentedStmt struct {
-	stmt   *sql.Stmt
-	query  string
-	logger *zap.Logger
-	stats  metrics.Agent
+	stmt    *sql.Stmt
+	query   string
+	logger  callLogger
+	stats   metrics.Agent
+	handles *handleTracker
+	handle  *handle
+	audit   *contextAuditor
+	slow    *slowQueryLog
 }
 
 // Query executes the prepared statement query
 func (s *InstrumentedStmt) Query(ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	s.audit.check(ctx, "prepared.query")
+	logger := s.logger.withContext(ctx)
 	start := time.Now()
 
-	s.logger.Debug("executing prepared statement query",
-		zap.String("query", s.query),
-		zap.Any("args", args),
-	)
+	logger.query("executing prepared statement query", args)
 
 	rows, err := s.stmt.QueryContext(ctx, args...)
 	duration := time.Since(start)
+	s.slow.observe(logger, args, duration)
 
 	if err != nil {
-		s.logger.Error("prepared statement query failed",
-			zap.String("query", s.query),
+		class := callErrorClass(ctx, err)
+		logger.failure("prepared statement query failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		s.stats.Increment("db.prepared.query.error")
+		s.stats.Increment("db.prepared.query.error." + class)
 	} else {
-		s.logger.Debug("prepared statement query completed",
-			zap.String("query", s.query),
+		logger.debug("prepared statement query completed",
 			zap.Duration("duration", duration),
 		)
 		s.stats.Increment("db.prepared.query.success")
 	}
 
 	s.stats.Timing("db.prepared.query.duration", duration)
-	return rows, err
+	return rows, Classify(err)
 }
 
 // Exec executes the prepared statement
 func (s *InstrumentedStmt) Exec(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	s.audit.check(ctx, "prepared.exec")
+	logger := s.logger.withContext(ctx)
 	start := time.Now()
 
-	s.logger.Debug("executing prepared statement",
-		zap.String("query", s.query),
-		zap.Any("args", args),
-	)
+	logger.query("executing prepared statement", args)
 
 	result, err := s.stmt.ExecContext(ctx, args...)
 	duration := time.Since(start)
+	s.slow.observe(logger, args, duration)
 
 	if err != nil {
-		s.logger.Error("prepared statement execution failed",
-			zap.String("query", s.query),
+		class := callErrorClass(ctx, err)
+		logger.failure("prepared statement execution failed", class,
 			zap.Duration("duration", duration),
 			zap.Error(err),
 		)
 		s.stats.Increment("db.prepared.exec.error")
+		s.stats.Increment("db.prepared.exec.error." + class)
 	} else {
 		rowsAffected, _ := result.RowsAffected()
-		s.logger.Debug("prepared statement completed",
-			zap.String("query", s.query),
+		logger.debug("prepared statement completed",
 			zap.Duration("duration", duration),
 			zap.Int64("rows_affected", rowsAffected),
 		)
@@ -499,26 +766,26 @@ func (s *InstrumentedStmt) Exec(ctx context.Context, args ...interface{}) (sql.R
 	}
 
 	s.stats.Timing("db.prepared.exec.duration", duration)
-	return result, err
+	return result, Classify(err)
 }
 
 // Close closes the prepared statement
 func (s *InstrumentedStmt) Close() error {
-	s.logger.Debug("closing prepared statement", zap.String("query", s.query))
+	s.logger.debug("closing prepared statement")
 
 	err := s.stmt.Close()
+	s.handles.release(s.handle)
 	if err != nil {
-		s.logger.Error("failed to close prepared statement",
-			zap.String("query", s.query),
+		class := errorClass(err)
+		s.logger.failure("failed to close prepared statement", class,
 			zap.Error(err),
 		)
 		s.stats.Increment("db.prepared.close.error")
+		s.stats.Increment("db.prepared.close.error." + class)
 	} else {
-		s.logger.Debug("prepared statement closed successfully",
-			zap.String("query", s.query),
-		)
+		s.logger.debug("prepared statement closed successfully")
 		s.stats.Increment("db.prepared.close.success")
 	}
 
-	return err
+	return Classify(err)
 }
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		err  error // built from dsn when nil
+		want string
+	}{
+		{
+			name: "lib/pq url parse error",
+			dsn:  "postgres://app:s3cr%t@db/app",
+			want: `parse "postgres://app:***@db/app": invalid URL escape "%t"`,
+		},
+		{
+			name: "wrapped keyword/value dsn",
+			err:  fmt.Errorf("failed to connect to %q: %w", "host=db user=app password='s3c ret' dbname=app", errors.New("pq: SSL is not enabled on the server")),
+			want: `failed to connect to "host=db user=app password=*** dbname=app": pq: SSL is not enabled on the server`,
+		},
+		{
+			name: "nothing to redact",
+			err:  errors.New(`pq: password authentication failed for user "app"`),
+			want: `pq: password authentication failed for user "app"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			if err == nil {
+				_, err = pq.NewConnector(tt.dsn)
+				if err == nil {
+					t.Fatalf("pq.NewConnector(%q) succeeded", tt.dsn)
+				}
+			}
+			got := redact(err)
+			if got.Error() != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", err, got, tt.want)
+			}
+			if strings.Contains(got.Error(), "s3c") {
+				t.Errorf("redact(%q) = %q, leaking the password", err, got)
+			}
+			if !errors.Is(got, err) {
+				t.Errorf("redact(%q) dropped the original error from the chain", err)
+			}
+		})
+	}
+	if redact(nil) != nil {
+		t.Error("redact(nil) != nil")
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Classes of failed calls. Each failure is counted under its call's error
+// bucket and again under the bucket with the class appended, e.g.
+// db.query.error.timeout, and logged with an error_class field.
+const (
+	errorCanceled   = "canceled"   // the caller gave up, e.g. the client disconnected
+	errorTimeout    = "timeout"    // a deadline, statement_timeout or lock_timeout ran out
+	errorConstraint = "constraint" // the data broke a constraint
+	errorConnection = "connection" // the database couldn't be reached or went away
+	errorOther      = "other"
+)
+
+// callErrorClass classifies err from a call made with ctx. A done context
+// explains the failure whatever the driver reported, as drivers report
+// cancellation in their own ways.
+func callErrorClass(ctx context.Context, err error) string {
+	switch ctx.Err() {
+	case context.Canceled:
+		return errorCanceled
+	case context.DeadlineExceeded:
+		return errorTimeout
+	}
+	return errorClass(err)
+}
+
+// errorClass classifies err by what it carries alone
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorTimeout
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, ErrNoPrimary):
+		return errorConnection
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57014": // query_canceled, by statement_timeout or a cancel request
+			if strings.Contains(pqErr.Message, "timeout") {
+				return errorTimeout
+			}
+			return errorCanceled
+		case "55P03": // lock_not_available, e.g. lock_timeout
+			return errorTimeout
+		case "57P01", "57P02", "57P03", "25006": // shutdowns, and a demoted primary
+			return errorConnection
+		}
+		switch pqErr.Code.Class() {
+		case "23": // integrity_constraint_violation
+			return errorConstraint
+		case "08": // connection_exception
+			return errorConnection
+		}
+		return errorOther
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return errorConnection
+	}
+	return errorOther
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// ErrNoPrimary is returned when no failover host accepts writes
+var ErrNoPrimary = errors.New("storage: no writable primary among the failover hosts")
+
+// failoverConnector connects to whichever of a cluster's hosts is the
+// primary. Every new connection is checked with pg_is_in_recovery, so a
+// demoted primary that comes back as a replica is never used for writes.
+//
+// When a connection fails in a way that means the primary moved, the
+// connector starts a new generation: connections of older generations
+// report themselves invalid, so database/sql drops them instead of reusing
+// them, and the next connection discovers the primary again.
+type failoverConnector struct {
+	hosts   []failoverHost
+	timeout time.Duration
+	driver  driver.Driver
+	logger  *zap.Logger
+	stats   metrics.Agent
+
+	generation atomic.Uint64
+
+	mu      sync.Mutex
+	primary int // index into hosts, -1 while unknown
+}
+
+type failoverHost struct {
+	addr      string
+	connector driver.Connector
+}
+
+// newFailoverConnector builds a connector for each of cfg.Failover.Hosts
+func newFailoverConnector(d driver.Driver, cfg *config.DatabaseConfig, logger *zap.Logger, stats metrics.Agent) (*failoverConnector, error) {
+	c := &failoverConnector{
+		timeout: cfg.Failover.ProbeTimeout,
+		driver:  d,
+		logger:  logger.With(zap.String("component", "db_failover")),
+		stats:   stats,
+		primary: -1,
+	}
+	for _, entry := range cfg.Failover.Hosts {
+		host, port, err := config.SplitHostPort(entry, cfg.Port)
+		if err != nil {
+			return nil, err
+		}
+		hostCfg := *cfg
+		hostCfg.Host, hostCfg.Port = host, port
+		connector, err := newConnector(d, hostCfg.GetDSN())
+		if err != nil {
+			return nil, err
+		}
+		c.hosts = append(c.hosts, failoverHost{addr: fmt.Sprintf("%s:%d", host, port), connector: connector})
+	}
+	if len(c.hosts) == 0 {
+		return nil, ErrNoPrimary
+	}
+	return c, nil
+}
+
+func (c *failoverConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Connect tries the known primary, then every host in order of preference
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	generation := c.generation.Load()
+	c.mu.Lock()
+	known := c.primary
+	c.mu.Unlock()
+
+	order := make([]int, 0, len(c.hosts))
+	if known >= 0 {
+		order = append(order, known)
+	}
+	for i := range c.hosts {
+		if i != known {
+			order = append(order, i)
+		}
+	}
+
+	start := time.Now()
+	var errs []error
+	for _, i := range order {
+		conn, err := c.connectPrimary(ctx, c.hosts[i])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", c.hosts[i].addr, err))
+			continue
+		}
+		if i != known {
+			c.discovered(i, known, time.Since(start))
+			generation = c.generation.Load()
+		}
+		return &generationConn{Conn: conn, owner: c, generation: generation}, nil
+	}
+	c.stats.Increment("db.failover.discovery.error")
+	c.logger.Error("no writable primary found", zap.Error(errors.Join(errs...)))
+	return nil, fmt.Errorf("%w: %w", ErrNoPrimary, errors.Join(errs...))
+}
+
+// connectPrimary connects to host, failing when it is a replica
+func (c *failoverConnector) connectPrimary(ctx context.Context, host failoverHost) (driver.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := host.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := inRecovery(ctx, conn)
+	if err == nil && replica {
+		err = errors.New("host is a replica")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// discovered records hosts[i] as the primary
+func (c *failoverConnector) discovered(i, previous int, took time.Duration) {
+	c.mu.Lock()
+	changed := c.primary != i
+	c.primary = i
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	c.stats.Timing("db.failover.discovery.duration", took)
+	if previous < 0 {
+		c.logger.Info("database primary discovered", zap.String("primary", c.hosts[i].addr), zap.Duration("duration", took))
+		return
+	}
+	// The old primary failed to connect. Drop the pool's connections to it
+	// too, in case it is still up and taking reads.
+	c.generation.Add(1)
+	c.stats.Increment("db.failover.promoted")
+	c.logger.Warn("database primary changed",
+		zap.String("previous", c.hosts[previous].addr),
+		zap.String("primary", c.hosts[i].addr),
+		zap.Duration("duration", took),
+	)
+}
+
+// reset starts a new generation after a connection of generation failed
+// with err. Errors from older generations, such as the rest of the pool
+// failing after the same failover, don't reset again.
+func (c *failoverConnector) reset(generation uint64, err error) {
+	if !c.generation.CompareAndSwap(generation, generation+1) {
+		return
+	}
+	c.mu.Lock()
+	previous := c.primary
+	c.primary = -1
+	c.mu.Unlock()
+
+	addr := ""
+	if previous >= 0 {
+		addr = c.hosts[previous].addr
+	}
+	c.stats.Increment("db.failover.detected")
+	c.logger.Warn("database failover detected, resetting the connection pool",
+		zap.String("primary", addr),
+		zap.Error(err),
+	)
+}
+
+// inRecovery reports whether conn is to a replica. Connections that can't
+// run queries directly are assumed to be to the primary.
+func inRecovery(ctx context.Context, conn driver.Conn) (bool, error) {
+	q, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return false, nil
+	}
+	rows, err := q.QueryContext(ctx, "SELECT pg_is_in_recovery()", nil)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return false, err
+	}
+	replica, _ := dest[0].(bool)
+	return replica, nil
+}
+
+// isFailoverError reports whether err means the connection's server is no
+// longer a usable primary. Broken connections aren't: database/sql retries
+// them on a new connection, and Connect moves on if the primary is down.
+func isFailoverError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "25006", // read_only_sql_transaction
+		"57P01", // admin_shutdown
+		"57P02", // crash_shutdown
+		"57P03": // cannot_connect_now
+		return true
+	}
+	return false
+}
+
+func (c *failoverConnector) current() uint64 {
+	return c.generation.Load()
+}
+
+// observe resets the connector when err is a failover error
+func (c *failoverConnector) observe(generation uint64, err error) {
+	if isFailoverError(err) {
+		c.reset(generation, err)
+	}
+}
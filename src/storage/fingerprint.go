@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// fingerprintCacheSize bounds the normalized queries kept. Statements come
+// from code, so a service has few distinct ones; queries built with
+// literals inline are normalized on every call once the cache is full.
+const fingerprintCacheSize = 4096
+
+var (
+	// inList matches IN lists of placeholders once literals are replaced
+	inList = regexp.MustCompile(`(?i)\bIN \( ?(?:\?|\$\d+)(?: ?, ?(?:\?|\$\d+))* ?\)`)
+	// valuesRows matches the repeated rows of a multi-row VALUES list
+	valuesRows = regexp.MustCompile(`(\( ?(?:\?|\$\d+|DEFAULT|NULL)(?: ?, ?(?:\?|\$\d+|DEFAULT|NULL))* ?\))(?: ?, ?\( ?(?:\?|\$\d+|DEFAULT|NULL)(?: ?, ?(?:\?|\$\d+|DEFAULT|NULL))* ?\))+`)
+
+	fingerprints     sync.Map // query -> fingerprint
+	fingerprintCount int
+	fingerprintMu    sync.Mutex
+)
+
+// fingerprint is a query's normalized form and an ID for its shape
+type fingerprint struct {
+	normalized string
+	id         string
+}
+
+// NormalizeQuery reduces query to its shape, so statements that differ
+// only in their literals group together in logs and dashboards, and values
+// inlined into the SQL, which may be personal data, stay out of logs:
+//
+//	SELECT * FROM users WHERE email = 'ada@example.com' AND id IN (1, 2, 3)
+//	SELECT * FROM users WHERE email = ? AND id IN (...)
+//
+// String, numeric and dollar-quoted literals become ?, IN lists and
+// multi-row VALUES lists collapse, comments are dropped and whitespace is
+// squeezed. Placeholders such as $1 are kept.
+func NormalizeQuery(query string) string {
+	return fingerprintOf(query).normalized
+}
+
+// QueryFingerprint returns a short ID for the shape of query, the same for
+// every query NormalizeQuery reduces to the same form
+func QueryFingerprint(query string) string {
+	return fingerprintOf(query).id
+}
+
+func fingerprintOf(query string) fingerprint {
+	if f, ok := fingerprints.Load(query); ok {
+		return f.(fingerprint)
+	}
+
+	normalized := normalize(query)
+	h := fnv.New64a()
+	h.Write([]byte(normalized))
+	f := fingerprint{normalized: normalized, id: fmt.Sprintf("%016x", h.Sum64())}
+
+	fingerprintMu.Lock()
+	if fingerprintCount < fingerprintCacheSize {
+		if _, loaded := fingerprints.LoadOrStore(query, f); !loaded {
+			fingerprintCount++
+		}
+	}
+	fingerprintMu.Unlock()
+	return f
+}
+
+func normalize(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		// Whitespace and comments collapse to a single space
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(query)
+			}
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(query)
+			}
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'':
+			i = skipString(query, i, false)
+			b.WriteByte('?')
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			// Placeholder
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			b.WriteString(query[i:j])
+			i = j
+		case c == '$':
+			if j, ok := skipDollarQuoted(query, i); ok {
+				b.WriteByte('?')
+				i = j
+			} else {
+				b.WriteByte(c)
+				i++
+			}
+		case c == '"':
+			// Quoted identifiers are kept
+			j := i + 1
+			for j < len(query) && query[j] != '"' {
+				j++
+			}
+			if j < len(query) {
+				j++
+			}
+			b.WriteString(query[i:j])
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			i = skipNumber(query, i)
+			b.WriteByte('?')
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(query) && isIdent(query[j]) {
+				j++
+			}
+			// Prefixed strings such as E'\n', B'101' or X'ff'
+			if j == i+1 && j < len(query) && query[j] == '\'' && strings.IndexByte("EeBbXxNn", c) >= 0 {
+				i = skipString(query, j, c == 'E' || c == 'e')
+				b.WriteByte('?')
+				continue
+			}
+			b.WriteString(query[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	normalized := inList.ReplaceAllStringFunc(b.String(), func(m string) string {
+		return m[:2] + " (...)"
+	})
+	return valuesRows.ReplaceAllString(normalized, "$1, ...")
+}
+
+// skipString returns the index after the string literal starting at the
+// quote at i. Doubled quotes stay inside it, and so do backslash escapes
+// when escapes is set, for E'...' strings; with standard_conforming_strings
+// on, as it is by default, a backslash in any other string is literal.
+func skipString(query string, i int, escapes bool) int {
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case '\'':
+			if j+1 < len(query) && query[j+1] == '\'' {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(query)
+}
+
+// skipDollarQuoted returns the index after a $tag$...$tag$ literal starting
+// at i, if there is one
+func skipDollarQuoted(query string, i int) (int, bool) {
+	j := i + 1
+	for j < len(query) && isIdent(query[j]) && query[j] != '$' {
+		j++
+	}
+	if j >= len(query) || query[j] != '$' {
+		return 0, false
+	}
+	tag := query[i : j+1]
+	end := strings.Index(query[j+1:], tag)
+	if end < 0 {
+		return len(query), true
+	}
+	return j + 1 + end + len(tag), true
+}
+
+func skipNumber(query string, i int) int {
+	j := i
+	for j < len(query) && (isDigit(query[j]) || query[j] == '.') {
+		j++
+	}
+	if j < len(query) && (query[j] == 'e' || query[j] == 'E') {
+		k := j + 1
+		if k < len(query) && (query[k] == '+' || query[k] == '-') {
+			k++
+		}
+		if k < len(query) && isDigit(query[k]) {
+			j = k
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+		}
+	}
+	return j
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
@@ -0,0 +1,44 @@
+package storage
+
+import "testing"
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "literals",
+			query: "SELECT * FROM users WHERE email = 'ada@example.com' AND id IN (1, 2, 3)",
+			want:  "SELECT * FROM users WHERE email = ? AND id IN (...)",
+		},
+		{
+			name:  "doubled quote",
+			query: "SELECT 'it''s', 'secret'",
+			want:  "SELECT ?, ?",
+		},
+		{
+			name:  "backslash in a standard string",
+			query: `SELECT * FROM files WHERE path = 'C:\' AND owner = 'ada@example.com'`,
+			want:  "SELECT * FROM files WHERE path = ? AND owner = ?",
+		},
+		{
+			name:  "backslash escape in an E string",
+			query: `SELECT E'it\'s', 'ada@example.com'`,
+			want:  "SELECT ?, ?",
+		},
+		{
+			name:  "dollar quoted",
+			query: "SELECT $tag$ada@example.com$tag$, $1",
+			want:  "SELECT ?, $1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalize(tt.query); got != tt.want {
+				t.Errorf("normalize(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
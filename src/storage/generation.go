@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// generations is implemented by connectors that can retire every
+// connection they have made so far, e.g. after a failover or a credentials
+// rotation. Connections in use finish their work and are closed instead of
+// going back to the pool.
+type generations interface {
+	// current is the generation new connections belong to
+	current() uint64
+	// observe sees every error of a connection of generation
+	observe(generation uint64, err error)
+}
+
+// generationConn reports the errors it sees to the connector that made
+// it, and stops being reused once the connector has moved to a newer
+// generation. Optional driver interfaces the wrapped connection lacks are
+// reported with driver.ErrSkip or their zero behavior, as in
+// chaos.WrapConnector.
+type generationConn struct {
+	driver.Conn
+	owner      generations
+	generation uint64
+}
+
+var (
+	_ driver.ConnPrepareContext = (*generationConn)(nil)
+	_ driver.ConnBeginTx        = (*generationConn)(nil)
+	_ driver.QueryerContext     = (*generationConn)(nil)
+	_ driver.ExecerContext      = (*generationConn)(nil)
+	_ driver.Pinger             = (*generationConn)(nil)
+	_ driver.SessionResetter    = (*generationConn)(nil)
+	_ driver.Validator          = (*generationConn)(nil)
+	_ driver.NamedValueChecker  = (*generationConn)(nil)
+)
+
+func (c *generationConn) observe(err error) error {
+	if err != nil {
+		c.owner.observe(c.generation, err)
+	}
+	return err
+}
+
+func (c *generationConn) stale() bool {
+	return c.generation != c.owner.current()
+}
+
+func (c *generationConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *generationConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err := pc.PrepareContext(ctx, query)
+		return stmt, c.observe(err)
+	}
+	stmt, err := c.Conn.Prepare(query)
+	return stmt, c.observe(err)
+}
+
+func (c *generationConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err := bc.BeginTx(ctx, opts)
+		return tx, c.observe(err)
+	}
+	// Deprecated, but the only way in for drivers without BeginTx
+	tx, err := c.Conn.Begin()
+	return tx, c.observe(err)
+}
+
+func (c *generationConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	qc, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := qc.QueryContext(ctx, query, args)
+	return rows, c.observe(err)
+}
+
+func (c *generationConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ec, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := ec.ExecContext(ctx, query, args)
+	return result, c.observe(err)
+}
+
+func (c *generationConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return c.observe(p.Ping(ctx))
+	}
+	return nil
+}
+
+// ResetSession runs before a pooled connection is reused
+func (c *generationConn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *generationConn) IsValid() bool {
+	if c.stale() {
+		return false
+	}
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *generationConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// RDS authentication tokens are valid for 15 minutes. They are only checked
+// when connecting, so a new one is made well before that for new
+// connections while open ones carry on.
+const (
+	rdsTokenTTL     = 15 * time.Minute
+	rdsTokenRefresh = 10 * time.Minute
+)
+
+// cloudSQLLoginScope lets an access token log in to Cloud SQL as an IAM
+// database user
+const cloudSQLLoginScope = "https://www.googleapis.com/auth/sqlservice.login"
+
+// emptyPayloadHash is the SHA-256 of an empty body, signed into the token
+var emptyPayloadHash = func() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}()
+
+// shortLived is implemented by sources of tokens that are checked only
+// when connecting. They are read for every new connection, and a new token
+// doesn't retire connections opened with an older one.
+type shortLived interface {
+	shortLived()
+}
+
+// RDSIAMTokens makes AWS RDS IAM authentication tokens for user, signed
+// with the default AWS credentials. The database user needs the rds_iam
+// role, and connections must use TLS.
+type RDSIAMTokens struct {
+	endpoint string
+	user     string
+	region   string
+	provider aws.CredentialsProvider
+	signer   *v4.Signer
+
+	mu     sync.Mutex
+	token  string
+	issued time.Time
+}
+
+// NewRDSIAMTokens loads the default AWS configuration. region may be empty
+// to take it from the environment.
+func NewRDSIAMTokens(ctx context.Context, host string, port int, user, region string) (*RDSIAMTokens, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	if awsCfg.Region == "" {
+		return nil, fmt.Errorf("database.iam.region is required when the environment sets no AWS region")
+	}
+	return &RDSIAMTokens{
+		endpoint: host + ":" + strconv.Itoa(port),
+		user:     user,
+		region:   awsCfg.Region,
+		provider: awsCfg.Credentials,
+		signer:   v4.NewSigner(),
+	}, nil
+}
+
+// Credentials implements CredentialSource
+func (t *RDSIAMTokens) Credentials(ctx context.Context) (Credentials, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token == "" || time.Since(t.issued) > rdsTokenRefresh {
+		now := time.Now()
+		token, err := t.sign(ctx, now)
+		if err != nil {
+			return Credentials{}, err
+		}
+		t.token, t.issued = token, now
+	}
+	return Credentials{User: t.user, Password: t.token}, nil
+}
+
+// sign presigns the rds-db:connect request that is the token
+func (t *RDSIAMTokens) sign(ctx context.Context, now time.Time) (string, error) {
+	creds, err := t.provider.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve aws credentials: %w", err)
+	}
+
+	query := url.Values{
+		"Action":        {"connect"},
+		"DBUser":        {t.user},
+		"X-Amz-Expires": {strconv.Itoa(int(rdsTokenTTL.Seconds()))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+t.endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	signed, _, err := t.signer.PresignHTTP(ctx, creds, req, emptyPayloadHash, "rds-db", t.region, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign rds auth token: %w", err)
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+func (t *RDSIAMTokens) shortLived() {}
+
+// CloudSQLIAMTokens logs in to Cloud SQL as an IAM database user with
+// OAuth2 access tokens from Application Default Credentials, or from a
+// service account key file. The token source refreshes them before they
+// expire. For a service account, user is its email without
+// ".gserviceaccount.com".
+type CloudSQLIAMTokens struct {
+	user  string
+	token func() (string, error)
+}
+
+// NewCloudSQLIAMTokens finds the Google credentials. credentialsFile may
+// be empty to use Application Default Credentials.
+func NewCloudSQLIAMTokens(ctx context.Context, user, credentialsFile string) (*CloudSQLIAMTokens, error) {
+	opts := []option.ClientOption{option.WithScopes(cloudSQLLoginScope)}
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+	creds, err := transport.Creds(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find google credentials: %w", err)
+	}
+	return &CloudSQLIAMTokens{
+		user: user,
+		token: func() (string, error) {
+			token, err := creds.TokenSource.Token()
+			if err != nil {
+				return "", err
+			}
+			return token.AccessToken, nil
+		},
+	}, nil
+}
+
+// Credentials implements CredentialSource
+func (t *CloudSQLIAMTokens) Credentials(ctx context.Context) (Credentials, error) {
+	token, err := t.token()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to get cloud sql access token: %w", err)
+	}
+	return Credentials{User: t.user, Password: token}, nil
+}
+
+func (t *CloudSQLIAMTokens) shortLived() {}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxStackDepth bounds the frames a sampled handle records
+const maxStackDepth = 32
+
+// Kinds of tracked handles, used in metric buckets and logs
+const (
+	handleTransaction = "transaction"
+	handleStatement   = "statement"
+)
+
+// handleTracker keeps the transactions and prepared statements that are
+// open, so ones that are never committed, rolled back or closed show up
+// before they exhaust the database's connection slots. A nil tracker
+// tracks nothing.
+type handleTracker struct {
+	cfg    *config.LeakDetectionConfig
+	logger *zap.Logger
+	stats  metrics.Agent
+
+	mu      sync.Mutex
+	handles map[*handle]struct{}
+	over    bool // whether the last scan found more than MaxOpen open
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// handle is one open transaction or prepared statement
+type handle struct {
+	kind      string
+	requestID string
+	query     string
+	opened    time.Time
+	stack     []uintptr // set for sampled handles
+	warned    bool
+}
+
+// newHandleTracker starts scanning open handles every cfg.Interval, or
+// returns nil when leak detection is off
+func newHandleTracker(cfg *config.LeakDetectionConfig, logger *zap.Logger, stats metrics.Agent) *handleTracker {
+	if cfg == nil || !cfg.Enabled || cfg.Interval <= 0 {
+		return nil
+	}
+	t := &handleTracker{
+		cfg:     cfg,
+		logger:  logger,
+		stats:   stats,
+		handles: make(map[*handle]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// open tracks a new handle opened by the caller's caller
+func (t *handleTracker) open(kind string, logger callLogger) *handle {
+	if t == nil {
+		return nil
+	}
+	h := &handle{
+		kind:      kind,
+		requestID: logger.requestID,
+		query:     logger.statement,
+		opened:    time.Now(),
+	}
+	if t.cfg.StackSampleRate > 0 && rand.Float64() < t.cfg.StackSampleRate {
+		pcs := make([]uintptr, maxStackDepth)
+		// Skip runtime.Callers, open and the engine method
+		h.stack = pcs[:runtime.Callers(3, pcs)]
+	}
+
+	t.mu.Lock()
+	t.handles[h] = struct{}{}
+	t.mu.Unlock()
+	return h
+}
+
+// release stops tracking h. Releasing a handle twice is harmless.
+func (t *handleTracker) release(h *handle) {
+	if t == nil || h == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.handles, h)
+	t.mu.Unlock()
+}
+
+func (t *handleTracker) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.scan()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// scan reports the open handles and warns about ones held too long, once
+// each, and about the count crossing MaxOpen
+func (t *handleTracker) scan() {
+	now := time.Now()
+	counts := map[string]int{handleTransaction: 0, handleStatement: 0}
+	var aged []handle
+
+	t.mu.Lock()
+	for h := range t.handles {
+		counts[h.kind]++
+		if t.cfg.MaxAge > 0 && !h.warned && now.Sub(h.opened) > t.cfg.MaxAge {
+			h.warned = true
+			aged = append(aged, *h)
+		}
+	}
+	total := len(t.handles)
+	wasOver := t.over
+	t.over = t.cfg.MaxOpen > 0 && total > t.cfg.MaxOpen
+	t.mu.Unlock()
+
+	for kind, n := range counts {
+		t.stats.Gauge("db.handles.open."+kind, n)
+	}
+	for _, h := range aged {
+		t.stats.Increment("db.handles.leaked." + h.kind)
+		fields := []zap.Field{
+			zap.String("kind", h.kind),
+			zap.Duration("age", now.Sub(h.opened)),
+		}
+		if h.query != "" {
+			f := fingerprintOf(h.query)
+			fields = append(fields, zap.String("query", f.normalized), zap.String("fingerprint", f.id))
+		}
+		if h.requestID != "" {
+			fields = append(fields, zap.String("request_id", h.requestID))
+		}
+		if h.stack != nil {
+			fields = append(fields, zap.String("opened_at", formatStack(h.stack)))
+		}
+		t.logger.Warn("database handle open longer than expected, possible leak", fields...)
+	}
+	if t.over && !wasOver {
+		t.logger.Warn("too many open database handles, possible leak",
+			zap.Int("open", total),
+			zap.Int("max_open", t.cfg.MaxOpen),
+			zap.Int("transactions", counts[handleTransaction]),
+			zap.Int("statements", counts[handleStatement]),
+		)
+	}
+}
+
+// close stops scanning
+func (t *handleTracker) close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+// formatStack renders recorded frames one per line, like a panic's trace
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		b.WriteString(frame.Function)
+		b.WriteString("\n\t")
+		b.WriteString(frame.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(frame.Line))
+		if !more {
+			break
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"coffee-and-running/src/requestid"
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fieldPool recycles the field slices of written entries. The engine logs
+// around every call, so with debug logging on this is the hottest
+// allocation site in the service.
+var fieldPool = sync.Pool{
+	New: func() interface{} {
+		fields := make([]zap.Field, 0, 8)
+		return &fields
+	},
+}
+
+// callLogger logs on behalf of one engine call, tagging entries with the
+// request ID that started it and the statement it runs. Every method checks
+// the level before doing anything else, so a disabled level costs no
+// allocations: no logger clone for the request ID, no field slice, no
+// normalizing of the statement and no rendering of args.
+type callLogger struct {
+	logger    *zap.Logger
+	requestID string
+	statement string
+}
+
+// newCallLogger annotates logger with the request ID carried by ctx, if
+// any, so database logs can be correlated with the originating request
+func newCallLogger(ctx context.Context, logger *zap.Logger) callLogger {
+	return callLogger{logger: logger}.withContext(ctx)
+}
+
+// withContext tags l with ctx's request ID, keeping l's own when ctx
+// carries none, e.g. for a statement run in a request's transaction
+func (l callLogger) withContext(ctx context.Context) callLogger {
+	if id := requestid.FromContext(ctx); id != "" {
+		l.requestID = id
+	}
+	return l
+}
+
+// withQuery tags l's entries with query's normalized form and fingerprint,
+// never the raw SQL, so literals stay out of logs
+func (l callLogger) withQuery(query string) callLogger {
+	l.statement = query
+	return l
+}
+
+func (l callLogger) debug(msg string, fields ...zap.Field) {
+	if ce := l.logger.Check(zapcore.DebugLevel, msg); ce != nil {
+		l.write(ce, fields)
+	}
+}
+
+func (l callLogger) warn(msg string, fields ...zap.Field) {
+	if ce := l.logger.Check(zapcore.WarnLevel, msg); ce != nil {
+		l.write(ce, fields)
+	}
+}
+
+// failure logs a failed call with its error class. Cancellations are the
+// caller's doing rather than the database's, so they are only warnings.
+func (l callLogger) failure(msg, class string, fields ...zap.Field) {
+	level := zapcore.ErrorLevel
+	if class == errorCanceled {
+		level = zapcore.WarnLevel
+	}
+	if ce := l.logger.Check(level, msg); ce != nil {
+		l.write(ce, append(fields, zap.String("error_class", class)))
+	}
+}
+
+// query logs l's statement about to run at debug level. Its args are
+// rendered only when the entry is written.
+func (l callLogger) query(msg string, args []interface{}) {
+	if ce := l.logger.Check(zapcore.DebugLevel, msg); ce != nil {
+		l.write(ce, []zap.Field{zap.Array("args", queryArgs(args))})
+	}
+}
+
+func (l callLogger) write(ce *zapcore.CheckedEntry, fields []zap.Field) {
+	pooled := fieldPool.Get().(*[]zap.Field)
+	all := (*pooled)[:0]
+	if l.statement != "" {
+		f := fingerprintOf(l.statement)
+		all = append(all, zap.String("query", f.normalized), zap.String("fingerprint", f.id))
+	}
+	all = append(all, fields...)
+	if l.requestID != "" {
+		all = append(all, zap.String("request_id", l.requestID))
+	}
+	ce.Write(all...)
+
+	// Cores copy what they keep, so the slice can be reused; clearing it
+	// keeps the pool from pinning errors and args
+	clear(all)
+	*pooled = all[:0]
+	fieldPool.Put(pooled)
+}
+
+// queryArgs renders query args only when their entry is encoded
+type queryArgs []interface{}
+
+func (a queryArgs) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, arg := range a {
+		if err := enc.AppendReflected(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"coffee-and-running/src/requestid"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const benchQuery = "UPDATE users SET email = $1, updated_at = $2 WHERE id = $3"
+
+var benchArgs = []interface{}{"ada@example.com", time.Unix(1700000000, 0), 42}
+
+// benchLogger returns a logger at level that encodes entries as JSON and
+// discards them, so enabled entries pay their full encoding cost
+func benchLogger(level zapcore.Level) *zap.Logger {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, zapcore.AddSync(io.Discard), level))
+}
+
+// logEager logs an Exec the way the engine did before callLogger: it
+// clones the logger for the request ID and builds every field, boxing the
+// args, whether or not the entry is written
+func logEager(ctx context.Context, logger *zap.Logger, duration time.Duration) {
+	if id := requestid.FromContext(ctx); id != "" {
+		logger = logger.With(zap.String("request_id", id))
+	}
+	logger.Debug("executing statement", zap.String("query", benchQuery), zap.Any("args", benchArgs))
+	logger.Debug("statement completed",
+		zap.String("query", benchQuery),
+		zap.Duration("duration", duration),
+		zap.Int64("rows_affected", 1),
+	)
+}
+
+// logChecked logs the same Exec through callLogger
+func logChecked(ctx context.Context, logger *zap.Logger, duration time.Duration) {
+	l := newCallLogger(ctx, logger).withQuery(benchQuery)
+	l.query("executing statement", benchArgs)
+	l.debug("statement completed",
+		zap.Duration("duration", duration),
+		zap.Int64("rows_affected", 1),
+	)
+}
+
+// BenchmarkCallLogger compares the logging of one Exec before and after
+// callLogger. At info level, where the debug entries are dropped,
+// callLogger shouldn't allocate at all.
+func BenchmarkCallLogger(b *testing.B) {
+	ctx := requestid.NewContext(context.Background(), "01HV6Z3Q8N6J5X2T9K4M7R1C0B")
+	for _, level := range []zapcore.Level{zapcore.InfoLevel, zapcore.DebugLevel} {
+		logger := benchLogger(level)
+		for _, bench := range []struct {
+			name string
+			log  func(context.Context, *zap.Logger, time.Duration)
+		}{
+			{"eager", logEager},
+			{"checked", logChecked},
+		} {
+			b.Run(level.String()+"/"+bench.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					bench.log(ctx, logger, time.Millisecond)
+				}
+			})
+		}
+	}
+}
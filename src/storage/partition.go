@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"coffee-and-running/src/config"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Partitions of the connection pool. Interactive is the request path's
+// and has whatever database.partitions doesn't reserve; Background is for
+// scheduled tasks, the worker pool, the outbox relay and other work no
+// client is waiting on.
+const (
+	Interactive = "interactive"
+	Background  = "background"
+)
+
+type partitionKey struct{}
+
+// WithPartition returns a context whose calls borrow connections from the
+// named partition of the pool. Names database.partitions doesn't list use
+// the interactive partition, so marking work costs nothing until a
+// partition is reserved for it.
+func WithPartition(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, partitionKey{}, name)
+}
+
+// PartitionOf returns the partition ctx's calls use, Interactive unless
+// WithPartition chose another
+func PartitionOf(ctx context.Context) string {
+	if name, ok := ctx.Value(partitionKey{}).(string); ok && name != "" {
+		return name
+	}
+	return Interactive
+}
+
+// Partitioner is implemented by engines, for the statistics of each
+// partition of the pool
+type Partitioner interface {
+	// PartitionStats returns each partition's statistics by name; an
+	// engine without partitions has only Interactive, the whole pool
+	PartitionStats() map[string]sql.DBStats
+}
+
+// openPartitions opens a pool per partition on connector, so they share
+// its connections' generations, and returns the interactive one apart. It
+// refuses partitions that would leave the interactive pool no connections,
+// which database/sql would take as no limit at all.
+func openPartitions(connector driver.Connector, cfg *config.DatabaseConfig) (*sql.DB, map[string]*sql.DB, error) {
+	remaining := cfg.MaxOpenConns
+	for name, conns := range cfg.Partitions {
+		if name == "" || name == Interactive {
+			return nil, nil, fmt.Errorf("database.partitions: %q can't be a partition name", name)
+		}
+		if conns <= 0 {
+			return nil, nil, fmt.Errorf("database.partitions.%s must be positive", name)
+		}
+		remaining -= conns
+	}
+	if len(cfg.Partitions) > 0 && remaining <= 0 {
+		return nil, nil, errors.New("database.partitions must leave database.max_open_conns some interactive connections")
+	}
+
+	interactive := sql.OpenDB(connector)
+	configurePool(interactive, cfg, cfg.MaxOpenConns)
+	if len(cfg.Partitions) == 0 {
+		return interactive, nil, nil
+	}
+	partitions := make(map[string]*sql.DB, len(cfg.Partitions))
+	for name, conns := range cfg.Partitions {
+		db := sql.OpenDB(connector)
+		configurePool(db, cfg, conns)
+		partitions[name] = db
+	}
+	interactive.SetMaxOpenConns(remaining)
+	return interactive, partitions, nil
+}
+
+// configurePool applies the pool settings, limiting it to maxOpen
+// connections
+func configurePool(db *sql.DB, cfg *config.DatabaseConfig, maxOpen int) {
+	if maxOpen > 0 {
+		db.SetMaxOpenConns(maxOpen)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
+
+// pools returns every partition's pool by name
+func (e *engine) pools() map[string]*sql.DB {
+	pools := map[string]*sql.DB{Interactive: e.db}
+	for name, db := range e.partitions {
+		pools[name] = db
+	}
+	return pools
+}
+
+// pool returns the pool of ctx's partition
+func (e *engine) pool(ctx context.Context) *sql.DB {
+	if db, ok := e.partitions[PartitionOf(ctx)]; ok {
+		return db
+	}
+	return e.db
+}
+
+// PartitionStats implements Partitioner, sending each partition's pool
+// metrics as db.pool.<partition>.*
+func (e *engine) PartitionStats() map[string]sql.DBStats {
+	stats := make(map[string]sql.DBStats, len(e.partitions)+1)
+	for name, db := range e.pools() {
+		s := db.Stats()
+		stats[name] = s
+
+		bucket := "db.pool." + name
+		e.stats.Gauge(bucket+".max_open", s.MaxOpenConnections)
+		e.stats.Gauge(bucket+".open", s.OpenConnections)
+		e.stats.Gauge(bucket+".in_use", s.InUse)
+		e.stats.Gauge(bucket+".idle", s.Idle)
+		e.stats.Count(bucket+".wait_count", s.WaitCount)
+		e.stats.Timing(bucket+".wait_duration", s.WaitDuration)
+	}
+	return stats
+}
+
+// PartitionStatsHandler serves each partition's pool statistics
+func PartitionStatsHandler(p Partitioner) http.HandlerFunc {
+	type partition struct {
+		Name           string  `json:"name"`
+		MaxOpen        int     `json:"max_open"`
+		Open           int     `json:"open"`
+		InUse          int     `json:"in_use"`
+		Idle           int     `json:"idle"`
+		WaitCount      int64   `json:"wait_count"`
+		WaitDurationMs float64 `json:"wait_duration_ms"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := p.PartitionStats()
+		partitions := make([]partition, 0, len(stats))
+		for name, s := range stats {
+			partitions = append(partitions, partition{
+				Name:           name,
+				MaxOpen:        s.MaxOpenConnections,
+				Open:           s.OpenConnections,
+				InUse:          s.InUse,
+				Idle:           s.Idle,
+				WaitCount:      s.WaitCount,
+				WaitDurationMs: float64(s.WaitDuration.Microseconds()) / 1000,
+			})
+		}
+		sort.Slice(partitions, func(i, j int) bool {
+			return partitions[i].Name < partitions[j].Name
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"partitions": partitions})
+	}
+}
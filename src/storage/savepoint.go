@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Savepoint marks a point in the transaction that RollbackTo can return
+// to, undoing what ran since without ending the transaction. Names are
+// quoted, so any string is safe; reusing one moves the savepoint.
+func (tx *InstrumentedTx) Savepoint(ctx context.Context, name string) error {
+	return tx.savepoint(ctx, "create", "SAVEPOINT "+pq.QuoteIdentifier(name))
+}
+
+// RollbackTo undoes what ran since the named savepoint, which stays set so
+// it can be rolled back to again. A statement that failed leaves the
+// transaction aborted until then.
+func (tx *InstrumentedTx) RollbackTo(ctx context.Context, name string) error {
+	return tx.savepoint(ctx, "rollback", "ROLLBACK TO SAVEPOINT "+pq.QuoteIdentifier(name))
+}
+
+// Release forgets the named savepoint and those set after it, keeping what
+// ran since
+func (tx *InstrumentedTx) Release(ctx context.Context, name string) error {
+	return tx.savepoint(ctx, "release", "RELEASE SAVEPOINT "+pq.QuoteIdentifier(name))
+}
+
+// Nested runs fn as a sub-transaction: under a savepoint that is released
+// when fn returns nil and rolled back to when it returns an error or
+// panics, so a failed step can be handled without losing the rest of the
+// transaction:
+//
+//	err := tx.Nested(ctx, func(tx *storage.InstrumentedTx) error {
+//	    _, err := tx.Exec(ctx, "INSERT INTO tags (name) VALUES ($1)", name)
+//	    return err
+//	})
+//	if errors.Is(err, apperr.Conflict) {
+//	    // the tag exists; the transaction carries on without it
+//	}
+//
+// Calls nest, each with its own savepoint. It returns fn's error, or the
+// savepoint's own when fn succeeded or rolling back failed.
+func (tx *InstrumentedTx) Nested(ctx context.Context, fn func(tx *InstrumentedTx) error) error {
+	tx.savepoints++
+	name := "nested_" + strconv.Itoa(tx.savepoints)
+	if err := tx.Savepoint(ctx, name); err != nil {
+		return err
+	}
+
+	panicked := true
+	defer func() {
+		if panicked {
+			tx.RollbackTo(context.WithoutCancel(ctx), name)
+		}
+	}()
+	err := fn(tx)
+	panicked = false
+
+	if err != nil {
+		tx.stats.Increment("db.transaction.savepoint.nested.rolled_back")
+		if rbErr := tx.RollbackTo(ctx, name); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	tx.stats.Increment("db.transaction.savepoint.nested.released")
+	return tx.Release(ctx, name)
+}
+
+// savepoint runs a savepoint statement with logging and metrics under
+// db.transaction.savepoint.<op>
+func (tx *InstrumentedTx) savepoint(ctx context.Context, op, statement string) error {
+	tx.audit.check(ctx, "transaction.savepoint."+op)
+	logger := tx.logger.withContext(ctx).withQuery(statement)
+	bucket := "db.transaction.savepoint." + op
+	start := time.Now()
+
+	_, err := tx.tx.ExecContext(ctx, statement)
+	duration := time.Since(start)
+
+	if err != nil {
+		class := callErrorClass(ctx, err)
+		logger.failure("transaction savepoint failed", class,
+			zap.String("operation", op),
+			zap.Duration("duration", duration),
+			zap.Error(err),
+		)
+		tx.stats.Increment(bucket + ".error")
+		tx.stats.Increment(bucket + ".error." + class)
+	} else {
+		logger.debug("transaction savepoint completed",
+			zap.String("operation", op),
+			zap.Duration("duration", duration),
+		)
+		tx.stats.Increment(bucket + ".success")
+	}
+
+	tx.stats.Timing(bucket+".duration", duration)
+	return Classify(err)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SessionVariableName matches the names WithSessionVariable accepts:
+// custom Postgres settings, which need a prefix such as app.
+var SessionVariableName = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)+$`)
+
+type sessionVariablesKey struct{}
+
+// sessionVariable is a setting transactions begun with a context set
+type sessionVariable struct {
+	name  string
+	value string
+}
+
+// WithSessionVariable returns a context whose transactions set the
+// Postgres setting name to value as they begin, like SET LOCAL, so row
+// level security policies can read it with current_setting:
+//
+//	CREATE POLICY tenant_isolation ON posts
+//	    USING (tenant_id = current_setting('app.current_tenant', true));
+//
+// Settings last until the transaction ends, so pooled connections never
+// carry one request's settings into another's. Calls outside a
+// transaction don't see them. A later value for the same name replaces the
+// earlier one. name must be a custom setting such as app.current_tenant;
+// it panics otherwise, since that is a programming error.
+func WithSessionVariable(ctx context.Context, name, value string) context.Context {
+	if !SessionVariableName.MatchString(name) {
+		panic(fmt.Sprintf("storage: invalid session variable name %q", name))
+	}
+	current := sessionVariables(ctx)
+	vars := make([]sessionVariable, 0, len(current)+1)
+	for _, v := range current {
+		if v.name != name {
+			vars = append(vars, v)
+		}
+	}
+	vars = append(vars, sessionVariable{name: name, value: value})
+	return context.WithValue(ctx, sessionVariablesKey{}, vars)
+}
+
+func sessionVariables(ctx context.Context) []sessionVariable {
+	vars, _ := ctx.Value(sessionVariablesKey{}).([]sessionVariable)
+	return vars
+}
+
+// setSessionVariables sets ctx's session variables in tx, in one round
+// trip. set_config takes bind parameters, unlike SET LOCAL, so values
+// never need quoting.
+func setSessionVariables(ctx context.Context, tx *InstrumentedTx) error {
+	vars := sessionVariables(ctx)
+	if len(vars) == 0 {
+		return nil
+	}
+	calls := make([]string, len(vars))
+	args := make([]interface{}, 0, 2*len(vars))
+	for i, v := range vars {
+		calls[i] = fmt.Sprintf("set_config($%d, $%d, true)", 2*i+1, 2*i+2)
+		args = append(args, v.name, v.value)
+	}
+	_, err := tx.Exec(ctx, "SELECT "+strings.Join(calls, ", "), args...)
+	return err
+}
+
+// WithTx runs fn in a transaction begun with ctx, so it has ctx's session
+// variables. The transaction is committed when fn returns nil and rolled
+// back when it returns an error or panics.
+func WithTx(ctx context.Context, engine Engine, fn func(tx *InstrumentedTx) error) error {
+	tx, err := engine.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// explainQueueSize bounds the slow queries waiting for a plan; more are
+// dropped rather than queued behind a slow database
+const explainQueueSize = 16
+
+// slowQueryLog reports calls slower than database.slow_query_threshold,
+// warning about them when database.log_slow_queries is set and handing
+// them to the explainer. A nil log reports nothing.
+type slowQueryLog struct {
+	threshold time.Duration
+	log       bool
+	stats     metrics.Agent
+	explainer *explainer
+}
+
+// newSlowQueryLog returns nil when neither logging nor plans are wanted
+func newSlowQueryLog(cfg *config.DatabaseConfig, explainer *explainer, stats metrics.Agent) *slowQueryLog {
+	if cfg.SlowQueryThreshold <= 0 || !cfg.LogSlowQueries && explainer == nil {
+		return nil
+	}
+	return &slowQueryLog{
+		threshold: cfg.SlowQueryThreshold,
+		log:       cfg.LogSlowQueries,
+		stats:     stats,
+		explainer: explainer,
+	}
+}
+
+// observe reports logger's statement if it took longer than the threshold
+func (s *slowQueryLog) observe(logger callLogger, args []interface{}, duration time.Duration) {
+	if s == nil || duration < s.threshold || logger.statement == "" {
+		return
+	}
+	s.stats.Increment("db.slow_queries")
+	if s.log {
+		logger.warn("slow query",
+			zap.Duration("duration", duration),
+			zap.Duration("threshold", s.threshold),
+		)
+	}
+	s.explainer.capture(logger, args)
+}
+
+// close stops the explainer
+func (s *slowQueryLog) close() {
+	if s != nil {
+		s.explainer.close()
+	}
+}
+
+// explainer logs the plans of slow queries. EXPLAIN runs in the
+// background, without ANALYZE so the statement isn't run again, at most
+// max_per_minute times a minute and once per statement per cooldown. A
+// nil explainer explains nothing.
+type explainer struct {
+	cfg     *config.ExplainConfig
+	pool    func(ctx context.Context) *sql.DB
+	stats   metrics.Agent
+	limiter *rate.Limiter
+
+	mu     sync.Mutex
+	recent map[string]time.Time // fingerprints by when they were last explained
+
+	queue chan explainRequest
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// explainRequest is a slow query waiting for its plan
+type explainRequest struct {
+	logger   callLogger
+	args     []interface{}
+	received time.Time
+}
+
+// newExplainer starts explaining slow queries with connections from pool,
+// or returns nil when database.explain is off
+func newExplainer(cfg *config.ExplainConfig, pool func(ctx context.Context) *sql.DB, stats metrics.Agent) *explainer {
+	if cfg == nil || !cfg.Enabled || cfg.MaxPerMinute <= 0 {
+		return nil
+	}
+	x := &explainer{
+		cfg:     cfg,
+		pool:    pool,
+		stats:   stats,
+		limiter: rate.NewLimiter(rate.Limit(float64(cfg.MaxPerMinute)/60), cfg.MaxPerMinute),
+		recent:  make(map[string]time.Time),
+		queue:   make(chan explainRequest, explainQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go x.run()
+	return x
+}
+
+// capture queues logger's statement for a plan, unless it can't be
+// explained, was explained within the cooldown or the rate is spent
+func (x *explainer) capture(logger callLogger, args []interface{}) {
+	if x == nil || !explainable(logger.statement) {
+		return
+	}
+	id := fingerprintOf(logger.statement).id
+	now := time.Now()
+
+	x.mu.Lock()
+	if last, ok := x.recent[id]; ok && now.Sub(last) < x.cfg.Cooldown {
+		x.mu.Unlock()
+		return
+	}
+	if !x.limiter.AllowN(now, 1) {
+		x.mu.Unlock()
+		x.stats.Increment("db.explain.rate_limited")
+		return
+	}
+	x.recent[id] = now
+	if len(x.recent) > fingerprintCacheSize {
+		for key, last := range x.recent {
+			if now.Sub(last) >= x.cfg.Cooldown {
+				delete(x.recent, key)
+			}
+		}
+	}
+	x.mu.Unlock()
+
+	select {
+	case x.queue <- explainRequest{logger: logger, args: args, received: now}:
+	default:
+		x.stats.Increment("db.explain.dropped")
+	}
+}
+
+func (x *explainer) run() {
+	defer close(x.done)
+	for {
+		select {
+		case req := <-x.queue:
+			x.explain(req)
+		case <-x.stop:
+			return
+		}
+	}
+}
+
+// explain logs the plan of req's statement
+func (x *explainer) explain(req explainRequest) {
+	ctx, cancel := context.WithTimeout(WithPartition(context.Background(), Background), x.cfg.Timeout)
+	defer cancel()
+	start := time.Now()
+
+	plan, err := x.plan(ctx, req.logger.statement, req.args)
+	x.stats.Timing("db.explain.duration", time.Since(start))
+	if err != nil {
+		// Statements using temporary tables or session settings of the
+		// caller's connection can't be explained on another one
+		req.logger.debug("failed to explain slow query", zap.Error(redact(err)))
+		x.stats.Increment("db.explain.error")
+		return
+	}
+	x.stats.Increment("db.explain.success")
+	req.logger.warn("slow query plan",
+		zap.String("plan", plan),
+		zap.Time("slow_at", req.received),
+	)
+}
+
+func (x *explainer) plan(ctx context.Context, query string, args []interface{}) (string, error) {
+	rows, err := x.pool(ctx).QueryContext(ctx, "EXPLAIN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// close stops explaining; queued queries are dropped
+func (x *explainer) close() {
+	if x == nil {
+		return
+	}
+	close(x.stop)
+	<-x.done
+}
+
+// explainable reports whether query is a single statement EXPLAIN
+// accepts. Without args, EXPLAIN goes through the simple query protocol,
+// which runs every statement in the string, so a query with a ; between
+// statements would have the ones after the first executed again.
+// Literals and comments are gone from the normalized form, so a ; left
+// may separate statements and the query is skipped.
+func explainable(query string) bool {
+	normalized := strings.TrimRight(fingerprintOf(query).normalized, "; ")
+	if strings.ContainsRune(normalized, ';') {
+		return false
+	}
+	fields := strings.Fields(normalized)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToLower(strings.TrimLeft(fields[0], "(")) {
+	case "select", "insert", "update", "delete", "with", "values", "table", "merge":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"coffee-and-running/src/apperr"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when no live row matches the key. It is of
+	// kind apperr.NotFound.
+	ErrNotFound error = apperr.New(apperr.NotFound, "storage", "row not found")
+	// ErrConflict matches every *ConflictError with errors.Is, as does
+	// apperr.Conflict
+	ErrConflict error = apperr.New(apperr.Conflict, "storage", "version conflict")
+)
+
+// ConflictError is returned when an optimistic update loses a race: the
+// row was changed since the caller read it. Handlers should respond 409
+// (or 412 for If-Match requests) and let the client re-read.
+type ConflictError struct {
+	Table    string
+	Key      interface{}
+	Expected int64
+	Current  int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("storage: %s %v was modified (expected version %d, now %d)",
+		e.Table, e.Key, e.Expected, e.Current)
+}
+
+// Is makes errors.Is(err, ErrConflict) and errors.Is(err, apperr.Conflict)
+// true
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict || target == apperr.Conflict
+}
+
+// Executor is satisfied by both Engine and *InstrumentedTx, so Table
+// helpers run inside or outside a transaction
+type Executor interface {
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Table describes the conventions a table opts into. Leaving DeletedAt or
+// Version empty disables soft deletes or optimistic locking respectively.
+//
+//	var posts = storage.Table{Name: "posts", Key: "id", DeletedAt: "deleted_at", Version: "version"}
+type Table struct {
+	Name string
+	// Key is the primary key column, "id" by default
+	Key string
+	// DeletedAt is a nullable timestamptz column; rows with it set are
+	// hidden by Live and restored by Restore
+	DeletedAt string
+	// Version is a bigint column incremented by every Update
+	Version string
+	// UpdatedAt, when set, is bumped to now() by Update
+	UpdatedAt string
+}
+
+func (t Table) key() string {
+	if t.Key == "" {
+		return "id"
+	}
+	return t.Key
+}
+
+// Live appends the soft-delete filter to a WHERE condition. Use it in
+// every read so deleted rows never leak:
+//
+//	engine.Query(ctx, `SELECT ... FROM posts WHERE `+posts.Live("author_id = $1"), authorID)
+func (t Table) Live(where string) string {
+	if t.DeletedAt == "" {
+		return where
+	}
+	if where == "" {
+		return t.DeletedAt + " IS NULL"
+	}
+	return "(" + where + ") AND " + t.DeletedAt + " IS NULL"
+}
+
+// Delete soft-deletes the row when DeletedAt is set and removes it
+// otherwise. It returns ErrNotFound if no live row has the key.
+func (t Table) Delete(ctx context.Context, db Executor, key interface{}) error {
+	var query string
+	if t.DeletedAt != "" {
+		query = fmt.Sprintf(`UPDATE %s SET %s = now() WHERE %s = $1 AND %s IS NULL`,
+			t.Name, t.DeletedAt, t.key(), t.DeletedAt)
+	} else {
+		query = fmt.Sprintf(`DELETE FROM %s WHERE %s = $1`, t.Name, t.key())
+	}
+	return expectOne(db.Exec(ctx, query, key))
+}
+
+// Restore undoes a soft delete. It returns ErrNotFound if no deleted row
+// has the key.
+func (t Table) Restore(ctx context.Context, db Executor, key interface{}) error {
+	if t.DeletedAt == "" {
+		return fmt.Errorf("storage: %s does not use soft deletes", t.Name)
+	}
+	query := fmt.Sprintf(`UPDATE %s SET %s = NULL WHERE %s = $1 AND %s IS NOT NULL`,
+		t.Name, t.DeletedAt, t.key(), t.DeletedAt)
+	return expectOne(db.Exec(ctx, query, key))
+}
+
+// Purge permanently removes rows soft-deleted more than olderThan ago and
+// returns how many were removed
+func (t Table) Purge(ctx context.Context, db Executor, olderThan time.Duration) (int64, error) {
+	if t.DeletedAt == "" {
+		return 0, fmt.Errorf("storage: %s does not use soft deletes", t.Name)
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s < $1`, t.Name, t.DeletedAt)
+	result, err := db.Exec(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Update sets columns on a live row and returns its new version. With
+// Version set, the update only applies if the row is still at version;
+// otherwise it returns a *ConflictError, or ErrNotFound if the row is
+// gone. Column names must come from code, never from the request.
+func (t Table) Update(ctx context.Context, db Executor, key interface{}, version int64, set map[string]interface{}) (int64, error) {
+	// Sorted so the same update always produces the same SQL
+	columns := make([]string, 0, len(set))
+	for column := range set {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	assignments := make([]string, 0, len(columns)+2)
+	args := make([]interface{}, 0, len(columns)+2)
+	for _, column := range columns {
+		args = append(args, set[column])
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if t.UpdatedAt != "" {
+		assignments = append(assignments, t.UpdatedAt+" = now()")
+	}
+
+	args = append(args, key)
+	where := fmt.Sprintf("%s = $%d", t.key(), len(args))
+	returning := "0"
+	if t.Version != "" {
+		assignments = append(assignments, fmt.Sprintf("%s = %s + 1", t.Version, t.Version))
+		args = append(args, version)
+		where += fmt.Sprintf(" AND %s = $%d", t.Version, len(args))
+		returning = t.Version
+	}
+	if len(assignments) == 0 {
+		return version, nil
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET %s WHERE %s RETURNING %s`,
+		t.Name, strings.Join(assignments, ", "), t.Live(where), returning)
+	updated, found, err := queryInt(ctx, db, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return updated, nil
+	}
+	if t.Version == "" {
+		return 0, ErrNotFound
+	}
+
+	// Nothing matched: tell a missing row apart from a stale version
+	query = fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, t.Version, t.Name, t.Live(t.key()+" = $1"))
+	current, found, err := queryInt(ctx, db, query, key)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	return 0, &ConflictError{Table: t.Name, Key: key, Expected: version, Current: current}
+}
+
+// queryInt reads a single integer column from the first row, if any.
+// Executor has no QueryRow since transactions don't expose one.
+func queryInt(ctx context.Context, db Executor, query string, args ...interface{}) (int64, bool, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, rows.Err()
+	}
+	var n int64
+	if err := rows.Scan(&n); err != nil {
+		return 0, false, err
+	}
+	return n, true, rows.Err()
+}
+
+func expectOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
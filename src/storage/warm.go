@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Warmer is implemented by engines, for filling the pool before traffic
+// arrives
+type Warmer interface {
+	// Warm opens conns connections, holding them all at once so each is a
+	// new one, and prepares statements on every one, then returns them to
+	// the pool. Preparing validates the statements against the schema and
+	// loads the tables they use into each server session's caches.
+	Warm(ctx context.Context, conns int, statements []string) error
+}
+
+// Warm implements Warmer
+func (e *engine) Warm(ctx context.Context, conns int, statements []string) error {
+	start := time.Now()
+
+	held := make([]*sql.Conn, 0, conns)
+	defer func() {
+		for _, conn := range held {
+			conn.Close()
+		}
+	}()
+
+	var errs []error
+	for len(held) < conns {
+		conn, err := e.db.Conn(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to open connection %d: %w", len(held)+1, err))
+			break
+		}
+		held = append(held, conn)
+
+		for _, query := range statements {
+			stmt, err := conn.PrepareContext(ctx, query)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to prepare %q: %w", query, redact(err)))
+				continue
+			}
+			stmt.Close()
+		}
+		if len(errs) > 0 {
+			// The same statements would fail on every connection
+			break
+		}
+	}
+
+	e.stats.Timing("db.warmup.duration", time.Since(start))
+	e.logger.Debug("database pool warmed",
+		zap.Int("connections", len(held)),
+		zap.Int("statements", len(statements)),
+		zap.Duration("duration", time.Since(start)))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,45 @@
+package tenancy
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"context"
+)
+
+// Bucket returns the metric prefix for tenant, e.g. "tenant.acme". Tenant
+// IDs are validated to be metric-safe before they reach the context.
+func Bucket(tenant string) string {
+	return "tenant." + tenant
+}
+
+// tenantAgent prefixes every bucket with the tenant
+type tenantAgent struct {
+	metrics.Agent
+	prefix string
+}
+
+// Stats returns an agent that tags every bucket with the tenant in ctx,
+// e.g. "orders.created" becomes "tenant.acme.orders.created". Without a
+// tenant it returns stats unchanged.
+func Stats(ctx context.Context, stats metrics.Agent) metrics.Agent {
+	tenant, ok := FromContext(ctx)
+	if !ok {
+		return stats
+	}
+	return &tenantAgent{Agent: stats, prefix: Bucket(tenant) + "."}
+}
+
+func (a *tenantAgent) Increment(bucket string) {
+	a.Agent.Increment(a.prefix + bucket)
+}
+
+func (a *tenantAgent) Count(bucket string, n interface{}) {
+	a.Agent.Count(a.prefix+bucket, n)
+}
+
+func (a *tenantAgent) Timing(bucket string, value interface{}) {
+	a.Agent.Timing(a.prefix+bucket, value)
+}
+
+func (a *tenantAgent) Gauge(bucket string, value interface{}) {
+	a.Agent.Gauge(a.prefix+bucket, value)
+}
@@ -0,0 +1,93 @@
+package tenancy
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// Middleware resolves the tenant of every request and stores it in the
+// request context. Resolvers are tried in order and the first non-empty
+// result wins. When required is set, requests without a tenant are
+// rejected with 400.
+type Middleware struct {
+	resolvers []Resolver
+	required  bool
+	variable  string
+	stats     metrics.Agent
+	logger    *zap.Logger
+}
+
+// NewMiddleware creates the tenant middleware. sessionVariable, when set,
+// names the Postgres setting the request's transactions set to its tenant
+// (see storage.WithSessionVariable); it returns an error if the name isn't
+// one. stats may be nil to disable per-tenant request metrics.
+func NewMiddleware(resolvers []Resolver, required bool, sessionVariable string, logger *zap.Logger, stats metrics.Agent) (*Middleware, error) {
+	if sessionVariable != "" && !storage.SessionVariableName.MatchString(sessionVariable) {
+		return nil, fmt.Errorf("tenancy.session_variable %q must be a custom setting name such as app.current_tenant", sessionVariable)
+	}
+	return &Middleware{
+		resolvers: resolvers,
+		required:  required,
+		variable:  sessionVariable,
+		stats:     stats,
+		logger:    logger.With(zap.String("component", "tenancy")),
+	}, nil
+}
+
+// Handler implements the chi middleware signature
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := ""
+		for _, resolve := range m.resolvers {
+			if tenant = resolve(r); tenant != "" {
+				break
+			}
+		}
+
+		if tenant == "" {
+			if m.required {
+				writeError(w, http.StatusBadRequest, "tenant is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := Validate(tenant); err != nil {
+			m.logger.Debug("Rejected invalid tenant", zap.String("tenant", tenant))
+			writeError(w, http.StatusBadRequest, "invalid tenant")
+			return
+		}
+
+		ctx := NewContext(r.Context(), tenant)
+		if m.variable != "" {
+			ctx = storage.WithSessionVariable(ctx, m.variable, tenant)
+		}
+		r = r.WithContext(ctx)
+		if m.stats == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			bucket := fmt.Sprintf("%s.http.%dxx", Bucket(tenant), ww.Status()/100)
+			m.stats.Increment(bucket + ".requests")
+			m.stats.Timing(bucket+".duration", time.Since(start))
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,114 @@
+package tenancy
+
+import (
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/storage"
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Isolation modes
+const (
+	// IsolationColumn keeps every tenant in shared tables and scopes
+	// queries with a mandatory tenant_id predicate
+	IsolationColumn = "column"
+	// IsolationSchema gives every tenant its own schema and scopes
+	// transactions by switching search_path
+	IsolationSchema = "schema"
+)
+
+// Scoper applies the configured isolation mode to database work
+type Scoper struct {
+	isolation    string
+	column       string
+	schemaPrefix string
+}
+
+// NewScoper creates a scoper from the tenancy config
+func NewScoper(cfg *config.TenancyConfig) (*Scoper, error) {
+	switch cfg.Isolation {
+	case "", IsolationColumn, IsolationSchema:
+	default:
+		return nil, fmt.Errorf("unsupported tenant isolation %q", cfg.Isolation)
+	}
+
+	isolation := cfg.Isolation
+	if isolation == "" {
+		isolation = IsolationColumn
+	}
+	column := cfg.Column
+	if column == "" {
+		column = "tenant_id"
+	}
+	return &Scoper{isolation: isolation, column: column, schemaPrefix: cfg.SchemaPrefix}, nil
+}
+
+// Isolation returns the active isolation mode
+func (s *Scoper) Isolation() string {
+	return s.isolation
+}
+
+// Schema returns the schema that holds tenant's tables
+func (s *Scoper) Schema(tenant string) string {
+	return s.schemaPrefix + tenant
+}
+
+// Begin starts a transaction scoped to the tenant in ctx. In schema mode
+// the search_path is switched for the transaction only, so pooled
+// connections never leak one tenant's path to another. It fails with
+// ErrNoTenant when ctx carries no tenant.
+func (s *Scoper) Begin(ctx context.Context, engine storage.Engine) (*storage.InstrumentedTx, error) {
+	tenant, err := MustFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := engine.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.isolation != IsolationSchema {
+		return tx, nil
+	}
+
+	// SET does not accept bind parameters, so the identifier is quoted
+	if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+pq.QuoteIdentifier(s.Schema(tenant))+", public"); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to scope transaction to tenant %s: %w", tenant, err)
+	}
+	return tx, nil
+}
+
+// Where returns the tenant predicate for a query that already binds args,
+// with the tenant appended as the next argument:
+//
+//	where, args, err := scoper.Where(ctx, []interface{}{postID})
+//	rows, err := engine.Query(ctx, "SELECT ... FROM posts WHERE id = $1 AND "+where, args...)
+//
+// In schema mode the predicate is "TRUE" and args are returned unchanged.
+// It fails with ErrNoTenant when ctx carries no tenant, so a missing
+// tenant can never widen a query to every tenant's rows.
+func (s *Scoper) Where(ctx context.Context, args []interface{}) (string, []interface{}, error) {
+	tenant, err := MustFromContext(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if s.isolation == IsolationSchema {
+		return "TRUE", args, nil
+	}
+
+	args = append(args, tenant)
+	return fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(s.column), len(args)), args, nil
+}
+
+// CreateSchema creates tenant's schema if it does not exist. Run the
+// tenant migrations against it afterwards.
+func (s *Scoper) CreateSchema(ctx context.Context, engine storage.Engine, tenant string) error {
+	if err := Validate(tenant); err != nil {
+		return err
+	}
+	_, err := engine.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(s.Schema(tenant)))
+	return err
+}
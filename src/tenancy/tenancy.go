@@ -0,0 +1,129 @@
+package tenancy
+
+import (
+	"coffee-and-running/src/auth"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxLength bounds tenant IDs, which end up in schema names and metric
+// buckets
+const maxLength = 63
+
+var (
+	// ErrNoTenant is returned when a tenant-scoped operation runs without a
+	// tenant in the context
+	ErrNoTenant = errors.New("tenancy: no tenant in context")
+	// ErrInvalidTenant is returned for tenant IDs with unsafe characters
+	ErrInvalidTenant = errors.New("tenancy: invalid tenant id")
+)
+
+type tenantKey struct{}
+
+// NewContext returns a copy of ctx scoped to tenant
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant stored in ctx
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// MustFromContext returns the tenant stored in ctx or ErrNoTenant
+func MustFromContext(ctx context.Context) (string, error) {
+	tenant, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// Validate checks that tenant is safe to use as an identifier: lowercase
+// letters, digits, '-' and '_', at most 63 characters
+func Validate(tenant string) error {
+	if tenant == "" || len(tenant) > maxLength {
+		return ErrInvalidTenant
+	}
+	for _, c := range tenant {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return ErrInvalidTenant
+		}
+	}
+	return nil
+}
+
+// Resolver extracts a tenant from a request. It returns "" when the request
+// carries no tenant for this source.
+type Resolver func(r *http.Request) string
+
+// HeaderResolver reads the tenant from a request header
+func HeaderResolver(header string) Resolver {
+	return func(r *http.Request) string {
+		return strings.ToLower(strings.TrimSpace(r.Header.Get(header)))
+	}
+}
+
+// SubdomainResolver reads the tenant from the first label of the host
+// below baseDomain, so "acme.example.com" resolves to "acme" for
+// baseDomain "example.com". The bare base domain and "www" carry no tenant.
+func SubdomainResolver(baseDomain string) Resolver {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(r *http.Request) string {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if i := strings.LastIndexByte(sub, '.'); i >= 0 {
+			sub = sub[i+1:]
+		}
+		if sub == "www" {
+			return ""
+		}
+		return sub
+	}
+}
+
+// ClaimResolver reads the tenant from a claim of the authenticated caller.
+// It must run after the authentication middleware.
+func ClaimResolver(claim string) Resolver {
+	return func(r *http.Request) string {
+		tenant, _ := auth.FromContext(r.Context()).String(claim)
+		return strings.ToLower(tenant)
+	}
+}
+
+// Resolvers builds resolvers for the named sources, tried in order:
+// "header", "subdomain" and "claim"
+func Resolvers(sources []string, header, baseDomain, claim string) ([]Resolver, error) {
+	resolvers := make([]Resolver, 0, len(sources))
+	for _, source := range sources {
+		switch source {
+		case "header":
+			resolvers = append(resolvers, HeaderResolver(header))
+		case "subdomain":
+			if baseDomain == "" {
+				return nil, errors.New("tenancy base_domain is required for the subdomain source")
+			}
+			resolvers = append(resolvers, SubdomainResolver(baseDomain))
+		case "claim":
+			resolvers = append(resolvers, ClaimResolver(claim))
+		default:
+			return nil, fmt.Errorf("unsupported tenant source %q", source)
+		}
+	}
+	return resolvers, nil
+}
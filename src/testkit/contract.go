@@ -0,0 +1,313 @@
+package testkit
+
+import (
+	"bytes"
+	"coffee-and-running/src/openapi"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ContractClient is a Go client generated by "starter gen client"
+type ContractClient interface {
+	Invoke(ctx context.Context, route string, path, params map[string]json.RawMessage, body json.RawMessage) (any, error)
+}
+
+// ContractExample replaces the arguments generated for one route
+type ContractExample struct {
+	// Path, query and header parameters by name
+	Path   map[string]interface{}
+	Params map[string]interface{}
+	Body   interface{}
+	// Status is the response the call must get. Any documented status is
+	// accepted when it's 0.
+	Status int
+}
+
+// Contract runs a generated client against the application, calling each
+// operation in the OpenAPI document with example arguments built from its
+// schemas
+type Contract struct {
+	// Spec is the document; it defaults to api/openapi.yaml at the module
+	// root
+	Spec string
+	// Client returns the generated client for the server at baseURL, such
+	// as func(url string) testkit.ContractClient { return apiclient.New(url) }
+	Client func(baseURL string) ContractClient
+	// Examples replace the generated arguments by route, such as
+	// "GET /webhooks/deliveries/{id}"
+	Examples map[string]ContractExample
+	// Skip lists routes not to call
+	Skip []string
+}
+
+// Run calls each documented route through the client against s, in a
+// subtest named after the route. A call fails when the client can't
+// represent its arguments or sends a request the document rejects, when
+// the response's status or body isn't documented, when a 2xx response
+// doesn't decode into the client's types and when an error response isn't
+// the error envelope. Documented routes s doesn't register fail too;
+// registered routes the document leaves out are logged.
+func (c *Contract) Run(t *testing.T, s *Server) {
+	t.Helper()
+	spec := c.Spec
+	if spec == "" {
+		root, err := moduleRoot()
+		if err != nil {
+			t.Fatalf("testkit: %v", err)
+		}
+		spec = filepath.Join(root, "api", "openapi.yaml")
+	}
+	doc, err := openapi.Load(spec)
+	if err != nil {
+		t.Fatalf("testkit: %v", err)
+	}
+	missing := c.checkRoutes(t, s, doc)
+
+	rec := &exchangeRecorder{}
+	validator := openapi.NewValidator(doc, s.Container.Logger, s.Stats,
+		openapi.ValidateRequests(), openapi.ValidateResponses(), openapi.OnViolation(rec.violation))
+	srv := httptest.NewServer(rec.wrap(validator.Middleware(s.Config.Handler)))
+	t.Cleanup(srv.Close)
+	client := c.Client(srv.URL)
+
+	skip := map[string]bool{}
+	for _, route := range c.Skip {
+		skip[route] = true
+	}
+	for _, e := range doc.Endpoints() {
+		route := e.Route()
+		t.Run(route, func(t *testing.T) {
+			if skip[route] {
+				t.Skip("skipped by the contract")
+			}
+			if missing[route] {
+				t.Skip("not registered")
+			}
+			example, ok := c.Examples[route]
+			if !ok {
+				example = generateExample(e)
+			}
+			path, params, body, err := example.encode()
+			if err != nil {
+				t.Fatalf("failed to encode the example: %v", err)
+			}
+
+			rec.reset()
+			_, err = client.Invoke(context.Background(), route, path, params, body)
+			x := rec.last()
+			if x == nil {
+				t.Fatalf("client sent no request: %v", err)
+			}
+			// The client can return before the handler does
+			<-x.done
+			for _, v := range x.request {
+				t.Errorf("request violates the document: %s", v)
+			}
+			for _, v := range x.response {
+				t.Errorf("%d response violates the document: %s", x.status, v)
+			}
+			switch {
+			case example.Status != 0 && x.status != example.Status:
+				t.Errorf("got a %d response, want %d: %s", x.status, example.Status, x.body)
+			case x.status < 300 && err != nil:
+				t.Errorf("client failed on a %d response: %v", x.status, err)
+			case x.status >= 300 && err == nil:
+				t.Errorf("client accepted a %d response", x.status)
+			case x.status >= 400 && !isErrorEnvelope(x.body):
+				t.Errorf("%d response is not the error envelope: %s", x.status, x.body)
+			}
+		})
+	}
+}
+
+// checkRoutes compares the document with the routes s registers and
+// returns the documented routes that are missing. Path parameter names
+// may differ between the two.
+func (c *Contract) checkRoutes(t *testing.T, s *Server, doc *openapi.Document) map[string]bool {
+	t.Helper()
+	routes, err := s.Container.Middleware.Routes(s.Router)
+	if err != nil {
+		t.Fatalf("testkit: %v", err)
+	}
+	registered := map[string]bool{}
+	for _, r := range routes {
+		registered[routeShape(r.Method, r.Pattern)] = true
+	}
+	documented, missing := map[string]bool{}, map[string]bool{}
+	for _, e := range doc.Endpoints() {
+		shape := routeShape(e.Method, e.Path)
+		documented[shape] = true
+		if !registered[shape] {
+			t.Errorf("testkit: %s is documented but not registered", e.Route())
+			missing[e.Route()] = true
+		}
+	}
+	for _, r := range routes {
+		if !documented[routeShape(r.Method, r.Pattern)] && !strings.HasSuffix(r.Pattern, "/*") {
+			t.Logf("testkit: %s %s is registered but not documented", r.Method, r.Pattern)
+		}
+	}
+	return missing
+}
+
+// routeShape normalizes a route for comparison: "GET /users/{}"
+func routeShape(method, pattern string) string {
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			segments[i] = "{}"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+// generateExample builds arguments from the document: every path
+// parameter, the query and header parameters that are required or have
+// an example, and the body
+func generateExample(e *openapi.Endpoint) ContractExample {
+	example := ContractExample{Path: map[string]interface{}{}, Params: map[string]interface{}{}}
+	for _, p := range e.Parameters {
+		switch {
+		case p.In == "path":
+			example.Path[p.Name] = openapi.Example(p.Schema)
+		case p.Required || (p.Schema != nil && p.Schema.Example != nil):
+			example.Params[p.Name] = openapi.Example(p.Schema)
+		}
+	}
+	if b := e.Operation.RequestBody; b != nil {
+		if media := b.Content["application/json"]; media != nil {
+			example.Body = openapi.Example(media.Schema)
+		}
+	}
+	return example
+}
+
+func (e ContractExample) encode() (path, params map[string]json.RawMessage, body json.RawMessage, err error) {
+	encode := func(values map[string]interface{}) (map[string]json.RawMessage, error) {
+		out := make(map[string]json.RawMessage, len(values))
+		for name, v := range values {
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = raw
+		}
+		return out, nil
+	}
+	if path, err = encode(e.Path); err != nil {
+		return nil, nil, nil, err
+	}
+	if params, err = encode(e.Params); err != nil {
+		return nil, nil, nil, err
+	}
+	if e.Body != nil {
+		if body, err = json.Marshal(e.Body); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return path, params, body, nil
+}
+
+// isErrorEnvelope reports whether body is {"error": "...", ...}
+func isErrorEnvelope(body []byte) bool {
+	var envelope struct {
+		Error *string `json:"error"`
+	}
+	return json.Unmarshal(body, &envelope) == nil && envelope.Error != nil
+}
+
+// exchange is what the server saw of one call. Its fields are set once
+// done is closed.
+type exchange struct {
+	done     chan struct{}
+	status   int
+	body     []byte
+	request  []openapi.Violation
+	response []openapi.Violation
+}
+
+// exchangeRecorder keeps the latest exchange through the contract's server
+type exchangeRecorder struct {
+	mu      sync.Mutex
+	current *exchange
+}
+
+func (r *exchangeRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = nil
+}
+
+func (r *exchangeRecorder) last() *exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *exchangeRecorder) violation(_ *http.Request, side string, violations []openapi.Violation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return
+	}
+	if side == "request" {
+		r.current.request = append(r.current.request, violations...)
+	} else {
+		r.current.response = append(r.current.response, violations...)
+	}
+}
+
+func (r *exchangeRecorder) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		x := &exchange{done: make(chan struct{})}
+		defer close(x.done)
+		r.mu.Lock()
+		r.current = x
+		r.mu.Unlock()
+
+		rw := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, req)
+
+		x.status = rw.code
+		if x.status == 0 {
+			x.status = http.StatusOK
+		}
+		x.body = rw.body.Bytes()
+	})
+}
+
+// recordingWriter keeps a copy of the status and body
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
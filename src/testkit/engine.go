@@ -0,0 +1,298 @@
+package testkit
+
+import (
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Statement is a query or command run against a FakeEngine. Transactions
+// are recorded as BEGIN, COMMIT and ROLLBACK.
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+// FakeEngine is a storage.Engine that runs no SQL: queries are answered
+// from stubs and every statement is recorded. Unstubbed queries return no
+// rows, so QueryRow scans fail with sql.ErrNoRows, and unstubbed commands
+// affect no rows.
+type FakeEngine struct {
+	storage.Engine
+
+	mu         sync.Mutex
+	stubs      []*Stub
+	statements []Statement
+}
+
+// NewFakeEngine creates an engine with no stubs, logging nowhere and
+// recording metrics to stats if given
+func NewFakeEngine(stats *Stats) *FakeEngine {
+	if stats == nil {
+		stats = NewStats()
+	}
+	e := &FakeEngine{}
+	e.Engine = storage.NewEngineFromDB(sql.OpenDB(e), zap.NewNop(), stats)
+	return e
+}
+
+// Stub is the canned response to queries containing a fragment
+type Stub struct {
+	fragment string
+	columns  []string
+	rows     [][]driver.Value
+	result   driver.Result
+	err      error
+}
+
+// On stubs queries whose text contains fragment, ignoring differences in
+// whitespace. The most recent matching stub answers.
+func (e *FakeEngine) On(fragment string) *Stub {
+	s := &Stub{fragment: normalize(fragment), result: driver.RowsAffected(0)}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stubs = append(e.stubs, s)
+	return s
+}
+
+// Rows makes matching queries return rows of values for columns
+func (s *Stub) Rows(columns []string, rows ...[]interface{}) *Stub {
+	s.columns = columns
+	s.rows = s.rows[:0]
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			values[i] = driverValue(v)
+		}
+		s.rows = append(s.rows, values)
+	}
+	return s
+}
+
+// Result makes matching commands report lastInsertID and rowsAffected
+func (s *Stub) Result(lastInsertID, rowsAffected int64) *Stub {
+	s.result = result{lastInsertID, rowsAffected}
+	return s
+}
+
+// Error makes matching queries and commands fail with err
+func (s *Stub) Error(err error) *Stub {
+	s.err = err
+	return s
+}
+
+// Statements returns everything run so far, in order
+func (e *FakeEngine) Statements() []Statement {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Statement(nil), e.statements...)
+}
+
+// AssertRan fails the test unless a statement containing fragment ran
+func (e *FakeEngine) AssertRan(t testing.TB, fragment string) {
+	t.Helper()
+	fragment = normalize(fragment)
+	var ran []string
+	for _, st := range e.Statements() {
+		if strings.Contains(st.Query, fragment) {
+			return
+		}
+		ran = append(ran, st.Query)
+	}
+	t.Errorf("no statement containing %q ran (ran: %q)", fragment, ran)
+}
+
+func (e *FakeEngine) record(query string, args []driver.NamedValue) *Stub {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	query = normalize(query)
+	e.statements = append(e.statements, Statement{Query: query, Args: values})
+	for i := len(e.stubs) - 1; i >= 0; i-- {
+		if strings.Contains(query, e.stubs[i].fragment) {
+			return e.stubs[i]
+		}
+	}
+	return nil
+}
+
+func normalize(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// Connect implements driver.Connector
+func (e *FakeEngine) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{engine: e}, nil
+}
+
+// Driver implements driver.Connector
+func (e *FakeEngine) Driver() driver.Driver {
+	return fakeDriver{e}
+}
+
+type fakeDriver struct{ engine *FakeEngine }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{engine: d.engine}, nil
+}
+
+// fakeConn answers every statement from the engine's stubs
+type fakeConn struct {
+	engine *FakeEngine
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.engine.record("BEGIN", nil)
+	return fakeTx{c.engine}, nil
+}
+
+func (c *fakeConn) Ping(context.Context) error { return nil }
+
+// CheckNamedValue accepts arguments as given, so tests see what the code
+// passed rather than its driver encoding
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stub := c.engine.record(query, args)
+	if stub == nil {
+		return &fakeRows{}, nil
+	}
+	if stub.err != nil {
+		return nil, stub.err
+	}
+	return &fakeRows{columns: stub.columns, rows: stub.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	stub := c.engine.record(query, args)
+	if stub == nil {
+		return driver.RowsAffected(0), nil
+	}
+	if stub.err != nil {
+		return nil, stub.err
+	}
+	return stub.result, nil
+}
+
+type fakeTx struct{ engine *FakeEngine }
+
+func (tx fakeTx) Commit() error {
+	tx.engine.record("COMMIT", nil)
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.engine.record("ROLLBACK", nil)
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+type result struct{ lastInsertID, rowsAffected int64 }
+
+func (r result) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+// driverValue converts a stubbed column value to a type database/sql can
+// scan from
+func driverValue(v interface{}) driver.Value {
+	switch v := v.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time:
+		return v
+	case driver.Valuer:
+		out, err := v.Value()
+		if err != nil {
+			panic(fmt.Sprintf("testkit: stub value %v: %v", v, err))
+		}
+		return out
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return rv.Int()
+	case rv.CanUint():
+		return int64(rv.Uint())
+	case rv.CanFloat():
+		return rv.Float()
+	case rv.Kind() == reflect.String:
+		return rv.String()
+	case rv.Kind() == reflect.Bool:
+		return rv.Bool()
+	default:
+		panic(fmt.Sprintf("testkit: unsupported stub value %T", v))
+	}
+}
@@ -0,0 +1,61 @@
+package testkit
+
+import (
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Logs captures what a logger from NewLogger writes
+type Logs struct {
+	*observer.ObservedLogs
+}
+
+// NewLogger returns a logger that records every entry at debug level and
+// above
+func NewLogger() (*zap.Logger, *Logs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return zap.New(core), &Logs{logs}
+}
+
+// TestLogger is NewLogger that also writes to the test's log, so entries
+// show up when the test fails or runs with -v
+func TestLogger(t testing.TB) (*zap.Logger, *Logs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	output := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel)).Core()
+	return zap.New(zapcore.NewTee(core, output)), &Logs{logs}
+}
+
+// AssertLogged fails the test unless an entry at level contains msg
+func (l *Logs) AssertLogged(t testing.TB, level zapcore.Level, msg string) {
+	t.Helper()
+	for _, entry := range l.All() {
+		if entry.Level == level && strings.Contains(entry.Message, msg) {
+			return
+		}
+	}
+	t.Errorf("no %s log containing %q (logged: %v)", level, msg, l.messages())
+}
+
+// AssertNotLogged fails the test if any entry is at level or above, e.g.
+// zap.ErrorLevel for a request that should succeed quietly
+func (l *Logs) AssertNotLogged(t testing.TB, level zapcore.Level) {
+	t.Helper()
+	for _, entry := range l.All() {
+		if entry.Level >= level {
+			t.Errorf("unexpected %s log %q", entry.Level, entry.Message)
+		}
+	}
+}
+
+func (l *Logs) messages() []string {
+	var msgs []string
+	for _, entry := range l.All() {
+		msgs = append(msgs, entry.Level.String()+": "+entry.Message)
+	}
+	return msgs
+}
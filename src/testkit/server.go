@@ -0,0 +1,79 @@
+package testkit
+
+import (
+	"coffee-and-running/src/app"
+	"coffee-and-running/src/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// Server is the application behind an httptest server, with its logs and
+// metrics captured
+type Server struct {
+	*httptest.Server
+	Container *app.Container
+	// Router holds the public routes the server serves
+	Router chi.Router
+	Logs   *Logs
+	Stats  *Stats
+}
+
+// NewServer builds the application from cfg and modules as the service
+// does, runs its start and ready hooks and serves its public routes until
+// the test ends. Point cfg.Database at a Schema to isolate the test.
+func NewServer(t testing.TB, cfg *config.Config, modules ...app.Module) *Server {
+	t.Helper()
+	logger, logs := NewLogger()
+	stats := NewStats()
+
+	c, err := app.NewContainer(cfg, app.WithLogger(logger), app.WithStats(stats))
+	if err != nil {
+		t.Fatalf("testkit: failed to build container: %v", err)
+	}
+	if err := c.Install(modules...); err != nil {
+		t.Fatalf("testkit: failed to install modules: %v", err)
+	}
+	public, _, err := c.Routers()
+	if err != nil {
+		t.Fatalf("testkit: failed to build routers: %v", err)
+	}
+	stop, err := c.Start()
+	if err != nil {
+		t.Fatalf("testkit: failed to start: %v", err)
+	}
+
+	srv := httptest.NewServer(public)
+	t.Cleanup(func() {
+		srv.Close()
+		stop()
+	})
+	return &Server{Server: srv, Container: c, Router: public, Logs: logs, Stats: stats}
+}
+
+// Do sends a request with an optional JSON body to path and fails the test
+// if it can't be sent. The response body is closed when the test ends.
+func (s *Server) Do(t testing.TB, method, path, body string) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, s.URL+path, r)
+	if err != nil {
+		t.Fatalf("testkit: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("testkit: %s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
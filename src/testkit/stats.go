@@ -0,0 +1,141 @@
+package testkit
+
+import (
+	"coffee-and-running/src/observability/metrics"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// Stats is a metrics.Agent that records what it is sent
+type Stats struct {
+	mu      sync.Mutex
+	counts  map[string]int64
+	timings map[string]int
+	gauges  map[string]interface{}
+}
+
+var _ metrics.Agent = (*Stats)(nil)
+
+// NewStats creates an empty recorder
+func NewStats() *Stats {
+	return &Stats{
+		counts:  make(map[string]int64),
+		timings: make(map[string]int),
+		gauges:  make(map[string]interface{}),
+	}
+}
+
+// Increment implements metrics.Agent
+func (s *Stats) Increment(bucket string) {
+	s.Count(bucket, 1)
+}
+
+// Count implements metrics.Agent
+func (s *Stats) Count(bucket string, n interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[bucket] += toInt64(n)
+}
+
+// Timing implements metrics.Agent. Only the number of timings per bucket
+// is kept.
+func (s *Stats) Timing(bucket string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timings[bucket]++
+}
+
+// Gauge implements metrics.Agent
+func (s *Stats) Gauge(bucket string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gauges[bucket] = value
+}
+
+// Close implements metrics.Agent
+func (s *Stats) Close() {}
+
+// IsEnabled implements metrics.Agent
+func (s *Stats) IsEnabled() bool { return true }
+
+// Counter returns the total counted in bucket
+func (s *Stats) Counter(bucket string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[bucket]
+}
+
+// Timings returns the number of timings sent to bucket
+func (s *Stats) Timings(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.timings[bucket]
+}
+
+// GaugeValue returns the last value of a gauge and whether it was set
+func (s *Stats) GaugeValue(bucket string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.gauges[bucket]
+	return v, ok
+}
+
+// AssertCount fails the test unless bucket counted exactly want
+func (s *Stats) AssertCount(t testing.TB, bucket string, want int64) {
+	t.Helper()
+	if got := s.Counter(bucket); got != want {
+		t.Errorf("metric %s counted %d, want %d (recorded: %v)", bucket, got, want, s.buckets())
+	}
+}
+
+// AssertTimed fails the test unless bucket received at least one timing
+func (s *Stats) AssertTimed(t testing.TB, bucket string) {
+	t.Helper()
+	if s.Timings(bucket) == 0 {
+		t.Errorf("metric %s was not timed (recorded: %v)", bucket, s.buckets())
+	}
+}
+
+// Reset forgets everything recorded so far
+func (s *Stats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = make(map[string]int64)
+	s.timings = make(map[string]int)
+	s.gauges = make(map[string]interface{})
+}
+
+// buckets lists every bucket recorded, for failure messages
+func (s *Stats) buckets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.counts {
+		names = append(names, name)
+	}
+	for name := range s.timings {
+		names = append(names, name)
+	}
+	for name := range s.gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func toInt64(n interface{}) int64 {
+	v := reflect.ValueOf(n)
+	switch {
+	case v.CanInt():
+		return v.Int()
+	case v.CanUint():
+		return int64(v.Uint())
+	case v.CanFloat():
+		return int64(v.Float())
+	default:
+		panic(fmt.Sprintf("testkit: unsupported count type %T", n))
+	}
+}
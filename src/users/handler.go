@@ -0,0 +1,257 @@
+package users
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/auth/revocation"
+	"coffee-and-running/src/httpx"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// Handler exposes the account API
+type Handler struct {
+	service *Service
+	logger  *zap.Logger
+}
+
+// NewHandler creates the account handler
+func NewHandler(service *Service, logger *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		logger:  logger.With(zap.String("component", "users.handler")),
+	}
+}
+
+// Mount registers the /auth routes
+func (h *Handler) Mount(r chi.Router) {
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/register", h.register)
+		r.Post("/login", h.login)
+		r.Post("/verify-email", h.verifyEmail)
+		r.Post("/password/forgot", h.forgotPassword)
+		r.Post("/password/reset", h.resetPassword)
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Require)
+			r.Get("/me", h.me)
+			r.Post("/verify-email/resend", h.resendVerification)
+			if h.service.CanLogout() {
+				r.Post("/logout", h.logout)
+				r.Post("/logout-all", h.logoutAll)
+			}
+		})
+	})
+}
+
+type registerRequest struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+
+	u := &User{Email: req.Email, FirstName: req.FirstName, LastName: req.LastName}
+	err := h.service.Register(r.Context(), u, req.Password)
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrEmailTaken):
+		writeError(w, http.StatusConflict, "email is already registered")
+		return
+	case err != nil:
+		h.logger.Error("failed to register user", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to register")
+		return
+	}
+
+	httpx.Respond(w, r, http.StatusCreated, map[string]interface{}{"user": u})
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+
+	session, err := h.service.Login(r.Context(), req.Email, req.Password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		writeError(w, http.StatusUnauthorized, "invalid email or password")
+		return
+	case errors.Is(err, ErrNotVerified):
+		writeError(w, http.StatusForbidden, "email address has not been verified")
+		return
+	case errors.Is(err, ErrInactive):
+		writeError(w, http.StatusForbidden, "account is inactive")
+		return
+	case err != nil:
+		h.logger.Error("failed to log in", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to log in")
+		return
+	}
+
+	httpx.Respond(w, r, http.StatusOK, session)
+}
+
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h *Handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+
+	err := h.service.VerifyEmail(r.Context(), req.Token)
+	switch {
+	case errors.Is(err, ErrInvalidToken):
+		writeError(w, http.StatusBadRequest, "verification link is invalid or has expired")
+		return
+	case err != nil:
+		h.logger.Error("failed to verify email", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to verify email")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(auth.Subject(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid subject")
+		return
+	}
+
+	if err := h.service.ResendVerification(r.Context(), id); err != nil && !errors.Is(err, ErrNotFound) {
+		h.logger.Error("failed to resend verification", zap.Int("user_id", id), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to resend verification")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+
+	if err := h.service.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		h.logger.Error("failed to request password reset", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to request password reset")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "request body must be JSON")
+		return
+	}
+
+	err := h.service.ResetPassword(r.Context(), req.Token, req.Password)
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrInvalidToken):
+		writeError(w, http.StatusBadRequest, "reset link is invalid or has expired")
+		return
+	case err != nil:
+		h.logger.Error("failed to reset password", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to reset password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	err := h.service.Logout(r.Context(), auth.FromContext(r.Context()))
+	switch {
+	case errors.Is(err, revocation.ErrNoTokenID):
+		writeError(w, http.StatusBadRequest, "token cannot be revoked; use /auth/logout-all")
+		return
+	case err != nil:
+		h.logger.Error("failed to log out", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to log out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) logoutAll(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(auth.Subject(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid subject")
+		return
+	}
+
+	if err := h.service.LogoutEverywhere(r.Context(), id); err != nil {
+		h.logger.Error("failed to log out everywhere", zap.Int("user_id", id), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to log out everywhere")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) me(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(auth.Subject(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid subject")
+		return
+	}
+
+	u, err := h.service.Find(r.Context(), id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	case err != nil:
+		h.logger.Error("failed to load user", zap.Int("user_id", id), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to load user")
+		return
+	}
+
+	httpx.Respond(w, r, http.StatusOK, map[string]interface{}{"user": u})
+}
+
+// writeError responds with {"error": msg}; errors are JSON whatever the
+// request accepts
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,84 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// errMalformedHash is returned for stored hashes that are not argon2id
+var errMalformedHash = errors.New("users: malformed password hash")
+
+const (
+	saltLength = 16
+	keyLength  = 32
+)
+
+// Hasher hashes passwords with argon2id into PHC strings such as
+// "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>", so parameters can be
+// raised later without invalidating existing hashes
+type Hasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// NewHasher creates a hasher. memory is in KiB.
+func NewHasher(memory, iterations uint32, parallelism uint8) *Hasher {
+	return &Hasher{memory: memory, iterations: iterations, parallelism: parallelism}
+}
+
+// Hash returns the encoded hash of password with a random salt
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches encoded, and whether encoded
+// was made with weaker parameters than the hasher's and should be replaced
+func (h *Hasher) Verify(password, encoded string) (match, rehash bool, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errMalformedHash
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, errMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errMalformedHash
+	}
+
+	got := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false, nil
+	}
+
+	rehash = memory < h.memory || iterations < h.iterations || parallelism < h.parallelism
+	return true, rehash, nil
+}
@@ -0,0 +1,348 @@
+package users
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxPasswordLength bounds the work an attacker can force per login
+const maxPasswordLength = 256
+
+// Templates sent by the service; see src/mailer/templates
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+)
+
+// Sender delivers templated email. *mailer.Mailer satisfies it.
+type Sender interface {
+	SendTemplate(ctx context.Context, name string, to []string, data interface{}) error
+}
+
+// Revoker invalidates issued access tokens. *revocation.List satisfies it.
+type Revoker interface {
+	Revoke(ctx context.Context, claims auth.Claims) error
+	RevokeSubject(ctx context.Context, subject string) error
+}
+
+// ValidationError is returned for unacceptable registration input
+type ValidationError struct {
+	msg string
+}
+
+func (e *ValidationError) Error() string {
+	return "users: " + e.msg
+}
+
+func errInvalid(msg string) error {
+	return &ValidationError{msg: msg}
+}
+
+// Session is the result of a successful login
+type Session struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	User        *User     `json:"user"`
+}
+
+// Service implements registration, login, email verification and password
+// reset
+type Service struct {
+	config  *config.UsersConfig
+	repo    *Repository
+	hasher  *Hasher
+	tokens  *auth.JWT
+	revoker Revoker
+	sender  Sender
+	logger  *zap.Logger
+	stats   metrics.Agent
+
+	// dummyHash is verified against when the email is unknown, so failed
+	// logins take the same time whether or not the account exists
+	dummyHash string
+}
+
+// NewService creates the users service. sender may be nil when email is
+// disabled; verification and reset links are then only logged. revoker may
+// be nil when token revocation is disabled; tokens then stay valid until
+// they expire, even after logout or a password reset.
+func NewService(cfg *config.UsersConfig, repo *Repository, tokens *auth.JWT, revoker Revoker, sender Sender, logger *zap.Logger, stats metrics.Agent) (*Service, error) {
+	hasher := NewHasher(cfg.Argon2.Memory, cfg.Argon2.Iterations, cfg.Argon2.Parallelism)
+	dummyHash, err := hasher.Hash("dummy-password")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare password hasher: %w", err)
+	}
+
+	return &Service{
+		config:    cfg,
+		repo:      repo,
+		hasher:    hasher,
+		tokens:    tokens,
+		revoker:   revoker,
+		sender:    sender,
+		logger:    logger.With(zap.String("component", "users")),
+		stats:     stats,
+		dummyHash: dummyHash,
+	}, nil
+}
+
+// Register creates an account and sends the verification email
+func (s *Service) Register(ctx context.Context, u *User, password string) error {
+	addr, err := mail.ParseAddress(u.Email)
+	if err != nil || addr.Address != u.Email {
+		return errInvalid("email is invalid")
+	}
+	if err := s.validatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Create(ctx, u, hash); err != nil {
+		return err
+	}
+	s.stats.Increment("users.registered")
+
+	s.sendToken(ctx, u, PurposeVerifyEmail, TemplateVerifyEmail, s.config.VerificationTTL, "/verify-email")
+	return nil
+}
+
+// Login checks credentials and issues an access token
+func (s *Service) Login(ctx context.Context, email, password string) (*Session, error) {
+	if len(password) > maxPasswordLength {
+		return nil, ErrInvalidCredentials
+	}
+
+	u, err := s.repo.FindByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		s.hasher.Verify(password, s.dummyHash)
+		s.stats.Increment("users.login.failed")
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	match, rehash, err := s.hasher.Verify(password, u.passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password for user %d: %w", u.ID, err)
+	}
+	if !match {
+		s.stats.Increment("users.login.failed")
+		return nil, ErrInvalidCredentials
+	}
+	if !u.IsActive {
+		return nil, ErrInactive
+	}
+	if s.config.RequireVerification && !u.Verified() {
+		return nil, ErrNotVerified
+	}
+
+	if rehash {
+		// Upgrade to the current parameters while the plaintext is at hand
+		if hash, err := s.hasher.Hash(password); err == nil {
+			if err := s.repo.UpdatePassword(ctx, u.ID, hash); err != nil {
+				s.logger.Warn("Failed to rehash password", zap.Int("user_id", u.ID), zap.Error(err))
+			}
+		}
+	}
+
+	token, expires, err := s.tokens.Issue(strconv.Itoa(u.ID), auth.Claims{
+		"email":          u.Email,
+		"email_verified": u.Verified(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.stats.Increment("users.login.success")
+	return &Session{AccessToken: token, TokenType: "Bearer", ExpiresAt: expires, User: u}, nil
+}
+
+// VerifyEmail consumes a verification token
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.repo.ConsumeToken(ctx, PurposeVerifyEmail, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if err := s.repo.MarkVerified(ctx, userID); err != nil {
+		return err
+	}
+	s.stats.Increment("users.verified")
+	return nil
+}
+
+// ResendVerification sends a new verification email to an unverified user
+func (s *Service) ResendVerification(ctx context.Context, userID int) error {
+	u, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u.Verified() {
+		return nil
+	}
+	s.sendToken(ctx, u, PurposeVerifyEmail, TemplateVerifyEmail, s.config.VerificationTTL, "/verify-email")
+	return nil
+}
+
+// RequestPasswordReset emails a reset link. It succeeds for unknown
+// emails too, so the response doesn't reveal which accounts exist.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	u, err := s.repo.FindByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !u.IsActive {
+		return nil
+	}
+
+	s.sendToken(ctx, u, PurposeResetPassword, TemplateResetPassword, s.config.ResetTTL, "/reset-password")
+	return nil
+}
+
+// ResetPassword consumes a reset token and sets a new password. Other
+// outstanding reset links for the user stop working.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+	if err := s.validatePassword(password); err != nil {
+		return err
+	}
+
+	userID, err := s.repo.ConsumeToken(ctx, PurposeResetPassword, hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdatePassword(ctx, userID, hash); err != nil {
+		return err
+	}
+	if err := s.repo.RevokeTokens(ctx, userID, PurposeResetPassword); err != nil {
+		s.logger.Warn("Failed to revoke reset tokens", zap.Int("user_id", userID), zap.Error(err))
+	}
+
+	// A reset link proves control of the mailbox
+	if err := s.repo.MarkVerified(ctx, userID); err != nil {
+		return err
+	}
+	// Sessions opened with the old password may be an attacker's
+	if s.revoker != nil {
+		if err := s.revoker.RevokeSubject(ctx, strconv.Itoa(userID)); err != nil {
+			s.logger.Error("Failed to revoke sessions after password reset", zap.Int("user_id", userID), zap.Error(err))
+		}
+	}
+	s.stats.Increment("users.password_reset")
+	return nil
+}
+
+// CanLogout reports whether tokens can be revoked
+func (s *Service) CanLogout() bool {
+	return s.revoker != nil
+}
+
+// Logout revokes the caller's access token
+func (s *Service) Logout(ctx context.Context, claims auth.Claims) error {
+	if err := s.revoker.Revoke(ctx, claims); err != nil {
+		return err
+	}
+	s.stats.Increment("users.logout")
+	return nil
+}
+
+// LogoutEverywhere revokes every access token issued to the user
+func (s *Service) LogoutEverywhere(ctx context.Context, id int) error {
+	if err := s.revoker.RevokeSubject(ctx, strconv.Itoa(id)); err != nil {
+		return err
+	}
+	s.stats.Increment("users.logout_everywhere")
+	return nil
+}
+
+// Find loads a user by ID
+func (s *Service) Find(ctx context.Context, id int) (*User, error) {
+	return s.repo.FindByID(ctx, id)
+}
+
+func (s *Service) validatePassword(password string) error {
+	if len(password) < s.config.MinPasswordLength {
+		return errInvalid(fmt.Sprintf("password must be at least %d characters", s.config.MinPasswordLength))
+	}
+	if len(password) > maxPasswordLength {
+		return errInvalid(fmt.Sprintf("password must be at most %d characters", maxPasswordLength))
+	}
+	return nil
+}
+
+// sendToken creates a one-time token and emails a link to it. Failures are
+// logged rather than returned: the account change has already happened
+// and the user can ask for another link.
+func (s *Service) sendToken(ctx context.Context, u *User, purpose, template string, ttl time.Duration, path string) {
+	token, err := newToken()
+	if err != nil {
+		s.logger.Error("Failed to generate token", zap.String("purpose", purpose), zap.Error(err))
+		return
+	}
+	if err := s.repo.CreateToken(ctx, u.ID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		s.logger.Error("Failed to store token", zap.String("purpose", purpose), zap.Int("user_id", u.ID), zap.Error(err))
+		return
+	}
+
+	link := s.config.BaseURL + path + "?token=" + url.QueryEscape(token)
+	if s.sender == nil {
+		s.logger.Warn("Email is disabled; not sending link",
+			zap.String("purpose", purpose), zap.Int("user_id", u.ID), zap.String("link", link))
+		return
+	}
+
+	name := u.FirstName
+	if name == "" {
+		name = u.Email
+	}
+	err = s.sender.SendTemplate(ctx, template, []string{u.Email}, map[string]string{
+		"Name":    name,
+		"AppName": s.config.AppName,
+		"Link":    link,
+		"Expires": ttl.String(),
+	})
+	if err != nil {
+		s.logger.Error("Failed to send email", zap.String("template", template), zap.Int("user_id", u.ID), zap.Error(err))
+	}
+}
+
+// newToken returns 32 random bytes, URL-safe encoded
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the stored form of a token. Tokens carry 256 bits of
+// entropy, so a plain SHA-256 is enough to keep a database leak from
+// yielding usable links.
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,166 @@
+package users
+
+import (
+	"coffee-and-running/src/apperr"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is returned when no user matches
+	ErrNotFound error = apperr.New(apperr.NotFound, "users", "user not found")
+	// ErrEmailTaken is returned when registering an existing email
+	ErrEmailTaken error = apperr.New(apperr.Conflict, "users", "email already registered")
+	// ErrInvalidCredentials is returned for unknown emails and wrong
+	// passwords alike, so login responses don't reveal which accounts exist
+	ErrInvalidCredentials error = apperr.New(apperr.Unauthorized, "users", "invalid email or password")
+	// ErrInvalidToken is returned for unknown, used or expired tokens
+	ErrInvalidToken error = apperr.New(apperr.Invalid, "users", "invalid or expired token")
+	// ErrNotVerified is returned at login when verification is required
+	ErrNotVerified = errors.New("users: email not verified")
+	// ErrInactive is returned at login for deactivated accounts
+	ErrInactive = errors.New("users: account is inactive")
+)
+
+// Token purposes
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+// User is an account in the users table
+type User struct {
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"first_name"`
+	LastName        string     `json:"last_name"`
+	IsActive        bool       `json:"is_active"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	passwordHash string
+}
+
+// Verified reports whether the user's email has been confirmed
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// Repository reads and writes users and their one-time tokens
+type Repository struct {
+	engine storage.Engine
+}
+
+// NewRepository creates a repository backed by engine
+func NewRepository(engine storage.Engine) *Repository {
+	return &Repository{engine: engine}
+}
+
+const userColumns = `id, email, password_hash, COALESCE(first_name, ''), COALESCE(last_name, ''),
+	COALESCE(is_active, true), email_verified_at, created_at, updated_at`
+
+// Create inserts u and fills in its ID and timestamps
+func (r *Repository) Create(ctx context.Context, u *User, passwordHash string) error {
+	err := r.engine.QueryRow(ctx,
+		`INSERT INTO users (email, password_hash, first_name, last_name, password_changed_at)
+		 VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NOW())
+		 RETURNING id, is_active, created_at, updated_at`,
+		normalizeEmail(u.Email), passwordHash, u.FirstName, u.LastName).
+		Scan(&u.ID, &u.IsActive, &u.CreatedAt, &u.UpdatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	u.Email = normalizeEmail(u.Email)
+	u.passwordHash = passwordHash
+	return nil
+}
+
+// FindByID loads a user by ID
+func (r *Repository) FindByID(ctx context.Context, id int) (*User, error) {
+	return r.scan(r.engine.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+// FindByEmail loads a user by email, case-insensitively
+func (r *Repository) FindByEmail(ctx context.Context, email string) (*User, error) {
+	return r.scan(r.engine.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, normalizeEmail(email)))
+}
+
+func (r *Repository) scan(row *sql.Row) (*User, error) {
+	var u User
+	var verifiedAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Email, &u.passwordHash, &u.FirstName, &u.LastName,
+		&u.IsActive, &verifiedAt, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if verifiedAt.Valid {
+		u.EmailVerifiedAt = &verifiedAt.Time
+	}
+	return &u, nil
+}
+
+// UpdatePassword replaces the user's password hash
+func (r *Repository) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	_, err := r.engine.Exec(ctx,
+		`UPDATE users SET password_hash = $2, password_changed_at = NOW(), updated_at = NOW() WHERE id = $1`,
+		id, passwordHash)
+	return err
+}
+
+// MarkVerified records that the user's email has been confirmed
+func (r *Repository) MarkVerified(ctx context.Context, id int) error {
+	_, err := r.engine.Exec(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1`,
+		id)
+	return err
+}
+
+// CreateToken stores the hash of a one-time token
+func (r *Repository) CreateToken(ctx context.Context, userID int, purpose string, tokenHash []byte, expiresAt time.Time) error {
+	_, err := r.engine.Exec(ctx,
+		`INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, purpose, tokenHash, expiresAt)
+	return err
+}
+
+// ConsumeToken marks a valid token as used and returns its user. Each token
+// can be consumed once, even under concurrent requests.
+func (r *Repository) ConsumeToken(ctx context.Context, purpose string, tokenHash []byte) (int, error) {
+	var userID int
+	err := r.engine.QueryRow(ctx,
+		`UPDATE user_tokens SET used_at = NOW()
+		 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id`,
+		tokenHash, purpose).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	return userID, err
+}
+
+// RevokeTokens invalidates the user's outstanding tokens for purpose
+func (r *Repository) RevokeTokens(ctx context.Context, userID int, purpose string) error {
+	_, err := r.engine.Exec(ctx,
+		`UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, purpose)
+	return err
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
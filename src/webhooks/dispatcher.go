@@ -0,0 +1,384 @@
+package webhooks
+
+import (
+	"bytes"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/observability/metrics"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxErrorLength bounds the reason stored with a failed attempt, and
+// maxExcerptLength the part of a receiver's response it quotes
+const (
+	maxErrorLength   = 1024
+	maxExcerptLength = 128
+)
+
+// Dispatcher delivers queued webhooks in the background. Deliveries are
+// claimed with FOR UPDATE SKIP LOCKED, so any number of replicas can run a
+// dispatcher against the same database.
+type Dispatcher struct {
+	config *config.WebhooksConfig
+	engine storage.Engine
+	client *http.Client
+	logger *zap.Logger
+	stats  metrics.Agent
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// claimed is a delivery leased by this dispatcher
+type claimed struct {
+	id         int64
+	endpointID int64
+	eventType  string
+	payload    []byte
+	attempts   int
+}
+
+// NewDispatcher creates a dispatcher. client should not retry on its own,
+// since the dispatcher schedules retries itself, and should only reach
+// public addresses, as httpclient.Factory.PublicClient's do.
+func NewDispatcher(cfg *config.WebhooksConfig, engine storage.Engine, client *http.Client, logger *zap.Logger, stats metrics.Agent) *Dispatcher {
+	return &Dispatcher{
+		config: cfg,
+		engine: engine,
+		client: client,
+		logger: logger.With(zap.String("component", "webhooks.dispatcher")),
+		stats:  stats,
+	}
+}
+
+// Start begins polling for due deliveries
+func (d *Dispatcher) Start() {
+	d.ctx, d.cancel = context.WithCancel(storage.WithPartition(context.Background(), storage.Background))
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.config.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-ticker.C:
+				d.poll()
+			}
+		}
+	}()
+
+	d.logger.Info("webhook dispatcher started",
+		zap.Int("workers", d.config.Workers),
+		zap.Duration("poll_interval", d.config.PollInterval))
+}
+
+// Close stops polling and waits for in-flight deliveries to finish. They
+// run on their own contexts, so they aren't cut short; deliveries claimed
+// but not yet started are left for their lease to expire.
+func (d *Dispatcher) Close() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	d.logger.Info("webhook dispatcher stopped")
+}
+
+// poll claims a batch of due deliveries and sends them with bounded concurrency
+func (d *Dispatcher) poll() {
+	batch, err := d.claim(d.ctx)
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.logger.Error("failed to claim webhook deliveries", zap.Error(err))
+			d.stats.Increment("webhooks.claim.error")
+		}
+		return
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	sem := make(chan struct{}, d.config.Workers)
+	var wg sync.WaitGroup
+	for _, delivery := range batch {
+		sem <- struct{}{}
+		if d.ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(delivery claimed) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			d.deliver(delivery)
+		}(delivery)
+	}
+	wg.Wait()
+}
+
+// claim leases due deliveries by pushing next_attempt_at past the request
+// timeout; a crashed dispatcher's lease simply expires
+func (d *Dispatcher) claim(ctx context.Context) ([]claimed, error) {
+	lease := d.lease()
+	rows, err := d.engine.Query(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		 WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING id, endpoint_id, event_type, payload, attempts`,
+		d.config.BatchSize, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		if err := rows.Scan(&c.id, &c.endpointID, &c.eventType, &c.payload, &c.attempts); err != nil {
+			return nil, fmt.Errorf("failed to scan claimed delivery: %w", err)
+		}
+		batch = append(batch, c)
+	}
+	return batch, rows.Err()
+}
+
+// lease is how long a claimed delivery is reserved for one attempt
+func (d *Dispatcher) lease() time.Duration {
+	return 2 * d.config.RequestTimeout
+}
+
+// deliver sends one delivery and records the outcome, within the lease
+// claim took. Its context is detached from the poll loop's, so Close
+// lets it finish.
+func (d *Dispatcher) deliver(c claimed) {
+	ctx, cancel := context.WithTimeout(storage.WithPartition(context.Background(), storage.Background), d.lease())
+	defer cancel()
+	logger := d.logger.With(
+		zap.Int64("delivery_id", c.id),
+		zap.Int64("endpoint_id", c.endpointID),
+		zap.String("event_type", c.eventType),
+	)
+
+	url, secrets, err := d.endpointTarget(ctx, c.endpointID)
+	if err != nil {
+		logger.Error("failed to load webhook endpoint", zap.Error(err))
+		d.recordFailure(ctx, logger, c, 0, err.Error())
+		return
+	}
+
+	start := time.Now()
+	status, body, err := d.send(ctx, c, url, secrets)
+	d.stats.Timing("webhooks.delivery.duration", time.Since(start))
+
+	switch {
+	case err != nil:
+		d.recordFailure(ctx, logger, c, 0, err.Error())
+	case status < 200 || status >= 300:
+		d.recordFailure(ctx, logger, c, status, body)
+	default:
+		d.recordSuccess(ctx, logger, c, status)
+	}
+}
+
+// endpointTarget returns the endpoint URL and its currently valid secrets
+func (d *Dispatcher) endpointTarget(ctx context.Context, endpointID int64) (string, []string, error) {
+	rows, err := d.engine.Query(ctx,
+		`SELECT e.url, s.secret FROM webhook_endpoints e
+		 JOIN webhook_secrets s ON s.endpoint_id = e.id
+		 WHERE e.id = $1 AND e.is_active AND (s.expires_at IS NULL OR s.expires_at > NOW())
+		 ORDER BY s.id DESC`,
+		endpointID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	var url string
+	var secrets []string
+	for rows.Next() {
+		var secret string
+		if err := rows.Scan(&url, &secret); err != nil {
+			return "", nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+	if url == "" {
+		return "", nil, fmt.Errorf("endpoint %d is inactive or has no valid secret", endpointID)
+	}
+	return url, secrets, nil
+}
+
+// send POSTs the signed payload and returns the status and an excerpt of
+// the body
+func (d *Dispatcher) send(ctx context.Context, c claimed, url string, secrets []string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(c.payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "webhooks-dispatcher/1.0")
+	req.Header.Set("Webhook-ID", strconv.FormatInt(c.id, 10))
+	req.Header.Set("Webhook-Event", c.eventType)
+	req.Header.Set("Webhook-Attempt", strconv.Itoa(c.attempts+1))
+	req.Header.Set(SignatureHeader, Sign(secrets, time.Now(), c.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxExcerptLength))
+	return resp.StatusCode, excerpt(body), nil
+}
+
+// excerpt keeps the printable ASCII of a receiver's response, which is
+// stored and shown to the tenant, without control characters or binary data
+func excerpt(body []byte) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return ' '
+		}
+		return r
+	}, string(body)))
+}
+
+// recordSuccess marks the delivery as delivered
+func (d *Dispatcher) recordSuccess(ctx context.Context, logger *zap.Logger, c claimed, status int) {
+	_, err := d.engine.Exec(ctx,
+		`UPDATE webhook_deliveries
+		 SET status = 'delivered', attempts = attempts + 1, last_status_code = $2,
+		     last_error = NULL, delivered_at = NOW()
+		 WHERE id = $1`,
+		c.id, status)
+	if err != nil {
+		logger.Error("failed to mark webhook delivered", zap.Error(err))
+		return
+	}
+
+	logger.Debug("webhook delivered", zap.Int("status", status), zap.Int("attempt", c.attempts+1))
+	d.stats.Increment("webhooks.delivery.success")
+}
+
+// recordFailure schedules the next attempt with exponential backoff, or
+// dead-letters the delivery once attempts are exhausted
+func (d *Dispatcher) recordFailure(ctx context.Context, logger *zap.Logger, c claimed, status int, reason string) {
+	attempts := c.attempts + 1
+	var statusCode sql.NullInt64
+	if status > 0 {
+		statusCode = sql.NullInt64{Int64: int64(status), Valid: true}
+	}
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+
+	d.stats.Increment("webhooks.delivery.failure")
+
+	if attempts >= d.config.MaxAttempts {
+		d.deadLetter(ctx, logger, c, attempts, statusCode, reason)
+		return
+	}
+
+	wait := d.backoff(attempts)
+	_, err := d.engine.Exec(ctx,
+		`UPDATE webhook_deliveries
+		 SET attempts = $2, last_status_code = $3, last_error = $4,
+		     next_attempt_at = NOW() + $5 * INTERVAL '1 millisecond'
+		 WHERE id = $1`,
+		c.id, attempts, statusCode, reason, wait.Milliseconds())
+	if err != nil {
+		logger.Error("failed to reschedule webhook delivery", zap.Error(err))
+		return
+	}
+
+	logger.Warn("webhook delivery failed, retry scheduled",
+		zap.Int("attempt", attempts),
+		zap.Int("status", status),
+		zap.Duration("backoff", wait),
+		zap.String("reason", reason))
+}
+
+// deadLetter marks the delivery dead and copies it to webhook_dead_letters
+func (d *Dispatcher) deadLetter(ctx context.Context, logger *zap.Logger, c claimed, attempts int, statusCode sql.NullInt64, reason string) {
+	tx, err := d.engine.Begin(ctx)
+	if err != nil {
+		logger.Error("failed to begin dead-letter transaction", zap.Error(err))
+		return
+	}
+
+	// Use a variable to track if we should rollback
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE webhook_deliveries SET status = 'dead', attempts = $2, last_status_code = $3, last_error = $4
+		 WHERE id = $1`,
+		c.id, attempts, statusCode, reason); err != nil {
+		logger.Error("failed to mark webhook delivery dead", zap.Error(err))
+		return
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO webhook_dead_letters
+		 (delivery_id, endpoint_id, event_type, payload, attempts, last_status_code, last_error)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		c.id, c.endpointID, c.eventType, c.payload, attempts, statusCode, reason); err != nil {
+		logger.Error("failed to insert webhook dead letter", zap.Error(err))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("failed to commit webhook dead letter", zap.Error(err))
+		return
+	}
+	committed = true
+
+	logger.Error("webhook delivery dead-lettered",
+		zap.Int("attempts", attempts),
+		zap.String("reason", reason))
+	d.stats.Increment("webhooks.delivery.dead_letter")
+}
+
+// backoff returns the exponential delay (with jitter) before attempt+1
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.config.InitialBackoff << (attempts - 1)
+	if wait <= 0 || wait > d.config.MaxBackoff {
+		wait = d.config.MaxBackoff
+	}
+	// +/- 20% jitter spreads retries from many deliveries that failed together
+	jitter := time.Duration(rand.Int63n(int64(wait)/5+1)) - wait/10
+	return wait + jitter
+}
@@ -0,0 +1,241 @@
+package webhooks
+
+import (
+	"coffee-and-running/src/auth"
+	"coffee-and-running/src/tenancy"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+// TenantFunc resolves the calling tenant from a request
+type TenantFunc func(r *http.Request) string
+
+// DefaultTenantFunc uses the tenant resolved by the tenancy middleware.
+// Request headers are never read directly, since any client can set them.
+func DefaultTenantFunc(r *http.Request) string {
+	tenant, _ := tenancy.FromContext(r.Context())
+	return tenant
+}
+
+// Handler exposes endpoint management and delivery-status APIs
+type Handler struct {
+	service *Service
+	tenant  TenantFunc
+	logger  *zap.Logger
+}
+
+// NewHandler creates the webhook HTTP handler. tenant may be nil to use
+// DefaultTenantFunc.
+func NewHandler(service *Service, tenant TenantFunc, logger *zap.Logger) *Handler {
+	if tenant == nil {
+		tenant = DefaultTenantFunc
+	}
+	return &Handler{
+		service: service,
+		tenant:  tenant,
+		logger:  logger.With(zap.String("component", "webhooks.handler")),
+	}
+}
+
+// Mount registers the webhook routes under /webhooks. Every route needs
+// an authenticated caller, so the auth middleware must run before them.
+func (h *Handler) Mount(r chi.Router) {
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(auth.Require)
+		r.Post("/endpoints", h.registerEndpoint)
+		r.Get("/endpoints", h.listEndpoints)
+		r.Post("/endpoints/{id}/rotate-secret", h.rotateSecret)
+		r.Get("/endpoints/{id}/deliveries", h.listDeliveries)
+		r.Get("/deliveries/{id}", h.getDelivery)
+		r.Post("/deliveries/{id}/retry", h.retryDelivery)
+	})
+}
+
+type registerRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type registerResponse struct {
+	Endpoint *Endpoint `json:"endpoint"`
+	Secret   string    `json:"secret"`
+}
+
+func (h *Handler) registerEndpoint(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeError(w, http.StatusBadRequest, "request body must be JSON with a url")
+		return
+	}
+
+	endpoint, secret, err := h.service.RegisterEndpoint(r.Context(), tenantID, req.URL, req.EventTypes)
+	if errors.Is(err, ErrInvalidURL) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.internalError(w, "failed to register webhook endpoint", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, registerResponse{Endpoint: endpoint, Secret: secret})
+}
+
+func (h *Handler) listEndpoints(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+
+	endpoints, err := h.service.ListEndpoints(r.Context(), tenantID)
+	if err != nil {
+		h.internalError(w, "failed to list webhook endpoints", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"endpoints": endpoints})
+}
+
+func (h *Handler) rotateSecret(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := h.service.RotateSecret(r.Context(), tenantID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "endpoint not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "failed to rotate webhook secret", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"secret": secret})
+}
+
+func (h *Handler) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.service.ListDeliveries(r.Context(), tenantID, id, limit)
+	if err != nil {
+		h.internalError(w, "failed to list webhook deliveries", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deliveries": deliveries})
+}
+
+func (h *Handler) getDelivery(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.service.GetDelivery(r.Context(), tenantID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "failed to get webhook delivery", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func (h *Handler) retryDelivery(w http.ResponseWriter, r *http.Request) {
+	tenantID, ok := h.requireTenant(w, r)
+	if !ok {
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.RetryDelivery(r.Context(), tenantID, id)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "delivery not found or already delivered")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "failed to retry webhook delivery", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireTenant resolves the tenant or responds 403
+func (h *Handler) requireTenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tenantID := h.tenant(r)
+	if tenantID == "" {
+		writeError(w, http.StatusForbidden, "tenant not identified")
+		return "", false
+	}
+	return tenantID, true
+}
+
+// internalError logs err and responds 500 without leaking details
+func (h *Handler) internalError(w http.ResponseWriter, msg string, err error) {
+	h.logger.Error(msg, zap.Error(err))
+	writeError(w, http.StatusInternalServerError, msg)
+}
+
+// pathID parses the {id} URL parameter or responds 400
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
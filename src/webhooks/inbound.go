@@ -0,0 +1,295 @@
+package webhooks
+
+import (
+	"bytes"
+	"coffee-and-running/src/observability/metrics"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Inbound verification errors
+var (
+	ErrMissingSignature = errors.New("webhooks: missing signature")
+	ErrInvalidSignature = errors.New("webhooks: invalid signature")
+	ErrStaleTimestamp   = errors.New("webhooks: timestamp outside tolerance")
+	ErrReplayed         = errors.New("webhooks: request replayed")
+)
+
+// DefaultTolerance is the accepted clock skew for timestamped signatures
+const DefaultTolerance = 5 * time.Minute
+
+// defaultMaxBody bounds the body read for verification
+const defaultMaxBody = 1 << 20 // 1 MB
+
+// Verifier checks the signature of an inbound webhook request. body is the
+// raw request body; implementations must not consume r.Body.
+type Verifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// ReplayCache remembers signatures or delivery IDs already accepted
+type ReplayCache interface {
+	// Seen records key and reports whether it was already present
+	Seen(key string, ttl time.Duration) bool
+}
+
+// TimestampedVerifier verifies "t=<unix>,v1=<hex>" signatures computed as
+// HMAC-SHA256(secret, "<t>.<body>"). This is Stripe's scheme and the one
+// used by this kit's own Dispatcher.
+type TimestampedVerifier struct {
+	Header    string        // e.g. "Stripe-Signature" or SignatureHeader
+	Secrets   []string      // every secret currently valid, to support rotation
+	Tolerance time.Duration // defaults to DefaultTolerance
+	Replay    ReplayCache   // optional
+}
+
+// NewStripeVerifier verifies Stripe's Stripe-Signature header
+func NewStripeVerifier(secrets []string, replay ReplayCache) *TimestampedVerifier {
+	return &TimestampedVerifier{Header: "Stripe-Signature", Secrets: secrets, Replay: replay}
+}
+
+// NewKitVerifier verifies webhooks sent by another service's Dispatcher
+func NewKitVerifier(secrets []string, replay ReplayCache) *TimestampedVerifier {
+	return &TimestampedVerifier{Header: SignatureHeader, Secrets: secrets, Replay: replay}
+}
+
+// Verify implements Verifier
+func (v *TimestampedVerifier) Verify(r *http.Request, body []byte) error {
+	header := r.Header.Get(v.Header)
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrMissingSignature
+	}
+
+	if err := checkTimestamp(timestamp, v.tolerance(), time.Now()); err != nil {
+		return err
+	}
+
+	matched, ok := anyMatch(v.Secrets, signatures, func(secret string) string {
+		return computeMAC(secret, timestamp, body)
+	})
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	// Keyed on the signature that verified, since the others in the
+	// header are the sender's to choose
+	return checkReplay(v.Replay, v.Header+":"+timestamp+":"+matched, 2*v.tolerance())
+}
+
+func (v *TimestampedVerifier) tolerance() time.Duration {
+	if v.Tolerance > 0 {
+		return v.Tolerance
+	}
+	return DefaultTolerance
+}
+
+// GitHubVerifier verifies GitHub's X-Hub-Signature-256 header. GitHub signs
+// only the body, with no timestamp, so there is no freshness guarantee: a
+// captured request verifies forever. The replay cache rejects a repeated
+// signature, which is to say a repeated body, within ReplayTTL; the
+// X-GitHub-Delivery header isn't signed, so it can't be trusted to tell
+// requests apart. Redeliveries from GitHub repeat the body and are
+// rejected within the TTL too.
+type GitHubVerifier struct {
+	Secrets   []string
+	Replay    ReplayCache   // optional
+	ReplayTTL time.Duration // defaults to 24h
+}
+
+// Verify implements Verifier
+func (v *GitHubVerifier) Verify(r *http.Request, body []byte) error {
+	header := r.Header.Get("X-Hub-Signature-256")
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok || signature == "" {
+		return ErrMissingSignature
+	}
+
+	if _, ok := anyMatch(v.Secrets, []string{signature}, func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}); !ok {
+		return ErrInvalidSignature
+	}
+
+	ttl := v.ReplayTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return checkReplay(v.Replay, "github:"+signature, ttl)
+}
+
+// SlackVerifier verifies Slack's X-Slack-Signature header, computed as
+// "v0=" + HMAC-SHA256(secret, "v0:<timestamp>:<body>")
+type SlackVerifier struct {
+	Secrets   []string
+	Tolerance time.Duration // defaults to DefaultTolerance
+	Replay    ReplayCache   // optional
+}
+
+// Verify implements Verifier
+func (v *SlackVerifier) Verify(r *http.Request, body []byte) error {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Slack-Signature"), "v0=")
+	if timestamp == "" || !ok || signature == "" {
+		return ErrMissingSignature
+	}
+
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if err := checkTimestamp(timestamp, tolerance, time.Now()); err != nil {
+		return err
+	}
+
+	if _, ok := anyMatch(v.Secrets, []string{signature}, func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "v0:%s:", timestamp)
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}); !ok {
+		return ErrInvalidSignature
+	}
+
+	return checkReplay(v.Replay, "slack:"+timestamp+":"+signature, 2*tolerance)
+}
+
+// VerifyMiddleware rejects requests whose signature doesn't verify with 401.
+// The body is buffered (up to maxBody bytes) and restored for the handler.
+func VerifyMiddleware(v Verifier, maxBody int64, logger *zap.Logger, stats metrics.Agent) func(http.Handler) http.Handler {
+	if maxBody <= 0 {
+		maxBody = defaultMaxBody
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "failed to read body", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > maxBody {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			if err := v.Verify(r, body); err != nil {
+				logger.Warn("inbound webhook rejected",
+					zap.String("path", r.URL.Path),
+					zap.String("remote_addr", r.RemoteAddr),
+					zap.Error(err))
+				stats.Increment("webhooks.inbound.rejected")
+				http.Error(w, "invalid webhook signature", http.StatusUnauthorized)
+				return
+			}
+
+			stats.Increment("webhooks.inbound.verified")
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// checkTimestamp rejects unix timestamps further than tolerance from now
+func checkTimestamp(value string, tolerance time.Duration, now time.Time) error {
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	skew := now.Sub(time.Unix(secs, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > tolerance {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+// anyMatch returns the provided signature that equals the expected MAC for
+// any secret, comparing in constant time, and whether there is one
+func anyMatch(secrets, signatures []string, expected func(secret string) string) (string, bool) {
+	for _, secret := range secrets {
+		want := []byte(expected(secret))
+		for _, signature := range signatures {
+			if hmac.Equal(want, []byte(signature)) {
+				return signature, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkReplay fails if key was already accepted
+func checkReplay(cache ReplayCache, key string, ttl time.Duration) error {
+	if cache != nil && cache.Seen(key, ttl) {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// MemoryReplayCache is an in-process ReplayCache. Use a shared store (e.g.
+// Redis) when several replicas receive the same webhooks.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	sweep   time.Time
+}
+
+// NewMemoryReplayCache creates an empty in-memory replay cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: make(map[string]time.Time)}
+}
+
+// Seen implements ReplayCache
+func (c *MemoryReplayCache) Seen(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.sweep) {
+		for k, expires := range c.entries {
+			if now.After(expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweep = now.Add(time.Minute)
+	}
+
+	if expires, ok := c.entries[key]; ok && now.Before(expires) {
+		return true
+	}
+	c.entries[key] = now.Add(ttl)
+	return false
+}
@@ -0,0 +1,49 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the delivery signature, formatted as
+// "t=<unix>,v1=<hex>[,v1=<hex>...]" with one v1 entry per active secret so
+// receivers keep verifying while a secret is being rotated
+const SignatureHeader = "Webhook-Signature"
+
+// Sign computes the signature header value for payload at time ts using
+// every active secret
+func Sign(secrets []string, ts time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+
+	parts := make([]string, 0, len(secrets)+1)
+	parts = append(parts, "t="+timestamp)
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+computeMAC(secret, timestamp, payload))
+	}
+	return strings.Join(parts, ",")
+}
+
+// computeMAC returns hex(HMAC-SHA256(secret, "<timestamp>.<payload>"))
+func computeMAC(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a new random signing secret
+func GenerateSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,375 @@
+package webhooks
+
+import (
+	"coffee-and-running/src/clients/httpclient"
+	"coffee-and-running/src/config"
+	"coffee-and-running/src/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// ErrNotFound is returned when an endpoint or delivery doesn't exist for the tenant
+var ErrNotFound = errors.New("webhooks: not found")
+
+// ErrInvalidURL is returned when an endpoint URL isn't an https URL of a
+// public host
+var ErrInvalidURL = errors.New("webhooks: invalid endpoint URL")
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Endpoint is a tenant's registered webhook receiver
+type Endpoint struct {
+	ID         int64     `json:"id"`
+	TenantID   string    `json:"tenant_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"` // empty subscribes to every event
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Delivery is a single event queued for an endpoint
+type Delivery struct {
+	ID             int64           `json:"id"`
+	EndpointID     int64           `json:"endpoint_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Querier is satisfied by both storage.Engine and *storage.InstrumentedTx,
+// so events can be enqueued inside a business transaction
+type Querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Service manages webhook endpoints, secrets and the delivery queue
+type Service struct {
+	config *config.WebhooksConfig
+	engine storage.Engine
+	logger *zap.Logger
+}
+
+// NewService creates a new webhook service
+func NewService(cfg *config.WebhooksConfig, engine storage.Engine, logger *zap.Logger) *Service {
+	return &Service{
+		config: cfg,
+		engine: engine,
+		logger: logger.With(zap.String("component", "webhooks")),
+	}
+}
+
+// RegisterEndpoint registers a receiver for the tenant and returns it along
+// with its initial signing secret, which is only ever shown once. url must
+// pass ValidateURL.
+func (s *Service) RegisterEndpoint(ctx context.Context, tenantID, url string, eventTypes []string) (*Endpoint, string, error) {
+	if err := ValidateURL(ctx, url); err != nil {
+		return nil, "", err
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	tx, err := s.engine.Begin(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Use a variable to track if we should rollback
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				s.logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	rows, err := tx.Query(ctx,
+		`INSERT INTO webhook_endpoints (tenant_id, url, event_types)
+		 VALUES ($1, $2, $3)
+		 RETURNING id, tenant_id, url, event_types, is_active, created_at`,
+		tenantID, url, pq.Array(eventTypes))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to insert webhook endpoint: %w", err)
+	}
+	endpoints, err := scanEndpoints(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	endpoint := endpoints[0]
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO webhook_secrets (endpoint_id, secret) VALUES ($1, $2)",
+		endpoint.ID, secret); err != nil {
+		return nil, "", fmt.Errorf("failed to insert webhook secret: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit webhook endpoint: %w", err)
+	}
+	committed = true
+
+	s.logger.Info("webhook endpoint registered",
+		zap.Int64("endpoint_id", endpoint.ID),
+		zap.String("tenant_id", tenantID),
+		zap.Strings("event_types", eventTypes))
+
+	return endpoint, secret, nil
+}
+
+// ValidateURL checks that raw is an https URL whose host resolves only to
+// public addresses, so endpoints can't aim deliveries at the service's own
+// network. The dispatcher checks addresses again as it connects, since a
+// name can resolve differently later.
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("%w: the scheme must be https", ErrInvalidURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("%w: a host is required", ErrInvalidURL)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is not a public host", ErrInvalidURL, host)
+	}
+
+	addrs := []netip.Addr{}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return fmt.Errorf("%w: %s does not resolve", ErrInvalidURL, host)
+	}
+	for _, addr := range addrs {
+		if !httpclient.IsPublic(addr) {
+			return fmt.Errorf("%w: %s is not a public host", ErrInvalidURL, host)
+		}
+	}
+	return nil
+}
+
+// ListEndpoints returns the tenant's endpoints
+func (s *Service) ListEndpoints(ctx context.Context, tenantID string) ([]*Endpoint, error) {
+	rows, err := s.engine.Query(ctx,
+		`SELECT id, tenant_id, url, event_types, is_active, created_at
+		 FROM webhook_endpoints WHERE tenant_id = $1 ORDER BY id`,
+		tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	return scanEndpoints(rows)
+}
+
+// RotateSecret issues a new signing secret. Existing secrets stay valid for
+// the configured overlap so receivers can roll over without dropping events.
+func (s *Service) RotateSecret(ctx context.Context, tenantID string, endpointID int64) (string, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.engine.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// Use a variable to track if we should rollback
+	var committed bool
+	defer func() {
+		if !committed {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				s.logger.Error("failed to rollback transaction", zap.Error(rollbackErr))
+			}
+		}
+	}()
+
+	result, err := tx.Exec(ctx,
+		`UPDATE webhook_secrets SET expires_at = NOW() + $3 * INTERVAL '1 second'
+		 WHERE endpoint_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		   AND EXISTS (SELECT 1 FROM webhook_endpoints WHERE id = $1 AND tenant_id = $2)`,
+		endpointID, tenantID, int64(s.config.SecretOverlap.Seconds()))
+	if err != nil {
+		return "", fmt.Errorf("failed to expire webhook secrets: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", ErrNotFound
+	}
+
+	if _, err := tx.Exec(ctx,
+		"INSERT INTO webhook_secrets (endpoint_id, secret) VALUES ($1, $2)",
+		endpointID, secret); err != nil {
+		return "", fmt.Errorf("failed to insert webhook secret: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit secret rotation: %w", err)
+	}
+	committed = true
+
+	s.logger.Info("webhook secret rotated",
+		zap.Int64("endpoint_id", endpointID),
+		zap.Duration("overlap", s.config.SecretOverlap))
+
+	return secret, nil
+}
+
+// Enqueue queues payload for every active endpoint of the tenant subscribed
+// to eventType, returning the number of deliveries created
+func (s *Service) Enqueue(ctx context.Context, tenantID, eventType string, payload interface{}) (int64, error) {
+	return s.EnqueueTx(ctx, s.engine, tenantID, eventType, payload)
+}
+
+// EnqueueTx is Enqueue within the caller's transaction, so events are only
+// delivered if the business change commits
+func (s *Service) EnqueueTx(ctx context.Context, q Querier, tenantID, eventType string, payload interface{}) (int64, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	result, err := q.Exec(ctx,
+		`INSERT INTO webhook_deliveries (endpoint_id, event_type, payload)
+		 SELECT id, $2, $3 FROM webhook_endpoints
+		 WHERE tenant_id = $1 AND is_active
+		   AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))`,
+		tenantID, eventType, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+
+	count, _ := result.RowsAffected()
+	s.logger.Debug("webhook deliveries enqueued",
+		zap.String("tenant_id", tenantID),
+		zap.String("event_type", eventType),
+		zap.Int64("count", count))
+
+	return count, nil
+}
+
+// GetDelivery returns a delivery owned by the tenant
+func (s *Service) GetDelivery(ctx context.Context, tenantID string, id int64) (*Delivery, error) {
+	rows, err := s.engine.Query(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		 WHERE d.id = $1 AND e.tenant_id = $2`,
+		id, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %w", err)
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, ErrNotFound
+	}
+	return deliveries[0], nil
+}
+
+// ListDeliveries returns the most recent deliveries for one of the tenant's endpoints
+func (s *Service) ListDeliveries(ctx context.Context, tenantID string, endpointID int64, limit int) ([]*Delivery, error) {
+	rows, err := s.engine.Query(ctx,
+		`SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		 WHERE d.endpoint_id = $1 AND e.tenant_id = $2
+		 ORDER BY d.id DESC LIMIT $3`,
+		endpointID, tenantID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return scanDeliveries(rows)
+}
+
+// RetryDelivery requeues a delivery (including dead-lettered ones) for immediate delivery
+func (s *Service) RetryDelivery(ctx context.Context, tenantID string, id int64) error {
+	result, err := s.engine.Exec(ctx,
+		`UPDATE webhook_deliveries d SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		 FROM webhook_endpoints e
+		 WHERE e.id = d.endpoint_id AND d.id = $1 AND e.tenant_id = $2 AND d.status <> 'delivered'`,
+		id, tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id, d.endpoint_id, d.event_type, d.payload, d.status, d.attempts,
+	d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+// scanEndpoints reads and closes rows of endpoint columns
+func scanEndpoints(rows *sql.Rows) ([]*Endpoint, error) {
+	defer rows.Close()
+
+	var endpoints []*Endpoint
+	for rows.Next() {
+		e := &Endpoint{}
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.URL, pq.Array(&e.EventTypes), &e.Active, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook endpoint: %w", err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// scanDeliveries reads and closes rows of deliveryColumns
+func scanDeliveries(rows *sql.Rows) ([]*Delivery, error) {
+	defer rows.Close()
+
+	var deliveries []*Delivery
+	for rows.Next() {
+		d := &Delivery{}
+		var statusCode sql.NullInt64
+		var lastError sql.NullString
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.EndpointID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &statusCode, &lastError, &d.CreatedAt, &deliveredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		if statusCode.Valid {
+			code := int(statusCode.Int64)
+			d.LastStatusCode = &code
+		}
+		if lastError.Valid {
+			d.LastError = &lastError.String
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}